@@ -23,6 +23,7 @@ import (
 
 	"github.com/google/exposure-notifications-server/internal/buildinfo"
 	"github.com/google/exposure-notifications-server/internal/cleanup"
+	"github.com/google/exposure-notifications-server/internal/middleware"
 	"github.com/google/exposure-notifications-server/internal/setup"
 	"github.com/google/exposure-notifications-server/pkg/logging"
 	_ "github.com/google/exposure-notifications-server/pkg/observability"
@@ -68,11 +69,16 @@ func realMain(ctx context.Context) error {
 		return fmt.Errorf("cleaup.NewExposureServer: %w", err)
 	}
 
-	srv, err := server.New(config.Port)
+	tlsConfig, err := config.TLS.ServerConfig()
 	if err != nil {
-		return fmt.Errorf("server.New: %w", err)
+		return fmt.Errorf("failed to configure TLS: %w", err)
+	}
+
+	srv, err := server.NewTLS(config.Port, tlsConfig)
+	if err != nil {
+		return fmt.Errorf("server.NewTLS: %w", err)
 	}
 	logger.Info("listening on: ", config.Port)
 
-	return srv.ServeHTTPHandler(ctx, cleanupExposureServer.Routes(ctx))
+	return srv.ServeHTTPHandler(ctx, middleware.SecurityHeaders(&config.SecurityHeaders)(cleanupExposureServer.Routes(ctx)))
 }
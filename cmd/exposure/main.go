@@ -22,6 +22,7 @@ import (
 	"syscall"
 
 	"github.com/google/exposure-notifications-server/internal/buildinfo"
+	"github.com/google/exposure-notifications-server/internal/middleware"
 	"github.com/google/exposure-notifications-server/internal/publish"
 	"github.com/google/exposure-notifications-server/internal/setup"
 	"github.com/google/exposure-notifications-server/pkg/logging"
@@ -68,10 +69,18 @@ func realMain(ctx context.Context) error {
 		return fmt.Errorf("publish.NewServer: %w", err)
 	}
 
-	srv, err := server.New(cfg.Port)
+	tlsConfig, err := cfg.TLS.ServerConfig()
 	if err != nil {
-		return fmt.Errorf("server.New: %w", err)
+		return fmt.Errorf("failed to configure TLS: %w", err)
+	}
+
+	srv, err := server.NewTLS(cfg.Port, tlsConfig)
+	if err != nil {
+		return fmt.Errorf("server.NewTLS: %w", err)
+	}
+	if err := srv.ConfigureHTTP(&cfg.HTTP); err != nil {
+		return fmt.Errorf("failed to configure HTTP server: %w", err)
 	}
 	logger.Infow("server listening", "port", cfg.Port)
-	return srv.ServeHTTPHandler(ctx, publishServer.Routes(ctx))
+	return srv.ServeHTTPHandler(ctx, middleware.SecurityHeaders(&cfg.SecurityHeaders)(publishServer.Routes(ctx)))
 }
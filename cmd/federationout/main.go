@@ -71,18 +71,21 @@ func realMain(ctx context.Context) error {
 
 	federationServer := federationout.NewServer(env, &config)
 
+	tlsConfig, err := config.TLS.ServerConfig()
+	if err != nil {
+		return fmt.Errorf("failed to configure TLS: %w", err)
+	}
+
 	var sopts []grpc.ServerOption
-	if config.TLSCertFile != "" && config.TLSKeyFile != "" {
-		creds, err := credentials.NewServerTLSFromFile(config.TLSCertFile, config.TLSKeyFile)
-		if err != nil {
-			return fmt.Errorf("failed to create credentials: %w", err)
-		}
-		sopts = append(sopts, grpc.Creds(creds))
+	if tlsConfig != nil {
+		sopts = append(sopts, grpc.Creds(credentials.NewTLS(tlsConfig)))
 	}
 
+	interceptors := []grpc.UnaryServerInterceptor{federationServer.(*federationout.Server).RequestIDInterceptor}
 	if !config.AllowAnyClient {
-		sopts = append(sopts, grpc.UnaryInterceptor(federationServer.(*federationout.Server).AuthInterceptor))
+		interceptors = append(interceptors, federationServer.(*federationout.Server).AuthInterceptor)
 	}
+	sopts = append(sopts, grpc.ChainUnaryInterceptor(interceptors...))
 
 	sopts = append(sopts, grpc.StatsHandler(&ocgrpc.ServerHandler{}))
 	grpcServer := grpc.NewServer(sopts...)
@@ -4,6 +4,7 @@ go 1.16
 
 require (
 	cloud.google.com/go v0.83.0
+	cloud.google.com/go/bigquery v1.18.0
 	cloud.google.com/go/storage v1.15.0
 	contrib.go.opencensus.io/exporter/ocagent v0.7.0
 	contrib.go.opencensus.io/exporter/prometheus v0.3.0
@@ -31,9 +32,11 @@ require (
 	github.com/gotestyourself/gotestyourself v2.2.0+incompatible // indirect
 	github.com/hashicorp/go-multierror v1.1.1
 	github.com/hashicorp/vault/api v1.1.0
+	github.com/jackc/pgconn v1.8.1
 	github.com/jackc/pgx/v4 v4.11.0
 	github.com/kelseyhightower/run v0.0.17
 	github.com/lstoll/awskms v0.0.0-20210310122415-d1696e9c112b
+	github.com/miekg/pkcs11 v1.1.2
 	github.com/mikehelmick/go-chaff v0.5.0
 	github.com/mitchellh/mapstructure v1.4.1
 	github.com/opencontainers/runc v0.1.1 // indirect
@@ -45,8 +48,10 @@ require (
 	github.com/sethvargo/go-retry v0.1.0
 	github.com/sethvargo/zapw v0.1.0
 	github.com/timakin/bodyclose v0.0.0-20200424151742-cb6215831a94
+	github.com/ugorji/go/codec v1.1.7
 	go.opencensus.io v0.23.0
 	go.uber.org/zap v1.17.0
+	golang.org/x/net v0.0.0-20210503060351-7fd8e65b6420
 	golang.org/x/oauth2 v0.0.0-20210514164344-f6687ab2804c
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
 	golang.org/x/time v0.0.0-20210608053304-ed9ce3a009e4
@@ -0,0 +1,100 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Action is an operation an admin console user takes on a resource.
+type Action string
+
+const (
+	ActionView   Action = "view"
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+)
+
+// ResourceType is a kind of resource managed through the admin console. Keys
+// and exposures are identified by the ID of the health authority or export
+// importer they belong to.
+type ResourceType string
+
+const (
+	ResourceAuthorizedApp      ResourceType = "authorized_app"
+	ResourceHealthAuthority    ResourceType = "health_authority"
+	ResourceHealthAuthorityKey ResourceType = "health_authority_key"
+	ResourceExposures          ResourceType = "exposures"
+	ResourceExportConfig       ResourceType = "export_config"
+	ResourceExportImporter     ResourceType = "export_importer"
+	ResourceExportImporterKey  ResourceType = "export_importer_key"
+	ResourceMirror             ResourceType = "mirror"
+	ResourceSignatureInfo      ResourceType = "signature_info"
+	ResourceSelfTest           ResourceType = "self_test"
+	ResourceRevisionToken      ResourceType = "revision_token"
+	ResourceQuarantine         ResourceType = "quarantine"
+	ResourceCacheMaintenance   ResourceType = "cache_maintenance"
+)
+
+// Resource is the target of an admin console action. The ID is empty when the
+// action applies to all resources of the type, or the resource is new.
+type Resource struct {
+	Type ResourceType
+	ID   string
+}
+
+// Authorizer decides if an admin console request may take an action on a
+// resource. Deployments can provide an implementation with role based rules,
+// for example using the identity headers added by a proxy in front of the
+// admin console.
+type Authorizer interface {
+	// Authorize returns an error if the request is not allowed.
+	Authorize(r *http.Request, action Action, resource Resource) error
+}
+
+// AllowAll is an Authorizer that allows every request. It is the default.
+type AllowAll struct{}
+
+// Authorize implements Authorizer.
+func (AllowAll) Authorize(*http.Request, Action, Resource) error {
+	return nil
+}
+
+// authorize checks the action with the server's authorizer. If the action is
+// not allowed, a forbidden error page is rendered, the request is aborted,
+// and false is returned.
+func (s *Server) authorize(c *gin.Context, action Action, typ ResourceType, id string) bool {
+	resource := Resource{Type: typ, ID: id}
+	if err := s.authorizer.Authorize(c.Request, action, resource); err != nil {
+		log.Printf("denied %s of %s %q: %v", action, typ, id, err)
+		c.HTML(http.StatusForbidden, "error", gin.H{"error": []string{"You are not allowed to perform this action."}})
+		c.Abort()
+		return false
+	}
+	return true
+}
+
+// saveAction returns the action for saving the resource with the given ID
+// param, where "0" is a new resource.
+func saveAction(id string) Action {
+	if id == "0" {
+		return ActionCreate
+	}
+	return ActionUpdate
+}
@@ -0,0 +1,140 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/go-cmp/cmp"
+)
+
+// denyDeletes is an Authorizer that denies all deletes and records the
+// resources it was asked about.
+type denyDeletes struct {
+	resources []Resource
+}
+
+func (a *denyDeletes) Authorize(_ *http.Request, action Action, resource Resource) error {
+	a.resources = append(a.resources, resource)
+	if action == ActionDelete {
+		return fmt.Errorf("deletes are not allowed")
+	}
+	return nil
+}
+
+func TestAuthorizerDeniesDeletes(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	cases := []struct {
+		name    string
+		path    string
+		route   string
+		handler func(s *Server) func(c *gin.Context)
+		form    url.Values
+		want    Resource
+	}{
+		{
+			name:    "authorized_app",
+			path:    "/app",
+			route:   "/app",
+			handler: (*Server).HandleAuthorizedAppsSave,
+			form: url.Values{
+				"action": {"delete"},
+				"key":    {base64.StdEncoding.EncodeToString([]byte("com.example.app"))},
+			},
+			want: Resource{Type: ResourceAuthorizedApp, ID: "com.example.app"},
+		},
+		{
+			name:    "mirror",
+			path:    "/mirrors/5",
+			route:   "/mirrors/:id",
+			handler: (*Server).HandleMirrorsSave,
+			form: url.Values{
+				"action":               {"delete"},
+				"index-file":           {"https://example.com/index.txt"},
+				"cloud-storage-bucket": {"bucket"},
+			},
+			want: Resource{Type: ResourceMirror, ID: "5"},
+		},
+		{
+			name:    "revoke_exposures",
+			path:    "/healthauthorityrevoke/3",
+			route:   "/healthauthorityrevoke/:id",
+			handler: (*Server).HandleHealthAuthorityRevokeExposures,
+			form:    url.Values{"exposure-keys": {"AAAAAAAAAAAAAAAAAAAAAA=="}},
+			want:    Resource{Type: ResourceExposures, ID: "3"},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			config := &Config{}
+			tmpl, err := config.TemplateRenderer()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// There is no server env, the request must be denied before the
+			// database is used.
+			authorizer := &denyDeletes{}
+			s := WithAuthorizer(authorizer)(&Server{config: config})
+
+			mux := gin.New()
+			mux.SetHTMLTemplate(tmpl)
+			mux.POST(tc.route, tc.handler(s))
+
+			r, err := http.NewRequestWithContext(ctx, http.MethodPost, tc.path, strings.NewReader(tc.form.Encode()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+
+			if got, want := w.Code, http.StatusForbidden; got != want {
+				t.Fatalf("expected status %d to be %d: %s", got, want, w.Body.String())
+			}
+			if diff := cmp.Diff([]Resource{tc.want}, authorizer.resources); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSaveAction(t *testing.T) {
+	t.Parallel()
+
+	if got, want := saveAction("0"), ActionCreate; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+	if got, want := saveAction("12"), ActionUpdate; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+}
@@ -21,12 +21,17 @@ import (
 	"fmt"
 	"html/template"
 	"io/fs"
+	"time"
 
+	"github.com/google/exposure-notifications-server/internal/middleware"
+	"github.com/google/exposure-notifications-server/internal/revision"
 	"github.com/google/exposure-notifications-server/internal/setup"
 	"github.com/google/exposure-notifications-server/internal/storage"
+	"github.com/google/exposure-notifications-server/internal/verification"
 	"github.com/google/exposure-notifications-server/pkg/database"
 	"github.com/google/exposure-notifications-server/pkg/keys"
 	"github.com/google/exposure-notifications-server/pkg/secrets"
+	"github.com/google/exposure-notifications-server/pkg/server"
 )
 
 var (
@@ -46,12 +51,59 @@ var (
 )
 
 type Config struct {
-	Database      database.Config
-	KeyManager    keys.Config
-	SecretManager secrets.Config
-	Storage       storage.Config
+	Database        database.Config
+	KeyManager      keys.Config
+	SecretManager   secrets.Config
+	Storage         storage.Config
+	TLS             server.TLSConfig
+	SecurityHeaders middleware.SecurityHeadersConfig
+	Verification    verification.Config
+	RevisionToken   revision.Config
 
 	Port string `env:"PORT, default=8080"`
+
+	// MaxActiveKeyVersions is the maximum number of active (not revoked) key
+	// versions a health authority can have. Zero, the default, means no limit.
+	MaxActiveKeyVersions uint `env:"HEALTH_AUTHORITY_MAX_ACTIVE_KEY_VERSIONS, default=0"`
+
+	// ExpiredKeyMinAge is how long ago a key version must have expired to be
+	// removed when purging the expired keys of a health authority.
+	ExpiredKeyMinAge time.Duration `env:"HEALTH_AUTHORITY_EXPIRED_KEY_MIN_AGE, default=720h"`
+
+	// RequestSigningKey is the base64 encoded HMAC key used to verify the
+	// signature of requests that modify data (see SignRequest). If empty,
+	// requests are not required to be signed. This may come from secret://.
+	RequestSigningKey string `env:"ADMIN_REQUEST_SIGNING_KEY"`
+	// RequestSignatureMaxAge is how long a signed request is valid for.
+	RequestSignatureMaxAge time.Duration `env:"ADMIN_REQUEST_SIGNATURE_MAX_AGE, default=5m"`
+
+	// SelfTestIssuer is the issuer of a health authority dedicated to the stats
+	// token self-test, which is disabled if empty. The self-test signs tokens
+	// with SelfTestSigningKey, a key manager key, and identifies the key with
+	// SelfTestKeyVersion. The health authority must not federate, and no
+	// authorized app may publish with it.
+	SelfTestIssuer     string `env:"SELF_TEST_HEALTH_AUTHORITY_ISSUER"`
+	SelfTestSigningKey string `env:"SELF_TEST_SIGNING_KEY"`
+	SelfTestKeyVersion string `env:"SELF_TEST_KEY_VERSION"`
+
+	// SelfTestDebug includes the decoded, unverified claims of the self-test
+	// token in the response when it fails to authenticate.
+	SelfTestDebug bool `env:"SELF_TEST_DEBUG, default=false"`
+
+	// ExportFileBaseURL is the URL export files are served under, e.g. the CDN
+	// that serves the bucket. It is used for the file URLs in the export files
+	// API. Without it, the bucket and object name are returned.
+	ExportFileBaseURL string `env:"EXPORT_FILE_BASE_URL"`
+
+	// RevisionKeyCacheDuration is how long the revision keys are cached for the
+	// revision token preview, which is enabled when the revision token AAD is
+	// set. The AAD must be the same as the publish server's.
+	RevisionKeyCacheDuration time.Duration `env:"REVISION_KEY_CACHE_DURATION, default=1m"`
+
+	// CreatedAtTruncateWindow is the window the creation time of approved
+	// quarantined keys is truncated to. It should be the same as the publish
+	// server's.
+	CreatedAtTruncateWindow time.Duration `env:"TRUNCATE_WINDOW, default=1h"`
 }
 
 func (c *Config) DatabaseConfig() *database.Config {
@@ -40,6 +40,17 @@ func (s *Server) HandleAuthorizedAppsSave() func(c *gin.Context) {
 			return
 		}
 
+		action := ActionUpdate
+		switch {
+		case form.Action == "delete":
+			action = ActionDelete
+		case form.PriorKey() == "":
+			action = ActionCreate
+		}
+		if !s.authorize(c, action, ResourceAuthorizedApp, form.PriorKey()) {
+			return
+		}
+
 		ctx := c.Request.Context()
 		m := TemplateMap{}
 
@@ -132,6 +143,9 @@ func (s *Server) HandleAuthorizedAppsShow() func(c *gin.Context) {
 		m := TemplateMap{}
 
 		appID, _ := c.GetQuery("apn")
+		if !s.authorize(c, ActionView, ResourceAuthorizedApp, appID) {
+			return
+		}
 		authorizedApp := model.NewAuthorizedApp()
 
 		if appID == "" {
@@ -0,0 +1,195 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/google/exposure-notifications-server/internal/export"
+	exportdatabase "github.com/google/exposure-notifications-server/internal/export/database"
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	"github.com/google/exposure-notifications-server/internal/storage"
+	"github.com/google/exposure-notifications-server/pkg/database"
+)
+
+// exportBatchJSON is the response of HandleExportBatch.
+type exportBatchJSON struct {
+	BatchID          int64                  `json:"batchID"`
+	ConfigID         int64                  `json:"configID"`
+	Status           string                 `json:"status"`
+	OutputRegion     string                 `json:"outputRegion"`
+	InputRegions     []string               `json:"inputRegions"`
+	ExcludeRegions   []string               `json:"excludeRegions,omitempty"`
+	IncludeTravelers bool                   `json:"includeTravelers"`
+	OnlyNonTravelers bool                   `json:"onlyNonTravelers"`
+	StartTimestamp   time.Time              `json:"startTimestamp"`
+	EndTimestamp     time.Time              `json:"endTimestamp"`
+	SignatureInfos   []*exportSignatureJSON `json:"signatureInfos"`
+	Files            []*exportBatchFileJSON `json:"files"`
+}
+
+type exportSignatureJSON struct {
+	ID                int64  `json:"id"`
+	SigningKeyID      string `json:"signingKeyID"`
+	SigningKeyVersion string `json:"signingKeyVersion"`
+}
+
+// exportBatchFileJSON is an export file of the batch. Exists, Keys, KeyIDs and
+// Digest are only set if the file was read from storage.
+type exportBatchFileJSON struct {
+	StoragePath string `json:"storagePath"`
+	BatchNum    int    `json:"batchNum"`
+	BatchSize   int    `json:"batchSize"`
+	Status      string `json:"status"`
+
+	Exists *bool    `json:"exists,omitempty"`
+	Keys   *int     `json:"keys,omitempty"`
+	KeyIDs []string `json:"keyIDs,omitempty"`
+	// Digest is the base64 encoded SHA-256 digest of the signed export.bin.
+	Digest string `json:"digest,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HandleExportBatch returns the metadata of the export batch with the batch-id
+// query parameter and its files. If the verify query parameter is true, the
+// files are also read from storage to check that they still exist and to
+// report their key counts, signing key IDs and digests.
+func (s *Server) HandleExportBatch() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		batchIDParam := c.Query("batch-id")
+		if !s.authorize(c, ActionView, ResourceExportConfig, batchIDParam) {
+			return
+		}
+
+		batchID, err := strconv.ParseInt(batchIDParam, 10, 64)
+		if err != nil || batchID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "batch-id must be a positive integer"})
+			return
+		}
+
+		var verify bool
+		if v := c.Query("verify"); v != "" {
+			verify, err = strconv.ParseBool(v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "verify must be true or false"})
+				return
+			}
+		}
+		blobstore := s.env.Blobstore()
+		if verify && blobstore == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cannot verify files, blob storage is not configured"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		exportDB := exportdatabase.New(s.env.Database())
+
+		eb, err := exportDB.LookupExportBatch(ctx, batchID)
+		if err != nil {
+			if errors.Is(err, database.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("export batch %d not found", batchID)})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to read export batch: %v", err)})
+			return
+		}
+
+		// Signature infos that expired since the export are still reported.
+		sigInfos, err := exportDB.LookupSignatureInfos(ctx, eb.SignatureInfoIDs, time.Time{})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to read signature infos: %v", err)})
+			return
+		}
+
+		files, err := exportDB.ListExportBatchFiles(ctx, batchID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to read export files: %v", err)})
+			return
+		}
+
+		resp := &exportBatchJSON{
+			BatchID:          eb.BatchID,
+			ConfigID:         eb.ConfigID,
+			Status:           eb.Status,
+			OutputRegion:     eb.OutputRegion,
+			InputRegions:     eb.EffectiveInputRegions(),
+			ExcludeRegions:   eb.ExcludeRegions,
+			IncludeTravelers: eb.IncludeTravelers,
+			OnlyNonTravelers: eb.OnlyNonTravelers,
+			StartTimestamp:   eb.StartTimestamp.UTC(),
+			EndTimestamp:     eb.EndTimestamp.UTC(),
+			SignatureInfos:   make([]*exportSignatureJSON, 0, len(sigInfos)),
+			Files:            make([]*exportBatchFileJSON, 0, len(files)),
+		}
+		for _, si := range sigInfos {
+			resp.SignatureInfos = append(resp.SignatureInfos, &exportSignatureJSON{
+				ID:                si.ID,
+				SigningKeyID:      si.SigningKeyID,
+				SigningKeyVersion: si.SigningKeyVersion,
+			})
+		}
+		for _, f := range files {
+			file := &exportBatchFileJSON{
+				StoragePath: f.BucketName + "/" + f.Filename,
+				BatchNum:    f.BatchNum,
+				BatchSize:   f.BatchSize,
+				Status:      f.Status,
+			}
+			if verify {
+				verifyExportFile(ctx, blobstore, f, file)
+			}
+			resp.Files = append(resp.Files, file)
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// verifyExportFile reads the export file from storage and fills in what was
+// found. Errors are reported on the file, so the other files are still
+// checked.
+func verifyExportFile(ctx context.Context, blobstore storage.Blobstore, f *model.ExportFile, file *exportBatchFileJSON) {
+	exists := false
+	file.Exists = &exists
+
+	data, err := blobstore.GetObject(ctx, f.BucketName, f.Filename)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			file.Error = fmt.Sprintf("failed to read file: %v", err)
+		}
+		return
+	}
+	exists = true
+
+	message, digest, err := export.UnmarshalExportFile(data)
+	if err != nil {
+		file.Error = fmt.Sprintf("failed to parse file: %v", err)
+		return
+	}
+
+	keys := len(message.GetKeys()) + len(message.GetRevisedKeys())
+	file.Keys = &keys
+	for _, si := range message.GetSignatureInfos() {
+		file.KeyIDs = append(file.KeyIDs, si.GetVerificationKeyId())
+	}
+	file.Digest = base64.StdEncoding.EncodeToString(digest)
+}
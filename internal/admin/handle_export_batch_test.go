@@ -0,0 +1,236 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/export"
+	exportdatabase "github.com/google/exposure-notifications-server/internal/export/database"
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	"github.com/google/exposure-notifications-server/internal/project"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/exposure-notifications-server/internal/storage"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHandleExportBatch(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testEnv, _ := newTestServer(t)
+
+	blobstore, err := storage.NewMemory(ctx, &storage.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := serverenv.New(ctx,
+		serverenv.WithDatabase(testEnv.Database()),
+		serverenv.WithBlobStorage(blobstore))
+	s, err := NewServer(&Config{}, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exportDB := exportdatabase.New(env.Database())
+	now := time.Now().UTC().Truncate(time.Second)
+
+	si := &model.SignatureInfo{
+		SigningKey:        "signing-key",
+		SigningKeyVersion: "v1",
+		SigningKeyID:      "310",
+	}
+	if err := exportDB.AddSignatureInfo(ctx, si); err != nil {
+		t.Fatal(err)
+	}
+
+	ec := &model.ExportConfig{
+		BucketName:       "export-bucket",
+		FilenameRoot:     "root",
+		Period:           time.Hour,
+		OutputRegion:     "US",
+		InputRegions:     []string{"US", "CA"},
+		SignatureInfoIDs: []int64{si.ID},
+	}
+	if err := exportDB.AddExportConfig(ctx, ec); err != nil {
+		t.Fatal(err)
+	}
+
+	eb := &model.ExportBatch{
+		ConfigID:         ec.ConfigID,
+		BucketName:       ec.BucketName,
+		FilenameRoot:     ec.FilenameRoot,
+		StartTimestamp:   now.Add(-2 * time.Hour),
+		EndTimestamp:     now.Add(-time.Hour),
+		OutputRegion:     ec.OutputRegion,
+		InputRegions:     ec.InputRegions,
+		SignatureInfoIDs: ec.SignatureInfoIDs,
+		Status:           model.ExportBatchOpen,
+	}
+	if err := exportDB.AddExportBatches(ctx, []*model.ExportBatch{eb}); err != nil {
+		t.Fatal(err)
+	}
+	eb, err = exportDB.LeaseBatch(ctx, time.Hour, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exportDB.FinalizeBatch(ctx, eb, []string{"root/1.zip", "root/2.zip"}, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the first file is still in storage.
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exposures := []*publishmodel.Exposure{
+		{ExposureKey: []byte("0123456789abcdef"), IntervalNumber: 100, IntervalCount: 144},
+		{ExposureKey: []byte("fedcba9876543210"), IntervalNumber: 100, IntervalCount: 144},
+	}
+	data, err := export.MarshalExportFile(eb, exposures, nil, 1, false, []*export.Signer{{SignatureInfo: si, Signer: key}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := blobstore.CreateObject(ctx, eb.BucketName, "root/1.zip", data, false, storage.ContentTypeZip); err != nil {
+		t.Fatal(err)
+	}
+	_, digest, err := export.UnmarshalExportFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	boolPtr := func(b bool) *bool { return &b }
+	intPtr := func(i int) *int { return &i }
+
+	cases := []struct {
+		name   string
+		query  string
+		status int
+		want   *exportBatchJSON
+	}{
+		{
+			name:   "bad_id",
+			query:  "batch-id=banana",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "unknown_id",
+			query:  "batch-id=123456",
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "metadata",
+			query:  fmt.Sprintf("batch-id=%d", eb.BatchID),
+			status: http.StatusOK,
+			want: &exportBatchJSON{
+				BatchID:        eb.BatchID,
+				ConfigID:       ec.ConfigID,
+				Status:         model.ExportBatchComplete,
+				OutputRegion:   "US",
+				InputRegions:   []string{"US", "CA"},
+				StartTimestamp: eb.StartTimestamp.UTC(),
+				EndTimestamp:   eb.EndTimestamp.UTC(),
+				SignatureInfos: []*exportSignatureJSON{
+					{ID: si.ID, SigningKeyID: "310", SigningKeyVersion: "v1"},
+				},
+				Files: []*exportBatchFileJSON{
+					{StoragePath: "export-bucket/root/1.zip", BatchNum: 1, BatchSize: 2, Status: model.ExportBatchComplete},
+					{StoragePath: "export-bucket/root/2.zip", BatchNum: 2, BatchSize: 2, Status: model.ExportBatchComplete},
+				},
+			},
+		},
+		{
+			name:   "verify",
+			query:  fmt.Sprintf("batch-id=%d&verify=true", eb.BatchID),
+			status: http.StatusOK,
+			want: &exportBatchJSON{
+				BatchID:        eb.BatchID,
+				ConfigID:       ec.ConfigID,
+				Status:         model.ExportBatchComplete,
+				OutputRegion:   "US",
+				InputRegions:   []string{"US", "CA"},
+				StartTimestamp: eb.StartTimestamp.UTC(),
+				EndTimestamp:   eb.EndTimestamp.UTC(),
+				SignatureInfos: []*exportSignatureJSON{
+					{ID: si.ID, SigningKeyID: "310", SigningKeyVersion: "v1"},
+				},
+				Files: []*exportBatchFileJSON{
+					{
+						StoragePath: "export-bucket/root/1.zip",
+						BatchNum:    1,
+						BatchSize:   2,
+						Status:      model.ExportBatchComplete,
+						Exists:      boolPtr(true),
+						Keys:        intPtr(2),
+						KeyIDs:      []string{"310"},
+						Digest:      base64.StdEncoding.EncodeToString(digest),
+					},
+					{
+						StoragePath: "export-bucket/root/2.zip",
+						BatchNum:    2,
+						BatchSize:   2,
+						Status:      model.ExportBatchComplete,
+						Exists:      boolPtr(false),
+					},
+				},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			server := newHTTPServer(t, http.MethodGet, "/", s.HandleExportBatch())
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/?"+tc.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := server.Client().Do(req)
+			if err != nil {
+				t.Fatalf("error making http call: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if got, want := resp.StatusCode, tc.status; got != want {
+				t.Fatalf("expected status %d to be %d", got, want)
+			}
+			if tc.want == nil {
+				return
+			}
+
+			var got exportBatchJSON
+			if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, &got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
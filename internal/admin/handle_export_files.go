@@ -0,0 +1,183 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	exportdatabase "github.com/google/exposure-notifications-server/internal/export/database"
+)
+
+const (
+	defaultExportFilesLimit = 50
+	maxExportFilesLimit     = 200
+)
+
+var errInvalidExportFilesPageToken = errors.New("invalid page token")
+
+// exportFilesJSON is the response of HandleExportFiles.
+type exportFilesJSON struct {
+	Files []*exportFileJSON `json:"files"`
+	// NextPageToken is set if there are more files, pass it as the page-token
+	// query parameter to get them.
+	NextPageToken string `json:"nextPageToken,omitempty"`
+}
+
+// exportFileJSON is an export file with its batch, as read from storage.
+type exportFileJSON struct {
+	BatchID        int64     `json:"batchID"`
+	ConfigID       int64     `json:"configID"`
+	StartTimestamp time.Time `json:"startTimestamp"`
+	EndTimestamp   time.Time `json:"endTimestamp"`
+	URL            string    `json:"url"`
+	*exportBatchFileJSON
+}
+
+// HandleExportFiles returns the export files of the region query parameter
+// whose batch window overlaps the from and to RFC 3339 timestamps, for all
+// export configs. The files are read from storage to report their key counts,
+// signing key IDs and digests, like HandleExportBatch does when verifying.
+// The results are paginated: limit sets the number of files per page, and
+// page-token is the nextPageToken of the previous page.
+func (s *Server) HandleExportFiles() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		region := strings.ToUpper(strings.TrimSpace(c.Query("region")))
+		if !s.authorize(c, ActionView, ResourceExportConfig, region) {
+			return
+		}
+		if region == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "region is required"})
+			return
+		}
+
+		since, err := time.Parse(time.RFC3339, c.Query("from"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be an RFC 3339 timestamp"})
+			return
+		}
+		until, err := time.Parse(time.RFC3339, c.Query("to"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be an RFC 3339 timestamp"})
+			return
+		}
+		if !until.After(since) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be after from"})
+			return
+		}
+
+		limit := defaultExportFilesLimit
+		if v := c.Query("limit"); v != "" {
+			limit, err = strconv.Atoi(v)
+			if err != nil || limit <= 0 || limit > maxExportFilesLimit {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxExportFilesLimit)})
+				return
+			}
+		}
+
+		criteria := &exportdatabase.RegionExportFilesCriteria{
+			Region: region,
+			Since:  since,
+			Until:  until,
+			// One more file is read to know if there is a next page.
+			Limit: limit + 1,
+		}
+		if token := c.Query("page-token"); token != "" {
+			criteria.AfterBatchID, criteria.AfterFilename, err = decodeExportFilesPageToken(token)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		blobstore := s.env.Blobstore()
+		if blobstore == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cannot read files, blob storage is not configured"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		files, err := exportdatabase.New(s.env.Database()).ListRegionExportFiles(ctx, criteria)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to read export files: %v", err)})
+			return
+		}
+
+		resp := &exportFilesJSON{}
+		if len(files) > limit {
+			files = files[:limit]
+			last := files[limit-1].File
+			resp.NextPageToken = encodeExportFilesPageToken(last.BatchID, last.Filename)
+		}
+		resp.Files = make([]*exportFileJSON, 0, len(files))
+		for _, rf := range files {
+			f := rf.File
+			file := &exportFileJSON{
+				BatchID:        f.BatchID,
+				ConfigID:       rf.ConfigID,
+				StartTimestamp: rf.StartTimestamp.UTC(),
+				EndTimestamp:   rf.EndTimestamp.UTC(),
+				URL:            s.exportFileURL(f.BucketName, f.Filename),
+				exportBatchFileJSON: &exportBatchFileJSON{
+					StoragePath: f.BucketName + "/" + f.Filename,
+					BatchNum:    f.BatchNum,
+					BatchSize:   f.BatchSize,
+					Status:      f.Status,
+				},
+			}
+			verifyExportFile(ctx, blobstore, f, file.exportBatchFileJSON)
+			resp.Files = append(resp.Files, file)
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// exportFileURL returns the URL of the export file under the configured base
+// URL. Without a base URL, the bucket and object name are returned.
+func (s *Server) exportFileURL(bucketName, filename string) string {
+	if base := s.config.ExportFileBaseURL; base != "" {
+		return strings.TrimSuffix(base, "/") + "/" + filename
+	}
+	return bucketName + "/" + filename
+}
+
+// encodeExportFilesPageToken returns the page token of the files after the
+// given file.
+func encodeExportFilesPageToken(batchID int64, filename string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(batchID, 10) + ":" + filename))
+}
+
+func decodeExportFilesPageToken(token string) (int64, string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, "", errInvalidExportFilesPageToken
+	}
+	parts := strings.SplitN(string(b), ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return 0, "", errInvalidExportFilesPageToken
+	}
+	batchID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || batchID <= 0 {
+		return 0, "", errInvalidExportFilesPageToken
+	}
+	return batchID, parts[1], nil
+}
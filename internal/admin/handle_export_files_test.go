@@ -0,0 +1,257 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/export"
+	exportdatabase "github.com/google/exposure-notifications-server/internal/export/database"
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	"github.com/google/exposure-notifications-server/internal/project"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/exposure-notifications-server/internal/storage"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExportFilesPageToken(t *testing.T) {
+	t.Parallel()
+
+	token := encodeExportFilesPageToken(42, "root/1:2.zip")
+	batchID, filename, err := decodeExportFilesPageToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batchID != 42 || filename != "root/1:2.zip" {
+		t.Errorf("expected 42 root/1:2.zip, got %d %s", batchID, filename)
+	}
+
+	for _, token := range []string{"!", encodeExportFilesPageToken(0, "root/1.zip"), encodeExportFilesPageToken(42, ""), "YmFuYW5h"} {
+		if _, _, err := decodeExportFilesPageToken(token); err != errInvalidExportFilesPageToken {
+			t.Errorf("token %q: expected %v, got %v", token, errInvalidExportFilesPageToken, err)
+		}
+	}
+}
+
+func TestHandleExportFiles(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testEnv, _ := newTestServer(t)
+
+	blobstore, err := storage.NewMemory(ctx, &storage.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := serverenv.New(ctx,
+		serverenv.WithDatabase(testEnv.Database()),
+		serverenv.WithBlobStorage(blobstore))
+	s, err := NewServer(&Config{ExportFileBaseURL: "https://cdn.example.com/"}, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exportDB := exportdatabase.New(env.Database())
+	base := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	// The US config has a batch for each of the first four hours, the CA
+	// config one for the same hours.
+	configs := make(map[string]*model.ExportConfig)
+	for _, region := range []string{"US", "CA"} {
+		ec := &model.ExportConfig{
+			BucketName:   "export-bucket",
+			FilenameRoot: region,
+			Period:       time.Hour,
+			OutputRegion: region,
+			InputRegions: []string{region},
+			From:         base,
+		}
+		if err := exportDB.AddExportConfig(ctx, ec); err != nil {
+			t.Fatal(err)
+		}
+		configs[region] = ec
+
+		batches := make([]*model.ExportBatch, 0, 4)
+		for i := 0; i < 4; i++ {
+			batches = append(batches, &model.ExportBatch{
+				ConfigID:       ec.ConfigID,
+				BucketName:     ec.BucketName,
+				FilenameRoot:   ec.FilenameRoot,
+				StartTimestamp: base.Add(time.Duration(i) * time.Hour),
+				EndTimestamp:   base.Add(time.Duration(i+1) * time.Hour),
+				OutputRegion:   ec.OutputRegion,
+				InputRegions:   ec.InputRegions,
+				Status:         model.ExportBatchOpen,
+			})
+		}
+		if err := exportDB.AddExportBatches(ctx, batches); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each batch has a single file with one key, which is in storage.
+	exposures := []*publishmodel.Exposure{
+		{ExposureKey: []byte("0123456789abcdef"), IntervalNumber: 100, IntervalCount: 144},
+	}
+	batches := make(map[int64]*model.ExportBatch)
+	for {
+		eb, err := exportDB.LeaseBatch(ctx, time.Hour, time.Now(), time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if eb == nil {
+			break
+		}
+		name := fmt.Sprintf("%s/%d.zip", eb.FilenameRoot, eb.BatchID)
+		if err := exportDB.FinalizeBatch(ctx, eb, []string{name}, 1); err != nil {
+			t.Fatal(err)
+		}
+		data, err := export.MarshalExportFile(eb, exposures, nil, 1, false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := blobstore.CreateObject(ctx, eb.BucketName, name, data, false, storage.ContentTypeZip); err != nil {
+			t.Fatal(err)
+		}
+		batches[eb.BatchID] = eb
+	}
+	if got, want := len(batches), 8; got != want {
+		t.Fatalf("expected %d batches, got %d", want, got)
+	}
+
+	// The US batches that overlap the second and third hour.
+	var want []int64
+	for id, eb := range batches {
+		if eb.OutputRegion == "US" && eb.StartTimestamp.After(base) && eb.EndTimestamp.Before(base.Add(4*time.Hour)) {
+			want = append(want, id)
+		}
+	}
+	if len(want) != 2 {
+		t.Fatalf("expected two US batches in the range, got %v", want)
+	}
+	if want[0] > want[1] {
+		want[0], want[1] = want[1], want[0]
+	}
+
+	get := func(t *testing.T, query url.Values) (int, *exportFilesJSON) {
+		t.Helper()
+
+		server := newHTTPServer(t, http.MethodGet, "/", s.HandleExportFiles())
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/?"+query.Encode(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := server.Client().Do(req)
+		if err != nil {
+			t.Fatalf("error making http call: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var got exportFilesJSON
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode, &got
+	}
+
+	t.Run("paginated", func(t *testing.T) {
+		t.Parallel()
+
+		query := url.Values{
+			"region": {"us"},
+			"from":   {base.Add(90 * time.Minute).Format(time.RFC3339)},
+			"to":     {base.Add(150 * time.Minute).Format(time.RFC3339)},
+			"limit":  {"1"},
+		}
+
+		var got []*exportFileJSON
+		for i := 0; ; i++ {
+			if i > len(want) {
+				t.Fatalf("expected at most %d pages", len(want))
+			}
+			status, page := get(t, query)
+			if status != http.StatusOK {
+				t.Fatalf("expected status %d to be %d", status, http.StatusOK)
+			}
+			got = append(got, page.Files...)
+			if page.NextPageToken == "" {
+				break
+			}
+			query.Set("page-token", page.NextPageToken)
+		}
+
+		keys := 1
+		exists := true
+		var wantFiles []*exportFileJSON
+		for _, id := range want {
+			eb := batches[id]
+			name := fmt.Sprintf("US/%d.zip", id)
+			wantFiles = append(wantFiles, &exportFileJSON{
+				BatchID:        id,
+				ConfigID:       configs["US"].ConfigID,
+				StartTimestamp: eb.StartTimestamp.UTC(),
+				EndTimestamp:   eb.EndTimestamp.UTC(),
+				URL:            "https://cdn.example.com/" + name,
+				exportBatchFileJSON: &exportBatchFileJSON{
+					StoragePath: "export-bucket/" + name,
+					BatchNum:    1,
+					BatchSize:   1,
+					Status:      model.ExportBatchComplete,
+					Exists:      &exists,
+					Keys:        &keys,
+				},
+			})
+		}
+		for _, f := range got {
+			if f.Digest == "" {
+				t.Errorf("expected a digest for %s", f.StoragePath)
+			}
+			f.Digest = ""
+		}
+		if diff := cmp.Diff(wantFiles, got); diff != "" {
+			t.Errorf("mismatch (-want, +got):\n%s", diff)
+		}
+	})
+
+	cases := []struct {
+		name  string
+		query url.Values
+	}{
+		{"no_region", url.Values{"from": {base.Format(time.RFC3339)}, "to": {base.Add(time.Hour).Format(time.RFC3339)}}},
+		{"bad_from", url.Values{"region": {"US"}, "from": {"banana"}, "to": {base.Format(time.RFC3339)}}},
+		{"empty_range", url.Values{"region": {"US"}, "from": {base.Format(time.RFC3339)}, "to": {base.Format(time.RFC3339)}}},
+		{"bad_limit", url.Values{"region": {"US"}, "from": {base.Format(time.RFC3339)}, "to": {base.Add(time.Hour).Format(time.RFC3339)}, "limit": {"1000"}}},
+		{"bad_page_token", url.Values{"region": {"US"}, "from": {base.Format(time.RFC3339)}, "to": {base.Add(time.Hour).Format(time.RFC3339)}, "page-token": {"banana"}}},
+	}
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if status, _ := get(t, tc.query); status != http.StatusBadRequest {
+				t.Errorf("expected status %d to be %d", status, http.StatusBadRequest)
+			}
+		})
+	}
+}
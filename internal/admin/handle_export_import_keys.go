@@ -77,6 +77,14 @@ func (f *exportImportKeyForm) PopulateImportFilePublicKey(exportImportID int64,
 
 func (s *Server) HandleExportImportKeys() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		keyAction := ActionUpdate
+		if c.Param("action") == "create" {
+			keyAction = ActionCreate
+		}
+		if !s.authorize(c, keyAction, ResourceExportImporterKey, c.Param("id")) {
+			return
+		}
+
 		ctx := c.Request.Context()
 
 		db := database.New(s.env.Database())
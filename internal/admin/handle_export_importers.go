@@ -30,6 +30,10 @@ import (
 // importers.
 func (s *Server) HandleExportImportersSave() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		if !s.authorize(c, saveAction(c.Param("id")), ResourceExportImporter, c.Param("id")) {
+			return
+		}
+
 		var form exportImporterFormData
 		if err := c.Bind(&form); err != nil {
 			ErrorPage(c, err.Error())
@@ -72,6 +76,10 @@ func (s *Server) HandleExportImportersSave() func(c *gin.Context) {
 // importers.
 func (s *Server) HandleExportImportersShow() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		if !s.authorize(c, ActionView, ResourceExportImporter, c.Param("id")) {
+			return
+		}
+
 		ctx := c.Request.Context()
 
 		db := database.New(s.env.Database())
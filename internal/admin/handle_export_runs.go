@@ -0,0 +1,111 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/google/exposure-notifications-server/internal/export/database"
+	"github.com/google/exposure-notifications-server/internal/export/model"
+)
+
+const (
+	defaultExportRunsLimit = 50
+	maxExportRunsLimit     = 500
+)
+
+// exportRunsJSON is the response of HandleExportRuns.
+type exportRunsJSON struct {
+	ExportRuns []*exportRunJSON `json:"exportRuns"`
+}
+
+// exportRunJSON is the record of the batches of one export config processed by
+// an export worker run.
+type exportRunJSON struct {
+	RunID       int64     `json:"runID"`
+	ConfigID    int64     `json:"configID"`
+	StartedAt   time.Time `json:"startedAt"`
+	FinishedAt  time.Time `json:"finishedAt"`
+	Batches     int       `json:"batches"`
+	Keys        int       `json:"keys"`
+	RevisedKeys int       `json:"revisedKeys"`
+	Outcome     string    `json:"outcome"`
+	Error       string    `json:"error,omitempty"`
+}
+
+func newExportRunJSON(r *model.ExportRun) *exportRunJSON {
+	return &exportRunJSON{
+		RunID:       r.RunID,
+		ConfigID:    r.ConfigID,
+		StartedAt:   r.StartedAt.UTC(),
+		FinishedAt:  r.FinishedAt.UTC(),
+		Batches:     r.Batches,
+		Keys:        r.Keys,
+		RevisedKeys: r.RevisedKeys,
+		Outcome:     r.Outcome,
+		Error:       r.Error,
+	}
+}
+
+// HandleExportRuns returns the most recent export runs, newest first. The
+// optional config-id query parameter restricts them to one export config, and
+// limit sets how many runs are returned.
+func (s *Server) HandleExportRuns() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		configIDParam := c.Query("config-id")
+		if !s.authorize(c, ActionView, ResourceExportConfig, configIDParam) {
+			return
+		}
+
+		var configID int64
+		if configIDParam != "" {
+			var err error
+			configID, err = strconv.ParseInt(configIDParam, 10, 64)
+			if err != nil || configID <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "config-id must be a positive integer"})
+				return
+			}
+		}
+
+		limit := defaultExportRunsLimit
+		if v := c.Query("limit"); v != "" {
+			var err error
+			limit, err = strconv.Atoi(v)
+			if err != nil || limit <= 0 || limit > maxExportRunsLimit {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxExportRunsLimit)})
+				return
+			}
+		}
+
+		runs, err := database.New(s.env.Database()).ListExportRuns(c.Request.Context(), configID, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to read export runs: %v", err)})
+			return
+		}
+
+		resp := &exportRunsJSON{
+			ExportRuns: make([]*exportRunJSON, 0, len(runs)),
+		}
+		for _, r := range runs {
+			resp.ExportRuns = append(resp.ExportRuns, newExportRunJSON(r))
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/google/exposure-notifications-server/internal/export"
+	exportdatabase "github.com/google/exposure-notifications-server/internal/export/database"
+	"github.com/google/exposure-notifications-server/internal/export/model"
+)
+
+// maxExportVerifySize is the largest export file that can be verified.
+const maxExportVerifySize = 32 << 20
+
+const (
+	// exportKeyActive is a signing key without an end timestamp.
+	exportKeyActive = "active"
+	// exportKeyGrace is a signing key that is being rotated out, but that
+	// still signs exports until its end timestamp.
+	exportKeyGrace = "grace"
+)
+
+// exportVerifyJSON is the response of HandleExportVerify.
+type exportVerifyJSON struct {
+	// Valid is true if at least one signature of the file was made by a
+	// current signing key.
+	Valid bool `json:"valid"`
+	// Digest is the base64 encoded SHA-256 digest of the export.bin.
+	Digest     string                       `json:"digest,omitempty"`
+	Signatures []*exportVerifySignatureJSON `json:"signatures,omitempty"`
+	Error      string                       `json:"error,omitempty"`
+}
+
+// exportVerifySignatureJSON is a signature of the export file. The key ID and
+// version are what the file claims, Key is the signing key that validated the
+// signature, if any.
+type exportVerifySignatureJSON struct {
+	VerificationKeyID      string               `json:"verificationKeyID"`
+	VerificationKeyVersion string               `json:"verificationKeyVersion"`
+	Key                    *exportVerifyKeyJSON `json:"key,omitempty"`
+}
+
+type exportVerifyKeyJSON struct {
+	ID                int64      `json:"id"`
+	SigningKeyID      string     `json:"signingKeyID"`
+	SigningKeyVersion string     `json:"signingKeyVersion"`
+	Status            string     `json:"status"`
+	EndTimestamp      *time.Time `json:"endTimestamp,omitempty"`
+}
+
+// exportVerifyKey is a current signing key and its public key.
+type exportVerifyKey struct {
+	info      *model.SignatureInfo
+	publicKey *ecdsa.PublicKey
+}
+
+// HandleExportVerify verifies the signatures of an export file, sent as the
+// raw request body, against the current signing keys. That is the active keys
+// and the keys that are being rotated out but have not reached their end
+// timestamp yet. It reports which key, if any, validated each signature, so
+// support staff can check a file a client received wasn't corrupted or
+// tampered with.
+func (s *Server) HandleExportVerify() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if !s.authorize(c, ActionView, ResourceSignatureInfo, "") {
+			return
+		}
+
+		data, err := io.ReadAll(io.LimitReader(c.Request.Body, maxExportVerifySize+1))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, &exportVerifyJSON{Error: fmt.Sprintf("failed to read export file: %v", err)})
+			return
+		}
+		if len(data) == 0 {
+			c.JSON(http.StatusBadRequest, &exportVerifyJSON{Error: "export file is required"})
+			return
+		}
+		if len(data) > maxExportVerifySize {
+			c.JSON(http.StatusRequestEntityTooLarge, &exportVerifyJSON{Error: fmt.Sprintf("export file is larger than %d bytes", maxExportVerifySize)})
+			return
+		}
+
+		_, digest, err := export.UnmarshalExportFile(data)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, &exportVerifyJSON{Error: fmt.Sprintf("failed to parse export file: %v", err)})
+			return
+		}
+		sigList, err := export.UnmarshalSignatureFile(data)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, &exportVerifyJSON{Error: fmt.Sprintf("failed to parse signature file: %v", err)})
+			return
+		}
+
+		ctx := c.Request.Context()
+		keys, err := s.currentExportKeys(ctx, time.Now())
+		if err != nil {
+			log.Printf("failed to load export signing keys: %v", err)
+			c.JSON(http.StatusInternalServerError, &exportVerifyJSON{Error: err.Error()})
+			return
+		}
+
+		resp := &exportVerifyJSON{
+			Digest:     base64.StdEncoding.EncodeToString(digest),
+			Signatures: make([]*exportVerifySignatureJSON, 0, len(sigList.GetSignatures())),
+		}
+		for _, sig := range sigList.GetSignatures() {
+			result := &exportVerifySignatureJSON{
+				VerificationKeyID:      sig.GetSignatureInfo().GetVerificationKeyId(),
+				VerificationKeyVersion: sig.GetSignatureInfo().GetVerificationKeyVersion(),
+			}
+			// The key is found by trying all of them, rather than trusting the
+			// key ID in the file.
+			for _, key := range keys {
+				if ecdsa.VerifyASN1(key.publicKey, digest, sig.GetSignature()) {
+					result.Key = key.toJSON()
+					resp.Valid = true
+					break
+				}
+			}
+			resp.Signatures = append(resp.Signatures, result)
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// currentExportKeys returns the signing keys that are not expired at the given
+// time, with their public keys.
+func (s *Server) currentExportKeys(ctx context.Context, now time.Time) ([]*exportVerifyKey, error) {
+	sigInfos, err := exportdatabase.New(s.env.Database()).ListAllSignatureInfos(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signature infos: %w", err)
+	}
+
+	keys := make([]*exportVerifyKey, 0, len(sigInfos))
+	for _, si := range sigInfos {
+		if !si.EndTimestamp.IsZero() && !si.EndTimestamp.After(now) {
+			continue
+		}
+
+		signer, err := s.env.GetSignerForKey(ctx, si.SigningKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get signer for signature info %d: %w", si.ID, err)
+		}
+		publicKey, ok := signer.Public().(*ecdsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("signing key of signature info %d is %T, not an ECDSA key", si.ID, signer.Public())
+		}
+		keys = append(keys, &exportVerifyKey{info: si, publicKey: publicKey})
+	}
+	return keys, nil
+}
+
+func (k *exportVerifyKey) toJSON() *exportVerifyKeyJSON {
+	key := &exportVerifyKeyJSON{
+		ID:                k.info.ID,
+		SigningKeyID:      k.info.SigningKeyID,
+		SigningKeyVersion: k.info.SigningKeyVersion,
+		Status:            exportKeyActive,
+	}
+	if !k.info.EndTimestamp.IsZero() {
+		end := k.info.EndTimestamp.UTC()
+		key.Status = exportKeyGrace
+		key.EndTimestamp = &end
+	}
+	return key
+}
@@ -0,0 +1,247 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/export"
+	exportdatabase "github.com/google/exposure-notifications-server/internal/export/database"
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	"github.com/google/exposure-notifications-server/internal/project"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/exposure-notifications-server/pkg/keys"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHandleExportVerify(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+
+	// The signing keys are in a filesystem key manager.
+	root := t.TempDir()
+	newKey := func(name string) *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name == "" {
+			return key
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, name), der, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	kms, err := keys.NewFilesystem(ctx, &keys.Config{FilesystemRoot: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := serverenv.New(ctx,
+		serverenv.WithDatabase(testDB),
+		serverenv.WithKeyManager(kms))
+	s, err := NewServer(&Config{}, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exportDB := exportdatabase.New(testDB)
+	now := time.Now().UTC().Truncate(time.Second)
+	addSigInfo := func(name, version string, end time.Time) *model.SignatureInfo {
+		si := &model.SignatureInfo{
+			SigningKey:        name,
+			SigningKeyVersion: version,
+			SigningKeyID:      "310",
+			EndTimestamp:      end,
+		}
+		if err := exportDB.AddSignatureInfo(ctx, si); err != nil {
+			t.Fatal(err)
+		}
+		return si
+	}
+
+	// The active key, the key it replaced that is still in its grace period,
+	// and an older key that expired.
+	activeKey := newKey("active")
+	activeInfo := addSigInfo("active", "v3", time.Time{})
+	graceKey := newKey("grace")
+	graceEnd := now.Add(time.Hour)
+	graceInfo := addSigInfo("grace", "v2", graceEnd)
+	expiredKey := newKey("expired")
+	expiredInfo := addSigInfo("expired", "v1", now.Add(-time.Hour))
+	randomKey := newKey("")
+	randomInfo := &model.SignatureInfo{SigningKeyVersion: "v9", SigningKeyID: "310"}
+
+	newFile := func(si *model.SignatureInfo, key *ecdsa.PrivateKey) []byte {
+		eb := &model.ExportBatch{
+			BatchID:          1,
+			OutputRegion:     "US",
+			StartTimestamp:   now.Add(-2 * time.Hour),
+			EndTimestamp:     now.Add(-time.Hour),
+			SignatureInfoIDs: []int64{si.ID},
+		}
+		exposures := []*publishmodel.Exposure{
+			{ExposureKey: []byte("0123456789abcdef"), IntervalNumber: 100, IntervalCount: 144},
+		}
+		data, err := export.MarshalExportFile(eb, exposures, nil, 1, false, []*export.Signer{{SignatureInfo: si, Signer: key}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	server := newHTTPServer(t, http.MethodPost, "/export-verify.json", s.HandleExportVerify())
+
+	cases := []struct {
+		name   string
+		data   []byte
+		status int
+		want   *exportVerifyJSON
+	}{
+		{
+			name:   "active_key",
+			data:   newFile(activeInfo, activeKey),
+			status: http.StatusOK,
+			want: &exportVerifyJSON{
+				Valid: true,
+				Signatures: []*exportVerifySignatureJSON{
+					{
+						VerificationKeyID:      "310",
+						VerificationKeyVersion: "v3",
+						Key: &exportVerifyKeyJSON{
+							ID:                activeInfo.ID,
+							SigningKeyID:      "310",
+							SigningKeyVersion: "v3",
+							Status:            exportKeyActive,
+						},
+					},
+				},
+			},
+		},
+		{
+			name:   "grace_key",
+			data:   newFile(graceInfo, graceKey),
+			status: http.StatusOK,
+			want: &exportVerifyJSON{
+				Valid: true,
+				Signatures: []*exportVerifySignatureJSON{
+					{
+						VerificationKeyID:      "310",
+						VerificationKeyVersion: "v2",
+						Key: &exportVerifyKeyJSON{
+							ID:                graceInfo.ID,
+							SigningKeyID:      "310",
+							SigningKeyVersion: "v2",
+							Status:            exportKeyGrace,
+							EndTimestamp:      &graceEnd,
+						},
+					},
+				},
+			},
+		},
+		{
+			name:   "expired_key",
+			data:   newFile(expiredInfo, expiredKey),
+			status: http.StatusOK,
+			want: &exportVerifyJSON{
+				Signatures: []*exportVerifySignatureJSON{
+					{VerificationKeyID: "310", VerificationKeyVersion: "v1"},
+				},
+			},
+		},
+		{
+			name:   "random_key",
+			data:   newFile(randomInfo, randomKey),
+			status: http.StatusOK,
+			want: &exportVerifyJSON{
+				Signatures: []*exportVerifySignatureJSON{
+					{VerificationKeyID: "310", VerificationKeyVersion: "v9"},
+				},
+			},
+		},
+		{
+			name:   "empty",
+			status: http.StatusBadRequest,
+			want:   &exportVerifyJSON{Error: "export file is required"},
+		},
+		{
+			name:   "not_a_zip",
+			data:   []byte("banana"),
+			status: http.StatusBadRequest,
+			want:   &exportVerifyJSON{Error: "failed to parse export file: can't read payload: zip: not a valid zip file"},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL+"/export-verify.json", bytes.NewReader(tc.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/zip")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := resp.StatusCode, tc.status; got != want {
+				t.Fatalf("expected status %d to be %d: %s", got, want, body)
+			}
+
+			var got exportVerifyJSON
+			if err := json.Unmarshal(body, &got); err != nil {
+				t.Fatal(err)
+			}
+			// The digest is checked separately, it's the same for all files.
+			if tc.want.Error == "" {
+				if got.Digest == "" {
+					t.Errorf("expected a digest")
+				}
+				got.Digest = ""
+			}
+			if diff := cmp.Diff(tc.want, &got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
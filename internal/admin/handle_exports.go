@@ -33,6 +33,10 @@ import (
 // HandleExportsSave handles the create/update actions for exports.
 func (s *Server) HandleExportsSave() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		if !s.authorize(c, saveAction(c.Param("id")), ResourceExportConfig, c.Param("id")) {
+			return
+		}
+
 		var form exportFormData
 		err := c.Bind(&form)
 		if err != nil {
@@ -72,6 +76,10 @@ func (s *Server) HandleExportsSave() func(c *gin.Context) {
 // HandleExportsShow handles the show action for exports.
 func (s *Server) HandleExportsShow() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		if !s.authorize(c, ActionView, ResourceExportConfig, c.Param("id")) {
+			return
+		}
+
 		ctx := c.Request.Context()
 		m := TemplateMap{}
 
@@ -134,6 +142,51 @@ type exportFormData struct {
 	ThruTime           string        `form:"thru-time"`
 	SigInfoIDs         []int64       `form:"sig-info"`
 	MaxRecordsOverride int           `form:"max-records-override"`
+
+	// One "REGION:ID" per line, empty signs all regions with SigInfoIDs.
+	RegionSignatureInfos string `form:"region-signature-infos"`
+
+	// Empty values are unbounded.
+	OnsetWindowMinDays  string `form:"onset-window-min-days"`
+	OnsetWindowMaxDays  string `form:"onset-window-max-days"`
+	ExcludeMissingOnset bool   `form:"exclude-missing-onset"`
+
+	OnlyRevisedKeys    bool `form:"only-revised-keys"`
+	ExcludeRevisedKeys bool `form:"exclude-revised-keys"`
+
+	IncludeCBOR bool `form:"include-cbor"`
+
+	// Zero exports keys of any transmission risk.
+	MinTransmissionRisk            int  `form:"min-transmission-risk"`
+	IncludeUnknownTransmissionRisk bool `form:"include-unknown-transmission-risk"`
+
+	// Empty leaves the report type of keys without one unset.
+	DefaultReportType string `form:"default-report-type"`
+
+	// Empty exports keys of any report type.
+	ReportTypes              []string `form:"report-types"`
+	IncludeUnknownReportType bool     `form:"include-unknown-report-type"`
+
+	// Empty uses the period.
+	Schedule string `form:"schedule"`
+
+	// Empty uses the storage defaults.
+	CacheControl       string `form:"cache-control"`
+	IndexCacheControl  string `form:"index-cache-control"`
+	ContentDisposition string `form:"content-disposition"`
+}
+
+// parseOptionalDays parses a number of days, returning nil if it's empty.
+func parseOptionalDays(s string) (*int, error) {
+	s = project.TrimSpaceAndNonPrintable(s)
+	if s == "" {
+		return nil, nil
+	}
+	days, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, err
+	}
+	return &days, nil
 }
 
 // splitRegions turns a string of regions (generally separated by newlines), and
@@ -150,6 +203,35 @@ func splitRegions(regions string) []string {
 	return ret
 }
 
+// parseRegionSignatureInfos parses "REGION:ID" lines, mapping each region to
+// the ID of the signature info it's signed with. Regions are kept as entered.
+func parseRegionSignatureInfos(s string) (map[string]int64, error) {
+	var ret map[string]int64
+	for _, line := range strings.Split(s, "\n") {
+		line = project.TrimSpaceAndNonPrintable(line)
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%q must be REGION:ID", line)
+		}
+		region := project.TrimSpaceAndNonPrintable(parts[0])
+		id, err := strconv.ParseInt(project.TrimSpaceAndNonPrintable(parts[1]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signature info ID for region %q: %w", region, err)
+		}
+		if _, ok := ret[region]; ok {
+			return nil, fmt.Errorf("region %q has more than one signature info", region)
+		}
+		if ret == nil {
+			ret = make(map[string]int64)
+		}
+		ret[region] = id
+	}
+	return ret, nil
+}
+
 func (f *exportFormData) PopulateExportConfig(ec *model.ExportConfig) error {
 	from, err := CombineDateAndTime(f.FromDate, f.FromTime)
 	if err != nil {
@@ -162,6 +244,18 @@ func (f *exportFormData) PopulateExportConfig(ec *model.ExportConfig) error {
 	if f.IncludeTravelers && f.OnlyNonTravelers {
 		return fmt.Errorf("cannot have both 'include travelers', and 'only non-travelers' set")
 	}
+	onsetMin, err := parseOptionalDays(f.OnsetWindowMinDays)
+	if err != nil {
+		return fmt.Errorf("invalid onset window minimum days: %w", err)
+	}
+	onsetMax, err := parseOptionalDays(f.OnsetWindowMaxDays)
+	if err != nil {
+		return fmt.Errorf("invalid onset window maximum days: %w", err)
+	}
+	regionSigInfos, err := parseRegionSignatureInfos(f.RegionSignatureInfos)
+	if err != nil {
+		return fmt.Errorf("invalid region signing keys: %w", err)
+	}
 
 	ec.BucketName = project.TrimSpaceAndNonPrintable(f.BucketName)
 	ec.FilenameRoot = project.TrimSpaceAndNonPrintable(f.FilenameRoot)
@@ -174,11 +268,32 @@ func (f *exportFormData) PopulateExportConfig(ec *model.ExportConfig) error {
 	ec.From = from
 	ec.Thru = thru
 	ec.SignatureInfoIDs = f.SigInfoIDs
+	ec.RegionSignatureInfos = regionSigInfos
 	if f.MaxRecordsOverride > 0 {
 		ec.MaxRecordsOverride = &f.MaxRecordsOverride
 	} else {
 		ec.MaxRecordsOverride = nil
 	}
+	ec.OnsetWindowMinDays = onsetMin
+	ec.OnsetWindowMaxDays = onsetMax
+	ec.ExcludeMissingOnset = f.ExcludeMissingOnset
+	ec.OnlyRevisedKeys = f.OnlyRevisedKeys
+	ec.ExcludeRevisedKeys = f.ExcludeRevisedKeys
+	ec.IncludeCBOR = f.IncludeCBOR
+	ec.MinTransmissionRisk = f.MinTransmissionRisk
+	ec.IncludeUnknownTransmissionRisk = f.IncludeUnknownTransmissionRisk
+	ec.DefaultReportType = project.TrimSpaceAndNonPrintable(f.DefaultReportType)
+	ec.ReportTypes = nil
+	for _, reportType := range f.ReportTypes {
+		if reportType = project.TrimSpaceAndNonPrintable(reportType); reportType != "" {
+			ec.ReportTypes = append(ec.ReportTypes, reportType)
+		}
+	}
+	ec.IncludeUnknownReportType = f.IncludeUnknownReportType
+	ec.Schedule = project.TrimSpaceAndNonPrintable(f.Schedule)
+	ec.CacheControl = project.TrimSpaceAndNonPrintable(f.CacheControl)
+	ec.IndexCacheControl = project.TrimSpaceAndNonPrintable(f.IndexCacheControl)
+	ec.ContentDisposition = project.TrimSpaceAndNonPrintable(f.ContentDisposition)
 
 	if limit := 10; len(ec.SignatureInfoIDs) > limit {
 		return fmt.Errorf("too many signing keys selected, there is a limit of %d", limit)
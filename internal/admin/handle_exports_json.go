@@ -0,0 +1,408 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/go-multierror"
+
+	"github.com/google/exposure-notifications-server/internal/export/database"
+	"github.com/google/exposure-notifications-server/internal/export/model"
+)
+
+// exportConfigsJSON is the downloadable snapshot of all export configs. It can
+// be uploaded again to restore the export configs.
+type exportConfigsJSON struct {
+	ExportConfigs []*exportConfigJSON `json:"exportConfigs"`
+}
+
+// exportConfigJSON is a single export config. Signing keys are referenced by
+// their signature info, the key material is never included.
+type exportConfigJSON struct {
+	ConfigID                       int64                        `json:"configID"`
+	BucketName                     string                       `json:"bucketName"`
+	FilenameRoot                   string                       `json:"filenameRoot"`
+	Period                         string                       `json:"period"`
+	OutputRegion                   string                       `json:"outputRegion"`
+	InputRegions                   []string                     `json:"inputRegions"`
+	ExcludeRegions                 []string                     `json:"excludeRegions"`
+	IncludeTravelers               bool                         `json:"includeTravelers"`
+	OnlyNonTravelers               bool                         `json:"onlyNonTravelers"`
+	From                           time.Time                    `json:"from"`
+	Thru                           *time.Time                   `json:"thru,omitempty"`
+	SignatureInfos                 []*signatureInfoRef          `json:"signatureInfos"`
+	RegionSignatureInfos           map[string]*signatureInfoRef `json:"regionSignatureInfos,omitempty"`
+	MaxRecordsOverride             *int                         `json:"maxRecordsOverride,omitempty"`
+	OnsetWindowMinDays             *int                         `json:"onsetWindowMinDays,omitempty"`
+	OnsetWindowMaxDays             *int                         `json:"onsetWindowMaxDays,omitempty"`
+	ExcludeMissingOnset            bool                         `json:"excludeMissingOnset"`
+	OnlyRevisedKeys                bool                         `json:"onlyRevisedKeys,omitempty"`
+	ExcludeRevisedKeys             bool                         `json:"excludeRevisedKeys,omitempty"`
+	IncludeCBOR                    bool                         `json:"includeCBOR,omitempty"`
+	MinTransmissionRisk            int                          `json:"minTransmissionRisk,omitempty"`
+	IncludeUnknownTransmissionRisk bool                         `json:"includeUnknownTransmissionRisk,omitempty"`
+	DefaultReportType              string                       `json:"defaultReportType,omitempty"`
+	ReportTypes                    []string                     `json:"reportTypes,omitempty"`
+	IncludeUnknownReportType       bool                         `json:"includeUnknownReportType,omitempty"`
+	Schedule                       string                       `json:"schedule,omitempty"`
+	CacheControl                   string                       `json:"cacheControl,omitempty"`
+	IndexCacheControl              string                       `json:"indexCacheControl,omitempty"`
+	ContentDisposition             string                       `json:"contentDisposition,omitempty"`
+}
+
+// signatureInfoRef references an existing signature info. The signing key is
+// the key manager resource name, which must match on upload.
+type signatureInfoRef struct {
+	ID                int64  `json:"id"`
+	SigningKey        string `json:"signingKey"`
+	SigningKeyVersion string `json:"signingKeyVersion"`
+	SigningKeyID      string `json:"signingKeyID"`
+}
+
+func newExportConfigJSON(ec *model.ExportConfig, sigInfos map[int64]*model.SignatureInfo) (*exportConfigJSON, error) {
+	ref := func(id int64) (*signatureInfoRef, error) {
+		si, ok := sigInfos[id]
+		if !ok {
+			return nil, fmt.Errorf("export config %d references unknown signature info %d", ec.ConfigID, id)
+		}
+		return &signatureInfoRef{
+			ID:                si.ID,
+			SigningKey:        si.SigningKey,
+			SigningKeyVersion: si.SigningKeyVersion,
+			SigningKeyID:      si.SigningKeyID,
+		}, nil
+	}
+
+	refs := make([]*signatureInfoRef, 0, len(ec.SignatureInfoIDs))
+	for _, id := range ec.SignatureInfoIDs {
+		r, err := ref(id)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, r)
+	}
+
+	var regionRefs map[string]*signatureInfoRef
+	for region, id := range ec.RegionSignatureInfos {
+		r, err := ref(id)
+		if err != nil {
+			return nil, err
+		}
+		if regionRefs == nil {
+			regionRefs = make(map[string]*signatureInfoRef, len(ec.RegionSignatureInfos))
+		}
+		regionRefs[region] = r
+	}
+
+	var thru *time.Time
+	if !ec.Thru.IsZero() {
+		t := ec.Thru.UTC()
+		thru = &t
+	}
+
+	return &exportConfigJSON{
+		ConfigID:                       ec.ConfigID,
+		BucketName:                     ec.BucketName,
+		FilenameRoot:                   ec.FilenameRoot,
+		Period:                         ec.Period.String(),
+		OutputRegion:                   ec.OutputRegion,
+		InputRegions:                   ec.InputRegions,
+		ExcludeRegions:                 ec.ExcludeRegions,
+		IncludeTravelers:               ec.IncludeTravelers,
+		OnlyNonTravelers:               ec.OnlyNonTravelers,
+		From:                           ec.From.UTC(),
+		Thru:                           thru,
+		SignatureInfos:                 refs,
+		RegionSignatureInfos:           regionRefs,
+		MaxRecordsOverride:             ec.MaxRecordsOverride,
+		OnsetWindowMinDays:             ec.OnsetWindowMinDays,
+		OnsetWindowMaxDays:             ec.OnsetWindowMaxDays,
+		ExcludeMissingOnset:            ec.ExcludeMissingOnset,
+		OnlyRevisedKeys:                ec.OnlyRevisedKeys,
+		ExcludeRevisedKeys:             ec.ExcludeRevisedKeys,
+		IncludeCBOR:                    ec.IncludeCBOR,
+		MinTransmissionRisk:            ec.MinTransmissionRisk,
+		IncludeUnknownTransmissionRisk: ec.IncludeUnknownTransmissionRisk,
+		DefaultReportType:              ec.DefaultReportType,
+		ReportTypes:                    ec.ReportTypes,
+		IncludeUnknownReportType:       ec.IncludeUnknownReportType,
+		Schedule:                       ec.Schedule,
+		CacheControl:                   ec.CacheControl,
+		IndexCacheControl:              ec.IndexCacheControl,
+		ContentDisposition:             ec.ContentDisposition,
+	}, nil
+}
+
+// toExportConfig converts back to an export config, resolving the signature
+// info references against the existing signature infos. All validation errors
+// are returned, as a multierror.
+func (j *exportConfigJSON) toExportConfig(sigInfos map[int64]*model.SignatureInfo) (*model.ExportConfig, error) {
+	var merr *multierror.Error
+
+	if strings.TrimSpace(j.BucketName) == "" {
+		merr = multierror.Append(merr, fmt.Errorf("bucketName is required"))
+	}
+	if strings.TrimSpace(j.FilenameRoot) == "" {
+		merr = multierror.Append(merr, fmt.Errorf("filenameRoot is required"))
+	}
+
+	if j.OutputRegion == "" {
+		merr = multierror.Append(merr, fmt.Errorf("outputRegion is required"))
+	} else if !validRegion(j.OutputRegion) {
+		merr = multierror.Append(merr, fmt.Errorf("outputRegion %q is not a valid region", j.OutputRegion))
+	}
+	excluded := make(map[string]struct{}, len(j.ExcludeRegions))
+	for _, r := range j.ExcludeRegions {
+		if !validRegion(r) {
+			merr = multierror.Append(merr, fmt.Errorf("excludeRegions %q is not a valid region", r))
+		}
+		excluded[r] = struct{}{}
+	}
+	for _, r := range j.InputRegions {
+		if !validRegion(r) {
+			merr = multierror.Append(merr, fmt.Errorf("inputRegions %q is not a valid region", r))
+		}
+		if _, ok := excluded[r]; ok {
+			merr = multierror.Append(merr, fmt.Errorf("region %q is both included and excluded", r))
+		}
+	}
+	if j.IncludeTravelers && j.OnlyNonTravelers {
+		merr = multierror.Append(merr, fmt.Errorf("cannot have both includeTravelers and onlyNonTravelers set"))
+	}
+
+	period, err := time.ParseDuration(j.Period)
+	if err != nil {
+		merr = multierror.Append(merr, fmt.Errorf("invalid period %q: %w", j.Period, err))
+	}
+	if j.From.IsZero() {
+		merr = multierror.Append(merr, fmt.Errorf("from is required"))
+	}
+	if j.Thru != nil && !j.Thru.After(j.From) {
+		merr = multierror.Append(merr, fmt.Errorf("thru must be after from"))
+	}
+
+	resolve := func(ref *signatureInfoRef) (int64, bool) {
+		si, ok := sigInfos[ref.ID]
+		if !ok {
+			merr = multierror.Append(merr, fmt.Errorf("signature info %d (signing key %q) does not exist", ref.ID, ref.SigningKey))
+			return 0, false
+		}
+		if si.SigningKey != ref.SigningKey {
+			merr = multierror.Append(merr, fmt.Errorf("signature info %d has signing key %q, expected %q", ref.ID, si.SigningKey, ref.SigningKey))
+			return 0, false
+		}
+		return si.ID, true
+	}
+
+	ids := make([]int64, 0, len(j.SignatureInfos))
+	for _, ref := range j.SignatureInfos {
+		if id, ok := resolve(ref); ok {
+			ids = append(ids, id)
+		}
+	}
+	var regionIDs map[string]int64
+	for region, ref := range j.RegionSignatureInfos {
+		if ref == nil {
+			merr = multierror.Append(merr, fmt.Errorf("regionSignatureInfos %q is missing its signature info", region))
+			continue
+		}
+		if id, ok := resolve(ref); ok {
+			if regionIDs == nil {
+				regionIDs = make(map[string]int64, len(j.RegionSignatureInfos))
+			}
+			regionIDs[region] = id
+		}
+	}
+	if limit := 10; len(j.SignatureInfos) > limit {
+		merr = multierror.Append(merr, fmt.Errorf("too many signing keys, there is a limit of %d", limit))
+	}
+
+	ec := &model.ExportConfig{
+		ConfigID:                       j.ConfigID,
+		BucketName:                     j.BucketName,
+		FilenameRoot:                   j.FilenameRoot,
+		Period:                         period,
+		OutputRegion:                   j.OutputRegion,
+		InputRegions:                   j.InputRegions,
+		ExcludeRegions:                 j.ExcludeRegions,
+		IncludeTravelers:               j.IncludeTravelers,
+		OnlyNonTravelers:               j.OnlyNonTravelers,
+		From:                           j.From,
+		SignatureInfoIDs:               ids,
+		RegionSignatureInfos:           regionIDs,
+		MaxRecordsOverride:             j.MaxRecordsOverride,
+		OnsetWindowMinDays:             j.OnsetWindowMinDays,
+		OnsetWindowMaxDays:             j.OnsetWindowMaxDays,
+		ExcludeMissingOnset:            j.ExcludeMissingOnset,
+		OnlyRevisedKeys:                j.OnlyRevisedKeys,
+		ExcludeRevisedKeys:             j.ExcludeRevisedKeys,
+		IncludeCBOR:                    j.IncludeCBOR,
+		MinTransmissionRisk:            j.MinTransmissionRisk,
+		IncludeUnknownTransmissionRisk: j.IncludeUnknownTransmissionRisk,
+		DefaultReportType:              j.DefaultReportType,
+		ReportTypes:                    j.ReportTypes,
+		IncludeUnknownReportType:       j.IncludeUnknownReportType,
+		Schedule:                       j.Schedule,
+		CacheControl:                   j.CacheControl,
+		IndexCacheControl:              j.IndexCacheControl,
+		ContentDisposition:             j.ContentDisposition,
+	}
+	if j.Thru != nil {
+		ec.Thru = *j.Thru
+	}
+	if err == nil {
+		// The period is only validated if it could be parsed.
+		if err := ec.Validate(); err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
+
+	if err := merr.ErrorOrNil(); err != nil {
+		return nil, err
+	}
+	return ec, nil
+}
+
+// validRegion returns true if the region is non-empty and only contains
+// letters, digits, dashes, and underscores.
+func validRegion(region string) bool {
+	if region == "" {
+		return false
+	}
+	for _, r := range region {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// HandleExportsDownload returns all export configs as JSON.
+func (s *Server) HandleExportsDownload() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if !s.authorize(c, ActionView, ResourceExportConfig, "") {
+			return
+		}
+
+		ctx := c.Request.Context()
+		db := database.New(s.env.Database())
+
+		configs, err := db.GetAllExportConfigs(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to read export configs: %v", err)})
+			return
+		}
+		sigInfos, err := signatureInfosByID(ctx, db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		resp := &exportConfigsJSON{
+			ExportConfigs: make([]*exportConfigJSON, 0, len(configs)),
+		}
+		for _, ec := range configs {
+			j, err := newExportConfigJSON(ec, sigInfos)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			resp.ExportConfigs = append(resp.ExportConfigs, j)
+		}
+
+		c.Header("Content-Disposition", `attachment; filename="export-configs.json"`)
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// HandleExportsUpload creates or updates export configs from JSON previously
+// returned by HandleExportsDownload. Configs with an ID that exists are
+// updated, all others are created. All configs are validated, and all
+// validation errors are reported, before any are written. The configs are
+// written in a single transaction.
+func (s *Server) HandleExportsUpload() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		// Uploads can both create and update export configs.
+		if !s.authorize(c, ActionCreate, ResourceExportConfig, "") || !s.authorize(c, ActionUpdate, ResourceExportConfig, "") {
+			return
+		}
+
+		ctx := c.Request.Context()
+		db := database.New(s.env.Database())
+
+		var req exportConfigsJSON
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": []string{fmt.Sprintf("invalid export configs: %v", err)}})
+			return
+		}
+
+		sigInfos, err := signatureInfosByID(ctx, db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{err.Error()}})
+			return
+		}
+
+		configs := make([]*model.ExportConfig, 0, len(req.ExportConfigs))
+		var errs []string
+		for i, j := range req.ExportConfigs {
+			ec, err := j.toExportConfig(sigInfos)
+			if err != nil {
+				var merr *multierror.Error
+				if errors.As(err, &merr) {
+					for _, err := range merr.WrappedErrors() {
+						errs = append(errs, fmt.Sprintf("exportConfigs[%d]: %v", i, err))
+					}
+				} else {
+					errs = append(errs, fmt.Sprintf("exportConfigs[%d]: %v", i, err))
+				}
+				continue
+			}
+			configs = append(configs, ec)
+		}
+		if len(errs) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+			return
+		}
+
+		created, updated, err := db.UpsertExportConfigs(ctx, configs)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{err.Error()}})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"created": created, "updated": updated})
+	}
+}
+
+// signatureInfosByID returns all signature infos, by ID.
+func signatureInfosByID(ctx context.Context, db *database.ExportDB) (map[int64]*model.SignatureInfo, error) {
+	infos, err := db.ListAllSignatureInfos(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signature infos: %w", err)
+	}
+	byID := make(map[int64]*model.SignatureInfo, len(infos))
+	for _, si := range infos {
+		byID[si.ID] = si
+	}
+	return byID, nil
+}
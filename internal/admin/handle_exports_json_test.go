@@ -0,0 +1,316 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/export/database"
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	"github.com/google/exposure-notifications-server/internal/project"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/hashicorp/go-multierror"
+)
+
+func TestExportConfigJSON(t *testing.T) {
+	t.Parallel()
+
+	sigInfos := map[int64]*model.SignatureInfo{
+		7: {
+			ID:                7,
+			SigningKey:        "/test/case/key/7",
+			SigningKeyVersion: "v1",
+			SigningKeyID:      "310",
+		},
+		8: {
+			ID:                8,
+			SigningKey:        "/test/case/key/8",
+			SigningKeyVersion: "v1",
+			SigningKeyID:      "311",
+		},
+	}
+
+	want := &model.ExportConfig{
+		ConfigID:            12,
+		BucketName:          "bucket",
+		FilenameRoot:        "root",
+		Period:              4 * time.Hour,
+		OutputRegion:        "TEST",
+		InputRegions:        []string{"TEST"},
+		ExcludeRegions:      []string{"NOT-TEST"},
+		IncludeTravelers:    true,
+		From:                time.Unix(1609579380, 0).UTC(),
+		Thru:                time.Unix(1641119640, 0).UTC(),
+		SignatureInfoIDs:    []int64{7},
+		MaxRecordsOverride:  intPtr(10),
+		OnsetWindowMaxDays:  intPtr(14),
+		ExcludeMissingOnset: true,
+	}
+	want.RegionSignatureInfos = map[string]int64{"TEST": 8}
+
+	j, err := newExportConfigJSON(want, sigInfos)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(b); !strings.Contains(got, `"signingKey":"/test/case/key/7"`) {
+		t.Errorf("expected signing key reference in %s", got)
+	}
+	if got := string(b); !strings.Contains(got, `"regionSignatureInfos":{"TEST":{"id":8,"signingKey":"/test/case/key/8"`) {
+		t.Errorf("expected region signing key reference in %s", got)
+	}
+
+	var decoded exportConfigJSON
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	got, err := decoded.toExportConfig(sigInfos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// A signature info with a different signing key isn't the one referenced.
+	other := map[int64]*model.SignatureInfo{
+		7: {ID: 7, SigningKey: "/other/key"},
+		8: sigInfos[8],
+	}
+	if _, err := decoded.toExportConfig(other); err == nil || !strings.Contains(err.Error(), "has signing key") {
+		t.Errorf("expected signing key mismatch, got %v", err)
+	}
+
+	if _, err := decoded.toExportConfig(map[int64]*model.SignatureInfo{}); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("expected missing signature info, got %v", err)
+	}
+}
+
+func TestExportConfigJSONValidation(t *testing.T) {
+	t.Parallel()
+
+	sigInfos := map[int64]*model.SignatureInfo{
+		1: {ID: 1, SigningKey: "/test/case/key/1"},
+	}
+	thru := time.Unix(1609579380, 0).UTC()
+
+	j := &exportConfigJSON{
+		FilenameRoot:     "root",
+		Period:           "5h",
+		OutputRegion:     "TEST",
+		InputRegions:     []string{"US", "not a region"},
+		ExcludeRegions:   []string{"US"},
+		IncludeTravelers: true,
+		From:             thru.Add(time.Hour),
+		Thru:             &thru,
+		SignatureInfos: []*signatureInfoRef{
+			{ID: 1, SigningKey: "/test/case/key/1"},
+			{ID: 2, SigningKey: "/test/case/key/2"},
+		},
+	}
+
+	_, err := j.toExportConfig(sigInfos)
+	var merr *multierror.Error
+	if !errors.As(err, &merr) {
+		t.Fatalf("expected multierror, got %v", err)
+	}
+
+	want := []string{
+		"bucketName is required",
+		`region "US" is both included and excluded`,
+		`inputRegions "not a region" is not a valid region`,
+		"thru must be after from",
+		`signature info 2 (signing key "/test/case/key/2") does not exist`,
+		"period must divide equally into 24 hours",
+	}
+	got := merr.WrappedErrors()
+	if len(got) != len(want) {
+		t.Fatalf("expected %d errors, got %d: %v", len(want), len(got), got)
+	}
+	for i, w := range want {
+		if !strings.Contains(got[i].Error(), w) {
+			t.Errorf("expected error %d %q to contain %q", i, got[i], w)
+		}
+	}
+}
+
+func TestHandleExportsUploadInvalid(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	env, s := newTestServer(t)
+	exportDB := database.New(env.Database())
+
+	// The first config is valid, but nothing is written since the second isn't.
+	body := `{"exportConfigs": [
+		{"bucketName": "bucket", "filenameRoot": "root", "period": "4h", "outputRegion": "TEST", "from": "2021-01-02T09:23:00Z"},
+		{"bucketName": "bucket", "filenameRoot": "root2", "period": "banana", "outputRegion": "",
+		 "from": "2021-01-02T09:23:00Z", "signatureInfos": [{"id": 42, "signingKey": "/missing/key"}]}
+	]}`
+
+	server := newHTTPServer(t, http.MethodPost, "/exports.json", s.HandleExportsUpload())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL+"/exports.json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got, want := resp.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("expected status %d to be %d", got, want)
+	}
+
+	var result struct {
+		Errors []string `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"exportConfigs[1]: outputRegion is required",
+		`exportConfigs[1]: invalid period "banana"`,
+		`exportConfigs[1]: signature info 42 (signing key "/missing/key") does not exist`,
+	}
+	if len(result.Errors) != len(want) {
+		t.Fatalf("expected %d errors, got %d: %v", len(want), len(result.Errors), result.Errors)
+	}
+	for i, w := range want {
+		if !strings.Contains(result.Errors[i], w) {
+			t.Errorf("expected error %d %q to contain %q", i, result.Errors[i], w)
+		}
+	}
+
+	configs, err := exportDB.GetAllExportConfigs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 0 {
+		t.Errorf("expected no export configs to be written, got %d", len(configs))
+	}
+}
+
+func TestHandleExportsDownloadUpload(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	// Each server has its own database, with the same signature info.
+	seed := func(t *testing.T) (*Server, *database.ExportDB, *model.SignatureInfo) {
+		t.Helper()
+
+		env, s := newTestServer(t)
+		exportDB := database.New(env.Database())
+
+		sigInfo := &model.SignatureInfo{
+			SigningKey:        "/test/case/key/1",
+			SigningKeyVersion: "v1",
+			SigningKeyID:      "310",
+		}
+		if err := exportDB.AddSignatureInfo(ctx, sigInfo); err != nil {
+			t.Fatal(err)
+		}
+		return s, exportDB, sigInfo
+	}
+
+	source, sourceDB, sigInfo := seed(t)
+	want := &model.ExportConfig{
+		BucketName:          "bucket",
+		FilenameRoot:        "root",
+		Period:              4 * time.Hour,
+		OutputRegion:        "TEST",
+		InputRegions:        []string{"TEST"},
+		ExcludeRegions:      []string{"NOT-TEST"},
+		IncludeTravelers:    true,
+		From:                time.Unix(1609579380, 0).UTC(),
+		Thru:                time.Unix(1641119640, 0).UTC(),
+		SignatureInfoIDs:    []int64{sigInfo.ID},
+		MaxRecordsOverride:  intPtr(10),
+		OnsetWindowMinDays:  intPtr(1),
+		OnsetWindowMaxDays:  intPtr(14),
+		ExcludeMissingOnset: true,
+	}
+	if err := sourceDB.AddExportConfig(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+
+	// Download from the source.
+	downloadServer := newHTTPServer(t, http.MethodGet, "/exports.json", source.HandleExportsDownload())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadServer.URL+"/exports.json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := downloadServer.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	downloaded, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("expected status %d to be %d: %s", got, want, downloaded)
+	}
+
+	// Upload to the destination.
+	dest, destDB, _ := seed(t)
+	uploadServer := newHTTPServer(t, http.MethodPost, "/exports.json", dest.HandleExportsUpload())
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, uploadServer.URL+"/exports.json", bytes.NewReader(downloaded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = uploadServer.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		b, _ := io.ReadAll(resp.Body)
+		t.Fatalf("expected status %d to be %d: %s", got, want, b)
+	}
+
+	got, err := destDB.GetAllExportConfigs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := cmp.Options{
+		cmpopts.IgnoreFields(model.ExportConfig{}, "ConfigID"),
+		cmpopts.EquateApproxTime(time.Second),
+	}
+	if diff := cmp.Diff([]*model.ExportConfig{want}, got, opts); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
@@ -111,6 +111,153 @@ func TestPopulateExportConfig(t *testing.T) {
 				MaxRecordsOverride: intPtr(10),
 			},
 		},
+		{
+			name: "region_signature_infos",
+			form: &exportFormData{
+				OutputRegion:         "TEST",
+				Period:               4 * time.Hour,
+				FromDate:             "2021-01-02",
+				FromTime:             "09:23",
+				SigInfoIDs:           []int64{1},
+				RegionSignatureInfos: "TEST:2\n\n other : 3 \n",
+			},
+			exp: &model.ExportConfig{
+				Period:               4 * time.Hour,
+				OutputRegion:         "TEST",
+				InputRegions:         []string{},
+				ExcludeRegions:       []string{},
+				From:                 from,
+				SignatureInfoIDs:     []int64{1},
+				RegionSignatureInfos: map[string]int64{"TEST": 2, "other": 3},
+			},
+		},
+		{
+			name: "region_signature_infos_invalid",
+			form: &exportFormData{
+				OutputRegion:         "TEST",
+				Period:               4 * time.Hour,
+				FromDate:             "2021-01-02",
+				FromTime:             "09:23",
+				RegionSignatureInfos: "TEST",
+			},
+			err: "must be REGION:ID",
+		},
+		{
+			name: "region_signature_infos_duplicate",
+			form: &exportFormData{
+				OutputRegion:         "TEST",
+				Period:               4 * time.Hour,
+				FromDate:             "2021-01-02",
+				FromTime:             "09:23",
+				RegionSignatureInfos: "TEST:1\nTEST:2",
+			},
+			err: "more than one signature info",
+		},
+		{
+			name: "onset_window",
+			form: &exportFormData{
+				OutputRegion:        "TEST",
+				Period:              4 * time.Hour,
+				FromDate:            "2021-01-02",
+				FromTime:            "09:23",
+				OnsetWindowMinDays:  "7",
+				OnsetWindowMaxDays:  " 14 ",
+				ExcludeMissingOnset: true,
+			},
+			exp: &model.ExportConfig{
+				Period:              4 * time.Hour,
+				OutputRegion:        "TEST",
+				InputRegions:        []string{},
+				ExcludeRegions:      []string{},
+				From:                from,
+				OnsetWindowMinDays:  intPtr(7),
+				OnsetWindowMaxDays:  intPtr(14),
+				ExcludeMissingOnset: true,
+			},
+		},
+		{
+			name: "revised_keys",
+			form: &exportFormData{
+				OutputRegion:    "TEST",
+				Period:          4 * time.Hour,
+				FromDate:        "2021-01-02",
+				FromTime:        "09:23",
+				OnlyRevisedKeys: true,
+			},
+			exp: &model.ExportConfig{
+				Period:          4 * time.Hour,
+				OutputRegion:    "TEST",
+				InputRegions:    []string{},
+				ExcludeRegions:  []string{},
+				From:            from,
+				OnlyRevisedKeys: true,
+			},
+		},
+		{
+			name: "min_transmission_risk",
+			form: &exportFormData{
+				OutputRegion:                   "TEST",
+				Period:                         4 * time.Hour,
+				FromDate:                       "2021-01-02",
+				FromTime:                       "09:23",
+				MinTransmissionRisk:            4,
+				IncludeUnknownTransmissionRisk: true,
+			},
+			exp: &model.ExportConfig{
+				Period:                         4 * time.Hour,
+				OutputRegion:                   "TEST",
+				InputRegions:                   []string{},
+				ExcludeRegions:                 []string{},
+				From:                           from,
+				MinTransmissionRisk:            4,
+				IncludeUnknownTransmissionRisk: true,
+			},
+		},
+		{
+			name: "default_report_type",
+			form: &exportFormData{
+				OutputRegion:      "TEST",
+				Period:            4 * time.Hour,
+				FromDate:          "2021-01-02",
+				FromTime:          "09:23",
+				DefaultReportType: " likely ",
+			},
+			exp: &model.ExportConfig{
+				Period:            4 * time.Hour,
+				OutputRegion:      "TEST",
+				InputRegions:      []string{},
+				ExcludeRegions:    []string{},
+				From:              from,
+				DefaultReportType: "likely",
+			},
+		},
+		{
+			name: "report_types",
+			form: &exportFormData{
+				OutputRegion:             "TEST",
+				Period:                   4 * time.Hour,
+				FromDate:                 "2021-01-02",
+				FromTime:                 "09:23",
+				ReportTypes:              []string{" confirmed ", ""},
+				IncludeUnknownReportType: true,
+			},
+			exp: &model.ExportConfig{
+				Period:                   4 * time.Hour,
+				OutputRegion:             "TEST",
+				InputRegions:             []string{},
+				ExcludeRegions:           []string{},
+				From:                     from,
+				ReportTypes:              []string{"confirmed"},
+				IncludeUnknownReportType: true,
+			},
+		},
+		{
+			name: "bad_onset_window",
+			form: &exportFormData{
+				OnsetWindowMaxDays: "two weeks",
+			},
+			err: "invalid onset window maximum days",
+		},
 		{
 			name: "bad_from",
 			form: &exportFormData{
@@ -0,0 +1,131 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	publishdb "github.com/google/exposure-notifications-server/internal/publish/database"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/verification"
+)
+
+// maxExposuresByCertificate is the most exposures returned for a certificate.
+const maxExposuresByCertificate = 1000
+
+// certificateHashRe matches the hex encoded SHA-256 digest of a certificate
+// ID, see verification.CertificateHash.
+var certificateHashRe = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// exposuresByCertificateJSON is the response of HandleExposuresByCertificate.
+type exposuresByCertificateJSON struct {
+	CertificateHash string          `json:"certificateHash"`
+	Exposures       []*exposureJSON `json:"exposures"`
+}
+
+// exposureJSON is the metadata of a published exposure. The key itself is
+// never returned.
+type exposureJSON struct {
+	CreatedAt                    time.Time  `json:"createdAt"`
+	HealthAuthorityID            *int64     `json:"healthAuthorityID,omitempty"`
+	AppPackageName               string     `json:"appPackageName,omitempty"`
+	Regions                      []string   `json:"regions"`
+	Traveler                     bool       `json:"traveler"`
+	LocalProvenance              bool       `json:"localProvenance"`
+	IntervalNumber               int32      `json:"intervalNumber"`
+	IntervalCount                int32      `json:"intervalCount"`
+	TransmissionRisk             int        `json:"transmissionRisk"`
+	ReportType                   string     `json:"reportType,omitempty"`
+	DaysSinceSymptomOnset        *int32     `json:"daysSinceSymptomOnset,omitempty"`
+	RevisedAt                    *time.Time `json:"revisedAt,omitempty"`
+	RevisedReportType            *string    `json:"revisedReportType,omitempty"`
+	RevisedTransmissionRisk      *int       `json:"revisedTransmissionRisk,omitempty"`
+	RevisedDaysSinceSymptomOnset *int32     `json:"revisedDaysSinceSymptomOnset,omitempty"`
+}
+
+func newExposureJSON(e *publishmodel.Exposure) *exposureJSON {
+	exp := &exposureJSON{
+		CreatedAt:                    e.CreatedAt.UTC(),
+		HealthAuthorityID:            e.HealthAuthorityID,
+		AppPackageName:               e.AppPackageName,
+		Regions:                      e.Regions,
+		Traveler:                     e.Traveler,
+		LocalProvenance:              e.LocalProvenance,
+		IntervalNumber:               e.IntervalNumber,
+		IntervalCount:                e.IntervalCount,
+		TransmissionRisk:             e.TransmissionRisk,
+		ReportType:                   e.ReportType,
+		DaysSinceSymptomOnset:        e.DaysSinceSymptomOnset,
+		RevisedReportType:            e.RevisedReportType,
+		RevisedTransmissionRisk:      e.RevisedTransmissionRisk,
+		RevisedDaysSinceSymptomOnset: e.RevisedDaysSinceSymptomOnset,
+	}
+	if e.RevisedAt != nil {
+		revisedAt := e.RevisedAt.UTC()
+		exp.RevisedAt = &revisedAt
+	}
+	return exp
+}
+
+// HandleExposuresByCertificate lists the metadata of the exposures that were
+// published with a verification certificate, so support staff can trace a
+// certificate without access to the keys. The certificate is given either by
+// the iss and jti query parameters, or by the certificate-hash query parameter
+// for certificates without a 'jti' claim.
+func (s *Server) HandleExposuresByCertificate() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if !s.authorize(c, ActionView, ResourceExposures, "") {
+			return
+		}
+
+		iss, jti, hash := c.Query("iss"), c.Query("jti"), c.Query("certificate-hash")
+		switch {
+		case hash != "" && (iss != "" || jti != ""):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "certificate-hash cannot be combined with iss and jti"})
+			return
+		case hash != "":
+			if !certificateHashRe.MatchString(hash) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "certificate-hash must be a hex encoded SHA-256 digest"})
+				return
+			}
+		case iss != "" && jti != "":
+			hash = verification.CertificateHash(verification.JTICertificateID(iss, jti))
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "iss and jti, or certificate-hash, are required"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		exposures, err := publishdb.New(s.env.Database()).ListExposuresByCertificateHash(ctx, hash, maxExposuresByCertificate)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to read exposures: %v", err)})
+			return
+		}
+
+		resp := &exposuresByCertificateJSON{
+			CertificateHash: hash,
+			Exposures:       make([]*exposureJSON, 0, len(exposures)),
+		}
+		for _, e := range exposures {
+			resp.Exposures = append(resp.Exposures, newExposureJSON(e))
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/project"
+	publishdb "github.com/google/exposure-notifications-server/internal/publish/database"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/verification"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHandleExposuresByCertificate(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	env, s := newTestServer(t)
+
+	withJTI := verification.CertificateHash(verification.JTICertificateID("doh.mystate.gov", "abc123"))
+	withoutJTI := verification.CertificateHash("sha256:0123")
+
+	createdAt := time.Now().UTC().Add(-time.Hour).Truncate(time.Hour)
+	newExposure := func(certificateHash string, intervalNumber int32, createdAt time.Time) *publishmodel.Exposure {
+		return &publishmodel.Exposure{
+			ExposureKey:     randomKey(t),
+			Regions:         []string{"US"},
+			IntervalNumber:  intervalNumber,
+			IntervalCount:   144,
+			CreatedAt:       createdAt,
+			LocalProvenance: true,
+			ReportType:      verifyapi.ReportTypeConfirmed,
+			CertificateHash: certificateHash,
+		}
+	}
+	older := newExposure(withJTI, 2650000, createdAt.Add(-time.Hour))
+	newer := newExposure(withJTI, 2650144, createdAt)
+	other := newExposure(withoutJTI, 2650288, createdAt)
+	uncertified := newExposure("", 2650432, createdAt)
+
+	if _, err := publishdb.New(env.Database()).InsertAndReviseExposures(ctx, &publishdb.InsertAndReviseExposuresRequest{
+		Incoming: []*publishmodel.Exposure{older, newer, other, uncertified},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		query  url.Values
+		status int
+		want   []int32
+	}{
+		{
+			name:   "iss_jti",
+			query:  url.Values{"iss": {"doh.mystate.gov"}, "jti": {"abc123"}},
+			status: http.StatusOK,
+			want:   []int32{2650144, 2650000},
+		},
+		{
+			name:   "certificate_hash",
+			query:  url.Values{"certificate-hash": {withoutJTI}},
+			status: http.StatusOK,
+			want:   []int32{2650288},
+		},
+		{
+			name:   "unknown",
+			query:  url.Values{"iss": {"doh.mystate.gov"}, "jti": {"abc124"}},
+			status: http.StatusOK,
+			want:   []int32{},
+		},
+		{
+			name:   "missing_jti",
+			query:  url.Values{"iss": {"doh.mystate.gov"}},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "both",
+			query:  url.Values{"iss": {"doh.mystate.gov"}, "jti": {"abc123"}, "certificate-hash": {withJTI}},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "malformed_hash",
+			query:  url.Values{"certificate-hash": {"jti:doh.mystate.gov:abc123"}},
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			server := newHTTPServer(t, http.MethodGet, "/", s.HandleExposuresByCertificate())
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/?"+tc.query.Encode(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := server.Client().Do(req)
+			if err != nil {
+				t.Fatalf("error making http call: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := resp.StatusCode, tc.status; got != want {
+				t.Fatalf("expected status %d to be %d: %s", got, want, body)
+			}
+			if tc.status != http.StatusOK {
+				return
+			}
+
+			// The keys themselves are never returned.
+			for _, e := range []*publishmodel.Exposure{older, newer, other} {
+				if bytes.Contains(body, []byte(base64.StdEncoding.EncodeToString(e.ExposureKey))) {
+					t.Errorf("expected response to not contain exposure key: %s", body)
+				}
+			}
+
+			var result exposuresByCertificateJSON
+			if err := json.Unmarshal(body, &result); err != nil {
+				t.Fatal(err)
+			}
+			got := make([]int32, 0, len(result.Exposures))
+			for _, e := range result.Exposures {
+				got = append(got, e.IntervalNumber)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
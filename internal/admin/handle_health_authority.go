@@ -15,22 +15,31 @@
 package admin
 
 import (
+	"encoding/base64"
 	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/exposure-notifications-server/internal/project"
+	publishdb "github.com/google/exposure-notifications-server/internal/publish/database"
 	"github.com/google/exposure-notifications-server/internal/verification/database"
 	"github.com/google/exposure-notifications-server/internal/verification/model"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
+	"github.com/google/exposure-notifications-server/pkg/base64util"
 )
 
 // HandleHealthAuthoritySave handles the create/update actions for health
 // authorities.
 func (s *Server) HandleHealthAuthoritySave() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		if !s.authorize(c, saveAction(c.Param("id")), ResourceHealthAuthority, c.Param("id")) {
+			return
+		}
+
 		var form healthAuthorityFormData
 		err := c.Bind(&form)
 		if err != nil {
@@ -56,6 +65,12 @@ func (s *Server) HandleHealthAuthoritySave() func(c *gin.Context) {
 			}
 		}
 		form.PopulateHealthAuthority(healthAuthority)
+		if s.config.Verification.RequireHTTPSIssuers {
+			if err := healthAuthority.ValidateHTTPSIssuer(); err != nil {
+				ErrorPage(c, fmt.Sprintf("Error writing health authority: %v", err))
+				return
+			}
+		}
 
 		// Decide if update or insert.
 		updateFn := haDB.AddHealthAuthority
@@ -76,6 +91,10 @@ func (s *Server) HandleHealthAuthoritySave() func(c *gin.Context) {
 // HandleHealthAuthorityShow handles the show action for health authorities.
 func (s *Server) HandleHealthAuthorityShow() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		if !s.authorize(c, ActionView, ResourceHealthAuthority, c.Param("id")) {
+			return
+		}
+
 		ctx := c.Request.Context()
 		m := TemplateMap{}
 
@@ -107,6 +126,14 @@ func (s *Server) HandleHealthAuthorityShow() func(c *gin.Context) {
 // HandleHealthAuthorityKeys handles the keys action for health authorities.
 func (s *Server) HandleHealthAuthorityKeys() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		keyAction := ActionUpdate
+		if c.Param("action") == "create" {
+			keyAction = ActionCreate
+		}
+		if !s.authorize(c, keyAction, ResourceHealthAuthorityKey, c.Param("id")) {
+			return
+		}
+
 		ctx := c.Request.Context()
 
 		haDB := database.New(s.env.Database())
@@ -135,7 +162,7 @@ func (s *Server) HandleHealthAuthorityKeys() func(c *gin.Context) {
 				ErrorPage(c, fmt.Sprintf("Error parsing new health authority key: %v", err))
 				return
 			}
-			err = haDB.AddHealthAuthorityKey(ctx, healthAuthority, &hak)
+			err = haDB.AddHealthAuthorityKeyWithLimit(ctx, healthAuthority, &hak, s.config.MaxActiveKeyVersions)
 			if err != nil {
 				ErrorPage(c, fmt.Sprintf("Error saving health authority key: %v", err))
 				return
@@ -181,12 +208,112 @@ func (s *Server) HandleHealthAuthorityKeys() func(c *gin.Context) {
 	}
 }
 
+// HandleHealthAuthorityRevokeExposures handles revoking previously published
+// exposure keys for a health authority. Revoked keys are excluded from all
+// future exports.
+func (s *Server) HandleHealthAuthorityRevokeExposures() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if !s.authorize(c, ActionDelete, ResourceExposures, c.Param("id")) {
+			return
+		}
+
+		ctx := c.Request.Context()
+		m := TemplateMap{}
+
+		var form revokeExposuresFormData
+		if err := c.Bind(&form); err != nil {
+			ErrorPage(c, err.Error())
+			return
+		}
+
+		haDB := database.New(s.env.Database())
+		haID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			ErrorPage(c, "Unable to parse `id` param")
+			return
+		}
+		healthAuthority, err := haDB.GetHealthAuthorityByID(ctx, haID)
+		if err != nil {
+			ErrorPage(c, fmt.Sprintf("error processing health authority: %v", err))
+			return
+		}
+
+		b64keys, err := form.ExposureKeysBase64()
+		if err != nil {
+			ErrorPage(c, fmt.Sprintf("Error parsing exposure keys: %v", err))
+			return
+		}
+
+		publishDB := publishdb.New(s.env.Database())
+		count, err := publishDB.RevokeExposures(ctx, healthAuthority.ID, b64keys, time.Now().UTC())
+		if err != nil {
+			ErrorPage(c, fmt.Sprintf("Error revoking exposure keys: %v", err))
+			return
+		}
+
+		m.AddSuccess(fmt.Sprintf("Revoked %d of %d exposure keys", count, len(b64keys)))
+		m["ha"] = healthAuthority
+		m["hak"] = &model.HealthAuthorityKey{From: time.Now()} // For create form.
+		c.HTML(http.StatusOK, "healthauthority", m)
+	}
+}
+
+// HandleHealthAuthorityPurgeKeys handles removing the key versions of a health
+// authority that expired more than ExpiredKeyMinAge ago. Keys that are still
+// valid or don't expire are never removed.
+func (s *Server) HandleHealthAuthorityPurgeKeys() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if !s.authorize(c, ActionDelete, ResourceHealthAuthorityKey, c.Param("id")) {
+			return
+		}
+
+		ctx := c.Request.Context()
+		m := TemplateMap{}
+
+		haDB := database.New(s.env.Database())
+		haID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			ErrorPage(c, "Unable to parse `id` param")
+			return
+		}
+		healthAuthority, err := haDB.GetHealthAuthorityByID(ctx, haID)
+		if err != nil {
+			ErrorPage(c, fmt.Sprintf("error processing health authority: %v", err))
+			return
+		}
+
+		purgeBefore := time.Now().UTC().Add(-s.config.ExpiredKeyMinAge)
+		count, err := haDB.PurgeHealthAuthorityKeys(ctx, healthAuthority, purgeBefore)
+		if err != nil {
+			ErrorPage(c, fmt.Sprintf("Error removing expired keys: %v", err))
+			return
+		}
+
+		// Reload the remaining keys.
+		healthAuthority, err = haDB.GetHealthAuthorityByID(ctx, haID)
+		if err != nil {
+			ErrorPage(c, fmt.Sprintf("error processing health authority: %v", err))
+			return
+		}
+
+		m.AddSuccess(fmt.Sprintf("Removed %d expired key versions", count))
+		m["ha"] = healthAuthority
+		m["hak"] = &model.HealthAuthorityKey{From: time.Now()} // For create form.
+		c.HTML(http.StatusOK, "healthauthority", m)
+	}
+}
+
 type healthAuthorityFormData struct {
 	Issuer         string `form:"issuer"`
 	Audience       string `form:"audience"`
 	Name           string `form:"name"`
 	EnableStatsAPI bool   `form:"enable-stats-api"`
+	NoFederate     bool   `form:"no-federate"`
+	StatsAudience  string `form:"stats-audience"`
+	DefaultKID     string `form:"default-stats-kid"`
+	StatsAlgs      string `form:"stats-algorithms"`
 	JwksURI        string `form:"jwks-uri"`
+	TrustAnchors   string `form:"trust-anchors"`
 }
 
 func (f *healthAuthorityFormData) PopulateHealthAuthority(ha *model.HealthAuthority) {
@@ -194,7 +321,12 @@ func (f *healthAuthorityFormData) PopulateHealthAuthority(ha *model.HealthAuthor
 	ha.Audience = f.Audience
 	ha.Name = f.Name
 	ha.EnableStatsAPI = f.EnableStatsAPI
+	ha.NoFederate = f.NoFederate
+	ha.SetStatsAudience(f.StatsAudience)
+	ha.SetDefaultStatsKeyVersion(f.DefaultKID)
+	ha.SetStatsAlgorithms(f.StatsAlgs)
 	ha.SetJWKS(f.JwksURI)
+	ha.TrustAnchorsPEM = project.TrimSpaceAndNonPrintable(f.TrustAnchors)
 }
 
 type keyhealthAuthorityFormData struct {
@@ -204,6 +336,7 @@ type keyhealthAuthorityFormData struct {
 	FromTime string `form:"from-time"`
 	ThruDate string `form:"thru-date"`
 	ThruTime string `form:"thru-time"`
+	Usage    string `form:"usage"`
 }
 
 func (f *keyhealthAuthorityFormData) FromTimestamp() (time.Time, error) {
@@ -227,7 +360,43 @@ func (f *keyhealthAuthorityFormData) PopulateHealthAuthorityKey(hak *model.Healt
 	hak.From = fTime
 	hak.Thru = tTime
 	hak.PublicKeyPEM = strings.ReplaceAll(project.TrimSpaceAndNonPrintable(f.PEMBlock), "\r", "")
+	hak.Usage = model.KeyUsage(project.TrimSpaceAndNonPrintable(f.Usage))
 
-	_, err = hak.PublicKey()
-	return err
+	return hak.Validate()
+}
+
+type revokeExposuresFormData struct {
+	ExposureKeys string `form:"exposure-keys"`
+}
+
+// ExposureKeysBase64 parses the whitespace or comma separated list of base64
+// exposure keys and returns them in the standard base64 encoding used by the
+// database.
+func (f *revokeExposuresFormData) ExposureKeysBase64() ([]string, error) {
+	fields := strings.FieldsFunc(f.ExposureKeys, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no exposure keys provided")
+	}
+
+	seen := make(map[string]struct{}, len(fields))
+	keys := make([]string, 0, len(fields))
+	for _, field := range fields {
+		b, err := base64util.DecodeString(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exposure key %q: %w", field, err)
+		}
+		if l := len(b); l != verifyapi.KeyLength {
+			return nil, fmt.Errorf("invalid exposure key %q: must be %d bytes, got %d", field, verifyapi.KeyLength, l)
+		}
+
+		encoded := base64.StdEncoding.EncodeToString(b)
+		if _, ok := seen[encoded]; ok {
+			continue
+		}
+		seen[encoded] = struct{}{}
+		keys = append(keys, encoded)
+	}
+	return keys, nil
 }
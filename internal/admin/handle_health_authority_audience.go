@@ -0,0 +1,94 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/google/exposure-notifications-server/internal/verification/database"
+	"github.com/google/exposure-notifications-server/internal/verification/model"
+)
+
+// healthAuthoritiesJSON is the response of HandleHealthAuthoritiesByAudience.
+type healthAuthoritiesJSON struct {
+	HealthAuthorities []*healthAuthorityJSON `json:"healthAuthorities"`
+}
+
+type healthAuthorityJSON struct {
+	ID            int64  `json:"id"`
+	Issuer        string `json:"issuer"`
+	Audience      string `json:"audience"`
+	StatsAudience string `json:"statsAudience,omitempty"`
+	Name          string `json:"name"`
+}
+
+func newHealthAuthorityJSON(ha *model.HealthAuthority) *healthAuthorityJSON {
+	resp := &healthAuthorityJSON{
+		ID:       ha.ID,
+		Issuer:   ha.Issuer,
+		Audience: ha.Audience,
+		Name:     ha.Name,
+	}
+	if ha.StatsAudience != nil {
+		resp.StatsAudience = *ha.StatsAudience
+	}
+	return resp
+}
+
+// HandleHealthAuthoritiesByAudience returns the health authorities with the
+// audience or stats audience in the required audience query parameter. If the
+// prefix query parameter is true, health authorities with an audience or stats
+// audience starting with it are returned.
+func (s *Server) HandleHealthAuthoritiesByAudience() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if !s.authorize(c, ActionView, ResourceHealthAuthority, "") {
+			return
+		}
+
+		audience := c.Query("audience")
+		if audience == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "audience is required"})
+			return
+		}
+
+		var prefix bool
+		if v := c.Query("prefix"); v != "" {
+			var err error
+			prefix, err = strconv.ParseBool(v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "prefix must be true or false"})
+				return
+			}
+		}
+
+		has, err := database.New(s.env.Database()).ListHealthAuthoritiesByAudience(c.Request.Context(), audience, prefix)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to read health authorities: %v", err)})
+			return
+		}
+
+		resp := &healthAuthoritiesJSON{
+			HealthAuthorities: make([]*healthAuthorityJSON, 0, len(has)),
+		}
+		for _, ha := range has {
+			resp.HealthAuthorities = append(resp.HealthAuthorities, newHealthAuthorityJSON(ha))
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/exposure-notifications-server/internal/verification/database"
+	"github.com/google/exposure-notifications-server/internal/verification/model"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHandleHealthAuthoritiesByAudience(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	env, s := newTestServer(t)
+	haDB := database.New(env.Database())
+
+	for _, ha := range []*model.HealthAuthority{
+		{Issuer: "a.doh.mystate.gov", Audience: "ens.usacovid.org", Name: "A"},
+		{Issuer: "b.doh.mystate.gov", Audience: "ens.usacovid.org/stats", Name: "B", StatsAudience: stringPtr("stats.usacovid.org")},
+		{Issuer: "c.doh.mystate.gov", Audience: "other.usacovid.org", Name: "C"},
+	} {
+		if err := haDB.AddHealthAuthority(ctx, ha); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name   string
+		query  url.Values
+		status int
+		want   []string
+	}{
+		{
+			name:   "missing_audience",
+			query:  url.Values{},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "bad_prefix",
+			query:  url.Values{"audience": {"ens"}, "prefix": {"banana"}},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "exact",
+			query:  url.Values{"audience": {"ens.usacovid.org"}},
+			status: http.StatusOK,
+			want:   []string{"a.doh.mystate.gov"},
+		},
+		{
+			name:   "prefix",
+			query:  url.Values{"audience": {"ens.usacovid.org"}, "prefix": {"true"}},
+			status: http.StatusOK,
+			want:   []string{"a.doh.mystate.gov", "b.doh.mystate.gov"},
+		},
+		{
+			name:   "no_match",
+			query:  url.Values{"audience": {"usacovid.org"}, "prefix": {"true"}},
+			status: http.StatusOK,
+			want:   []string{},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			server := newHTTPServer(t, http.MethodGet, "/", s.HandleHealthAuthoritiesByAudience())
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/?"+tc.query.Encode(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := server.Client().Do(req)
+			if err != nil {
+				t.Fatalf("error making http call: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if got, want := resp.StatusCode, tc.status; got != want {
+				t.Fatalf("expected status %d to be %d", got, want)
+			}
+			if tc.status != http.StatusOK {
+				return
+			}
+
+			var result healthAuthoritiesJSON
+			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]string, 0, len(result.HealthAuthorities))
+			for _, ha := range result.HealthAuthorities {
+				got = append(got, ha.Issuer)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
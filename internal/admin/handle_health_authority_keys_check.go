@@ -0,0 +1,129 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+
+	"github.com/google/exposure-notifications-server/internal/verification/database"
+	"github.com/google/exposure-notifications-server/internal/verification/model"
+)
+
+// healthAuthorityKeysCheckJSON is the response of
+// HandleHealthAuthorityKeysCheck.
+type healthAuthorityKeysCheckJSON struct {
+	HealthAuthorities []*healthAuthorityKeysJSON `json:"healthAuthorities"`
+	// Invalid is the number of keys, over all health authorities, that don't
+	// parse.
+	Invalid int `json:"invalid"`
+}
+
+type healthAuthorityKeysJSON struct {
+	ID     int64                          `json:"id"`
+	Issuer string                         `json:"issuer"`
+	Keys   []*healthAuthorityKeyCheckJSON `json:"keys"`
+}
+
+// healthAuthorityKeyCheckJSON is the result for one key version. Error is why
+// the key doesn't parse, if it isn't valid.
+type healthAuthorityKeyCheckJSON struct {
+	Version     string `json:"version"`
+	Valid       bool   `json:"valid"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// checkHealthAuthorityKeys parses the public key of each key version of the
+// health authority. The keys are only read, live verification isn't affected.
+func checkHealthAuthorityKeys(ha *model.HealthAuthority) (*healthAuthorityKeysJSON, int) {
+	resp := &healthAuthorityKeysJSON{
+		ID:     ha.ID,
+		Issuer: ha.Issuer,
+		Keys:   make([]*healthAuthorityKeyCheckJSON, 0, len(ha.Keys)),
+	}
+
+	invalid := 0
+	for _, k := range ha.Keys {
+		result := &healthAuthorityKeyCheckJSON{Version: k.Version}
+		if _, err := k.PublicKey(); err != nil {
+			result.Error = err.Error()
+			invalid++
+		} else {
+			result.Valid = true
+			// The key parsed, so the fingerprint can't fail.
+			result.Fingerprint, _ = k.Fingerprint()
+		}
+		resp.Keys = append(resp.Keys, result)
+	}
+	return resp, invalid
+}
+
+// HandleHealthAuthorityKeysCheck reports which key versions don't parse into
+// a usable public key, e.g. after onboarding health authorities in bulk. If
+// the id query parameter is set, only that health authority is checked,
+// otherwise all of them are.
+func (s *Server) HandleHealthAuthorityKeysCheck() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if !s.authorize(c, ActionView, ResourceHealthAuthority, "") {
+			return
+		}
+
+		ctx := c.Request.Context()
+		haDB := database.New(s.env.Database())
+
+		var has []*model.HealthAuthority
+		if v := c.Query("id"); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "id must be an integer"})
+				return
+			}
+
+			ha, err := haDB.GetHealthAuthorityByID(ctx, id)
+			if err != nil {
+				if errors.Is(err, pgx.ErrNoRows) {
+					c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("health authority %d not found", id)})
+					return
+				}
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to read health authority: %v", err)})
+				return
+			}
+			has = append(has, ha)
+		} else {
+			var err error
+			has, err = haDB.ListAllHealthAuthoritiesWithKeys(ctx)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to read health authorities: %v", err)})
+				return
+			}
+		}
+
+		resp := &healthAuthorityKeysCheckJSON{
+			HealthAuthorities: make([]*healthAuthorityKeysJSON, 0, len(has)),
+		}
+		for _, ha := range has {
+			result, invalid := checkHealthAuthorityKeys(ha)
+			resp.HealthAuthorities = append(resp.HealthAuthorities, result)
+			resp.Invalid += invalid
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/exposure-notifications-server/internal/verification/database"
+	"github.com/google/exposure-notifications-server/internal/verification/model"
+	"github.com/google/exposure-notifications-server/internal/verification/verifytest"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestCheckHealthAuthorityKeys(t *testing.T) {
+	t.Parallel()
+
+	valid := verifytest.NewHealthAuthority(t, "valid.test.health").Key
+	ha := &model.HealthAuthority{
+		ID:     4,
+		Issuer: "keys.test.health",
+		Keys: []*model.HealthAuthorityKey{
+			{Version: "v1", PublicKeyPEM: valid.PublicKeyPEM},
+			{Version: "v2", PublicKeyPEM: "not a PEM block"},
+			{Version: "v3", PublicKeyPEM: ""},
+		},
+	}
+	fingerprint, err := valid.Fingerprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, invalid := checkHealthAuthorityKeys(ha)
+	want := &healthAuthorityKeysJSON{
+		ID:     4,
+		Issuer: "keys.test.health",
+		Keys: []*healthAuthorityKeyCheckJSON{
+			{Version: "v1", Valid: true, Fingerprint: fingerprint},
+			{Version: "v2", Error: "unable to decode PEM block containing PUBLIC KEY"},
+			{Version: "v3", Error: "unable to decode PEM block containing PUBLIC KEY"},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+	if invalid != 2 {
+		t.Errorf("expected 2 invalid keys, got %d", invalid)
+	}
+}
+
+func TestHandleHealthAuthorityKeysCheck(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	env, s := newTestServer(t)
+	haDB := database.New(env.Database())
+
+	clean := verifytest.NewHealthAuthority(t, "clean.test.health")
+	legacy := verifytest.NewHealthAuthority(t, "legacy.test.health")
+	for _, h := range []*verifytest.HealthAuthority{clean, legacy} {
+		ha := &model.HealthAuthority{Issuer: h.HealthAuthority.Issuer, Audience: "aud", Name: h.HealthAuthority.Issuer}
+		if err := haDB.AddHealthAuthority(ctx, ha); err != nil {
+			t.Fatal(err)
+		}
+		h.HealthAuthority.ID = ha.ID
+		key := &model.HealthAuthorityKey{Version: "v1", From: time.Now().Add(-time.Hour), PublicKeyPEM: h.Key.PublicKeyPEM}
+		if err := haDB.AddHealthAuthorityKey(ctx, ha, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Malformed keys can't be added by the admin console anymore, they are
+	// written directly like legacy data.
+	for _, version := range []string{"v2", "v3"} {
+		if _, err := env.Database().Pool.Exec(ctx, `
+			INSERT INTO HealthAuthorityKey
+				(health_authority_id, version, from_timestamp, public_key)
+			VALUES
+				($1, $2, $3, $4)
+			`, legacy.HealthAuthority.ID, version, time.Now(), "-----BEGIN PUBLIC KEY-----\nbanana\n-----END PUBLIC KEY-----"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name        string
+		query       url.Values
+		status      int
+		wantInvalid map[string][]string
+		wantCount   int
+	}{
+		{
+			name:        "all",
+			query:       url.Values{},
+			status:      http.StatusOK,
+			wantInvalid: map[string][]string{"clean.test.health": {}, "legacy.test.health": {"v2", "v3"}},
+			wantCount:   2,
+		},
+		{
+			name:        "clean",
+			query:       url.Values{"id": {strconv.FormatInt(clean.HealthAuthority.ID, 10)}},
+			status:      http.StatusOK,
+			wantInvalid: map[string][]string{"clean.test.health": {}},
+		},
+		{
+			name:        "legacy",
+			query:       url.Values{"id": {strconv.FormatInt(legacy.HealthAuthority.ID, 10)}},
+			status:      http.StatusOK,
+			wantInvalid: map[string][]string{"legacy.test.health": {"v2", "v3"}},
+			wantCount:   2,
+		},
+		{
+			name:   "bad_id",
+			query:  url.Values{"id": {"banana"}},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "not_found",
+			query:  url.Values{"id": {"987654"}},
+			status: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			server := newHTTPServer(t, http.MethodGet, "/", s.HandleHealthAuthorityKeysCheck())
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/?"+tc.query.Encode(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := server.Client().Do(req)
+			if err != nil {
+				t.Fatalf("error making http call: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if got, want := resp.StatusCode, tc.status; got != want {
+				t.Fatalf("expected status %d to be %d", got, want)
+			}
+			if tc.status != http.StatusOK {
+				return
+			}
+
+			var result healthAuthorityKeysCheckJSON
+			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+
+			got := make(map[string][]string)
+			for _, ha := range result.HealthAuthorities {
+				invalid := []string{}
+				for _, k := range ha.Keys {
+					if !k.Valid {
+						if k.Error == "" {
+							t.Errorf("expected an error for invalid key %s/%s", ha.Issuer, k.Version)
+						}
+						invalid = append(invalid, k.Version)
+					}
+				}
+				got[ha.Issuer] = invalid
+			}
+			if diff := cmp.Diff(tc.wantInvalid, got, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+			if got, want := result.Invalid, tc.wantCount; got != want {
+				t.Errorf("expected %d invalid keys, got %d", want, got)
+			}
+		})
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -67,6 +68,81 @@ func TestPopulateHealthAuthority(t *testing.T) {
 				JwksURI:        stringPtr("https://foo.bar"),
 			},
 		},
+		{
+			name: "stats_audience",
+			form: &healthAuthorityFormData{
+				Issuer:        "test-iss",
+				Audience:      "test-aud",
+				Name:          "test-ha",
+				StatsAudience: " test-stats-aud ",
+			},
+			exp: &model.HealthAuthority{
+				Issuer:        "test-iss",
+				Audience:      "test-aud",
+				Name:          "test-ha",
+				StatsAudience: stringPtr("test-stats-aud"),
+			},
+		},
+		{
+			name: "default_stats_kid",
+			form: &healthAuthorityFormData{
+				Issuer:     "test-iss",
+				Audience:   "test-aud",
+				Name:       "test-ha",
+				DefaultKID: " v1 ",
+			},
+			exp: &model.HealthAuthority{
+				Issuer:                 "test-iss",
+				Audience:               "test-aud",
+				Name:                   "test-ha",
+				DefaultStatsKeyVersion: stringPtr("v1"),
+			},
+		},
+		{
+			name: "stats_algorithms",
+			form: &healthAuthorityFormData{
+				Issuer:    "test-iss",
+				Audience:  "test-aud",
+				Name:      "test-ha",
+				StatsAlgs: "es256, ES384",
+			},
+			exp: &model.HealthAuthority{
+				Issuer:          "test-iss",
+				Audience:        "test-aud",
+				Name:            "test-ha",
+				StatsAlgorithms: []string{"ES256", "ES384"},
+			},
+		},
+		{
+			name: "no_federate",
+			form: &healthAuthorityFormData{
+				Issuer:     "test-iss",
+				Audience:   "test-aud",
+				Name:       "test-ha",
+				NoFederate: true,
+			},
+			exp: &model.HealthAuthority{
+				Issuer:     "test-iss",
+				Audience:   "test-aud",
+				Name:       "test-ha",
+				NoFederate: true,
+			},
+		},
+		{
+			name: "trust_anchors",
+			form: &healthAuthorityFormData{
+				Issuer:       "test-iss",
+				Audience:     "test-aud",
+				Name:         "test-ha",
+				TrustAnchors: "  -----BEGIN CERTIFICATE-----\n-----END CERTIFICATE-----\n",
+			},
+			exp: &model.HealthAuthority{
+				Issuer:          "test-iss",
+				Audience:        "test-aud",
+				Name:            "test-ha",
+				TrustAnchorsPEM: "-----BEGIN CERTIFICATE-----\n-----END CERTIFICATE-----",
+			},
+		},
 	}
 
 	for _, tc := range cases {
@@ -123,6 +199,39 @@ YHc1cKvIIi6/H56AJS/kZEYQnfDpxrgyGhdAm+pNN2GAJ3XdnQZ1Sk4amg==
 -----END PUBLIC KEY-----`,
 			},
 		},
+		{
+			name: "stats_usage",
+			form: &keyhealthAuthorityFormData{
+				Version: "123",
+				PEMBlock: `-----BEGIN PUBLIC KEY-----
+MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEml59itec9qzwVojreLXdPNRsUWzf
+YHc1cKvIIi6/H56AJS/kZEYQnfDpxrgyGhdAm+pNN2GAJ3XdnQZ1Sk4amg==
+-----END PUBLIC KEY-----`,
+				FromDate: "2021-01-02",
+				FromTime: "09:23",
+				Usage:    "stats",
+			},
+			exp: &model.HealthAuthorityKey{
+				Version: "123",
+				From:    from,
+				PublicKeyPEM: `-----BEGIN PUBLIC KEY-----
+MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEml59itec9qzwVojreLXdPNRsUWzf
+YHc1cKvIIi6/H56AJS/kZEYQnfDpxrgyGhdAm+pNN2GAJ3XdnQZ1Sk4amg==
+-----END PUBLIC KEY-----`,
+				Usage: model.KeyUsageStats,
+			},
+		},
+		{
+			name: "bad_usage",
+			form: &keyhealthAuthorityFormData{
+				PEMBlock: `-----BEGIN PUBLIC KEY-----
+MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEml59itec9qzwVojreLXdPNRsUWzf
+YHc1cKvIIi6/H56AJS/kZEYQnfDpxrgyGhdAm+pNN2GAJ3XdnQZ1Sk4amg==
+-----END PUBLIC KEY-----`,
+				Usage: "banana",
+			},
+			err: "invalid key usage",
+		},
 		{
 			name: "bad_from",
 			form: &keyhealthAuthorityFormData{
@@ -381,6 +490,75 @@ func TestHandleHealthAuthoritySave(t *testing.T) {
 	}
 }
 
+func TestHandleHealthAuthoritySave_RequireHTTPSIssuers(t *testing.T) {
+	t.Parallel()
+	ctx := project.TestContext(t)
+
+	_, s := newTestServer(t)
+	s.config.Verification.RequireHTTPSIssuers = true
+
+	cases := []struct {
+		name   string
+		issuer string
+		status int
+		want   []string
+	}{
+		{
+			name:   "https",
+			issuer: "https://doh.mystate.gov",
+			status: http.StatusSeeOther,
+		},
+		{
+			name:   "http",
+			issuer: "http://doh.otherstate.gov",
+			status: http.StatusInternalServerError,
+			want:   []string{"issuer is not an https:// URL"},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			server := newHTTPServer(t, http.MethodPost, "/:id", s.HandleHealthAuthoritySave())
+
+			form, err := serializeForm(&healthAuthorityFormData{
+				Issuer:   tc.issuer,
+				Audience: "test-aud",
+				Name:     "test-ha",
+			})
+			if err != nil {
+				t.Fatalf("unable to serialize form: %v", err)
+			}
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL+"/0", strings.NewReader(form.Encode()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+			client := server.Client()
+			client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			}
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("error making http call: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if got, want := resp.StatusCode, tc.status; got != want {
+				t.Errorf("expected status %d to be %d", got, want)
+			}
+			if len(tc.want) > 0 {
+				mustFindStrings(t, resp, tc.want...)
+			}
+		})
+	}
+}
+
 func TestHandleHealthAuthorityKeys(t *testing.T) {
 	t.Parallel()
 
@@ -578,3 +756,235 @@ YHc1cKvIIi6/H56AJS/kZEYQnfDpxrgyGhdAm+pNN2GAJ3XdnQZ1Sk4amg==
 		})
 	}
 }
+
+func TestRevokeExposuresFormData(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		form *revokeExposuresFormData
+		exp  []string
+		err  string
+	}{
+		{
+			name: "default",
+			form: &revokeExposuresFormData{
+				ExposureKeys: "AAAAAAAAAAAAAAAAAAAAAA==,\r\nAQEBAQEBAQEBAQEBAQEBAQ== AAAAAAAAAAAAAAAAAAAAAA",
+			},
+			exp: []string{"AAAAAAAAAAAAAAAAAAAAAA==", "AQEBAQEBAQEBAQEBAQEBAQ=="},
+		},
+		{
+			name: "empty",
+			form: &revokeExposuresFormData{
+				ExposureKeys: " \n ",
+			},
+			err: "no exposure keys provided",
+		},
+		{
+			name: "not_base64",
+			form: &revokeExposuresFormData{
+				ExposureKeys: "banana!",
+			},
+			err: "invalid exposure key",
+		},
+		{
+			name: "wrong_length",
+			form: &revokeExposuresFormData{
+				ExposureKeys: "AAAA",
+			},
+			err: "must be 16 bytes",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := tc.form.ExposureKeysBase64()
+			if err != nil {
+				if tc.err == "" {
+					t.Fatal(err)
+				}
+				if got, want := err.Error(), tc.err; !strings.Contains(got, want) {
+					t.Errorf("expected %q to contain %q", got, want)
+				}
+				return
+			}
+			if tc.err != "" {
+				t.Fatalf("expected error %q", tc.err)
+			}
+
+			if diff := cmp.Diff(tc.exp, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestHandleHealthAuthorityRevokeExposures(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	env, s := newTestServer(t)
+	db := env.Database()
+	verificationDB := database.New(db)
+
+	healthAuthority := &model.HealthAuthority{
+		Issuer:   "test-iss",
+		Audience: "test-aud",
+		Name:     "TEST",
+		Keys:     []*model.HealthAuthorityKey{},
+	}
+	if err := verificationDB.AddHealthAuthority(ctx, healthAuthority); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		id     string
+		form   *revokeExposuresFormData
+		status int
+		want   []string
+	}{
+		{
+			name:   "invalid_id",
+			id:     "banana",
+			form:   &revokeExposuresFormData{},
+			status: 500,
+			want:   []string{"Unable to parse `id` param"},
+		},
+		{
+			name:   "not_existing",
+			id:     "123456",
+			form:   &revokeExposuresFormData{},
+			status: 500,
+			want:   []string{"error processing health authority"},
+		},
+		{
+			name: "invalid_keys",
+			id:   fmt.Sprintf("%d", healthAuthority.ID),
+			form: &revokeExposuresFormData{
+				ExposureKeys: "AAAA",
+			},
+			status: 500,
+			want:   []string{"Error parsing exposure keys"},
+		},
+		{
+			name: "revoke",
+			id:   fmt.Sprintf("%d", healthAuthority.ID),
+			form: &revokeExposuresFormData{
+				ExposureKeys: "AAAAAAAAAAAAAAAAAAAAAA==",
+			},
+			status: 200,
+			want:   []string{"Revoked 0 of 1 exposure keys"},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			server := newHTTPServer(t, http.MethodPost, "/:id", s.HandleHealthAuthorityRevokeExposures())
+
+			// URL values
+			form, err := serializeForm(tc.form)
+			if err != nil {
+				t.Fatalf("unable to serialize form: %v", err)
+			}
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/%s", server.URL, tc.id), strings.NewReader(form.Encode()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+			client := server.Client()
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("error making http call: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if got, want := resp.StatusCode, tc.status; got != want {
+				b, err := io.ReadAll(resp.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Errorf("expected status %d to be %d; headers: %#v; body: %s", got, want, resp.Header, b)
+			}
+
+			mustFindStrings(t, resp, tc.want...)
+		})
+	}
+}
+
+func TestHandleHealthAuthorityPurgeKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	env, s := newTestServer(t)
+	s.config.ExpiredKeyMinAge = 30 * 24 * time.Hour
+	verificationDB := database.New(env.Database())
+
+	healthAuthority := &model.HealthAuthority{
+		Issuer:   "test-iss",
+		Audience: "test-aud",
+		Name:     "TEST",
+	}
+	if err := verificationDB.AddHealthAuthority(ctx, healthAuthority); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	from := now.Add(-90 * 24 * time.Hour)
+	keys := []*model.HealthAuthorityKey{
+		{Version: "valid", From: from},
+		{Version: "expired-long-ago", From: from, Thru: now.Add(-60 * 24 * time.Hour)},
+		{Version: "expired-recently", From: from, Thru: now.Add(-24 * time.Hour)},
+	}
+	for _, key := range keys {
+		key.PublicKeyPEM = `-----BEGIN PUBLIC KEY-----
+MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEml59itec9qzwVojreLXdPNRsUWzf
+YHc1cKvIIi6/H56AJS/kZEYQnfDpxrgyGhdAm+pNN2GAJ3XdnQZ1Sk4amg==
+-----END PUBLIC KEY-----`
+		if err := verificationDB.AddHealthAuthorityKey(ctx, healthAuthority, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := newHTTPServer(t, http.MethodPost, "/:id", s.HandleHealthAuthorityPurgeKeys())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/%d", server.URL, healthAuthority.ID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("error making http call: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("expected status %d to be %d", got, want)
+	}
+	mustFindStrings(t, resp, "Removed 1 expired key versions")
+
+	got, err := verificationDB.GetHealthAuthorityKeys(ctx, healthAuthority)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := make([]string, 0, len(got))
+	for _, key := range got {
+		versions = append(versions, key.Version)
+	}
+	sort.Strings(versions)
+	if diff := cmp.Diff([]string{"expired-recently", "valid"}, versions); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
@@ -41,7 +41,7 @@ func (s *Server) HandleIndex() func(c *gin.Context) {
 		m["apps"] = apps
 
 		// Load health authorities.
-		has, err := hadb.New(db).ListAllHealthAuthoritiesWithoutKeys(ctx)
+		has, err := hadb.New(db).ListAllHealthAuthoritiesWithKeys(ctx)
 		if err != nil {
 			ErrorPage(c, err.Error())
 			return
@@ -33,6 +33,14 @@ func (s *Server) HandleMirrorsSave() func(c *gin.Context) {
 			return
 		}
 
+		action := saveAction(c.Param("id"))
+		if form.Action == "delete" {
+			action = ActionDelete
+		}
+		if !s.authorize(c, action, ResourceMirror, c.Param("id")) {
+			return
+		}
+
 		ctx := c.Request.Context()
 		m := TemplateMap{}
 
@@ -83,6 +91,10 @@ func (s *Server) HandleMirrorsSave() func(c *gin.Context) {
 // HandleMirrorsShow handles the show action for mirrors.
 func (s *Server) HandleMirrorsShow() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		if !s.authorize(c, ActionView, ResourceMirror, c.Param("id")) {
+			return
+		}
+
 		ctx := c.Request.Context()
 		m := TemplateMap{}
 
@@ -0,0 +1,175 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	publishdb "github.com/google/exposure-notifications-server/internal/publish/database"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/pkg/database"
+)
+
+const (
+	defaultQuarantineLimit = 100
+	maxQuarantineLimit     = 1000
+)
+
+// quarantinesJSON is the response of HandleQuarantineList.
+type quarantinesJSON struct {
+	Quarantines []*quarantineJSON `json:"quarantines"`
+}
+
+// quarantineJSON is a publish request held for review. The keys themselves
+// are never returned.
+type quarantineJSON struct {
+	ID                int64      `json:"id"`
+	AppPackageName    string     `json:"appPackageName"`
+	HealthAuthorityID *int64     `json:"healthAuthorityID,omitempty"`
+	Regions           []string   `json:"regions"`
+	Reason            string     `json:"reason"`
+	Keys              int        `json:"keys"`
+	Status            string     `json:"status"`
+	CreatedAt         time.Time  `json:"createdAt"`
+	ReviewedAt        *time.Time `json:"reviewedAt,omitempty"`
+}
+
+func newQuarantineJSON(q *publishmodel.Quarantine) *quarantineJSON {
+	result := &quarantineJSON{
+		ID:                q.ID,
+		AppPackageName:    q.AppPackageName,
+		HealthAuthorityID: q.HealthAuthorityID,
+		Regions:           q.Regions,
+		Reason:            q.Reason,
+		Keys:              q.NumKeys,
+		Status:            string(q.Status),
+		CreatedAt:         q.CreatedAt.UTC(),
+	}
+	if q.ReviewedAt != nil {
+		reviewedAt := q.ReviewedAt.UTC()
+		result.ReviewedAt = &reviewedAt
+	}
+	return result
+}
+
+// HandleQuarantineList returns the quarantined publish requests, oldest first.
+// The optional status query parameter selects pending (the default), approved
+// or rejected requests, and limit sets how many are returned.
+func (s *Server) HandleQuarantineList() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if !s.authorize(c, ActionView, ResourceQuarantine, "") {
+			return
+		}
+
+		status := publishmodel.QuarantinePending
+		if v := c.Query("status"); v != "" {
+			status = publishmodel.QuarantineStatus(strings.ToUpper(v))
+			switch status {
+			case publishmodel.QuarantinePending, publishmodel.QuarantineApproved, publishmodel.QuarantineRejected:
+			default:
+				c.JSON(http.StatusBadRequest, gin.H{"error": "status must be one of pending, approved or rejected"})
+				return
+			}
+		}
+
+		limit := defaultQuarantineLimit
+		if v := c.Query("limit"); v != "" {
+			var err error
+			limit, err = strconv.Atoi(v)
+			if err != nil || limit <= 0 || limit > maxQuarantineLimit {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxQuarantineLimit)})
+				return
+			}
+		}
+
+		quarantines, err := publishdb.New(s.env.Database()).ListQuarantines(c.Request.Context(), status, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to read quarantines: %v", err)})
+			return
+		}
+
+		resp := &quarantinesJSON{
+			Quarantines: make([]*quarantineJSON, 0, len(quarantines)),
+		}
+		for _, q := range quarantines {
+			resp.Quarantines = append(resp.Quarantines, newQuarantineJSON(q))
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// HandleQuarantineReview approves or rejects a quarantined publish request,
+// depending on the action param. Approved keys are saved with the approval
+// time as their creation time, truncated to CreatedAtTruncateWindow, so that
+// they are included in the next exports. Rejected keys are deleted.
+func (s *Server) HandleQuarantineReview() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		action := c.Param("action")
+		if !s.authorize(c, ActionUpdate, ResourceQuarantine, id) {
+			return
+		}
+
+		quarantineID, err := strconv.ParseInt(id, 10, 64)
+		if err != nil || quarantineID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "id must be a positive integer"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		db := publishdb.New(s.env.Database())
+		now := time.Now().UTC()
+
+		var approved int64
+		switch action {
+		case "approve":
+			createdAt := publishmodel.TruncateWindow(now, s.config.CreatedAtTruncateWindow)
+			approved, err = db.ApproveQuarantine(ctx, quarantineID, createdAt, now)
+		case "reject":
+			err = db.RejectQuarantine(ctx, quarantineID, now)
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "action must be approve or reject"})
+			return
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, database.ErrNotFound):
+				c.JSON(http.StatusNotFound, gin.H{"error": "quarantine not found"})
+			case errors.Is(err, publishdb.ErrQuarantineReviewed):
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to %s quarantine: %v", action, err)})
+			}
+			return
+		}
+
+		q, err := db.GetQuarantine(ctx, quarantineID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to read quarantine: %v", err)})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"quarantine":   newQuarantineJSON(q),
+			"approvedKeys": approved,
+		})
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/project"
+	publishdb "github.com/google/exposure-notifications-server/internal/publish/database"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
+)
+
+func TestHandleQuarantine(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	env, s := newTestServer(t)
+	publishDB := publishdb.New(env.Database())
+
+	quarantine := func(t *testing.T) *publishmodel.Quarantine {
+		t.Helper()
+
+		q := &publishmodel.Quarantine{
+			AppPackageName: "gov.state.health",
+			Regions:        []string{"US"},
+			Reason:         "test",
+			CreatedAt:      time.Now().UTC().Add(-time.Hour).Truncate(time.Hour),
+		}
+		exposure := &publishmodel.Exposure{
+			ExposureKey:     randomKey(t),
+			AppPackageName:  "gov.state.health",
+			Regions:         []string{"US"},
+			IntervalNumber:  2650000,
+			IntervalCount:   144,
+			LocalProvenance: true,
+			ReportType:      verifyapi.ReportTypeConfirmed,
+		}
+		if _, err := publishDB.InsertAndReviseExposures(ctx, &publishdb.InsertAndReviseExposuresRequest{
+			Incoming:   []*publishmodel.Exposure{exposure},
+			Quarantine: q,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return q
+	}
+	approved := quarantine(t)
+	rejected := quarantine(t)
+
+	review := func(t *testing.T, id int64, action string) (int, []byte) {
+		t.Helper()
+
+		server := newHTTPServer(t, http.MethodPost, "/quarantine/:id/:action", s.HandleQuarantineReview())
+		url := fmt.Sprintf("%s/quarantine/%d/%s", server.URL, id, action)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := server.Client().Do(req)
+		if err != nil {
+			t.Fatalf("error making http call: %v", err)
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, body
+	}
+
+	if code, body := review(t, approved.ID, "approve"); code != http.StatusOK {
+		t.Fatalf("expected approval to succeed, got %d: %s", code, body)
+	}
+	if code, body := review(t, rejected.ID, "reject"); code != http.StatusOK {
+		t.Fatalf("expected rejection to succeed, got %d: %s", code, body)
+	}
+
+	cases := []struct {
+		name   string
+		id     int64
+		action string
+		status int
+	}{
+		{"already_reviewed", approved.ID, "reject", http.StatusConflict},
+		{"not_found", rejected.ID + 100, "approve", http.StatusNotFound},
+		{"unknown_action", rejected.ID, "banana", http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		if code, body := review(t, tc.id, tc.action); code != tc.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tc.name, tc.status, code, body)
+		}
+	}
+
+	// Only the approved key was saved.
+	var count int
+	if _, err := publishDB.IterateExposures(ctx, publishdb.IterateExposuresCriteria{
+		IncludeRegions: []string{"US"},
+		UntilTimestamp: time.Now().Add(time.Hour),
+	}, func(*publishmodel.Exposure) error {
+		count++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := count, 1; got != want {
+		t.Errorf("expected %d exportable keys, got %d", want, got)
+	}
+
+	// Approved publishes are listed by status.
+	server := newHTTPServer(t, http.MethodGet, "/quarantine.json", s.HandleQuarantineList())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/quarantine.json?status=approved", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("error making http call: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var list quarantinesJSON
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Quarantines) != 1 || list.Quarantines[0].ID != approved.ID || list.Quarantines[0].ReviewedAt == nil {
+		t.Errorf("expected the approved quarantine, got %#v", list.Quarantines)
+	}
+}
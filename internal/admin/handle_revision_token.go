@@ -0,0 +1,177 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	publishdb "github.com/google/exposure-notifications-server/internal/publish/database"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
+	"github.com/google/exposure-notifications-server/pkg/base64util"
+)
+
+// revisionTargetReportTypes are the report types a key may be revised to, in
+// the order they are listed in a preview.
+var revisionTargetReportTypes = []string{
+	verifyapi.ReportTypeConfirmed,
+	verifyapi.ReportTypeClinical,
+	verifyapi.ReportTypeNegative,
+}
+
+// revisionTokenPreviewRequest is the body of a revision token preview request.
+type revisionTokenPreviewRequest struct {
+	// RevisionToken is the base64 encoded token, as given to the client.
+	RevisionToken string `json:"revisionToken"`
+}
+
+// revisionTokenPreview is what a revision token authorizes.
+type revisionTokenPreview struct {
+	Error string                 `json:"error,omitempty"`
+	Keys  []*revisableKeyPreview `json:"keys,omitempty"`
+}
+
+// revisableKeyPreview is a key in a revision token, and the report types it
+// may currently be revised to. The key itself is never included, only its
+// hash.
+type revisableKeyPreview struct {
+	// KeyHash is the base64 encoded SHA-256 hash of the key.
+	KeyHash        string `json:"keyHash"`
+	IntervalNumber int32  `json:"intervalNumber"`
+	IntervalCount  int32  `json:"intervalCount"`
+
+	// Published is false if the key is not in the database, for example
+	// because it expired, in which case it can't be revised.
+	Published         bool   `json:"published"`
+	ReportType        string `json:"reportType,omitempty"`
+	RevisedReportType string `json:"revisedReportType,omitempty"`
+
+	// AllowedTransitions are the report types the key may be revised to. It is
+	// empty if the key was already revised or was not published locally.
+	AllowedTransitions []string `json:"allowedTransitions"`
+}
+
+// HandleRevisionTokenPreview decrypts a revision token and returns the keys it
+// allows revising, identified by their hashes, and the report type transitions
+// that are currently allowed for each of them. This is the same check the
+// publish API makes, so support staff can see what a client will be able to
+// revise before it does.
+func (s *Server) HandleRevisionTokenPreview() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if !s.authorize(c, ActionView, ResourceRevisionToken, "") {
+			return
+		}
+
+		if s.tokenManager == nil {
+			c.JSON(http.StatusNotFound, &revisionTokenPreview{Error: "revision token preview is not configured"})
+			return
+		}
+
+		var req revisionTokenPreviewRequest
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, &revisionTokenPreview{Error: fmt.Sprintf("failed to parse request: %v", err)})
+			return
+		}
+		if req.RevisionToken == "" {
+			c.JSON(http.StatusBadRequest, &revisionTokenPreview{Error: "revisionToken is required"})
+			return
+		}
+
+		preview, status, err := s.previewRevisionToken(c.Request.Context(), req.RevisionToken)
+		if err != nil {
+			if status == http.StatusInternalServerError {
+				log.Printf("failed to preview revision token: %v", err)
+			}
+			c.JSON(status, &revisionTokenPreview{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, preview)
+	}
+}
+
+// previewRevisionToken decrypts the token and looks up the current state of its
+// keys. On error, it also returns the status to respond with.
+func (s *Server) previewRevisionToken(ctx context.Context, token string) (*revisionTokenPreview, int, error) {
+	tokenBytes, err := base64util.DecodeString(token)
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("revision token is not valid base64: %w", err)
+	}
+
+	tokenData, err := s.tokenManager.UnmarshalRevisionToken(ctx, tokenBytes, s.config.RevisionToken.AAD)
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("failed to decrypt revision token: %w", err)
+	}
+
+	b64keys := make([]string, 0, len(tokenData.RevisableKeys))
+	for _, rk := range tokenData.RevisableKeys {
+		b64keys = append(b64keys, base64.StdEncoding.EncodeToString(rk.TemporaryExposureKey))
+	}
+	exposures, err := publishdb.New(s.env.Database()).LookupExposures(ctx, b64keys)
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to look up keys: %w", err)
+	}
+
+	preview := &revisionTokenPreview{
+		Keys: make([]*revisableKeyPreview, 0, len(tokenData.RevisableKeys)),
+	}
+	for i, rk := range tokenData.RevisableKeys {
+		hash := sha256.Sum256(rk.TemporaryExposureKey)
+		key := &revisableKeyPreview{
+			KeyHash:            base64.StdEncoding.EncodeToString(hash[:]),
+			IntervalNumber:     rk.IntervalNumber,
+			IntervalCount:      rk.IntervalCount,
+			AllowedTransitions: []string{},
+		}
+
+		if exp, ok := exposures[b64keys[i]]; ok {
+			key.Published = true
+			key.ReportType = exp.ReportType
+			if exp.RevisedReportType != nil {
+				key.RevisedReportType = *exp.RevisedReportType
+			}
+			key.AllowedTransitions = allowedRevisions(exp)
+		}
+		preview.Keys = append(preview.Keys, key)
+	}
+	return preview, http.StatusOK, nil
+}
+
+// allowedRevisions returns the report types the published key may be revised
+// to by a client. Like in Revise, a key can only be revised once, keys that
+// were not published locally can't be revised through the publish API, and an
+// empty report type is treated as clinical.
+func allowedRevisions(exp *publishmodel.Exposure) []string {
+	allowed := []string{}
+	if exp.RevisedAt != nil || !exp.LocalProvenance {
+		return allowed
+	}
+
+	from := exp.ReportType
+	if from == "" {
+		from = verifyapi.ReportTypeClinical
+	}
+	for _, to := range revisionTargetReportTypes {
+		if publishmodel.ValidReportTypeTransition(from, to) {
+			allowed = append(allowed, to)
+		}
+	}
+	return allowed
+}
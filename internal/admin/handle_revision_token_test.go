@@ -0,0 +1,241 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/project"
+	publishdb "github.com/google/exposure-notifications-server/internal/publish/database"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/revision"
+	revisiondb "github.com/google/exposure-notifications-server/internal/revision/database"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
+	"github.com/google/exposure-notifications-server/pkg/keys"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHandleRevisionTokenPreview_NotConfigured(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{config: &Config{}, authorizer: AllowAll{}}
+	server := newHTTPServer(t, http.MethodPost, "/revision-token/preview", s.HandleRevisionTokenPreview())
+
+	body := postRevisionTokenPreview(t, server.URL+"/revision-token/preview", "dG9rZW4=", http.StatusNotFound)
+	var preview revisionTokenPreview
+	if err := json.Unmarshal(body, &preview); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := preview.Error, "revision token preview is not configured"; got != want {
+		t.Errorf("expected error %q to be %q", got, want)
+	}
+}
+
+func TestHandleRevisionTokenPreview(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+
+	kms := keys.TestKeyManager(t)
+	keyID := keys.TestEncryptionKey(t, kms)
+	env := serverenv.New(ctx,
+		serverenv.WithDatabase(testDB),
+		serverenv.WithKeyManager(kms))
+
+	revDB, err := revisiondb.New(testDB, &revisiondb.KMSConfig{WrapperKeyID: keyID, KeyManager: kms})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := revDB.CreateRevisionKey(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	aad := []byte("revision-token-preview")
+	cfg := &Config{
+		RevisionToken: revision.Config{
+			KeyID:     keyID,
+			AAD:       aad,
+			MinLength: 28,
+		},
+		RevisionKeyCacheDuration: time.Minute,
+	}
+	s, err := NewServer(cfg, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A clinical and a self-report key that can be revised, a key that was
+	// already revised, and a key that was never published.
+	createdAt := time.Now().UTC().Add(-time.Hour).Truncate(time.Hour)
+	newExposure := func(reportType string, intervalNumber int32) *publishmodel.Exposure {
+		return &publishmodel.Exposure{
+			ExposureKey:     randomKey(t),
+			Regions:         []string{"US"},
+			IntervalNumber:  intervalNumber,
+			IntervalCount:   144,
+			CreatedAt:       createdAt,
+			LocalProvenance: true,
+			ReportType:      reportType,
+		}
+	}
+	clinical := newExposure(verifyapi.ReportTypeClinical, 2650000)
+	selfReport := newExposure(verifyapi.ReportTypeSelfReport, 2650144)
+	revised := newExposure(verifyapi.ReportTypeClinical, 2650288)
+	unpublished := newExposure(verifyapi.ReportTypeClinical, 2650432)
+
+	publishDB := publishdb.New(testDB)
+	if _, err := publishDB.InsertAndReviseExposures(ctx, &publishdb.InsertAndReviseExposuresRequest{
+		Incoming: []*publishmodel.Exposure{clinical, selfReport, revised},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	revisedConfirmed := *revised
+	revisedConfirmed.ReportType = verifyapi.ReportTypeConfirmed
+	revisedConfirmed.CreatedAt = createdAt.Add(time.Minute)
+	if _, err := publishDB.InsertAndReviseExposures(ctx, &publishdb.InsertAndReviseExposuresRequest{
+		Incoming: []*publishmodel.Exposure{&revisedConfirmed},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Mint a token the same way the publish API does.
+	tm, err := revision.New(ctx, revDB, time.Minute, 28)
+	if err != nil {
+		t.Fatal(err)
+	}
+	granted := []*publishmodel.Exposure{clinical, selfReport, revised, unpublished}
+	token, err := tm.MakeRevisionToken(ctx, nil, granted, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := newHTTPServer(t, http.MethodPost, "/revision-token/preview", s.HandleRevisionTokenPreview())
+	body := postRevisionTokenPreview(t, server.URL+"/revision-token/preview", base64.StdEncoding.EncodeToString(token), http.StatusOK)
+
+	var got revisionTokenPreview
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	keyHash := func(e *publishmodel.Exposure) string {
+		hash := sha256.Sum256(e.ExposureKey)
+		return base64.StdEncoding.EncodeToString(hash[:])
+	}
+	want := revisionTokenPreview{
+		Keys: []*revisableKeyPreview{
+			{
+				KeyHash:            keyHash(clinical),
+				IntervalNumber:     clinical.IntervalNumber,
+				IntervalCount:      clinical.IntervalCount,
+				Published:          true,
+				ReportType:         verifyapi.ReportTypeClinical,
+				AllowedTransitions: []string{verifyapi.ReportTypeConfirmed, verifyapi.ReportTypeNegative},
+			},
+			{
+				KeyHash:            keyHash(selfReport),
+				IntervalNumber:     selfReport.IntervalNumber,
+				IntervalCount:      selfReport.IntervalCount,
+				Published:          true,
+				ReportType:         verifyapi.ReportTypeSelfReport,
+				AllowedTransitions: []string{verifyapi.ReportTypeConfirmed, verifyapi.ReportTypeClinical, verifyapi.ReportTypeNegative},
+			},
+			{
+				KeyHash:            keyHash(revised),
+				IntervalNumber:     revised.IntervalNumber,
+				IntervalCount:      revised.IntervalCount,
+				Published:          true,
+				ReportType:         verifyapi.ReportTypeClinical,
+				RevisedReportType:  verifyapi.ReportTypeConfirmed,
+				AllowedTransitions: []string{},
+			},
+			{
+				KeyHash:            keyHash(unpublished),
+				IntervalNumber:     unpublished.IntervalNumber,
+				IntervalCount:      unpublished.IntervalCount,
+				AllowedTransitions: []string{},
+			},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// The preview must never contain the keys themselves.
+	for _, e := range granted {
+		if b64 := e.ExposureKeyBase64(); strings.Contains(string(body), b64) {
+			t.Errorf("expected preview to not contain key %q: %s", b64, body)
+		}
+	}
+
+	// A token encrypted with different AAD is rejected.
+	other, err := tm.MakeRevisionToken(ctx, nil, granted, []byte("other-aad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body = postRevisionTokenPreview(t, server.URL+"/revision-token/preview", base64.StdEncoding.EncodeToString(other), http.StatusBadRequest)
+	if !bytes.Contains(body, []byte("failed to decrypt revision token")) {
+		t.Errorf("expected decryption error, got %s", body)
+	}
+}
+
+func postRevisionTokenPreview(t *testing.T, url, token string, wantStatus int) []byte {
+	t.Helper()
+
+	ctx := project.TestContext(t)
+	reqBody, err := json.Marshal(&revisionTokenPreviewRequest{RevisionToken: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(reqBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resp.StatusCode, wantStatus; got != want {
+		t.Errorf("expected status %d to be %d: %s", got, want, body)
+	}
+	return body
+}
+
+func randomKey(t *testing.T) []byte {
+	t.Helper()
+
+	key := make([]byte, verifyapi.KeyLength)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
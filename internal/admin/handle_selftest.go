@@ -0,0 +1,226 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	aadb "github.com/google/exposure-notifications-server/internal/authorizedapp/database"
+	"github.com/google/exposure-notifications-server/internal/verification/database"
+)
+
+// selfTestTokenLifetime is how long a self-test stats token is valid for.
+const selfTestTokenLifetime = 5 * time.Minute
+
+// statsTokenSelfTestResult is the result of a stats token self-test.
+type statsTokenSelfTestResult struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+
+	// Time taken to sign the token through the key manager, and to
+	// authenticate it, in milliseconds.
+	SignMs         float64 `json:"signMs"`
+	AuthenticateMs float64 `json:"authenticateMs"`
+
+	HealthAuthorityID int64 `json:"healthAuthorityID,omitempty"`
+
+	// UnverifiedClaims are the claims of a token that failed to authenticate,
+	// only set in debug mode. They come from decoding the token without
+	// checking its signature, so they must not be trusted.
+	UnverifiedClaims *unverifiedClaims `json:"unverifiedClaims,omitempty"`
+}
+
+// unverifiedClaims are the fields of a token that help explain why it failed
+// to authenticate. The signature is never included.
+type unverifiedClaims struct {
+	Issuer    string `json:"iss"`
+	Audience  string `json:"aud"`
+	ExpiresAt int64  `json:"exp"`
+	KeyID     string `json:"kid"`
+}
+
+// HandleStatsTokenSelfTest mints a stats token for the self-test health
+// authority, signed by the configured key manager key, and authenticates it the
+// same way the stats API does. This exercises the key manager, the database,
+// and the verification cache.
+func (s *Server) HandleStatsTokenSelfTest() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if !s.authorize(c, ActionView, ResourceSelfTest, "stats-token") {
+			return
+		}
+
+		if s.verifier == nil {
+			c.JSON(http.StatusNotFound, &statsTokenSelfTestResult{Error: "stats token self-test is not configured"})
+			return
+		}
+
+		result := s.runStatsTokenSelfTest(c.Request.Context())
+		if !result.Success {
+			log.Printf("stats token self-test failed: %v", result.Error)
+			c.JSON(http.StatusInternalServerError, result)
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func (s *Server) runStatsTokenSelfTest(ctx context.Context) *statsTokenSelfTestResult {
+	result := &statsTokenSelfTestResult{}
+	issuer := s.config.SelfTestIssuer
+
+	if err := s.checkSelfTestIsolation(ctx, issuer); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	start := time.Now()
+	token, err := s.mintSelfTestStatsToken(ctx, time.Now())
+	result.SignMs = msSince(start)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	start = time.Now()
+	id, err := s.verifier.AuthenticateStatsToken(ctx, token)
+	result.AuthenticateMs = msSince(start)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to authenticate stats token: %v", err)
+		if s.config.SelfTestDebug {
+			claims, err := parseUnverifiedClaims(token)
+			if err != nil {
+				result.Error += fmt.Sprintf(" (failed to decode unverified claims: %v)", err)
+				return result
+			}
+			result.UnverifiedClaims = claims
+		}
+		return result
+	}
+
+	result.Success = true
+	result.HealthAuthorityID = id
+	return result
+}
+
+// checkSelfTestIsolation returns an error if the self-test health authority
+// could be used for real traffic. It must not federate, and no authorized app
+// may publish with it.
+func (s *Server) checkSelfTestIsolation(ctx context.Context, issuer string) error {
+	ha, err := database.New(s.env.Database()).GetHealthAuthority(ctx, issuer)
+	if err != nil {
+		return fmt.Errorf("failed to load self-test health authority %q: %w", issuer, err)
+	}
+	if !ha.NoFederate {
+		return fmt.Errorf("self-test health authority %q is not isolated: federation is enabled", issuer)
+	}
+
+	apps, err := aadb.New(s.env.Database()).ListAuthorizedApps(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list authorized apps: %w", err)
+	}
+	for _, app := range apps {
+		if _, ok := app.AllowedHealthAuthorityIDs[ha.ID]; ok {
+			return fmt.Errorf("self-test health authority %q is not isolated: authorized app %q allows it", issuer, app.AppPackageName)
+		}
+	}
+	return nil
+}
+
+// mintSelfTestStatsToken creates a stats token for the self-test health
+// authority, signed by the self-test signing key.
+func (s *Server) mintSelfTestStatsToken(ctx context.Context, now time.Time) (string, error) {
+	signer, err := s.env.GetSignerForKey(ctx, s.config.SelfTestSigningKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to get self-test signer: %w", err)
+	}
+
+	now = now.UTC()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, &jwt.StandardClaims{
+		Audience:  s.config.Verification.StatsAudience,
+		ExpiresAt: now.Add(selfTestTokenLifetime).Unix(),
+		IssuedAt:  now.Unix(),
+		Issuer:    s.config.SelfTestIssuer,
+		NotBefore: now.Add(-1 * time.Second).Unix(),
+	})
+	token.Header["kid"] = s.config.SelfTestKeyVersion
+
+	signed, err := signES256(token, signer)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign stats token: %w", err)
+	}
+	return signed, nil
+}
+
+// parseUnverifiedClaims decodes the claims and key ID of a token without
+// verifying its signature.
+func parseUnverifiedClaims(rawToken string) (*unverifiedClaims, error) {
+	var claims jwt.StandardClaims
+	token, _, err := new(jwt.Parser).ParseUnverified(rawToken, &claims)
+	if err != nil {
+		return nil, err
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	return &unverifiedClaims{
+		Issuer:    claims.Issuer,
+		Audience:  claims.Audience,
+		ExpiresAt: claims.ExpiresAt,
+		KeyID:     kid,
+	}, nil
+}
+
+// signES256 signs the token with an ECDSA P-256 signer. Signers return ASN.1
+// signatures, which are converted to the R || S format used by JWTs, see
+// https://tools.ietf.org/html/rfc7518#section-3.4.
+func signES256(token *jwt.Token, signer crypto.Signer) (string, error) {
+	signingString, err := token.SigningString()
+	if err != nil {
+		return "", err
+	}
+
+	digest := sha256.Sum256([]byte(signingString))
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+
+	var parsed struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(sig, &parsed); err != nil {
+		return "", fmt.Errorf("failed to parse signature: %w", err)
+	}
+
+	const keyBytes = 256 / 8
+	out := make([]byte, 2*keyBytes)
+	parsed.R.FillBytes(out[:keyBytes])
+	parsed.S.FillBytes(out[keyBytes:])
+
+	return strings.Join([]string{signingString, jwt.EncodeSegment(out)}, "."), nil
+}
+
+func msSince(start time.Time) float64 {
+	return float64(time.Since(start)) / float64(time.Millisecond)
+}
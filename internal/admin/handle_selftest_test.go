@@ -0,0 +1,288 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	aadb "github.com/google/exposure-notifications-server/internal/authorizedapp/database"
+	aamodel "github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/exposure-notifications-server/internal/verification"
+	"github.com/google/exposure-notifications-server/internal/verification/database"
+	"github.com/google/exposure-notifications-server/internal/verification/model"
+	"github.com/google/exposure-notifications-server/internal/verification/verifytest"
+	"github.com/google/exposure-notifications-server/pkg/keys"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSignES256(t *testing.T) {
+	t.Parallel()
+
+	key := verifytest.NewSigningKey(t, "sign-es256")
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, &jwt.StandardClaims{Issuer: "iss"})
+
+	signed, err := signES256(token, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := jwt.ParseWithClaims(signed, &jwt.StandardClaims{}, func(*jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.Valid {
+		t.Errorf("expected token to be valid")
+	}
+}
+
+func TestHandleStatsTokenSelfTest_NotConfigured(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{config: &Config{}, authorizer: AllowAll{}}
+	server := newHTTPServer(t, http.MethodGet, "/selftest/stats-token", s.HandleStatsTokenSelfTest())
+
+	result := getSelfTestResult(t, server.URL+"/selftest/stats-token", http.StatusNotFound)
+	if result.Success {
+		t.Errorf("expected self-test to not succeed")
+	}
+}
+
+func TestHandleStatsTokenSelfTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	cases := []struct {
+		name string
+		// setup may change the config and database before the server is created.
+		setup      func(t *testing.T, cfg *Config, ha *verifytest.HealthAuthority, env *serverenv.ServerEnv)
+		wantStatus int
+		wantErr    string
+		// wantClaims is whether the unverified claims are in the result.
+		wantClaims bool
+	}{
+		{
+			name:       "success",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "signing_key_unavailable",
+			setup: func(t *testing.T, cfg *Config, ha *verifytest.HealthAuthority, env *serverenv.ServerEnv) {
+				cfg.SelfTestSigningKey = "does-not-exist"
+			},
+			wantStatus: http.StatusInternalServerError,
+			wantErr:    "failed to get self-test signer",
+		},
+		{
+			name: "wrong_key_version",
+			setup: func(t *testing.T, cfg *Config, ha *verifytest.HealthAuthority, env *serverenv.ServerEnv) {
+				cfg.SelfTestKeyVersion = "v99"
+			},
+			wantStatus: http.StatusInternalServerError,
+			wantErr:    "failed to authenticate stats token",
+		},
+		{
+			name: "wrong_key_version_debug",
+			setup: func(t *testing.T, cfg *Config, ha *verifytest.HealthAuthority, env *serverenv.ServerEnv) {
+				cfg.SelfTestKeyVersion = "v99"
+				cfg.SelfTestDebug = true
+			},
+			wantStatus: http.StatusInternalServerError,
+			wantErr:    "failed to authenticate stats token",
+			wantClaims: true,
+		},
+		{
+			name: "success_debug",
+			setup: func(t *testing.T, cfg *Config, ha *verifytest.HealthAuthority, env *serverenv.ServerEnv) {
+				cfg.SelfTestDebug = true
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "not_isolated",
+			setup: func(t *testing.T, cfg *Config, ha *verifytest.HealthAuthority, env *serverenv.ServerEnv) {
+				app := aamodel.NewAuthorizedApp()
+				app.AppPackageName = "com.example.app"
+				app.AllowedHealthAuthorityIDs[ha.HealthAuthority.ID] = struct{}{}
+				if err := aadb.New(env.Database()).InsertAuthorizedApp(ctx, app); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantStatus: http.StatusInternalServerError,
+			wantErr:    "is not isolated",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			testDB, _ := testDatabaseInstance.NewDatabase(t)
+
+			// The self-test signing key is in a filesystem key manager.
+			ha := verifytest.NewHealthAuthority(t, "selftest.test.health")
+			ha.HealthAuthority.NoFederate = true
+			ha.Key.Usage = model.KeyUsageStats
+			ha.Store(ctx, t, database.New(testDB))
+
+			root := t.TempDir()
+			der, err := x509.MarshalECPrivateKey(ha.PrivateKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(root, "selftest"), der, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			kms, err := keys.NewFilesystem(ctx, &keys.Config{FilesystemRoot: root})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			env := serverenv.New(ctx,
+				serverenv.WithDatabase(testDB),
+				serverenv.WithKeyManager(kms))
+
+			cfg := &Config{
+				Verification: verification.Config{
+					CacheDuration: time.Minute,
+					StatsAudience: "selftest-aud",
+				},
+				SelfTestIssuer:     ha.HealthAuthority.Issuer,
+				SelfTestSigningKey: "selftest",
+				SelfTestKeyVersion: ha.Key.Version,
+			}
+			if tc.setup != nil {
+				tc.setup(t, cfg, ha, env)
+			}
+
+			s, err := NewServer(cfg, env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			server := newHTTPServer(t, http.MethodGet, "/selftest/stats-token", s.HandleStatsTokenSelfTest())
+
+			result := getSelfTestResult(t, server.URL+"/selftest/stats-token", tc.wantStatus)
+			if got, want := result.Success, tc.wantErr == ""; got != want {
+				t.Errorf("expected success to be %t, got %t: %s", want, got, result.Error)
+			}
+			if !strings.Contains(result.Error, tc.wantErr) {
+				t.Errorf("expected error %q to contain %q", result.Error, tc.wantErr)
+			}
+			if got, want := result.UnverifiedClaims != nil, tc.wantClaims; got != want {
+				t.Fatalf("expected unverified claims to be present %t, got %#v", want, result.UnverifiedClaims)
+			}
+			if tc.wantClaims {
+				want := &unverifiedClaims{
+					Issuer:    ha.HealthAuthority.Issuer,
+					Audience:  "selftest-aud",
+					ExpiresAt: result.UnverifiedClaims.ExpiresAt,
+					KeyID:     cfg.SelfTestKeyVersion,
+				}
+				if diff := cmp.Diff(want, result.UnverifiedClaims); diff != "" {
+					t.Errorf("mismatch (-want, +got):\n%s", diff)
+				}
+				if result.UnverifiedClaims.ExpiresAt <= time.Now().Unix() {
+					t.Errorf("expected expiry %d to be in the future", result.UnverifiedClaims.ExpiresAt)
+				}
+			}
+			if tc.wantErr == "" {
+				if got, want := result.HealthAuthorityID, ha.HealthAuthority.ID; got != want {
+					t.Errorf("expected health authority %d to be %d", got, want)
+				}
+				if result.SignMs <= 0 || result.AuthenticateMs <= 0 {
+					t.Errorf("expected timings, got sign %vms authenticate %vms", result.SignMs, result.AuthenticateMs)
+				}
+			}
+		})
+	}
+}
+
+func TestParseUnverifiedClaims(t *testing.T) {
+	t.Parallel()
+
+	key := verifytest.NewSigningKey(t, "unverified-claims")
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, &jwt.StandardClaims{
+		Audience:  "aud",
+		ExpiresAt: 1234,
+		Issuer:    "iss",
+	})
+	token.Header["kid"] = "v1"
+	signed, err := signES256(token, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := parseUnverifiedClaims(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &unverifiedClaims{Issuer: "iss", Audience: "aud", ExpiresAt: 1234, KeyID: "v1"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// The signature is not dumped.
+	b, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := signed[strings.LastIndex(signed, ".")+1:]
+	if strings.Contains(string(b), sig) {
+		t.Errorf("expected %s to not contain the signature", b)
+	}
+
+	if _, err := parseUnverifiedClaims("not-a-token"); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func getSelfTestResult(t *testing.T, url string, wantStatus int) *statsTokenSelfTestResult {
+	t.Helper()
+
+	ctx := project.TestContext(t)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got, want := resp.StatusCode, wantStatus; got != want {
+		t.Errorf("expected status %d to be %d", got, want)
+	}
+
+	var result statsTokenSelfTestResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	return &result
+}
@@ -39,6 +39,10 @@ const defaultDigestMessage = "hello world"
 // infos.
 func (s *Server) HandleSignatureInfosSave() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		if !s.authorize(c, saveAction(c.Param("id")), ResourceSignatureInfo, c.Param("id")) {
+			return
+		}
+
 		var form signatureInfoFormData
 		err := c.Bind(&form)
 		if err != nil {
@@ -89,6 +93,10 @@ func (s *Server) HandleSignatureInfosSave() func(c *gin.Context) {
 // HandleSignatureInfosShow handles the show action for signature infos.
 func (s *Server) HandleSignatureInfosShow() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		if !s.authorize(c, ActionView, ResourceSignatureInfo, c.Param("id")) {
+			return
+		}
+
 		ctx := c.Request.Context()
 		m := TemplateMap{}
 
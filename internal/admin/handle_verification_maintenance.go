@@ -0,0 +1,91 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/google/exposure-notifications-server/internal/verification/database"
+)
+
+// cacheMaintenanceModeJSON is the response of the verification cache
+// maintenance mode handlers.
+type cacheMaintenanceModeJSON struct {
+	Enabled   bool       `json:"enabled"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+}
+
+// HandleCacheMaintenanceModeShow returns whether the verification cache
+// maintenance mode is enabled from the admin console. Servers can also enable
+// it with VERIFICATION_CACHE_MAINTENANCE_MODE.
+func (s *Server) HandleCacheMaintenanceModeShow() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if !s.authorize(c, ActionView, ResourceCacheMaintenance, "") {
+			return
+		}
+
+		s.renderCacheMaintenanceMode(c)
+	}
+}
+
+// HandleCacheMaintenanceModeSave enables or disables the verification cache
+// maintenance mode of all servers, depending on the action param. Servers
+// apply it the next time they refresh it, see
+// VERIFICATION_CACHE_MAINTENANCE_MODE_REFRESH_INTERVAL, so enable it before the
+// database maintenance starts.
+func (s *Server) HandleCacheMaintenanceModeSave() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		action := c.Param("action")
+		if !s.authorize(c, ActionUpdate, ResourceCacheMaintenance, "") {
+			return
+		}
+
+		var enabled bool
+		switch action {
+		case "enable":
+			enabled = true
+		case "disable":
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "action must be enable or disable"})
+			return
+		}
+
+		db := database.New(s.env.Database())
+		if err := db.SetCacheMaintenanceMode(c.Request.Context(), enabled, time.Now().UTC()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to %s cache maintenance mode: %v", action, err)})
+			return
+		}
+		s.renderCacheMaintenanceMode(c)
+	}
+}
+
+func (s *Server) renderCacheMaintenanceMode(c *gin.Context) {
+	enabled, updatedAt, err := database.New(s.env.Database()).CacheMaintenanceMode(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to read cache maintenance mode: %v", err)})
+		return
+	}
+
+	resp := &cacheMaintenanceModeJSON{Enabled: enabled}
+	if !updatedAt.IsZero() {
+		updatedAt = updatedAt.UTC()
+		resp.UpdatedAt = &updatedAt
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,99 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/exposure-notifications-server/internal/verification/database"
+)
+
+func TestHandleCacheMaintenanceMode(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	env, s := newTestServer(t)
+	haDB := database.New(env.Database())
+
+	call := func(t *testing.T, method, route, path string, handler func(*Server) func(c *gin.Context)) (int, *cacheMaintenanceModeJSON) {
+		t.Helper()
+
+		server := newHTTPServer(t, method, route, handler(s))
+		req, err := http.NewRequestWithContext(ctx, method, server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := server.Client().Do(req)
+		if err != nil {
+			t.Fatalf("error making http call: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var result cacheMaintenanceModeJSON
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode, &result
+	}
+	show := func(t *testing.T) *cacheMaintenanceModeJSON {
+		t.Helper()
+
+		code, result := call(t, http.MethodGet, "/verification-maintenance.json", "/verification-maintenance.json", (*Server).HandleCacheMaintenanceModeShow)
+		if code != http.StatusOK {
+			t.Fatalf("expected status %d to be %d", code, http.StatusOK)
+		}
+		return result
+	}
+	save := func(t *testing.T, action string) (int, *cacheMaintenanceModeJSON) {
+		t.Helper()
+		return call(t, http.MethodPost, "/verification-maintenance/:action", "/verification-maintenance/"+action, (*Server).HandleCacheMaintenanceModeSave)
+	}
+
+	if got := show(t); got.Enabled || got.UpdatedAt != nil {
+		t.Errorf("expected maintenance mode to be unset, got %#v", got)
+	}
+
+	for _, action := range []string{"enable", "disable"} {
+		code, got := save(t, action)
+		if code != http.StatusOK {
+			t.Fatalf("expected %s to succeed, got %d", action, code)
+		}
+		want := action == "enable"
+		if got.Enabled != want || got.UpdatedAt == nil {
+			t.Errorf("%s: expected maintenance mode %t, got %#v", action, want, got)
+		}
+		if got := show(t); got.Enabled != want {
+			t.Errorf("%s: expected maintenance mode %t to be %t", action, got.Enabled, want)
+		}
+
+		enabled, _, err := haDB.CacheMaintenanceMode(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if enabled != want {
+			t.Errorf("%s: expected stored maintenance mode %t to be %t", action, enabled, want)
+		}
+	}
+
+	if code, _ := save(t, "banana"); code != http.StatusBadRequest {
+		t.Errorf("expected unknown action to fail with %d, got %d", http.StatusBadRequest, code)
+	}
+}
@@ -20,25 +20,91 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/exposure-notifications-server/internal/revision"
+	revisiondb "github.com/google/exposure-notifications-server/internal/revision/database"
 	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/exposure-notifications-server/internal/verification"
+	"github.com/google/exposure-notifications-server/internal/verification/database"
+	"github.com/google/exposure-notifications-server/pkg/base64util"
 )
 
 // Server is the admin server.
 type Server struct {
-	config *Config
-	env    *serverenv.ServerEnv
+	config     *Config
+	env        *serverenv.ServerEnv
+	signingKey []byte
+	authorizer Authorizer
+	verifier   *verification.Verifier // For the stats token self-test, if enabled.
+
+	tokenManager *revision.TokenManager // For the revision token preview, if enabled.
+}
+
+// Option is an option for the admin console server.
+type Option func(*Server) *Server
+
+// WithAuthorizer sets the authorizer consulted by the admin handlers. The
+// default allows all requests.
+func WithAuthorizer(a Authorizer) Option {
+	return func(s *Server) *Server {
+		s.authorizer = a
+		return s
+	}
 }
 
 // NewServer makes a new admin console server.
-func NewServer(config *Config, env *serverenv.ServerEnv) (*Server, error) {
+func NewServer(config *Config, env *serverenv.ServerEnv, opts ...Option) (*Server, error) {
 	if env.Database() == nil {
 		return nil, fmt.Errorf("missing Database in server env")
 	}
 
-	return &Server{
-		config: config,
-		env:    env,
-	}, nil
+	var signingKey []byte
+	if config.RequestSigningKey != "" {
+		var err error
+		signingKey, err = base64util.DecodeString(config.RequestSigningKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode ADMIN_REQUEST_SIGNING_KEY: %w", err)
+		}
+	}
+
+	var verifier *verification.Verifier
+	if config.SelfTestIssuer != "" {
+		if config.SelfTestSigningKey == "" || config.SelfTestKeyVersion == "" {
+			return nil, fmt.Errorf("SELF_TEST_SIGNING_KEY and SELF_TEST_KEY_VERSION are required with SELF_TEST_HEALTH_AUTHORITY_ISSUER")
+		}
+		var err error
+		verifier, err = verification.New(database.New(env.Database()), &config.Verification)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create self-test verifier: %w", err)
+		}
+	}
+
+	var tokenManager *revision.TokenManager
+	if len(config.RevisionToken.AAD) > 0 {
+		revisionDB, err := revisiondb.New(env.Database(), &revisiondb.KMSConfig{
+			WrapperKeyID: config.RevisionToken.KeyID,
+			KeyManager:   env.GetKeyManager(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("revisiondb.New: %w", err)
+		}
+		tokenManager, err = revision.New(context.Background(), revisionDB, config.RevisionKeyCacheDuration, config.RevisionToken.MinLength)
+		if err != nil {
+			return nil, fmt.Errorf("revision.New: %w", err)
+		}
+	}
+
+	s := &Server{
+		config:       config,
+		env:          env,
+		signingKey:   signingKey,
+		authorizer:   AllowAll{},
+		verifier:     verifier,
+		tokenManager: tokenManager,
+	}
+	for _, opt := range opts {
+		s = opt(s)
+	}
+	return s, nil
 }
 
 func (s *Server) Routes(ctx context.Context) http.Handler {
@@ -54,34 +120,64 @@ func (s *Server) Routes(ctx context.Context) http.Handler {
 	// Static assets.
 	mux.StaticFS("/assets/", http.FS(assetsFS))
 
+	// Requests that modify data must be signed, if a signing key is configured.
+	signed := s.RequireSignedRequest()
+
 	// Landing page.
 	mux.GET("/", s.HandleIndex())
 
 	// Authorized App Handling.
 	mux.GET("/app", s.HandleAuthorizedAppsShow())
-	mux.POST("/app", s.HandleAuthorizedAppsSave())
+	mux.POST("/app", signed, s.HandleAuthorizedAppsSave())
 
 	// HealthAuthority[Key] Handling.
 	mux.GET("/healthauthority/:id", s.HandleHealthAuthorityShow())
-	mux.POST("/healthauthority/:id", s.HandleHealthAuthoritySave())
-	mux.POST("/healthauthoritykey/:id/:action/:version", s.HandleHealthAuthorityKeys())
+	mux.POST("/healthauthority/:id", signed, s.HandleHealthAuthoritySave())
+	mux.POST("/healthauthoritykey/:id/:action/:version", signed, s.HandleHealthAuthorityKeys())
+	mux.POST("/healthauthorityrevoke/:id", signed, s.HandleHealthAuthorityRevokeExposures())
+	mux.POST("/healthauthoritypurgekeys/:id", signed, s.HandleHealthAuthorityPurgeKeys())
+	mux.GET("/healthauthorities.json", s.HandleHealthAuthoritiesByAudience())
+	mux.GET("/healthauthority-keys-check.json", s.HandleHealthAuthorityKeysCheck())
+
+	// Exposure lookups.
+	mux.GET("/exposures-by-certificate.json", s.HandleExposuresByCertificate())
+
+	// Publish quarantine review.
+	mux.GET("/quarantine.json", s.HandleQuarantineList())
+	mux.POST("/quarantine/:id/:action", signed, s.HandleQuarantineReview())
+
+	// Verification cache maintenance mode.
+	mux.GET("/verification-maintenance.json", s.HandleCacheMaintenanceModeShow())
+	mux.POST("/verification-maintenance/:action", signed, s.HandleCacheMaintenanceModeSave())
 
 	// Export Config Handling.
 	mux.GET("/exports/:id", s.HandleExportsShow())
-	mux.POST("/exports/:id", s.HandleExportsSave())
+	mux.POST("/exports/:id", signed, s.HandleExportsSave())
+	mux.GET("/exports.json", s.HandleExportsDownload())
+	mux.POST("/exports.json", signed, s.HandleExportsUpload())
+	mux.GET("/export-runs.json", s.HandleExportRuns())
+	mux.GET("/export-batch.json", s.HandleExportBatch())
+	mux.GET("/export-files.json", s.HandleExportFiles())
+	mux.POST("/export-verify.json", s.HandleExportVerify())
 
 	// Export importer configuration
 	mux.GET("/export-importers/:id", s.HandleExportImportersShow())
-	mux.POST("/export-importers/:id", s.HandleExportImportersSave())
-	mux.POST("/export-importers-key/:id/:action/:keyid", s.HandleExportImportKeys())
+	mux.POST("/export-importers/:id", signed, s.HandleExportImportersSave())
+	mux.POST("/export-importers-key/:id/:action/:keyid", signed, s.HandleExportImportKeys())
 
 	// Mirror handling.
 	mux.GET("/mirrors/:id", s.HandleMirrorsShow())
-	mux.POST("/mirrors/:id", s.HandleMirrorsSave())
+	mux.POST("/mirrors/:id", signed, s.HandleMirrorsSave())
 
 	// Signature Info.
 	mux.GET("/siginfo/:id", s.HandleSignatureInfosShow())
-	mux.POST("/siginfo/:id", s.HandleSignatureInfosSave())
+	mux.POST("/siginfo/:id", signed, s.HandleSignatureInfosSave())
+
+	// Self-tests.
+	mux.GET("/selftest/stats-token", s.HandleStatsTokenSelfTest())
+
+	// Revision token preview.
+	mux.POST("/revision-token/preview", s.HandleRevisionTokenPreview())
 
 	// Healthz.
 	mux.GET("/health", s.HandleHealthz())
@@ -39,6 +39,7 @@ func TestServerRoutes(t *testing.T) {
 		{"apps", "/app"},
 		{"health_authority", "/healthauthority/0"},
 		{"exports", "/exports/0"},
+		{"exports_json", "/exports.json"},
 		{"export_importers", "/export-importers/0"},
 		{"mirrors", "/mirrors/0"},
 		{"siginfo", "/siginfo/0"},
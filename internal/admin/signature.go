@@ -0,0 +1,138 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// SignatureHeader is the header that carries the base64 encoded HMAC-SHA256
+	// signature of an admin request.
+	SignatureHeader = "X-Admin-Signature"
+
+	// SignatureTimestampHeader is the header that carries the unix timestamp,
+	// in seconds, at which an admin request was signed.
+	SignatureTimestampHeader = "X-Admin-Signature-Timestamp"
+)
+
+// SignRequest signs the request with the given key, setting the signature
+// headers. The request body is read and replaced, so it can still be sent.
+func SignRequest(r *http.Request, key []byte, now time.Time) error {
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	sig := requestSignature(key, r.Method, r.URL.RequestURI(), timestamp, body)
+
+	r.Header.Set(SignatureTimestampHeader, timestamp)
+	r.Header.Set(SignatureHeader, base64.StdEncoding.EncodeToString(sig))
+	return nil
+}
+
+// verifyRequest checks that the request was signed by the given key no more
+// than maxAge from now, in either direction.
+func verifyRequest(r *http.Request, key []byte, maxAge time.Duration, now time.Time) error {
+	timestamp := r.Header.Get(SignatureTimestampHeader)
+	if timestamp == "" {
+		return fmt.Errorf("missing %s header", SignatureTimestampHeader)
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s header: %w", SignatureTimestampHeader, err)
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > maxAge || age < -maxAge {
+		return fmt.Errorf("signature timestamp is outside of the allowed window of %v", maxAge)
+	}
+
+	header := r.Header.Get(SignatureHeader)
+	if header == "" {
+		return fmt.Errorf("missing %s header", SignatureHeader)
+	}
+	got, err := base64.StdEncoding.DecodeString(header)
+	if err != nil {
+		return fmt.Errorf("invalid %s header: %w", SignatureHeader, err)
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	want := requestSignature(key, r.Method, r.URL.RequestURI(), timestamp, body)
+	if !hmac.Equal(got, want) {
+		return fmt.Errorf("signature does not match request")
+	}
+	return nil
+}
+
+// requestSignature calculates the HMAC-SHA256 of the request method, URI,
+// signing timestamp, and body.
+func requestSignature(key []byte, method, uri, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n", method, uri, timestamp)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// readBody reads the full request body and replaces it so it can be read
+// again.
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	if err := r.Body.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close request body: %w", err)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// RequireSignedRequest is middleware that rejects requests that aren't signed
+// by the configured request signing key. If no key is configured, all requests
+// are allowed.
+func (s *Server) RequireSignedRequest() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if len(s.signingKey) == 0 {
+			c.Next()
+			return
+		}
+
+		if err := verifyRequest(c.Request, s.signingKey, s.config.RequestSignatureMaxAge, time.Now()); err != nil {
+			log.Printf("rejected unsigned request to %s: %v", c.Request.URL.Path, err)
+			c.HTML(http.StatusUnauthorized, "error", gin.H{"error": []string{"Request signature is missing or invalid."}})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
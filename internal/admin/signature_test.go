@@ -0,0 +1,162 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/exposure-notifications-server/internal/project"
+)
+
+func TestRequireSignedRequest(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	key := []byte("super-secret-signing-key")
+	body := "name=example&enable-stats-api=true"
+
+	cases := []struct {
+		name       string
+		signingKey []byte
+		sign       func(t *testing.T, r *http.Request)
+		status     int
+	}{
+		{
+			name:       "signed",
+			signingKey: key,
+			sign: func(t *testing.T, r *http.Request) {
+				if err := SignRequest(r, key, time.Now()); err != nil {
+					t.Fatal(err)
+				}
+			},
+			status: http.StatusOK,
+		},
+		{
+			name:       "unsigned",
+			signingKey: key,
+			sign:       func(t *testing.T, r *http.Request) {},
+			status:     http.StatusUnauthorized,
+		},
+		{
+			name:       "unsigned_no_key",
+			signingKey: nil,
+			sign:       func(t *testing.T, r *http.Request) {},
+			status:     http.StatusOK,
+		},
+		{
+			name:       "wrong_key",
+			signingKey: key,
+			sign: func(t *testing.T, r *http.Request) {
+				if err := SignRequest(r, []byte("some-other-key"), time.Now()); err != nil {
+					t.Fatal(err)
+				}
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:       "tampered_body",
+			signingKey: key,
+			sign: func(t *testing.T, r *http.Request) {
+				if err := SignRequest(r, key, time.Now()); err != nil {
+					t.Fatal(err)
+				}
+				r.Body = io.NopCloser(strings.NewReader(body + "&no-federate=true"))
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:       "tampered_path",
+			signingKey: key,
+			sign: func(t *testing.T, r *http.Request) {
+				if err := SignRequest(r, key, time.Now()); err != nil {
+					t.Fatal(err)
+				}
+				r.URL.Path = "/healthauthority/2"
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:       "expired",
+			signingKey: key,
+			sign: func(t *testing.T, r *http.Request) {
+				if err := SignRequest(r, key, time.Now().Add(-time.Hour)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:       "invalid_signature_encoding",
+			signingKey: key,
+			sign: func(t *testing.T, r *http.Request) {
+				if err := SignRequest(r, key, time.Now()); err != nil {
+					t.Fatal(err)
+				}
+				r.Header.Set(SignatureHeader, "not base64!")
+			},
+			status: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			config := &Config{RequestSignatureMaxAge: 5 * time.Minute}
+			tmpl, err := config.TemplateRenderer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := &Server{config: config, signingKey: tc.signingKey}
+
+			var gotBody string
+			mux := gin.New()
+			mux.SetHTMLTemplate(tmpl)
+			mux.POST("/healthauthority/:id", s.RequireSignedRequest(), func(c *gin.Context) {
+				b, err := io.ReadAll(c.Request.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				gotBody = string(b)
+				c.Status(http.StatusOK)
+			})
+
+			r, err := http.NewRequestWithContext(ctx, http.MethodPost, "/healthauthority/1", strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			tc.sign(t, r)
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+
+			if got, want := w.Code, tc.status; got != want {
+				t.Fatalf("expected status %d to be %d: %s", got, want, w.Body.String())
+			}
+			if tc.status == http.StatusOK && gotBody != body {
+				t.Errorf("expected handler to read body %q, got %q", body, gotBody)
+			}
+		})
+	}
+}
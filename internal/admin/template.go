@@ -17,6 +17,7 @@ package admin
 import (
 	"fmt"
 	"html/template"
+	"strings"
 	"time"
 )
 
@@ -26,6 +27,7 @@ var TemplateFuncMap = template.FuncMap{
 	"htmlDate":     timestampFormatter("2006-01-02"),
 	"htmlTime":     timestampFormatter("15:04"),
 	"htmlDatetime": timestampFormatter(time.UnixDate),
+	"join":         strings.Join,
 }
 
 // timestampFormatter returns a function that formats the given timestamp.
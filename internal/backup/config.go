@@ -17,10 +17,12 @@ package backup
 import (
 	"time"
 
+	"github.com/google/exposure-notifications-server/internal/middleware"
 	"github.com/google/exposure-notifications-server/internal/setup"
 	"github.com/google/exposure-notifications-server/pkg/database"
 	"github.com/google/exposure-notifications-server/pkg/observability"
 	"github.com/google/exposure-notifications-server/pkg/secrets"
+	"github.com/google/exposure-notifications-server/pkg/server"
 )
 
 // Compile-time check to assert this config matches requirements.
@@ -36,6 +38,8 @@ type Config struct {
 	Database              database.Config
 	ObservabilityExporter observability.Config
 	SecretManager         secrets.Config
+	TLS                   server.TLSConfig
+	SecurityHeaders       middleware.SecurityHeadersConfig
 
 	Port string `env:"PORT, default=8080"`
 
@@ -18,6 +18,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/google/exposure-notifications-server/internal/export/database"
 	"github.com/google/exposure-notifications-server/internal/middleware"
@@ -106,6 +107,34 @@ func (s *ExportServer) handleCleanup() http.Handler {
 			}
 		}()
 
+		// Export runs
+		func() {
+			ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+			defer cancel()
+
+			if count, err := s.database.DeleteExportRunsBefore(ctx, cutoff); err != nil {
+				merr = multierror.Append(merr, fmt.Errorf("failed to delete export runs: %w", err))
+			} else {
+				logger.Infow("purged export runs", "count", count)
+			}
+		}()
+
+		// Orphaned files
+		if s.config.OrphanCleanup {
+			func() {
+				ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+				defer cancel()
+
+				olderThan := time.Now().UTC().Add(-s.config.OrphanGracePeriod)
+				orphans, err := s.database.DeleteOrphanedFiles(ctx, s.blobstore, olderThan, s.config.OrphanDryRun)
+				if err != nil {
+					merr = multierror.Append(merr, fmt.Errorf("failed to delete orphaned files: %w", err))
+				} else {
+					logger.Infow("purged orphaned files", "count", len(orphans), "dry_run", s.config.OrphanDryRun)
+				}
+			}()
+		}
+
 		if errs := merr.WrappedErrors(); len(errs) > 0 {
 			logger.Errorw("failed to cleanup exports", "errors", errs)
 			s.h.RenderJSON(w, http.StatusInternalServerError, errs)
@@ -18,6 +18,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/google/exposure-notifications-server/internal/middleware"
 	"github.com/google/exposure-notifications-server/internal/publish/database"
@@ -93,7 +94,7 @@ func (s *ExposureServer) handleCleanup() http.Handler {
 			ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
 			defer cancel()
 
-			if count, err := s.database.DeleteExposuresBefore(ctx, cutoff); err != nil {
+			if count, err := s.deleteExposures(ctx, cutoff); err != nil {
 				merr = multierror.Append(merr, fmt.Errorf("failed to delete exposures: %w", err))
 			} else {
 				logger.Infow("purged exposures", "count", count)
@@ -112,6 +113,18 @@ func (s *ExposureServer) handleCleanup() http.Handler {
 			}
 		}()
 
+		// Daily key quotas, which are only read for the current day.
+		func() {
+			ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+			defer cancel()
+
+			if count, err := s.database.DeleteDailyKeyCountsBefore(ctx, time.Now()); err != nil {
+				merr = multierror.Append(merr, fmt.Errorf("failed to delete daily key counts: %w", err))
+			} else {
+				logger.Infow("purged daily key counts", "count", count)
+			}
+		}()
+
 		if errs := merr.WrappedErrors(); len(errs) > 0 {
 			logger.Errorw("failed to cleanup exposures", "errors", errs)
 			s.h.RenderJSON(w, http.StatusInternalServerError, errs)
@@ -122,3 +135,25 @@ func (s *ExposureServer) handleCleanup() http.Handler {
 		s.h.RenderJSON(w, http.StatusOK, nil)
 	})
 }
+
+// deleteExposures deletes the exposures created before the cutoff, in batches
+// if configured. If a batch fails, the exposures deleted by earlier batches are
+// still counted.
+func (s *ExposureServer) deleteExposures(ctx context.Context, cutoff time.Time) (int64, error) {
+	batchSize := int64(s.config.ExposureBatchSize)
+	if batchSize == 0 {
+		return s.database.DeleteExposuresBefore(ctx, cutoff)
+	}
+
+	var total int64
+	for {
+		count, err := s.database.DeleteExposuresBatchBefore(ctx, cutoff, int(batchSize))
+		if err != nil {
+			return total, err
+		}
+		total += count
+		if count < batchSize {
+			return total, nil
+		}
+	}
+}
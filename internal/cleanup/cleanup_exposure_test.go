@@ -22,7 +22,10 @@ import (
 	"time"
 
 	"github.com/google/exposure-notifications-server/internal/project"
+	publishdb "github.com/google/exposure-notifications-server/internal/publish/database"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
 	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestNewExposureServer(t *testing.T) {
@@ -97,3 +100,92 @@ func TestExposureHandler_ServeHTTP(t *testing.T) {
 		t.Errorf("expected %d to be %d: %s", got, want, w.Body.String())
 	}
 }
+
+func TestExposureHandler_ResumesFromCheckpoint(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	env := serverenv.New(ctx, serverenv.WithDatabase(testDB))
+	publishDB := publishdb.New(testDB)
+
+	ttl := 336 * time.Hour
+	expired := time.Now().UTC().Add(-ttl - 24*time.Hour).Truncate(time.Hour)
+
+	insert := func(t *testing.T, key string, createdAt time.Time) *publishmodel.Exposure {
+		t.Helper()
+
+		exposure := &publishmodel.Exposure{
+			ExposureKey:     []byte(key),
+			Regions:         []string{"US"},
+			IntervalNumber:  100,
+			IntervalCount:   144,
+			CreatedAt:       createdAt,
+			LocalProvenance: true,
+		}
+		if _, err := publishDB.InsertAndReviseExposures(ctx, &publishdb.InsertAndReviseExposuresRequest{
+			Incoming:     []*publishmodel.Exposure{exposure},
+			RequireToken: false,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return exposure
+	}
+
+	for i := 0; i < 5; i++ {
+		insert(t, fmt.Sprintf("expired-%d", i), expired.Add(time.Duration(i)*time.Hour))
+	}
+	current := insert(t, "current", time.Now().UTC().Truncate(time.Hour))
+
+	// Simulate a cleanup that was killed after committing its first batch.
+	cutoff, err := cutoffDate(ttl, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := publishDB.DeleteExposuresBatchBefore(ctx, cutoff, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// An exposure older than the checkpoint is not rescanned by the restarted
+	// cleanup, which shows that it resumed rather than starting over.
+	skipped := insert(t, "before-checkpoint", expired.Add(-time.Hour))
+
+	server, err := NewExposureServer(&Config{
+		Timeout:           5 * time.Second,
+		TTL:               ttl,
+		ExposureBatchSize: 2,
+	}, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	server.Routes(ctx).ServeHTTP(w, r)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("expected %d to be %d: %s", got, want, w.Body.String())
+	}
+
+	var got [][]byte
+	if _, err := publishDB.IterateExposures(ctx, publishdb.IterateExposuresCriteria{}, func(e *publishmodel.Exposure) error {
+		got = append(got, e.ExposureKey)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]byte{skipped.ExposureKey, current.ExposureKey}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	checkpoint, err := publishDB.GetCleanupCheckpoint(ctx, publishdb.ExposureCleanupCheckpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := expired.Add(4 * time.Hour); !checkpoint.Equal(want) {
+		t.Errorf("expected checkpoint %v to be %v", checkpoint, want)
+	}
+}
@@ -17,11 +17,13 @@ package cleanup
 import (
 	"time"
 
+	"github.com/google/exposure-notifications-server/internal/middleware"
 	"github.com/google/exposure-notifications-server/internal/setup"
 	"github.com/google/exposure-notifications-server/internal/storage"
 	"github.com/google/exposure-notifications-server/pkg/database"
 	"github.com/google/exposure-notifications-server/pkg/observability"
 	"github.com/google/exposure-notifications-server/pkg/secrets"
+	"github.com/google/exposure-notifications-server/pkg/server"
 )
 
 // Compile-time check to assert this config matches requirements.
@@ -39,11 +41,27 @@ type Config struct {
 	SecretManager         secrets.Config
 	Storage               storage.Config
 	ObservabilityExporter observability.Config
+	TLS                   server.TLSConfig
+	SecurityHeaders       middleware.SecurityHeadersConfig
 
 	Port    string        `env:"PORT, default=8080"`
 	Timeout time.Duration `env:"CLEANUP_TIMEOUT, default=10m"`
 	TTL     time.Duration `env:"CLEANUP_TTL, default=336h"`
 
+	// ExposureBatchSize is the number of exposures deleted per transaction. The
+	// progress is persisted after each batch, so an interrupted cleanup resumes
+	// where it left off. 0 deletes all expired exposures in one transaction.
+	ExposureBatchSize uint `env:"CLEANUP_EXPOSURE_BATCH_SIZE, default=10000"`
+
+	// OrphanCleanup enables deleting export files in the blobstore that have no
+	// export file record, e.g. files left behind by interrupted export runs.
+	// Only files for batches that ended more than OrphanGracePeriod ago are
+	// considered. If OrphanDryRun is set, orphaned files are logged but not
+	// deleted.
+	OrphanCleanup     bool          `env:"CLEANUP_ORPHANED_EXPORT_FILES, default=false"`
+	OrphanGracePeriod time.Duration `env:"CLEANUP_ORPHAN_GRACE_PERIOD, default=24h"`
+	OrphanDryRun      bool          `env:"CLEANUP_ORPHAN_DRY_RUN, default=false"`
+
 	DebugOverrideCleanupMinDuration bool `env:"DEBUG_OVERRIDE_CLEANUP_MIN_DURATION, default=false"`
 }
 
@@ -16,11 +16,13 @@ package debugger
 
 import (
 	"github.com/google/exposure-notifications-server/internal/authorizedapp"
+	"github.com/google/exposure-notifications-server/internal/middleware"
 	"github.com/google/exposure-notifications-server/internal/setup"
 	"github.com/google/exposure-notifications-server/internal/storage"
 	"github.com/google/exposure-notifications-server/pkg/database"
 	"github.com/google/exposure-notifications-server/pkg/keys"
 	"github.com/google/exposure-notifications-server/pkg/secrets"
+	"github.com/google/exposure-notifications-server/pkg/server"
 )
 
 // Compile-time check to assert this config matches requirements.
@@ -34,13 +36,20 @@ var (
 
 // Config represents the configuration and associated environment variables.
 type Config struct {
-	AuthorizedApp authorizedapp.Config
-	Database      database.Config
-	KeyManager    keys.Config
-	SecretManager secrets.Config
-	Storage       storage.Config
+	AuthorizedApp   authorizedapp.Config
+	Database        database.Config
+	KeyManager      keys.Config
+	SecretManager   secrets.Config
+	Storage         storage.Config
+	TLS             server.TLSConfig
+	SecurityHeaders middleware.SecurityHeadersConfig
 
 	Port string `env:"PORT, default=8080"`
+
+	// Gzip compression of responses. Responses smaller than
+	// ResponseCompressionMinBytes are not compressed.
+	EnableResponseCompression   bool `env:"ENABLE_RESPONSE_COMPRESSION, default=true"`
+	ResponseCompressionMinBytes int  `env:"RESPONSE_COMPRESSION_MIN_BYTES, default=1024"`
 }
 
 func (c *Config) AuthorizedAppConfig() *authorizedapp.Config {
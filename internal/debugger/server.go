@@ -65,7 +65,12 @@ func (s *Server) Routes(ctx context.Context) *mux.Router {
 	r.Use(middleware.PopulateLogger(logger))
 
 	r.Handle("/health", server.HandleHealthz(s.env.Database()))
-	r.Handle("/", s.handleDebug())
+
+	debug := s.handleDebug()
+	if s.config.EnableResponseCompression {
+		debug = middleware.Compress(s.config.ResponseCompressionMinBytes)(debug)
+	}
+	r.Handle("/", debug)
 
 	return r
 }
@@ -120,7 +120,16 @@ func (s *Server) maybeCreateBatches(ctx context.Context, ec *model.ExportConfig,
 		return 0, fmt.Errorf("fetching most recent batch for config %d: %w", ec.ConfigID, err)
 	}
 
-	ranges := makeBatchRanges(ec.Period, latestEnd, now, s.config.TruncateWindow)
+	var ranges []batchRange
+	if ec.Schedule != "" {
+		schedule, err := model.ParseSchedule(ec.Schedule)
+		if err != nil {
+			return 0, fmt.Errorf("parsing schedule for config %d: %w", ec.ConfigID, err)
+		}
+		ranges = makeScheduledBatchRanges(schedule, latestEnd, now, s.config.TruncateWindow)
+	} else {
+		ranges = makeBatchRanges(ec.Period, latestEnd, now, s.config.TruncateWindow)
+	}
 	if len(ranges) == 0 {
 		stats.Record(ctx, mBatcherNoWork.M(1))
 		logger.Debugw("skipping batch creation")
@@ -129,22 +138,34 @@ func (s *Server) maybeCreateBatches(ctx context.Context, ec *model.ExportConfig,
 
 	batches := make([]*model.ExportBatch, 0, len(ranges))
 	for _, br := range ranges {
-		infoIds := make([]int64, len(ec.SignatureInfoIDs))
-		copy(infoIds, ec.SignatureInfoIDs)
+		sigInfoIDs := ec.OutputSignatureInfoIDs()
+		infoIds := make([]int64, len(sigInfoIDs))
+		copy(infoIds, sigInfoIDs)
 		batches = append(batches, &model.ExportBatch{
-			ConfigID:           ec.ConfigID,
-			BucketName:         ec.BucketName,
-			FilenameRoot:       ec.FilenameRoot,
-			StartTimestamp:     br.start,
-			EndTimestamp:       br.end,
-			OutputRegion:       ec.OutputRegion,
-			InputRegions:       ec.InputRegions,
-			IncludeTravelers:   ec.IncludeTravelers,
-			OnlyNonTravelers:   ec.OnlyNonTravelers,
-			ExcludeRegions:     ec.ExcludeRegions,
-			Status:             model.ExportBatchOpen,
-			SignatureInfoIDs:   infoIds,
-			MaxRecordsOverride: ec.MaxRecordsOverride,
+			ConfigID:                       ec.ConfigID,
+			BucketName:                     ec.BucketName,
+			FilenameRoot:                   ec.FilenameRoot,
+			StartTimestamp:                 br.start,
+			EndTimestamp:                   br.end,
+			OutputRegion:                   ec.OutputRegion,
+			InputRegions:                   ec.InputRegions,
+			IncludeTravelers:               ec.IncludeTravelers,
+			OnlyNonTravelers:               ec.OnlyNonTravelers,
+			ExcludeRegions:                 ec.ExcludeRegions,
+			Status:                         model.ExportBatchOpen,
+			SignatureInfoIDs:               infoIds,
+			MaxRecordsOverride:             ec.MaxRecordsOverride,
+			OnsetWindowMinDays:             ec.OnsetWindowMinDays,
+			OnsetWindowMaxDays:             ec.OnsetWindowMaxDays,
+			ExcludeMissingOnset:            ec.ExcludeMissingOnset,
+			OnlyRevisedKeys:                ec.OnlyRevisedKeys,
+			ExcludeRevisedKeys:             ec.ExcludeRevisedKeys,
+			IncludeCBOR:                    ec.IncludeCBOR,
+			MinTransmissionRisk:            ec.MinTransmissionRisk,
+			IncludeUnknownTransmissionRisk: ec.IncludeUnknownTransmissionRisk,
+			DefaultReportType:              ec.DefaultReportType,
+			ReportTypes:                    ec.ReportTypes,
+			IncludeUnknownReportType:       ec.IncludeUnknownReportType,
 		})
 	}
 
@@ -204,3 +225,37 @@ func makeBatchRanges(period time.Duration, latestEnd, now time.Time, truncateWin
 	}
 	return ranges
 }
+
+// makeScheduledBatchRanges is like makeBatchRanges, but batches start and end
+// at the times matching the schedule instead of every period. All batches
+// since latestEnd are created, so exports catch up after downtime. If
+// latestEnd doesn't match the schedule, the first batch starts at the
+// previous matching time, overlapping the last batch so no keys are missed.
+func makeScheduledBatchRanges(schedule *model.Schedule, latestEnd, now time.Time, truncateWindow time.Duration) []batchRange {
+	// We don't want any batches with an end date greater than the end of the
+	// exposure publish window.
+	publishEnd := publishmodel.TruncateWindow(now, truncateWindow)
+
+	// Special case: if there have not been batches before, return only the most
+	// recent complete one.
+	if latestEnd.Before(sanityDate) {
+		end := schedule.Prev(publishEnd)
+		start := schedule.Prev(end.Add(-time.Minute))
+		if end.IsZero() || start.IsZero() {
+			return nil
+		}
+		return []batchRange{{start: start.UTC(), end: end.UTC()}}
+	}
+
+	start := schedule.Prev(latestEnd)
+	if start.IsZero() {
+		return nil
+	}
+
+	var ranges []batchRange
+	for end := schedule.Next(start); !end.IsZero() && !end.After(publishEnd); end = schedule.Next(end) {
+		ranges = append(ranges, batchRange{start: start.UTC(), end: end.UTC()})
+		start = end
+	}
+	return ranges
+}
@@ -16,9 +16,12 @@ package export
 
 import (
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/google/exposure-notifications-server/internal/export/model"
 )
 
 type simpleBatchRange struct {
@@ -137,6 +140,102 @@ func TestMakeBatchRanges(t *testing.T) {
 	}
 }
 
+// TestMakeScheduledBatchRanges tests makeScheduledBatchRanges().
+func TestMakeScheduledBatchRanges(t *testing.T) {
+	t.Parallel()
+
+	// Midnight in New York is 05:00 UTC in December.
+	const (
+		utcDaily = "0 0 * * *"
+		nycDaily = "CRON_TZ=America/New_York 0 0 * * *"
+	)
+
+	now := "12-10 10:11"
+	testCases := []struct {
+		name      string
+		schedule  string
+		latestEnd string
+		want      []simpleBatchRange
+	}{
+		{
+			name:      "utc region with no previous batches",
+			schedule:  utcDaily,
+			latestEnd: "",
+			want:      []simpleBatchRange{{"12-09 00:00", "12-10 00:00"}},
+		},
+		{
+			name:      "new york region with no previous batches",
+			schedule:  nycDaily,
+			latestEnd: "",
+			want:      []simpleBatchRange{{"12-09 05:00", "12-10 05:00"}},
+		},
+		{
+			name:      "utc region up to date",
+			schedule:  utcDaily,
+			latestEnd: "12-10 00:00",
+		},
+		{
+			name:      "new york region up to date",
+			schedule:  nycDaily,
+			latestEnd: "12-10 05:00",
+		},
+		{
+			name:      "utc region catches up",
+			schedule:  utcDaily,
+			latestEnd: "12-08 00:00",
+			want:      []simpleBatchRange{{"12-08 00:00", "12-09 00:00"}, {"12-09 00:00", "12-10 00:00"}},
+		},
+		{
+			name:      "new york region catches up",
+			schedule:  nycDaily,
+			latestEnd: "12-08 05:00",
+			want:      []simpleBatchRange{{"12-08 05:00", "12-09 05:00"}, {"12-09 05:00", "12-10 05:00"}},
+		},
+		{
+			name:      "new batch overlaps with previous if misaligned",
+			schedule:  "0 */6 * * *",
+			latestEnd: "12-10 03:00",
+			want:      []simpleBatchRange{{"12-10 00:00", "12-10 06:00"}},
+		},
+		{
+			name:      "schedule switched from utc to new york",
+			schedule:  nycDaily,
+			latestEnd: "12-09 00:00",
+			want:      []simpleBatchRange{{"12-08 05:00", "12-09 05:00"}, {"12-09 05:00", "12-10 05:00"}},
+		},
+		{
+			name:      "frequent schedule doesn't overlap open publish window",
+			schedule:  "*/30 * * * *",
+			latestEnd: "12-10 09:00",
+			want:      []simpleBatchRange{{"12-10 09:00", "12-10 09:30"}, {"12-10 09:30", "12-10 10:00"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			schedule, err := model.ParseSchedule(tc.schedule)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			nowT := fromSimpleTime(t, now)
+			latestEndT := fromSimpleTime(t, tc.latestEnd)
+
+			got := toSimpleBatchRange(t, makeScheduledBatchRanges(schedule, latestEndT, nowT, time.Hour))
+			if len(got) == 0 && len(tc.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("unexpected batches, got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
 func fromSimpleTime(t *testing.T, s string) time.Time {
 	t.Helper()
 	if s == "" {
@@ -0,0 +1,89 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"fmt"
+
+	"github.com/ugorji/go/codec"
+)
+
+// cborHandle encodes and decodes the CBOR export files. Structs are encoded as
+// maps with their fields sorted by name, and integers and lengths in their
+// shortest form, so the same message always encodes to the same bytes.
+var cborHandle = &codec.CborHandle{}
+
+// The CBOR messages use the field names of the protocol buffers. Fields that
+// are unset in the protocol buffer are left out.
+
+type cborFile struct {
+	Header     string          `codec:"header"`
+	Payload    []byte          `codec:"payload"`
+	Signatures []cborSignature `codec:"signatures"`
+}
+
+type cborExport struct {
+	StartTimestamp *uint64             `codec:"start_timestamp,omitempty"`
+	EndTimestamp   *uint64             `codec:"end_timestamp,omitempty"`
+	Region         *string             `codec:"region,omitempty"`
+	BatchNum       *int32              `codec:"batch_num,omitempty"`
+	BatchSize      *int32              `codec:"batch_size,omitempty"`
+	SignatureInfos []cborSignatureInfo `codec:"signature_infos"`
+	Keys           []cborKey           `codec:"keys"`
+	RevisedKeys    []cborKey           `codec:"revised_keys"`
+}
+
+type cborKey struct {
+	KeyData                    []byte `codec:"key_data,omitempty"`
+	TransmissionRiskLevel      *int32 `codec:"transmission_risk_level,omitempty"`
+	RollingStartIntervalNumber *int32 `codec:"rolling_start_interval_number,omitempty"`
+	RollingPeriod              *int32 `codec:"rolling_period,omitempty"`
+	ReportType                 *int32 `codec:"report_type,omitempty"`
+	DaysSinceOnsetOfSymptoms   *int32 `codec:"days_since_onset_of_symptoms,omitempty"`
+}
+
+type cborSignatureInfo struct {
+	VerificationKeyVersion *string `codec:"verification_key_version,omitempty"`
+	VerificationKeyID      *string `codec:"verification_key_id,omitempty"`
+	SignatureAlgorithm     *string `codec:"signature_algorithm,omitempty"`
+}
+
+type cborSignature struct {
+	SignatureInfo *cborSignatureInfo `codec:"signature_info,omitempty"`
+	BatchNum      *int32             `codec:"batch_num,omitempty"`
+	BatchSize     *int32             `codec:"batch_size,omitempty"`
+	Signature     []byte             `codec:"signature,omitempty"`
+}
+
+// encodeCBOR encodes v as CBOR.
+func encodeCBOR(v interface{}) ([]byte, error) {
+	var b []byte
+	if err := codec.NewEncoderBytes(&b, cborHandle).Encode(v); err != nil {
+		return nil, fmt.Errorf("cbor: %w", err)
+	}
+	return b, nil
+}
+
+// decodeCBOR decodes a single CBOR item, which must span all of data, into v.
+func decodeCBOR(data []byte, v interface{}) error {
+	d := codec.NewDecoderBytes(data, cborHandle)
+	if err := d.Decode(v); err != nil {
+		return fmt.Errorf("cbor: %w", err)
+	}
+	if n := d.NumBytesRead(); n != len(data) {
+		return fmt.Errorf("cbor: decoded %d of %d bytes", n, len(data))
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	"github.com/google/exposure-notifications-server/internal/pb/export"
+	"github.com/google/exposure-notifications-server/internal/storage"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	cborSuffix        = ".cbor"
+	cborIndexFilename = "cbor-index.txt"
+
+	// cborHeader identifies a CBOR export file, like fixedHeader does for
+	// export.bin.
+	cborHeader = "EK Export CBOR v1"
+)
+
+// A CBOR export file is a map with the header, the payload and the
+// signatures:
+//
+//   {"header": "EK Export CBOR v1", "payload": bytes, "signatures": [...]}
+//
+// The payload is the CBOR encoded TemporaryExposureKeyExport, with the field
+// names of the protocol buffer, and every signature is a TEKSignature over
+// the SHA-256 digest of the payload. Fields that are unset in the protocol
+// buffer are left out.
+
+// CBORExportFile is a decoded CBOR export file.
+type CBORExportFile struct {
+	// Payload is the signed payload, as it was read from the file.
+	Payload    []byte
+	Export     *export.TemporaryExposureKeyExport
+	Signatures *export.TEKSignatureList
+}
+
+// cborFilename returns the name of the CBOR export file of an export file,
+// e.g. "root/1-2-00001.cbor" for "root/1-2-00001.zip".
+func cborFilename(objectName string) string {
+	return strings.TrimSuffix(objectName, filenameSuffix) + cborSuffix
+}
+
+// cborIndexObjectName returns the name of the index of the CBOR files next to
+// an index file.
+func cborIndexObjectName(indexObjectName string) string {
+	return path.Join(path.Dir(indexObjectName), cborIndexFilename)
+}
+
+// MarshalCBORExportFile encodes the export as a CBOR export file, signed by
+// the signers.
+func MarshalCBORExportFile(exp *export.TemporaryExposureKeyExport, signers []*Signer) ([]byte, error) {
+	payload, err := encodeCBOR(toCBORExport(exp))
+	if err != nil {
+		return nil, fmt.Errorf("encoding payload: %w", err)
+	}
+
+	digest := sha256.Sum256(payload)
+	sigs := make([]cborSignature, 0, len(signers))
+	for _, s := range signers {
+		sig, err := generateSignature(digest[:], s.Signer)
+		if err != nil {
+			return nil, fmt.Errorf("unable to generate signature: %w", err)
+		}
+		sigs = append(sigs, toCBORSignature(&export.TEKSignature{
+			SignatureInfo: createSignatureInfo(s.SignatureInfo),
+			BatchNum:      proto.Int32(1),
+			BatchSize:     proto.Int32(1),
+			Signature:     sig,
+		}))
+	}
+
+	return encodeCBOR(&cborFile{
+		Header:     cborHeader,
+		Payload:    payload,
+		Signatures: sigs,
+	})
+}
+
+// UnmarshalCBORExportFile decodes a CBOR export file. The signatures are not
+// verified, they are over the SHA-256 digest of the returned Payload.
+func UnmarshalCBORExportFile(data []byte) (*CBORExportFile, error) {
+	var f cborFile
+	if err := decodeCBOR(data, &f); err != nil {
+		return nil, err
+	}
+	if f.Header != cborHeader {
+		return nil, fmt.Errorf("unsupported cbor export header %q", f.Header)
+	}
+	if f.Payload == nil {
+		return nil, fmt.Errorf("cbor export file has no payload")
+	}
+
+	var exp cborExport
+	if err := decodeCBOR(f.Payload, &exp); err != nil {
+		return nil, fmt.Errorf("decoding payload: %w", err)
+	}
+
+	list := &export.TEKSignatureList{}
+	for _, sig := range f.Signatures {
+		list.Signatures = append(list.Signatures, sig.proto())
+	}
+
+	return &CBORExportFile{
+		Payload:    f.Payload,
+		Export:     exp.proto(),
+		Signatures: list,
+	}, nil
+}
+
+func toCBORExport(exp *export.TemporaryExposureKeyExport) *cborExport {
+	c := &cborExport{
+		StartTimestamp: exp.StartTimestamp,
+		EndTimestamp:   exp.EndTimestamp,
+		Region:         exp.Region,
+		BatchNum:       exp.BatchNum,
+		BatchSize:      exp.BatchSize,
+		SignatureInfos: make([]cborSignatureInfo, 0, len(exp.SignatureInfos)),
+		Keys:           toCBORKeys(exp.Keys),
+		RevisedKeys:    toCBORKeys(exp.RevisedKeys),
+	}
+	for _, si := range exp.SignatureInfos {
+		c.SignatureInfos = append(c.SignatureInfos, toCBORSignatureInfo(si))
+	}
+	return c
+}
+
+func toCBORKeys(keys []*export.TemporaryExposureKey) []cborKey {
+	c := make([]cborKey, 0, len(keys))
+	for _, k := range keys {
+		ck := cborKey{
+			KeyData:                    k.KeyData,
+			TransmissionRiskLevel:      k.TransmissionRiskLevel,
+			RollingStartIntervalNumber: k.RollingStartIntervalNumber,
+			RollingPeriod:              k.RollingPeriod,
+			DaysSinceOnsetOfSymptoms:   k.DaysSinceOnsetOfSymptoms,
+		}
+		if k.ReportType != nil {
+			ck.ReportType = proto.Int32(int32(k.GetReportType()))
+		}
+		c = append(c, ck)
+	}
+	return c
+}
+
+func toCBORSignatureInfo(si *export.SignatureInfo) cborSignatureInfo {
+	return cborSignatureInfo{
+		VerificationKeyVersion: si.VerificationKeyVersion,
+		VerificationKeyID:      si.VerificationKeyId,
+		SignatureAlgorithm:     si.SignatureAlgorithm,
+	}
+}
+
+func toCBORSignature(sig *export.TEKSignature) cborSignature {
+	c := cborSignature{
+		BatchNum:  sig.BatchNum,
+		BatchSize: sig.BatchSize,
+		Signature: sig.Signature,
+	}
+	if sig.SignatureInfo != nil {
+		si := toCBORSignatureInfo(sig.SignatureInfo)
+		c.SignatureInfo = &si
+	}
+	return c
+}
+
+func (c *cborExport) proto() *export.TemporaryExposureKeyExport {
+	exp := &export.TemporaryExposureKeyExport{
+		StartTimestamp: c.StartTimestamp,
+		EndTimestamp:   c.EndTimestamp,
+		Region:         c.Region,
+		BatchNum:       c.BatchNum,
+		BatchSize:      c.BatchSize,
+		Keys:           cborKeysProto(c.Keys),
+		RevisedKeys:    cborKeysProto(c.RevisedKeys),
+	}
+	for i := range c.SignatureInfos {
+		exp.SignatureInfos = append(exp.SignatureInfos, c.SignatureInfos[i].proto())
+	}
+	return exp
+}
+
+func cborKeysProto(c []cborKey) []*export.TemporaryExposureKey {
+	keys := make([]*export.TemporaryExposureKey, 0, len(c))
+	for _, ck := range c {
+		k := &export.TemporaryExposureKey{
+			KeyData:                    ck.KeyData,
+			TransmissionRiskLevel:      ck.TransmissionRiskLevel,
+			RollingStartIntervalNumber: ck.RollingStartIntervalNumber,
+			RollingPeriod:              ck.RollingPeriod,
+			DaysSinceOnsetOfSymptoms:   ck.DaysSinceOnsetOfSymptoms,
+		}
+		if ck.ReportType != nil {
+			k.ReportType = export.TemporaryExposureKey_ReportType(*ck.ReportType).Enum()
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func (c *cborSignatureInfo) proto() *export.SignatureInfo {
+	return &export.SignatureInfo{
+		VerificationKeyVersion: c.VerificationKeyVersion,
+		VerificationKeyId:      c.VerificationKeyID,
+		SignatureAlgorithm:     c.SignatureAlgorithm,
+	}
+}
+
+func (c *cborSignature) proto() *export.TEKSignature {
+	sig := &export.TEKSignature{
+		BatchNum:  c.BatchNum,
+		BatchSize: c.BatchSize,
+		Signature: c.Signature,
+	}
+	if c.SignatureInfo != nil {
+		sig.SignatureInfo = c.SignatureInfo.proto()
+	}
+	return sig
+}
+
+// writeCBORFile writes the CBOR export file of the export file, if the batch
+// includes CBOR files. The keys are read from the export file itself, so both
+// files always have the same keys, and the signature infos are those of the
+// signers, since a re-signed export file keeps its original payload.
+func (s *Server) writeCBORFile(ctx context.Context, eb *model.ExportBatch, objectName string, data []byte, signers []*Signer, attrs *storage.ObjectAttrs) error {
+	if !eb.IncludeCBOR {
+		return nil
+	}
+
+	exp, _, err := UnmarshalExportFile(data)
+	if err != nil {
+		return fmt.Errorf("reading export file: %w", err)
+	}
+	exp.SignatureInfos = make([]*export.SignatureInfo, 0, len(signers))
+	for _, si := range signers {
+		exp.SignatureInfos = append(exp.SignatureInfos, createSignatureInfo(si.SignatureInfo))
+	}
+	b, err := MarshalCBORExportFile(exp, signers)
+	if err != nil {
+		return fmt.Errorf("marshaling cbor export file: %w", err)
+	}
+
+	// The CBOR file is cached like the export file.
+	cborAttrs := storage.DefaultObjectAttrs(true, storage.ContentTypeCBOR)
+	if attrs != nil && attrs.CacheControl != "" {
+		cborAttrs.CacheControl = attrs.CacheControl
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, blobOperationTimeout)
+	defer cancel()
+	name := cborFilename(objectName)
+	if err := s.env.Blobstore().CreateObjectWithAttrs(ctx, eb.BucketName, name, b, cborAttrs); err != nil {
+		return fmt.Errorf("creating cbor export file %s in bucket %s: %w", name, eb.BucketName, err)
+	}
+	return nil
+}
+
+// writeCBORIndex writes the index of the CBOR files next to the index. Not
+// every export file has a CBOR file, e.g. those written before CBOR files
+// were enabled, so the index lists the CBOR files of the previous CBOR index
+// and those of the new objects, if the batch includes CBOR files, that are
+// still in the index. Nothing is written if there are no CBOR files.
+func (s *Server) writeCBORIndex(ctx context.Context, eb *model.ExportBatch, indexObjectName string, objects, newObjectNames []string, attrs *storage.ObjectAttrs) error {
+	cborIndex := cborIndexObjectName(indexObjectName)
+	previous, err := s.readIndex(ctx, eb.BucketName, cborIndex)
+	if err != nil {
+		return err
+	}
+	if previous == nil && !eb.IncludeCBOR {
+		return nil
+	}
+
+	written := make(map[string]struct{}, len(previous)+len(newObjectNames))
+	for _, name := range previous {
+		written[name] = struct{}{}
+	}
+	if eb.IncludeCBOR {
+		for _, name := range newObjectNames {
+			written[cborFilename(name)] = struct{}{}
+		}
+	}
+
+	entries := make([]string, 0, len(written))
+	for _, o := range objects {
+		name := cborFilename(o)
+		if _, ok := written[name]; ok {
+			entries = append(entries, name)
+		}
+	}
+
+	return s.writeIndexFile(ctx, eb.BucketName, cborIndex, entries, attrs)
+}
@@ -0,0 +1,368 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	"github.com/google/exposure-notifications-server/internal/project"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/exposure-notifications-server/internal/storage"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
+	"github.com/google/exposure-notifications-server/pkg/errcmp"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+func TestCBORFilename(t *testing.T) {
+	t.Parallel()
+
+	if got, want := cborFilename("root/1-2-00001.zip"), "root/1-2-00001.cbor"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+	if got, want := cborIndexObjectName("root/index.txt"), "root/cbor-index.txt"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+}
+
+func TestMarshalUnmarshalCBORExportFile(t *testing.T) {
+	t.Parallel()
+
+	batch := &model.ExportBatch{
+		StartTimestamp: time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC),
+		EndTimestamp:   time.Date(2020, 5, 1, 1, 0, 0, 0, time.UTC),
+		OutputRegion:   "US",
+	}
+	exposures := []*publishmodel.Exposure{
+		{
+			ExposureKey:           []byte("ABC"),
+			IntervalNumber:        18,
+			IntervalCount:         144,
+			TransmissionRisk:      8,
+			DaysSinceSymptomOnset: proto.Int32(-3),
+			ReportType:            verifyapi.ReportTypeConfirmed,
+		},
+		{
+			ExposureKey:      []byte("DEF"),
+			IntervalNumber:   118,
+			IntervalCount:    1,
+			TransmissionRisk: 1,
+		},
+	}
+	revisedExposures := []*publishmodel.Exposure{
+		{
+			ExposureKey:                  []byte("123"),
+			IntervalNumber:               100,
+			IntervalCount:                144,
+			TransmissionRisk:             4,
+			RevisedReportType:            proto.String(verifyapi.ReportTypeNegative),
+			RevisedDaysSinceSymptomOnset: proto.Int32(2),
+		},
+	}
+	signers := []*Signer{
+		newTestSigner(t, "310", "v1"),
+		newTestSigner(t, "311", "v2"),
+	}
+
+	zipped, err := MarshalExportFile(batch, exposures, revisedExposures, 1, false, signers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _, err := UnmarshalExportFile(zipped)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := MarshalCBORExportFile(want, signers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := UnmarshalCBORExportFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The CBOR file has the same keys and header as the protobuf file.
+	if diff := cmp.Diff(want, got.Export, protocmp.Transform()); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// The signatures verify the payload.
+	if got, want := len(got.Signatures.Signatures), len(signers); got != want {
+		t.Fatalf("expected %d signatures, got %d", want, got)
+	}
+	digest := sha256.Sum256(got.Payload)
+	for i, sig := range got.Signatures.Signatures {
+		info := sig.GetSignatureInfo()
+		if got, want := info.GetVerificationKeyId(), signers[i].SignatureInfo.SigningKeyID; got != want {
+			t.Errorf("expected verification key id %q to be %q", got, want)
+		}
+		if got, want := info.GetVerificationKeyVersion(), signers[i].SignatureInfo.SigningKeyVersion; got != want {
+			t.Errorf("expected verification key version %q to be %q", got, want)
+		}
+		if got, want := info.GetSignatureAlgorithm(), algorithm; got != want {
+			t.Errorf("expected signature algorithm %q to be %q", got, want)
+		}
+		if got, want := sig.GetBatchNum(), int32(1); got != want {
+			t.Errorf("expected batch num %d to be %d", got, want)
+		}
+		pub := signers[i].Signer.Public().(*ecdsa.PublicKey)
+		if !ecdsa.VerifyASN1(pub, digest[:], sig.GetSignature()) {
+			t.Errorf("signature %d does not verify", i)
+		}
+	}
+
+	// A modified payload doesn't verify.
+	tampered := append([]byte(nil), got.Payload...)
+	tampered[len(tampered)-1] ^= 1
+	tamperedDigest := sha256.Sum256(tampered)
+	if ecdsa.VerifyASN1(signers[0].Signer.Public().(*ecdsa.PublicKey), tamperedDigest[:], got.Signatures.Signatures[0].GetSignature()) {
+		t.Errorf("expected signature to not verify a modified payload")
+	}
+
+	// The encoding is deterministic, only the signatures differ.
+	again, err := MarshalCBORExportFile(want, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned, err := UnmarshalCBORExportFile(again)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got.Payload, unsigned.Payload); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestUnmarshalCBORExportFile_Errors(t *testing.T) {
+	t.Parallel()
+
+	encode := func(v interface{}) []byte {
+		b, err := encodeCBOR(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	payload := func(v interface{}) []byte {
+		return encode(map[string]interface{}{
+			"header":  cborHeader,
+			"payload": encode(v),
+		})
+	}
+
+	cases := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{
+			name: "not_cbor",
+			data: []byte("PK"),
+			err:  "cbor:",
+		},
+		{
+			name: "not_map",
+			data: encode(1),
+			err:  "cbor:",
+		},
+		{
+			name: "trailing_bytes",
+			data: append(encode(map[string]interface{}{"header": cborHeader}), 0x01),
+			err:  "cbor: decoded",
+		},
+		{
+			name: "wrong_header",
+			data: encode(map[string]interface{}{"header": "EK Export v1"}),
+			err:  "unsupported cbor export header",
+		},
+		{
+			name: "missing_payload",
+			data: encode(map[string]interface{}{"header": cborHeader}),
+			err:  "has no payload",
+		},
+		{
+			name: "wrong_field_type",
+			data: payload(map[string]interface{}{"region": 1}),
+			err:  "decoding payload: cbor:",
+		},
+		{
+			name: "out_of_range",
+			data: payload(map[string]interface{}{
+				"keys": []interface{}{
+					map[string]interface{}{"rolling_period": uint64(1 << 40)},
+				},
+			}),
+			err: "decoding payload: cbor:",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := UnmarshalCBORExportFile(tc.data)
+			errcmp.MustMatch(t, err, tc.err)
+		})
+	}
+}
+
+func TestWriteCBORFile(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now().UTC().Truncate(time.Hour)
+	exposures := []*publishmodel.Exposure{
+		{
+			ExposureKey:    randomTEK(t),
+			IntervalNumber: 100,
+			IntervalCount:  144,
+		},
+	}
+	signers := []*Signer{newTestSigner(t, "310", "v1")}
+
+	cases := []struct {
+		name        string
+		includeCBOR bool
+	}{
+		{name: "enabled", includeCBOR: true},
+		{name: "disabled", includeCBOR: false},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := project.TestContext(t)
+
+			blobstore, err := storage.NewMemory(ctx, &storage.Config{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := &Server{
+				config: &Config{},
+				env:    serverenv.New(ctx, serverenv.WithBlobStorage(blobstore)),
+			}
+
+			eb := &model.ExportBatch{
+				BatchID:        1,
+				BucketName:     "bucket",
+				FilenameRoot:   "root",
+				StartTimestamp: now.Add(-time.Hour),
+				EndTimestamp:   now,
+				OutputRegion:   "US",
+				IncludeCBOR:    tc.includeCBOR,
+			}
+			data, err := MarshalExportFile(eb, exposures, nil, 1, false, signers)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			objectName := exportFilename(eb, 1, 0)
+			if _, err := s.writeExportFile(ctx, eb, objectName, data, len(exposures), signers, storage.DefaultObjectAttrs(true, storage.ContentTypeZip)); err != nil {
+				t.Fatal(err)
+			}
+
+			b, err := blobstore.GetObject(ctx, eb.BucketName, cborFilename(objectName))
+			if !tc.includeCBOR {
+				if !errors.Is(err, storage.ErrNotFound) {
+					t.Errorf("expected no cbor file, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := UnmarshalCBORExportFile(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, _, err := UnmarshalExportFile(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(want, got.Export, protocmp.Transform()); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWriteCBORIndex(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	blobstore, err := storage.NewMemory(ctx, &storage.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		config: &Config{},
+		env:    serverenv.New(ctx, serverenv.WithBlobStorage(blobstore)),
+	}
+	attrs := storage.DefaultObjectAttrs(false, storage.ContentTypeTextPlain)
+	indexObjectName := "root/index.txt"
+	cborIndex := cborIndexObjectName(indexObjectName)
+
+	// Without CBOR files, no index is written.
+	eb := &model.ExportBatch{BucketName: "bucket", FilenameRoot: "root"}
+	if err := s.writeCBORIndex(ctx, eb, indexObjectName, []string{"root/1-2-00001.zip"}, []string{"root/1-2-00001.zip"}, attrs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blobstore.GetObject(ctx, eb.BucketName, cborIndex); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected no cbor index, got %v", err)
+	}
+
+	// Once enabled, only the new files have CBOR files.
+	eb.IncludeCBOR = true
+	objects := []string{"root/1-2-00001.zip", "root/2-3-00001.zip", "root/2-3-00002.zip"}
+	if err := s.writeCBORIndex(ctx, eb, indexObjectName, objects, objects[1:], attrs); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.readIndex(ctx, eb.BucketName, cborIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"root/2-3-00001.cbor", "root/2-3-00002.cbor"}, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// After disabling CBOR files, the existing CBOR files stay listed until
+	// their export files expire.
+	eb.IncludeCBOR = false
+	objects = []string{"root/2-3-00002.zip", "root/3-4-00001.zip"}
+	if err := s.writeCBORIndex(ctx, eb, indexObjectName, objects, objects[1:], attrs); err != nil {
+		t.Fatal(err)
+	}
+	got, err = s.readIndex(ctx, eb.BucketName, cborIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"root/2-3-00002.cbor"}, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
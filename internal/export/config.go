@@ -16,14 +16,21 @@
 package export
 
 import (
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/google/exposure-notifications-server/internal/httpclient"
+	"github.com/google/exposure-notifications-server/internal/middleware"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
 	"github.com/google/exposure-notifications-server/internal/setup"
+	"github.com/google/exposure-notifications-server/internal/statssink"
 	"github.com/google/exposure-notifications-server/internal/storage"
 	"github.com/google/exposure-notifications-server/pkg/database"
 	"github.com/google/exposure-notifications-server/pkg/keys"
 	"github.com/google/exposure-notifications-server/pkg/observability"
 	"github.com/google/exposure-notifications-server/pkg/secrets"
+	"github.com/google/exposure-notifications-server/pkg/server"
 )
 
 // Compile-time check to assert this config matches requirements.
@@ -33,6 +40,7 @@ var (
 	_ setup.KeyManagerConfigProvider            = (*Config)(nil)
 	_ setup.SecretManagerConfigProvider         = (*Config)(nil)
 	_ setup.ObservabilityExporterConfigProvider = (*Config)(nil)
+	_ setup.StatsSinkConfigProvider             = (*Config)(nil)
 )
 
 // Config represents the configuration and associated environment variables for
@@ -43,6 +51,11 @@ type Config struct {
 	SecretManager         secrets.Config
 	Storage               storage.Config
 	ObservabilityExporter observability.Config
+	StatsSink             statssink.Config
+	HTTPClient            httpclient.Config
+	TLS                   server.TLSConfig
+	HTTP                  server.HTTPConfig
+	SecurityHeaders       middleware.SecurityHeadersConfig
 
 	Port               string        `env:"PORT, default=8080"`
 	CreateTimeout      time.Duration `env:"CREATE_BATCHES_TIMEOUT, default=5m"`
@@ -54,6 +67,123 @@ type Config struct {
 	TruncateWindow     time.Duration `env:"TRUNCATE_WINDOW, default=1h"`
 	MinWindowAge       time.Duration `env:"MIN_WINDOW_AGE, default=2h"`
 	TTL                time.Duration `env:"CLEANUP_TTL, default=336h"`
+
+	// ExportActivationDelay holds newly published keys back from export, so
+	// that keys revised shortly after they are published are only exported
+	// once. A batch is not leased until its end is older than the delay, which
+	// means every key in it is too. Filtering the keys instead would drop them
+	// from the export entirely, as later batches cover later keys.
+	ExportActivationDelay time.Duration `env:"EXPORT_ACTIVATION_DELAY, default=0"`
+
+	// WorkerConcurrency is the number of export batches a worker processes at
+	// the same time. Batches that cover the same regions are still processed
+	// one after another. Each concurrent batch signs and writes its own files,
+	// so this also bounds the load on the key manager and blob storage.
+	WorkerConcurrency uint `env:"EXPORT_WORKER_CONCURRENCY, default=1"`
+
+	// StreamExportFiles writes the export files of a batch as the keys are read
+	// from the database, in key order, so that memory use doesn't grow with the
+	// size of the batch. The keys are counted before they are written, so the
+	// keys are read twice. When disabled, all keys of the batch are loaded
+	// before the files are written.
+	StreamExportFiles bool `env:"EXPORT_STREAM_FILES, default=true"`
+
+	// ExportEmptyBatches writes a signed export file without keys, and lists it
+	// in the index, for a batch that has no keys. Otherwise such a batch
+	// writes no files, and clients can't tell an empty window from one that
+	// wasn't exported yet. The empty file isn't padded to
+	// EXPORT_FILE_MIN_RECORDS; padding only applies to batches with keys.
+	ExportEmptyBatches bool `env:"EXPORT_EMPTY_BATCHES, default=false"`
+
+	// SignMaxAttempts is the maximum number of key manager sign calls made for a
+	// single signature. Calls that the key manager throttles are retried with
+	// exponential backoff, starting at SignRetryBackoff and capped at
+	// SignRetryMaxBackoff, with SignRetryJitterPercent of jitter. Other errors
+	// are not retried and fail the batch. A value of 1 disables retries.
+	SignMaxAttempts        uint          `env:"EXPORT_SIGN_MAX_ATTEMPTS, default=5"`
+	SignRetryBackoff       time.Duration `env:"EXPORT_SIGN_RETRY_BACKOFF, default=200ms"`
+	SignRetryMaxBackoff    time.Duration `env:"EXPORT_SIGN_RETRY_MAX_BACKOFF, default=5s"`
+	SignRetryJitterPercent uint64        `env:"EXPORT_SIGN_RETRY_JITTER_PERCENT, default=20"`
+
+	// WebhookURL, if set, receives a POST request after each batch with files
+	// is exported and the index is updated. The payload is signed with
+	// HMAC-SHA256 using WebhookSecret, see webhookSignatureHeader. Requests
+	// that fail are retried up to WebhookMaxAttempts with exponential backoff,
+	// starting at WebhookRetryBackoff. Delivery failures are logged, but don't
+	// fail the export. The file URLs in the payload start with
+	// WebhookFileBaseURL, e.g. the CDN that serves the bucket.
+	WebhookURL          string        `env:"EXPORT_WEBHOOK_URL"`
+	WebhookSecret       string        `env:"EXPORT_WEBHOOK_SECRET"`
+	WebhookFileBaseURL  string        `env:"EXPORT_WEBHOOK_FILE_BASE_URL"`
+	WebhookTimeout      time.Duration `env:"EXPORT_WEBHOOK_TIMEOUT, default=10s"`
+	WebhookMaxAttempts  uint          `env:"EXPORT_WEBHOOK_MAX_ATTEMPTS, default=3"`
+	WebhookRetryBackoff time.Duration `env:"EXPORT_WEBHOOK_RETRY_BACKOFF, default=1s"`
+
+	// DeltaWindows, if set, writes a delta export for each window after every
+	// batch, with the keys published or revised in the window before the end
+	// of the batch. Clients that already have the keys up to the start of a
+	// window can download its delta instead of every batch since. A delta is
+	// signed like the batch and written under <root>/delta/<window>/, with an
+	// index.txt that only lists the current delta files. Each window must be a
+	// whole number of hours, e.g. "24h,72h".
+	DeltaWindows []time.Duration `env:"EXPORT_DELTA_WINDOWS"`
+
+	// IndexMaxEntries, if set, caps the number of export files listed in the
+	// index of an export config. New files are added to index.txt, and once
+	// it is full, its oldest files roll over to a new page: index-1.txt, then
+	// index-2.txt and so on. Pages only change when their files expire, so
+	// they can be cached. Each index file that has older files ends with the
+	// name of the next older one, so clients can page backward by following
+	// entries that end in .txt, until a page doesn't exist. Zero lists all
+	// files in index.txt.
+	IndexMaxEntries int `env:"EXPORT_INDEX_MAX_ENTRIES, default=0"`
+
+	// SignatureSidecar writes a JSON description of the signatures of each
+	// export file next to it, e.g. "root/1-2-00001.sig.json" for
+	// "root/1-2-00001.zip", for tooling that doesn't parse the export.sig. The
+	// sidecars are listed in "signatures.txt" next to each index.txt, in the
+	// same order, and are rewritten when the files are re-signed.
+	SignatureSidecar bool `env:"EXPORT_SIGNATURE_SIDECAR, default=false"`
+
+	// ReconcileTimeout bounds a single run of the index reconciliation. When
+	// ReconcileDryRun is set, discrepancies between each index and the files in
+	// storage and the database are logged and reported as metrics, but the
+	// index isn't rewritten.
+	ReconcileTimeout time.Duration `env:"RECONCILE_INDEX_TIMEOUT, default=5m"`
+	ReconcileDryRun  bool          `env:"RECONCILE_INDEX_DRY_RUN, default=true"`
+
+	// ResignTimeout bounds a single request to re-sign existing batches. Larger
+	// ranges are resumed with additional requests.
+	ResignTimeout time.Duration `env:"RESIGN_BATCHES_TIMEOUT, default=5m"`
+
+	// RevisionChangelogBucket, if set, enables writing a daily changelog of
+	// revisions to the bucket, with the number of keys per region whose report
+	// type changed from one type to another that day. The changelog for a UTC
+	// day is written to <RevisionChangelogFilenameRoot>/<YYYY-MM-DD>.json. If
+	// RevisionChangelogSignatureInfoID is set, it is signed with that signature
+	// info and the signature is written next to it, as <YYYY-MM-DD>.sig.json.
+	RevisionChangelogBucket          string        `env:"EXPORT_REVISION_CHANGELOG_BUCKET"`
+	RevisionChangelogFilenameRoot    string        `env:"EXPORT_REVISION_CHANGELOG_FILENAME_ROOT, default=revisions"`
+	RevisionChangelogSignatureInfoID int64         `env:"EXPORT_REVISION_CHANGELOG_SIGNATURE_INFO_ID, default=0"`
+	RevisionChangelogTimeout         time.Duration `env:"EXPORT_REVISION_CHANGELOG_TIMEOUT, default=5m"`
+
+	// RegionAliases maps a source region code to the export region that should
+	// also include its keys. This is used when regions are merged
+	// administratively, e.g. "OLD1:NEW,OLD2:NEW" causes the exports for "NEW"
+	// to include keys tagged with "OLD1" or "OLD2".
+	RegionAliases map[string]string `env:"EXPORT_REGION_ALIASES"`
+
+	// MissingRegionPolicy and DefaultRegion must match the publish server. If
+	// the policy is "default", keys without a region are exported as if they
+	// were in DefaultRegion. If it is "reject", they are never exported.
+	MissingRegionPolicy publishmodel.MissingRegionPolicy `env:"MISSING_REGION_POLICY, default=default"`
+	DefaultRegion       string                           `env:"DEFAULT_REGION"`
+
+	// RegionCanonicalMapping must match the publish server. The input and
+	// exclude regions of export batches are normalized with it, so they match
+	// the canonical regions that keys are stored under.
+	RegionCanonicalMapping map[string]string `env:"REGION_CANONICAL_MAPPING"`
+
 	// ReprocessCount needs to be incremented by one every time you go back and
 	// regenerate previously exported files.
 	ReprocessCount uint `env:"REPROCESS_COUNT, default=0"`
@@ -63,6 +193,61 @@ func (c *Config) RepressGeneration() int64 {
 	return int64(c.ReprocessCount)
 }
 
+// ExpandRegionAliases returns the provided regions plus any source regions
+// that are aliased to one of them. The original order is preserved and
+// aliased regions are appended in sorted order.
+func (c *Config) ExpandRegionAliases(regions []string) []string {
+	if len(c.RegionAliases) == 0 {
+		return regions
+	}
+
+	seen := make(map[string]struct{}, len(regions))
+	for _, r := range regions {
+		seen[strings.ToUpper(r)] = struct{}{}
+	}
+
+	var aliased []string
+	for source, target := range c.RegionAliases {
+		source = strings.ToUpper(source)
+		if _, ok := seen[strings.ToUpper(target)]; !ok {
+			continue
+		}
+		if _, ok := seen[source]; ok {
+			continue
+		}
+		aliased = append(aliased, source)
+	}
+	if len(aliased) == 0 {
+		return regions
+	}
+	sort.Strings(aliased)
+
+	result := make([]string, 0, len(regions)+len(aliased))
+	result = append(result, regions...)
+	return append(result, aliased...)
+}
+
+// IncludeMissingRegions returns true if keys without a region belong in an
+// export of the include regions, less the exclude regions, based on the missing
+// region policy.
+func (c *Config) IncludeMissingRegions(include, exclude []string) bool {
+	region := c.MissingRegionPolicy.Region(c.DefaultRegion)
+	if region == "" {
+		return false
+	}
+	for _, r := range exclude {
+		if r == region {
+			return false
+		}
+	}
+	for _, r := range include {
+		if r == region {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Config) BlobstoreConfig() *storage.Config {
 	return &c.Storage
 }
@@ -82,3 +267,7 @@ func (c *Config) SecretManagerConfig() *secrets.Config {
 func (c *Config) ObservabilityExporterConfig() *observability.Config {
 	return &c.ObservabilityExporter
 }
+
+func (c *Config) StatsSinkConfig() *statssink.Config {
+	return &c.StatsSink
+}
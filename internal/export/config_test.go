@@ -0,0 +1,139 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"testing"
+
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExpandRegionAliases(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		aliases map[string]string
+		regions []string
+		want    []string
+	}{
+		{
+			name:    "no_aliases",
+			regions: []string{"US"},
+			want:    []string{"US"},
+		},
+		{
+			name:    "unrelated_aliases",
+			aliases: map[string]string{"OLD": "NEW"},
+			regions: []string{"US"},
+			want:    []string{"US"},
+		},
+		{
+			name:    "merged_region",
+			aliases: map[string]string{"OLDB": "NEW", "OLDA": "NEW", "OTHER": "ELSEWHERE"},
+			regions: []string{"NEW"},
+			want:    []string{"NEW", "OLDA", "OLDB"},
+		},
+		{
+			name:    "already_present",
+			aliases: map[string]string{"OLDA": "NEW", "OLDB": "NEW"},
+			regions: []string{"NEW", "OLDA"},
+			want:    []string{"NEW", "OLDA", "OLDB"},
+		},
+		{
+			name:    "case_insensitive",
+			aliases: map[string]string{"olda": "new"},
+			regions: []string{"NEW"},
+			want:    []string{"NEW", "OLDA"},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := &Config{RegionAliases: tc.aliases}
+			got := cfg.ExpandRegionAliases(tc.regions)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestIncludeMissingRegions(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name          string
+		policy        publishmodel.MissingRegionPolicy
+		defaultRegion string
+		include       []string
+		exclude       []string
+		want          bool
+	}{
+		{
+			name:          "default_applied",
+			policy:        publishmodel.MissingRegionDefault,
+			defaultRegion: "US",
+			include:       []string{"US"},
+			want:          true,
+		},
+		{
+			name:    "default_not_configured",
+			policy:  publishmodel.MissingRegionDefault,
+			include: []string{"US"},
+			want:    false,
+		},
+		{
+			name:          "other_region",
+			policy:        publishmodel.MissingRegionDefault,
+			defaultRegion: "US",
+			include:       []string{"CA"},
+			want:          false,
+		},
+		{
+			name:          "default_excluded",
+			policy:        publishmodel.MissingRegionDefault,
+			defaultRegion: "US",
+			include:       []string{"US"},
+			exclude:       []string{"US"},
+			want:          false,
+		},
+		{
+			name:          "reject",
+			policy:        publishmodel.MissingRegionReject,
+			defaultRegion: "US",
+			include:       []string{"US"},
+			want:          false,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := &Config{MissingRegionPolicy: tc.policy, DefaultRegion: tc.defaultRegion}
+			if got := cfg.IncludeMissingRegions(tc.include, tc.exclude); got != tc.want {
+				t.Errorf("expected %t to be %t", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	"github.com/google/exposure-notifications-server/internal/pb/export"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"google.golang.org/protobuf/proto"
+)
+
+// contentHasher computes the content hash of a batch: the hash of what its
+// export files contain, apart from the batch window and the padding keys,
+// which are random. Two batches with the same hash export the same keys, in
+// the same region, signed with the same keys.
+//
+// The keys are hashed as they are written to the export files, and the hashes
+// are combined independently of the order of the keys, so the content hash is
+// the same whether the keys are streamed or loaded at once.
+type contentHasher struct {
+	defaultReportType string
+
+	// sum is the sum of the hashes of the keys, as 256 bit integers.
+	sum         big.Int
+	keys        int
+	revisedKeys int
+}
+
+func newContentHasher(eb *model.ExportBatch) *contentHasher {
+	return &contentHasher{defaultReportType: eb.DefaultReportType}
+}
+
+// add adds a new or revised key to the content.
+func (h *contentHasher) add(exp *publishmodel.Exposure, revised bool) error {
+	pbek := makeExportTEK(exp, h.defaultReportType)
+	if revised {
+		pbek = makeRevisedTEK(exp, h.defaultReportType)
+	}
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(pbek)
+	if err != nil {
+		return fmt.Errorf("marshaling key for content hash: %w", err)
+	}
+
+	// A key is exported differently as a new or revised key.
+	kind := byte(0)
+	if revised {
+		kind = 1
+		h.revisedKeys++
+	} else {
+		h.keys++
+	}
+	digest := sha256.Sum256(append([]byte{kind}, b...))
+	h.sum.Add(&h.sum, new(big.Int).SetBytes(digest[:]))
+	return nil
+}
+
+// hash returns the base64 encoded content hash of the batch with the keys
+// added so far.
+func (h *contentHasher) hash(eb *model.ExportBatch, sigInfos []*model.SignatureInfo) (string, error) {
+	header := &export.TemporaryExposureKeyExport{
+		Region:         proto.String(eb.OutputRegion),
+		SignatureInfos: make([]*export.SignatureInfo, 0, len(sigInfos)),
+	}
+	for _, si := range sigInfos {
+		header.SignatureInfos = append(header.SignatureInfos, createSignatureInfo(si))
+	}
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(header)
+	if err != nil {
+		return "", fmt.Errorf("marshaling header for content hash: %w", err)
+	}
+
+	d := sha256.New()
+	d.Write(b)
+	var counts [16]byte
+	binary.BigEndian.PutUint64(counts[:8], uint64(h.keys))
+	binary.BigEndian.PutUint64(counts[8:], uint64(h.revisedKeys))
+	d.Write(counts[:])
+	d.Write(h.sum.Bytes())
+	return base64.StdEncoding.EncodeToString(d.Sum(nil)), nil
+}
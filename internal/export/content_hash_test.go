@@ -0,0 +1,234 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"testing"
+	"time"
+
+	exportdatabase "github.com/google/exposure-notifications-server/internal/export/database"
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	"github.com/google/exposure-notifications-server/internal/project"
+	publishdb "github.com/google/exposure-notifications-server/internal/publish/database"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/exposure-notifications-server/internal/storage"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1alpha1"
+	"github.com/google/exposure-notifications-server/pkg/keys"
+)
+
+func TestContentHasher(t *testing.T) {
+	t.Parallel()
+
+	eb := &model.ExportBatch{OutputRegion: "US", DefaultReportType: verifyapi.ReportTypeConfirmed}
+	sigInfos := []*model.SignatureInfo{{ID: 1, SigningKeyID: "310", SigningKeyVersion: "v1"}}
+
+	newExposure := func(intervalNumber int32) *publishmodel.Exposure {
+		return &publishmodel.Exposure{
+			ExposureKey:    randomTEK(t),
+			IntervalNumber: intervalNumber,
+			IntervalCount:  144,
+			ReportType:     verifyapi.ReportTypeConfirmed,
+		}
+	}
+	a, b, c := newExposure(100), newExposure(244), newExposure(388)
+
+	hash := func(eb *model.ExportBatch, sigInfos []*model.SignatureInfo, exposures, revised []*publishmodel.Exposure) string {
+		t.Helper()
+
+		h := newContentHasher(eb)
+		for _, exp := range exposures {
+			if err := h.add(exp, false); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, exp := range revised {
+			if err := h.add(exp, true); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := h.hash(eb, sigInfos)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	want := hash(eb, sigInfos, []*publishmodel.Exposure{a, b}, []*publishmodel.Exposure{c})
+
+	// The batch window isn't part of the content, nor is the order of the keys.
+	other := *eb
+	other.StartTimestamp = time.Unix(1600000000, 0)
+	other.EndTimestamp = time.Unix(1600003600, 0)
+	if got := hash(&other, sigInfos, []*publishmodel.Exposure{b, a}, []*publishmodel.Exposure{c}); got != want {
+		t.Errorf("expected the same content hash for reordered keys, got %q and %q", got, want)
+	}
+
+	region := *eb
+	region.OutputRegion = "CA"
+	changed := map[string]string{
+		"missing_key":    hash(eb, sigInfos, []*publishmodel.Exposure{a}, []*publishmodel.Exposure{c}),
+		"revised_as_new": hash(eb, sigInfos, []*publishmodel.Exposure{a, b, c}, nil),
+		"region":         hash(&region, sigInfos, []*publishmodel.Exposure{a, b}, []*publishmodel.Exposure{c}),
+		"signature_info": hash(eb, []*model.SignatureInfo{{ID: 1, SigningKeyID: "310", SigningKeyVersion: "v2"}}, []*publishmodel.Exposure{a, b}, []*publishmodel.Exposure{c}),
+	}
+	for name, got := range changed {
+		if got == want {
+			t.Errorf("%s: expected the content hash to change", name)
+		}
+	}
+}
+
+func TestExportBatchContentHash(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		stream bool
+	}{
+		{name: "buffered"},
+		{name: "stream", stream: true},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := project.TestContext(t)
+			testDB, _ := testDatabaseInstance.NewDatabase(t)
+			exportDB := exportdatabase.New(testDB)
+			baseTime := time.Now().UTC().Add(-48 * time.Hour).Truncate(time.Hour)
+
+			root := t.TempDir()
+			kms, err := keys.NewFilesystem(ctx, &keys.Config{FilesystemRoot: root})
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeTestSigningKey(t, root, "key")
+			si := &model.SignatureInfo{
+				SigningKey:        "key",
+				SigningKeyID:      "key",
+				SigningKeyVersion: "1",
+			}
+			if err := exportDB.AddSignatureInfo(ctx, si); err != nil {
+				t.Fatal(err)
+			}
+
+			ec := &model.ExportConfig{
+				BucketName:       "bucket",
+				FilenameRoot:     "us",
+				Period:           time.Hour,
+				OutputRegion:     "US",
+				InputRegions:     []string{"US"},
+				From:             baseTime,
+				SignatureInfoIDs: []int64{si.ID},
+			}
+			if err := exportDB.AddExportConfig(ctx, ec); err != nil {
+				t.Fatal(err)
+			}
+
+			// The third window has a key, the first two are empty.
+			if _, err := publishdb.New(testDB).InsertAndReviseExposures(ctx, &publishdb.InsertAndReviseExposuresRequest{
+				Incoming: []*publishmodel.Exposure{
+					{
+						ExposureKey:     randomTEK(t),
+						Regions:         []string{"US"},
+						IntervalNumber:  100,
+						IntervalCount:   144,
+						CreatedAt:       baseTime.Add(2*time.Hour + time.Minute),
+						LocalProvenance: true,
+						ReportType:      verifyapi.ReportTypeConfirmed,
+					},
+				},
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			blobstore, err := storage.NewMemory(ctx, &storage.Config{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			server := &Server{
+				config: &Config{
+					MaxRecords:         100,
+					TTL:                14 * 24 * time.Hour,
+					StreamExportFiles:  tc.stream,
+					ExportEmptyBatches: true,
+				},
+				env: serverenv.New(ctx,
+					serverenv.WithDatabase(testDB),
+					serverenv.WithBlobStorage(blobstore),
+					serverenv.WithKeyManager(kms)),
+			}
+
+			// The first two batches write the empty file since empty batches are
+			// exported. The third has a key. Each batch stores its content hash.
+			for i, wantFiles := range []int{1, 1, 1} {
+				start := baseTime.Add(time.Duration(i) * time.Hour)
+				if err := exportDB.AddExportBatches(ctx, []*model.ExportBatch{
+					{
+						ConfigID:         ec.ConfigID,
+						BucketName:       ec.BucketName,
+						FilenameRoot:     ec.FilenameRoot,
+						StartTimestamp:   start,
+						EndTimestamp:     start.Add(time.Hour),
+						OutputRegion:     ec.OutputRegion,
+						InputRegions:     ec.InputRegions,
+						Status:           model.ExportBatchOpen,
+						SignatureInfoIDs: ec.SignatureInfoIDs,
+					},
+				}); err != nil {
+					t.Fatal(err)
+				}
+				eb, err := exportDB.LeaseBatch(ctx, time.Hour, time.Now(), time.Now())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if eb == nil {
+					t.Fatalf("batch %d: expected a batch to lease", i)
+				}
+
+				files, err := server.exportBatch(ctx, eb, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := len(files.objectNames); got != wantFiles {
+					t.Errorf("batch %d: expected %d files, got %v", i, wantFiles, files.objectNames)
+				}
+
+				completed, err := exportDB.LookupExportBatch(ctx, eb.BatchID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if completed.Status != model.ExportBatchComplete {
+					t.Errorf("batch %d: expected status %q, got %q", i, model.ExportBatchComplete, completed.Status)
+				}
+				if completed.ContentHash == "" {
+					t.Errorf("batch %d: expected a content hash", i)
+				}
+			}
+
+			files, err := exportDB.LookupExportFiles(ctx, ec.ConfigID, server.config.TTL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := len(files), 3; got != want {
+				t.Errorf("expected %d export files, got %v", want, files)
+			}
+		})
+	}
+}
@@ -18,9 +18,11 @@ package database
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math/rand"
+	"strings"
 	"time"
 
 	"github.com/google/exposure-notifications-server/internal/export/model"
@@ -48,26 +50,8 @@ func (db *ExportDB) AddExportConfig(ctx context.Context, ec *model.ExportConfig)
 		return err
 	}
 
-	thru := database.NullableTime(ec.Thru)
 	return db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
-		row := tx.QueryRow(ctx, `
-			INSERT INTO
-				ExportConfig
-				(bucket_name, filename_root, period_seconds, output_region, from_timestamp, thru_timestamp,
-				 signature_info_ids, input_regions, include_travelers, exclude_regions, only_non_travelers,
-				 max_records_override)
-			VALUES
-				($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
-			RETURNING config_id
-		`, ec.BucketName, ec.FilenameRoot, int(ec.Period.Seconds()), ec.OutputRegion,
-			ec.From, thru, ec.SignatureInfoIDs, ec.InputRegions, ec.IncludeTravelers,
-			ec.ExcludeRegions, ec.OnlyNonTravelers, ec.MaxRecordsOverride)
-
-		if err := row.Scan(&ec.ConfigID); err != nil {
-			return fmt.Errorf("fetching config_id: %w", err)
-		}
-
-		return nil
+		return addExportConfig(ctx, tx, ec)
 	})
 }
 
@@ -77,30 +61,123 @@ func (db *ExportDB) UpdateExportConfig(ctx context.Context, ec *model.ExportConf
 		return err
 	}
 
-	thru := database.NullableTime(ec.Thru)
 	return db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
-		result, err := tx.Exec(ctx, `
-			UPDATE
-				ExportConfig
-			SET
-				bucket_name = $1, filename_root = $2, period_seconds = $3, output_region = $4, from_timestamp = $5,
-				thru_timestamp = $6, signature_info_ids = $7, input_regions = $8, include_travelers = $9,
-				exclude_regions = $10, only_non_travelers = $11, max_records_override = $12
-			WHERE config_id = $13
-		`, ec.BucketName, ec.FilenameRoot, int(ec.Period.Seconds()), ec.OutputRegion,
-			ec.From, thru, ec.SignatureInfoIDs, ec.InputRegions, ec.IncludeTravelers,
-			ec.ExcludeRegions, ec.OnlyNonTravelers, ec.MaxRecordsOverride,
-			ec.ConfigID)
+		updated, err := updateExportConfig(ctx, tx, ec)
 		if err != nil {
-			return fmt.Errorf("updating signatureinfo: %w", err)
+			return err
 		}
-		if result.RowsAffected() != 1 {
+		if !updated {
 			return fmt.Errorf("no rows updated")
 		}
 		return nil
 	})
 }
 
+// UpsertExportConfigs updates the export configs whose ConfigID exists and
+// creates all others, assigning them a new ConfigID. Either all configs are
+// written, or none are.
+func (db *ExportDB) UpsertExportConfigs(ctx context.Context, ecs []*model.ExportConfig) (created, updated int, err error) {
+	for _, ec := range ecs {
+		if err := ec.Validate(); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		created, updated = 0, 0
+		for _, ec := range ecs {
+			if ec.ConfigID != 0 {
+				ok, err := updateExportConfig(ctx, tx, ec)
+				if err != nil {
+					return err
+				}
+				if ok {
+					updated++
+					continue
+				}
+			}
+
+			if err := addExportConfig(ctx, tx, ec); err != nil {
+				return err
+			}
+			created++
+		}
+		return nil
+	}); err != nil {
+		return 0, 0, fmt.Errorf("upsert export configs: %w", err)
+	}
+
+	return created, updated, nil
+}
+
+func addExportConfig(ctx context.Context, tx pgx.Tx, ec *model.ExportConfig) error {
+	thru := database.NullableTime(ec.Thru)
+	regionSigInfos, err := marshalRegionSignatureInfos(ec.RegionSignatureInfos)
+	if err != nil {
+		return err
+	}
+	row := tx.QueryRow(ctx, `
+		INSERT INTO
+			ExportConfig
+			(bucket_name, filename_root, period_seconds, output_region, from_timestamp, thru_timestamp,
+			 signature_info_ids, input_regions, include_travelers, exclude_regions, only_non_travelers,
+			 max_records_override, onset_window_min_days, onset_window_max_days, exclude_missing_onset,
+			 schedule, cache_control, index_cache_control, content_disposition, only_revised_keys,
+			 exclude_revised_keys, include_cbor, min_transmission_risk, include_unknown_transmission_risk,
+			 default_report_type, report_types, include_unknown_report_type, region_signature_infos)
+		VALUES
+			($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25,
+			 $26, $27, $28)
+		RETURNING config_id
+	`, ec.BucketName, ec.FilenameRoot, int(ec.Period.Seconds()), ec.OutputRegion,
+		ec.From, thru, ec.SignatureInfoIDs, ec.InputRegions, ec.IncludeTravelers,
+		ec.ExcludeRegions, ec.OnlyNonTravelers, ec.MaxRecordsOverride,
+		ec.OnsetWindowMinDays, ec.OnsetWindowMaxDays, ec.ExcludeMissingOnset,
+		ec.Schedule, ec.CacheControl, ec.IndexCacheControl, ec.ContentDisposition, ec.OnlyRevisedKeys,
+		ec.ExcludeRevisedKeys, ec.IncludeCBOR, ec.MinTransmissionRisk, ec.IncludeUnknownTransmissionRisk,
+		ec.DefaultReportType, ec.ReportTypes, ec.IncludeUnknownReportType, regionSigInfos)
+
+	if err := row.Scan(&ec.ConfigID); err != nil {
+		return fmt.Errorf("fetching config_id: %w", err)
+	}
+	return nil
+}
+
+// updateExportConfig updates the export config, returning false if it doesn't
+// exist.
+func updateExportConfig(ctx context.Context, tx pgx.Tx, ec *model.ExportConfig) (bool, error) {
+	thru := database.NullableTime(ec.Thru)
+	regionSigInfos, err := marshalRegionSignatureInfos(ec.RegionSignatureInfos)
+	if err != nil {
+		return false, err
+	}
+	result, err := tx.Exec(ctx, `
+		UPDATE
+			ExportConfig
+		SET
+			bucket_name = $1, filename_root = $2, period_seconds = $3, output_region = $4, from_timestamp = $5,
+			thru_timestamp = $6, signature_info_ids = $7, input_regions = $8, include_travelers = $9,
+			exclude_regions = $10, only_non_travelers = $11, max_records_override = $12,
+			onset_window_min_days = $13, onset_window_max_days = $14, exclude_missing_onset = $15,
+			schedule = $16, cache_control = $17, index_cache_control = $18, content_disposition = $19,
+			only_revised_keys = $20, exclude_revised_keys = $21, include_cbor = $22,
+			min_transmission_risk = $23, include_unknown_transmission_risk = $24, default_report_type = $25,
+			report_types = $26, include_unknown_report_type = $27, region_signature_infos = $28
+		WHERE config_id = $29
+	`, ec.BucketName, ec.FilenameRoot, int(ec.Period.Seconds()), ec.OutputRegion,
+		ec.From, thru, ec.SignatureInfoIDs, ec.InputRegions, ec.IncludeTravelers,
+		ec.ExcludeRegions, ec.OnlyNonTravelers, ec.MaxRecordsOverride,
+		ec.OnsetWindowMinDays, ec.OnsetWindowMaxDays, ec.ExcludeMissingOnset,
+		ec.Schedule, ec.CacheControl, ec.IndexCacheControl, ec.ContentDisposition,
+		ec.OnlyRevisedKeys, ec.ExcludeRevisedKeys, ec.IncludeCBOR, ec.MinTransmissionRisk,
+		ec.IncludeUnknownTransmissionRisk, ec.DefaultReportType, ec.ReportTypes, ec.IncludeUnknownReportType,
+		regionSigInfos, ec.ConfigID)
+	if err != nil {
+		return false, fmt.Errorf("updating export config: %w", err)
+	}
+	return result.RowsAffected() == 1, nil
+}
+
 func (db *ExportDB) GetExportConfig(ctx context.Context, id int64) (*model.ExportConfig, error) {
 	var config *model.ExportConfig
 	if err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
@@ -108,7 +185,11 @@ func (db *ExportDB) GetExportConfig(ctx context.Context, id int64) (*model.Expor
 			SELECT
 				config_id, bucket_name, filename_root, period_seconds, output_region,
 				from_timestamp, thru_timestamp, signature_info_ids, input_regions,
-				include_travelers, exclude_regions, only_non_travelers, max_records_override
+				include_travelers, exclude_regions, only_non_travelers, max_records_override,
+				onset_window_min_days, onset_window_max_days, exclude_missing_onset, schedule,
+				cache_control, index_cache_control, content_disposition, only_revised_keys,
+				exclude_revised_keys, include_cbor, min_transmission_risk, include_unknown_transmission_risk,
+				default_report_type, report_types, include_unknown_report_type, region_signature_infos
 			FROM
 				ExportConfig
 			WHERE
@@ -136,7 +217,11 @@ func (db *ExportDB) GetAllExportConfigs(ctx context.Context) ([]*model.ExportCon
 			SELECT
 				config_id, bucket_name, filename_root, period_seconds, output_region,
 				from_timestamp, thru_timestamp, signature_info_ids, input_regions, include_travelers,
-				exclude_regions, only_non_travelers, max_records_override
+				exclude_regions, only_non_travelers, max_records_override,
+				onset_window_min_days, onset_window_max_days, exclude_missing_onset, schedule,
+				cache_control, index_cache_control, content_disposition, only_revised_keys,
+				exclude_revised_keys, include_cbor, min_transmission_risk, include_unknown_transmission_risk,
+				default_report_type, report_types, include_unknown_report_type, region_signature_infos
 			FROM
 				ExportConfig
 			ORDER BY config_id
@@ -175,7 +260,11 @@ func (db *ExportDB) IterateExportConfigs(ctx context.Context, t time.Time, f fun
 			SELECT
 				config_id, bucket_name, filename_root, period_seconds, output_region,
 				from_timestamp, thru_timestamp, signature_info_ids, input_regions, include_travelers,
-				exclude_regions, only_non_travelers, max_records_override
+				exclude_regions, only_non_travelers, max_records_override,
+				onset_window_min_days, onset_window_max_days, exclude_missing_onset, schedule,
+				cache_control, index_cache_control, content_disposition, only_revised_keys,
+				exclude_revised_keys, include_cbor, min_transmission_risk, include_unknown_transmission_risk,
+				default_report_type, report_types, include_unknown_report_type, region_signature_infos
 			FROM
 				ExportConfig
 			WHERE
@@ -210,17 +299,41 @@ func (db *ExportDB) IterateExportConfigs(ctx context.Context, t time.Time, f fun
 	return nil
 }
 
+// marshalRegionSignatureInfos returns the JSON of the region signature infos
+// for the region_signature_infos column.
+func marshalRegionSignatureInfos(m map[string]int64) (string, error) {
+	if m == nil {
+		m = map[string]int64{}
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal region signature infos: %w", err)
+	}
+	return string(b), nil
+}
+
 func scanOneExportConfig(row pgx.Row) (*model.ExportConfig, error) {
 	var (
 		m             model.ExportConfig
 		outputRegion  sql.NullString
 		periodSeconds int
 		thru          *time.Time
+		sigInfos      []byte
 	)
 	if err := row.Scan(&m.ConfigID, &m.BucketName, &m.FilenameRoot, &periodSeconds, &outputRegion, &m.From, &thru,
-		&m.SignatureInfoIDs, &m.InputRegions, &m.IncludeTravelers, &m.ExcludeRegions, &m.OnlyNonTravelers, &m.MaxRecordsOverride); err != nil {
+		&m.SignatureInfoIDs, &m.InputRegions, &m.IncludeTravelers, &m.ExcludeRegions, &m.OnlyNonTravelers, &m.MaxRecordsOverride,
+		&m.OnsetWindowMinDays, &m.OnsetWindowMaxDays, &m.ExcludeMissingOnset, &m.Schedule,
+		&m.CacheControl, &m.IndexCacheControl, &m.ContentDisposition, &m.OnlyRevisedKeys,
+		&m.ExcludeRevisedKeys, &m.IncludeCBOR, &m.MinTransmissionRisk, &m.IncludeUnknownTransmissionRisk,
+		&m.DefaultReportType, &m.ReportTypes, &m.IncludeUnknownReportType, &sigInfos); err != nil {
 		return nil, err
 	}
+	if err := json.Unmarshal(sigInfos, &m.RegionSignatureInfos); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal region signature infos: %w", err)
+	}
+	if len(m.RegionSignatureInfos) == 0 {
+		m.RegionSignatureInfos = nil
+	}
 
 	m.Period = time.Duration(periodSeconds) * time.Second
 	if thru != nil {
@@ -484,9 +597,11 @@ func (db *ExportDB) AddExportBatches(ctx context.Context, batches []*model.Expor
 		_, err := tx.Prepare(ctx, stmtName, `
 			INSERT INTO
 				ExportBatch
-				(config_id, bucket_name, filename_root, start_timestamp, end_timestamp, output_region, status, signature_info_ids, input_regions, include_travelers, exclude_regions, only_non_travelers, max_records_override)
+				(config_id, bucket_name, filename_root, start_timestamp, end_timestamp, output_region, status, signature_info_ids, input_regions, include_travelers, exclude_regions, only_non_travelers, max_records_override,
+				 onset_window_min_days, onset_window_max_days, exclude_missing_onset, only_revised_keys, exclude_revised_keys, include_cbor,
+				 min_transmission_risk, include_unknown_transmission_risk, default_report_type, report_types, include_unknown_report_type)
 			VALUES
-				($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+				($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24)
 		`)
 		if err != nil {
 			return err
@@ -495,7 +610,9 @@ func (db *ExportDB) AddExportBatches(ctx context.Context, batches []*model.Expor
 		for _, eb := range batches {
 			if _, err := tx.Exec(ctx, stmtName,
 				eb.ConfigID, eb.BucketName, eb.FilenameRoot, eb.StartTimestamp, eb.EndTimestamp, eb.OutputRegion, eb.Status, eb.SignatureInfoIDs,
-				eb.InputRegions, eb.IncludeTravelers, eb.ExcludeRegions, eb.OnlyNonTravelers, eb.MaxRecordsOverride); err != nil {
+				eb.InputRegions, eb.IncludeTravelers, eb.ExcludeRegions, eb.OnlyNonTravelers, eb.MaxRecordsOverride,
+				eb.OnsetWindowMinDays, eb.OnsetWindowMaxDays, eb.ExcludeMissingOnset, eb.OnlyRevisedKeys, eb.ExcludeRevisedKeys, eb.IncludeCBOR,
+				eb.MinTransmissionRisk, eb.IncludeUnknownTransmissionRisk, eb.DefaultReportType, eb.ReportTypes, eb.IncludeUnknownReportType); err != nil {
 				return err
 			}
 		}
@@ -503,8 +620,10 @@ func (db *ExportDB) AddExportBatches(ctx context.Context, batches []*model.Expor
 	})
 }
 
-// LeaseBatch returns a leased ExportBatch for the worker to process. If no work to do, nil will be returned.
-func (db *ExportDB) LeaseBatch(ctx context.Context, ttl time.Duration, batchMaxCloseTime time.Time) (*model.ExportBatch, error) {
+// LeaseBatch returns a leased ExportBatch for the worker to process. Only
+// batches that end before batchMaxCloseTime are considered. The lease expires
+// ttl after now. If no work to do, nil will be returned.
+func (db *ExportDB) LeaseBatch(ctx context.Context, ttl time.Duration, batchMaxCloseTime, now time.Time) (*model.ExportBatch, error) {
 	var openBatchIDs []int64
 
 	if err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
@@ -523,11 +642,11 @@ func (db *ExportDB) LeaseBatch(ctx context.Context, ttl time.Duration, batchMaxC
 					(status = $2 AND lease_expires < $3)
 				)
 			AND
-				end_timestamp < $3
+				end_timestamp < $4
 			ORDER BY
 				end_timestamp ASC
 			LIMIT 100
-		`, model.ExportBatchOpen, model.ExportBatchPending, batchMaxCloseTime)
+		`, model.ExportBatchOpen, model.ExportBatchPending, now, batchMaxCloseTime)
 		if err != nil {
 			return fmt.Errorf("failed to list: %w", err)
 		}
@@ -578,7 +697,7 @@ func (db *ExportDB) LeaseBatch(ctx context.Context, ttl time.Duration, batchMaxC
 				return err
 			}
 
-			if status == model.ExportBatchComplete || (expires != nil && status == model.ExportBatchPending && batchMaxCloseTime.Before(*expires)) {
+			if status == model.ExportBatchComplete || (expires != nil && status == model.ExportBatchPending && now.Before(*expires)) {
 				// Something beat us to this batch, it's no longer available.
 				return nil
 			}
@@ -591,7 +710,7 @@ func (db *ExportDB) LeaseBatch(ctx context.Context, ttl time.Duration, batchMaxC
 				WHERE
 					batch_id = $3
 				`,
-				model.ExportBatchPending, batchMaxCloseTime.Add(ttl), bid,
+				model.ExportBatchPending, now.Add(ttl), bid,
 			); err != nil {
 				return err
 			}
@@ -631,7 +750,10 @@ type queryRowFn func(ctx context.Context, query string, args ...interface{}) pgx
 func lookupExportBatch(ctx context.Context, batchID int64, queryRow queryRowFn) (*model.ExportBatch, error) {
 	row := queryRow(ctx, `
 		SELECT
-			batch_id, config_id, bucket_name, filename_root, start_timestamp, end_timestamp, output_region, status, lease_expires, signature_info_ids, input_regions, include_travelers, exclude_regions, only_non_travelers, max_records_override
+			batch_id, config_id, bucket_name, filename_root, start_timestamp, end_timestamp, output_region, status, lease_expires, signature_info_ids, input_regions, include_travelers, exclude_regions, only_non_travelers, max_records_override,
+			onset_window_min_days, onset_window_max_days, exclude_missing_onset, only_revised_keys, exclude_revised_keys, include_cbor,
+			min_transmission_risk, include_unknown_transmission_risk, default_report_type, report_types, include_unknown_report_type,
+			content_hash
 		FROM
 			ExportBatch
 		WHERE
@@ -641,7 +763,10 @@ func lookupExportBatch(ctx context.Context, batchID int64, queryRow queryRowFn)
 
 	var expires *time.Time
 	eb := model.ExportBatch{}
-	if err := row.Scan(&eb.BatchID, &eb.ConfigID, &eb.BucketName, &eb.FilenameRoot, &eb.StartTimestamp, &eb.EndTimestamp, &eb.OutputRegion, &eb.Status, &expires, &eb.SignatureInfoIDs, &eb.InputRegions, &eb.IncludeTravelers, &eb.ExcludeRegions, &eb.OnlyNonTravelers, &eb.MaxRecordsOverride); err != nil {
+	if err := row.Scan(&eb.BatchID, &eb.ConfigID, &eb.BucketName, &eb.FilenameRoot, &eb.StartTimestamp, &eb.EndTimestamp, &eb.OutputRegion, &eb.Status, &expires, &eb.SignatureInfoIDs, &eb.InputRegions, &eb.IncludeTravelers, &eb.ExcludeRegions, &eb.OnlyNonTravelers, &eb.MaxRecordsOverride,
+		&eb.OnsetWindowMinDays, &eb.OnsetWindowMaxDays, &eb.ExcludeMissingOnset, &eb.OnlyRevisedKeys, &eb.ExcludeRevisedKeys, &eb.IncludeCBOR,
+		&eb.MinTransmissionRisk, &eb.IncludeUnknownTransmissionRisk, &eb.DefaultReportType, &eb.ReportTypes, &eb.IncludeUnknownReportType,
+		&eb.ContentHash); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, database.ErrNotFound
 		}
@@ -653,7 +778,8 @@ func lookupExportBatch(ctx context.Context, batchID int64, queryRow queryRowFn)
 	return &eb, nil
 }
 
-// FinalizeBatch writes the ExportFile records and marks the ExportBatch as complete.
+// FinalizeBatch writes the ExportFile records and marks the ExportBatch as
+// complete, with the content hash of eb.
 func (db *ExportDB) FinalizeBatch(ctx context.Context, eb *model.ExportBatch, files []string, batchSize int) error {
 	return db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
 		// Update ExportFile for the files created.
@@ -681,7 +807,7 @@ func (db *ExportDB) FinalizeBatch(ctx context.Context, eb *model.ExportBatch, fi
 		}
 
 		// Update ExportBatch to mark it complete.
-		if err := completeBatch(ctx, tx, eb.BatchID); err != nil {
+		if err := completeBatch(ctx, tx, eb.BatchID, eb.ContentHash); err != nil {
 			return fmt.Errorf("marking batch %v complete: %w", eb.BatchID, err)
 		}
 		return nil
@@ -772,6 +898,183 @@ func (db *ExportDB) LookupExportFiles(ctx context.Context, configID int64, ttl t
 	return files, nil
 }
 
+// ListBatchExportFiles returns up to limit completed export files for the
+// config's batches with IDs between fromBatchID and toBatchID, inclusive. If
+// toBatchID is 0, there is no upper bound. Files are ordered by batch ID, then
+// filename. Files of the first batch are only returned if their name is after
+// afterFilename, so the last file of a page can be used to get the next page.
+func (db *ExportDB) ListBatchExportFiles(ctx context.Context, configID, fromBatchID int64, afterFilename string, toBatchID int64, limit int) ([]*model.ExportFile, error) {
+	var files []*model.ExportFile
+
+	if err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+			SELECT
+				ef.bucket_name, ef.filename, ef.batch_id, ef.output_region, ef.batch_num, ef.batch_size,
+				ef.status, ef.input_regions, ef.include_travelers, ef.exclude_regions, ef.only_non_travelers
+			FROM
+				ExportFile ef
+			INNER JOIN
+				ExportBatch eb ON (eb.batch_id = ef.batch_id)
+			WHERE
+				eb.config_id = $1
+			AND
+				(ef.batch_id > $2 OR (ef.batch_id = $2 AND ef.filename > $3))
+			AND
+				($4::BIGINT = 0 OR ef.batch_id <= $4)
+			AND
+				ef.status = $5
+			ORDER BY
+				ef.batch_id, ef.filename
+			LIMIT $6
+		`, configID, fromBatchID, afterFilename, toBatchID, model.ExportBatchComplete, limit)
+		if err != nil {
+			return fmt.Errorf("failed to list: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			if err := rows.Err(); err != nil {
+				return fmt.Errorf("failed to iterate: %w", err)
+			}
+
+			var file model.ExportFile
+			if err := rows.Scan(&file.BucketName, &file.Filename, &file.BatchID, &file.OutputRegion, &file.BatchNum, &file.BatchSize,
+				&file.Status, &file.InputRegions, &file.IncludeTravelers, &file.ExcludeRegions, &file.OnlyNonTravelers); err != nil {
+				return fmt.Errorf("failed to parse: %w", err)
+			}
+			files = append(files, &file)
+		}
+
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("list batch export files: %w", err)
+	}
+
+	return files, nil
+}
+
+// RegionExportFile is a completed export file with the time window of its
+// batch.
+type RegionExportFile struct {
+	File           *model.ExportFile
+	ConfigID       int64
+	StartTimestamp time.Time
+	EndTimestamp   time.Time
+}
+
+// RegionExportFilesCriteria selects export files of an output region.
+type RegionExportFilesCriteria struct {
+	Region string
+	// Since and Until are the time range, only files of batches whose window
+	// overlaps [Since, Until) are returned.
+	Since time.Time
+	Until time.Time
+	// AfterBatchID and AfterFilename are the last file of the previous page.
+	// Only files after it are returned.
+	AfterBatchID  int64
+	AfterFilename string
+	Limit         int
+}
+
+// ListRegionExportFiles returns up to criteria.Limit completed export files of
+// the output region, for all export configs, ordered by batch ID and filename.
+func (db *ExportDB) ListRegionExportFiles(ctx context.Context, criteria *RegionExportFilesCriteria) ([]*RegionExportFile, error) {
+	var files []*RegionExportFile
+
+	if err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+			SELECT
+				ef.bucket_name, ef.filename, ef.batch_id, ef.output_region, ef.batch_num, ef.batch_size,
+				ef.status, ef.input_regions, ef.include_travelers, ef.exclude_regions, ef.only_non_travelers,
+				eb.config_id, eb.start_timestamp, eb.end_timestamp
+			FROM
+				ExportFile ef
+			INNER JOIN
+				ExportBatch eb ON (eb.batch_id = ef.batch_id)
+			WHERE
+				ef.output_region = $1
+			AND
+				eb.start_timestamp < $3 AND eb.end_timestamp > $2
+			AND
+				(ef.batch_id > $4 OR (ef.batch_id = $4 AND ef.filename > $5))
+			AND
+				ef.status = $6
+			ORDER BY
+				ef.batch_id, ef.filename
+			LIMIT $7
+		`, criteria.Region, criteria.Since, criteria.Until, criteria.AfterBatchID, criteria.AfterFilename,
+			model.ExportBatchComplete, criteria.Limit)
+		if err != nil {
+			return fmt.Errorf("failed to list: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			if err := rows.Err(); err != nil {
+				return fmt.Errorf("failed to iterate: %w", err)
+			}
+
+			var file model.ExportFile
+			rf := &RegionExportFile{File: &file}
+			if err := rows.Scan(&file.BucketName, &file.Filename, &file.BatchID, &file.OutputRegion, &file.BatchNum, &file.BatchSize,
+				&file.Status, &file.InputRegions, &file.IncludeTravelers, &file.ExcludeRegions, &file.OnlyNonTravelers,
+				&rf.ConfigID, &rf.StartTimestamp, &rf.EndTimestamp); err != nil {
+				return fmt.Errorf("failed to parse: %w", err)
+			}
+			files = append(files, rf)
+		}
+
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("list region export files: %w", err)
+	}
+
+	return files, nil
+}
+
+// ListExportBatchFiles returns the export files of the batch in any status,
+// ordered by batch number.
+func (db *ExportDB) ListExportBatchFiles(ctx context.Context, batchID int64) ([]*model.ExportFile, error) {
+	var files []*model.ExportFile
+
+	if err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+			SELECT
+				bucket_name, filename, batch_id, output_region, batch_num, batch_size,
+				status, input_regions, include_travelers, exclude_regions, only_non_travelers
+			FROM
+				ExportFile
+			WHERE
+				batch_id = $1
+			ORDER BY
+				batch_num, filename
+		`, batchID)
+		if err != nil {
+			return fmt.Errorf("failed to list: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			if err := rows.Err(); err != nil {
+				return fmt.Errorf("failed to iterate: %w", err)
+			}
+
+			var file model.ExportFile
+			if err := rows.Scan(&file.BucketName, &file.Filename, &file.BatchID, &file.OutputRegion, &file.BatchNum, &file.BatchSize,
+				&file.Status, &file.InputRegions, &file.IncludeTravelers, &file.ExcludeRegions, &file.OnlyNonTravelers); err != nil {
+				return fmt.Errorf("failed to parse: %w", err)
+			}
+			files = append(files, &file)
+		}
+
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("list export batch files: %w", err)
+	}
+
+	return files, nil
+}
+
 type joinedExportBatchFile struct {
 	bucketName  string
 	filename    string
@@ -856,6 +1159,9 @@ func (db *ExportDB) DeleteFilesBefore(ctx context.Context, before time.Time, blo
 
 	count := 0
 	batchFileDeleteCounter := make(map[int64]int)
+	// Blob name prefixes (by bucket) of the batches with files deleted in this
+	// run, used to find blobs for these batches that have no ExportFile record.
+	batchPrefixes := make(map[string]map[string]struct{})
 
 	for _, f := range files {
 		f := f
@@ -891,12 +1197,69 @@ func (db *ExportDB) DeleteFilesBefore(ctx context.Context, before time.Time, blo
 			return 0, err
 		}
 
+		if prefix := exportBatchFilePrefix(f.filename); prefix != "" {
+			if _, ok := batchPrefixes[f.bucketName]; !ok {
+				batchPrefixes[f.bucketName] = make(map[string]struct{})
+			}
+			batchPrefixes[f.bucketName][prefix] = struct{}{}
+		}
+
 		count++
 	}
 
+	// Interrupted export runs can leave files in the blobstore that were never
+	// recorded in ExportFile. Those are removed along with the rest of the batch.
+	for bucket, prefixes := range batchPrefixes {
+		for prefix := range prefixes {
+			n, err := deleteObjectsWithPrefix(ctx, blobstore, bucket, prefix)
+			if err != nil {
+				return 0, fmt.Errorf("delete orphaned batch objects: %w", err)
+			}
+			count += n
+		}
+	}
+
 	return count, nil
 }
 
+// exportBatchFilePrefix returns the prefix shared by all files in the same
+// export batch, e.g. "root/1600000000-1600003600-" for the file
+// "root/1600000000-1600003600-00001.zip". It returns the empty string if the
+// filename isn't in the export file format.
+func exportBatchFilePrefix(filename string) string {
+	idx := strings.LastIndex(filename, "-")
+	if idx <= 0 || idx < strings.LastIndex(filename, "/") {
+		return ""
+	}
+	return filename[:idx+1]
+}
+
+// deleteObjectsWithPrefix deletes all objects in the bucket that start with
+// the given prefix, returning the number of objects deleted.
+func deleteObjectsWithPrefix(ctx context.Context, blobstore storage.Blobstore, bucket, prefix string) (int, error) {
+	var names []string
+	pageToken := ""
+	for {
+		page, nextToken, err := blobstore.ListObjects(ctx, bucket, prefix, pageToken)
+		if err != nil {
+			return 0, fmt.Errorf("list objects: %w", err)
+		}
+		names = append(names, page...)
+
+		if nextToken == "" {
+			break
+		}
+		pageToken = nextToken
+	}
+
+	for _, name := range names {
+		if err := blobstore.DeleteObject(ctx, bucket, name); err != nil {
+			return 0, fmt.Errorf("delete object: %w", err)
+		}
+	}
+	return len(names), nil
+}
+
 // addExportFile adds a row to ExportFile. If the row already exists (based on the primary key),
 // ErrKeyConflict is returned.
 func addExportFile(ctx context.Context, tx pgx.Tx, ef *model.ExportFile) error {
@@ -947,8 +1310,8 @@ func updateExportBatchStatus(ctx context.Context, tx pgx.Tx, batchID int64, stat
 	return nil
 }
 
-// completeBatch marks a batch as completed.
-func completeBatch(ctx context.Context, tx pgx.Tx, batchID int64) error {
+// completeBatch marks a batch as completed, with the content hash of its files.
+func completeBatch(ctx context.Context, tx pgx.Tx, batchID int64, contentHash string) error {
 	logger := logging.FromContext(ctx)
 	batch, err := lookupExportBatch(ctx, batchID, tx.QueryRow)
 	if err != nil {
@@ -965,10 +1328,10 @@ func completeBatch(ctx context.Context, tx pgx.Tx, batchID int64) error {
 		UPDATE
 			ExportBatch
 		SET
-			status = $1, lease_expires = NULL
+			status = $1, lease_expires = NULL, content_hash = $2
 		WHERE
-			batch_id = $2
-		`, model.ExportBatchComplete, batchID)
+			batch_id = $3
+		`, model.ExportBatchComplete, contentHash, batchID)
 	if err != nil {
 		return err
 	}
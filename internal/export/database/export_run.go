@@ -0,0 +1,120 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/export/model"
+
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// AddExportRuns saves the records of an export worker run, assigning each one a
+// RunID.
+func (db *ExportDB) AddExportRuns(ctx context.Context, runs []*model.ExportRun) error {
+	if len(runs) == 0 {
+		return nil
+	}
+
+	return db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		for _, r := range runs {
+			var exportErr *string
+			if r.Error != "" {
+				exportErr = &r.Error
+			}
+
+			row := tx.QueryRow(ctx, `
+				INSERT INTO
+					ExportRun
+					(config_id, started_at, finished_at, batches, keys, revised_keys, outcome, error)
+				VALUES
+					($1, $2, $3, $4, $5, $6, $7, $8)
+				RETURNING run_id
+			`, r.ConfigID, r.StartedAt, r.FinishedAt, r.Batches, r.Keys, r.RevisedKeys, r.Outcome, exportErr)
+			if err := row.Scan(&r.RunID); err != nil {
+				return fmt.Errorf("inserting export run for config %d: %w", r.ConfigID, err)
+			}
+		}
+		return nil
+	})
+}
+
+// ListExportRuns returns the most recent export runs, newest first, up to
+// limit. If configID is not 0, only the runs for that export config are
+// returned.
+func (db *ExportDB) ListExportRuns(ctx context.Context, configID int64, limit int) ([]*model.ExportRun, error) {
+	var runs []*model.ExportRun
+	if err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+			SELECT
+				run_id, config_id, started_at, finished_at, batches, keys, revised_keys, outcome, error
+			FROM
+				ExportRun
+			WHERE
+				$1 = 0 OR config_id = $1
+			ORDER BY started_at DESC, run_id DESC
+			LIMIT $2
+		`, configID, limit)
+		if err != nil {
+			return fmt.Errorf("failed to list export runs: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			if err := rows.Err(); err != nil {
+				return fmt.Errorf("failed to iterate: %w", err)
+			}
+
+			var r model.ExportRun
+			var exportErr sql.NullString
+			if err := rows.Scan(&r.RunID, &r.ConfigID, &r.StartedAt, &r.FinishedAt,
+				&r.Batches, &r.Keys, &r.RevisedKeys, &r.Outcome, &exportErr); err != nil {
+				return fmt.Errorf("failed to parse: %w", err)
+			}
+			r.Error = exportErr.String
+			runs = append(runs, &r)
+		}
+		return rows.Err()
+	}); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// DeleteExportRunsBefore deletes the export runs that started before "before".
+// Returns the number of records deleted.
+func (db *ExportDB) DeleteExportRunsBefore(ctx context.Context, before time.Time) (int64, error) {
+	var count int64
+	if err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `
+			DELETE FROM
+				ExportRun
+			WHERE
+				started_at < $1
+			`, before)
+		if err != nil {
+			return fmt.Errorf("deleting export runs: %w", err)
+		}
+		count = result.RowsAffected()
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,180 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/exposure-notifications-server/pkg/database"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAddListExportRuns(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	exportDB := New(testDB)
+
+	configIDs := make([]int64, 0, 2)
+	for _, region := range []string{"US", "CA"} {
+		ec := &model.ExportConfig{
+			BucketName:   "bucket",
+			FilenameRoot: region,
+			Period:       time.Hour,
+			OutputRegion: region,
+			From:         time.Now().UTC(),
+		}
+		if err := exportDB.AddExportConfig(ctx, ec); err != nil {
+			t.Fatal(err)
+		}
+		configIDs = append(configIDs, ec.ConfigID)
+	}
+
+	start := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	runs := []*model.ExportRun{
+		{
+			ConfigID:    configIDs[0],
+			StartedAt:   start,
+			FinishedAt:  start.Add(time.Minute),
+			Batches:     2,
+			Keys:        20,
+			RevisedKeys: 3,
+			Outcome:     model.ExportRunSuccess,
+		},
+		{
+			ConfigID:   configIDs[1],
+			StartedAt:  start,
+			FinishedAt: start.Add(time.Minute),
+			Batches:    1,
+			Outcome:    model.ExportRunFailure,
+			Error:      "failed to process batch 2/2",
+		},
+		{
+			ConfigID:   configIDs[0],
+			StartedAt:  start.Add(10 * time.Minute),
+			FinishedAt: start.Add(11 * time.Minute),
+			Batches:    1,
+			Keys:       5,
+			Outcome:    model.ExportRunSuccess,
+		},
+	}
+	if err := exportDB.AddExportRuns(ctx, runs); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range runs {
+		if r.RunID == 0 {
+			t.Errorf("expected run ID to be set: %#v", r)
+		}
+	}
+
+	cases := []struct {
+		name     string
+		configID int64
+		limit    int
+		want     []*model.ExportRun
+	}{
+		{
+			name:  "all",
+			limit: 10,
+			want:  []*model.ExportRun{runs[2], runs[1], runs[0]},
+		},
+		{
+			name:  "limit",
+			limit: 1,
+			want:  []*model.ExportRun{runs[2]},
+		},
+		{
+			name:     "config",
+			configID: configIDs[0],
+			limit:    10,
+			want:     []*model.ExportRun{runs[2], runs[0]},
+		},
+		{
+			name:     "failed_config",
+			configID: configIDs[1],
+			limit:    10,
+			want:     []*model.ExportRun{runs[1]},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := exportDB.ListExportRuns(ctx, tc.configID, tc.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, got, database.ApproxTime); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDeleteExportRunsBefore(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	exportDB := New(testDB)
+
+	ec := &model.ExportConfig{
+		BucketName:   "bucket",
+		FilenameRoot: "US",
+		Period:       time.Hour,
+		OutputRegion: "US",
+		From:         time.Now().UTC(),
+	}
+	if err := exportDB.AddExportConfig(ctx, ec); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	runs := make([]*model.ExportRun, 0, 3)
+	for _, age := range []time.Duration{72 * time.Hour, 48 * time.Hour, time.Hour} {
+		runs = append(runs, &model.ExportRun{
+			ConfigID:   ec.ConfigID,
+			StartedAt:  now.Add(-age),
+			FinishedAt: now.Add(-age).Add(time.Minute),
+			Outcome:    model.ExportRunSuccess,
+		})
+	}
+	if err := exportDB.AddExportRuns(ctx, runs); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := exportDB.DeleteExportRunsBefore(ctx, now.Add(-24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := count, int64(2); got != want {
+		t.Errorf("expected %d runs to be deleted, got %d", want, got)
+	}
+
+	got, err := exportDB.ListExportRuns(ctx, ec.ConfigID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]*model.ExportRun{runs[2]}, got, database.ApproxTime); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"github.com/google/exposure-notifications-server/internal/project"
 	publishdb "github.com/google/exposure-notifications-server/internal/publish/database"
 	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/storage"
 	"github.com/google/exposure-notifications-server/pkg/database"
 
 	"github.com/google/go-cmp/cmp"
@@ -163,7 +164,16 @@ func TestAddGetUpdateExportConfig(t *testing.T) {
 	want.Period = 15 * time.Minute
 	want.Thru = time.Time{}
 	want.SignatureInfoIDs = []int64{1, 2, 3, 4, 5}
+	want.RegionSignatureInfos = map[string]int64{"US": 6, "CA": 7}
 	want.InputRegions = []string{"US", "CA"}
+	onsetMin, onsetMax := 0, 14
+	want.OnsetWindowMinDays = &onsetMin
+	want.OnsetWindowMaxDays = &onsetMax
+	want.ExcludeMissingOnset = true
+	want.Schedule = "CRON_TZ=America/New_York 0 0 * * *"
+	want.CacheControl = "public, max-age=3600"
+	want.IndexCacheControl = "public, max-age=60"
+	want.ContentDisposition = "attachment"
 
 	if err := exportDB.UpdateExportConfig(ctx, want); err != nil {
 		t.Fatal(err)
@@ -178,6 +188,75 @@ func TestAddGetUpdateExportConfig(t *testing.T) {
 	}
 }
 
+func TestUpsertExportConfigs(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	exportDB := New(testDB)
+
+	from := time.Now().UTC().Truncate(time.Second)
+	existing := &model.ExportConfig{
+		BucketName:   "mocked",
+		FilenameRoot: "root",
+		Period:       time.Hour,
+		OutputRegion: "US",
+		From:         from,
+	}
+	if err := exportDB.AddExportConfig(ctx, existing); err != nil {
+		t.Fatal(err)
+	}
+
+	updatedConfig := *existing
+	updatedConfig.FilenameRoot = "updated"
+	missing := &model.ExportConfig{
+		ConfigID:     existing.ConfigID + 100,
+		BucketName:   "mocked",
+		FilenameRoot: "missing",
+		Period:       time.Hour,
+		OutputRegion: "US",
+		From:         from,
+	}
+	fresh := &model.ExportConfig{
+		BucketName:   "mocked",
+		FilenameRoot: "fresh",
+		Period:       time.Hour,
+		OutputRegion: "US",
+		From:         from,
+	}
+
+	created, updated, err := exportDB.UpsertExportConfigs(ctx, []*model.ExportConfig{&updatedConfig, missing, fresh})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created != 2 || updated != 1 {
+		t.Errorf("expected 2 created and 1 updated, got %d created and %d updated", created, updated)
+	}
+
+	got, err := exportDB.GetAllExportConfigs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]*model.ExportConfig{&updatedConfig, missing, fresh}, got, database.ApproxTime); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// An invalid config prevents all configs from being written.
+	invalid := *fresh
+	invalid.ConfigID = 0
+	invalid.Period = 7 * time.Hour
+	if _, _, err := exportDB.UpsertExportConfigs(ctx, []*model.ExportConfig{fresh, &invalid}); err == nil {
+		t.Fatal("expected error")
+	}
+	got, err = exportDB.GetAllExportConfigs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Errorf("expected 3 export configs, got %d", len(got))
+	}
+}
+
 func TestIterateExportConfigs(t *testing.T) {
 	t.Parallel()
 
@@ -313,7 +392,7 @@ func TestBatches(t *testing.T) {
 				var batchID int64
 				// Lease all the batches.
 				for range batches {
-					got, err := New(testDB).LeaseBatch(ctx, time.Hour, now)
+					got, err := New(testDB).LeaseBatch(ctx, time.Hour, now, now)
 					if err != nil {
 						t.Fatal(err)
 					}
@@ -336,14 +415,14 @@ func TestBatches(t *testing.T) {
 					batchID = got.BatchID
 				}
 				// Every batch is leased.
-				got, err := New(testDB).LeaseBatch(ctx, time.Hour, now)
+				got, err := New(testDB).LeaseBatch(ctx, time.Hour, now, now)
 				if got != nil || err != nil {
 					t.Errorf("all leased: got (%v, %v), want (nil, nil)", got, err)
 				}
 				return batchID
 			}
 			// Now, all end times are in the future, so no batches can be leased.
-			got, err := New(testDB).LeaseBatch(ctx, time.Hour, now)
+			got, err := New(testDB).LeaseBatch(ctx, time.Hour, now, now)
 			if got != nil || err != nil {
 				t.Errorf("got (%v, %v), want (nil, nil)", got, err)
 			}
@@ -357,7 +436,7 @@ func TestBatches(t *testing.T) {
 
 			// Complete a batch.
 			err = testDB.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
-				return completeBatch(ctx, tx, batchID)
+				return completeBatch(ctx, tx, batchID, "")
 			})
 			if err != nil {
 				t.Fatal(err)
@@ -373,6 +452,71 @@ func TestBatches(t *testing.T) {
 	}
 }
 
+// TestLeaseBatchActivationDelay ensures that a lease taken with a cutoff well
+// before now still expires relative to now.
+func TestLeaseBatchActivationDelay(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	exportDB := New(testDB)
+
+	now := time.Now().Truncate(time.Microsecond)
+	ec := &model.ExportConfig{
+		BucketName:   "mocked",
+		FilenameRoot: "root",
+		Period:       time.Hour,
+		OutputRegion: "R",
+		From:         now.Add(-4 * time.Hour),
+	}
+	if err := exportDB.AddExportConfig(ctx, ec); err != nil {
+		t.Fatal(err)
+	}
+	eb := &model.ExportBatch{
+		ConfigID:       ec.ConfigID,
+		BucketName:     ec.BucketName,
+		FilenameRoot:   ec.FilenameRoot,
+		OutputRegion:   ec.OutputRegion,
+		Status:         model.ExportBatchOpen,
+		StartTimestamp: now.Add(-4 * time.Hour),
+		EndTimestamp:   now.Add(-3 * time.Hour),
+	}
+	if err := exportDB.AddExportBatches(ctx, []*model.ExportBatch{eb}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The activation delay is longer than the lease.
+	ttl := time.Hour
+	closedBefore := now.Add(-2 * time.Hour)
+
+	got, err := exportDB.LeaseBatch(ctx, ttl, closedBefore, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil {
+		t.Fatal("could not lease a batch")
+	}
+	wantExpires := now.Add(ttl)
+	if got.LeaseExpires.Before(wantExpires) || got.LeaseExpires.After(wantExpires.Add(time.Minute)) {
+		t.Errorf("LeaseBatch: expires at %s, wanted a time close to %s", got.LeaseExpires, wantExpires)
+	}
+
+	// Another worker can't take the batch while the lease is held.
+	got, err = exportDB.LeaseBatch(ctx, ttl, closedBefore, now.Add(time.Minute))
+	if got != nil || err != nil {
+		t.Errorf("leased: got (%v, %v), want (nil, nil)", got, err)
+	}
+
+	// Once the lease has expired, it can.
+	got, err = exportDB.LeaseBatch(ctx, ttl, closedBefore, now.Add(2*ttl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil {
+		t.Error("could not lease the batch after the lease expired")
+	}
+}
+
 func TestFinalizeBatch(t *testing.T) {
 	t.Parallel()
 
@@ -407,7 +551,7 @@ func TestFinalizeBatch(t *testing.T) {
 	}
 
 	// Lease the batch.
-	eb, err := exportDB.LeaseBatch(ctx, time.Hour, now)
+	eb, err := exportDB.LeaseBatch(ctx, time.Hour, now, now)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -476,6 +620,82 @@ func TestFinalizeBatch(t *testing.T) {
 	}
 }
 
+func TestListExportBatchFiles(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	exportDB := New(testDB)
+	now := time.Now().Truncate(time.Microsecond)
+
+	ec := &model.ExportConfig{
+		BucketName:   "some-bucket",
+		FilenameRoot: "filename-root",
+		Period:       time.Minute,
+		OutputRegion: "US",
+	}
+	if err := exportDB.AddExportConfig(ctx, ec); err != nil {
+		t.Fatal(err)
+	}
+
+	eb := &model.ExportBatch{
+		ConfigID:       ec.ConfigID,
+		BucketName:     ec.BucketName,
+		FilenameRoot:   ec.FilenameRoot,
+		StartTimestamp: now.Add(-2 * time.Hour),
+		EndTimestamp:   now.Add(-time.Hour),
+		OutputRegion:   ec.OutputRegion,
+		Status:         model.ExportBatchOpen,
+	}
+	if err := exportDB.AddExportBatches(ctx, []*model.ExportBatch{eb}); err != nil {
+		t.Fatal(err)
+	}
+	eb, err := exportDB.LeaseBatch(ctx, time.Hour, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := exportDB.ListExportBatchFiles(ctx, eb.BatchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("expected no files before the batch is finalized, got %d", len(got))
+	}
+
+	if err := exportDB.FinalizeBatch(ctx, eb, []string{"file1.zip", "file2.zip"}, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = exportDB.ListExportBatchFiles(ctx, eb.BatchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*model.ExportFile{
+		{
+			BucketName:   eb.BucketName,
+			Filename:     "file1.zip",
+			BatchID:      eb.BatchID,
+			OutputRegion: eb.OutputRegion,
+			BatchNum:     1,
+			BatchSize:    2,
+			Status:       model.ExportBatchComplete,
+		},
+		{
+			BucketName:   eb.BucketName,
+			Filename:     "file2.zip",
+			BatchID:      eb.BatchID,
+			OutputRegion: eb.OutputRegion,
+			BatchNum:     2,
+			BatchSize:    2,
+			Status:       model.ExportBatchComplete,
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
 // TestTravelerKeys ensures traveler keys are pulled in when necessary.
 func TestTravelerKeys(t *testing.T) {
 	t.Parallel()
@@ -539,7 +759,7 @@ func TestTravelerKeys(t *testing.T) {
 
 	// Re-fetch the ExposureBatch by leasing it; this is important to this test which is trying
 	// to ensure our dates are going in-and-out of the database correctly.
-	leased, err := New(testDB).LeaseBatch(ctx, time.Hour, now)
+	leased, err := New(testDB).LeaseBatch(ctx, time.Hour, now, now)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -670,7 +890,7 @@ func TestExcludeRegions(t *testing.T) {
 
 			// Re-fetch the ExposureBatch by leasing it; this is important to this test which is trying
 			// to ensure our dates are going in-and-out of the database correctly.
-			leased, err := New(testDB).LeaseBatch(ctx, time.Hour, now)
+			leased, err := New(testDB).LeaseBatch(ctx, time.Hour, now, now)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -774,7 +994,7 @@ func TestKeysInBatch(t *testing.T) {
 
 	// Re-fetch the ExposureBatch by leasing it; this is important to this test which is trying
 	// to ensure our dates are going in-and-out of the database correctly.
-	leased, err := New(testDB).LeaseBatch(ctx, time.Hour, now)
+	leased, err := New(testDB).LeaseBatch(ctx, time.Hour, now, now)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -831,7 +1051,7 @@ func TestAddExportFileSkipsDuplicates(t *testing.T) {
 		t.Fatal(err)
 	}
 	// Lease the batch to get the ID.
-	eb, err := exportDB.LeaseBatch(ctx, time.Hour, time.Now())
+	eb, err := exportDB.LeaseBatch(ctx, time.Hour, time.Now(), time.Now())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -888,4 +1108,111 @@ func TestAddExportFileSkipsDuplicates(t *testing.T) {
 	}
 }
 
-// TODO(jan25) add TestDeleteFilesBefore. Related to issue #241
+func TestDeleteFilesBefore(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	exportDB := New(testDB)
+	now := time.Now().Truncate(time.Microsecond)
+
+	blobstore, err := storage.NewMemory(ctx, &storage.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Add a config.
+	ec := &model.ExportConfig{
+		BucketName:   "some-bucket",
+		FilenameRoot: "filename-root",
+		Period:       time.Minute,
+		OutputRegion: "US",
+	}
+	if err := exportDB.AddExportConfig(ctx, ec); err != nil {
+		t.Fatal(err)
+	}
+
+	// Add and lease a batch.
+	eb := &model.ExportBatch{
+		ConfigID:       ec.ConfigID,
+		BucketName:     ec.BucketName,
+		FilenameRoot:   ec.FilenameRoot,
+		StartTimestamp: now.Add(-2 * time.Hour),
+		EndTimestamp:   now.Add(-time.Hour),
+		OutputRegion:   ec.OutputRegion,
+		Status:         model.ExportBatchOpen,
+	}
+	if err := exportDB.AddExportBatches(ctx, []*model.ExportBatch{eb}); err != nil {
+		t.Fatal(err)
+	}
+	eb, err = exportDB.LeaseBatch(ctx, time.Hour, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two files are recorded for the batch, the third was written by an
+	// interrupted run and never recorded. The last file belongs to a different
+	// batch and must not be deleted.
+	files := []string{"filename-root/100-200-00001.zip", "filename-root/100-200-00002.zip"}
+	orphan := "filename-root/100-200-00003.zip"
+	other := "filename-root/300-400-00001.zip"
+	for _, name := range append(append([]string{}, files...), orphan, other) {
+		if err := blobstore.CreateObject(ctx, ec.BucketName, name, []byte("contents"), false, storage.ContentTypeZip); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := exportDB.FinalizeBatch(ctx, eb, files, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	sleepTime := time.Second
+	time.Sleep(sleepTime)
+	if _, err := exportDB.MarkExpiredFiles(ctx, eb.ConfigID, sleepTime); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := exportDB.DeleteFilesBefore(ctx, now, blobstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := count, 3; got != want {
+		t.Errorf("expected %d files deleted, got %d", want, got)
+	}
+
+	remaining, _, err := blobstore.ListObjects(ctx, ec.BucketName, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{other}, remaining); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	gotBatch, err := exportDB.LookupExportBatch(ctx, eb.BatchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotBatch.Status != model.ExportBatchDeleted {
+		t.Errorf("gotBatch.Status=%q, want=%q", gotBatch.Status, model.ExportBatchDeleted)
+	}
+}
+
+func TestExportBatchFilePrefix(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		filename string
+		want     string
+	}{
+		{"root/1600000000-1600003600-00001.zip", "root/1600000000-1600003600-"},
+		{"nested/root/1-2-00010.zip", "nested/root/1-2-"},
+		{"my-root/index.txt", ""},
+		{"index.txt", ""},
+	}
+
+	for _, tc := range cases {
+		if got := exportBatchFilePrefix(tc.filename); got != tc.want {
+			t.Errorf("exportBatchFilePrefix(%q) = %q, want %q", tc.filename, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	"github.com/google/exposure-notifications-server/internal/storage"
+	"github.com/google/exposure-notifications-server/pkg/logging"
+
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// exportFileRe matches the portion of an export file name after the filename
+// root, i.e. "<start>-<end>-<num>.zip", or of a file written next to it: the
+// signature sidecar ".sig.json" and the CBOR export file ".cbor".
+var exportFileRe = regexp.MustCompile(`^((\d+)-(\d+)-\d+)(\.zip|\.sig\.json|\.cbor)$`)
+
+// OrphanedFile is an export file in the blobstore that has no matching
+// ExportFile record.
+type OrphanedFile struct {
+	BucketName string
+	Filename   string
+}
+
+// exportFileLocation is a distinct bucket and filename root that export files
+// are written to.
+type exportFileLocation struct {
+	bucketName   string
+	filenameRoot string
+}
+
+// pendingBatch is the time range of an export batch that is currently being
+// processed.
+type pendingBatch struct {
+	start int64
+	end   int64
+}
+
+// DeleteOrphanedFiles finds export files in the blobstore, under the bucket and
+// filename root of every export config, that have no ExportFile record. Files
+// for batches that ended after olderThan and files that belong to a batch that
+// is currently being processed are never considered orphaned, since the
+// ExportFile records are only written once a batch is finalized.
+//
+// If dryRun is true, the orphaned files are returned but not deleted.
+func (db *ExportDB) DeleteOrphanedFiles(ctx context.Context, blobstore storage.Blobstore, olderThan time.Time, dryRun bool) ([]*OrphanedFile, error) {
+	logger := logging.FromContext(ctx).Named("DeleteOrphanedFiles")
+
+	locations, err := db.exportFileLocations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []*OrphanedFile
+	for _, loc := range locations {
+		found, err := db.findOrphanedFiles(ctx, blobstore, loc, olderThan)
+		if err != nil {
+			return nil, fmt.Errorf("finding orphaned files in %s/%s: %w", loc.bucketName, loc.filenameRoot, err)
+		}
+		orphans = append(orphans, found...)
+	}
+
+	for _, o := range orphans {
+		if dryRun {
+			logger.Infow("found orphaned export file", "bucket", o.BucketName, "filename", o.Filename)
+			continue
+		}
+
+		if err := blobstore.DeleteObject(ctx, o.BucketName, o.Filename); err != nil {
+			return nil, fmt.Errorf("delete object: %w", err)
+		}
+		logger.Infow("deleted orphaned export file", "bucket", o.BucketName, "filename", o.Filename)
+	}
+
+	return orphans, nil
+}
+
+func (db *ExportDB) findOrphanedFiles(ctx context.Context, blobstore storage.Blobstore, loc *exportFileLocation, olderThan time.Time) ([]*OrphanedFile, error) {
+	prefix := loc.filenameRoot + "/"
+
+	type candidate struct {
+		name       string
+		exportName string
+		start, end int64
+	}
+	var candidates []*candidate
+	pageToken := ""
+	for {
+		names, nextToken, err := blobstore.ListObjects(ctx, loc.bucketName, prefix, pageToken)
+		if err != nil {
+			return nil, fmt.Errorf("list objects: %w", err)
+		}
+
+		for _, name := range names {
+			exportName, start, end, ok := parseExportFilename(prefix, name)
+			if !ok {
+				// Not an export file, e.g. the index.
+				continue
+			}
+			if time.Unix(end, 0).After(olderThan) {
+				continue
+			}
+			candidates = append(candidates, &candidate{name: name, exportName: exportName, start: start, end: end})
+		}
+
+		if nextToken == "" {
+			break
+		}
+		pageToken = nextToken
+	}
+
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	// Files written next to an export file belong to its ExportFile record.
+	names := make([]string, 0, len(candidates))
+	seen := make(map[string]struct{}, len(candidates))
+	for _, c := range candidates {
+		if _, ok := seen[c.exportName]; ok {
+			continue
+		}
+		seen[c.exportName] = struct{}{}
+		names = append(names, c.exportName)
+	}
+
+	recorded, err := db.recordedExportFiles(ctx, loc.bucketName, names)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := db.pendingBatches(ctx, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []*OrphanedFile
+	for _, c := range candidates {
+		if _, ok := recorded[c.exportName]; ok {
+			continue
+		}
+		if inPendingBatch(pending, c.start, c.end) {
+			continue
+		}
+		orphans = append(orphans, &OrphanedFile{
+			BucketName: loc.bucketName,
+			Filename:   c.name,
+		})
+	}
+	return orphans, nil
+}
+
+// inPendingBatch returns true if a file with the given start and end
+// timestamps could have been written for one of the pending batches. File
+// names for regenerated batches offset both timestamps by the same amount.
+func inPendingBatch(pending []*pendingBatch, start, end int64) bool {
+	for _, p := range pending {
+		if offset := start - p.start; offset >= 0 && end-p.end == offset {
+			return true
+		}
+	}
+	return false
+}
+
+// parseExportFilename parses the name of the export file and its start and
+// end timestamps from a file name created under the given prefix. For a file
+// written next to an export file, the name of the export file is returned. It
+// returns false if the name isn't an export file or written next to one.
+func parseExportFilename(prefix, name string) (string, int64, int64, bool) {
+	if !strings.HasPrefix(name, prefix) {
+		return "", 0, 0, false
+	}
+
+	matches := exportFileRe.FindStringSubmatch(strings.TrimPrefix(name, prefix))
+	if len(matches) != 5 {
+		return "", 0, 0, false
+	}
+
+	start, err := strconv.ParseInt(matches[2], 10, 64)
+	if err != nil {
+		return "", 0, 0, false
+	}
+	end, err := strconv.ParseInt(matches[3], 10, 64)
+	if err != nil {
+		return "", 0, 0, false
+	}
+	return prefix + matches[1] + ".zip", start, end, true
+}
+
+// exportFileLocations returns the distinct bucket and filename roots across
+// all export configs.
+func (db *ExportDB) exportFileLocations(ctx context.Context) ([]*exportFileLocation, error) {
+	var locations []*exportFileLocation
+	if err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+			SELECT DISTINCT
+				bucket_name, filename_root
+			FROM
+				ExportConfig
+		`)
+		if err != nil {
+			return fmt.Errorf("failed to list: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var loc exportFileLocation
+			if err := rows.Scan(&loc.bucketName, &loc.filenameRoot); err != nil {
+				return fmt.Errorf("failed to parse: %w", err)
+			}
+			locations = append(locations, &loc)
+		}
+		return rows.Err()
+	}); err != nil {
+		return nil, fmt.Errorf("export file locations: %w", err)
+	}
+	return locations, nil
+}
+
+// recordedExportFiles returns the subset of the filenames that have an
+// ExportFile record in the given bucket.
+func (db *ExportDB) recordedExportFiles(ctx context.Context, bucketName string, filenames []string) (map[string]struct{}, error) {
+	recorded := make(map[string]struct{}, len(filenames))
+	if err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+			SELECT
+				filename
+			FROM
+				ExportFile
+			WHERE
+				bucket_name = $1 AND filename = ANY($2)
+		`, bucketName, filenames)
+		if err != nil {
+			return fmt.Errorf("failed to list: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var filename string
+			if err := rows.Scan(&filename); err != nil {
+				return fmt.Errorf("failed to parse: %w", err)
+			}
+			recorded[filename] = struct{}{}
+		}
+		return rows.Err()
+	}); err != nil {
+		return nil, fmt.Errorf("recorded export files: %w", err)
+	}
+	return recorded, nil
+}
+
+// pendingBatches returns the batches currently being processed for the given
+// bucket and filename root.
+func (db *ExportDB) pendingBatches(ctx context.Context, loc *exportFileLocation) ([]*pendingBatch, error) {
+	var batches []*pendingBatch
+	if err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+			SELECT
+				start_timestamp, end_timestamp
+			FROM
+				ExportBatch
+			WHERE
+				bucket_name = $1 AND filename_root = $2 AND status = $3
+		`, loc.bucketName, loc.filenameRoot, model.ExportBatchPending)
+		if err != nil {
+			return fmt.Errorf("failed to list: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var start, end time.Time
+			if err := rows.Scan(&start, &end); err != nil {
+				return fmt.Errorf("failed to parse: %w", err)
+			}
+			batches = append(batches, &pendingBatch{start: start.Unix(), end: end.Unix()})
+		}
+		return rows.Err()
+	}); err != nil {
+		return nil, fmt.Errorf("pending batches: %w", err)
+	}
+	return batches, nil
+}
@@ -0,0 +1,202 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/exposure-notifications-server/internal/storage"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDeleteOrphanedFiles(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	exportDB := New(testDB)
+	now := time.Now().UTC().Truncate(time.Second)
+	gracePeriod := 24 * time.Hour
+
+	blobstore, err := storage.NewMemory(ctx, &storage.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ec := &model.ExportConfig{
+		BucketName:   "bucket",
+		FilenameRoot: "root",
+		Period:       time.Hour,
+		OutputRegion: "US",
+	}
+	if err := exportDB.AddExportConfig(ctx, ec); err != nil {
+		t.Fatal(err)
+	}
+
+	// A completed batch, with its file recorded.
+	completed := &model.ExportBatch{
+		ConfigID:       ec.ConfigID,
+		BucketName:     ec.BucketName,
+		FilenameRoot:   ec.FilenameRoot,
+		StartTimestamp: now.Add(-50 * time.Hour),
+		EndTimestamp:   now.Add(-49 * time.Hour),
+		OutputRegion:   ec.OutputRegion,
+		Status:         model.ExportBatchOpen,
+	}
+	if err := exportDB.AddExportBatches(ctx, []*model.ExportBatch{completed}); err != nil {
+		t.Fatal(err)
+	}
+	completed, err = exportDB.LeaseBatch(ctx, time.Hour, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordedFile := fileForBatch(completed, 0, 1)
+	if err := exportDB.FinalizeBatch(ctx, completed, []string{recordedFile}, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// A batch that is currently being processed, its files aren't recorded yet.
+	inProgress := &model.ExportBatch{
+		ConfigID:       ec.ConfigID,
+		BucketName:     ec.BucketName,
+		FilenameRoot:   ec.FilenameRoot,
+		StartTimestamp: now.Add(-49 * time.Hour),
+		EndTimestamp:   now.Add(-48 * time.Hour),
+		OutputRegion:   ec.OutputRegion,
+		Status:         model.ExportBatchOpen,
+	}
+	if err := exportDB.AddExportBatches(ctx, []*model.ExportBatch{inProgress}); err != nil {
+		t.Fatal(err)
+	}
+	inProgress, err = exportDB.LeaseBatch(ctx, time.Hour, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orphans := []string{
+		"root/300-400-00001.cbor",
+		"root/300-400-00001.sig.json",
+		"root/300-400-00001.zip",
+		"root/500-600-00001.sig.json", // without its export file
+	}
+	recentOrphan := fmt.Sprintf("root/%d-%d-00001.zip", now.Add(-time.Hour).Unix(), now.Unix())
+	files := []string{
+		recordedFile,
+		strings.TrimSuffix(recordedFile, ".zip") + ".sig.json",
+		strings.TrimSuffix(recordedFile, ".zip") + ".cbor",
+		fileForBatch(inProgress, 0, 1),
+		fileForBatch(inProgress, 2, 1), // regenerated
+		recentOrphan,
+		"root/index.txt",
+		"root/signatures.txt",
+	}
+	files = append(files, orphans...)
+	for _, name := range files {
+		if err := blobstore.CreateObject(ctx, ec.BucketName, name, []byte("contents"), false, storage.ContentTypeZip); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := make([]*OrphanedFile, 0, len(orphans))
+	for _, name := range orphans {
+		want = append(want, &OrphanedFile{BucketName: ec.BucketName, Filename: name})
+	}
+
+	// Dry run reports, but doesn't delete.
+	got, err := exportDB.DeleteOrphanedFiles(ctx, blobstore, now.Add(-gracePeriod), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("dry run mismatch (-want, +got):\n%s", diff)
+	}
+	for _, name := range orphans {
+		if _, err := blobstore.GetObject(ctx, ec.BucketName, name); err != nil {
+			t.Errorf("expected orphan %q to exist after dry run: %v", name, err)
+		}
+	}
+
+	got, err = exportDB.DeleteOrphanedFiles(ctx, blobstore, now.Add(-gracePeriod), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	remaining, _, err := blobstore.ListObjects(ctx, ec.BucketName, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range files {
+		exists := containsString(remaining, name)
+		isOrphan := containsString(orphans, name)
+		if isOrphan && exists {
+			t.Errorf("expected %q to be deleted", name)
+		}
+		if !isOrphan && !exists {
+			t.Errorf("expected %q to not be deleted", name)
+		}
+	}
+}
+
+func TestParseExportFilename(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name       string
+		wantExport string
+		wantStart  int64
+		wantEnd    int64
+		wantOK     bool
+	}{
+		{"root/100-200-00001.zip", "root/100-200-00001.zip", 100, 200, true},
+		{"root/100-200-00001.sig.json", "root/100-200-00001.zip", 100, 200, true},
+		{"root/100-200-00001.cbor", "root/100-200-00001.zip", 100, 200, true},
+		{"root/index.txt", "", 0, 0, false},
+		{"root/signatures.txt", "", 0, 0, false},
+		{"root/nested/100-200-00001.zip", "", 0, 0, false},
+		{"other/100-200-00001.zip", "", 0, 0, false},
+		{"root/100-200-00001.sig", "", 0, 0, false},
+	}
+
+	for _, tc := range cases {
+		exportName, start, end, ok := parseExportFilename("root/", tc.name)
+		if exportName != tc.wantExport || start != tc.wantStart || end != tc.wantEnd || ok != tc.wantOK {
+			t.Errorf("parseExportFilename(%q) = (%q, %d, %d, %t), want (%q, %d, %d, %t)",
+				tc.name, exportName, start, end, ok, tc.wantExport, tc.wantStart, tc.wantEnd, tc.wantOK)
+		}
+	}
+}
+
+func fileForBatch(eb *model.ExportBatch, regenCount int64, num int) string {
+	return fmt.Sprintf("%s/%d-%d-%05d.zip", eb.FilenameRoot,
+		eb.StartTimestamp.Unix()+regenCount, eb.EndTimestamp.Unix()+regenCount, num)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,268 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	"github.com/google/exposure-notifications-server/internal/storage"
+)
+
+// indexPageSuffix is the suffix of index pages, see readIndex.
+const indexPageSuffix = ".txt"
+
+// IndexDiscrepancyReason describes why an export file's presence in the index
+// doesn't match storage and the database.
+type IndexDiscrepancyReason string
+
+const (
+	// IndexMissingBlob is an index entry for a file that doesn't exist in the
+	// blobstore.
+	IndexMissingBlob IndexDiscrepancyReason = "missing_blob"
+	// IndexMissingRecord is an index entry for a file that has no current
+	// ExportFile record, either because it was never recorded or because it
+	// has expired.
+	IndexMissingRecord IndexDiscrepancyReason = "missing_record"
+	// IndexMissingEntry is a recorded file that exists in the blobstore, but
+	// isn't listed in the index.
+	IndexMissingEntry IndexDiscrepancyReason = "missing_entry"
+)
+
+// IndexDiscrepancy is a single export file that is incorrectly listed in, or
+// missing from, an index.
+type IndexDiscrepancy struct {
+	Filename string
+	Reason   IndexDiscrepancyReason
+}
+
+// IndexReconciliation is the result of comparing an export config's index
+// against storage and the database.
+type IndexReconciliation struct {
+	// Indexed is the current contents of the index.
+	Indexed []string
+	// Expected is the sorted list of files the index should contain.
+	Expected []string
+	// Discrepancies are the differences between Indexed and Expected.
+	Discrepancies []*IndexDiscrepancy
+}
+
+// ReconcileIndex compares the index file for the export config with the
+// unexpired ExportFile records (see LookupExportFiles) and the files that exist
+// in the blobstore. A file belongs in the index only if it has a record and it
+// exists. Index entries for batches that are currently being processed are
+// kept as long as the file exists, since those records are only written once
+// the batch is finalized.
+//
+// The index isn't modified, callers should write Expected if there are any
+// discrepancies.
+func (db *ExportDB) ReconcileIndex(ctx context.Context, blobstore storage.Blobstore, ec *model.ExportConfig, indexFilename string, ttl time.Duration) (*IndexReconciliation, error) {
+	indexed, err := readIndex(ctx, blobstore, ec.BucketName, indexFilename)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := ec.FilenameRoot + "/"
+	existing, err := listObjects(ctx, blobstore, ec.BucketName, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := db.LookupExportFiles(ctx, ec.ConfigID, ttl)
+	if err != nil {
+		return nil, err
+	}
+	recorded := make(map[string]struct{}, len(files))
+	for _, f := range files {
+		recorded[f] = struct{}{}
+	}
+
+	pending, err := db.pendingBatches(ctx, &exportFileLocation{
+		bucketName:   ec.BucketName,
+		filenameRoot: ec.FilenameRoot,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &IndexReconciliation{Indexed: indexed}
+	expected := make(map[string]struct{}, len(files))
+	inIndex := make(map[string]struct{}, len(indexed))
+	for _, name := range indexed {
+		inIndex[name] = struct{}{}
+
+		if _, ok := existing[name]; !ok {
+			result.Discrepancies = append(result.Discrepancies, &IndexDiscrepancy{Filename: name, Reason: IndexMissingBlob})
+			continue
+		}
+		if _, ok := recorded[name]; !ok {
+			if _, start, end, ok := parseExportFilename(prefix, name); !ok || !inPendingBatch(pending, start, end) {
+				result.Discrepancies = append(result.Discrepancies, &IndexDiscrepancy{Filename: name, Reason: IndexMissingRecord})
+				continue
+			}
+		}
+		expected[name] = struct{}{}
+	}
+
+	for _, name := range files {
+		if _, ok := existing[name]; !ok {
+			continue
+		}
+		if _, ok := inIndex[name]; !ok {
+			result.Discrepancies = append(result.Discrepancies, &IndexDiscrepancy{Filename: name, Reason: IndexMissingEntry})
+		}
+		expected[name] = struct{}{}
+	}
+
+	result.Expected = make([]string, 0, len(expected))
+	for name := range expected {
+		result.Expected = append(result.Expected, name)
+	}
+	sort.Strings(result.Expected)
+
+	sort.Slice(result.Discrepancies, func(i, j int) bool {
+		return result.Discrepancies[i].Filename < result.Discrepancies[j].Filename
+	})
+
+	return result, nil
+}
+
+// IndexBackfill is the result of adding recorded export files that are missing
+// from an export config's index.
+type IndexBackfill struct {
+	// Indexed is the current contents of the index.
+	Indexed []string
+	// Added are the recorded files that exist in the blobstore, but aren't in
+	// the index.
+	Added []string
+	// MissingBlobs are the recorded files that don't exist in the blobstore,
+	// and can't be added.
+	MissingBlobs []string
+	// Entries is the sorted list of files the index should contain, the
+	// current entries and Added.
+	Entries []string
+}
+
+// BackfillIndex finds the unexpired ExportFile records (see LookupExportFiles)
+// that exist in the blobstore, but are missing from the index file for the
+// export config. Unlike ReconcileIndex, existing index entries are kept as
+// they are, even if they have no record or their file doesn't exist.
+//
+// The index isn't modified, callers should write Entries if anything was
+// added.
+func (db *ExportDB) BackfillIndex(ctx context.Context, blobstore storage.Blobstore, ec *model.ExportConfig, indexFilename string, ttl time.Duration) (*IndexBackfill, error) {
+	indexed, err := readIndex(ctx, blobstore, ec.BucketName, indexFilename)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := db.LookupExportFiles(ctx, ec.ConfigID, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := listObjects(ctx, blobstore, ec.BucketName, ec.FilenameRoot+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	result := &IndexBackfill{Indexed: indexed}
+	entries := make(map[string]struct{}, len(indexed)+len(files))
+	for _, name := range indexed {
+		entries[name] = struct{}{}
+	}
+	for _, name := range files {
+		if _, ok := entries[name]; ok {
+			continue
+		}
+		if _, ok := existing[name]; !ok {
+			result.MissingBlobs = append(result.MissingBlobs, name)
+			continue
+		}
+		result.Added = append(result.Added, name)
+		entries[name] = struct{}{}
+	}
+	sort.Strings(result.Added)
+	sort.Strings(result.MissingBlobs)
+
+	result.Entries = make([]string, 0, len(entries))
+	for name := range entries {
+		result.Entries = append(result.Entries, name)
+	}
+	sort.Strings(result.Entries)
+
+	return result, nil
+}
+
+// readIndex returns the entries in an index file. A missing index has no
+// entries. If the index is split into pages, its last entry is the name of the
+// next older page, which ends in .txt. The pages are followed and the entries
+// of all pages are returned.
+func readIndex(ctx context.Context, blobstore storage.Blobstore, bucketName, indexFilename string) ([]string, error) {
+	var entries []string
+	seen := make(map[string]struct{})
+	for name := indexFilename; name != ""; {
+		if _, ok := seen[name]; ok {
+			return nil, fmt.Errorf("read index %s/%s: page %s is listed twice", bucketName, indexFilename, name)
+		}
+		seen[name] = struct{}{}
+
+		data, err := blobstore.GetObject(ctx, bucketName, name)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				break
+			}
+			return nil, fmt.Errorf("read index %s/%s: %w", bucketName, name, err)
+		}
+
+		name = ""
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line == "" {
+				continue
+			}
+			if strings.HasSuffix(line, indexPageSuffix) {
+				name = line
+				continue
+			}
+			entries = append(entries, line)
+		}
+	}
+	return entries, nil
+}
+
+// listObjects returns the names of all objects in the bucket with the prefix.
+func listObjects(ctx context.Context, blobstore storage.Blobstore, bucketName, prefix string) (map[string]struct{}, error) {
+	names := make(map[string]struct{})
+	pageToken := ""
+	for {
+		page, nextToken, err := blobstore.ListObjects(ctx, bucketName, prefix, pageToken)
+		if err != nil {
+			return nil, fmt.Errorf("list objects: %w", err)
+		}
+		for _, name := range page {
+			names[name] = struct{}{}
+		}
+
+		if nextToken == "" {
+			return names, nil
+		}
+		pageToken = nextToken
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/exposure-notifications-server/internal/storage"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReconcileIndex(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	exportDB := New(testDB)
+	now := time.Now().UTC().Truncate(time.Second)
+	ttl := 14 * 24 * time.Hour
+
+	blobstore, err := storage.NewMemory(ctx, &storage.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ec := &model.ExportConfig{
+		BucketName:   "bucket",
+		FilenameRoot: "root",
+		Period:       time.Hour,
+		OutputRegion: "US",
+	}
+	if err := exportDB.AddExportConfig(ctx, ec); err != nil {
+		t.Fatal(err)
+	}
+	indexName := "root/index.txt"
+
+	// A completed batch with three recorded files.
+	completed := &model.ExportBatch{
+		ConfigID:       ec.ConfigID,
+		BucketName:     ec.BucketName,
+		FilenameRoot:   ec.FilenameRoot,
+		StartTimestamp: now.Add(-50 * time.Hour),
+		EndTimestamp:   now.Add(-49 * time.Hour),
+		OutputRegion:   ec.OutputRegion,
+		Status:         model.ExportBatchOpen,
+	}
+	if err := exportDB.AddExportBatches(ctx, []*model.ExportBatch{completed}); err != nil {
+		t.Fatal(err)
+	}
+	completed, err = exportDB.LeaseBatch(ctx, time.Hour, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexedFile := fileForBatch(completed, 0, 1)
+	unindexedFile := fileForBatch(completed, 0, 2)
+	unwrittenFile := fileForBatch(completed, 0, 3)
+	if err := exportDB.FinalizeBatch(ctx, completed, []string{indexedFile, unindexedFile, unwrittenFile}, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	// A batch that is currently being processed, its files aren't recorded yet.
+	inProgress := &model.ExportBatch{
+		ConfigID:       ec.ConfigID,
+		BucketName:     ec.BucketName,
+		FilenameRoot:   ec.FilenameRoot,
+		StartTimestamp: now.Add(-49 * time.Hour),
+		EndTimestamp:   now.Add(-48 * time.Hour),
+		OutputRegion:   ec.OutputRegion,
+		Status:         model.ExportBatchOpen,
+	}
+	if err := exportDB.AddExportBatches(ctx, []*model.ExportBatch{inProgress}); err != nil {
+		t.Fatal(err)
+	}
+	inProgress, err = exportDB.LeaseBatch(ctx, time.Hour, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inProgressFile := fileForBatch(inProgress, 0, 1)
+
+	// A file that exists, but was never recorded.
+	unrecordedFile := "root/300-400-00001.zip"
+
+	for _, name := range []string{indexedFile, unindexedFile, inProgressFile, unrecordedFile} {
+		if err := blobstore.CreateObject(ctx, ec.BucketName, name, []byte("contents"), false, storage.ContentTypeZip); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("missing_index", func(t *testing.T) {
+		got, err := exportDB.ReconcileIndex(ctx, blobstore, ec, indexName, ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := &IndexReconciliation{
+			Expected: []string{indexedFile, unindexedFile},
+			Discrepancies: []*IndexDiscrepancy{
+				{Filename: indexedFile, Reason: IndexMissingEntry},
+				{Filename: unindexedFile, Reason: IndexMissingEntry},
+			},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("mismatch (-want, +got):\n%s", diff)
+		}
+	})
+
+	indexed := []string{indexedFile, unwrittenFile, inProgressFile, unrecordedFile}
+	if err := blobstore.CreateObject(ctx, ec.BucketName, indexName, []byte(strings.Join(indexed, "\n")), false, storage.ContentTypeTextPlain); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("inconsistent", func(t *testing.T) {
+		got, err := exportDB.ReconcileIndex(ctx, blobstore, ec, indexName, ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := &IndexReconciliation{
+			Indexed:  indexed,
+			Expected: []string{indexedFile, unindexedFile, inProgressFile},
+			Discrepancies: []*IndexDiscrepancy{
+				{Filename: unindexedFile, Reason: IndexMissingEntry},
+				{Filename: unwrittenFile, Reason: IndexMissingBlob},
+				{Filename: unrecordedFile, Reason: IndexMissingRecord},
+			},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("mismatch (-want, +got):\n%s", diff)
+		}
+	})
+
+	expected := []string{indexedFile, unindexedFile, inProgressFile}
+	if err := blobstore.CreateObject(ctx, ec.BucketName, indexName, []byte(strings.Join(expected, "\n")), false, storage.ContentTypeTextPlain); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("consistent", func(t *testing.T) {
+		got, err := exportDB.ReconcileIndex(ctx, blobstore, ec, indexName, ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := &IndexReconciliation{
+			Indexed:  expected,
+			Expected: expected,
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("mismatch (-want, +got):\n%s", diff)
+		}
+	})
+}
+
+func TestBackfillIndex(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	exportDB := New(testDB)
+	now := time.Now().UTC().Truncate(time.Second)
+	ttl := 14 * 24 * time.Hour
+
+	blobstore, err := storage.NewMemory(ctx, &storage.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ec := &model.ExportConfig{
+		BucketName:   "bucket",
+		FilenameRoot: "root",
+		Period:       time.Hour,
+		OutputRegion: "US",
+	}
+	if err := exportDB.AddExportConfig(ctx, ec); err != nil {
+		t.Fatal(err)
+	}
+	indexName := "root/index.txt"
+
+	eb := &model.ExportBatch{
+		ConfigID:       ec.ConfigID,
+		BucketName:     ec.BucketName,
+		FilenameRoot:   ec.FilenameRoot,
+		StartTimestamp: now.Add(-50 * time.Hour),
+		EndTimestamp:   now.Add(-49 * time.Hour),
+		OutputRegion:   ec.OutputRegion,
+		Status:         model.ExportBatchOpen,
+	}
+	if err := exportDB.AddExportBatches(ctx, []*model.ExportBatch{eb}); err != nil {
+		t.Fatal(err)
+	}
+	eb, err = exportDB.LeaseBatch(ctx, time.Hour, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexedFile := fileForBatch(eb, 0, 1)
+	unindexedFile := fileForBatch(eb, 0, 2)
+	unwrittenFile := fileForBatch(eb, 0, 3)
+	if err := exportDB.FinalizeBatch(ctx, eb, []string{indexedFile, unindexedFile, unwrittenFile}, 3); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{indexedFile, unindexedFile} {
+		if err := blobstore.CreateObject(ctx, ec.BucketName, name, []byte("contents"), false, storage.ContentTypeZip); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The index omits a recorded file, and lists one that was never recorded,
+	// which is kept.
+	unrecordedFile := "root/300-400-00001.zip"
+	indexed := []string{indexedFile, unrecordedFile}
+	if err := blobstore.CreateObject(ctx, ec.BucketName, indexName, []byte(strings.Join(indexed, "\n")), false, storage.ContentTypeTextPlain); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := exportDB.BackfillIndex(ctx, blobstore, ec, indexName, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &IndexBackfill{
+		Indexed:      indexed,
+		Added:        []string{unindexedFile},
+		MissingBlobs: []string{unwrittenFile},
+		Entries:      []string{indexedFile, unindexedFile, unrecordedFile},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestReadIndex_Pages(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	blobstore, err := storage.NewMemory(ctx, &storage.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := map[string]string{
+		"root/index.txt":   "root/5.zip\nroot/6.zip\nroot/index-1.txt",
+		"root/index-1.txt": "root/3.zip\nroot/4.zip\nroot/index-2.txt",
+		"root/index-2.txt": "root/1.zip\nroot/2.zip",
+		"loop/index.txt":   "loop/1.zip\nloop/index-1.txt",
+		"loop/index-1.txt": "loop/2.zip\nloop/index.txt",
+	}
+	for name, data := range pages {
+		if err := blobstore.CreateObject(ctx, "bucket", name, []byte(data), false, storage.ContentTypeTextPlain); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := readIndex(ctx, blobstore, "bucket", "root/index.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"root/5.zip", "root/6.zip", "root/3.zip", "root/4.zip", "root/1.zip", "root/2.zip"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	if _, err := readIndex(ctx, blobstore, "bucket", "loop/index.txt"); err == nil || !strings.Contains(err.Error(), "listed twice") {
+		t.Errorf("expected error for looping pages, got %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	publishdatabase "github.com/google/exposure-notifications-server/internal/publish/database"
+	"github.com/google/exposure-notifications-server/internal/storage"
+	"github.com/google/exposure-notifications-server/pkg/logging"
+)
+
+// deltaBatch returns the batch of the delta export for the window that ends
+// with the batch. The delta files are named like batch files, under the delta
+// root of the window.
+func deltaBatch(eb *model.ExportBatch, window time.Duration) *model.ExportBatch {
+	delta := *eb
+	delta.StartTimestamp = eb.EndTimestamp.Add(-window)
+	delta.FilenameRoot = deltaFilenameRoot(eb.FilenameRoot, window)
+	// Deltas are only written as protobuf zip files.
+	delta.IncludeCBOR = false
+	return &delta
+}
+
+// deltaFilenameRoot returns the root of the delta files of a window, e.g.
+// "us/delta/24h".
+func deltaFilenameRoot(filenameRoot string, window time.Duration) string {
+	return fmt.Sprintf("%s/delta/%dh", filenameRoot, window/time.Hour)
+}
+
+// writeDeltaExports writes the delta exports of the configured windows for the
+// batch. Failures are logged, but never fail the batch, the previous delta
+// remains in place until the next batch.
+func (s *Server) writeDeltaExports(ctx context.Context, eb *model.ExportBatch, criteria publishdatabase.IterateExposuresCriteria, maxRecords int, sigInfos []*model.SignatureInfo, attrs, indexAttrs *storage.ObjectAttrs) {
+	logger := logging.FromContext(ctx).Named("writeDeltaExports")
+
+	for _, window := range s.config.DeltaWindows {
+		if _, err := s.writeDeltaExport(ctx, eb, criteria, window, maxRecords, sigInfos, attrs, indexAttrs); err != nil {
+			logger.Errorw("failed to write delta export", "batch_id", eb.BatchID, "window", window, "error", err)
+		}
+	}
+}
+
+// writeDeltaExport writes the keys that were published or revised in the
+// window before the end of the batch, and replaces the delta index with the
+// new files. The files that were listed in the previous index are deleted.
+//
+// Unlike batches, deltas aren't padded. The keys of a delta were already
+// exported, and padded, with their batches.
+func (s *Server) writeDeltaExport(ctx context.Context, eb *model.ExportBatch, criteria publishdatabase.IterateExposuresCriteria, window time.Duration, maxRecords int, sigInfos []*model.SignatureInfo, attrs, indexAttrs *storage.ObjectAttrs) (*exportFiles, error) {
+	logger := logging.FromContext(ctx)
+
+	delta := deltaBatch(eb, window)
+	criteria.SinceTimestamp = delta.StartTimestamp
+	criteria.UntilTimestamp = delta.EndTimestamp
+
+	primaryKeys, revisedKeys, _, err := s.readExposures(ctx, criteria, batchKeySelection(eb))
+	if err != nil {
+		return nil, fmt.Errorf("reading exposures for delta: %w", err)
+	}
+	files, err := s.writeGroups(ctx, delta, groupExposures(primaryKeys, revisedKeys, maxRecords), sigInfos, attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	indexObjectName := indexFilename(delta.FilenameRoot)
+	previous, err := s.readIndex(ctx, delta.BucketName, indexObjectName)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.writeIndex(ctx, delta.BucketName, indexObjectName, files.objectNames, indexAttrs); err != nil {
+		return nil, err
+	}
+	logger.Infof("Wrote delta export %q with %d files for batch %d", indexObjectName, len(files.objectNames), eb.BatchID)
+
+	current := make(map[string]struct{}, len(files.objectNames))
+	for _, name := range files.objectNames {
+		current[name] = struct{}{}
+	}
+	for _, name := range previous {
+		if _, ok := current[name]; ok {
+			continue
+		}
+		if err := s.deleteObject(ctx, delta.BucketName, name); err != nil {
+			// The file is no longer listed, it is only left behind.
+			logger.Warnw("failed to delete previous delta file", "object", name, "error", err)
+		}
+		if s.config.SignatureSidecar {
+			if err := s.deleteObject(ctx, delta.BucketName, sidecarFilename(name)); err != nil {
+				logger.Warnw("failed to delete previous delta signature sidecar", "object", name, "error", err)
+			}
+		}
+	}
+	return files, nil
+}
+
+// readIndex returns the object names listed in an index file, or nil if the
+// index doesn't exist.
+func (s *Server) readIndex(ctx context.Context, bucketName, indexObjectName string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, blobOperationTimeout)
+	defer cancel()
+
+	data, err := s.env.Blobstore().GetObject(ctx, bucketName, indexObjectName)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading index file %s in bucket %s: %w", indexObjectName, bucketName, err)
+	}
+
+	var names []string
+	for _, name := range strings.Split(string(data), "\n") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (s *Server) deleteObject(ctx context.Context, bucketName, objectName string) error {
+	ctx, cancel := context.WithTimeout(ctx, blobOperationTimeout)
+	defer cancel()
+	return s.env.Blobstore().DeleteObject(ctx, bucketName, objectName)
+}
@@ -0,0 +1,219 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	"github.com/google/exposure-notifications-server/internal/project"
+	publishdb "github.com/google/exposure-notifications-server/internal/publish/database"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/storage"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1alpha1"
+	"github.com/google/exposure-notifications-server/pkg/database"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDeltaBatch(t *testing.T) {
+	t.Parallel()
+
+	end := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	eb := &model.ExportBatch{
+		BatchID:        4,
+		FilenameRoot:   "us",
+		StartTimestamp: end.Add(-time.Hour),
+		EndTimestamp:   end,
+	}
+
+	delta := deltaBatch(eb, 24*time.Hour)
+	if got, want := delta.StartTimestamp, end.Add(-24*time.Hour); !got.Equal(want) {
+		t.Errorf("expected start %v to be %v", got, want)
+	}
+	if got, want := delta.EndTimestamp, end; !got.Equal(want) {
+		t.Errorf("expected end %v to be %v", got, want)
+	}
+	if got, want := exportFilename(delta, 1, 0), "us/delta/24h/1622462400-1622548800-00001.zip"; got != want {
+		t.Errorf("expected filename %q to be %q", got, want)
+	}
+	if got, want := indexFilename(delta.FilenameRoot), "us/delta/24h/index.txt"; got != want {
+		t.Errorf("expected index %q to be %q", got, want)
+	}
+
+	// The batch is unchanged.
+	if got, want := eb.FilenameRoot, "us"; got != want {
+		t.Errorf("expected batch root %q to be %q", got, want)
+	}
+}
+
+func TestWriteDeltaExport(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	server := newStreamTestServer(ctx, t, testDB, false)
+
+	baseTime := time.Now().UTC().Add(-48 * time.Hour).Truncate(time.Hour)
+
+	// Keys from before the window, one of which is revised in it, and keys
+	// published in the window.
+	before := insertDeltaTestExposures(ctx, t, testDB, 3, baseTime.Add(10*time.Minute))
+	inWindow := insertDeltaTestExposures(ctx, t, testDB, 4, baseTime.Add(2*time.Hour+10*time.Minute))
+	reviseDeltaTestExposure(ctx, t, testDB, before[0], baseTime.Add(2*time.Hour+20*time.Minute))
+
+	eb := &model.ExportBatch{
+		BatchID:        1,
+		ConfigID:       1,
+		BucketName:     "bucket",
+		FilenameRoot:   "us",
+		StartTimestamp: baseTime.Add(2 * time.Hour),
+		EndTimestamp:   baseTime.Add(3 * time.Hour),
+		OutputRegion:   "US",
+		InputRegions:   []string{"US"},
+	}
+	criteria := publishdb.IterateExposuresCriteria{
+		SinceTimestamp: eb.StartTimestamp,
+		UntilTimestamp: eb.EndTimestamp,
+		IncludeRegions: eb.InputRegions,
+	}
+	attrs := storage.DefaultObjectAttrs(true, storage.ContentTypeZip)
+	indexAttrs := storage.DefaultObjectAttrs(false, storage.ContentTypeTextPlain)
+
+	files, err := server.writeDeltaExport(ctx, eb, criteria, time.Hour, 100, nil, attrs, indexAttrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(files.objectNames), 1; got != want {
+		t.Fatalf("expected %d files, got %d", want, got)
+	}
+
+	data, err := server.env.Blobstore().GetObject(ctx, eb.BucketName, files.objectNames[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	export, _, err := UnmarshalExportFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := export.GetStartTimestamp(), uint64(baseTime.Add(2*time.Hour).Unix()); got != want {
+		t.Errorf("expected start timestamp %d to be %d", got, want)
+	}
+	if got, want := export.GetEndTimestamp(), uint64(eb.EndTimestamp.Unix()); got != want {
+		t.Errorf("expected end timestamp %d to be %d", got, want)
+	}
+
+	// Only the keys published in the window are new, and only the revision
+	// in the window is included.
+	gotKeys := make([]string, 0, len(export.Keys))
+	for _, k := range export.Keys {
+		gotKeys = append(gotKeys, string(k.GetKeyData()))
+	}
+	wantKeys := make([]string, 0, len(inWindow))
+	for _, exp := range inWindow {
+		wantKeys = append(wantKeys, string(exp.ExposureKey))
+	}
+	sort.Strings(gotKeys)
+	sort.Strings(wantKeys)
+	if diff := cmp.Diff(wantKeys, gotKeys); diff != "" {
+		t.Errorf("keys mismatch (-want, +got):\n%s", diff)
+	}
+	if got, want := len(export.RevisedKeys), 1; got != want {
+		t.Fatalf("expected %d revised keys, got %d", want, got)
+	}
+	if got, want := string(export.RevisedKeys[0].GetKeyData()), string(before[0].ExposureKey); got != want {
+		t.Errorf("expected revised key %x to be %x", got, want)
+	}
+
+	index, err := server.readIndex(ctx, eb.BucketName, "us/delta/1h/index.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(files.objectNames, index); diff != "" {
+		t.Errorf("index mismatch (-want, +got):\n%s", diff)
+	}
+
+	// The next batch replaces the delta, and the previous files are deleted.
+	next := *eb
+	next.BatchID = 2
+	next.StartTimestamp = eb.EndTimestamp
+	next.EndTimestamp = eb.EndTimestamp.Add(time.Hour)
+	criteria.SinceTimestamp = next.StartTimestamp
+	criteria.UntilTimestamp = next.EndTimestamp
+
+	nextFiles, err := server.writeDeltaExport(ctx, &next, criteria, time.Hour, 100, nil, attrs, indexAttrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(nextFiles.objectNames); got != 0 {
+		t.Errorf("expected no files, got %d", got)
+	}
+
+	index, err = server.readIndex(ctx, eb.BucketName, "us/delta/1h/index.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index) != 0 {
+		t.Errorf("expected empty index, got %v", index)
+	}
+	if _, err := server.env.Blobstore().GetObject(ctx, eb.BucketName, files.objectNames[0]); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected previous delta file to be deleted, got %v", err)
+	}
+}
+
+func insertDeltaTestExposures(ctx context.Context, tb testing.TB, db *database.DB, numKeys int, createdAt time.Time) []*publishmodel.Exposure {
+	tb.Helper()
+
+	exposures := make([]*publishmodel.Exposure, 0, numKeys)
+	for i := 0; i < numKeys; i++ {
+		exposures = append(exposures, &publishmodel.Exposure{
+			ExposureKey:     randomTEK(tb),
+			Regions:         []string{"US"},
+			IntervalNumber:  100,
+			IntervalCount:   144,
+			CreatedAt:       createdAt,
+			LocalProvenance: true,
+			ReportType:      verifyapi.ReportTypeClinical,
+		})
+	}
+	if _, err := publishdb.New(db).InsertAndReviseExposures(ctx, &publishdb.InsertAndReviseExposuresRequest{
+		Incoming: exposures,
+	}); err != nil {
+		tb.Fatalf("inserting exposures: %v", err)
+	}
+	return exposures
+}
+
+func reviseDeltaTestExposure(ctx context.Context, tb testing.TB, db *database.DB, exp *publishmodel.Exposure, revisedAt time.Time) {
+	tb.Helper()
+
+	revision := &publishmodel.Exposure{
+		ExposureKey:     exp.ExposureKey,
+		Regions:         exp.Regions,
+		IntervalNumber:  exp.IntervalNumber,
+		IntervalCount:   exp.IntervalCount,
+		CreatedAt:       revisedAt,
+		LocalProvenance: exp.LocalProvenance,
+		ReportType:      verifyapi.ReportTypeConfirmed,
+	}
+	if _, err := publishdb.New(db).InsertAndReviseExposures(ctx, &publishdb.InsertAndReviseExposuresRequest{
+		Incoming: []*publishmodel.Exposure{revision},
+	}); err != nil {
+		tb.Fatalf("revising exposure: %v", err)
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"fmt"
+	"hash"
 	"io"
 	"sort"
 
@@ -30,6 +31,7 @@ import (
 
 	"github.com/google/exposure-notifications-server/internal/pb/export"
 
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -65,7 +67,42 @@ func MarshalExportFile(eb *model.ExportBatch, exposures, revisedExposures []*pub
 		return nil, fmt.Errorf("unable to marshal signature file: %w", err)
 	}
 
-	// create compressed archive of binary and signature
+	return zipExportFile(expContents, sigContents)
+}
+
+// ResignExportFile replaces the signatures of an existing export file with
+// signatures from the given signers. The export.bin contents are preserved
+// byte for byte, including the signature infos they were created with, so
+// the keys and their digest are unchanged.
+func ResignExportFile(zippedProtoPayload []byte, signers []*Signer) ([]byte, error) {
+	zp, err := zip.NewReader(bytes.NewReader(zippedProtoPayload), int64(len(zippedProtoPayload)))
+	if err != nil {
+		return nil, fmt.Errorf("can't read payload: %w", err)
+	}
+
+	var expContents []byte
+	for _, file := range zp.File {
+		if file.Name == exportBinaryName {
+			if expContents, err = readZipFile(file); err != nil {
+				return nil, fmt.Errorf("unable to read %v: %w", exportBinaryName, err)
+			}
+			break
+		}
+	}
+	if expContents == nil {
+		return nil, fmt.Errorf("payload is invalid: no %v file was found", exportBinaryName)
+	}
+
+	sigContents, err := marshalSignature(expContents, signers)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal signature file: %w", err)
+	}
+	return zipExportFile(expContents, sigContents)
+}
+
+// zipExportFile creates the compressed archive of the export binary and
+// signature.
+func zipExportFile(expContents, sigContents []byte) ([]byte, error) {
 	buf := new(bytes.Buffer)
 	zw := zip.NewWriter(buf)
 	zf, err := zw.Create(exportBinaryName)
@@ -112,13 +149,7 @@ func UnmarshalExportFile(zippedProtoPayload []byte) (*export.TemporaryExposureKe
 }
 
 func unmarshalContent(file *zip.File) (*export.TemporaryExposureKeyExport, []byte, error) {
-	f, err := file.Open()
-	if err != nil {
-		return nil, nil, err
-	}
-	defer f.Close()
-
-	content, err := io.ReadAll(f)
+	content, err := readZipFile(file)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -139,6 +170,16 @@ func unmarshalContent(file *zip.File) (*export.TemporaryExposureKeyExport, []byt
 	return message, digest[:], nil
 }
 
+func readZipFile(file *zip.File) ([]byte, error) {
+	f, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
+
 func sortExposures(exposures []*publishmodel.Exposure) {
 	sort.Slice(exposures, func(i, j int) bool {
 		return bytes.Compare(exposures[i].ExposureKey, exposures[j].ExposureKey) < 0
@@ -197,23 +238,27 @@ func marshalContents(eb *model.ExportBatch, exposures, revisedExposures []*publi
 	sortExposures(exposures)
 	pbeks := make([]*export.TemporaryExposureKey, 0, len(exposures))
 	for _, exp := range exposures {
-		pbek := makeTEK(exp)
-		assignReportType(&exp.ReportType, pbek)
-		if exp.HasDaysSinceSymptomOnset() {
-			pbek.DaysSinceOnsetOfSymptoms = proto.Int32(*exp.DaysSinceSymptomOnset)
-		}
-		pbeks = append(pbeks, pbek)
+		pbeks = append(pbeks, makeExportTEK(exp, eb.DefaultReportType))
 	}
 
 	sortExposures(revisedExposures)
 	pbRevisedKeys := make([]*export.TemporaryExposureKey, 0, len(revisedExposures))
 	for _, exp := range revisedExposures {
-		pbek := makeTEK(exp)
-		assignReportType(exp.RevisedReportType, pbek)
-		pbek.DaysSinceOnsetOfSymptoms = exp.RevisedDaysSinceSymptomOnset
-		pbRevisedKeys = append(pbRevisedKeys, pbek)
+		pbRevisedKeys = append(pbRevisedKeys, makeRevisedTEK(exp, eb.DefaultReportType))
+	}
+
+	pbeke := exportHeader(eb, fileNum, splitBatch, signers)
+	pbeke.Keys = pbeks
+	pbeke.RevisedKeys = pbRevisedKeys
+	protoBytes, err := proto.Marshal(pbeke)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal exposure keys: %w", err)
 	}
+	return append(exportBytes, protoBytes...), nil
+}
 
+// exportHeader returns the export message for the file, without any keys.
+func exportHeader(eb *model.ExportBatch, fileNum int32, splitBatch bool, signers []*Signer) *export.TemporaryExposureKeyExport {
 	exportSigInfos := make([]*export.SignatureInfo, 0, len(signers))
 	for _, si := range signers {
 		exportSigInfos = append(exportSigInfos, createSignatureInfo(si.SignatureInfo))
@@ -223,21 +268,160 @@ func marshalContents(eb *model.ExportBatch, exposures, revisedExposures []*publi
 	if splitBatch {
 		offset = int64(fileNum)
 	}
-	pbeke := export.TemporaryExposureKeyExport{
+	return &export.TemporaryExposureKeyExport{
 		StartTimestamp: proto.Uint64(uint64(eb.StartTimestamp.Unix())),
 		EndTimestamp:   proto.Uint64(uint64(eb.EndTimestamp.Unix() + offset)),
 		Region:         proto.String(eb.OutputRegion),
 		BatchNum:       proto.Int32(1), // all batches are now size 1 (single file)
 		BatchSize:      proto.Int32(1), // so it's always 1 of 1.
-		Keys:           pbeks,
-		RevisedKeys:    pbRevisedKeys,
 		SignatureInfos: exportSigInfos,
 	}
-	protoBytes, err := proto.Marshal(&pbeke)
+}
+
+// assignDefaultReportType sets the report type of a key that doesn't have a
+// known one to the default of the export config, if it has one.
+func assignDefaultReportType(defaultReportType string, pbek *export.TemporaryExposureKey) {
+	if pbek.ReportType != nil || defaultReportType == "" {
+		return
+	}
+	assignReportType(&defaultReportType, pbek)
+}
+
+// makeExportTEK converts a new key for the export.
+func makeExportTEK(exp *publishmodel.Exposure, defaultReportType string) *export.TemporaryExposureKey {
+	pbek := makeTEK(exp)
+	assignReportType(&exp.ReportType, pbek)
+	assignDefaultReportType(defaultReportType, pbek)
+	if exp.HasDaysSinceSymptomOnset() {
+		pbek.DaysSinceOnsetOfSymptoms = proto.Int32(*exp.DaysSinceSymptomOnset)
+	}
+	return pbek
+}
+
+// makeRevisedTEK converts a revised key for the export.
+func makeRevisedTEK(exp *publishmodel.Exposure, defaultReportType string) *export.TemporaryExposureKey {
+	pbek := makeTEK(exp)
+	assignReportType(exp.RevisedReportType, pbek)
+	assignDefaultReportType(defaultReportType, pbek)
+	pbek.DaysSinceOnsetOfSymptoms = exp.RevisedDaysSinceSymptomOnset
+	return pbek
+}
+
+// Field numbers of the keys in the TemporaryExposureKeyExport message. They are
+// the last fields of the message, so the encoded keys can follow the encoded
+// header.
+const (
+	exportKeysFieldNum        protowire.Number = 7
+	exportRevisedKeysFieldNum protowire.Number = 8
+)
+
+// exportFileWriter writes an export file as keys are added, instead of
+// building the whole export in memory first. The export.bin contents are
+// hashed as they are written and signed on Close. Writing the same keys in
+// order produces the same file as MarshalExportFile.
+type exportFileWriter struct {
+	zw      *zip.Writer
+	bin     io.Writer
+	digest  hash.Hash
+	signers []*Signer
+
+	defaultReportType string
+
+	// revised is set once the first revised key has been written, after which
+	// new keys can't be written.
+	revised bool
+	lastKey []byte
+	buf     []byte
+}
+
+// newExportFileWriter starts an export file that is written to w. Keys must be
+// written with WriteKey and then WriteRevisedKey, each in order of their
+// ExposureKey, and the file is complete once Close returns.
+func newExportFileWriter(w io.Writer, eb *model.ExportBatch, fileNum int32, splitBatch bool, signers []*Signer) (*exportFileWriter, error) {
+	headerBytes, err := proto.Marshal(exportHeader(eb, fileNum, splitBatch, signers))
 	if err != nil {
-		return nil, fmt.Errorf("unable to marshal exposure keys: %w", err)
+		return nil, fmt.Errorf("unable to marshal export header: %w", err)
 	}
-	return append(exportBytes, protoBytes...), nil
+
+	zw := zip.NewWriter(w)
+	zf, err := zw.Create(exportBinaryName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create zip entry for export: %w", err)
+	}
+
+	digest := sha256.New()
+	fw := &exportFileWriter{
+		zw:      zw,
+		bin:     io.MultiWriter(zf, digest),
+		digest:  digest,
+		signers: signers,
+
+		defaultReportType: eb.DefaultReportType,
+	}
+	if _, err := fw.bin.Write(fixedHeader); err != nil {
+		return nil, fmt.Errorf("unable to write export to archive: %w", err)
+	}
+	if _, err := fw.bin.Write(headerBytes); err != nil {
+		return nil, fmt.Errorf("unable to write export to archive: %w", err)
+	}
+	return fw, nil
+}
+
+// WriteKey writes a new key to the export.
+func (w *exportFileWriter) WriteKey(exp *publishmodel.Exposure) error {
+	if w.revised {
+		return fmt.Errorf("keys must be written before revised keys")
+	}
+	return w.writeTEK(exportKeysFieldNum, exp.ExposureKey, makeExportTEK(exp, w.defaultReportType))
+}
+
+// WriteRevisedKey writes a revised key to the export.
+func (w *exportFileWriter) WriteRevisedKey(exp *publishmodel.Exposure) error {
+	if !w.revised {
+		w.revised = true
+		w.lastKey = nil
+	}
+	return w.writeTEK(exportRevisedKeysFieldNum, exp.ExposureKey, makeRevisedTEK(exp, w.defaultReportType))
+}
+
+func (w *exportFileWriter) writeTEK(num protowire.Number, key []byte, pbek *export.TemporaryExposureKey) error {
+	if w.lastKey != nil && bytes.Compare(w.lastKey, key) >= 0 {
+		return fmt.Errorf("keys must be written in order")
+	}
+	w.lastKey = key
+
+	// The key is encoded into the same buffer each time.
+	w.buf = protowire.AppendTag(w.buf[:0], num, protowire.BytesType)
+	w.buf = protowire.AppendVarint(w.buf, uint64(proto.Size(pbek)))
+	b, err := proto.MarshalOptions{}.MarshalAppend(w.buf, pbek)
+	if err != nil {
+		return fmt.Errorf("unable to marshal exposure key: %w", err)
+	}
+	w.buf = b
+	if _, err := w.bin.Write(w.buf); err != nil {
+		return fmt.Errorf("unable to write export to archive: %w", err)
+	}
+	return nil
+}
+
+// Close signs the export and writes the signature file.
+func (w *exportFileWriter) Close() error {
+	sigContents, err := marshalSignatureDigest(w.digest.Sum(nil), w.signers)
+	if err != nil {
+		return fmt.Errorf("unable to marshal signature file: %w", err)
+	}
+
+	zf, err := w.zw.Create(exportSignatureName)
+	if err != nil {
+		return fmt.Errorf("unable to create zip entry for signature: %w", err)
+	}
+	if _, err := zf.Write(sigContents); err != nil {
+		return fmt.Errorf("unable to write signature to archive: %w", err)
+	}
+	if err := w.zw.Close(); err != nil {
+		return fmt.Errorf("unable to close archive: %w", err)
+	}
+	return nil
 }
 
 func createSignatureInfo(si *model.SignatureInfo) *export.SignatureInfo {
@@ -289,9 +473,16 @@ func unmarshalSignatureContent(file *zip.File) (*export.TEKSignatureList, error)
 }
 
 func marshalSignature(exportContents []byte, signers []*Signer) ([]byte, error) {
+	digest := sha256.Sum256(exportContents)
+	return marshalSignatureDigest(digest[:], signers)
+}
+
+// marshalSignatureDigest creates the signature file for the SHA256 digest of
+// the export contents.
+func marshalSignatureDigest(digest []byte, signers []*Signer) ([]byte, error) {
 	signatures := make([]*export.TEKSignature, 0, len(signers))
 	for _, s := range signers {
-		sig, err := generateSignature(exportContents, s.Signer)
+		sig, err := generateSignature(digest, s.Signer)
 		if err != nil {
 			return nil, fmt.Errorf("unable to generate signature: %w", err)
 		}
@@ -313,9 +504,8 @@ func marshalSignature(exportContents []byte, signers []*Signer) ([]byte, error)
 	return protoBytes, nil
 }
 
-func generateSignature(data []byte, signer crypto.Signer) ([]byte, error) {
-	digest := sha256.Sum256(data)
-	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+func generateSignature(digest []byte, signer crypto.Signer) ([]byte, error) {
+	sig, err := signer.Sign(rand.Reader, digest, crypto.SHA256)
 	if err != nil {
 		return nil, fmt.Errorf("unable to sign: %w", err)
 	}
@@ -15,8 +15,16 @@
 package export
 
 import (
+	"archive/zip"
+	"bytes"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/binary"
+	"fmt"
 	"io"
 	"testing"
 	"time"
@@ -25,6 +33,7 @@ import (
 	"github.com/google/exposure-notifications-server/internal/pb/export"
 	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
 	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
+	"github.com/google/exposure-notifications-server/pkg/errcmp"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
@@ -188,6 +197,287 @@ func TestMarshalUnmarshalExportFile(t *testing.T) {
 	}
 }
 
+func TestResignExportFile(t *testing.T) {
+	t.Parallel()
+
+	batch := &model.ExportBatch{
+		StartTimestamp: time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC),
+		EndTimestamp:   time.Date(2020, 5, 1, 1, 0, 0, 0, time.UTC),
+		OutputRegion:   "US",
+	}
+	exposures := []*publishmodel.Exposure{
+		{
+			ExposureKey:      []byte("ABC"),
+			IntervalNumber:   18,
+			IntervalCount:    144,
+			TransmissionRisk: 8,
+			ReportType:       verifyapi.ReportTypeConfirmed,
+		},
+	}
+
+	oldSigner := newTestSigner(t, "compromised", "1")
+	newSigner := newTestSigner(t, "trusted", "2")
+
+	original, err := MarshalExportFile(batch, exposures, nil, 1, false, []*Signer{oldSigner})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resigned, err := ResignExportFile(original, []*Signer{newSigner})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The payload is unchanged.
+	if got, want := readTestZipFile(t, resigned, exportBinaryName), readTestZipFile(t, original, exportBinaryName); !bytes.Equal(got, want) {
+		t.Errorf("expected %v to be unchanged", exportBinaryName)
+	}
+
+	// The signature is from the new key only.
+	sigs, err := UnmarshalSignatureFile(resigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(sigs.Signatures), 1; got != want {
+		t.Fatalf("expected %d signatures, got %d", want, got)
+	}
+	sig := sigs.Signatures[0]
+	if got, want := sig.GetSignatureInfo().GetVerificationKeyId(), "trusted"; got != want {
+		t.Errorf("expected verification key id %q to be %q", got, want)
+	}
+	if got, want := sig.GetSignatureInfo().GetVerificationKeyVersion(), "2"; got != want {
+		t.Errorf("expected verification key version %q to be %q", got, want)
+	}
+
+	digest := sha256.Sum256(readTestZipFile(t, resigned, exportBinaryName))
+	if !ecdsa.VerifyASN1(newSigner.Signer.Public().(*ecdsa.PublicKey), digest[:], sig.Signature) {
+		t.Errorf("expected signature to verify under the new key")
+	}
+	if ecdsa.VerifyASN1(oldSigner.Signer.Public().(*ecdsa.PublicKey), digest[:], sig.Signature) {
+		t.Errorf("expected signature to not verify under the old key")
+	}
+}
+
+func TestExportFileWriter(t *testing.T) {
+	t.Parallel()
+
+	batch := &model.ExportBatch{
+		StartTimestamp: time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC),
+		EndTimestamp:   time.Date(2020, 5, 1, 1, 0, 0, 0, time.UTC),
+		OutputRegion:   "US",
+	}
+	signers := []*Signer{
+		{
+			SignatureInfo: &model.SignatureInfo{SigningKeyID: "310", SigningKeyVersion: "1"},
+			Signer:        &customTestSigner{sig: []byte("signature-1")},
+		},
+		{
+			SignatureInfo: &model.SignatureInfo{SigningKeyID: "311", SigningKeyVersion: "2"},
+			Signer:        &customTestSigner{sig: []byte("signature-2")},
+		},
+	}
+
+	cases := []struct {
+		name       string
+		keys       int
+		revised    int
+		fileNum    int32
+		splitBatch bool
+	}{
+		{
+			name: "keys",
+			keys: 100,
+		},
+		{
+			name:    "revised_keys",
+			revised: 100,
+		},
+		{
+			name:    "keys_and_revised_keys",
+			keys:    1000,
+			revised: 250,
+		},
+		{
+			name:       "split_batch",
+			keys:       10,
+			revised:    10,
+			fileNum:    3,
+			splitBatch: true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			exposures := testExportExposures(tc.keys, false)
+			revised := testExportExposures(tc.revised, true)
+
+			var buf bytes.Buffer
+			w, err := newExportFileWriter(&buf, batch, tc.fileNum, tc.splitBatch, signers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, exp := range exposures {
+				if err := w.WriteKey(exp); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, exp := range revised {
+				if err := w.WriteRevisedKey(exp); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			want, err := MarshalExportFile(batch, exposures, revised, tc.fileNum, tc.splitBatch, signers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), want) {
+				t.Errorf("expected streamed export file to match MarshalExportFile")
+			}
+		})
+	}
+}
+
+func TestExportFileWriter_Order(t *testing.T) {
+	t.Parallel()
+
+	batch := &model.ExportBatch{OutputRegion: "US"}
+	exposures := testExportExposures(2, false)
+
+	w, err := newExportFileWriter(io.Discard, batch, 1, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteKey(exposures[1]); err != nil {
+		t.Fatal(err)
+	}
+	errcmp.MustMatch(t, w.WriteKey(exposures[0]), "keys must be written in order")
+
+	// Revised keys are ordered separately, but must come last.
+	if err := w.WriteRevisedKey(exposures[0]); err != nil {
+		t.Fatal(err)
+	}
+	errcmp.MustMatch(t, w.WriteKey(exposures[1]), "keys must be written before revised keys")
+}
+
+// BenchmarkExportFile compares the memory used to write an export file with
+// MarshalExportFile, which needs all of the keys and their encoding at once,
+// and with an exportFileWriter, which is given one key at a time. The keys
+// given to the exportFileWriter are garbage once they are written, so apart
+// from the archive, the memory in use doesn't grow with the number of keys.
+func BenchmarkExportFile(b *testing.B) {
+	batch := &model.ExportBatch{
+		StartTimestamp: time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC),
+		EndTimestamp:   time.Date(2020, 5, 1, 1, 0, 0, 0, time.UTC),
+		OutputRegion:   "US",
+	}
+	signers := []*Signer{newTestSigner(b, "310", "1")}
+
+	for _, n := range []int{1000, 10000, 100000} {
+		n := n
+
+		b.Run(fmt.Sprintf("buffered_%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := MarshalExportFile(batch, testExportExposures(n, false), nil, 1, false, signers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("streamed_%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var buf bytes.Buffer
+				w, err := newExportFileWriter(&buf, batch, 1, false, signers)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for j := 0; j < n; j++ {
+					if err := w.WriteKey(testExportExposure(j, false)); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if err := w.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// testExportExposures returns n exposures, in key order.
+func testExportExposures(n int, revised bool) []*publishmodel.Exposure {
+	exposures := make([]*publishmodel.Exposure, 0, n)
+	for i := 0; i < n; i++ {
+		exposures = append(exposures, testExportExposure(i, revised))
+	}
+	return exposures
+}
+
+// testExportExposure returns the i-th exposure for an export, whose key is i.
+func testExportExposure(i int, revised bool) *publishmodel.Exposure {
+	key := make([]byte, verifyapi.KeyLength)
+	binary.BigEndian.PutUint64(key[verifyapi.KeyLength-8:], uint64(i))
+
+	exp := &publishmodel.Exposure{
+		ExposureKey:      key,
+		IntervalNumber:   int32(2650000 + 144*(i%14)),
+		IntervalCount:    144,
+		TransmissionRisk: i % 8,
+		ReportType:       verifyapi.ReportTypeConfirmed,
+	}
+	exp.SetDaysSinceSymptomOnset(int32(i%14) - 7)
+	if revised {
+		exp.RevisedReportType = proto.String(verifyapi.ReportTypeClinical)
+		exp.RevisedDaysSinceSymptomOnset = proto.Int32(int32(i % 5))
+	}
+	return exp
+}
+
+func newTestSigner(tb testing.TB, keyID, keyVersion string) *Signer {
+	tb.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return &Signer{
+		SignatureInfo: &model.SignatureInfo{
+			SigningKeyID:      keyID,
+			SigningKeyVersion: keyVersion,
+		},
+		Signer: key,
+	}
+}
+
+func readTestZipFile(tb testing.TB, data []byte, name string) []byte {
+	tb.Helper()
+
+	zp, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for _, file := range zp.File {
+		if file.Name == name {
+			b, err := readZipFile(file)
+			if err != nil {
+				tb.Fatal(err)
+			}
+			return b
+		}
+	}
+	tb.Fatalf("no %v in archive", name)
+	return nil
+}
+
 type customTestSigner struct {
 	sig []byte
 	pub crypto.PublicKey
@@ -197,3 +487,120 @@ func (s *customTestSigner) Public() crypto.PublicKey { return s.pub }
 func (s *customTestSigner) Sign(io.Reader, []byte, crypto.SignerOpts) ([]byte, error) {
 	return s.sig, nil
 }
+
+func TestExportFile_DefaultReportType(t *testing.T) {
+	t.Parallel()
+
+	newKey := func(b byte, reportType string) *publishmodel.Exposure {
+		return &publishmodel.Exposure{
+			ExposureKey:      bytes.Repeat([]byte{b}, 16),
+			TransmissionRisk: 2,
+			IntervalNumber:   100,
+			IntervalCount:    144,
+			ReportType:       reportType,
+		}
+	}
+	newRevisedKey := func(b byte, reportType *string) *publishmodel.Exposure {
+		exp := newKey(b, verifyapi.ReportTypeClinical)
+		exp.RevisedReportType = reportType
+		return exp
+	}
+
+	cases := []struct {
+		name              string
+		defaultReportType string
+		want              []export.TemporaryExposureKey_ReportType
+		wantRevised       []export.TemporaryExposureKey_ReportType
+	}{
+		{
+			name: "no_default",
+			// Zero is the value of an unset report type.
+			want:        []export.TemporaryExposureKey_ReportType{export.TemporaryExposureKey_CONFIRMED_TEST, 0, 0},
+			wantRevised: []export.TemporaryExposureKey_ReportType{export.TemporaryExposureKey_CONFIRMED_TEST, 0},
+		},
+		{
+			name:              "default",
+			defaultReportType: verifyapi.ReportTypeClinical,
+			want: []export.TemporaryExposureKey_ReportType{
+				export.TemporaryExposureKey_CONFIRMED_TEST,
+				export.TemporaryExposureKey_CONFIRMED_CLINICAL_DIAGNOSIS,
+				export.TemporaryExposureKey_CONFIRMED_CLINICAL_DIAGNOSIS,
+			},
+			wantRevised: []export.TemporaryExposureKey_ReportType{
+				export.TemporaryExposureKey_CONFIRMED_TEST,
+				export.TemporaryExposureKey_CONFIRMED_CLINICAL_DIAGNOSIS,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			batch := &model.ExportBatch{
+				StartTimestamp:    time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC),
+				EndTimestamp:      time.Date(2020, 5, 1, 1, 0, 0, 0, time.UTC),
+				OutputRegion:      "US",
+				DefaultReportType: tc.defaultReportType,
+			}
+			// A stored report type, none, and one the export doesn't know.
+			exposures := []*publishmodel.Exposure{
+				newKey(1, verifyapi.ReportTypeConfirmed),
+				newKey(2, ""),
+				newKey(3, "banana"),
+			}
+			revised := []*publishmodel.Exposure{
+				newRevisedKey(4, proto.String(verifyapi.ReportTypeConfirmed)),
+				newRevisedKey(5, nil),
+			}
+
+			data, err := MarshalExportFile(batch, exposures, revised, 1, false, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The streamed file must be the same.
+			var buf bytes.Buffer
+			w, err := newExportFileWriter(&buf, batch, 1, false, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, exp := range exposures {
+				if err := w.WriteKey(exp); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, exp := range revised {
+				if err := w.WriteRevisedKey(exp); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), data) {
+				t.Errorf("expected streamed export file to match MarshalExportFile")
+			}
+
+			got, _, err := UnmarshalExportFile(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reportTypes := func(keys []*export.TemporaryExposureKey) []export.TemporaryExposureKey_ReportType {
+				types := make([]export.TemporaryExposureKey_ReportType, 0, len(keys))
+				for _, k := range keys {
+					types = append(types, k.GetReportType())
+				}
+				return types
+			}
+			if diff := cmp.Diff(tc.want, reportTypes(got.Keys)); diff != "" {
+				t.Errorf("keys mismatch (-want, +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.wantRevised, reportTypes(got.RevisedKeys)); diff != "" {
+				t.Errorf("revised keys mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
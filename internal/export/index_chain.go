@@ -0,0 +1,217 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/exposure-notifications-server/internal/storage"
+	"github.com/google/exposure-notifications-server/pkg/logging"
+)
+
+// indexPageSuffix is the suffix of index files. Export files end in
+// filenameSuffix, so an index entry with this suffix points to the next older
+// index page instead.
+const indexPageSuffix = ".txt"
+
+// indexPageFilename returns the name of the nth page of the index, for example
+// root/index-1.txt. Page 0 is the index itself.
+func indexPageFilename(indexObjectName string, n int) string {
+	if n == 0 {
+		return indexObjectName
+	}
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(indexObjectName, indexPageSuffix), n, indexPageSuffix)
+}
+
+// indexPageNumber returns the number of an index page name, the inverse of
+// indexPageFilename. It returns 0 if the name isn't a page of the index.
+func indexPageNumber(indexObjectName, name string) int {
+	prefix := strings.TrimSuffix(indexObjectName, indexPageSuffix) + "-"
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, indexPageSuffix) {
+		return 0
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, prefix), indexPageSuffix))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// indexPage is a page of an index, with the objects it lists and the name of
+// the next older page, if any.
+type indexPage struct {
+	name    string
+	objects []string
+	next    string
+}
+
+// entries returns the lines of the page.
+func (p *indexPage) entries() []string {
+	if p.next == "" {
+		return p.objects
+	}
+	return append(append(make([]string, 0, len(p.objects)+1), p.objects...), p.next)
+}
+
+// parseIndexPage splits the lines of an index page into objects and the name
+// of the next older page.
+func parseIndexPage(name string, entries []string) *indexPage {
+	p := &indexPage{name: name}
+	for _, e := range entries {
+		if strings.HasSuffix(e, indexPageSuffix) {
+			p.next = e
+			continue
+		}
+		p.objects = append(p.objects, e)
+	}
+	return p
+}
+
+// readIndexPages reads the index and the chain of older pages it points to,
+// newest first. The chain ends at a page that doesn't exist, since the oldest
+// pages are deleted once all of their objects have expired. It also returns
+// the highest page number that was ever linked, so new pages get new names.
+func (s *Server) readIndexPages(ctx context.Context, bucketName, indexObjectName string) ([]*indexPage, int, error) {
+	var pages []*indexPage
+	maxPage := 0
+	seen := make(map[string]struct{})
+	for name := indexObjectName; name != ""; {
+		if _, ok := seen[name]; ok {
+			return nil, 0, fmt.Errorf("reading index %s in bucket %s: page %s is listed twice", indexObjectName, bucketName, name)
+		}
+		seen[name] = struct{}{}
+		if n := indexPageNumber(indexObjectName, name); n > maxPage {
+			maxPage = n
+		}
+
+		entries, err := s.readIndex(ctx, bucketName, name)
+		if err != nil {
+			return nil, 0, err
+		}
+		if entries == nil {
+			break
+		}
+		page := parseIndexPage(name, entries)
+		pages = append(pages, page)
+		name = page.next
+	}
+	return pages, maxPage, nil
+}
+
+// paginateIndex returns the pages to write for the sorted objects, given the
+// current pages of the index, newest first, the first of which is the index
+// itself. Objects that are already on an older page stay there, and objects
+// that are no longer indexed are removed from their page. New objects are
+// added to the index, and once it has more than maxEntries objects, the oldest
+// maxEntries of them are moved to a new page, numbered after maxPage. So older
+// pages only change when their objects expire, which removes them from the
+// oldest page first.
+//
+// It returns the pages that have to be written, oldest first with the index
+// last, and the names of the older pages that are empty and can be deleted. A
+// deleted page may still be listed by the page after it, readers stop at a
+// page that doesn't exist.
+func paginateIndex(indexObjectName string, objects []string, current []*indexPage, maxPage, maxEntries int) ([]*indexPage, []string) {
+	indexed := make(map[string]struct{}, len(objects))
+	for _, o := range objects {
+		indexed[o] = struct{}{}
+	}
+
+	// The older pages, oldest first, minus the objects that are no longer
+	// indexed. Empty pages at the end of the chain are deleted.
+	var older []*indexPage
+	if len(current) > 1 {
+		older = current[1:]
+	}
+	paged := make(map[string]struct{}, len(objects))
+	var write []*indexPage
+	var deleted []string
+	newest := ""
+	for i := len(older) - 1; i >= 0; i-- {
+		p := older[i]
+		kept := make([]string, 0, len(p.objects))
+		for _, o := range p.objects {
+			if _, ok := indexed[o]; ok {
+				kept = append(kept, o)
+				paged[o] = struct{}{}
+			}
+		}
+		if len(kept) == 0 && newest == "" {
+			deleted = append(deleted, p.name)
+			continue
+		}
+		if len(kept) != len(p.objects) {
+			write = append(write, &indexPage{name: p.name, objects: kept, next: p.next})
+		}
+		newest = p.name
+	}
+
+	head := make([]string, 0, len(objects)-len(paged))
+	for _, o := range objects {
+		if _, ok := paged[o]; !ok {
+			head = append(head, o)
+		}
+	}
+	for len(head) > maxEntries {
+		maxPage++
+		p := &indexPage{
+			name:    indexPageFilename(indexObjectName, maxPage),
+			objects: head[:maxEntries],
+			next:    newest,
+		}
+		write = append(write, p)
+		newest = p.name
+		head = head[maxEntries:]
+	}
+	write = append(write, &indexPage{name: indexObjectName, objects: head, next: newest})
+	return write, deleted
+}
+
+// writeExportIndex writes the index of an export config, split into a chain of
+// pages of at most IndexMaxEntries objects, see paginateIndex. The older pages
+// are written first, so the pages a client can reach from the index always
+// exist. The signature sidecar index, if enabled, lists the sidecars of all
+// objects.
+func (s *Server) writeExportIndex(ctx context.Context, bucketName, indexObjectName string, objects []string, attrs *storage.ObjectAttrs) error {
+	if s.config.IndexMaxEntries <= 0 {
+		return s.writeIndex(ctx, bucketName, indexObjectName, objects, attrs)
+	}
+
+	current, maxPage, err := s.readIndexPages(ctx, bucketName, indexObjectName)
+	if err != nil {
+		return err
+	}
+	pages, deleted := paginateIndex(indexObjectName, objects, current, maxPage, s.config.IndexMaxEntries)
+	for _, p := range pages {
+		if err := s.writeIndexFile(ctx, bucketName, p.name, p.entries(), attrs); err != nil {
+			return err
+		}
+	}
+	if err := s.writeSidecarIndex(ctx, bucketName, indexObjectName, objects, attrs); err != nil {
+		return err
+	}
+
+	// Failures are logged, the pages no longer list any objects.
+	logger := logging.FromContext(ctx)
+	for _, name := range deleted {
+		if err := s.deleteObject(ctx, bucketName, name); err != nil {
+			logger.Warnw("failed to delete expired index page", "object", name, "error", err)
+		}
+	}
+	return nil
+}
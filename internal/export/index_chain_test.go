@@ -0,0 +1,278 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/exposure-notifications-server/internal/storage"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestIndexPageFilename(t *testing.T) {
+	t.Parallel()
+
+	if got, want := indexPageFilename("root/index.txt", 0), "root/index.txt"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+	if got, want := indexPageFilename("root/index.txt", 2), "root/index-2.txt"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+}
+
+func TestIndexPageNumber(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		want int
+	}{
+		{name: "root/index.txt", want: 0},
+		{name: "root/index-2.txt", want: 2},
+		{name: "root/index-12.txt", want: 12},
+		{name: "other/index-2.txt", want: 0},
+		{name: "root/index-x.txt", want: 0},
+		{name: "root/index-2.zip", want: 0},
+	}
+
+	for _, tc := range cases {
+		if got := indexPageNumber("root/index.txt", tc.name); got != tc.want {
+			t.Errorf("page number of %q: expected %d to be %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPaginateIndex(t *testing.T) {
+	t.Parallel()
+
+	objects := func(from, to int) []string {
+		var names []string
+		for i := from; i <= to; i++ {
+			names = append(names, fmt.Sprintf("root/%d.zip", i))
+		}
+		return names
+	}
+
+	cases := []struct {
+		name        string
+		objects     []string
+		current     []*indexPage
+		maxPage     int
+		wantPages   []*indexPage
+		wantDeleted []string
+	}{
+		{
+			name:    "under_cap",
+			objects: objects(1, 3),
+			wantPages: []*indexPage{
+				{name: "root/index.txt", objects: objects(1, 3)},
+			},
+		},
+		{
+			name:    "chain",
+			objects: objects(1, 8),
+			wantPages: []*indexPage{
+				{name: "root/index-1.txt", objects: objects(1, 3)},
+				{name: "root/index-2.txt", objects: objects(4, 6), next: "root/index-1.txt"},
+				{name: "root/index.txt", objects: objects(7, 8), next: "root/index-2.txt"},
+			},
+		},
+		{
+			name:    "append",
+			objects: objects(1, 9),
+			current: []*indexPage{
+				{name: "root/index.txt", objects: objects(4, 6), next: "root/index-1.txt"},
+				{name: "root/index-1.txt", objects: objects(1, 3)},
+			},
+			maxPage: 1,
+			wantPages: []*indexPage{
+				{name: "root/index-2.txt", objects: objects(4, 6), next: "root/index-1.txt"},
+				{name: "root/index.txt", objects: objects(7, 9), next: "root/index-2.txt"},
+			},
+		},
+		{
+			name:    "unchanged",
+			objects: objects(1, 5),
+			current: []*indexPage{
+				{name: "root/index.txt", objects: objects(4, 5), next: "root/index-1.txt"},
+				{name: "root/index-1.txt", objects: objects(1, 3)},
+			},
+			maxPage: 1,
+			wantPages: []*indexPage{
+				{name: "root/index.txt", objects: objects(4, 5), next: "root/index-1.txt"},
+			},
+		},
+		{
+			name:    "expired",
+			objects: objects(3, 8),
+			current: []*indexPage{
+				{name: "root/index.txt", objects: objects(7, 8), next: "root/index-2.txt"},
+				{name: "root/index-2.txt", objects: objects(4, 6), next: "root/index-1.txt"},
+				{name: "root/index-1.txt", objects: objects(1, 3)},
+			},
+			maxPage: 2,
+			wantPages: []*indexPage{
+				{name: "root/index-1.txt", objects: objects(3, 3)},
+				{name: "root/index.txt", objects: objects(7, 8), next: "root/index-2.txt"},
+			},
+		},
+		{
+			name:    "deleted",
+			objects: objects(5, 10),
+			current: []*indexPage{
+				{name: "root/index.txt", objects: objects(7, 8), next: "root/index-2.txt"},
+				{name: "root/index-2.txt", objects: objects(4, 6), next: "root/index-1.txt"},
+				{name: "root/index-1.txt", objects: objects(1, 3)},
+			},
+			maxPage: 2,
+			wantPages: []*indexPage{
+				{name: "root/index-2.txt", objects: objects(5, 6), next: "root/index-1.txt"},
+				{name: "root/index-3.txt", objects: objects(7, 9), next: "root/index-2.txt"},
+				{name: "root/index.txt", objects: objects(10, 10), next: "root/index-3.txt"},
+			},
+			wantDeleted: []string{"root/index-1.txt"},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			pages, deleted := paginateIndex("root/index.txt", tc.objects, tc.current, tc.maxPage, 3)
+			if diff := cmp.Diff(tc.wantPages, pages, cmp.AllowUnexported(indexPage{}), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("pages mismatch (-want, +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.wantDeleted, deleted); diff != "" {
+				t.Errorf("deleted mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWriteExportIndex(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	blobstore, err := storage.NewMemory(ctx, &storage.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		config: &Config{IndexMaxEntries: 3},
+		env:    serverenv.New(ctx, serverenv.WithBlobStorage(blobstore)),
+	}
+	attrs := storage.DefaultObjectAttrs(false, storage.ContentTypeTextPlain)
+
+	objects := make([]string, 0, 12)
+	for i := 1; i <= 12; i++ {
+		objects = append(objects, fmt.Sprintf("root/%d.zip", i))
+	}
+
+	// readChain follows the index from the primary index to the oldest page
+	// that exists, like a client paging backward.
+	readChain := func(t *testing.T) ([][]string, []string) {
+		t.Helper()
+
+		var pages [][]string
+		var all []string
+		for name := "root/index.txt"; name != ""; {
+			entries, err := s.readIndex(ctx, "bucket", name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entries == nil {
+				break
+			}
+
+			page := parseIndexPage(name, entries)
+			pages = append(pages, page.objects)
+			all = append(page.objects, all...)
+			name = page.next
+		}
+		return pages, all
+	}
+
+	if err := s.writeExportIndex(ctx, "bucket", "root/index.txt", objects[:8], attrs); err != nil {
+		t.Fatal(err)
+	}
+	pages, all := readChain(t)
+	wantPages := [][]string{
+		{"root/7.zip", "root/8.zip"},
+		{"root/4.zip", "root/5.zip", "root/6.zip"},
+		{"root/1.zip", "root/2.zip", "root/3.zip"},
+	}
+	if diff := cmp.Diff(wantPages, pages); diff != "" {
+		t.Errorf("pages mismatch (-want, +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(objects[:8], all); diff != "" {
+		t.Errorf("chain mismatch (-want, +got):\n%s", diff)
+	}
+	page1, err := blobstore.GetObject(ctx, "bucket", "root/index-1.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// New files are appended, the older pages don't change.
+	if err := s.writeExportIndex(ctx, "bucket", "root/index.txt", objects[:10], attrs); err != nil {
+		t.Fatal(err)
+	}
+	pages, all = readChain(t)
+	wantPages = [][]string{
+		{"root/10.zip"},
+		{"root/7.zip", "root/8.zip", "root/9.zip"},
+		{"root/4.zip", "root/5.zip", "root/6.zip"},
+		{"root/1.zip", "root/2.zip", "root/3.zip"},
+	}
+	if diff := cmp.Diff(wantPages, pages); diff != "" {
+		t.Errorf("pages mismatch (-want, +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(objects[:10], all); diff != "" {
+		t.Errorf("chain mismatch (-want, +got):\n%s", diff)
+	}
+	if got, err := blobstore.GetObject(ctx, "bucket", "root/index-1.txt"); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(got, page1) {
+		t.Errorf("expected root/index-1.txt to be unchanged, got %q", got)
+	}
+
+	// Once all files of the oldest page expire, it is deleted.
+	if err := s.writeExportIndex(ctx, "bucket", "root/index.txt", objects[4:12], attrs); err != nil {
+		t.Fatal(err)
+	}
+	pages, all = readChain(t)
+	wantPages = [][]string{
+		{"root/10.zip", "root/11.zip", "root/12.zip"},
+		{"root/7.zip", "root/8.zip", "root/9.zip"},
+		{"root/5.zip", "root/6.zip"},
+	}
+	if diff := cmp.Diff(wantPages, pages); diff != "" {
+		t.Errorf("pages mismatch (-want, +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(objects[4:12], all); diff != "" {
+		t.Errorf("chain mismatch (-want, +got):\n%s", diff)
+	}
+	if _, err := blobstore.GetObject(ctx, "bucket", "root/index-1.txt"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected expired page to be deleted, got %v", err)
+	}
+}
@@ -29,6 +29,7 @@ var (
 	ExportConfigIDTagKey  = tag.MustNewKey("config_id")
 	ExportRegionTagKey    = tag.MustNewKey("region")
 	ExportTravelersTagKey = tag.MustNewKey("includes_travelers")
+	ExportReasonTagKey    = tag.MustNewKey("reason")
 )
 
 var (
@@ -39,6 +40,12 @@ var (
 	mBatcherCreated        = stats.Int64(metricPrefix+"/batches_created", "Number of export batchers created", stats.UnitDimensionless)
 	mWorkerBadKeyLength    = stats.Int64(metricPrefix+"/worker_bad_key_length", "Number of dropped keys caused by bad key length", stats.UnitDimensionless)
 	mExportBatchCompletion = stats.Int64(metricPrefix+"/batch_completion", "Number of batches complete by output region", stats.UnitDimensionless)
+	mIndexDiscrepancies    = stats.Int64(metricPrefix+"/index_discrepancies", "Number of index entries that don't match storage and the database", stats.UnitDimensionless)
+	mSignRetries           = stats.Int64(metricPrefix+"/sign_retries", "Number of key manager sign calls retried after being throttled", stats.UnitDimensionless)
+	mExportFileBytes       = stats.Int64(metricPrefix+"/file_size", "Size of written export files", stats.UnitBytes)
+	mExportFileKeys        = stats.Int64(metricPrefix+"/file_keys", "Number of keys in written export files", stats.UnitDimensionless)
+	mExportBatchDurationMs = stats.Float64(metricPrefix+"/batch_duration", "Time to generate a completed export batch", stats.UnitMilliseconds)
+	mWebhookFailures       = stats.Int64(metricPrefix+"/webhook_failures", "Number of export webhooks that could not be delivered", stats.UnitDimensionless)
 )
 
 func init() {
@@ -80,5 +87,45 @@ func init() {
 			Aggregation: view.Sum(),
 			TagKeys:     []tag.Key{ExportConfigIDTagKey, ExportRegionTagKey, ExportTravelersTagKey},
 		},
+		{
+			Name:        metricPrefix + "/index_discrepancies_count",
+			Description: "Total count of index entries that don't match storage and the database",
+			Measure:     mIndexDiscrepancies,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{ExportConfigIDTagKey, ExportReasonTagKey},
+		},
+		{
+			Name:        metricPrefix + "/sign_retries_count",
+			Description: "Total count of key manager sign calls retried after being throttled",
+			Measure:     mSignRetries,
+			Aggregation: view.Sum(),
+		},
+		{
+			Name:        metricPrefix + "/file_size_bytes",
+			Description: "Distribution of the size of written export files",
+			Measure:     mExportFileBytes,
+			Aggregation: view.Distribution(1<<10, 10<<10, 50<<10, 100<<10, 250<<10, 500<<10, 1<<20, 2<<20, 5<<20, 10<<20),
+			TagKeys:     []tag.Key{ExportConfigIDTagKey, ExportRegionTagKey},
+		},
+		{
+			Name:        metricPrefix + "/file_keys",
+			Description: "Distribution of the number of keys in written export files",
+			Measure:     mExportFileKeys,
+			Aggregation: view.Distribution(10, 50, 100, 500, 1000, 2500, 5000, 10000, 17500, 25000),
+			TagKeys:     []tag.Key{ExportConfigIDTagKey, ExportRegionTagKey},
+		},
+		{
+			Name:        metricPrefix + "/batch_duration",
+			Description: "Distribution of the time to generate a completed export batch",
+			Measure:     mExportBatchDurationMs,
+			Aggregation: view.Distribution(100, 500, 1000, 5000, 10000, 30000, 60000, 120000, 300000, 600000, 1200000),
+			TagKeys:     []tag.Key{ExportConfigIDTagKey, ExportRegionTagKey},
+		},
+		{
+			Name:        metricPrefix + "/webhook_failures_count",
+			Description: "Total count of export webhooks that could not be delivered",
+			Measure:     mWebhookFailures,
+			Aggregation: view.Sum(),
+		},
 	}...)
 }
@@ -0,0 +1,176 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	"github.com/google/exposure-notifications-server/internal/project"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/exposure-notifications-server/internal/storage"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1alpha1"
+	"github.com/google/exposure-notifications-server/pkg/observability"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+// fakeExporter keeps the latest exported rows for each view.
+type fakeExporter struct {
+	mu   sync.Mutex
+	rows map[string][]*view.Row
+}
+
+func (e *fakeExporter) ExportView(d *view.Data) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rows[d.View.Name] = d.Rows
+}
+
+// distribution returns the exported distribution for the view with exactly the
+// given tags, or nil if there is none.
+func (e *fakeExporter) distribution(name string, tags map[tag.Key]string) *view.DistributionData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, row := range e.rows[name] {
+		if len(row.Tags) != len(tags) {
+			continue
+		}
+		match := true
+		for _, t := range row.Tags {
+			if tags[t.Key] != t.Value {
+				match = false
+			}
+		}
+		if d, ok := row.Data.(*view.DistributionData); ok && match {
+			return d
+		}
+	}
+	return nil
+}
+
+func TestExportFileMetrics(t *testing.T) {
+	// Not parallel, the exporter and reporting period are global.
+
+	ctx := project.TestContext(t)
+
+	fileBytesView := metricPrefix + "/file_size_bytes"
+	fileKeysView := metricPrefix + "/file_keys"
+	batchDurationView := metricPrefix + "/batch_duration"
+	for _, v := range observability.AllViews() {
+		switch v.Name {
+		case fileBytesView, fileKeysView, batchDurationView:
+			v := v
+			if err := view.Register(v); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { view.Unregister(v) })
+		}
+	}
+
+	exporter := &fakeExporter{rows: make(map[string][]*view.Row)}
+	view.RegisterExporter(exporter)
+	t.Cleanup(func() { view.UnregisterExporter(exporter) })
+	view.SetReportingPeriod(10 * time.Millisecond)
+	t.Cleanup(func() { view.SetReportingPeriod(time.Minute) })
+
+	blobstore, err := storage.NewMemory(ctx, &storage.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		config: &Config{},
+		env:    serverenv.New(ctx, serverenv.WithBlobStorage(blobstore)),
+	}
+
+	// Generate a batch of keys, based on a single key.
+	now := time.Now().UTC()
+	exposures := []*publishmodel.Exposure{
+		{
+			ExposureKey:      randomTEK(t),
+			TransmissionRisk: verifyapi.TransmissionRiskConfirmedStandard,
+			IntervalNumber:   publishmodel.IntervalNumber(now.Add(-24 * time.Hour)),
+			IntervalCount:    144,
+			ReportType:       verifyapi.ReportTypeConfirmed,
+		},
+	}
+	exposures, _, err = ensureMinNumExposures(exposures, "US", 100, 1, 1000, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eb := &model.ExportBatch{
+		BatchID:        1,
+		ConfigID:       4242,
+		BucketName:     "metrics-bucket",
+		FilenameRoot:   "metrics-root",
+		OutputRegion:   "MX",
+		StartTimestamp: now.Add(-time.Hour),
+		EndTimestamp:   now,
+	}
+
+	objectName, _, err := s.createFile(ctx, &createFileInfo{
+		exposures:   exposures,
+		exportBatch: eb,
+		attrs:       storage.DefaultObjectAttrs(true, storage.ContentTypeZip),
+		fileNum:     1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := blobstore.GetObject(ctx, eb.BucketName, objectName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordBatchDuration(ctx, eb, 1500*time.Millisecond)
+
+	tags := map[tag.Key]string{
+		ExportConfigIDTagKey: "4242",
+		ExportRegionTagKey:   "MX",
+	}
+
+	// waitFor waits for a single measurement to be exported for the view.
+	waitFor := func(t *testing.T, name string) *view.DistributionData {
+		t.Helper()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if d := exporter.distribution(name, tags); d != nil && d.Count > 0 {
+				if d.Count != 1 {
+					t.Fatalf("expected a single %s measurement, got %d", name, d.Count)
+				}
+				return d
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected a %s measurement with tags %v", name, tags)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	if got, want := waitFor(t, fileBytesView).Mean, float64(len(data)); got != want {
+		t.Errorf("expected file size %v to be %v", got, want)
+	}
+	if got, want := waitFor(t, fileKeysView).Mean, float64(len(exposures)); got != want {
+		t.Errorf("expected file keys %v to be %v", got, want)
+	}
+	if got, want := waitFor(t, batchDurationView).Mean, 1500.0; got != want {
+		t.Errorf("expected batch duration %vms to be %vms", got, want)
+	}
+}
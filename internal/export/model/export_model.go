@@ -17,8 +17,12 @@ package model
 
 import (
 	"errors"
+	"fmt"
+	"sort"
 	"strings"
 	"time"
+
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
 )
 
 var (
@@ -49,6 +53,62 @@ type ExportConfig struct {
 	Thru               time.Time
 	SignatureInfoIDs   []int64
 	MaxRecordsOverride *int
+
+	// RegionSignatureInfos maps a region to the ID of the signature info that
+	// its export files are signed with instead of SignatureInfoIDs, for regions
+	// whose files must be signed with a region specific key. It applies to the
+	// batches created, and the files re-signed, after it changes.
+	RegionSignatureInfos map[string]int64
+
+	// OnsetWindowMinDays and OnsetWindowMaxDays restrict the export to keys
+	// whose symptom onset is within that many days before the end of the
+	// batch, inclusive. A nil bound is unbounded. ExcludeMissingOnset excludes
+	// keys without symptom onset information.
+	OnsetWindowMinDays  *int
+	OnsetWindowMaxDays  *int
+	ExcludeMissingOnset bool
+
+	// OnlyRevisedKeys exports only the revised keys, as a separate stream of
+	// revisions with its own FilenameRoot. ExcludeRevisedKeys exports only the
+	// new keys, for use alongside such a stream.
+	OnlyRevisedKeys    bool
+	ExcludeRevisedKeys bool
+
+	// IncludeCBOR also writes each export file as CBOR, next to the protobuf
+	// zip file, and lists the CBOR files in a separate index.
+	IncludeCBOR bool
+
+	// MinTransmissionRisk, if set, exports only keys with at least this
+	// transmission risk. Like for OnlyRevisedKeys, such a config is a separate
+	// stream with its own FilenameRoot, so the main export is unaffected. Keys
+	// with an unknown (0) transmission risk are excluded, unless
+	// IncludeUnknownTransmissionRisk is set.
+	MinTransmissionRisk            int
+	IncludeUnknownTransmissionRisk bool
+
+	// DefaultReportType, if set, is the report type of exported keys that
+	// don't have one. Otherwise the field is left unset for those keys.
+	DefaultReportType string
+
+	// ReportTypes, if set, exports only keys with one of these report types,
+	// as a separate stream with its own FilenameRoot. Keys without a report
+	// type are excluded, unless IncludeUnknownReportType is set or
+	// DefaultReportType is one of the ReportTypes, since they are then
+	// exported as such.
+	ReportTypes              []string
+	IncludeUnknownReportType bool
+
+	// Schedule is an optional cron schedule, see ParseSchedule. If set,
+	// batches end at each time matching the schedule, instead of every Period
+	// aligned to UTC midnight.
+	Schedule string
+
+	// CacheControl and IndexCacheControl override the default cache-control
+	// headers of the export files and the index file. ContentDisposition is
+	// optionally set as the content-disposition header of the export files.
+	CacheControl       string
+	IndexCacheControl  string
+	ContentDisposition string
 }
 
 // EffectiveInputRegions either returns `InputRegions` or if that array is
@@ -65,6 +125,39 @@ func (ec *ExportConfig) ExcludeRegionsOnePerLine() string {
 	return strings.Join(ec.ExcludeRegions, "\n")
 }
 
+// RegionSignatureInfosOnePerLine returns the region signature infos as
+// "REGION:ID" lines, sorted by region.
+func (ec *ExportConfig) RegionSignatureInfosOnePerLine() string {
+	lines := make([]string, 0, len(ec.RegionSignatureInfos))
+	for region, id := range ec.RegionSignatureInfos {
+		lines = append(lines, fmt.Sprintf("%s:%d", region, id))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// OutputSignatureInfoIDs returns the IDs of the signature infos that the
+// export files of the output region are signed with. That is the region's
+// signature info in RegionSignatureInfos, matched exactly, or SignatureInfoIDs
+// if it has none.
+func (ec *ExportConfig) OutputSignatureInfoIDs() []int64 {
+	if id, ok := ec.RegionSignatureInfos[ec.OutputRegion]; ok {
+		return []int64{id}
+	}
+	return ec.SignatureInfoIDs
+}
+
+// HasReportType returns true if keys of the report type are exported by a
+// config restricted to ReportTypes.
+func (ec *ExportConfig) HasReportType(reportType string) bool {
+	for _, rt := range ec.ReportTypes {
+		if rt == reportType {
+			return true
+		}
+	}
+	return false
+}
+
 func (ec *ExportConfig) Validate() error {
 	if ec.Period > oneDay {
 		return errors.New("maximum period is 24h")
@@ -75,9 +168,69 @@ func (ec *ExportConfig) Validate() error {
 	if int64(oneDay.Seconds())%int64(ec.Period.Seconds()) != 0 {
 		return errors.New("period must divide equally into 24 hours (e.g., 2h, 4h, 12h, 15m, 30m)")
 	}
+	if ec.OnsetWindowMinDays != nil && ec.OnsetWindowMaxDays != nil && *ec.OnsetWindowMinDays > *ec.OnsetWindowMaxDays {
+		return errors.New("onset window minimum days must be less than or equal to maximum days")
+	}
+	if ec.OnlyRevisedKeys && ec.ExcludeRevisedKeys {
+		return errors.New("cannot both only include and exclude revised keys")
+	}
+	if ec.MinTransmissionRisk < 0 || ec.MinTransmissionRisk > verifyapi.MaxTransmissionRisk {
+		return fmt.Errorf("minimum transmission risk must be between 0 and %d", verifyapi.MaxTransmissionRisk)
+	}
+	if ec.IncludeUnknownTransmissionRisk && ec.MinTransmissionRisk == 0 {
+		return errors.New("including unknown transmission risks requires a minimum transmission risk")
+	}
+	if ec.DefaultReportType != "" && !validExportReportType(ec.DefaultReportType) {
+		return fmt.Errorf("default report type must be one of %q, %q or %q",
+			verifyapi.ReportTypeConfirmed, verifyapi.ReportTypeClinical, verifyapi.ReportTypeSelfReport)
+	}
+	for _, reportType := range ec.ReportTypes {
+		if !validExportReportType(reportType) {
+			return fmt.Errorf("report types must be %q, %q or %q, got %q",
+				verifyapi.ReportTypeConfirmed, verifyapi.ReportTypeClinical, verifyapi.ReportTypeSelfReport, reportType)
+		}
+	}
+	if ec.IncludeUnknownReportType && len(ec.ReportTypes) == 0 {
+		return errors.New("including unknown report types requires report types")
+	}
+	for region, id := range ec.RegionSignatureInfos {
+		if region == "" {
+			return errors.New("region signature infos cannot have an empty region")
+		}
+		if id <= 0 {
+			return fmt.Errorf("signature info for region %q must be a positive ID, got: %d", region, id)
+		}
+	}
+	if ec.Schedule != "" {
+		if _, err := ParseSchedule(ec.Schedule); err != nil {
+			return err
+		}
+	}
+	headers := []struct {
+		name, value string
+	}{
+		{"cache-control", ec.CacheControl},
+		{"index cache-control", ec.IndexCacheControl},
+		{"content-disposition", ec.ContentDisposition},
+	}
+	for _, h := range headers {
+		if strings.ContainsAny(h.value, "\r\n") {
+			return fmt.Errorf("%s must not contain line breaks", h.name)
+		}
+	}
 	return nil
 }
 
+// validExportReportType returns true if keys of the report type can be
+// exported.
+func validExportReportType(reportType string) bool {
+	switch reportType {
+	case verifyapi.ReportTypeConfirmed, verifyapi.ReportTypeClinical, verifyapi.ReportTypeSelfReport:
+		return true
+	}
+	return false
+}
+
 // ExportBatch holds what was used to generate an export.
 type ExportBatch struct {
 	BatchID            int64
@@ -95,6 +248,28 @@ type ExportBatch struct {
 	LeaseExpires       time.Time
 	SignatureInfoIDs   []int64
 	MaxRecordsOverride *int
+
+	OnsetWindowMinDays  *int
+	OnsetWindowMaxDays  *int
+	ExcludeMissingOnset bool
+
+	OnlyRevisedKeys    bool
+	ExcludeRevisedKeys bool
+
+	IncludeCBOR bool
+
+	MinTransmissionRisk            int
+	IncludeUnknownTransmissionRisk bool
+
+	DefaultReportType string
+
+	ReportTypes              []string
+	IncludeUnknownReportType bool
+
+	// ContentHash is the hash of the content of the batch's export files,
+	// without the batch window and padding. It's set when the batch is
+	// completed, and empty for batches that were completed without files.
+	ContentHash string
 }
 
 // EffectiveMaxRecords returns either the provided value or the override
@@ -16,7 +16,9 @@ package model
 
 import (
 	"testing"
+	"time"
 
+	"github.com/google/exposure-notifications-server/pkg/errcmp"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -78,3 +80,142 @@ func TestEffectiveMaxRecords(t *testing.T) {
 		t.Fatalf("mismatch want: %v got: %v", want, got)
 	}
 }
+
+func TestExportConfigValidate_TransmissionRisk(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name           string
+		min            int
+		includeUnknown bool
+		err            string
+	}{
+		{name: "unset"},
+		{name: "min", min: 4},
+		{name: "min_include_unknown", min: 8, includeUnknown: true},
+		{name: "negative", min: -1, err: "minimum transmission risk must be between 0 and 8"},
+		{name: "too_high", min: 9, err: "minimum transmission risk must be between 0 and 8"},
+		{name: "include_unknown_without_min", includeUnknown: true, err: "requires a minimum transmission risk"},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ec := &ExportConfig{
+				Period:                         time.Hour,
+				MinTransmissionRisk:            tc.min,
+				IncludeUnknownTransmissionRisk: tc.includeUnknown,
+			}
+			errcmp.MustMatch(t, ec.Validate(), tc.err)
+		})
+	}
+}
+
+func TestExportConfigValidate_DefaultReportType(t *testing.T) {
+	t.Parallel()
+
+	for reportType, wantErr := range map[string]string{
+		"":            "",
+		"confirmed":   "",
+		"likely":      "",
+		"user-report": "",
+		"negative":    "default report type must be one of",
+		"banana":      "default report type must be one of",
+	} {
+		ec := &ExportConfig{Period: time.Hour, DefaultReportType: reportType}
+		errcmp.MustMatch(t, ec.Validate(), wantErr)
+	}
+}
+
+func TestExportConfigValidate_ReportTypes(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name           string
+		reportTypes    []string
+		includeUnknown bool
+		err            string
+	}{
+		{name: "unset"},
+		{name: "confirmed", reportTypes: []string{"confirmed"}},
+		{name: "all", reportTypes: []string{"confirmed", "likely", "user-report"}, includeUnknown: true},
+		{name: "negative", reportTypes: []string{"confirmed", "negative"}, err: `report types must be "confirmed", "likely" or "user-report", got "negative"`},
+		{name: "empty", reportTypes: []string{""}, err: `got ""`},
+		{name: "include_unknown_without_report_types", includeUnknown: true, err: "requires report types"},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ec := &ExportConfig{
+				Period:                   time.Hour,
+				ReportTypes:              tc.reportTypes,
+				IncludeUnknownReportType: tc.includeUnknown,
+			}
+			errcmp.MustMatch(t, ec.Validate(), tc.err)
+		})
+	}
+}
+
+func TestOutputSignatureInfoIDs(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		region string
+		want   []int64
+	}{
+		{"mapped", "US-CA", []int64{12}},
+		{"exact_match", "US-WA", []int64{1, 2}},
+		{"unmapped", "US-OR", []int64{1, 2}},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ec := &ExportConfig{
+				OutputRegion:         tc.region,
+				SignatureInfoIDs:     []int64{1, 2},
+				RegionSignatureInfos: map[string]int64{"US-CA": 12, "us-wa": 13},
+			}
+			if diff := cmp.Diff(tc.want, ec.OutputSignatureInfoIDs()); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestExportConfigValidate_RegionSignatureInfos(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		sigInfos map[string]int64
+		err      string
+	}{
+		{name: "unset"},
+		{name: "valid", sigInfos: map[string]int64{"US": 1}},
+		{name: "empty_region", sigInfos: map[string]int64{"": 1}, err: "cannot have an empty region"},
+		{name: "invalid_id", sigInfos: map[string]int64{"US": 0}, err: `signature info for region "US" must be a positive ID`},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ec := &ExportConfig{Period: time.Hour, RegionSignatureInfos: tc.sigInfos}
+			errcmp.MustMatch(t, ec.Validate(), tc.err)
+		})
+	}
+}
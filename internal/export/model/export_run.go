@@ -0,0 +1,72 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	ExportRunSuccess = "SUCCESS"
+	ExportRunFailure = "FAILURE"
+
+	// maxExportRunErrorLength bounds the error summary stored for a run.
+	maxExportRunErrorLength = 2000
+)
+
+// ExportRun is the record of the batches of a single export config that were
+// processed by one export worker run.
+type ExportRun struct {
+	RunID      int64
+	ConfigID   int64
+	StartedAt  time.Time
+	FinishedAt time.Time
+
+	// Batches is the number of batches that were exported or failed. Batches
+	// that were skipped, e.g. because another worker held the lock, are not
+	// counted.
+	Batches     int
+	Keys        int
+	RevisedKeys int
+
+	// Outcome is ExportRunFailure if any of the batches failed, and Error
+	// summarizes the failures.
+	Outcome string
+	Error   string
+}
+
+// AddBatch records an exported batch with its key counts.
+func (r *ExportRun) AddBatch(keys, revisedKeys int) {
+	r.Batches++
+	r.Keys += keys
+	r.RevisedKeys += revisedKeys
+}
+
+// AddFailure records a failed batch and adds its error to the summary. The
+// summary is truncated if there are many failures.
+func (r *ExportRun) AddFailure(err error) {
+	r.Batches++
+	r.Outcome = ExportRunFailure
+
+	msg := err.Error()
+	if r.Error != "" {
+		msg = r.Error + "; " + msg
+	}
+	if len(msg) > maxExportRunErrorLength {
+		msg = strings.ToValidUTF8(msg[:maxExportRunErrorLength], "") + "..."
+	}
+	r.Error = msg
+}
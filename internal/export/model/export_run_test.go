@@ -0,0 +1,62 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExportRun(t *testing.T) {
+	t.Parallel()
+
+	run := &ExportRun{ConfigID: 1, Outcome: ExportRunSuccess}
+	run.AddBatch(10, 2)
+	run.AddBatch(5, 0)
+
+	want := &ExportRun{ConfigID: 1, Batches: 2, Keys: 15, RevisedKeys: 2, Outcome: ExportRunSuccess}
+	if diff := cmp.Diff(want, run); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	run.AddFailure(errors.New("batch 3 failed"))
+	run.AddFailure(errors.New("batch 4 failed"))
+
+	want.Batches = 4
+	want.Outcome = ExportRunFailure
+	want.Error = "batch 3 failed; batch 4 failed"
+	if diff := cmp.Diff(want, run); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestExportRun_TruncatesError(t *testing.T) {
+	t.Parallel()
+
+	run := &ExportRun{Outcome: ExportRunSuccess}
+	for i := 0; i < 100; i++ {
+		run.AddFailure(errors.New(strings.Repeat("x", 100)))
+	}
+
+	if got, want := len(run.Error), maxExportRunErrorLength+len("..."); got != want {
+		t.Errorf("expected error length %d, got %d", want, got)
+	}
+	if got, want := run.Batches, 100; got != want {
+		t.Errorf("expected %d batches, got %d", want, got)
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scheduleTZPrefix optionally precedes a schedule to set its time zone.
+const scheduleTZPrefix = "CRON_TZ="
+
+// maxScheduleSearch is how far Next and Prev search for a matching time.
+const maxScheduleSearch = 366 * oneDay
+
+// Schedule is a parsed cron schedule, in the standard five field format:
+//
+//	minute hour day-of-month month day-of-week
+//
+// Each field is "*", or a comma separated list of values and ranges
+// ("1,15", "9-17"), optionally with a step ("*/15", "0-30/10"). Day of week
+// is 0-6, starting on Sunday. If both day of month and day of week are
+// restricted, a time matches if either does.
+//
+// The schedule is in UTC, unless it is prefixed with "CRON_TZ=<zone> ", where
+// zone is an IANA time zone name, for example "CRON_TZ=America/New_York 0 0 * * *"
+// for midnight in New York.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// If either day field is "*", both must match, otherwise either may.
+	domStar, dowStar bool
+	location         *time.Location
+}
+
+// ParseSchedule parses a cron schedule.
+func ParseSchedule(spec string) (*Schedule, error) {
+	spec = strings.TrimSpace(spec)
+
+	location := time.UTC
+	if strings.HasPrefix(spec, scheduleTZPrefix) {
+		parts := strings.SplitN(strings.TrimPrefix(spec, scheduleTZPrefix), " ", 2)
+		loc, err := time.LoadLocation(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule time zone %q: %w", parts[0], err)
+		}
+		location = loc
+		spec = ""
+		if len(parts) > 1 {
+			spec = parts[1]
+		}
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields, got %d", spec, len(fields))
+	}
+
+	s := &Schedule{location: location}
+	bounds := []struct {
+		name     string
+		min, max int
+		bits     *uint64
+	}{
+		{"minute", 0, 59, &s.minute},
+		{"hour", 0, 23, &s.hour},
+		{"day of month", 1, 31, &s.dom},
+		{"month", 1, 12, &s.month},
+		{"day of week", 0, 6, &s.dow},
+	}
+	for i, b := range bounds {
+		bits, err := parseScheduleField(fields[i], b.min, b.max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %s %q: %w", b.name, fields[i], err)
+		}
+		*b.bits = bits
+	}
+	s.domStar = fields[2] == "*"
+	s.dowStar = fields[4] == "*"
+
+	// A schedule like "0 0 30 2 *" is valid syntax, but never matches.
+	if s.Next(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)).IsZero() {
+		return nil, fmt.Errorf("invalid schedule %q: never matches", spec)
+	}
+	return s, nil
+}
+
+// parseScheduleField returns a bitset of the values matched by the field.
+func parseScheduleField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", part[i+1:])
+			}
+			rangePart = part[:i]
+		}
+
+		lo, hi := min, max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", bounds[0])
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value %q", bounds[1])
+				}
+			} else if step > 1 {
+				// "5/15" means starting at 5, through the max.
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q must be within %d-%d", rangePart, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// Location returns the time zone of the schedule.
+func (s *Schedule) Location() *time.Location {
+	return s.location
+}
+
+func (s *Schedule) matchesDay(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first time strictly after t that matches the schedule, or
+// the zero time if there is none within a year.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.In(s.location).Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxScheduleSearch)
+
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.location)
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.location)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = startOfHour(t).Add(time.Hour)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// Prev returns the last time at or before t that matches the schedule, or the
+// zero time if there is none within a year.
+func (s *Schedule) Prev(t time.Time) time.Time {
+	t = t.In(s.location).Truncate(time.Minute)
+	limit := t.Add(-maxScheduleSearch)
+
+	for t.After(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, s.location).Add(-time.Minute)
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, s.location).Add(-time.Minute)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = startOfHour(t).Add(-time.Minute)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(-time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// startOfHour truncates t to the hour in its own location, which may not be
+// offset from UTC by whole hours.
+func startOfHour(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+}
@@ -0,0 +1,159 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule_Errors(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		spec string
+		want string
+	}{
+		{spec: "", want: "expected 5 fields"},
+		{spec: "0 0 * *", want: "expected 5 fields"},
+		{spec: "0 0 * * * *", want: "expected 5 fields"},
+		{spec: "60 0 * * *", want: "minute"},
+		{spec: "0 24 * * *", want: "hour"},
+		{spec: "0 0 0 * *", want: "day of month"},
+		{spec: "0 0 * 13 *", want: "month"},
+		{spec: "0 0 * * 7", want: "day of week"},
+		{spec: "0 5-1 * * *", want: "hour"},
+		{spec: "*/0 * * * *", want: "invalid step"},
+		{spec: "a * * * *", want: "invalid value"},
+		{spec: "0 0 30 2 *", want: "never matches"},
+		{spec: "CRON_TZ=Not/AZone 0 0 * * *", want: "time zone"},
+		{spec: "CRON_TZ=UTC", want: "expected 5 fields"},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.spec, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := ParseSchedule(tc.spec)
+			if err == nil {
+				t.Fatalf("expected error")
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("expected %q to contain %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestSchedule_NextPrev(t *testing.T) {
+	t.Parallel()
+
+	// A Thursday.
+	at := time.Date(2020, 12, 10, 10, 11, 30, 0, time.UTC)
+
+	cases := []struct {
+		name     string
+		spec     string
+		at       time.Time
+		wantNext time.Time
+		wantPrev time.Time
+	}{
+		{
+			name:     "hourly",
+			spec:     "0 * * * *",
+			at:       at,
+			wantNext: time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC),
+			wantPrev: time.Date(2020, 12, 10, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "prev includes matching time",
+			spec:     "0 * * * *",
+			at:       time.Date(2020, 12, 10, 10, 0, 0, 0, time.UTC),
+			wantNext: time.Date(2020, 12, 10, 11, 0, 0, 0, time.UTC),
+			wantPrev: time.Date(2020, 12, 10, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "steps",
+			spec:     "*/15 * * * *",
+			at:       at,
+			wantNext: time.Date(2020, 12, 10, 10, 15, 0, 0, time.UTC),
+			wantPrev: time.Date(2020, 12, 10, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "list and range",
+			spec:     "30 2,20-22 * * *",
+			at:       at,
+			wantNext: time.Date(2020, 12, 10, 20, 30, 0, 0, time.UTC),
+			wantPrev: time.Date(2020, 12, 10, 2, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "day of week",
+			spec:     "0 0 * * 1",
+			at:       at,
+			wantNext: time.Date(2020, 12, 14, 0, 0, 0, 0, time.UTC),
+			wantPrev: time.Date(2020, 12, 7, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "day of month or day of week",
+			spec:     "0 0 1 * 5",
+			at:       at,
+			wantNext: time.Date(2020, 12, 11, 0, 0, 0, 0, time.UTC),
+			wantPrev: time.Date(2020, 12, 4, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "across years",
+			spec:     "0 0 1 1 *",
+			at:       at,
+			wantNext: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+			wantPrev: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "time zone",
+			spec:     "CRON_TZ=America/New_York 0 0 * * *",
+			at:       at,
+			wantNext: time.Date(2020, 12, 11, 5, 0, 0, 0, time.UTC),
+			wantPrev: time.Date(2020, 12, 10, 5, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "half hour time zone",
+			spec:     "CRON_TZ=Asia/Kolkata 0 * * * *",
+			at:       at,
+			wantNext: time.Date(2020, 12, 10, 10, 30, 0, 0, time.UTC),
+			wantPrev: time.Date(2020, 12, 10, 9, 30, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s, err := ParseSchedule(tc.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Next(tc.at); !got.Equal(tc.wantNext) {
+				t.Errorf("expected next %v to be %v", got.UTC(), tc.wantNext)
+			}
+			if got := s.Prev(tc.at); !got.Equal(tc.wantPrev) {
+				t.Errorf("expected prev %v to be %v", got.UTC(), tc.wantPrev)
+			}
+		})
+	}
+}
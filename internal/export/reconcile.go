@@ -0,0 +1,238 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	exportdatabase "github.com/google/exposure-notifications-server/internal/export/database"
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	"github.com/google/exposure-notifications-server/pkg/database"
+	"github.com/google/exposure-notifications-server/pkg/logging"
+	"github.com/hashicorp/go-multierror"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+// handleReconcileIndex is a handler that compares the index of every export
+// config with the export files in storage and the database. Discrepancies are
+// logged and reported as metrics and, unless running in dry run mode, the index
+// is rewritten to match.
+func (s *Server) handleReconcileIndex() http.Handler {
+	db := s.env.Database()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		logger := logging.FromContext(ctx).Named("handleReconcileIndex")
+		logger.Debugw("starting")
+		defer logger.Debugw("finishing")
+
+		ctx, cancel := context.WithTimeout(ctx, s.config.ReconcileTimeout)
+		defer cancel()
+
+		configs, err := exportdatabase.New(db).GetAllExportConfigs(ctx)
+		if err != nil {
+			logger.Errorw("failed to list export configs", "error", err)
+			s.h.RenderJSON(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		var merr *multierror.Error
+		for _, ec := range configs {
+			if _, err := s.reconcileIndex(ctx, ec); err != nil {
+				// Immediately stop if the context is expired.
+				if err := ctx.Err(); err != nil {
+					merr = multierror.Append(merr, err)
+					break
+				}
+
+				// Keep going, other indexes may still be reconciled.
+				merr = multierror.Append(merr, fmt.Errorf("failed to reconcile index for config %d: %w", ec.ConfigID, err))
+			}
+		}
+
+		if errs := merr.WrappedErrors(); len(errs) > 0 {
+			logger.Errorw("failed to reconcile indexes", "errors", errs)
+			s.h.RenderJSON(w, http.StatusInternalServerError, errs)
+			return
+		}
+
+		s.h.RenderJSON(w, http.StatusOK, nil)
+	})
+}
+
+// reconcileIndex reconciles the index for a single export config. It holds the
+// same lock as workers writing the index, and skips the config if a worker is
+// currently writing it. The returned reconciliation is nil if the config was
+// skipped.
+func (s *Server) reconcileIndex(ctx context.Context, ec *model.ExportConfig) (*exportdatabase.IndexReconciliation, error) {
+	logger := logging.FromContext(ctx).Named("reconcileIndex").
+		With("config", ec.ConfigID)
+	db := s.env.Database()
+
+	unlock, err := db.Lock(ctx, exportConfigLockID(ec.ConfigID), time.Minute)
+	if err != nil {
+		if errors.Is(err, database.ErrAlreadyLocked) {
+			logger.Debugw("skipping (already locked)")
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to obtain lock: %w", err)
+	}
+	defer func() {
+		if err := unlock(); err != nil {
+			logger.Errorw("failed to unlock", "error", err)
+		}
+	}()
+
+	indexName := indexFilename(ec.FilenameRoot)
+	result, err := exportdatabase.New(db).ReconcileIndex(ctx, s.env.Blobstore(), ec, indexName, s.config.TTL)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Discrepancies) == 0 {
+		return result, nil
+	}
+
+	counts := make(map[exportdatabase.IndexDiscrepancyReason]int64)
+	for _, d := range result.Discrepancies {
+		logger.Warnw("index discrepancy", "index", indexName, "filename", d.Filename, "reason", d.Reason)
+		counts[d.Reason]++
+	}
+	for reason, count := range counts {
+		tags := []tag.Mutator{
+			tag.Upsert(ExportConfigIDTagKey, fmt.Sprintf("%d", ec.ConfigID)),
+			tag.Upsert(ExportReasonTagKey, string(reason)),
+		}
+		if err := stats.RecordWithTags(ctx, tags, mIndexDiscrepancies.M(count)); err != nil {
+			logger.Errorw("failed to record index discrepancies", "error", err)
+		}
+	}
+
+	if s.config.ReconcileDryRun {
+		logger.Infow("not rewriting index (dry run)", "index", indexName, "discrepancies", len(result.Discrepancies))
+		return result, nil
+	}
+
+	if err := s.writeExportIndex(ctx, ec.BucketName, indexName, result.Expected, indexFileAttrs(ec)); err != nil {
+		return nil, err
+	}
+	logger.Infow("rewrote index", "index", indexName, "entries", len(result.Expected), "discrepancies", len(result.Discrepancies))
+	return result, nil
+}
+
+// backfillIndexResult is the outcome of backfilling an index.
+type backfillIndexResult struct {
+	// Added are the files that were added to the index.
+	Added []string `json:"added"`
+	// MissingBlobs are the recorded files that could not be added, because
+	// they don't exist in the blobstore.
+	MissingBlobs []string `json:"missingBlobs,omitempty"`
+	// Entries is the number of files in the index.
+	Entries int `json:"entries"`
+}
+
+// handleBackfillIndex is a handler that adds the export files of the config
+// given by the config-id query parameter to its index, if they are recorded in
+// the database and exist in the blobstore, but the index omits them. It is a
+// targeted repair after a partial failure: unlike handleReconcileIndex, entries
+// are only added, never removed.
+func (s *Server) handleBackfillIndex() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		logger := logging.FromContext(ctx).Named("handleBackfillIndex")
+
+		configID, err := parseBatchIDParam(r, "config-id", true)
+		if err != nil {
+			s.h.RenderJSON(w, http.StatusBadRequest, err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, s.config.ReconcileTimeout)
+		defer cancel()
+
+		ec, err := exportdatabase.New(s.env.Database()).GetExportConfig(ctx, configID)
+		if err != nil {
+			if errors.Is(err, database.ErrNotFound) {
+				s.h.RenderJSON(w, http.StatusNotFound, fmt.Errorf("unknown export config %d", configID))
+				return
+			}
+			logger.Errorw("failed to get export config", "config", configID, "error", err)
+			s.h.RenderJSON(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		result, err := s.backfillIndex(ctx, ec)
+		if err != nil {
+			if errors.Is(err, database.ErrAlreadyLocked) {
+				s.h.RenderJSON(w, http.StatusConflict, fmt.Errorf("index for export config %d is being written, try again later", configID))
+				return
+			}
+			logger.Errorw("failed to backfill index", "config", configID, "error", err)
+			s.h.RenderJSON(w, http.StatusInternalServerError, err)
+			return
+		}
+		s.h.RenderJSON(w, http.StatusOK, result)
+	})
+}
+
+// backfillIndex adds the missing export files to the index for the export
+// config, holding the same lock as workers writing the index. The index is
+// only written if files were added.
+func (s *Server) backfillIndex(ctx context.Context, ec *model.ExportConfig) (*backfillIndexResult, error) {
+	logger := logging.FromContext(ctx).Named("backfillIndex").
+		With("config", ec.ConfigID)
+	db := s.env.Database()
+
+	unlock, err := db.Lock(ctx, exportConfigLockID(ec.ConfigID), time.Minute)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain lock: %w", err)
+	}
+	defer func() {
+		if err := unlock(); err != nil {
+			logger.Errorw("failed to unlock", "error", err)
+		}
+	}()
+
+	indexName := indexFilename(ec.FilenameRoot)
+	backfill, err := exportdatabase.New(db).BackfillIndex(ctx, s.env.Blobstore(), ec, indexName, s.config.TTL)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range backfill.MissingBlobs {
+		logger.Warnw("recorded export file does not exist, not adding it to the index", "index", indexName, "filename", name)
+	}
+
+	result := &backfillIndexResult{
+		Added:        backfill.Added,
+		MissingBlobs: backfill.MissingBlobs,
+		Entries:      len(backfill.Indexed),
+	}
+	if len(backfill.Added) == 0 {
+		return result, nil
+	}
+
+	if err := s.writeExportIndex(ctx, ec.BucketName, indexName, backfill.Entries, indexFileAttrs(ec)); err != nil {
+		return nil, err
+	}
+	result.Entries = len(backfill.Entries)
+	logger.Infow("backfilled index", "index", indexName, "added", len(backfill.Added), "entries", len(backfill.Entries))
+	return result, nil
+}
@@ -0,0 +1,265 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	exportdatabase "github.com/google/exposure-notifications-server/internal/export/database"
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/exposure-notifications-server/internal/storage"
+	"github.com/google/exposure-notifications-server/pkg/render"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHandleReconcileIndex(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name        string
+		dryRun      bool
+		wantRewrite bool
+	}{
+		{
+			name:        "dry_run",
+			dryRun:      true,
+			wantRewrite: false,
+		},
+		{
+			name:        "rewrite",
+			dryRun:      false,
+			wantRewrite: true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := project.TestContext(t)
+			testDB, _ := testDatabaseInstance.NewDatabase(t)
+			exportDB := exportdatabase.New(testDB)
+			now := time.Now().UTC().Truncate(time.Second)
+
+			blobstore, err := storage.NewMemory(ctx, &storage.Config{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ec := &model.ExportConfig{
+				BucketName:   "bucket",
+				FilenameRoot: "root",
+				Period:       time.Hour,
+				OutputRegion: "US",
+			}
+			if err := exportDB.AddExportConfig(ctx, ec); err != nil {
+				t.Fatal(err)
+			}
+
+			eb := &model.ExportBatch{
+				ConfigID:       ec.ConfigID,
+				BucketName:     ec.BucketName,
+				FilenameRoot:   ec.FilenameRoot,
+				StartTimestamp: now.Add(-50 * time.Hour),
+				EndTimestamp:   now.Add(-49 * time.Hour),
+				OutputRegion:   ec.OutputRegion,
+				Status:         model.ExportBatchOpen,
+			}
+			if err := exportDB.AddExportBatches(ctx, []*model.ExportBatch{eb}); err != nil {
+				t.Fatal(err)
+			}
+			eb, err = exportDB.LeaseBatch(ctx, time.Hour, now, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The index lists a recorded file that was never written and a file
+			// that was never recorded, but omits the file that was recorded and
+			// written.
+			recorded := exportFilename(eb, 1, 0)
+			unwritten := exportFilename(eb, 2, 0)
+			unrecorded := "root/300-400-00001.zip"
+			if err := exportDB.FinalizeBatch(ctx, eb, []string{recorded, unwritten}, 2); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{recorded, unrecorded} {
+				if err := blobstore.CreateObject(ctx, ec.BucketName, name, []byte("contents"), false, storage.ContentTypeZip); err != nil {
+					t.Fatal(err)
+				}
+			}
+			indexName := exportIndexFilename(eb)
+			index := strings.Join([]string{unwritten, unrecorded}, "\n")
+			if err := blobstore.CreateObject(ctx, ec.BucketName, indexName, []byte(index), false, storage.ContentTypeTextPlain); err != nil {
+				t.Fatal(err)
+			}
+
+			server := &Server{
+				config: &Config{
+					TTL:              14 * 24 * time.Hour,
+					ReconcileTimeout: time.Minute,
+					ReconcileDryRun:  tc.dryRun,
+				},
+				env: serverenv.New(ctx,
+					serverenv.WithDatabase(testDB),
+					serverenv.WithBlobStorage(blobstore)),
+				h: render.NewRenderer(),
+			}
+
+			r, err := http.NewRequestWithContext(ctx, http.MethodGet, "/reconcile-index", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			server.handleReconcileIndex().ServeHTTP(w, r)
+
+			if got, want := w.Code, http.StatusOK; got != want {
+				t.Fatalf("expected status %d to be %d: %s", got, want, w.Body.String())
+			}
+
+			got, err := blobstore.GetObject(ctx, ec.BucketName, indexName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := index
+			if tc.wantRewrite {
+				want = recorded
+			}
+			if got := string(got); got != want {
+				t.Errorf("expected index %q to be %q", got, want)
+			}
+		})
+	}
+}
+
+func TestHandleBackfillIndex(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	exportDB := exportdatabase.New(testDB)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	blobstore, err := storage.NewMemory(ctx, &storage.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ec := &model.ExportConfig{
+		BucketName:   "bucket",
+		FilenameRoot: "root",
+		Period:       time.Hour,
+		OutputRegion: "US",
+	}
+	if err := exportDB.AddExportConfig(ctx, ec); err != nil {
+		t.Fatal(err)
+	}
+
+	eb := &model.ExportBatch{
+		ConfigID:       ec.ConfigID,
+		BucketName:     ec.BucketName,
+		FilenameRoot:   ec.FilenameRoot,
+		StartTimestamp: now.Add(-50 * time.Hour),
+		EndTimestamp:   now.Add(-49 * time.Hour),
+		OutputRegion:   ec.OutputRegion,
+		Status:         model.ExportBatchOpen,
+	}
+	if err := exportDB.AddExportBatches(ctx, []*model.ExportBatch{eb}); err != nil {
+		t.Fatal(err)
+	}
+	eb, err = exportDB.LeaseBatch(ctx, time.Hour, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both files were written and recorded, but the index only lists the
+	// first, and a file that was never recorded.
+	first := exportFilename(eb, 1, 0)
+	second := exportFilename(eb, 2, 0)
+	unrecorded := "root/300-400-00001.zip"
+	if err := exportDB.FinalizeBatch(ctx, eb, []string{first, second}, 2); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{first, second, unrecorded} {
+		if err := blobstore.CreateObject(ctx, ec.BucketName, name, []byte("contents"), false, storage.ContentTypeZip); err != nil {
+			t.Fatal(err)
+		}
+	}
+	indexName := exportIndexFilename(eb)
+	index := strings.Join([]string{first, unrecorded}, "\n")
+	if err := blobstore.CreateObject(ctx, ec.BucketName, indexName, []byte(index), false, storage.ContentTypeTextPlain); err != nil {
+		t.Fatal(err)
+	}
+
+	server := &Server{
+		config: &Config{
+			TTL:              14 * 24 * time.Hour,
+			ReconcileTimeout: time.Minute,
+		},
+		env: serverenv.New(ctx,
+			serverenv.WithDatabase(testDB),
+			serverenv.WithBlobStorage(blobstore)),
+		h: render.NewRenderer(),
+	}
+
+	backfill := func(t *testing.T, query string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		r, err := http.NewRequestWithContext(ctx, http.MethodPost, "/backfill-index"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		server.handleBackfillIndex().ServeHTTP(w, r)
+		return w
+	}
+
+	if got, want := backfill(t, "").Code, http.StatusBadRequest; got != want {
+		t.Errorf("expected status without a config %d to be %d", got, want)
+	}
+	if got, want := backfill(t, fmt.Sprintf("?config-id=%d", ec.ConfigID+1)).Code, http.StatusNotFound; got != want {
+		t.Errorf("expected status for an unknown config %d to be %d", got, want)
+	}
+
+	w := backfill(t, fmt.Sprintf("?config-id=%d", ec.ConfigID))
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("expected status %d to be %d: %s", got, want, w.Body.String())
+	}
+	var result backfillIndexResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(&backfillIndexResult{Added: []string{second}, Entries: 3}, &result); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// The missing entry is restored, the unrecorded one is kept.
+	got, err := blobstore.GetObject(ctx, ec.BucketName, indexName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(got), strings.Join([]string{first, second, unrecorded}, "\n"); got != want {
+		t.Errorf("expected index %q to be %q", got, want)
+	}
+}
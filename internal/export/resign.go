@@ -0,0 +1,282 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	exportdatabase "github.com/google/exposure-notifications-server/internal/export/database"
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	"github.com/google/exposure-notifications-server/internal/pb/export"
+	"github.com/google/exposure-notifications-server/internal/storage"
+	"github.com/google/exposure-notifications-server/pkg/database"
+	"github.com/google/exposure-notifications-server/pkg/logging"
+)
+
+// resignPageSize is the number of export files loaded from the database at a
+// time while re-signing.
+const resignPageSize = 100
+
+// resignResult is the outcome of re-signing a range of batches.
+type resignResult struct {
+	// Resigned is the number of files that were given new signatures, Skipped
+	// the number that already had them.
+	Resigned int `json:"resigned"`
+	Skipped  int `json:"skipped"`
+
+	// NextBatchID is set if the range was not finished before the timeout. It
+	// is passed as from-batch-id to resume.
+	NextBatchID int64 `json:"nextBatchID,omitempty"`
+}
+
+// handleResignBatches is a handler that replaces the signatures of existing
+// export files with signatures from the export config's current signature
+// infos, for recovery after a signing key is compromised. The export.bin
+// payloads are not regenerated, but the CBOR files of batches that include
+// them are, since their signatures are over the whole CBOR payload. The config is given by the config-id query
+// parameter, and the range of batches by from-batch-id and to-batch-id, which
+// are both optional and inclusive.
+//
+// Files that already have the current signatures are skipped, and a run that
+// times out returns the batch to resume from, so large ranges can be re-signed
+// over multiple requests. Once the range is complete, the index is rewritten.
+func (s *Server) handleResignBatches() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		logger := logging.FromContext(ctx).Named("handleResignBatches")
+
+		configID, err := parseBatchIDParam(r, "config-id", true)
+		if err != nil {
+			s.h.RenderJSON(w, http.StatusBadRequest, err)
+			return
+		}
+		fromBatchID, err := parseBatchIDParam(r, "from-batch-id", false)
+		if err != nil {
+			s.h.RenderJSON(w, http.StatusBadRequest, err)
+			return
+		}
+		toBatchID, err := parseBatchIDParam(r, "to-batch-id", false)
+		if err != nil {
+			s.h.RenderJSON(w, http.StatusBadRequest, err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, s.config.ResignTimeout)
+		defer cancel()
+
+		ec, err := exportdatabase.New(s.env.Database()).GetExportConfig(ctx, configID)
+		if err != nil {
+			if errors.Is(err, database.ErrNotFound) {
+				s.h.RenderJSON(w, http.StatusNotFound, fmt.Errorf("unknown export config %d", configID))
+				return
+			}
+			logger.Errorw("failed to get export config", "config", configID, "error", err)
+			s.h.RenderJSON(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		result, err := s.resignBatches(ctx, ec, fromBatchID, toBatchID)
+		if err != nil {
+			logger.Errorw("failed to re-sign batches", "config", configID, "error", err)
+			s.h.RenderJSON(w, http.StatusInternalServerError, err)
+			return
+		}
+		s.h.RenderJSON(w, http.StatusOK, result)
+	})
+}
+
+// parseBatchIDParam parses a positive ID query parameter. If the parameter is
+// missing and not required, 0 is returned.
+func parseBatchIDParam(r *http.Request, name string, required bool) (int64, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		if required {
+			return 0, fmt.Errorf("%s is required", name)
+		}
+		return 0, nil
+	}
+
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("%s must be a positive integer", name)
+	}
+	return id, nil
+}
+
+// resignBatches re-signs the completed export files for the config's batches
+// between fromBatchID and toBatchID. If the context is done before the range
+// is finished, the result has the batch to resume from.
+func (s *Server) resignBatches(ctx context.Context, ec *model.ExportConfig, fromBatchID, toBatchID int64) (*resignResult, error) {
+	logger := logging.FromContext(ctx).Named("resignBatches").
+		With("config", ec.ConfigID)
+	exportDB := exportdatabase.New(s.env.Database())
+
+	sigInfos, err := exportDB.LookupSignatureInfos(ctx, ec.OutputSignatureInfoIDs(), time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("error loading signature info for config %d: %w", ec.ConfigID, err)
+	}
+	if len(sigInfos) == 0 {
+		return nil, fmt.Errorf("export config %d has no valid signature infos", ec.ConfigID)
+	}
+	signers, err := s.signers(ctx, sigInfos)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &resignResult{}
+	var afterFilename string
+	var eb *model.ExportBatch
+	for {
+		files, err := exportDB.ListBatchExportFiles(ctx, ec.ConfigID, fromBatchID, afterFilename, toBatchID, resignPageSize)
+		if err != nil {
+			if ctx.Err() != nil {
+				result.NextBatchID = fromBatchID
+				return result, nil
+			}
+			return nil, err
+		}
+
+		for _, ef := range files {
+			if ctx.Err() != nil {
+				logger.Infow("timed out re-signing batches", "next_batch_id", ef.BatchID)
+				result.NextBatchID = ef.BatchID
+				return result, nil
+			}
+
+			// Files are listed in batch order, so each batch is only loaded once.
+			if eb == nil || eb.BatchID != ef.BatchID {
+				if eb, err = exportDB.LookupExportBatch(ctx, ef.BatchID); err != nil {
+					if ctx.Err() != nil {
+						result.NextBatchID = ef.BatchID
+						return result, nil
+					}
+					return nil, fmt.Errorf("loading batch %d: %w", ef.BatchID, err)
+				}
+			}
+
+			resigned, err := s.resignFile(ctx, eb, ef, signers, exportFileAttrs(ec))
+			if err != nil {
+				return nil, fmt.Errorf("re-signing file %q for batch %d: %w", ef.Filename, ef.BatchID, err)
+			}
+			if resigned {
+				result.Resigned++
+			} else {
+				result.Skipped++
+			}
+		}
+
+		if len(files) < resignPageSize {
+			break
+		}
+		last := files[len(files)-1]
+		fromBatchID, afterFilename = last.BatchID, last.Filename
+	}
+
+	if err := s.rewriteIndex(ctx, ec); err != nil {
+		return nil, err
+	}
+	logger.Infow("re-signed batches", "resigned", result.Resigned, "skipped", result.Skipped)
+	return result, nil
+}
+
+// resignFile replaces the signatures of a single export file of the batch, and
+// regenerates its CBOR file. It returns false if the file already has
+// signatures from exactly these signers.
+func (s *Server) resignFile(ctx context.Context, eb *model.ExportBatch, ef *model.ExportFile, signers []*Signer, attrs *storage.ObjectAttrs) (bool, error) {
+	blobCtx, cancel := context.WithTimeout(ctx, blobOperationTimeout)
+	defer cancel()
+
+	data, err := s.env.Blobstore().GetObject(blobCtx, ef.BucketName, ef.Filename)
+	if err != nil {
+		return false, fmt.Errorf("reading file: %w", err)
+	}
+
+	sigs, err := UnmarshalSignatureFile(data)
+	if err != nil {
+		return false, fmt.Errorf("reading signatures: %w", err)
+	}
+	if hasSigners(sigs.Signatures, signers) {
+		return false, nil
+	}
+
+	resigned, err := ResignExportFile(data, signers)
+	if err != nil {
+		return false, err
+	}
+	// The sidecar and the CBOR file must match the new signatures.
+	if err := s.writeSignatureSidecar(ctx, ef.BucketName, ef.Filename, resigned, signers, attrs); err != nil {
+		return false, err
+	}
+	if err := s.writeCBORFile(ctx, eb, ef.Filename, resigned, signers, attrs); err != nil {
+		return false, err
+	}
+	if err := s.env.Blobstore().CreateObjectWithAttrs(blobCtx, ef.BucketName, ef.Filename, resigned, attrs); err != nil {
+		return false, fmt.Errorf("writing file: %w", err)
+	}
+	return true, nil
+}
+
+// rewriteIndex rewrites the index for the export config from the database,
+// holding the same lock as workers writing the index.
+func (s *Server) rewriteIndex(ctx context.Context, ec *model.ExportConfig) error {
+	db := s.env.Database()
+
+	unlock, err := db.Lock(ctx, exportConfigLockID(ec.ConfigID), time.Minute)
+	if err != nil {
+		return fmt.Errorf("failed to obtain index lock: %w", err)
+	}
+	defer func() {
+		if err := unlock(); err != nil {
+			logging.FromContext(ctx).Errorw("failed to unlock", "error", err)
+		}
+	}()
+
+	objects, err := exportdatabase.New(db).LookupExportFiles(ctx, ec.ConfigID, s.config.TTL)
+	if err != nil {
+		return fmt.Errorf("lookup available export files: %w", err)
+	}
+	sort.Strings(objects)
+
+	return s.writeExportIndex(ctx, ec.BucketName, indexFilename(ec.FilenameRoot), objects, indexFileAttrs(ec))
+}
+
+// hasSigners returns true if the signatures are from exactly the given signers,
+// identified by their verification key ID and version.
+func hasSigners(sigs []*export.TEKSignature, signers []*Signer) bool {
+	if len(sigs) != len(signers) {
+		return false
+	}
+
+	want := make(map[string]int, len(signers))
+	for _, s := range signers {
+		want[s.SignatureInfo.SigningKeyID+"/"+s.SignatureInfo.SigningKeyVersion]++
+	}
+	for _, sig := range sigs {
+		info := sig.GetSignatureInfo()
+		key := info.GetVerificationKeyId() + "/" + info.GetVerificationKeyVersion()
+		if want[key] == 0 {
+			return false
+		}
+		want[key]--
+	}
+	return true
+}
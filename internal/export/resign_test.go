@@ -0,0 +1,295 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	exportdatabase "github.com/google/exposure-notifications-server/internal/export/database"
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	"github.com/google/exposure-notifications-server/internal/pb/export"
+	"github.com/google/exposure-notifications-server/internal/project"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/exposure-notifications-server/internal/storage"
+	"github.com/google/exposure-notifications-server/pkg/keys"
+	"github.com/google/exposure-notifications-server/pkg/render"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+func TestHandleResignBatches(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	exportDB := exportdatabase.New(testDB)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	blobstore, err := storage.NewMemory(ctx, &storage.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The trusted key is in the key manager, the compromised key only signed
+	// the original files.
+	oldSigner := newTestSigner(t, "compromised", "1")
+	newKey := newTestSigner(t, "trusted", "2").Signer.(*ecdsa.PrivateKey)
+
+	root := t.TempDir()
+	der, err := x509.MarshalECPrivateKey(newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "trusted"), der, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	kms, err := keys.NewFilesystem(ctx, &keys.Config{FilesystemRoot: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	si := &model.SignatureInfo{
+		SigningKey:        "trusted",
+		SigningKeyID:      "trusted",
+		SigningKeyVersion: "2",
+	}
+	if err := exportDB.AddSignatureInfo(ctx, si); err != nil {
+		t.Fatal(err)
+	}
+
+	ec := &model.ExportConfig{
+		BucketName:       "bucket",
+		FilenameRoot:     "root",
+		Period:           time.Hour,
+		OutputRegion:     "US",
+		SignatureInfoIDs: []int64{si.ID},
+		IncludeCBOR:      true,
+	}
+	if err := exportDB.AddExportConfig(ctx, ec); err != nil {
+		t.Fatal(err)
+	}
+
+	// Create batches with files signed by the compromised key.
+	var batchIDs []int64
+	originals := make(map[string][]byte)
+	for i := 0; i < 3; i++ {
+		eb := &model.ExportBatch{
+			ConfigID:       ec.ConfigID,
+			BucketName:     ec.BucketName,
+			FilenameRoot:   ec.FilenameRoot,
+			StartTimestamp: now.Add(time.Duration(-10+i) * time.Hour),
+			EndTimestamp:   now.Add(time.Duration(-9+i) * time.Hour),
+			OutputRegion:   ec.OutputRegion,
+			Status:         model.ExportBatchOpen,
+			IncludeCBOR:    ec.IncludeCBOR,
+		}
+		if err := exportDB.AddExportBatches(ctx, []*model.ExportBatch{eb}); err != nil {
+			t.Fatal(err)
+		}
+		eb, err = exportDB.LeaseBatch(ctx, time.Hour, now, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exposures := []*publishmodel.Exposure{
+			{
+				ExposureKey:    []byte(fmt.Sprintf("key-%d-0123456", i)),
+				IntervalNumber: 100,
+				IntervalCount:  144,
+			},
+		}
+		data, err := MarshalExportFile(eb, exposures, nil, 1, false, []*Signer{oldSigner})
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := exportFilename(eb, 1, 0)
+		if err := blobstore.CreateObject(ctx, ec.BucketName, name, data, true, storage.ContentTypeZip); err != nil {
+			t.Fatal(err)
+		}
+		exp, _, err := UnmarshalExportFile(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cbor, err := MarshalCBORExportFile(exp, []*Signer{oldSigner})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := blobstore.CreateObject(ctx, ec.BucketName, cborFilename(name), cbor, true, storage.ContentTypeCBOR); err != nil {
+			t.Fatal(err)
+		}
+		if err := exportDB.FinalizeBatch(ctx, eb, []string{name}, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		batchIDs = append(batchIDs, eb.BatchID)
+		originals[name] = data
+	}
+
+	server := &Server{
+		config: &Config{
+			TTL:           14 * 24 * time.Hour,
+			ResignTimeout: time.Minute,
+		},
+		env: serverenv.New(ctx,
+			serverenv.WithDatabase(testDB),
+			serverenv.WithBlobStorage(blobstore),
+			serverenv.WithKeyManager(kms)),
+		h: render.NewRenderer(),
+	}
+
+	resign := func(t *testing.T, query string) *resignResult {
+		t.Helper()
+
+		r, err := http.NewRequestWithContext(ctx, http.MethodGet, "/resign-batches?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		server.handleResignBatches().ServeHTTP(w, r)
+
+		if got, want := w.Code, http.StatusOK; got != want {
+			t.Fatalf("expected status %d to be %d: %s", got, want, w.Body.String())
+		}
+		var result resignResult
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		return &result
+	}
+
+	// Re-sign the first two batches.
+	got := resign(t, fmt.Sprintf("config-id=%d&to-batch-id=%d", ec.ConfigID, batchIDs[1]))
+	if diff := cmp.Diff(&resignResult{Resigned: 2}, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Resuming from the second batch skips the files already re-signed.
+	got = resign(t, fmt.Sprintf("config-id=%d&from-batch-id=%d", ec.ConfigID, batchIDs[1]))
+	if diff := cmp.Diff(&resignResult{Resigned: 1, Skipped: 1}, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	for name, original := range originals {
+		data, err := blobstore.GetObject(ctx, ec.BucketName, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		payload := readTestZipFile(t, data, exportBinaryName)
+		if want := readTestZipFile(t, original, exportBinaryName); !bytes.Equal(payload, want) {
+			t.Errorf("expected %v of %q to be unchanged", exportBinaryName, name)
+		}
+
+		sigs, err := UnmarshalSignatureFile(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(sigs.Signatures), 1; got != want {
+			t.Fatalf("expected %d signatures in %q, got %d", want, name, got)
+		}
+		digest := sha256.Sum256(payload)
+		if !ecdsa.VerifyASN1(&newKey.PublicKey, digest[:], sigs.Signatures[0].Signature) {
+			t.Errorf("expected signature of %q to verify under the new key", name)
+		}
+
+		// The CBOR file is regenerated with the new signature infos.
+		cborName := cborFilename(name)
+		cborData, err := blobstore.GetObject(ctx, ec.BucketName, cborName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := UnmarshalCBORExportFile(cborData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantInfo := &export.SignatureInfo{
+			VerificationKeyVersion: proto.String("2"),
+			VerificationKeyId:      proto.String("trusted"),
+			SignatureAlgorithm:     proto.String(algorithm),
+		}
+		if diff := cmp.Diff([]*export.SignatureInfo{wantInfo}, f.Export.SignatureInfos, protocmp.Transform()); diff != "" {
+			t.Errorf("signature infos of %q mismatch (-want, +got):\n%s", cborName, diff)
+		}
+		if got, want := len(f.Signatures.Signatures), 1; got != want {
+			t.Fatalf("expected %d signatures in %q, got %d", want, cborName, got)
+		}
+		if diff := cmp.Diff(wantInfo, f.Signatures.Signatures[0].SignatureInfo, protocmp.Transform()); diff != "" {
+			t.Errorf("signature info of %q mismatch (-want, +got):\n%s", cborName, diff)
+		}
+		cborDigest := sha256.Sum256(f.Payload)
+		if !ecdsa.VerifyASN1(&newKey.PublicKey, cborDigest[:], f.Signatures.Signatures[0].Signature) {
+			t.Errorf("expected signature of %q to verify under the new key", cborName)
+		}
+	}
+
+	// The index lists all files.
+	index, err := blobstore.GetObject(ctx, ec.BucketName, indexFilename(ec.FilenameRoot))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(strings.Split(string(index), "\n")), len(originals); got != want {
+		t.Errorf("expected %d index entries, got %d: %s", want, got, index)
+	}
+}
+
+func TestHandleResignBatches_BadRequest(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name  string
+		query string
+	}{
+		{name: "missing_config", query: ""},
+		{name: "invalid_config", query: "config-id=abc"},
+		{name: "invalid_from", query: "config-id=1&from-batch-id=-1"},
+		{name: "invalid_to", query: "config-id=1&to-batch-id=0"},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := project.TestContext(t)
+			server := &Server{config: &Config{}, h: render.NewRenderer()}
+
+			r, err := http.NewRequestWithContext(ctx, http.MethodGet, "/resign-batches?"+tc.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			server.handleResignBatches().ServeHTTP(w, r)
+
+			if got, want := w.Code, http.StatusBadRequest; got != want {
+				t.Errorf("expected status %d to be %d: %s", got, want, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	exportdatabase "github.com/google/exposure-notifications-server/internal/export/database"
+	publishdatabase "github.com/google/exposure-notifications-server/internal/publish/database"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/storage"
+	"github.com/google/exposure-notifications-server/pkg/logging"
+	"github.com/google/exposure-notifications-server/pkg/timeutils"
+)
+
+const (
+	revisionChangelogDayFormat = "2006-01-02"
+	revisionChangelogSuffix    = ".json"
+	revisionChangelogSigSuffix = ".sig.json"
+)
+
+// revisionChangelog is the changelog of the revisions on a UTC day. It only
+// has counts, never the keys that were revised.
+type revisionChangelog struct {
+	Day     string                     `json:"day"`
+	Regions []*revisionChangelogRegion `json:"regions"`
+}
+
+type revisionChangelogRegion struct {
+	Region      string                         `json:"region"`
+	Transitions []*revisionChangelogTransition `json:"transitions"`
+}
+
+// revisionChangelogTransition is the number of keys revised from one report
+// type to another. From is empty for keys published without a report type.
+type revisionChangelogTransition struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Count int64  `json:"count"`
+}
+
+// revisionChangelogSignature is the signature of a changelog, over the
+// SHA-256 digest of the changelog file.
+type revisionChangelogSignature struct {
+	// File is the name of the changelog file.
+	File string `json:"file"`
+	// Algorithm is the OID of the signature algorithm.
+	Algorithm   string `json:"algorithm"`
+	KeyID       string `json:"keyID,omitempty"`
+	KeyVersion  string `json:"keyVersion,omitempty"`
+	KeyResource string `json:"keyResource"`
+	// Signature is the base64 encoded signature.
+	Signature string `json:"signature"`
+}
+
+// revisionChangelogResult is the response of the changelog handler.
+type revisionChangelogResult struct {
+	Day         string   `json:"day"`
+	Transitions int      `json:"transitions"`
+	Objects     []string `json:"objects"`
+}
+
+// handleRevisionChangelog is a handler that writes the revision changelog of a
+// UTC day, given by the day query parameter as YYYY-MM-DD, and yesterday by
+// default. Only days that are over can be written. Writing a day again
+// replaces its changelog.
+func (s *Server) handleRevisionChangelog() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		logger := logging.FromContext(ctx).Named("handleRevisionChangelog")
+
+		if s.config.RevisionChangelogBucket == "" {
+			s.h.RenderJSON(w, http.StatusNotFound, fmt.Errorf("revision changelog is not enabled"))
+			return
+		}
+
+		today := timeutils.UTCMidnight(time.Now())
+		day := today.AddDate(0, 0, -1)
+		if v := r.URL.Query().Get("day"); v != "" {
+			parsed, err := time.Parse(revisionChangelogDayFormat, v)
+			if err != nil {
+				s.h.RenderJSON(w, http.StatusBadRequest, fmt.Errorf("day must be in the format YYYY-MM-DD"))
+				return
+			}
+			if !parsed.Before(today) {
+				s.h.RenderJSON(w, http.StatusBadRequest, fmt.Errorf("day must be before %s", today.Format(revisionChangelogDayFormat)))
+				return
+			}
+			day = parsed
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, s.config.RevisionChangelogTimeout)
+		defer cancel()
+
+		result, err := s.writeRevisionChangelog(ctx, day)
+		if err != nil {
+			logger.Errorw("failed to write revision changelog", "day", day, "error", err)
+			s.h.RenderJSON(w, http.StatusInternalServerError, err)
+			return
+		}
+		s.h.RenderJSON(w, http.StatusOK, result)
+	})
+}
+
+// writeRevisionChangelog writes, and signs if configured, the changelog of the
+// day that starts at the UTC midnight day.
+func (s *Server) writeRevisionChangelog(ctx context.Context, day time.Time) (*revisionChangelogResult, error) {
+	transitions, err := publishdatabase.New(s.env.Database()).ReadRevisionTransitions(ctx, day, day.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+
+	changelog := newRevisionChangelog(day, transitions)
+	b, err := json.Marshal(changelog)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling revision changelog: %w", err)
+	}
+
+	bucket := s.config.RevisionChangelogBucket
+	name := path.Join(s.config.RevisionChangelogFilenameRoot, changelog.Day+revisionChangelogSuffix)
+	result := &revisionChangelogResult{
+		Day:         changelog.Day,
+		Transitions: len(transitions),
+	}
+
+	// The signature is made before anything is written, so a changelog is never
+	// written without its signature.
+	var sigData []byte
+	if id := s.config.RevisionChangelogSignatureInfoID; id > 0 {
+		sig, err := s.signRevisionChangelog(ctx, name, b, id)
+		if err != nil {
+			return nil, err
+		}
+		if sigData, err = json.Marshal(sig); err != nil {
+			return nil, fmt.Errorf("marshaling revision changelog signature: %w", err)
+		}
+	}
+
+	if err := s.writeRevisionChangelogObject(ctx, bucket, name, b); err != nil {
+		return nil, err
+	}
+	result.Objects = append(result.Objects, name)
+
+	if sigData != nil {
+		sigName := path.Join(s.config.RevisionChangelogFilenameRoot, changelog.Day+revisionChangelogSigSuffix)
+		if err := s.writeRevisionChangelogObject(ctx, bucket, sigName, sigData); err != nil {
+			return nil, err
+		}
+		result.Objects = append(result.Objects, sigName)
+	}
+	return result, nil
+}
+
+// signRevisionChangelog signs the changelog data with the signature info.
+func (s *Server) signRevisionChangelog(ctx context.Context, name string, data []byte, signatureInfoID int64) (*revisionChangelogSignature, error) {
+	sigInfos, err := exportdatabase.New(s.env.Database()).LookupSignatureInfos(ctx, []int64{signatureInfoID}, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("error loading signature info %d: %w", signatureInfoID, err)
+	}
+	if len(sigInfos) == 0 {
+		return nil, fmt.Errorf("signature info %d is not valid", signatureInfoID)
+	}
+	signers, err := s.signers(ctx, sigInfos)
+	if err != nil {
+		return nil, err
+	}
+	signer := signers[0]
+
+	digest := sha256.Sum256(data)
+	sig, err := generateSignature(digest[:], signer.Signer)
+	if err != nil {
+		return nil, fmt.Errorf("signing revision changelog: %w", err)
+	}
+	return &revisionChangelogSignature{
+		File:        name,
+		Algorithm:   algorithm,
+		KeyID:       signer.SignatureInfo.SigningKeyID,
+		KeyVersion:  signer.SignatureInfo.SigningKeyVersion,
+		KeyResource: signer.SignatureInfo.SigningKey,
+		Signature:   base64.StdEncoding.EncodeToString(sig),
+	}, nil
+}
+
+func (s *Server) writeRevisionChangelogObject(ctx context.Context, bucket, name string, data []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, blobOperationTimeout)
+	defer cancel()
+
+	if err := s.env.Blobstore().CreateObjectWithAttrs(ctx, bucket, name, data, storage.DefaultObjectAttrs(true, storage.ContentTypeJSON)); err != nil {
+		return fmt.Errorf("creating %s in bucket %s: %w", name, bucket, err)
+	}
+	return nil
+}
+
+// newRevisionChangelog groups the transitions of the day by region. The
+// transitions must be ordered by region, like ReadRevisionTransitions returns
+// them. A day without revisions has no regions.
+func newRevisionChangelog(day time.Time, transitions []*publishmodel.RevisionTransition) *revisionChangelog {
+	changelog := &revisionChangelog{
+		Day:     day.UTC().Format(revisionChangelogDayFormat),
+		Regions: make([]*revisionChangelogRegion, 0),
+	}
+
+	var current *revisionChangelogRegion
+	for _, t := range transitions {
+		if current == nil || current.Region != t.Region {
+			current = &revisionChangelogRegion{Region: t.Region}
+			changelog.Regions = append(changelog.Regions, current)
+		}
+		current.Transitions = append(current.Transitions, &revisionChangelogTransition{
+			From:  t.From,
+			To:    t.To,
+			Count: t.Count,
+		})
+	}
+	return changelog
+}
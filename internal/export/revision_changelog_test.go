@@ -0,0 +1,285 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	exportdatabase "github.com/google/exposure-notifications-server/internal/export/database"
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	"github.com/google/exposure-notifications-server/internal/project"
+	publishdb "github.com/google/exposure-notifications-server/internal/publish/database"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/exposure-notifications-server/internal/storage"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
+	"github.com/google/exposure-notifications-server/pkg/keys"
+	"github.com/google/exposure-notifications-server/pkg/render"
+	"github.com/google/exposure-notifications-server/pkg/timeutils"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNewRevisionChangelog(t *testing.T) {
+	t.Parallel()
+
+	day := time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)
+	transitions := []*publishmodel.RevisionTransition{
+		{Day: day, Region: "CA", From: "", To: verifyapi.ReportTypeConfirmed, Count: 1},
+		{Day: day, Region: "US", From: verifyapi.ReportTypeClinical, To: verifyapi.ReportTypeConfirmed, Count: 5},
+		{Day: day, Region: "US", From: verifyapi.ReportTypeClinical, To: verifyapi.ReportTypeNegative, Count: 2},
+	}
+
+	want := &revisionChangelog{
+		Day: "2021-03-04",
+		Regions: []*revisionChangelogRegion{
+			{
+				Region: "CA",
+				Transitions: []*revisionChangelogTransition{
+					{From: "", To: verifyapi.ReportTypeConfirmed, Count: 1},
+				},
+			},
+			{
+				Region: "US",
+				Transitions: []*revisionChangelogTransition{
+					{From: verifyapi.ReportTypeClinical, To: verifyapi.ReportTypeConfirmed, Count: 5},
+					{From: verifyapi.ReportTypeClinical, To: verifyapi.ReportTypeNegative, Count: 2},
+				},
+			},
+		},
+	}
+	if diff := cmp.Diff(want, newRevisionChangelog(day, transitions)); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// A day without revisions is an empty list, not null.
+	b, err := json.Marshal(newRevisionChangelog(day, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), `{"day":"2021-03-04","regions":[]}`; got != want {
+		t.Errorf("expected %s to be %s", got, want)
+	}
+}
+
+func TestHandleRevisionChangelog_BadRequest(t *testing.T) {
+	t.Parallel()
+
+	tomorrow := timeutils.UTCMidnight(time.Now()).AddDate(0, 0, 1).Format("2006-01-02")
+	today := timeutils.UTCMidnight(time.Now()).Format("2006-01-02")
+
+	cases := []struct {
+		name   string
+		bucket string
+		query  string
+		status int
+	}{
+		{name: "disabled", query: "", status: http.StatusNotFound},
+		{name: "invalid_day", bucket: "bucket", query: "day=banana", status: http.StatusBadRequest},
+		{name: "today", bucket: "bucket", query: "day=" + today, status: http.StatusBadRequest},
+		{name: "future", bucket: "bucket", query: "day=" + tomorrow, status: http.StatusBadRequest},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := project.TestContext(t)
+			server := &Server{
+				config: &Config{RevisionChangelogBucket: tc.bucket},
+				h:      render.NewRenderer(),
+			}
+
+			r, err := http.NewRequestWithContext(ctx, http.MethodGet, "/revision-changelog?"+tc.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			server.handleRevisionChangelog().ServeHTTP(w, r)
+
+			if got, want := w.Code, tc.status; got != want {
+				t.Errorf("expected status %d to be %d: %s", got, want, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandleRevisionChangelog(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	publishDB := publishdb.New(testDB)
+
+	blobstore, err := storage.NewMemory(ctx, &storage.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := newTestSigner(t, "changelog", "1").Signer.(*ecdsa.PrivateKey)
+	root := t.TempDir()
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "changelog"), der, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	kms, err := keys.NewFilesystem(ctx, &keys.Config{FilesystemRoot: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	si := &model.SignatureInfo{
+		SigningKey:        "changelog",
+		SigningKeyID:      "changelog",
+		SigningKeyVersion: "1",
+	}
+	if err := exportdatabase.New(testDB).AddSignatureInfo(ctx, si); err != nil {
+		t.Fatal(err)
+	}
+
+	// Seed keys and revise some of them two days ago, and one yesterday.
+	day := timeutils.UTCMidnight(time.Now()).AddDate(0, 0, -2)
+	exposures := make([]*publishmodel.Exposure, 0, 4)
+	for _, regions := range [][]string{{"US", "CA"}, {"US"}, {"US"}, {"US"}} {
+		exposures = append(exposures, &publishmodel.Exposure{
+			ExposureKey:     randomTEK(t),
+			Regions:         regions,
+			IntervalNumber:  100,
+			IntervalCount:   144,
+			CreatedAt:       day.Add(-time.Hour),
+			LocalProvenance: true,
+			ReportType:      verifyapi.ReportTypeClinical,
+		})
+	}
+	if _, err := publishDB.InsertAndReviseExposures(ctx, &publishdb.InsertAndReviseExposuresRequest{
+		Incoming: exposures,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for i, revisedAt := range []time.Time{day.Add(time.Hour), day.Add(2 * time.Hour), day.Add(25 * time.Hour)} {
+		revision := *exposures[i]
+		revision.ReportType = verifyapi.ReportTypeConfirmed
+		revision.CreatedAt = revisedAt
+		if _, err := publishDB.InsertAndReviseExposures(ctx, &publishdb.InsertAndReviseExposuresRequest{
+			Incoming: []*publishmodel.Exposure{&revision},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := &Server{
+		config: &Config{
+			RevisionChangelogBucket:          "bucket",
+			RevisionChangelogFilenameRoot:    "revisions",
+			RevisionChangelogSignatureInfoID: si.ID,
+			RevisionChangelogTimeout:         time.Minute,
+		},
+		env: serverenv.New(ctx,
+			serverenv.WithDatabase(testDB),
+			serverenv.WithBlobStorage(blobstore),
+			serverenv.WithKeyManager(kms)),
+		h: render.NewRenderer(),
+	}
+
+	dayStr := day.Format("2006-01-02")
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, "/revision-changelog?day="+dayStr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	server.handleRevisionChangelog().ServeHTTP(w, r)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("expected status %d to be %d: %s", got, want, w.Body.String())
+	}
+
+	name := "revisions/" + dayStr + ".json"
+	sigName := "revisions/" + dayStr + ".sig.json"
+	var result revisionChangelogResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(&revisionChangelogResult{Day: dayStr, Transitions: 2, Objects: []string{name, sigName}}, &result); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	data, err := blobstore.GetObject(ctx, "bucket", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var changelog revisionChangelog
+	if err := json.Unmarshal(data, &changelog); err != nil {
+		t.Fatal(err)
+	}
+	want := &revisionChangelog{
+		Day: dayStr,
+		Regions: []*revisionChangelogRegion{
+			{
+				Region: "CA",
+				Transitions: []*revisionChangelogTransition{
+					{From: verifyapi.ReportTypeClinical, To: verifyapi.ReportTypeConfirmed, Count: 1},
+				},
+			},
+			{
+				Region: "US",
+				Transitions: []*revisionChangelogTransition{
+					{From: verifyapi.ReportTypeClinical, To: verifyapi.ReportTypeConfirmed, Count: 2},
+				},
+			},
+		},
+	}
+	if diff := cmp.Diff(want, &changelog); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// The changelog has no key material.
+	for _, e := range exposures {
+		if bytes.Contains(data, []byte(e.ExposureKeyBase64())) {
+			t.Errorf("expected changelog to not contain exposure key: %s", data)
+		}
+	}
+
+	sigData, err := blobstore.GetObject(ctx, "bucket", sigName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sig revisionChangelogSignature
+	if err := json.Unmarshal(sigData, &sig); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sig.File, name; got != want {
+		t.Errorf("expected signature file %q to be %q", got, want)
+	}
+	raw, err := base64.StdEncoding.DecodeString(sig.Signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(data)
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], raw) {
+		t.Errorf("expected changelog signature to verify")
+	}
+}
@@ -17,20 +17,32 @@ package export
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"time"
 
+	"github.com/google/exposure-notifications-server/internal/httpclient"
 	"github.com/google/exposure-notifications-server/internal/middleware"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
 	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/exposure-notifications-server/internal/statssink"
 	"github.com/google/exposure-notifications-server/pkg/logging"
 	"github.com/google/exposure-notifications-server/pkg/render"
 	"github.com/google/exposure-notifications-server/pkg/server"
 	"github.com/gorilla/mux"
 )
 
+// maxWorkerConcurrency is the upper bound on WorkerConcurrency.
+const maxWorkerConcurrency = 32
+
 // Server hosts end points to manage export batches.
 type Server struct {
-	config *Config
-	env    *serverenv.ServerEnv
-	h      *render.Renderer
+	config    *Config
+	env       *serverenv.ServerEnv
+	h         *render.Renderer
+	statsSink statssink.StatsSink
+
+	regionNormalizer *publishmodel.RegionNormalizer
+	webhookClient    *http.Client
 }
 
 // NewServer makes a Server.
@@ -47,11 +59,60 @@ func NewServer(cfg *Config, env *serverenv.ServerEnv) (*Server, error) {
 	if cfg.MinWindowAge < 0 {
 		return nil, fmt.Errorf("MIN_WINDOW_AGE must be a duration of >= 0")
 	}
+	if cfg.ExportActivationDelay < 0 {
+		return nil, fmt.Errorf("EXPORT_ACTIVATION_DELAY must be a duration of >= 0")
+	}
+	if cfg.WorkerConcurrency > maxWorkerConcurrency {
+		return nil, fmt.Errorf("EXPORT_WORKER_CONCURRENCY must be <= %d", maxWorkerConcurrency)
+	}
+	if err := cfg.MissingRegionPolicy.Validate(); err != nil {
+		return nil, fmt.Errorf("MISSING_REGION_POLICY: %w", err)
+	}
+	regionNormalizer, err := publishmodel.NewRegionNormalizer(cfg.RegionCanonicalMapping)
+	if err != nil {
+		return nil, fmt.Errorf("REGION_CANONICAL_MAPPING: %w", err)
+	}
+	if cfg.WebhookURL != "" {
+		if cfg.WebhookSecret == "" {
+			return nil, fmt.Errorf("EXPORT_WEBHOOK_SECRET is required when EXPORT_WEBHOOK_URL is set")
+		}
+		if cfg.WebhookMaxAttempts < 1 {
+			return nil, fmt.Errorf("EXPORT_WEBHOOK_MAX_ATTEMPTS must be >= 1")
+		}
+		if cfg.WebhookRetryBackoff <= 0 {
+			return nil, fmt.Errorf("EXPORT_WEBHOOK_RETRY_BACKOFF must be a duration of > 0")
+		}
+	}
+
+	for _, window := range cfg.DeltaWindows {
+		if window <= 0 || window%time.Hour != 0 {
+			return nil, fmt.Errorf("EXPORT_DELTA_WINDOWS must be positive whole hours, got %v", window)
+		}
+	}
+
+	if cfg.RevisionChangelogSignatureInfoID < 0 {
+		return nil, fmt.Errorf("EXPORT_REVISION_CHANGELOG_SIGNATURE_INFO_ID cannot be negative")
+	}
+
+	if cfg.IndexMaxEntries < 0 {
+		return nil, fmt.Errorf("EXPORT_INDEX_MAX_ENTRIES cannot be negative")
+	}
+
+	// Default to aggregating stats in the database if no other sink is
+	// configured.
+	statsSink := env.StatsSink()
+	if statsSink == nil {
+		statsSink = statssink.NewPostgres(env.Database())
+	}
 
 	return &Server{
-		config: cfg,
-		env:    env,
-		h:      render.NewRenderer(),
+		config:    cfg,
+		env:       env,
+		h:         render.NewRenderer(),
+		statsSink: statsSink,
+
+		regionNormalizer: regionNormalizer,
+		webhookClient:    httpclient.New(&cfg.HTTPClient, cfg.WebhookTimeout),
 	}, nil
 }
 
@@ -65,9 +126,18 @@ func (s *Server) Routes(ctx context.Context) *mux.Router {
 	r.Use(middleware.PopulateObservability())
 	r.Use(middleware.PopulateLogger(logger))
 
+	// Unknown paths and methods get JSON error bodies.
+	r.NotFoundHandler = server.HandleNotFound()
+	r.MethodNotAllowedHandler = server.HandleMethodNotAllowed()
+
+	// The jobs are triggered by the scheduler with either method.
 	r.Handle("/health", server.HandleHealthz(s.env.Database()))
-	r.Handle("/create-batches", s.handleCreateBatches())
-	r.Handle("/do-work", s.handleDoWork())
+	r.Handle("/create-batches", s.handleCreateBatches()).Methods(http.MethodGet, http.MethodPost)
+	r.Handle("/do-work", s.handleDoWork()).Methods(http.MethodGet, http.MethodPost)
+	r.Handle("/reconcile-index", s.handleReconcileIndex()).Methods(http.MethodGet, http.MethodPost)
+	r.Handle("/backfill-index", s.handleBackfillIndex()).Methods(http.MethodGet, http.MethodPost)
+	r.Handle("/resign-batches", s.handleResignBatches()).Methods(http.MethodGet, http.MethodPost)
+	r.Handle("/revision-changelog", s.handleRevisionChangelog()).Methods(http.MethodGet, http.MethodPost)
 
 	return r
 }
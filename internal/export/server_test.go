@@ -15,12 +15,18 @@
 package export
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/google/exposure-notifications-server/internal/project"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
 	"github.com/google/exposure-notifications-server/internal/serverenv"
 	"github.com/google/exposure-notifications-server/internal/storage"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
 	"github.com/google/exposure-notifications-server/pkg/database"
 	"github.com/google/exposure-notifications-server/pkg/keys"
 )
@@ -36,6 +42,7 @@ func TestNewServer(t *testing.T) {
 
 	testCases := []struct {
 		name string
+		cfg  *Config
 		env  *serverenv.ServerEnv
 		err  error
 	}{
@@ -68,6 +75,52 @@ func TestNewServer(t *testing.T) {
 			),
 			err: nil,
 		},
+		{
+			name: "too much concurrency",
+			cfg:  &Config{WorkerConcurrency: maxWorkerConcurrency + 1},
+			env: serverenv.New(ctx,
+				serverenv.WithBlobStorage(emptyStorage),
+				serverenv.WithDatabase(emptyDB),
+				serverenv.WithKeyManager(emptyKMS),
+			),
+			err: fmt.Errorf("EXPORT_WORKER_CONCURRENCY must be <= %d", maxWorkerConcurrency),
+		},
+		{
+			name: "invalid missing region policy",
+			cfg:  &Config{MissingRegionPolicy: "banana"},
+			env: serverenv.New(ctx,
+				serverenv.WithBlobStorage(emptyStorage),
+				serverenv.WithDatabase(emptyDB),
+				serverenv.WithKeyManager(emptyKMS),
+			),
+			err: fmt.Errorf(`MISSING_REGION_POLICY: unknown missing region policy "banana", must be "default" or "reject"`),
+		},
+		{
+			name: "invalid delta window",
+			cfg: &Config{
+				MissingRegionPolicy: publishmodel.MissingRegionDefault,
+				DeltaWindows:        []time.Duration{24 * time.Hour, 90 * time.Minute},
+			},
+			env: serverenv.New(ctx,
+				serverenv.WithBlobStorage(emptyStorage),
+				serverenv.WithDatabase(emptyDB),
+				serverenv.WithKeyManager(emptyKMS),
+			),
+			err: fmt.Errorf("EXPORT_DELTA_WINDOWS must be positive whole hours, got 1h30m0s"),
+		},
+		{
+			name: "negative index max entries",
+			cfg: &Config{
+				MissingRegionPolicy: publishmodel.MissingRegionDefault,
+				IndexMaxEntries:     -1,
+			},
+			env: serverenv.New(ctx,
+				serverenv.WithBlobStorage(emptyStorage),
+				serverenv.WithDatabase(emptyDB),
+				serverenv.WithKeyManager(emptyKMS),
+			),
+			err: fmt.Errorf("EXPORT_INDEX_MAX_ENTRIES cannot be negative"),
+		},
 	}
 
 	for _, tc := range testCases {
@@ -76,7 +129,12 @@ func TestNewServer(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 
-			got, err := NewServer(&Config{}, tc.env)
+			cfg := tc.cfg
+			if cfg == nil {
+				cfg = &Config{MissingRegionPolicy: publishmodel.MissingRegionDefault}
+			}
+
+			got, err := NewServer(cfg, tc.env)
 			if tc.err != nil {
 				if err.Error() != tc.err.Error() {
 					t.Fatalf("got %+v: want %v", err, tc.err)
@@ -89,3 +147,56 @@ func TestNewServer(t *testing.T) {
 		})
 	}
 }
+
+func TestRoutes_Errors(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	s := &Server{config: &Config{}, env: serverenv.New(ctx)}
+	r := s.Routes(ctx)
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		code   string
+	}{
+		{
+			name:   "unknown_path",
+			method: http.MethodGet,
+			path:   "/nope",
+			status: http.StatusNotFound,
+			code:   verifyapi.ErrorNotFound,
+		},
+		{
+			name:   "wrong_method",
+			method: http.MethodDelete,
+			path:   "/do-work",
+			status: http.StatusMethodNotAllowed,
+			code:   verifyapi.ErrorMethodNotAllowed,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+
+			if got, want := w.Code, tc.status; got != want {
+				t.Fatalf("expected status %d to be %d", got, want)
+			}
+			var got verifyapi.ErrorResponse
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := got.Code, tc.code; got != want {
+				t.Errorf("expected code %q to be %q", got, want)
+			}
+		})
+	}
+}
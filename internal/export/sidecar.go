@@ -0,0 +1,130 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/google/exposure-notifications-server/internal/storage"
+)
+
+const (
+	sidecarSuffix        = ".sig.json"
+	sidecarIndexFilename = "signatures.txt"
+)
+
+// signatureSidecar describes the signatures in the export.sig of an export
+// file, for tooling that doesn't parse the TEKSignatureList.
+type signatureSidecar struct {
+	// File is the name of the export file.
+	File       string              `json:"file"`
+	Signatures []*sidecarSignature `json:"signatures"`
+}
+
+type sidecarSignature struct {
+	// Algorithm is the OID of the signature algorithm.
+	Algorithm  string `json:"algorithm"`
+	KeyID      string `json:"keyID,omitempty"`
+	KeyVersion string `json:"keyVersion,omitempty"`
+	// KeyResource is the key manager resource of the signing key, if the
+	// signature was made by one of the export's current signers.
+	KeyResource string `json:"keyResource,omitempty"`
+	BatchNum    int32  `json:"batchNum"`
+	BatchSize   int32  `json:"batchSize"`
+	// Signature is the base64 encoded signature.
+	Signature string `json:"signature"`
+}
+
+// sidecarFilename returns the name of the signature sidecar of an export file,
+// e.g. "root/1-2-00001.sig.json" for "root/1-2-00001.zip".
+func sidecarFilename(objectName string) string {
+	return strings.TrimSuffix(objectName, filenameSuffix) + sidecarSuffix
+}
+
+// sidecarIndexObjectName returns the name of the index of the sidecars next to
+// an index file.
+func sidecarIndexObjectName(indexObjectName string) string {
+	return path.Join(path.Dir(indexObjectName), sidecarIndexFilename)
+}
+
+// newSignatureSidecar describes the signatures of the export file. They are
+// read from the file itself, so the sidecar always matches the signatures a
+// client verifies.
+func newSignatureSidecar(objectName string, data []byte, signers []*Signer) (*signatureSidecar, error) {
+	sigs, err := UnmarshalSignatureFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("reading signatures: %w", err)
+	}
+
+	sidecar := &signatureSidecar{
+		File:       objectName,
+		Signatures: make([]*sidecarSignature, 0, len(sigs.Signatures)),
+	}
+	for _, sig := range sigs.Signatures {
+		info := sig.GetSignatureInfo()
+		entry := &sidecarSignature{
+			Algorithm:  info.GetSignatureAlgorithm(),
+			KeyID:      info.GetVerificationKeyId(),
+			KeyVersion: info.GetVerificationKeyVersion(),
+			BatchNum:   sig.GetBatchNum(),
+			BatchSize:  sig.GetBatchSize(),
+			Signature:  base64.StdEncoding.EncodeToString(sig.GetSignature()),
+		}
+		for _, signer := range signers {
+			if signer.SignatureInfo.SigningKeyID == entry.KeyID && signer.SignatureInfo.SigningKeyVersion == entry.KeyVersion {
+				entry.KeyResource = signer.SignatureInfo.SigningKey
+				break
+			}
+		}
+		sidecar.Signatures = append(sidecar.Signatures, entry)
+	}
+	return sidecar, nil
+}
+
+// writeSignatureSidecar writes the signature sidecar of the export file, if
+// sidecars are enabled.
+func (s *Server) writeSignatureSidecar(ctx context.Context, bucketName, objectName string, data []byte, signers []*Signer, attrs *storage.ObjectAttrs) error {
+	if !s.config.SignatureSidecar {
+		return nil
+	}
+
+	sidecar, err := newSignatureSidecar(objectName, data, signers)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(sidecar)
+	if err != nil {
+		return fmt.Errorf("marshaling signature sidecar: %w", err)
+	}
+
+	// The sidecar is cached like the export file.
+	sidecarAttrs := storage.DefaultObjectAttrs(true, storage.ContentTypeJSON)
+	if attrs != nil && attrs.CacheControl != "" {
+		sidecarAttrs.CacheControl = attrs.CacheControl
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, blobOperationTimeout)
+	defer cancel()
+	name := sidecarFilename(objectName)
+	if err := s.env.Blobstore().CreateObjectWithAttrs(ctx, bucketName, name, b, sidecarAttrs); err != nil {
+		return fmt.Errorf("creating signature sidecar %s in bucket %s: %w", name, bucketName, err)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	"github.com/google/exposure-notifications-server/internal/project"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/exposure-notifications-server/internal/storage"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSidecarFilename(t *testing.T) {
+	t.Parallel()
+
+	if got, want := sidecarFilename("root/1-2-00001.zip"), "root/1-2-00001.sig.json"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+	if got, want := sidecarIndexObjectName("root/index.txt"), "root/signatures.txt"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+}
+
+func TestWriteSignatureSidecar(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	blobstore, err := storage.NewMemory(ctx, &storage.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		config: &Config{SignatureSidecar: true},
+		env:    serverenv.New(ctx, serverenv.WithBlobStorage(blobstore)),
+	}
+
+	signers := []*Signer{
+		newTestSigner(t, "310", "v1"),
+		newTestSigner(t, "311", "v2"),
+	}
+	signers[0].SignatureInfo.SigningKey = "projects/p/locations/l/keyRings/r/cryptoKeys/k1/cryptoKeyVersions/1"
+	signers[1].SignatureInfo.SigningKey = "projects/p/locations/l/keyRings/r/cryptoKeys/k2/cryptoKeyVersions/1"
+
+	now := time.Now().UTC().Truncate(time.Hour)
+	eb := &model.ExportBatch{
+		BatchID:        1,
+		BucketName:     "bucket",
+		FilenameRoot:   "root",
+		StartTimestamp: now.Add(-time.Hour),
+		EndTimestamp:   now,
+		OutputRegion:   "US",
+	}
+	exposures := []*publishmodel.Exposure{
+		{
+			ExposureKey:    randomTEK(t),
+			IntervalNumber: 100,
+			IntervalCount:  144,
+		},
+	}
+	data, err := MarshalExportFile(eb, exposures, nil, 1, false, signers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objectName := exportFilename(eb, 1, 0)
+	if _, err := s.writeExportFile(ctx, eb, objectName, data, len(exposures), signers, storage.DefaultObjectAttrs(true, storage.ContentTypeZip)); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := blobstore.GetObject(ctx, eb.BucketName, sidecarFilename(objectName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sidecar signatureSidecar
+	if err := json.Unmarshal(b, &sidecar); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sidecar.File, objectName; got != want {
+		t.Errorf("expected file %q to be %q", got, want)
+	}
+
+	// The sidecar matches the signatures in the export file.
+	sigs, err := UnmarshalSignatureFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make([]*sidecarSignature, 0, len(sigs.Signatures))
+	for i, sig := range sigs.Signatures {
+		want = append(want, &sidecarSignature{
+			Algorithm:   algorithm,
+			KeyID:       signers[i].SignatureInfo.SigningKeyID,
+			KeyVersion:  signers[i].SignatureInfo.SigningKeyVersion,
+			KeyResource: signers[i].SignatureInfo.SigningKey,
+			BatchNum:    1,
+			BatchSize:   1,
+			Signature:   base64.StdEncoding.EncodeToString(sig.GetSignature()),
+		})
+	}
+	if diff := cmp.Diff(want, sidecar.Signatures); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// The signatures verify the export contents.
+	export, _, err := UnmarshalExportFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := readTestZipFile(t, data, exportBinaryName)
+	digest := sha256.Sum256(contents)
+	for i, entry := range sidecar.Signatures {
+		sig, err := base64.StdEncoding.DecodeString(entry.Signature)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pub := signers[i].Signer.Public().(*ecdsa.PublicKey)
+		if !ecdsa.VerifyASN1(pub, digest[:], sig) {
+			t.Errorf("signature %d of %s does not verify", i, entry.KeyID)
+		}
+	}
+	if got, want := len(export.GetSignatureInfos()), len(sidecar.Signatures); got != want {
+		t.Errorf("expected %d signature infos, got %d", want, got)
+	}
+
+	// The sidecars are listed next to the index.
+	if err := s.writeIndex(ctx, eb.BucketName, exportIndexFilename(eb), []string{objectName}, storage.DefaultObjectAttrs(false, storage.ContentTypeTextPlain)); err != nil {
+		t.Fatal(err)
+	}
+	index, err := s.readIndex(ctx, eb.BucketName, "root/signatures.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{sidecarFilename(objectName)}, index); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestWriteSignatureSidecar_Disabled(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	blobstore, err := storage.NewMemory(ctx, &storage.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		config: &Config{},
+		env:    serverenv.New(ctx, serverenv.WithBlobStorage(blobstore)),
+	}
+
+	eb := &model.ExportBatch{BucketName: "bucket", FilenameRoot: "root"}
+	if _, err := s.writeExportFile(ctx, eb, "root/1-2-00001.zip", []byte("not a zip"), 0, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blobstore.GetObject(ctx, "bucket", "root/1-2-00001.sig.json"); err == nil {
+		t.Errorf("expected no sidecar to be written")
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/exposure-notifications-server/pkg/logging"
+	"github.com/sethvargo/go-retry"
+	"go.opencensus.io/stats"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// retryingSigner retries sign calls that the key manager throttled, with
+// exponential backoff and jitter. Other errors are returned immediately.
+//
+// crypto.Signer doesn't take a context, so the signer holds the context of the
+// batch it signs for.
+type retryingSigner struct {
+	ctx    context.Context
+	signer crypto.Signer
+
+	maxAttempts   uint
+	backoff       time.Duration
+	maxBackoff    time.Duration
+	jitterPercent uint64
+}
+
+// retryingSigner wraps the key manager signer with the configured sign retries,
+// unless retries are disabled.
+func (s *Server) retryingSigner(ctx context.Context, signer crypto.Signer) crypto.Signer {
+	if s.config.SignMaxAttempts <= 1 {
+		return signer
+	}
+	return &retryingSigner{
+		ctx:           ctx,
+		signer:        signer,
+		maxAttempts:   s.config.SignMaxAttempts,
+		backoff:       s.config.SignRetryBackoff,
+		maxBackoff:    s.config.SignRetryMaxBackoff,
+		jitterPercent: s.config.SignRetryJitterPercent,
+	}
+}
+
+func (r *retryingSigner) Public() crypto.PublicKey {
+	return r.signer.Public()
+}
+
+func (r *retryingSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	b, err := r.newBackoff()
+	if err != nil {
+		return nil, err
+	}
+
+	var sig []byte
+	var attempts uint
+	var throttled error
+	if err := retry.Do(r.ctx, b, func(ctx context.Context) error {
+		attempts++
+		var err error
+		sig, err = r.signer.Sign(rand, digest, opts)
+		if err == nil {
+			return nil
+		}
+		if !isThrottled(err) {
+			return err
+		}
+
+		throttled = err
+		if attempts < r.maxAttempts {
+			logging.FromContext(ctx).Warnw("key manager throttled sign call, retrying", "attempt", attempts, "error", err)
+			stats.Record(ctx, mSignRetries.M(1))
+		}
+		return retry.RetryableError(err)
+	}); err != nil {
+		if throttled != nil && errors.Is(err, throttled) {
+			return nil, fmt.Errorf("key manager throttled %d sign attempts: %w", attempts, throttled)
+		}
+		return nil, err
+	}
+	return sig, nil
+}
+
+// newBackoff returns the backoff for a single signature. Backoffs keep state, so
+// they can't be shared between calls.
+func (r *retryingSigner) newBackoff() (retry.Backoff, error) {
+	b, err := retry.NewExponential(r.backoff)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sign retry backoff: %w", err)
+	}
+	if r.jitterPercent > 0 {
+		b = retry.WithJitterPercent(r.jitterPercent, b)
+	}
+	if r.maxBackoff > 0 {
+		b = retry.WithCappedDuration(r.maxBackoff, b)
+	}
+	return retry.WithMaxRetries(uint64(r.maxAttempts-1), b), nil
+}
+
+// isThrottled returns true if the key manager rejected the call because of
+// quota or load, which is worth retrying after a backoff. The errors of the
+// key managers are usually wrapped, so the gRPC status is looked up in the
+// chain.
+func isThrottled(err error) bool {
+	var grpcErr interface {
+		GRPCStatus() *grpcstatus.Status
+	}
+	if !errors.As(err, &grpcErr) {
+		return false
+	}
+
+	switch grpcErr.GRPCStatus().Code() {
+	case grpccodes.ResourceExhausted, grpccodes.Unavailable:
+		return true
+	default:
+		return false
+	}
+}
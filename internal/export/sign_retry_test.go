@@ -0,0 +1,148 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"crypto"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/exposure-notifications-server/pkg/errcmp"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// throttlingSigner fails the first failures calls with err, then signs.
+type throttlingSigner struct {
+	failures int
+	err      error
+	calls    int
+}
+
+func (s *throttlingSigner) Public() crypto.PublicKey { return nil }
+
+func (s *throttlingSigner) Sign(_ io.Reader, digest []byte, _ crypto.SignerOpts) ([]byte, error) {
+	s.calls++
+	if s.calls <= s.failures {
+		// Wrapped the same way as the Cloud KMS signer.
+		return nil, fmt.Errorf("failed to sign: %w", s.err)
+	}
+	return []byte("signature"), nil
+}
+
+func TestRetryingSigner(t *testing.T) {
+	t.Parallel()
+
+	throttled := grpcstatus.Error(grpccodes.ResourceExhausted, "quota exceeded")
+	permanent := grpcstatus.Error(grpccodes.PermissionDenied, "permission denied")
+
+	cases := []struct {
+		name        string
+		maxAttempts uint
+		failures    int
+		err         error
+		wantCalls   int
+		wantErr     string
+	}{
+		{
+			name:        "success",
+			maxAttempts: 5,
+			wantCalls:   1,
+		},
+		{
+			name:        "throttled_then_success",
+			maxAttempts: 5,
+			failures:    3,
+			err:         throttled,
+			wantCalls:   4,
+		},
+		{
+			name:        "unavailable_then_success",
+			maxAttempts: 5,
+			failures:    1,
+			err:         grpcstatus.Error(grpccodes.Unavailable, "unavailable"),
+			wantCalls:   2,
+		},
+		{
+			name:        "throttled_max_attempts",
+			maxAttempts: 3,
+			failures:    10,
+			err:         throttled,
+			wantCalls:   3,
+			wantErr:     "key manager throttled 3 sign attempts: failed to sign: rpc error: code = ResourceExhausted desc = quota exceeded",
+		},
+		{
+			name:        "permanent",
+			maxAttempts: 5,
+			failures:    10,
+			err:         permanent,
+			wantCalls:   1,
+			wantErr:     "failed to sign: rpc error: code = PermissionDenied desc = permission denied",
+		},
+		{
+			name:        "not_grpc",
+			maxAttempts: 5,
+			failures:    10,
+			err:         errors.New("bad digest"),
+			wantCalls:   1,
+			wantErr:     "failed to sign: bad digest",
+		},
+		{
+			name:        "retries_disabled",
+			maxAttempts: 1,
+			failures:    1,
+			err:         throttled,
+			wantCalls:   1,
+			wantErr:     "failed to sign: rpc error: code = ResourceExhausted desc = quota exceeded",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := project.TestContext(t)
+			s := &Server{
+				config: &Config{
+					SignMaxAttempts:        tc.maxAttempts,
+					SignRetryBackoff:       time.Millisecond,
+					SignRetryMaxBackoff:    5 * time.Millisecond,
+					SignRetryJitterPercent: 20,
+				},
+			}
+
+			fake := &throttlingSigner{failures: tc.failures, err: tc.err}
+			sig, err := s.retryingSigner(ctx, fake).Sign(rand.Reader, []byte("digest"), crypto.SHA256)
+			errcmp.MustMatch(t, err, tc.wantErr)
+
+			if got, want := fake.calls, tc.wantCalls; got != want {
+				t.Errorf("expected %d sign calls, got %d", want, got)
+			}
+			if tc.wantErr == "" && string(sig) != "signature" {
+				t.Errorf("expected signature, got %q", sig)
+			}
+			if tc.wantErr != "" && sig != nil {
+				t.Errorf("expected no signature, got %q", sig)
+			}
+		})
+	}
+}
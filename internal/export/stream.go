@@ -0,0 +1,307 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	publishdatabase "github.com/google/exposure-notifications-server/internal/publish/database"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/storage"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1alpha1"
+	"github.com/google/exposure-notifications-server/pkg/logging"
+	"go.opencensus.io/stats"
+)
+
+// keyCounts are the number of keys of a batch, counted before the keys are
+// written.
+type keyCounts struct {
+	keys         int
+	revisedKeys  int
+	droppedKeys  int
+	maxCreatedAt time.Time
+}
+
+// countExposures counts the selected new and revised keys that match the
+// criteria. If content isn't nil, the keys are also added to it.
+func (s *Server) countExposures(ctx context.Context, criteria publishdatabase.IterateExposuresCriteria, sel keySelection, content *contentHasher) (*keyCounts, error) {
+	publishDB := publishdatabase.New(s.env.Database())
+
+	counts := &keyCounts{}
+	if sel.newKeys {
+		if _, err := publishDB.IterateExposures(ctx, criteria, func(exp *publishmodel.Exposure) error {
+			if len(exp.ExposureKey) != verifyapi.KeyLength {
+				counts.droppedKeys++
+				return nil
+			}
+			// see if assigned time for generated data should be moved up.
+			if exp.CreatedAt.After(counts.maxCreatedAt) {
+				counts.maxCreatedAt = exp.CreatedAt
+			}
+			counts.keys++
+			if content != nil {
+				return content.add(exp, false)
+			}
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("counting exposures: %w", err)
+		}
+	}
+
+	if sel.revisedKeys {
+		criteria.OnlyRevisedKeys = true
+		if _, err := publishDB.IterateExposures(ctx, criteria, func(exp *publishmodel.Exposure) error {
+			if len(exp.ExposureKey) != verifyapi.KeyLength {
+				counts.droppedKeys++
+				return nil
+			}
+			counts.revisedKeys++
+			if content != nil {
+				return content.add(exp, true)
+			}
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("counting revised exposures: %w", err)
+		}
+	}
+	return counts, nil
+}
+
+// streamExportFiles writes the export files of the batch as the keys are read
+// from the database. The keys are read in key order, so only the file being
+// written is held in memory.
+//
+// The files are the same as with bufferExportFiles: the new keys and then the
+// revised keys, split into files after maxRecords keys. The keys are counted
+// first, since the number of files is part of each file, and the last file is
+// padded as a whole if there are too few keys.
+func (s *Server) streamExportFiles(ctx context.Context, eb *model.ExportBatch, criteria publishdatabase.IterateExposuresCriteria, maxRecords int, sigInfos []*model.SignatureInfo, attrs *storage.ObjectAttrs) (*exportFiles, error) {
+	logger := logging.FromContext(ctx)
+
+	sel := batchKeySelection(eb)
+	content := newContentHasher(eb)
+	counts, err := s.countExposures(ctx, criteria, sel, content)
+	if err != nil {
+		return nil, fmt.Errorf("reading exposures for batch: %w", err)
+	}
+	if counts.droppedKeys > 0 {
+		logger.Errorw("export found keys of invalid length", "dropped_keys", counts.droppedKeys)
+		stats.Record(ctx, mWorkerBadKeyLength.M(int64(counts.droppedKeys)))
+	}
+
+	// The content hash is computed before the batch is padded, since padding
+	// inserts random keys.
+	contentHash, err := content.hash(eb, sigInfos)
+	if err != nil {
+		return nil, err
+	}
+	total := counts.keys + counts.revisedKeys
+	numFiles := (total + maxRecords - 1) / maxRecords
+	if numFiles == 0 {
+		logger.Infof("No records for export batch")
+		return &exportFiles{contentHash: contentHash}, nil
+	}
+
+	signers, err := s.signers(ctx, sigInfos)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &fileStreamer{
+		s:          s,
+		eb:         eb,
+		signers:    signers,
+		attrs:      attrs,
+		maxRecords: maxRecords,
+		splitBatch: numFiles > 1,
+		files: &exportFiles{
+			objectNames:    make([]string, 0, numFiles),
+			digests:        make([]string, 0, numFiles),
+			numKeys:        counts.keys,
+			numRevisedKeys: counts.revisedKeys,
+			contentHash:    contentHash,
+		},
+	}
+
+	// If there are too few keys, the new keys of the last file are held back
+	// and padded before they are written. There are fewer than MinRecords of
+	// them.
+	padFrom := counts.keys
+	if counts.keys < s.config.MinRecords {
+		padFrom = (numFiles - 1) * maxRecords
+	}
+
+	criteria.OrderByKey = true
+	publishDB := publishdatabase.New(s.env.Database())
+
+	var held []*publishmodel.Exposure
+	var n int
+	if sel.newKeys {
+		if _, err := publishDB.IterateExposures(ctx, criteria, func(exp *publishmodel.Exposure) error {
+			if len(exp.ExposureKey) != verifyapi.KeyLength {
+				return nil
+			}
+			if n++; n > counts.keys {
+				return errKeysChanged
+			}
+			if n > padFrom {
+				held = append(held, exp)
+				return nil
+			}
+			return fs.add(ctx, exp, false)
+		}); err != nil {
+			return nil, fmt.Errorf("iterating exposures: %w", err)
+		}
+	}
+	if n != counts.keys {
+		return nil, errKeysChanged
+	}
+
+	if len(held) > 0 {
+		padded, generated, err := ensureMinNumExposures(held, eb.OutputRegion, s.config.MinRecords, s.config.PaddingRange, maxRecords, counts.maxCreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("ensureMinNumExposures: %w", err)
+		}
+		if err := s.insertGenerated(ctx, generated); err != nil {
+			return nil, err
+		}
+		// Only the original keys count towards the size of the file.
+		sortExposures(padded)
+		for _, exp := range padded {
+			if err := fs.write(ctx, exp, false); err != nil {
+				return nil, err
+			}
+		}
+		if err := fs.count(ctx, len(held)); err != nil {
+			return nil, err
+		}
+	}
+
+	n = 0
+	if sel.revisedKeys {
+		criteria.OnlyRevisedKeys = true
+		if _, err := publishDB.IterateExposures(ctx, criteria, func(exp *publishmodel.Exposure) error {
+			if len(exp.ExposureKey) != verifyapi.KeyLength {
+				return nil
+			}
+			if n++; n > counts.revisedKeys {
+				return errKeysChanged
+			}
+			return fs.add(ctx, exp, true)
+		}); err != nil {
+			return nil, fmt.Errorf("iterating revised exposures: %w", err)
+		}
+	}
+	if n != counts.revisedKeys {
+		return nil, errKeysChanged
+	}
+
+	// The last file may not be full.
+	if err := fs.flush(ctx); err != nil {
+		return nil, err
+	}
+	return fs.files, nil
+}
+
+// errKeysChanged is returned if the keys of a batch change between counting
+// and writing them. The batch is retried.
+var errKeysChanged = errors.New("exposures changed while writing export files")
+
+// fileStreamer writes keys to export files, starting a new file after
+// maxRecords keys.
+type fileStreamer struct {
+	s          *Server
+	eb         *model.ExportBatch
+	signers    []*Signer
+	attrs      *storage.ObjectAttrs
+	maxRecords int
+	splitBatch bool
+
+	files *exportFiles
+
+	// The file being written, if any.
+	buf     bytes.Buffer
+	w       *exportFileWriter
+	fileNum int32
+	keys    int
+}
+
+// add writes the key and counts it towards the size of the file.
+func (fs *fileStreamer) add(ctx context.Context, exp *publishmodel.Exposure, revised bool) error {
+	if err := fs.write(ctx, exp, revised); err != nil {
+		return err
+	}
+	return fs.count(ctx, 1)
+}
+
+// write writes the key to the current file, starting a new file if needed.
+func (fs *fileStreamer) write(ctx context.Context, exp *publishmodel.Exposure, revised bool) error {
+	if fs.w == nil {
+		if ctx.Err() != nil {
+			return errBatchTimeout
+		}
+
+		fs.fileNum++
+		fs.buf.Reset()
+		w, err := newExportFileWriter(&fs.buf, fs.eb, fs.fileNum, fs.splitBatch, fs.signers)
+		if err != nil {
+			return fmt.Errorf("creating export file %d for batch %d: %w", fs.fileNum, fs.eb.BatchID, err)
+		}
+		fs.w = w
+	}
+
+	if revised {
+		return fs.w.WriteRevisedKey(exp)
+	}
+	return fs.w.WriteKey(exp)
+}
+
+// count adds n keys to the size of the current file, and completes the file
+// when it is full.
+func (fs *fileStreamer) count(ctx context.Context, n int) error {
+	fs.keys += n
+	if fs.keys < fs.maxRecords {
+		return nil
+	}
+	return fs.flush(ctx)
+}
+
+// flush completes and writes the current file, if any.
+func (fs *fileStreamer) flush(ctx context.Context) error {
+	if fs.w == nil {
+		return nil
+	}
+
+	if err := fs.w.Close(); err != nil {
+		return fmt.Errorf("creating export file %d for batch %d: %w", fs.fileNum, fs.eb.BatchID, err)
+	}
+	objectName := exportFilename(fs.eb, fs.fileNum, fs.s.config.RepressGeneration())
+	digest, err := fs.s.writeExportFile(ctx, fs.eb, objectName, fs.buf.Bytes(), fs.keys, fs.signers, fs.attrs)
+	if err != nil {
+		return fmt.Errorf("creating export file %d for batch %d: %w", fs.fileNum, fs.eb.BatchID, err)
+	}
+	logging.FromContext(ctx).Infof("Wrote export file %q for batch %d, signed with %v keys", objectName, fs.eb.BatchID, len(fs.signers))
+
+	fs.files.objectNames = append(fs.files.objectNames, objectName)
+	fs.files.digests = append(fs.files.digests, digest)
+	fs.w = nil
+	fs.keys = 0
+	return nil
+}
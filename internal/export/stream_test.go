@@ -0,0 +1,322 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	"github.com/google/exposure-notifications-server/internal/project"
+	publishdb "github.com/google/exposure-notifications-server/internal/publish/database"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/exposure-notifications-server/internal/storage"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1alpha1"
+	"github.com/google/exposure-notifications-server/pkg/database"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStreamExportFiles(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name       string
+		maxRecords int
+		wantFiles  int
+	}{
+		{
+			name:       "single_file",
+			maxRecords: 100,
+			wantFiles:  1,
+		},
+		{
+			// The last file has new and revised keys.
+			name:       "split_batch",
+			maxRecords: 7,
+			wantFiles:  5,
+		},
+		{
+			name:       "split_batch_exact",
+			maxRecords: 10,
+			wantFiles:  3,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := project.TestContext(t)
+			testDB, _ := testDatabaseInstance.NewDatabase(t)
+
+			eb, criteria := insertStreamTestExposures(ctx, t, testDB, 20, 10)
+
+			buffered := newStreamTestServer(ctx, t, testDB, false)
+			streamed := newStreamTestServer(ctx, t, testDB, true)
+
+			attrs := storage.DefaultObjectAttrs(true, storage.ContentTypeZip)
+			wantFiles, err := buffered.bufferExportFiles(ctx, eb, criteria, tc.maxRecords, nil, attrs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotFiles, err := streamed.streamExportFiles(ctx, eb, criteria, tc.maxRecords, nil, attrs)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(wantFiles, gotFiles, cmp.AllowUnexported(exportFiles{})); diff != "" {
+				t.Fatalf("mismatch (-want, +got):\n%s", diff)
+			}
+			if got, want := len(gotFiles.objectNames), tc.wantFiles; got != want {
+				t.Errorf("expected %d files, got %d", want, got)
+			}
+
+			for _, name := range gotFiles.objectNames {
+				want, err := buffered.env.Blobstore().GetObject(ctx, eb.BucketName, name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := streamed.env.Blobstore().GetObject(ctx, eb.BucketName, name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("expected streamed file %q to match the buffered file", name)
+				}
+			}
+		})
+	}
+}
+
+func TestStreamExportFiles_Padding(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+
+	eb, criteria := insertStreamTestExposures(ctx, t, testDB, 5, 2)
+
+	server := newStreamTestServer(ctx, t, testDB, true)
+	server.config.MinRecords = 20
+
+	files, err := server.streamExportFiles(ctx, eb, criteria, 100, nil, storage.DefaultObjectAttrs(true, storage.ContentTypeZip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(files.objectNames), 1; got != want {
+		t.Fatalf("expected %d files, got %d", want, got)
+	}
+
+	data, err := server.env.Blobstore().GetObject(ctx, eb.BucketName, files.objectNames[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	export, _, err := UnmarshalExportFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(export.Keys), 20; got != want {
+		t.Errorf("expected %d keys, got %d", want, got)
+	}
+	if got, want := len(export.RevisedKeys), 2; got != want {
+		t.Errorf("expected %d revised keys, got %d", want, got)
+	}
+
+	// The generated keys are saved.
+	counts, err := server.countExposures(ctx, criteria, allKeys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := counts.keys, 20; got != want {
+		t.Errorf("expected %d keys to be saved, got %d", want, got)
+	}
+}
+
+func TestExportFiles_RevisedKeys(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name            string
+		stream          bool
+		onlyRevised     bool
+		excludeRevised  bool
+		wantKeys        int
+		wantRevisedKeys int
+	}{
+		{
+			name:            "all_keys",
+			wantKeys:        10,
+			wantRevisedKeys: 2,
+		},
+		{
+			// The revisions stream is not padded.
+			name:            "only_revised",
+			onlyRevised:     true,
+			wantKeys:        0,
+			wantRevisedKeys: 2,
+		},
+		{
+			name:            "exclude_revised",
+			excludeRevised:  true,
+			wantKeys:        10,
+			wantRevisedKeys: 0,
+		},
+		{
+			name:            "only_revised_stream",
+			stream:          true,
+			onlyRevised:     true,
+			wantKeys:        0,
+			wantRevisedKeys: 2,
+		},
+		{
+			name:            "exclude_revised_stream",
+			stream:          true,
+			excludeRevised:  true,
+			wantKeys:        10,
+			wantRevisedKeys: 0,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := project.TestContext(t)
+			testDB, _ := testDatabaseInstance.NewDatabase(t)
+
+			eb, criteria := insertStreamTestExposures(ctx, t, testDB, 5, 2)
+			eb.OnlyRevisedKeys = tc.onlyRevised
+			eb.ExcludeRevisedKeys = tc.excludeRevised
+
+			server := newStreamTestServer(ctx, t, testDB, tc.stream)
+			server.config.MinRecords = 10
+
+			attrs := storage.DefaultObjectAttrs(true, storage.ContentTypeZip)
+			write := server.bufferExportFiles
+			if tc.stream {
+				write = server.streamExportFiles
+			}
+			files, err := write(ctx, eb, criteria, 100, nil, attrs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := len(files.objectNames), 1; got != want {
+				t.Fatalf("expected %d files, got %d", want, got)
+			}
+
+			data, err := server.env.Blobstore().GetObject(ctx, eb.BucketName, files.objectNames[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			export, _, err := UnmarshalExportFile(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := len(export.Keys), tc.wantKeys; got != want {
+				t.Errorf("expected %d keys, got %d", want, got)
+			}
+			if got, want := len(export.RevisedKeys), tc.wantRevisedKeys; got != want {
+				t.Errorf("expected %d revised keys, got %d", want, got)
+			}
+		})
+	}
+}
+
+func newStreamTestServer(ctx context.Context, tb testing.TB, db *database.DB, stream bool) *Server {
+	tb.Helper()
+
+	blobstore, err := storage.NewMemory(ctx, &storage.Config{})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return &Server{
+		config: &Config{
+			MaxInsertBatchSize: 100,
+			StreamExportFiles:  stream,
+		},
+		env: serverenv.New(ctx,
+			serverenv.WithDatabase(db),
+			serverenv.WithBlobStorage(blobstore)),
+	}
+}
+
+// insertStreamTestExposures inserts new keys and revises some of them, and
+// returns a batch and criteria that include all of them.
+func insertStreamTestExposures(ctx context.Context, tb testing.TB, db *database.DB, numKeys, numRevised int) (*model.ExportBatch, publishdb.IterateExposuresCriteria) {
+	tb.Helper()
+
+	baseTime := time.Now().UTC().Add(-48 * time.Hour).Truncate(time.Hour)
+	publishDB := publishdb.New(db)
+
+	exposures := make([]*publishmodel.Exposure, 0, numKeys)
+	for i := 0; i < numKeys; i++ {
+		exposures = append(exposures, &publishmodel.Exposure{
+			ExposureKey:     randomTEK(tb),
+			Regions:         []string{"US"},
+			IntervalNumber:  100,
+			IntervalCount:   144,
+			CreatedAt:       baseTime.Add(time.Minute),
+			LocalProvenance: true,
+			ReportType:      verifyapi.ReportTypeClinical,
+		})
+	}
+	if _, err := publishDB.InsertAndReviseExposures(ctx, &publishdb.InsertAndReviseExposuresRequest{
+		Incoming: exposures,
+	}); err != nil {
+		tb.Fatalf("inserting exposures: %v", err)
+	}
+
+	revisions := make([]*publishmodel.Exposure, 0, numRevised)
+	for _, exp := range exposures[:numRevised] {
+		revisions = append(revisions, &publishmodel.Exposure{
+			ExposureKey:     exp.ExposureKey,
+			Regions:         exp.Regions,
+			IntervalNumber:  exp.IntervalNumber,
+			IntervalCount:   exp.IntervalCount,
+			CreatedAt:       baseTime.Add(2 * time.Minute),
+			LocalProvenance: exp.LocalProvenance,
+			ReportType:      verifyapi.ReportTypeConfirmed,
+		})
+	}
+	if _, err := publishDB.InsertAndReviseExposures(ctx, &publishdb.InsertAndReviseExposuresRequest{
+		Incoming: revisions,
+	}); err != nil {
+		tb.Fatalf("revising exposures: %v", err)
+	}
+
+	eb := &model.ExportBatch{
+		BatchID:        1,
+		ConfigID:       1,
+		BucketName:     "bucket",
+		FilenameRoot:   "us",
+		StartTimestamp: baseTime,
+		EndTimestamp:   baseTime.Add(time.Hour),
+		OutputRegion:   "US",
+		InputRegions:   []string{"US"},
+	}
+	criteria := publishdb.IterateExposuresCriteria{
+		SinceTimestamp: eb.StartTimestamp,
+		UntilTimestamp: eb.EndTimestamp,
+		IncludeRegions: eb.InputRegions,
+	}
+	return eb, criteria
+}
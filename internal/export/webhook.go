@@ -0,0 +1,160 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	"github.com/google/exposure-notifications-server/pkg/logging"
+	"github.com/sethvargo/go-retry"
+	"go.opencensus.io/stats"
+)
+
+// webhookSignatureHeader carries the hex encoded HMAC-SHA256 of the webhook
+// request body, keyed with the webhook secret.
+const webhookSignatureHeader = "X-Export-Signature"
+
+// exportWebhook is the payload of the request sent to the webhook after a
+// batch is exported.
+type exportWebhook struct {
+	BatchID        int64                `json:"batchID"`
+	ConfigID       int64                `json:"configID"`
+	Region         string               `json:"region"`
+	StartTimestamp time.Time            `json:"startTimestamp"`
+	EndTimestamp   time.Time            `json:"endTimestamp"`
+	IndexURL       string               `json:"indexURL"`
+	Files          []*exportWebhookFile `json:"files"`
+}
+
+type exportWebhookFile struct {
+	URL string `json:"url"`
+	// Digest is the base64 encoded SHA-256 digest of the file.
+	Digest string `json:"digest"`
+}
+
+// newExportWebhook builds the webhook payload for the files of the batch.
+func (s *Server) newExportWebhook(eb *model.ExportBatch, files *exportFiles) *exportWebhook {
+	payload := &exportWebhook{
+		BatchID:        eb.BatchID,
+		ConfigID:       eb.ConfigID,
+		Region:         eb.OutputRegion,
+		StartTimestamp: eb.StartTimestamp.UTC(),
+		EndTimestamp:   eb.EndTimestamp.UTC(),
+		IndexURL:       s.webhookFileURL(eb, exportIndexFilename(eb)),
+		Files:          make([]*exportWebhookFile, 0, len(files.objectNames)),
+	}
+	for i, name := range files.objectNames {
+		payload.Files = append(payload.Files, &exportWebhookFile{
+			URL:    s.webhookFileURL(eb, name),
+			Digest: files.digests[i],
+		})
+	}
+	return payload
+}
+
+// webhookFileURL returns the URL of the object under the configured base URL.
+// Without a base URL, the bucket and object name are returned.
+func (s *Server) webhookFileURL(eb *model.ExportBatch, objectName string) string {
+	if base := s.config.WebhookFileBaseURL; base != "" {
+		return strings.TrimSuffix(base, "/") + "/" + objectName
+	}
+	return eb.BucketName + "/" + objectName
+}
+
+// notifyExport sends the webhook for a batch that was exported with files, if
+// a webhook is configured. Failures are logged, but never fail the batch.
+func (s *Server) notifyExport(ctx context.Context, eb *model.ExportBatch, files *exportFiles) {
+	if s.config.WebhookURL == "" || files == nil || len(files.objectNames) == 0 {
+		return
+	}
+
+	logger := logging.FromContext(ctx).Named("notifyExport").
+		With("batch_id", eb.BatchID).
+		With("config_id", eb.ConfigID)
+
+	body, err := json.Marshal(s.newExportWebhook(eb, files))
+	if err != nil {
+		logger.Errorw("failed to marshal export webhook", "error", err)
+		stats.Record(ctx, mWebhookFailures.M(1))
+		return
+	}
+
+	if err := s.sendWebhook(ctx, body); err != nil {
+		logger.Errorw("failed to deliver export webhook", "error", err)
+		stats.Record(ctx, mWebhookFailures.M(1))
+		return
+	}
+	logger.Debugw("delivered export webhook")
+}
+
+// sendWebhook POSTs the signed body to the webhook. Requests that fail with a
+// network error, a 429 or a 5xx status are retried with exponential backoff.
+func (s *Server) sendWebhook(ctx context.Context, body []byte) error {
+	maxAttempts := s.config.WebhookMaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = 1
+	}
+	b, err := retry.NewExponential(s.config.WebhookRetryBackoff)
+	if err != nil {
+		return fmt.Errorf("invalid webhook retry backoff: %w", err)
+	}
+	b = retry.WithMaxRetries(uint64(maxAttempts-1), b)
+
+	signature := signWebhook(s.config.WebhookSecret, body)
+
+	return retry.Do(ctx, b, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.WebhookURL, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("failed to build webhook request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(webhookSignatureHeader, signature)
+
+		resp, err := s.webhookClient.Do(req)
+		if err != nil {
+			return retry.RetryableError(fmt.Errorf("failed to send webhook: %w", err))
+		}
+		defer resp.Body.Close()
+		// Drain the body so the connection can be reused.
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+		switch {
+		case resp.StatusCode >= 200 && resp.StatusCode < 300:
+			return nil
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+			return retry.RetryableError(fmt.Errorf("webhook returned status %d", resp.StatusCode))
+		default:
+			return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+		}
+	})
+}
+
+// signWebhook returns the hex encoded HMAC-SHA256 of body.
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,194 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/export/model"
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNotifyExport(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2021, 3, 4, 5, 0, 0, 0, time.UTC)
+	eb := &model.ExportBatch{
+		BatchID:        12,
+		ConfigID:       3,
+		BucketName:     "export-bucket",
+		FilenameRoot:   "root",
+		OutputRegion:   "US",
+		StartTimestamp: now.Add(-time.Hour),
+		EndTimestamp:   now,
+	}
+	files := &exportFiles{
+		objectNames: []string{"root/1-2.zip", "root/2-2.zip"},
+		digests:     []string{"ZGlnZXN0MQ==", "ZGlnZXN0Mg=="},
+	}
+
+	cases := []struct {
+		name        string
+		baseURL     string
+		statuses    []int
+		maxAttempts uint
+		wantCalls   int32
+		want        *exportWebhook
+	}{
+		{
+			name:        "success",
+			baseURL:     "https://cdn.example.com/",
+			statuses:    []int{http.StatusOK},
+			maxAttempts: 3,
+			wantCalls:   1,
+			want: &exportWebhook{
+				BatchID:        12,
+				ConfigID:       3,
+				Region:         "US",
+				StartTimestamp: now.Add(-time.Hour),
+				EndTimestamp:   now,
+				IndexURL:       "https://cdn.example.com/root/index.txt",
+				Files: []*exportWebhookFile{
+					{URL: "https://cdn.example.com/root/1-2.zip", Digest: "ZGlnZXN0MQ=="},
+					{URL: "https://cdn.example.com/root/2-2.zip", Digest: "ZGlnZXN0Mg=="},
+				},
+			},
+		},
+		{
+			name:        "no_base_url",
+			statuses:    []int{http.StatusNoContent},
+			maxAttempts: 3,
+			wantCalls:   1,
+			want: &exportWebhook{
+				BatchID:        12,
+				ConfigID:       3,
+				Region:         "US",
+				StartTimestamp: now.Add(-time.Hour),
+				EndTimestamp:   now,
+				IndexURL:       "export-bucket/root/index.txt",
+				Files: []*exportWebhookFile{
+					{URL: "export-bucket/root/1-2.zip", Digest: "ZGlnZXN0MQ=="},
+					{URL: "export-bucket/root/2-2.zip", Digest: "ZGlnZXN0Mg=="},
+				},
+			},
+		},
+		{
+			name:        "retries_server_errors",
+			statuses:    []int{http.StatusInternalServerError, http.StatusTooManyRequests, http.StatusOK},
+			maxAttempts: 3,
+			wantCalls:   3,
+		},
+		{
+			name:        "gives_up",
+			statuses:    []int{http.StatusBadGateway},
+			maxAttempts: 2,
+			wantCalls:   2,
+		},
+		{
+			name:        "client_error_not_retried",
+			statuses:    []int{http.StatusBadRequest},
+			maxAttempts: 3,
+			wantCalls:   1,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := project.TestContext(t)
+
+			var calls int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				call := atomic.AddInt32(&calls, 1)
+
+				body, err := io.ReadAll(r.Body)
+				if err != nil {
+					t.Errorf("failed to read body: %v", err)
+				}
+				if got, want := r.Header.Get(webhookSignatureHeader), signWebhook("secret", body); got != want {
+					t.Errorf("expected signature %q to be %q", got, want)
+				}
+				if tc.want != nil {
+					var got exportWebhook
+					if err := json.Unmarshal(body, &got); err != nil {
+						t.Errorf("failed to parse body: %v", err)
+					}
+					if diff := cmp.Diff(tc.want, &got); diff != "" {
+						t.Errorf("mismatch (-want, +got):\n%s", diff)
+					}
+				}
+
+				status := tc.statuses[len(tc.statuses)-1]
+				if int(call) <= len(tc.statuses) {
+					status = tc.statuses[call-1]
+				}
+				w.WriteHeader(status)
+			}))
+			t.Cleanup(ts.Close)
+
+			s := &Server{
+				config: &Config{
+					WebhookURL:          ts.URL,
+					WebhookSecret:       "secret",
+					WebhookFileBaseURL:  tc.baseURL,
+					WebhookMaxAttempts:  tc.maxAttempts,
+					WebhookRetryBackoff: time.Millisecond,
+				},
+				webhookClient: ts.Client(),
+			}
+			s.notifyExport(ctx, eb, files)
+
+			if got, want := atomic.LoadInt32(&calls), tc.wantCalls; got != want {
+				t.Errorf("expected %d calls to be %d", got, want)
+			}
+		})
+	}
+}
+
+func TestNotifyExport_Disabled(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	t.Cleanup(ts.Close)
+
+	eb := &model.ExportBatch{BatchID: 1, FilenameRoot: "root"}
+
+	// No webhook configured.
+	s := &Server{config: &Config{}, webhookClient: ts.Client()}
+	s.notifyExport(ctx, eb, &exportFiles{objectNames: []string{"root/1-1.zip"}, digests: []string{"x"}})
+
+	// No files were written.
+	s = &Server{config: &Config{WebhookURL: ts.URL, WebhookMaxAttempts: 1, WebhookRetryBackoff: time.Millisecond}, webhookClient: ts.Client()}
+	s.notifyExport(ctx, eb, &exportFiles{})
+
+	if got := atomic.LoadInt32(&calls); got != 0 {
+		t.Errorf("expected no calls, got %d", got)
+	}
+}
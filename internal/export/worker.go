@@ -17,16 +17,20 @@ package export
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"math/big"
 	"net/http"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	exportdatabase "github.com/google/exposure-notifications-server/internal/export/database"
 	publishdatabase "github.com/google/exposure-notifications-server/internal/publish/database"
+	"github.com/google/exposure-notifications-server/internal/statssink"
 	"github.com/google/exposure-notifications-server/internal/storage"
 	coredb "github.com/google/exposure-notifications-server/pkg/database"
 	"github.com/hashicorp/go-multierror"
@@ -45,55 +49,55 @@ const (
 	filenameSuffix       = ".zip"
 	blobOperationTimeout = 50 * time.Second
 
-	travelerLockID       = "TRAVELERS"
-	exportAppPackageName = "export-generated"
+	travelerLockID = "TRAVELERS"
+
+	// regionLockRetryInterval is how long a concurrent worker waits before
+	// trying to obtain the region locks for a batch again.
+	regionLockRetryInterval = time.Second
+	exportAppPackageName    = "export-generated"
 )
 
 // handleDoWork is a handler to iterate the rows of ExportBatch, and creates
-// export files.
+// export files. Up to WorkerConcurrency batches are processed at a time.
 func (s *Server) handleDoWork() http.Handler {
-	db := s.env.Database()
-
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
+		reqCtx := r.Context()
 
-		logger := logging.FromContext(ctx).Named("handleDoWork")
+		logger := logging.FromContext(reqCtx).Named("handleDoWork")
 		logger.Debugw("starting")
 		defer logger.Debugw("finishing")
 
-		ctx, cancel := context.WithTimeout(ctx, s.config.WorkerTimeout)
+		ctx, cancel := context.WithTimeout(reqCtx, s.config.WorkerTimeout)
 		defer cancel()
 
-		var merr *multierror.Error
-
-		indexesWritten := make(map[int64]struct{})
-
-		for {
-			if ctx.Err() != nil {
-				logger.Warnw("deadline passed, still work to do")
-				merr = multierror.Append(merr, fmt.Errorf("deadline exceeded"))
-				break
-			}
+		concurrency := int(s.config.WorkerConcurrency)
+		if concurrency < 1 {
+			concurrency = 1
+		}
 
-			// Check for a batch and obtain a lease for it.
-			batch, err := exportdatabase.New(db).LeaseBatch(ctx, s.config.WorkerTimeout, time.Now())
-			if err != nil {
-				logger.Errorw("failed to lease batch", "error", err)
-				merr = multierror.Append(merr, fmt.Errorf("failed to lease batch: %w", err))
-				break
-			}
-			if batch == nil {
-				logger.Debugw("no more work to do")
-				break
-			}
+		var mu sync.Mutex
+		var merr *multierror.Error
+		appendErr := func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			merr = multierror.Append(merr, err)
+		}
 
-			if err := s.processBatch(ctx, batch, indexesWritten); err != nil {
-				merr = multierror.Append(merr, fmt.Errorf("failed to process batch %d/%d: %w", batch.BatchID, batch.ConfigID, err))
-				continue
-			}
+		indexes := newIndexTracker()
+		runs := newRunTracker(time.Now().UTC())
 
-			logger.Debugw("completed batch", "batch_id", batch.BatchID, "config_id", batch.ConfigID)
+		var wg sync.WaitGroup
+		for i := 0; i < concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.doWork(ctx, indexes, runs, appendErr)
+			}()
 		}
+		wg.Wait()
+
+		// The worker context may be done, the runs are still saved.
+		s.saveExportRuns(reqCtx, runs.list(time.Now().UTC()))
 
 		if errs := merr.WrappedErrors(); len(errs) > 0 {
 			logger.Errorw("failed to run worker", "errors", errs)
@@ -106,9 +110,154 @@ func (s *Server) handleDoWork() http.Handler {
 	})
 }
 
-func (s *Server) processBatch(ctx context.Context, batch *model.ExportBatch, indexesWritten map[int64]struct{}) error {
+// doWork leases and processes batches until there are none left, the context
+// is done, or leasing fails. Errors are passed to appendErr, and the outcome of
+// each batch is recorded in runs.
+func (s *Server) doWork(ctx context.Context, indexes *indexTracker, runs *runTracker, appendErr func(error)) {
+	logger := logging.FromContext(ctx).Named("doWork")
 	db := s.env.Database()
 
+	for {
+		if ctx.Err() != nil {
+			logger.Warnw("deadline passed, still work to do")
+			appendErr(fmt.Errorf("deadline exceeded"))
+			return
+		}
+
+		// Check for a batch and obtain a lease for it. Batches that end within
+		// the activation delay are left for a later run.
+		now := time.Now()
+		closedBefore := now.Add(-s.config.ExportActivationDelay)
+		batch, err := exportdatabase.New(db).LeaseBatch(ctx, s.config.WorkerTimeout, closedBefore, now)
+		if err != nil {
+			logger.Errorw("failed to lease batch", "error", err)
+			appendErr(fmt.Errorf("failed to lease batch: %w", err))
+			return
+		}
+		if batch == nil {
+			logger.Debugw("no more work to do")
+			return
+		}
+
+		files, err := s.processBatch(ctx, batch, indexes)
+		if err != nil {
+			err = fmt.Errorf("failed to process batch %d/%d: %w", batch.BatchID, batch.ConfigID, err)
+			runs.addFailure(batch.ConfigID, err)
+			appendErr(err)
+			continue
+		}
+		if files != nil {
+			runs.addBatch(batch.ConfigID, files)
+			s.notifyExport(ctx, batch, files)
+		}
+
+		logger.Debugw("completed batch", "batch_id", batch.BatchID, "config_id", batch.ConfigID)
+	}
+}
+
+// indexTracker records the export configs whose index has been written by
+// any of the concurrent workers in a single round.
+type indexTracker struct {
+	mu      sync.Mutex
+	written map[int64]struct{}
+}
+
+func newIndexTracker() *indexTracker {
+	return &indexTracker{
+		written: make(map[int64]struct{}),
+	}
+}
+
+// markWritten records that the index for the config is being written and
+// returns true if it wasn't already.
+func (t *indexTracker) markWritten(configID int64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.written[configID]; ok {
+		return false
+	}
+	t.written[configID] = struct{}{}
+	return true
+}
+
+// runTracker records the outcome of the batches processed by any of the
+// concurrent workers in a single round, per export config.
+type runTracker struct {
+	mu        sync.Mutex
+	startedAt time.Time
+	runs      map[int64]*model.ExportRun
+}
+
+func newRunTracker(startedAt time.Time) *runTracker {
+	return &runTracker{
+		startedAt: startedAt,
+		runs:      make(map[int64]*model.ExportRun),
+	}
+}
+
+// run returns the run for the config. The caller must hold the lock.
+func (t *runTracker) run(configID int64) *model.ExportRun {
+	r, ok := t.runs[configID]
+	if !ok {
+		r = &model.ExportRun{
+			ConfigID:  configID,
+			StartedAt: t.startedAt,
+			Outcome:   model.ExportRunSuccess,
+		}
+		t.runs[configID] = r
+	}
+	return r
+}
+
+// addBatch records an exported batch for the config.
+func (t *runTracker) addBatch(configID int64, files *exportFiles) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.run(configID).AddBatch(files.numKeys, files.numRevisedKeys)
+}
+
+// addFailure records a failed batch for the config.
+func (t *runTracker) addFailure(configID int64, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.run(configID).AddFailure(err)
+}
+
+// list returns the runs of the configs that had batches, ordered by config.
+func (t *runTracker) list(finishedAt time.Time) []*model.ExportRun {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	runs := make([]*model.ExportRun, 0, len(t.runs))
+	for _, r := range t.runs {
+		r.FinishedAt = finishedAt
+		runs = append(runs, r)
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].ConfigID < runs[j].ConfigID
+	})
+	return runs
+}
+
+// saveExportRuns saves the run records. Failures are logged, but never fail
+// the run.
+func (s *Server) saveExportRuns(ctx context.Context, runs []*model.ExportRun) {
+	if err := exportdatabase.New(s.env.Database()).AddExportRuns(ctx, runs); err != nil {
+		logger := logging.FromContext(ctx).Named("saveExportRuns")
+		logger.Errorw("failed to save export runs", "error", err)
+	}
+}
+
+// inputRegions returns the canonical input regions of the batch, plus the
+// regions that are aliased to them.
+func (s *Server) inputRegions(eb *model.ExportBatch) []string {
+	return s.config.ExpandRegionAliases(s.regionNormalizer.NormalizeAll(eb.EffectiveInputRegions()))
+}
+
+// processBatch exports the batch. It returns the files that were written, or
+// nil if the batch was skipped and will be retried later.
+func (s *Server) processBatch(ctx context.Context, batch *model.ExportBatch, indexes *indexTracker) (*exportFiles, error) {
 	// Obtain the necessary locks for this export batch. Ensure that only
 	// one export worker is operating over a region at a time.
 	//
@@ -119,8 +268,14 @@ func (s *Server) processBatch(ctx context.Context, batch *model.ExportBatch, ind
 	// In the export lease selection, we attempt to order export batch filling such that
 	// earlier batches are filled before later batches. This helps to reduce the possibility
 	// of non-overlapping generated data.
-	locks := make([]string, 0, len(batch.EffectiveInputRegions())+1)
-	locks = append(locks, batch.EffectiveInputRegions()...)
+	//
+	// The locks are in the database, so export workers on other instances,
+	// e.g. from a cron job misconfigured to run on several nodes, back off
+	// instead of writing conflicting files and indexes for the same config.
+	// They expire with the worker timeout if the worker holding them crashes.
+	inputRegions := s.inputRegions(batch)
+	locks := make([]string, 0, len(inputRegions)+1)
+	locks = append(locks, inputRegions...)
 	if batch.IncludeTravelers {
 		locks = append(locks, travelerLockID)
 	}
@@ -130,13 +285,13 @@ func (s *Server) processBatch(ctx context.Context, batch *model.ExportBatch, ind
 		With("config_id", batch.ConfigID).
 		With("regions", locks)
 
-	unlock, err := db.MultiLock(ctx, locks, s.config.WorkerTimeout)
+	unlock, err := s.lockRegions(ctx, locks)
 	if err != nil {
 		if errors.Is(err, coredb.ErrAlreadyLocked) {
 			logger.Warnw("skipping (already locked)")
-			return nil
+			return nil, nil
 		}
-		return fmt.Errorf("failed to obtain locks on %q: %w", locks, err)
+		return nil, fmt.Errorf("failed to obtain locks on %q: %w", locks, err)
 	}
 	defer func() {
 		if err := unlock(); err != nil {
@@ -150,18 +305,36 @@ func (s *Server) processBatch(ctx context.Context, batch *model.ExportBatch, ind
 	// avoid writing the same file repeatedly and hitting a rate limit. This
 	// ensures that we write the index file for an empty batch at most once
 	// per processed config each round.
-	emitIndexForEmptyBatch := false
-	if _, ok := indexesWritten[batch.ConfigID]; !ok {
-		emitIndexForEmptyBatch = true
-		indexesWritten[batch.ConfigID] = struct{}{}
-	}
+	emitIndexForEmptyBatch := indexes.markWritten(batch.ConfigID)
 
 	// Ensure that the locks are released on either success or failure path.
-	if err = s.exportBatch(ctx, batch, emitIndexForEmptyBatch); err != nil {
-		return fmt.Errorf("failed to create files for batch: %w", err)
+	files, err := s.exportBatch(ctx, batch, emitIndexForEmptyBatch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create files for batch: %w", err)
 	}
 
-	return nil
+	return files, nil
+}
+
+// lockRegions obtains the locks for a batch. With concurrent workers, the
+// locks are likely held by another worker in this process that is processing an
+// earlier batch for the same regions, so wait for it rather than leaving this
+// batch leased until the lease expires.
+func (s *Server) lockRegions(ctx context.Context, locks []string) (coredb.UnlockFn, error) {
+	db := s.env.Database()
+
+	for {
+		unlock, err := db.MultiLock(ctx, locks, s.config.WorkerTimeout)
+		if err == nil || !errors.Is(err, coredb.ErrAlreadyLocked) || s.config.WorkerConcurrency <= 1 {
+			return unlock, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(regionLockRetryInterval):
+		}
+	}
 }
 
 type group struct {
@@ -173,52 +346,107 @@ func (g *group) Length() int {
 	return len(g.exposures) + len(g.revised)
 }
 
-func (s *Server) batchExposures(ctx context.Context, criteria publishdatabase.IterateExposuresCriteria, maxRecords int, outputRegion string) ([]*group, error) {
-	logger := logging.FromContext(ctx)
-	db := s.env.Database()
+// keySelection selects which keys of a batch are exported.
+type keySelection struct {
+	newKeys     bool
+	revisedKeys bool
+}
+
+// allKeys exports both the new and the revised keys.
+var allKeys = keySelection{newKeys: true, revisedKeys: true}
+
+// batchKeySelection returns the keys exported by the batch. A batch either
+// exports all keys, only the new keys or only the revised keys.
+func batchKeySelection(eb *model.ExportBatch) keySelection {
+	return keySelection{
+		newKeys:     !eb.OnlyRevisedKeys,
+		revisedKeys: !eb.ExcludeRevisedKeys,
+	}
+}
 
+func (s *Server) batchExposures(ctx context.Context, criteria publishdatabase.IterateExposuresCriteria, sel keySelection, maxRecords int, outputRegion string) ([]*group, error) {
 	// Build up groups of exposures in memory. We need to use memory so we can
 	// determine the total number of groups (which is embedded in each export
 	// file). This technique avoids SELECT COUNT which would lock the database
 	// slowing new uploads.
+	primaryKeys, revisedKeys, maxCreatedAt, err := s.readExposures(ctx, criteria, sel)
+	if err != nil {
+		return nil, err
+	}
+	return s.padGroups(ctx, groupExposures(primaryKeys, revisedKeys, maxRecords), sel, len(primaryKeys), maxRecords, outputRegion, maxCreatedAt)
+}
+
+// padGroups pads the new keys of the last group if the batch has fewer than
+// MinRecords new keys, and inserts the generated keys.
+func (s *Server) padGroups(ctx context.Context, groups []*group, sel keySelection, numPrimaryKeys, maxRecords int, outputRegion string, maxCreatedAt time.Time) ([]*group, error) {
+	logger := logging.FromContext(ctx)
+
+	if len(groups) == 0 {
+		logger.Infof("No records for export batch")
+	} else if sel.newKeys && numPrimaryKeys < s.config.MinRecords {
+		// only drop into the padding code if the overall sum of groups is less than requested. Otherwise the pre-sorting
+		// will give away the generated data.
+		lastGroup := groups[len(groups)-1]
+		var generated []*publishmodel.Exposure
+		var err error
+		lastGroup.exposures, generated, err = ensureMinNumExposures(lastGroup.exposures, outputRegion, s.config.MinRecords, s.config.PaddingRange, maxRecords, maxCreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("ensureMinNumExposures: %w", err)
+		}
+		// padding revised keys doesn't provide any useful protection as one can work backwords and figure out which
+		// keys appeared as primary keys in a previous export.
+
+		if err := s.insertGenerated(ctx, generated); err != nil {
+			return nil, err
+		}
+	}
+
+	return groups, nil
+}
+
+// readExposures loads the selected new and revised keys that match the
+// criteria, sorted, and returns the latest creation time of the new keys.
+func (s *Server) readExposures(ctx context.Context, criteria publishdatabase.IterateExposuresCriteria, sel keySelection) ([]*publishmodel.Exposure, []*publishmodel.Exposure, time.Time, error) {
+	logger := logging.FromContext(ctx)
+	db := s.env.Database()
+
 	primaryKeys := make([]*publishmodel.Exposure, 0, s.config.MinRecords)
 	revisedKeys := make([]*publishmodel.Exposure, 0, s.config.MinRecords)
-	totalNewKeys, totalRevisedKeys := 0, 0
 	droppedKeys := 0
 
 	publishDB := publishdatabase.New(db)
 
 	maxCreatedAt := time.Time{}
-	_, err := publishDB.IterateExposures(ctx, criteria, func(exp *publishmodel.Exposure) error {
-		if len(exp.ExposureKey) != verifyapi.KeyLength {
-			droppedKeys++
+	if sel.newKeys {
+		if _, err := publishDB.IterateExposures(ctx, criteria, func(exp *publishmodel.Exposure) error {
+			if len(exp.ExposureKey) != verifyapi.KeyLength {
+				droppedKeys++
+				return nil
+			}
+			// see if assigned time for generated data should be moved up.
+			if exp.CreatedAt.After(maxCreatedAt) {
+				maxCreatedAt = exp.CreatedAt
+			}
+			primaryKeys = append(primaryKeys, exp)
 			return nil
+		}); err != nil {
+			return nil, nil, time.Time{}, fmt.Errorf("iterating exposures: %w", err)
 		}
-		// see if assigned time for generated data should be moved up.
-		if exp.CreatedAt.After(maxCreatedAt) {
-			maxCreatedAt = exp.CreatedAt
-		}
-		primaryKeys = append(primaryKeys, exp)
-		totalNewKeys++
-		return nil
-	})
-	if err != nil {
-		return nil, fmt.Errorf("iterating exposures: %w", err)
 	}
 
 	// go get the revised keys.
-	criteria.OnlyRevisedKeys = true
-	_, err = publishDB.IterateExposures(ctx, criteria, func(exp *publishmodel.Exposure) error {
-		if len(exp.ExposureKey) != verifyapi.KeyLength {
-			droppedKeys++
+	if sel.revisedKeys {
+		criteria.OnlyRevisedKeys = true
+		if _, err := publishDB.IterateExposures(ctx, criteria, func(exp *publishmodel.Exposure) error {
+			if len(exp.ExposureKey) != verifyapi.KeyLength {
+				droppedKeys++
+				return nil
+			}
+			revisedKeys = append(revisedKeys, exp)
 			return nil
+		}); err != nil {
+			return nil, nil, time.Time{}, fmt.Errorf("iterating revised exposures: %w", err)
 		}
-		revisedKeys = append(revisedKeys, exp)
-		totalRevisedKeys++
-		return nil
-	})
-	if err != nil {
-		return nil, fmt.Errorf("iterating revised exposures: %w", err)
 	}
 
 	if droppedKeys > 0 {
@@ -230,8 +458,12 @@ func (s *Server) batchExposures(ctx context.Context, criteria publishdatabase.It
 	// the sizing information is the same.
 	sortExposures(primaryKeys)
 	sortExposures(revisedKeys)
+	return primaryKeys, revisedKeys, maxCreatedAt, nil
+}
 
-	// Break these into groups according to the max records per file.
+// groupExposures breaks the keys into groups according to the max records per
+// file, the new keys first.
+func groupExposures(primaryKeys, revisedKeys []*publishmodel.Exposure, maxRecords int) []*group {
 	groups := make([]*group, 0, 1)
 	nextGroup := &group{}
 	for _, exp := range primaryKeys {
@@ -253,51 +485,91 @@ func (s *Server) batchExposures(ctx context.Context, criteria publishdatabase.It
 	if nextGroup.Length() > 0 {
 		groups = append(groups, nextGroup)
 	}
+	return groups
+}
 
-	if len(groups) == 0 {
-		logger.Infof("No records for export batch")
-	} else if len(primaryKeys) < s.config.MinRecords {
-		// only drop into the padding code if the overall sum of groups is less than requested. Otherwise the pre-sorting
-		// will give away the generated data.
-		lastGroup := groups[len(groups)-1]
-		var generated []*publishmodel.Exposure
-		lastGroup.exposures, generated, err = ensureMinNumExposures(lastGroup.exposures, outputRegion, s.config.MinRecords, s.config.PaddingRange, maxRecords, maxCreatedAt)
+// insertGenerated persists the keys generated to pad out an export.
+func (s *Server) insertGenerated(ctx context.Context, generated []*publishmodel.Exposure) error {
+	logger := logging.FromContext(ctx)
+	publishDB := publishdatabase.New(s.env.Database())
+
+	length := len(generated)
+	if length == 0 {
+		return nil
+	}
+
+	// we generated some data in order to pad out this export. This data needs to be persisted.
+	insertRequest := &publishdatabase.InsertAndReviseExposuresRequest{
+		RequireToken:  false,
+		SkipRevisions: true,
+	}
+	// Insert the generated data in batches.
+	for i := 0; i < length; i = i + s.config.MaxInsertBatchSize {
+		upper := i + s.config.MaxInsertBatchSize
+		if upper > length {
+			upper = length
+		}
+		insertRequest.Incoming = generated[i:upper]
+		insertResponse, err := publishDB.InsertAndReviseExposures(ctx, insertRequest)
 		if err != nil {
-			return nil, fmt.Errorf("ensureMinNumExposures: %w", err)
+			// If this fails, the batch will be retried.
+			return fmt.Errorf("writing generated data, publishDB.InsertAndReviseExposures: %w", err)
 		}
-		// padding revised keys doesn't provide any useful protection as one can work backwords and figure out which
-		// keys appeared as primary keys in a previous export.
+		logger.Debugw("persisting generated keys", "num", insertResponse.Inserted)
+		i = i + upper
+	}
+	return nil
+}
 
-		if length := len(generated); length > 0 {
-			// we generated some data in order to pad out this export. This data needs to be persisted.
-			insertRequest := &publishdatabase.InsertAndReviseExposuresRequest{
-				RequireToken:  false,
-				SkipRevisions: true,
-			}
-			// Insert the generated data in batches.
-			for i := 0; i < length; i = i + s.config.MaxInsertBatchSize {
-				upper := i + s.config.MaxInsertBatchSize
-				if upper > length {
-					upper = length
-				}
-				insertRequest.Incoming = generated[i:upper]
-				insertResponse, err := publishDB.InsertAndReviseExposures(ctx, insertRequest)
-				if err != nil {
-					// If this fails, the batch will be retried.
-					return nil, fmt.Errorf("writing generated data, publishDB.InsertAndReviseExposures: %w", err)
-				}
-				logger.Debugw("persisting generated keys", "num", insertResponse.Inserted)
-				i = i + upper
-			}
-		}
+// onsetWindow returns the symptom onset window for the batch, relative to the
+// end of the batch, or nil if the batch isn't restricted by onset.
+func onsetWindow(eb *model.ExportBatch) *publishdatabase.OnsetWindow {
+	if eb.OnsetWindowMinDays == nil && eb.OnsetWindowMaxDays == nil && !eb.ExcludeMissingOnset {
+		return nil
+	}
+	return &publishdatabase.OnsetWindow{
+		Reference:      eb.EndTimestamp,
+		MinDays:        eb.OnsetWindowMinDays,
+		MaxDays:        eb.OnsetWindowMaxDays,
+		ExcludeMissing: eb.ExcludeMissingOnset,
 	}
+}
 
-	return groups, nil
+// transmissionRiskFilter returns the transmission risk filter for the batch, or
+// nil if the batch isn't restricted by transmission risk.
+func transmissionRiskFilter(eb *model.ExportBatch) *publishdatabase.TransmissionRiskFilter {
+	if eb.MinTransmissionRisk <= 0 {
+		return nil
+	}
+	return &publishdatabase.TransmissionRiskFilter{
+		Min:            eb.MinTransmissionRisk,
+		IncludeUnknown: eb.IncludeUnknownTransmissionRisk,
+	}
+}
+
+// reportTypeFilter returns the report type filter for the batch, or nil if the
+// batch isn't restricted by report type. Keys without a report type are
+// included if they are exported with an allowed default report type.
+func reportTypeFilter(eb *model.ExportBatch) *publishdatabase.ReportTypeFilter {
+	if len(eb.ReportTypes) == 0 {
+		return nil
+	}
+	includeUnknown := eb.IncludeUnknownReportType
+	for _, reportType := range eb.ReportTypes {
+		if reportType == eb.DefaultReportType {
+			includeUnknown = true
+		}
+	}
+	return &publishdatabase.ReportTypeFilter{
+		Allowed:        eb.ReportTypes,
+		IncludeUnknown: includeUnknown,
+	}
 }
 
-func (s *Server) exportBatch(ctx context.Context, eb *model.ExportBatch, emitIndexForEmptyBatch bool) error {
+func (s *Server) exportBatch(ctx context.Context, eb *model.ExportBatch, emitIndexForEmptyBatch bool) (*exportFiles, error) {
 	logger := logging.FromContext(ctx)
 	db := s.env.Database()
+	start := time.Now()
 
 	maxRecords := eb.EffectiveMaxRecords(s.config.MaxRecords)
 	if maxRecords != s.config.MaxRecords {
@@ -308,81 +580,223 @@ func (s *Server) exportBatch(ctx context.Context, eb *model.ExportBatch, emitInd
 
 	// Criteria starts w/ non-revised keys.
 	// Will be changed later to grab the revised keys.
+	includeRegions := s.inputRegions(eb)
+	excludeRegions := s.regionNormalizer.NormalizeAll(eb.ExcludeRegions)
 	criteria := publishdatabase.IterateExposuresCriteria{
-		SinceTimestamp:      eb.StartTimestamp,
-		UntilTimestamp:      eb.EndTimestamp,
-		IncludeRegions:      eb.EffectiveInputRegions(),
-		IncludeTravelers:    eb.IncludeTravelers, // Travelers are included from "any" region.
-		OnlyNonTravelers:    eb.OnlyNonTravelers,
-		ExcludeRegions:      eb.ExcludeRegions,
-		OnlyLocalProvenance: false, // include federated ids
-		OnlyRevisedKeys:     false,
-	}
-
-	groups, err := s.batchExposures(ctx, criteria, maxRecords, eb.OutputRegion)
-	if err != nil {
-		return fmt.Errorf("reading exposures for batch: %w", err)
+		SinceTimestamp:        eb.StartTimestamp,
+		UntilTimestamp:        eb.EndTimestamp,
+		IncludeRegions:        includeRegions,
+		IncludeMissingRegions: s.config.IncludeMissingRegions(includeRegions, excludeRegions),
+		IncludeTravelers:      eb.IncludeTravelers, // Travelers are included from "any" region.
+		OnlyNonTravelers:      eb.OnlyNonTravelers,
+		ExcludeRegions:        excludeRegions,
+		OnlyLocalProvenance:   false, // include federated ids
+		OnlyRevisedKeys:       false,
+		OnsetWindow:           onsetWindow(eb),
+		TransmissionRisk:      transmissionRiskFilter(eb),
+		ReportTypes:           reportTypeFilter(eb),
 	}
 
 	exportDB := exportdatabase.New(db)
 	// Load the non-expired signature infos associated with this export batch.
 	sigInfos, err := exportDB.LookupSignatureInfos(ctx, eb.SignatureInfoIDs, time.Now())
 	if err != nil {
-		return fmt.Errorf("error loading signature info for batch %d, %w", eb.BatchID, err)
+		return nil, fmt.Errorf("error loading signature info for batch %d, %w", eb.BatchID, err)
+	}
+
+	// The object headers come from the current config, so that changing them
+	// applies to the next files written.
+	ec, err := exportDB.GetExportConfig(ctx, eb.ConfigID)
+	if err != nil {
+		return nil, fmt.Errorf("error loading export config for batch %d, %w", eb.BatchID, err)
 	}
 
 	// Create the export files.
-	batchSize := len(groups)
-	splitBatch := batchSize > 1
-	objectNames := make([]string, 0, len(groups))
+	var files *exportFiles
+	if s.config.StreamExportFiles {
+		files, err = s.streamExportFiles(ctx, eb, criteria, maxRecords, sigInfos, exportFileAttrs(ec))
+	} else {
+		files, err = s.bufferExportFiles(ctx, eb, criteria, maxRecords, sigInfos, exportFileAttrs(ec))
+	}
+	if err == nil && len(files.objectNames) == 0 && s.config.ExportEmptyBatches {
+		contentHash := files.contentHash
+		files, err = s.writeEmptyBatchMarker(ctx, eb, sigInfos, exportFileAttrs(ec))
+		if err == nil {
+			files.contentHash = contentHash
+		}
+	}
+	if err != nil {
+		if errors.Is(err, errBatchTimeout) {
+			logger.Infof("Timed out writing export files for batch %s, the entire batch will be retried once the batch lease expires on %v", eb.BatchID, eb.LeaseExpires)
+			return nil, nil
+		}
+		return nil, err
+	}
+	// The content hash is only kept if the batch wrote files.
+	eb.ContentHash = ""
+	if len(files.objectNames) > 0 {
+		eb.ContentHash = files.contentHash
+	}
+	objectNames := files.objectNames
+	batchSize := len(objectNames)
+
+	// Emit the index file if needed.
+	if batchSize > 0 || emitIndexForEmptyBatch {
+		if err := s.retryingCreateIndex(ctx, eb, objectNames, indexFileAttrs(ec)); err != nil {
+			return nil, err
+		}
+	}
+
+	// Write the files records in database and complete the batch.
+	if err := exportDB.FinalizeBatch(ctx, eb, objectNames, batchSize); err != nil {
+		return nil, fmt.Errorf("completing batch: %w", err)
+	}
+	logger.Infof("Batch %d completed", eb.BatchID)
+
+	// The region locks of the batch are still held, so the deltas of the config
+	// aren't written by two batches at once.
+	s.writeDeltaExports(ctx, eb, criteria, maxRecords, sigInfos, exportFileAttrs(ec), indexFileAttrs(ec))
+
+	tags := []tag.Mutator{
+		tag.Upsert(ExportConfigIDTagKey, fmt.Sprintf("%d", eb.ConfigID)),
+		tag.Upsert(ExportRegionTagKey, eb.OutputRegion),
+		tag.Upsert(ExportTravelersTagKey, fmt.Sprintf("%v", eb.IncludeTravelers)),
+	}
+	if err := stats.RecordWithTags(ctx, tags, mExportBatchCompletion.M(1)); err != nil {
+		logger.Errorw("failed to record export batch completion", "error", err)
+	}
+	recordBatchDuration(ctx, eb, time.Since(start))
+	s.recordExport(ctx, eb, files)
+
+	return files, nil
+}
+
+// errBatchTimeout is returned when the context is done before all files of a
+// batch are written. The batch is retried once the lease expires.
+var errBatchTimeout = errors.New("timed out writing export files")
+
+// exportFiles are the files written for a batch.
+type exportFiles struct {
+	objectNames []string
+	// digests are the base64 encoded SHA-256 digests of the files, in the
+	// same order as objectNames.
+	digests        []string
+	numKeys        int
+	numRevisedKeys int
+
+	// contentHash is the content hash of the batch, see contentHasher.
+	contentHash string
+}
+
+// bufferExportFiles loads all the keys of the batch and then writes them to
+// the export files.
+func (s *Server) bufferExportFiles(ctx context.Context, eb *model.ExportBatch, criteria publishdatabase.IterateExposuresCriteria, maxRecords int, sigInfos []*model.SignatureInfo, attrs *storage.ObjectAttrs) (*exportFiles, error) {
+	sel := batchKeySelection(eb)
+	primaryKeys, revisedKeys, maxCreatedAt, err := s.readExposures(ctx, criteria, sel)
+	if err != nil {
+		return nil, fmt.Errorf("reading exposures for batch: %w", err)
+	}
+
+	// The content hash is computed before the batch is padded, since padding
+	// inserts random keys.
+	content := newContentHasher(eb)
+	for _, exp := range primaryKeys {
+		if err := content.add(exp, false); err != nil {
+			return nil, err
+		}
+	}
+	for _, exp := range revisedKeys {
+		if err := content.add(exp, true); err != nil {
+			return nil, err
+		}
+	}
+	contentHash, err := content.hash(eb, sigInfos)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := s.padGroups(ctx, groupExposures(primaryKeys, revisedKeys, maxRecords), sel, len(primaryKeys), maxRecords, eb.OutputRegion, maxCreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("reading exposures for batch: %w", err)
+	}
+	files, err := s.writeGroups(ctx, eb, groups, sigInfos, attrs)
+	if err != nil {
+		return nil, err
+	}
+	files.contentHash = contentHash
+	return files, nil
+}
+
+// writeEmptyBatchMarker writes a signed export file without keys for a batch
+// that has none, so that clients can tell that its window was processed. The
+// file isn't padded, as padding only applies to batches with keys.
+func (s *Server) writeEmptyBatchMarker(ctx context.Context, eb *model.ExportBatch, sigInfos []*model.SignatureInfo, attrs *storage.ObjectAttrs) (*exportFiles, error) {
+	logging.FromContext(ctx).Infof("No keys for export batch %d, writing an empty export file", eb.BatchID)
+	return s.writeGroups(ctx, eb, []*group{{}}, sigInfos, attrs)
+}
+
+// writeGroups writes an export file for each group of keys.
+func (s *Server) writeGroups(ctx context.Context, eb *model.ExportBatch, groups []*group, sigInfos []*model.SignatureInfo, attrs *storage.ObjectAttrs) (*exportFiles, error) {
+	logger := logging.FromContext(ctx)
+
+	splitBatch := len(groups) > 1
+	files := &exportFiles{
+		objectNames: make([]string, 0, len(groups)),
+		digests:     make([]string, 0, len(groups)),
+	}
 	for i, group := range groups {
 		if ctx.Err() != nil {
-			logger.Infof("Timed out writing export files for batch %s, the entire batch will be retried once the batch lease expires on %v", eb.BatchID, eb.LeaseExpires)
-			return nil
+			return nil, errBatchTimeout
 		}
 
 		// 20201120 - Batch num/size changed to always be 1/1.
 		// The batch numbering being deemed unnecessary.
 		// However timing adjustments are put in place for variable batch sizes.
-		objectName, err := s.createFile(ctx,
+		objectName, digest, err := s.createFile(ctx,
 			&createFileInfo{
 				exposures:        group.exposures,
 				revisedExposures: group.revised,
 				exportBatch:      eb,
 				signatureInfos:   sigInfos,
+				attrs:            attrs,
 				fileNum:          int32(i + 1), // the batchNum and batchSize are flattened to 1 and 1 when
 				splitBatch:       splitBatch,
 			})
 		if err != nil {
-			return fmt.Errorf("creating export file %d for batch %d: %w", i+1, eb.BatchID, err)
+			return nil, fmt.Errorf("creating export file %d for batch %d: %w", i+1, eb.BatchID, err)
 		}
 		logger.Infof("Wrote export file %q for batch %d", objectName, eb.BatchID)
-		objectNames = append(objectNames, objectName)
+		files.objectNames = append(files.objectNames, objectName)
+		files.digests = append(files.digests, digest)
+		files.numKeys += len(group.exposures)
+		files.numRevisedKeys += len(group.revised)
 	}
+	return files, nil
+}
 
-	// Emit the index file if needed.
-	if batchSize > 0 || emitIndexForEmptyBatch {
-		if err := s.retryingCreateIndex(ctx, eb, objectNames); err != nil {
-			return err
-		}
+// recordExport records the completed batch in the stats sink. Failures are
+// logged, but never fail the batch.
+func (s *Server) recordExport(ctx context.Context, eb *model.ExportBatch, files *exportFiles) {
+	if s.statsSink == nil {
+		return
 	}
 
-	// Write the files records in database and complete the batch.
-	if err := exportDB.FinalizeBatch(ctx, eb, objectNames, batchSize); err != nil {
-		return fmt.Errorf("completing batch: %w", err)
+	record := &statssink.ExportRecord{
+		BatchID:        eb.BatchID,
+		ConfigID:       eb.ConfigID,
+		FilenameRoot:   eb.FilenameRoot,
+		OutputRegion:   eb.OutputRegion,
+		StartTimestamp: eb.StartTimestamp,
+		EndTimestamp:   eb.EndTimestamp,
+		NumKeys:        files.numKeys,
+		NumRevisedKeys: files.numRevisedKeys,
+		NumFiles:       len(files.objectNames),
+		CreatedAt:      time.Now().UTC(),
 	}
-	logger.Infof("Batch %d completed", eb.BatchID)
 
-	tags := []tag.Mutator{
-		tag.Upsert(ExportConfigIDTagKey, fmt.Sprintf("%d", eb.ConfigID)),
-		tag.Upsert(ExportRegionTagKey, eb.OutputRegion),
-		tag.Upsert(ExportTravelersTagKey, fmt.Sprintf("%v", eb.IncludeTravelers)),
-	}
-	if err := stats.RecordWithTags(ctx, tags, mExportBatchCompletion.M(1)); err != nil {
-		logger.Errorw("failed to record export batch completion", "error", err)
+	if err := s.statsSink.RecordExport(ctx, record); err != nil {
+		logger := logging.FromContext(ctx).Named("recordExport")
+		logger.Errorw("failed to record export stats", "error", err)
 	}
-
-	return nil
 }
 
 type createFileInfo struct {
@@ -390,48 +804,120 @@ type createFileInfo struct {
 	revisedExposures []*publishmodel.Exposure
 	exportBatch      *model.ExportBatch
 	signatureInfos   []*model.SignatureInfo
+	attrs            *storage.ObjectAttrs
 	fileNum          int32 // file number, normally 1, but could be higher in a split batch
 	splitBatch       bool  // Did this batch contain more than 1 file due to too many keys?
 }
 
-func (s *Server) createFile(ctx context.Context, cfi *createFileInfo) (string, error) {
+// createFile writes an export file and returns its name and digest.
+func (s *Server) createFile(ctx context.Context, cfi *createFileInfo) (string, string, error) {
 	logger := logging.FromContext(ctx)
 
-	signers := make([]*Signer, 0, len(cfi.signatureInfos))
-	for _, si := range cfi.signatureInfos {
-		signer, err := s.env.GetSignerForKey(ctx, si.SigningKey)
-		if err != nil {
-			return "", fmt.Errorf("unable to get signer for key %v: %w", si.SigningKey, err)
-		}
-		signers = append(signers, &Signer{SignatureInfo: si, Signer: signer})
+	signers, err := s.signers(ctx, cfi.signatureInfos)
+	if err != nil {
+		return "", "", err
 	}
 
 	// Generate exposure key export file.
 	data, err := MarshalExportFile(cfi.exportBatch, cfi.exposures, cfi.revisedExposures, cfi.fileNum, cfi.splitBatch, signers)
 	if err != nil {
-		return "", fmt.Errorf("marshaling export file: %w", err)
+		return "", "", fmt.Errorf("marshaling export file: %w", err)
 	}
 
 	objectName := exportFilename(cfi.exportBatch, cfi.fileNum, s.config.RepressGeneration())
 	logger.Infof("Created file %v, signed with %v keys", objectName, len(signers))
-	ctx, cancel := context.WithTimeout(ctx, blobOperationTimeout)
+	numKeys := len(cfi.exposures) + len(cfi.revisedExposures)
+	digest, err := s.writeExportFile(ctx, cfi.exportBatch, objectName, data, numKeys, signers, cfi.attrs)
+	if err != nil {
+		return "", "", err
+	}
+	return objectName, digest, nil
+}
+
+// writeExportFile writes the contents of an export file with numKeys keys to
+// the batch's bucket, and records the size of the file. If enabled, the
+// signature sidecar and the CBOR file are written first, so they exist once
+// the file does. It returns the base64 encoded SHA-256 digest of the file.
+func (s *Server) writeExportFile(ctx context.Context, eb *model.ExportBatch, objectName string, data []byte, numKeys int, signers []*Signer, attrs *storage.ObjectAttrs) (string, error) {
+	if err := s.writeSignatureSidecar(ctx, eb.BucketName, objectName, data, signers, attrs); err != nil {
+		return "", err
+	}
+	if err := s.writeCBORFile(ctx, eb, objectName, data, signers, attrs); err != nil {
+		return "", err
+	}
+
+	writeCtx, cancel := context.WithTimeout(ctx, blobOperationTimeout)
 	defer cancel()
-	if err := s.env.Blobstore().CreateObject(ctx, cfi.exportBatch.BucketName, objectName, data, true, storage.ContentTypeZip); err != nil {
-		return "", fmt.Errorf("creating file %s in bucket %s: %w", objectName, cfi.exportBatch.BucketName, err)
+	if err := s.env.Blobstore().CreateObjectWithAttrs(writeCtx, eb.BucketName, objectName, data, attrs); err != nil {
+		return "", fmt.Errorf("creating file %s in bucket %s: %w", objectName, eb.BucketName, err)
+	}
+
+	if err := stats.RecordWithTags(ctx, exportMetricTags(eb), mExportFileBytes.M(int64(len(data))), mExportFileKeys.M(int64(numKeys))); err != nil {
+		logging.FromContext(ctx).Errorw("failed to record export file size", "error", err)
+	}
+
+	digest := sha256.Sum256(data)
+	return base64.StdEncoding.EncodeToString(digest[:]), nil
+}
+
+// recordBatchDuration records how long it took to generate a completed batch.
+func recordBatchDuration(ctx context.Context, eb *model.ExportBatch, d time.Duration) {
+	ms := float64(d) / float64(time.Millisecond)
+	if err := stats.RecordWithTags(ctx, exportMetricTags(eb), mExportBatchDurationMs.M(ms)); err != nil {
+		logging.FromContext(ctx).Errorw("failed to record export batch duration", "error", err)
+	}
+}
+
+// exportMetricTags are the tags of the export file and batch metrics.
+func exportMetricTags(eb *model.ExportBatch) []tag.Mutator {
+	return []tag.Mutator{
+		tag.Upsert(ExportConfigIDTagKey, fmt.Sprintf("%d", eb.ConfigID)),
+		tag.Upsert(ExportRegionTagKey, eb.OutputRegion),
+	}
+}
+
+// exportFileAttrs returns the headers of the export files for the config.
+func exportFileAttrs(ec *model.ExportConfig) *storage.ObjectAttrs {
+	attrs := storage.DefaultObjectAttrs(true, storage.ContentTypeZip)
+	if ec.CacheControl != "" {
+		attrs.CacheControl = ec.CacheControl
 	}
-	return objectName, nil
+	attrs.ContentDisposition = ec.ContentDisposition
+	return attrs
+}
+
+// indexFileAttrs returns the headers of the index file for the config.
+func indexFileAttrs(ec *model.ExportConfig) *storage.ObjectAttrs {
+	attrs := storage.DefaultObjectAttrs(false, storage.ContentTypeTextPlain)
+	if ec.IndexCacheControl != "" {
+		attrs.CacheControl = ec.IndexCacheControl
+	}
+	return attrs
+}
+
+// signers gets the signer for each signature info from the key manager.
+func (s *Server) signers(ctx context.Context, sigInfos []*model.SignatureInfo) ([]*Signer, error) {
+	signers := make([]*Signer, 0, len(sigInfos))
+	for _, si := range sigInfos {
+		signer, err := s.env.GetSignerForKey(ctx, si.SigningKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get signer for key %v: %w", si.SigningKey, err)
+		}
+		signers = append(signers, &Signer{SignatureInfo: si, Signer: s.retryingSigner(ctx, signer)})
+	}
+	return signers, nil
 }
 
 // retryingCreateIndex create the index file. The index file includes _all_
 // batches for an ExportConfig, so multiple workers may be racing to update it.
 // We use a lock to make them line up after one another.
-func (s *Server) retryingCreateIndex(ctx context.Context, eb *model.ExportBatch, objectNames []string) error {
+func (s *Server) retryingCreateIndex(ctx context.Context, eb *model.ExportBatch, objectNames []string, attrs *storage.ObjectAttrs) error {
 	logger := logging.FromContext(ctx)
 	db := s.env.Database()
 
 	// Lock at the export config level, if there are multiple batches in parallel for the same
 	// config, they should serially update the index.
-	lockID := fmt.Sprintf("export-config-%d", eb.ConfigID)
+	lockID := exportConfigLockID(eb.ConfigID)
 	sleep := 10 * time.Second
 	for {
 		if ctx.Err() != nil {
@@ -454,7 +940,7 @@ func (s *Server) retryingCreateIndex(ctx context.Context, eb *model.ExportBatch,
 			return fmt.Errorf("marking expired: %w", err)
 		}
 
-		indexName, entries, err := s.createIndex(ctx, eb, objectNames)
+		indexName, entries, err := s.createIndex(ctx, eb, objectNames, attrs)
 		if err != nil {
 			if err1 := unlock(); err1 != nil {
 				return fmt.Errorf("releasing lock: %v (original error: %w)", err1, err)
@@ -484,7 +970,7 @@ func (s *Server) markExpiredFiles(ctx context.Context, eb *model.ExportBatch) er
 	return nil
 }
 
-func (s *Server) createIndex(ctx context.Context, eb *model.ExportBatch, newObjectNames []string) (string, int, error) {
+func (s *Server) createIndex(ctx context.Context, eb *model.ExportBatch, newObjectNames []string, attrs *storage.ObjectAttrs) (string, int, error) {
 	db := s.env.Database()
 
 	objects, err := exportdatabase.New(db).LookupExportFiles(ctx, eb.ConfigID, s.config.TTL)
@@ -506,15 +992,59 @@ func (s *Server) createIndex(ctx context.Context, eb *model.ExportBatch, newObje
 	}
 	sort.Strings(objects)
 
-	data := []byte(strings.Join(objects, "\n"))
-
 	indexObjectName := exportIndexFilename(eb)
+	if err := s.writeExportIndex(ctx, eb.BucketName, indexObjectName, objects, attrs); err != nil {
+		return "", 0, err
+	}
+	if err := s.writeCBORIndex(ctx, eb, indexObjectName, objects, newObjectNames, attrs); err != nil {
+		return "", 0, err
+	}
+	return indexObjectName, len(objects), nil
+}
+
+// writeIndex writes the sorted object names as the index file. If signature
+// sidecars are enabled, their names are written to a separate index next to
+// it, so the index itself only lists export files. The index is never split
+// into pages, see writeExportIndex.
+func (s *Server) writeIndex(ctx context.Context, bucketName, indexObjectName string, objects []string, attrs *storage.ObjectAttrs) error {
+	if err := s.writeIndexFile(ctx, bucketName, indexObjectName, objects, attrs); err != nil {
+		return err
+	}
+	return s.writeSidecarIndex(ctx, bucketName, indexObjectName, objects, attrs)
+}
+
+// writeIndexFile writes the entries, one per line, as the index file.
+func (s *Server) writeIndexFile(ctx context.Context, bucketName, indexObjectName string, entries []string, attrs *storage.ObjectAttrs) error {
+	data := []byte(strings.Join(entries, "\n"))
+
 	ctx, cancel := context.WithTimeout(ctx, blobOperationTimeout)
 	defer cancel()
-	if err := s.env.Blobstore().CreateObject(ctx, eb.BucketName, indexObjectName, data, false, storage.ContentTypeTextPlain); err != nil {
-		return "", 0, fmt.Errorf("creating index file %s in bucket %s: %w", indexObjectName, eb.BucketName, err)
+	if err := s.env.Blobstore().CreateObjectWithAttrs(ctx, bucketName, indexObjectName, data, attrs); err != nil {
+		return fmt.Errorf("creating index file %s in bucket %s: %w", indexObjectName, bucketName, err)
 	}
-	return indexObjectName, len(objects), nil
+	return nil
+}
+
+// writeSidecarIndex writes the signature sidecar index of the objects next to
+// the index, if signature sidecars are enabled.
+func (s *Server) writeSidecarIndex(ctx context.Context, bucketName, indexObjectName string, objects []string, attrs *storage.ObjectAttrs) error {
+	if !s.config.SignatureSidecar {
+		return nil
+	}
+
+	sidecars := make([]string, 0, len(objects))
+	for _, o := range objects {
+		sidecars = append(sidecars, sidecarFilename(o))
+	}
+	sidecarIndex := sidecarIndexObjectName(indexObjectName)
+	data := []byte(strings.Join(sidecars, "\n"))
+
+	ctx, cancel := context.WithTimeout(ctx, blobOperationTimeout)
+	defer cancel()
+	if err := s.env.Blobstore().CreateObjectWithAttrs(ctx, bucketName, sidecarIndex, data, attrs); err != nil {
+		return fmt.Errorf("creating signature index file %s in bucket %s: %w", sidecarIndex, bucketName, err)
+	}
+	return nil
 }
 
 // The batchNum is still needed in the filename to preserve a stable filename sort
@@ -525,8 +1055,18 @@ func exportFilename(eb *model.ExportBatch, fileNum int32, regenCount int64) stri
 	return fmt.Sprintf("%s/%d-%d-%05d%s", eb.FilenameRoot, sTime, eTime, fileNum, filenameSuffix)
 }
 
+// exportConfigLockID is the lock held while writing the index for an export
+// config.
+func exportConfigLockID(configID int64) string {
+	return fmt.Sprintf("export-config-%d", configID)
+}
+
 func exportIndexFilename(eb *model.ExportBatch) string {
-	return fmt.Sprintf("%s/index.txt", eb.FilenameRoot)
+	return indexFilename(eb.FilenameRoot)
+}
+
+func indexFilename(filenameRoot string) string {
+	return fmt.Sprintf("%s/index.txt", filenameRoot)
 }
 
 // randomInt is inclusive, [min:max].
@@ -15,18 +15,36 @@
 package export
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	exportdatabase "github.com/google/exposure-notifications-server/internal/export/database"
 	"github.com/google/exposure-notifications-server/internal/export/model"
 	"github.com/google/exposure-notifications-server/internal/project"
 	publishdb "github.com/google/exposure-notifications-server/internal/publish/database"
 	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
 	"github.com/google/exposure-notifications-server/internal/serverenv"
-	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1alpha1"
+	"github.com/google/exposure-notifications-server/internal/storage"
+	hadb "github.com/google/exposure-notifications-server/internal/verification/database"
+	hamodel "github.com/google/exposure-notifications-server/internal/verification/model"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
+	"github.com/google/exposure-notifications-server/pkg/keys"
+	"github.com/google/exposure-notifications-server/pkg/render"
 	"github.com/google/exposure-notifications-server/pkg/util"
 	"github.com/google/go-cmp/cmp"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
 )
 
 func TestRandomInt(t *testing.T) {
@@ -255,7 +273,7 @@ func TestBatchExposures(t *testing.T) {
 					OnlyLocalProvenance: true,
 				}
 
-				groups, err := server.batchExposures(ctx, criteria, config.MaxRecords, "US")
+				groups, err := server.batchExposures(ctx, criteria, allKeys, config.MaxRecords, "US")
 				if err != nil {
 					t.Fatalf("failed to read exposures: %v", err)
 				}
@@ -286,7 +304,7 @@ func TestBatchExposures(t *testing.T) {
 					OnlyLocalProvenance: false,
 				}
 
-				groups, err := server.batchExposures(ctx, criteria, config.MaxRecords, "REMOTE")
+				groups, err := server.batchExposures(ctx, criteria, allKeys, config.MaxRecords, "REMOTE")
 				if err != nil {
 					t.Fatalf("failed to read exposures: %v", err)
 				}
@@ -314,7 +332,7 @@ func TestBatchExposures(t *testing.T) {
 					IncludeTravelers:    true,
 					OnlyLocalProvenance: true,
 				}
-				groups, err := server.batchExposures(ctx, criteria, config.MaxRecords, "US")
+				groups, err := server.batchExposures(ctx, criteria, allKeys, config.MaxRecords, "US")
 				if err != nil {
 					t.Fatalf("failed to read exposures: %v", err)
 				}
@@ -347,7 +365,7 @@ func TestBatchExposures(t *testing.T) {
 					OnlyNonTravelers:    true,
 					OnlyLocalProvenance: false,
 				}
-				groups, err := server.batchExposures(ctx, criteria, config.MaxRecords, "REMOTE")
+				groups, err := server.batchExposures(ctx, criteria, allKeys, config.MaxRecords, "REMOTE")
 				if err != nil {
 					t.Fatalf("failed to read exposures: %v", err)
 				}
@@ -374,6 +392,89 @@ func TestBatchExposures(t *testing.T) {
 	}
 }
 
+func TestBatchExposuresRevokedKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	testPublishDB := publishdb.New(testDB)
+
+	healthAuthority := &hamodel.HealthAuthority{
+		Issuer:   "iss",
+		Audience: "aud",
+		Name:     "name",
+	}
+	if err := hadb.New(testDB).AddHealthAuthority(ctx, healthAuthority); err != nil {
+		t.Fatalf("unable to create health authority: %v", err)
+	}
+
+	config := Config{
+		MinRecords:         1,
+		PaddingRange:       0,
+		MaxRecords:         100,
+		TruncateWindow:     time.Hour,
+		MaxInsertBatchSize: 100,
+	}
+	server := Server{
+		config: &config,
+		env:    serverenv.New(ctx, serverenv.WithDatabase(testDB)),
+	}
+
+	baseTime := time.Date(2020, 10, 28, 1, 0, 0, 0, time.UTC)
+	exposures := make([]*publishmodel.Exposure, 0, 3)
+	for i := 0; i < 3; i++ {
+		exposures = append(exposures, &publishmodel.Exposure{
+			ExposureKey:       randomTEK(t),
+			Regions:           []string{"US"},
+			IntervalNumber:    100,
+			IntervalCount:     144,
+			CreatedAt:         baseTime,
+			LocalProvenance:   true,
+			HealthAuthorityID: &healthAuthority.ID,
+			ReportType:        verifyapi.ReportTypeConfirmed,
+		})
+	}
+	if _, err := testPublishDB.InsertAndReviseExposures(ctx, &publishdb.InsertAndReviseExposuresRequest{
+		Incoming:     exposures,
+		RequireToken: false,
+	}); err != nil {
+		t.Fatalf("inserting exposures: %v", err)
+	}
+
+	revoked := exposures[1].ExposureKeyBase64()
+	if _, err := testPublishDB.RevokeExposures(ctx, healthAuthority.ID, []string{revoked}, baseTime.Add(time.Minute)); err != nil {
+		t.Fatalf("revoking exposures: %v", err)
+	}
+
+	criteria := publishdb.IterateExposuresCriteria{
+		SinceTimestamp: baseTime,
+		UntilTimestamp: baseTime.Add(time.Hour),
+		IncludeRegions: []string{"US"},
+	}
+	groups, err := server.batchExposures(ctx, criteria, allKeys, config.MaxRecords, "US")
+	if err != nil {
+		t.Fatalf("failed to read exposures: %v", err)
+	}
+
+	got := make(map[string]struct{})
+	for _, group := range groups {
+		for _, exp := range group.exposures {
+			got[exp.ExposureKeyBase64()] = struct{}{}
+		}
+		for _, exp := range group.revised {
+			got[exp.ExposureKeyBase64()] = struct{}{}
+		}
+	}
+
+	want := map[string]struct{}{
+		exposures[0].ExposureKeyBase64(): {},
+		exposures[2].ExposureKeyBase64(): {},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
 func TestVariableBatchMaxSize(t *testing.T) {
 	t.Parallel()
 
@@ -429,7 +530,7 @@ func TestVariableBatchMaxSize(t *testing.T) {
 			OnlyLocalProvenance: true,
 		}
 
-		groups, err := server.batchExposures(ctx, criteria, batchSize, "REMOTE")
+		groups, err := server.batchExposures(ctx, criteria, allKeys, batchSize, "REMOTE")
 		if err != nil {
 			t.Fatalf("failed to read exposures: %v", err)
 		}
@@ -454,6 +555,77 @@ func TestVariableBatchMaxSize(t *testing.T) {
 	}
 }
 
+func TestBatchExposuresRegionAliases(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	testPublishDB := publishdb.New(testDB)
+
+	baseTime := time.Date(2020, 10, 28, 1, 0, 0, 0, time.UTC).Truncate(time.Hour)
+	want := make(map[string]struct{})
+	exposures := make([]*publishmodel.Exposure, 0, 4)
+	for _, region := range []string{"NEW", "OLDA", "OLDB", "OTHER"} {
+		exp := &publishmodel.Exposure{
+			ExposureKey:     randomTEK(t),
+			Regions:         []string{region},
+			IntervalNumber:  100,
+			IntervalCount:   144,
+			CreatedAt:       baseTime,
+			LocalProvenance: true,
+			ReportType:      verifyapi.ReportTypeConfirmed,
+		}
+		exposures = append(exposures, exp)
+		if region != "OTHER" {
+			want[exp.ExposureKeyBase64()] = struct{}{}
+		}
+	}
+	if _, err := testPublishDB.InsertAndReviseExposures(ctx, &publishdb.InsertAndReviseExposuresRequest{
+		Incoming:     exposures,
+		RequireToken: false,
+	}); err != nil {
+		t.Fatalf("inserting exposures: %v", err)
+	}
+
+	config := Config{
+		MinRecords:         1,
+		PaddingRange:       0,
+		MaxRecords:         100,
+		TruncateWindow:     time.Hour,
+		MaxInsertBatchSize: 100,
+		RegionAliases: map[string]string{
+			"OLDA": "NEW",
+			"OLDB": "NEW",
+		},
+	}
+	server := Server{
+		config: &config,
+		env:    serverenv.New(ctx, serverenv.WithDatabase(testDB)),
+	}
+
+	criteria := publishdb.IterateExposuresCriteria{
+		SinceTimestamp:      baseTime,
+		UntilTimestamp:      baseTime.Add(time.Hour),
+		IncludeRegions:      config.ExpandRegionAliases([]string{"NEW"}),
+		OnlyLocalProvenance: true,
+	}
+	groups, err := server.batchExposures(ctx, criteria, allKeys, config.MaxRecords, "NEW")
+	if err != nil {
+		t.Fatalf("failed to read exposures: %v", err)
+	}
+
+	got := make(map[string]struct{})
+	for _, group := range groups {
+		for _, exp := range group.exposures {
+			got[exp.ExposureKeyBase64()] = struct{}{}
+		}
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("exposures mismatch (-want, +got):\n%s", diff)
+	}
+}
+
 // randomTEK is like util.RandomTEK, but handles the error from tb.
 func randomTEK(tb testing.TB) []byte {
 	tb.Helper()
@@ -522,3 +694,1170 @@ func TestExportFilename(t *testing.T) {
 		})
 	}
 }
+
+func TestDoWorkConcurrency(t *testing.T) {
+	t.Parallel()
+
+	// Two hourly batches for each of four regions. Batches for the same region
+	// can't be processed at the same time, so concurrent workers also have to
+	// wait on each other.
+	regions := []string{"US", "CA", "MX", "GB"}
+	const batchesPerRegion = 2
+	baseTime := time.Now().UTC().Add(-48 * time.Hour).Truncate(time.Hour)
+
+	// The same keys are exported in every run.
+	exposures := make([]*publishmodel.Exposure, 0, len(regions)*batchesPerRegion*3)
+	for _, region := range regions {
+		for b := 0; b < batchesPerRegion; b++ {
+			for k := 0; k < 3; k++ {
+				exposures = append(exposures, &publishmodel.Exposure{
+					ExposureKey:     randomTEK(t),
+					Regions:         []string{region},
+					IntervalNumber:  100,
+					IntervalCount:   144,
+					CreatedAt:       baseTime.Add(time.Duration(b) * time.Hour).Add(time.Duration(k) * time.Minute),
+					LocalProvenance: true,
+					ReportType:      verifyapi.ReportTypeConfirmed,
+				})
+			}
+		}
+	}
+
+	// run exports the keys with the given concurrency and returns the contents
+	// of every export file and index, by name.
+	run := func(t *testing.T, concurrency uint) map[string]interface{} {
+		t.Helper()
+
+		ctx := project.TestContext(t)
+		testDB, _ := testDatabaseInstance.NewDatabase(t)
+		exportDB := exportdatabase.New(testDB)
+
+		if _, err := publishdb.New(testDB).InsertAndReviseExposures(ctx, &publishdb.InsertAndReviseExposuresRequest{
+			Incoming:     exposures,
+			RequireToken: false,
+		}); err != nil {
+			t.Fatalf("inserting exposures: %v", err)
+		}
+
+		for _, region := range regions {
+			ec := &model.ExportConfig{
+				BucketName:   "bucket",
+				FilenameRoot: strings.ToLower(region),
+				Period:       time.Hour,
+				OutputRegion: region,
+				InputRegions: []string{region},
+				From:         baseTime,
+			}
+			if err := exportDB.AddExportConfig(ctx, ec); err != nil {
+				t.Fatal(err)
+			}
+
+			batches := make([]*model.ExportBatch, 0, batchesPerRegion)
+			for b := 0; b < batchesPerRegion; b++ {
+				batches = append(batches, &model.ExportBatch{
+					ConfigID:       ec.ConfigID,
+					BucketName:     ec.BucketName,
+					FilenameRoot:   ec.FilenameRoot,
+					StartTimestamp: baseTime.Add(time.Duration(b) * time.Hour),
+					EndTimestamp:   baseTime.Add(time.Duration(b+1) * time.Hour),
+					OutputRegion:   ec.OutputRegion,
+					InputRegions:   ec.InputRegions,
+					Status:         model.ExportBatchOpen,
+				})
+			}
+			if err := exportDB.AddExportBatches(ctx, batches); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		blobstore, err := storage.NewMemory(ctx, &storage.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		server := &Server{
+			config: &Config{
+				WorkerTimeout:     time.Minute,
+				MinRecords:        1,
+				PaddingRange:      0,
+				MaxRecords:        100,
+				TruncateWindow:    time.Hour,
+				TTL:               14 * 24 * time.Hour,
+				WorkerConcurrency: concurrency,
+			},
+			env: serverenv.New(ctx,
+				serverenv.WithDatabase(testDB),
+				serverenv.WithBlobStorage(blobstore)),
+			h: render.NewRenderer(),
+		}
+
+		r, err := http.NewRequestWithContext(ctx, http.MethodPost, "/do-work", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		server.handleDoWork().ServeHTTP(w, r)
+		if got, want := w.Code, http.StatusOK; got != want {
+			t.Fatalf("expected status %d to be %d: %s", got, want, w.Body.String())
+		}
+
+		// Every batch is complete.
+		if batch, err := exportDB.LeaseBatch(ctx, time.Minute, time.Now(), time.Now()); err != nil {
+			t.Fatal(err)
+		} else if batch != nil {
+			t.Fatalf("expected all batches to be complete, leased %#v", batch)
+		}
+
+		names, _, err := blobstore.ListObjects(ctx, "bucket", "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(names), len(regions)*(batchesPerRegion+1); got != want {
+			t.Fatalf("expected %d objects to be %d: %v", got, want, names)
+		}
+
+		contents := make(map[string]interface{}, len(names))
+		for _, name := range names {
+			b, err := blobstore.GetObject(ctx, "bucket", name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.HasSuffix(name, "/index.txt") {
+				contents[name] = string(b)
+				continue
+			}
+
+			exp, _, err := UnmarshalExportFile(b)
+			if err != nil {
+				t.Fatalf("failed to unmarshal %s: %v", name, err)
+			}
+			contents[name] = exp
+		}
+		return contents
+	}
+
+	sequential := run(t, 1)
+	concurrent := run(t, 4)
+	if diff := cmp.Diff(sequential, concurrent, protocmp.Transform()); diff != "" {
+		t.Errorf("concurrent export mismatch (-sequential, +concurrent):\n%s", diff)
+	}
+}
+
+func TestProcessBatchConcurrentWorkers(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	exportDB := exportdatabase.New(testDB)
+
+	baseTime := time.Now().UTC().Add(-48 * time.Hour).Truncate(time.Hour)
+	if _, err := publishdb.New(testDB).InsertAndReviseExposures(ctx, &publishdb.InsertAndReviseExposuresRequest{
+		Incoming: []*publishmodel.Exposure{
+			{
+				ExposureKey:     randomTEK(t),
+				Regions:         []string{"US"},
+				IntervalNumber:  100,
+				IntervalCount:   144,
+				CreatedAt:       baseTime.Add(time.Minute),
+				LocalProvenance: true,
+				ReportType:      verifyapi.ReportTypeConfirmed,
+			},
+		},
+		RequireToken: false,
+	}); err != nil {
+		t.Fatalf("inserting exposures: %v", err)
+	}
+
+	ec := &model.ExportConfig{
+		BucketName:   "bucket",
+		FilenameRoot: "us",
+		Period:       time.Hour,
+		OutputRegion: "US",
+		InputRegions: []string{"US"},
+		From:         baseTime,
+	}
+	if err := exportDB.AddExportConfig(ctx, ec); err != nil {
+		t.Fatal(err)
+	}
+	if err := exportDB.AddExportBatches(ctx, []*model.ExportBatch{
+		{
+			ConfigID:       ec.ConfigID,
+			BucketName:     ec.BucketName,
+			FilenameRoot:   ec.FilenameRoot,
+			StartTimestamp: baseTime,
+			EndTimestamp:   baseTime.Add(time.Hour),
+			OutputRegion:   ec.OutputRegion,
+			InputRegions:   ec.InputRegions,
+			Status:         model.ExportBatchOpen,
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	batch, err := exportDB.LeaseBatch(ctx, time.Minute, time.Now(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batch == nil {
+		t.Fatal("expected a batch to lease")
+	}
+
+	memory, err := storage.NewMemory(ctx, &storage.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocking := &blockingBlobstore{
+		Blobstore: memory,
+		blocked:   make(chan struct{}),
+		release:   make(chan struct{}),
+	}
+
+	// Two instances of the export worker run at the same time, as with a cron
+	// job misconfigured to run on several nodes. They only share the database
+	// and the storage.
+	newServer := func(blobstore storage.Blobstore) *Server {
+		return &Server{
+			config: &Config{
+				WorkerTimeout:  time.Minute,
+				MinRecords:     1,
+				MaxRecords:     100,
+				TruncateWindow: time.Hour,
+				TTL:            14 * 24 * time.Hour,
+			},
+			env: serverenv.New(ctx,
+				serverenv.WithDatabase(testDB),
+				serverenv.WithBlobStorage(blobstore)),
+		}
+	}
+	first, second := newServer(blocking), newServer(memory)
+
+	type result struct {
+		files *exportFiles
+		err   error
+	}
+	firstResult := make(chan result, 1)
+	go func() {
+		files, err := first.processBatch(ctx, batch, newIndexTracker())
+		firstResult <- result{files, err}
+	}()
+
+	// The first worker holds the locks while it writes the export file.
+	select {
+	case <-blocking.blocked:
+	case r := <-firstResult:
+		t.Fatalf("expected the first worker to be writing, it finished: %v", r.err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the first worker to write")
+	}
+
+	files, err := second.processBatch(ctx, batch, newIndexTracker())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files != nil {
+		t.Fatalf("expected the second worker to back off, wrote %v", files.objectNames)
+	}
+
+	close(blocking.release)
+	got := <-firstResult
+	if got.err != nil {
+		t.Fatal(got.err)
+	}
+	if got.files == nil || len(got.files.objectNames) != 1 {
+		t.Fatalf("expected the first worker to write one file, got %#v", got.files)
+	}
+
+	names, _, err := memory.ListObjects(ctx, "bucket", "us/", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exported []string
+	for _, name := range names {
+		if strings.HasSuffix(name, filenameSuffix) {
+			exported = append(exported, name)
+		}
+	}
+	if diff := cmp.Diff(got.files.objectNames, exported); diff != "" {
+		t.Errorf("exported files mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+// blockingBlobstore blocks the first object written until release is closed.
+// blocked is closed once the write has started.
+type blockingBlobstore struct {
+	storage.Blobstore
+
+	once    sync.Once
+	blocked chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingBlobstore) CreateObjectWithAttrs(ctx context.Context, parent, name string, contents []byte, attrs *storage.ObjectAttrs) error {
+	b.once.Do(func() {
+		close(b.blocked)
+		<-b.release
+	})
+	return b.Blobstore.CreateObjectWithAttrs(ctx, parent, name, contents, attrs)
+}
+
+func TestExportObjectAttrs(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		ec        *model.ExportConfig
+		wantFile  *storage.ObjectAttrs
+		wantIndex *storage.ObjectAttrs
+	}{
+		{
+			name: "defaults",
+			ec:   &model.ExportConfig{},
+			wantFile: &storage.ObjectAttrs{
+				ContentType:  storage.ContentTypeZip,
+				CacheControl: storage.CacheControlCacheable,
+			},
+			wantIndex: &storage.ObjectAttrs{
+				ContentType:  storage.ContentTypeTextPlain,
+				CacheControl: storage.CacheControlNoCache,
+			},
+		},
+		{
+			name: "configured",
+			ec: &model.ExportConfig{
+				CacheControl:       "public, max-age=3600",
+				IndexCacheControl:  "public, max-age=60",
+				ContentDisposition: "attachment",
+			},
+			wantFile: &storage.ObjectAttrs{
+				ContentType:        storage.ContentTypeZip,
+				CacheControl:       "public, max-age=3600",
+				ContentDisposition: "attachment",
+			},
+			wantIndex: &storage.ObjectAttrs{
+				ContentType:  storage.ContentTypeTextPlain,
+				CacheControl: "public, max-age=60",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if diff := cmp.Diff(tc.wantFile, exportFileAttrs(tc.ec)); diff != "" {
+				t.Errorf("export file mismatch (-want, +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.wantIndex, indexFileAttrs(tc.ec)); diff != "" {
+				t.Errorf("index file mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDoWorkObjectAttrs(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	exportDB := exportdatabase.New(testDB)
+	baseTime := time.Now().UTC().Add(-48 * time.Hour).Truncate(time.Hour)
+
+	// The US config has its own headers, the CA config uses the defaults.
+	configs := map[string]*model.ExportConfig{
+		"US": {
+			CacheControl:       "public, max-age=3600",
+			IndexCacheControl:  "public, max-age=60",
+			ContentDisposition: "attachment",
+		},
+		"CA": {},
+	}
+
+	exposures := make([]*publishmodel.Exposure, 0, len(configs))
+	for region, ec := range configs {
+		exposures = append(exposures, &publishmodel.Exposure{
+			ExposureKey:     randomTEK(t),
+			Regions:         []string{region},
+			IntervalNumber:  100,
+			IntervalCount:   144,
+			CreatedAt:       baseTime.Add(time.Minute),
+			LocalProvenance: true,
+			ReportType:      verifyapi.ReportTypeConfirmed,
+		})
+
+		ec.BucketName = "bucket"
+		ec.FilenameRoot = strings.ToLower(region)
+		ec.Period = time.Hour
+		ec.OutputRegion = region
+		ec.InputRegions = []string{region}
+		ec.From = baseTime
+		if err := exportDB.AddExportConfig(ctx, ec); err != nil {
+			t.Fatal(err)
+		}
+		if err := exportDB.AddExportBatches(ctx, []*model.ExportBatch{
+			{
+				ConfigID:       ec.ConfigID,
+				BucketName:     ec.BucketName,
+				FilenameRoot:   ec.FilenameRoot,
+				StartTimestamp: baseTime,
+				EndTimestamp:   baseTime.Add(time.Hour),
+				OutputRegion:   ec.OutputRegion,
+				InputRegions:   ec.InputRegions,
+				Status:         model.ExportBatchOpen,
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := publishdb.New(testDB).InsertAndReviseExposures(ctx, &publishdb.InsertAndReviseExposuresRequest{
+		Incoming:     exposures,
+		RequireToken: false,
+	}); err != nil {
+		t.Fatalf("inserting exposures: %v", err)
+	}
+
+	blobstore, err := storage.NewMemory(ctx, &storage.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &Server{
+		config: &Config{
+			WorkerTimeout:  time.Minute,
+			MinRecords:     1,
+			MaxRecords:     100,
+			TruncateWindow: time.Hour,
+			TTL:            14 * 24 * time.Hour,
+		},
+		env: serverenv.New(ctx,
+			serverenv.WithDatabase(testDB),
+			serverenv.WithBlobStorage(blobstore)),
+		h: render.NewRenderer(),
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, "/do-work", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	server.handleDoWork().ServeHTTP(w, r)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("expected status %d to be %d: %s", got, want, w.Body.String())
+	}
+
+	memory := blobstore.(*storage.Memory)
+	for region, ec := range configs {
+		names, _, err := blobstore.ListObjects(ctx, "bucket", ec.FilenameRoot+"/", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(names), 2; got != want {
+			t.Fatalf("expected %d objects for %s, got %v", want, region, names)
+		}
+
+		for _, name := range names {
+			got, err := memory.Attrs("bucket", name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := exportFileAttrs(ec)
+			if name == indexFilename(ec.FilenameRoot) {
+				want = indexFileAttrs(ec)
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("%s mismatch (-want, +got):\n%s", name, diff)
+			}
+		}
+	}
+}
+
+func TestDoWorkRegionSignatureInfos(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	exportDB := exportdatabase.New(testDB)
+	baseTime := time.Now().UTC().Add(-48 * time.Hour).Truncate(time.Hour)
+
+	// Each key is in the key manager and has its own signature info.
+	root := t.TempDir()
+	kms, err := keys.NewFilesystem(ctx, &keys.Config{FilesystemRoot: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKeys := make(map[string]*ecdsa.PublicKey)
+	sigInfos := make(map[string]*model.SignatureInfo)
+	for _, name := range []string{"default-key", "ca-key"} {
+		publicKeys[name] = writeTestSigningKey(t, root, name)
+		si := &model.SignatureInfo{
+			SigningKey:        name,
+			SigningKeyID:      name,
+			SigningKeyVersion: "1",
+		}
+		if err := exportDB.AddSignatureInfo(ctx, si); err != nil {
+			t.Fatal(err)
+		}
+		sigInfos[name] = si
+	}
+
+	blobstore, err := storage.NewMemory(ctx, &storage.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &Server{
+		config: &Config{
+			WorkerTimeout:  time.Minute,
+			MinRecords:     1,
+			MaxRecords:     100,
+			TruncateWindow: time.Hour,
+			TTL:            14 * 24 * time.Hour,
+		},
+		env: serverenv.New(ctx,
+			serverenv.WithDatabase(testDB),
+			serverenv.WithBlobStorage(blobstore),
+			serverenv.WithKeyManager(kms)),
+		h: render.NewRenderer(),
+	}
+
+	// Both configs are set up with the default key and map CA to its own key,
+	// so only CA must be signed with it. The lower case region must not match.
+	regions := map[string]string{"US": "default-key", "CA": "ca-key"}
+	exposures := make([]*publishmodel.Exposure, 0, len(regions))
+	for region := range regions {
+		exposures = append(exposures, &publishmodel.Exposure{
+			ExposureKey:     randomTEK(t),
+			Regions:         []string{region},
+			IntervalNumber:  100,
+			IntervalCount:   144,
+			CreatedAt:       baseTime.Add(time.Minute),
+			LocalProvenance: true,
+			ReportType:      verifyapi.ReportTypeConfirmed,
+		})
+
+		ec := &model.ExportConfig{
+			BucketName:       "bucket",
+			FilenameRoot:     strings.ToLower(region),
+			Period:           time.Hour,
+			OutputRegion:     region,
+			InputRegions:     []string{region},
+			From:             baseTime,
+			SignatureInfoIDs: []int64{sigInfos["default-key"].ID},
+			RegionSignatureInfos: map[string]int64{
+				"CA": sigInfos["ca-key"].ID,
+				"us": sigInfos["ca-key"].ID,
+			},
+		}
+		if err := exportDB.AddExportConfig(ctx, ec); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.maybeCreateBatches(ctx, ec, baseTime.Add(90*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := publishdb.New(testDB).InsertAndReviseExposures(ctx, &publishdb.InsertAndReviseExposuresRequest{
+		Incoming:     exposures,
+		RequireToken: false,
+	}); err != nil {
+		t.Fatalf("inserting exposures: %v", err)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, "/do-work", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	server.handleDoWork().ServeHTTP(w, r)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("expected status %d to be %d: %s", got, want, w.Body.String())
+	}
+
+	for region, keyName := range regions {
+		names, _, err := blobstore.ListObjects(ctx, "bucket", strings.ToLower(region)+"/", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exported := 0
+		for _, name := range names {
+			if !strings.HasSuffix(name, filenameSuffix) {
+				continue
+			}
+			exported++
+
+			data, err := blobstore.GetObject(ctx, "bucket", name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sigs, err := UnmarshalSignatureFile(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := len(sigs.Signatures), 1; got != want {
+				t.Fatalf("expected %d signatures in %q, got %d", want, name, got)
+			}
+			sig := sigs.Signatures[0]
+			if got, want := sig.GetSignatureInfo().GetVerificationKeyId(), keyName; got != want {
+				t.Errorf("expected %s key id %q to be %q", region, got, want)
+			}
+			digest := sha256.Sum256(readTestZipFile(t, data, exportBinaryName))
+			if !ecdsa.VerifyASN1(publicKeys[keyName], digest[:], sig.Signature) {
+				t.Errorf("expected %s export %q to be signed by %s", region, name, keyName)
+			}
+		}
+		if exported == 0 {
+			t.Errorf("expected export files for %s, got %v", region, names)
+		}
+	}
+}
+
+// writeTestSigningKey writes a new signing key for the filesystem key manager
+// rooted at root, and returns its public key.
+func writeTestSigningKey(tb testing.TB, root, name string) *ecdsa.PublicKey {
+	tb.Helper()
+
+	key := newTestSigner(tb, name, "1").Signer.(*ecdsa.PrivateKey)
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, name), der, 0o600); err != nil {
+		tb.Fatal(err)
+	}
+	return &key.PublicKey
+}
+
+// checkEmptyExportFile checks that the export file has no keys and is signed
+// with the public key.
+func checkEmptyExportFile(tb testing.TB, data []byte, publicKey *ecdsa.PublicKey) {
+	tb.Helper()
+
+	exp, _, err := UnmarshalExportFile(data)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if got := len(exp.Keys) + len(exp.RevisedKeys); got != 0 {
+		tb.Errorf("expected no keys, got %d", got)
+	}
+	sigs, err := UnmarshalSignatureFile(data)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if got, want := len(sigs.Signatures), 1; got != want {
+		tb.Fatalf("expected %d signatures, got %d", want, got)
+	}
+	digest := sha256.Sum256(readTestZipFile(tb, data, exportBinaryName))
+	if !ecdsa.VerifyASN1(publicKey, digest[:], sigs.Signatures[0].Signature) {
+		tb.Errorf("expected the empty export file to be signed")
+	}
+}
+
+func TestWriteEmptyBatchMarker(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	root := t.TempDir()
+	kms, err := keys.NewFilesystem(ctx, &keys.Config{FilesystemRoot: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := writeTestSigningKey(t, root, "key")
+
+	blobstore, err := storage.NewMemory(ctx, &storage.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &Server{
+		config: &Config{MinRecords: 1000, PaddingRange: 100, ExportEmptyBatches: true},
+		env: serverenv.New(ctx,
+			serverenv.WithBlobStorage(blobstore),
+			serverenv.WithKeyManager(kms)),
+	}
+
+	eb := &model.ExportBatch{
+		BatchID:        1,
+		BucketName:     "bucket",
+		FilenameRoot:   "us",
+		OutputRegion:   "US",
+		StartTimestamp: time.Unix(1600000000, 0).UTC(),
+		EndTimestamp:   time.Unix(1600003600, 0).UTC(),
+	}
+	sigInfos := []*model.SignatureInfo{{SigningKey: "key", SigningKeyID: "key", SigningKeyVersion: "1"}}
+	files, err := server.writeEmptyBatchMarker(ctx, eb, sigInfos, storage.DefaultObjectAttrs(true, storage.ContentTypeZip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(files.objectNames), 1; got != want {
+		t.Fatalf("expected %d files, got %v", want, files.objectNames)
+	}
+	if files.numKeys != 0 || files.numRevisedKeys != 0 {
+		t.Errorf("expected no keys, got %d keys and %d revised keys", files.numKeys, files.numRevisedKeys)
+	}
+
+	data, err := blobstore.GetObject(ctx, "bucket", files.objectNames[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEmptyExportFile(t, data, publicKey)
+}
+
+func TestDoWorkEmptyBatches(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		enabled bool
+		stream  bool
+	}{
+		{name: "disabled"},
+		{name: "enabled", enabled: true},
+		{name: "enabled_stream", enabled: true, stream: true},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := project.TestContext(t)
+			testDB, _ := testDatabaseInstance.NewDatabase(t)
+			exportDB := exportdatabase.New(testDB)
+			baseTime := time.Now().UTC().Add(-48 * time.Hour).Truncate(time.Hour)
+
+			root := t.TempDir()
+			kms, err := keys.NewFilesystem(ctx, &keys.Config{FilesystemRoot: root})
+			if err != nil {
+				t.Fatal(err)
+			}
+			publicKey := writeTestSigningKey(t, root, "key")
+			si := &model.SignatureInfo{
+				SigningKey:        "key",
+				SigningKeyID:      "key",
+				SigningKeyVersion: "1",
+			}
+			if err := exportDB.AddSignatureInfo(ctx, si); err != nil {
+				t.Fatal(err)
+			}
+
+			ec := &model.ExportConfig{
+				BucketName:       "bucket",
+				FilenameRoot:     "us",
+				Period:           time.Hour,
+				OutputRegion:     "US",
+				InputRegions:     []string{"US"},
+				From:             baseTime,
+				SignatureInfoIDs: []int64{si.ID},
+			}
+			if err := exportDB.AddExportConfig(ctx, ec); err != nil {
+				t.Fatal(err)
+			}
+			if err := exportDB.AddExportBatches(ctx, []*model.ExportBatch{
+				{
+					ConfigID:         ec.ConfigID,
+					BucketName:       ec.BucketName,
+					FilenameRoot:     ec.FilenameRoot,
+					StartTimestamp:   baseTime,
+					EndTimestamp:     baseTime.Add(time.Hour),
+					OutputRegion:     ec.OutputRegion,
+					InputRegions:     ec.InputRegions,
+					Status:           model.ExportBatchOpen,
+					SignatureInfoIDs: ec.SignatureInfoIDs,
+				},
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			blobstore, err := storage.NewMemory(ctx, &storage.Config{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Padding is configured, but the batch has no keys to pad.
+			server := &Server{
+				config: &Config{
+					WorkerTimeout:      time.Minute,
+					MinRecords:         1000,
+					PaddingRange:       100,
+					MaxRecords:         10000,
+					TruncateWindow:     time.Hour,
+					TTL:                14 * 24 * time.Hour,
+					StreamExportFiles:  tc.stream,
+					ExportEmptyBatches: tc.enabled,
+				},
+				env: serverenv.New(ctx,
+					serverenv.WithDatabase(testDB),
+					serverenv.WithBlobStorage(blobstore),
+					serverenv.WithKeyManager(kms)),
+				h: render.NewRenderer(),
+			}
+
+			r, err := http.NewRequestWithContext(ctx, http.MethodPost, "/do-work", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			server.handleDoWork().ServeHTTP(w, r)
+			if got, want := w.Code, http.StatusOK; got != want {
+				t.Fatalf("expected status %d to be %d: %s", got, want, w.Body.String())
+			}
+
+			files, err := exportDB.LookupExportFiles(ctx, ec.ConfigID, server.config.TTL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tc.enabled {
+				if len(files) != 0 {
+					t.Errorf("expected no export files, got %v", files)
+				}
+				return
+			}
+			if got, want := len(files), 1; got != want {
+				t.Fatalf("expected %d export files, got %v", want, files)
+			}
+
+			index, err := blobstore.GetObject(ctx, "bucket", indexFilename(ec.FilenameRoot))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := strings.TrimSpace(string(index)), files[0]; got != want {
+				t.Errorf("expected index %q to list %q", got, want)
+			}
+
+			data, err := blobstore.GetObject(ctx, "bucket", files[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkEmptyExportFile(t, data, publicKey)
+		})
+	}
+}
+
+func TestDoWorkActivationDelay(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	exportDB := exportdatabase.New(testDB)
+	now := time.Now().UTC().Truncate(time.Minute)
+
+	ec := &model.ExportConfig{
+		BucketName:   "bucket",
+		FilenameRoot: "us",
+		Period:       time.Hour,
+		OutputRegion: "US",
+		InputRegions: []string{"US"},
+		From:         now.Add(-4 * time.Hour),
+	}
+	if err := exportDB.AddExportConfig(ctx, ec); err != nil {
+		t.Fatal(err)
+	}
+
+	// An older batch, and one that ended just now with a just published key.
+	starts := []time.Time{now.Add(-4 * time.Hour), now.Add(-1 * time.Hour)}
+	batches := make([]*model.ExportBatch, 0, len(starts))
+	exposures := make([]*publishmodel.Exposure, 0, len(starts))
+	for _, start := range starts {
+		batches = append(batches, &model.ExportBatch{
+			ConfigID:       ec.ConfigID,
+			BucketName:     ec.BucketName,
+			FilenameRoot:   ec.FilenameRoot,
+			StartTimestamp: start,
+			EndTimestamp:   start.Add(59 * time.Minute),
+			OutputRegion:   ec.OutputRegion,
+			InputRegions:   ec.InputRegions,
+			Status:         model.ExportBatchOpen,
+		})
+		exposures = append(exposures, &publishmodel.Exposure{
+			ExposureKey:     randomTEK(t),
+			Regions:         []string{"US"},
+			IntervalNumber:  100,
+			IntervalCount:   144,
+			CreatedAt:       start.Add(30 * time.Minute),
+			LocalProvenance: true,
+			ReportType:      verifyapi.ReportTypeConfirmed,
+		})
+	}
+	if err := exportDB.AddExportBatches(ctx, batches); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := publishdb.New(testDB).InsertAndReviseExposures(ctx, &publishdb.InsertAndReviseExposuresRequest{
+		Incoming:     exposures,
+		RequireToken: false,
+	}); err != nil {
+		t.Fatalf("inserting exposures: %v", err)
+	}
+
+	blobstore, err := storage.NewMemory(ctx, &storage.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &Server{
+		config: &Config{
+			WorkerTimeout:         time.Minute,
+			MinRecords:            1,
+			MaxRecords:            100,
+			TruncateWindow:        time.Hour,
+			TTL:                   14 * 24 * time.Hour,
+			ExportActivationDelay: 30 * time.Minute,
+		},
+		env: serverenv.New(ctx,
+			serverenv.WithDatabase(testDB),
+			serverenv.WithBlobStorage(blobstore)),
+		h: render.NewRenderer(),
+	}
+
+	// exportedKeys runs the worker and returns the keys in all export files.
+	exportedKeys := func(t *testing.T) [][]byte {
+		t.Helper()
+
+		r, err := http.NewRequestWithContext(ctx, http.MethodPost, "/do-work", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		server.handleDoWork().ServeHTTP(w, r)
+		if got, want := w.Code, http.StatusOK; got != want {
+			t.Fatalf("expected status %d to be %d: %s", got, want, w.Body.String())
+		}
+
+		names, _, err := blobstore.ListObjects(ctx, "bucket", "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var keys [][]byte
+		for _, name := range names {
+			if name == indexFilename(ec.FilenameRoot) {
+				continue
+			}
+			b, err := blobstore.GetObject(ctx, "bucket", name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			exp, _, err := UnmarshalExportFile(b)
+			if err != nil {
+				t.Fatalf("failed to unmarshal %s: %v", name, err)
+			}
+			for _, k := range exp.Keys {
+				keys = append(keys, k.KeyData)
+			}
+		}
+		return keys
+	}
+
+	// The just published key is held back.
+	if diff := cmp.Diff([][]byte{exposures[0].ExposureKey}, exportedKeys(t)); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Once the delay has elapsed, it is exported.
+	server.config.ExportActivationDelay = 0
+	if diff := cmp.Diff([][]byte{exposures[0].ExposureKey, exposures[1].ExposureKey}, exportedKeys(t)); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestDoWorkExportRuns(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	exportDB := exportdatabase.New(testDB)
+
+	eb, _ := insertStreamTestExposures(ctx, t, testDB, 3, 1)
+
+	ec := &model.ExportConfig{
+		BucketName:   eb.BucketName,
+		FilenameRoot: eb.FilenameRoot,
+		Period:       time.Hour,
+		OutputRegion: eb.OutputRegion,
+		InputRegions: eb.InputRegions,
+		From:         eb.StartTimestamp,
+	}
+	if err := exportDB.AddExportConfig(ctx, ec); err != nil {
+		t.Fatal(err)
+	}
+	eb.ConfigID = ec.ConfigID
+	eb.Status = model.ExportBatchOpen
+	if err := exportDB.AddExportBatches(ctx, []*model.ExportBatch{eb}); err != nil {
+		t.Fatal(err)
+	}
+
+	blobstore, err := storage.NewMemory(ctx, &storage.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &Server{
+		config: &Config{
+			WorkerTimeout:      time.Minute,
+			MinRecords:         1,
+			MaxRecords:         100,
+			MaxInsertBatchSize: 100,
+			TruncateWindow:     time.Hour,
+			TTL:                14 * 24 * time.Hour,
+		},
+		env: serverenv.New(ctx,
+			serverenv.WithDatabase(testDB),
+			serverenv.WithBlobStorage(blobstore)),
+		h: render.NewRenderer(),
+	}
+
+	before := time.Now()
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, "/do-work", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	server.handleDoWork().ServeHTTP(w, r)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("expected status %d to be %d: %s", got, want, w.Body.String())
+	}
+
+	runs, err := exportDB.ListExportRuns(ctx, ec.ConfigID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(runs), 1; got != want {
+		t.Fatalf("expected %d runs, got %d", want, got)
+	}
+
+	run := runs[0]
+	want := &model.ExportRun{
+		RunID:       run.RunID,
+		ConfigID:    ec.ConfigID,
+		StartedAt:   run.StartedAt,
+		FinishedAt:  run.FinishedAt,
+		Batches:     1,
+		Keys:        3,
+		RevisedKeys: 1,
+		Outcome:     model.ExportRunSuccess,
+	}
+	if diff := cmp.Diff(want, run); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+	if run.StartedAt.Before(before.Add(-time.Second)) || run.FinishedAt.Before(run.StartedAt) {
+		t.Errorf("expected run to start after %v and finish after it started, got %v - %v", before, run.StartedAt, run.FinishedAt)
+	}
+}
+
+func TestRunTracker(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	runs := newRunTracker(start)
+	runs.addBatch(2, &exportFiles{numKeys: 10, numRevisedKeys: 1})
+	runs.addBatch(1, &exportFiles{numKeys: 7})
+	runs.addBatch(2, &exportFiles{numKeys: 5, numRevisedKeys: 2})
+	runs.addFailure(1, fmt.Errorf("failed to process batch 4/1: %w", errBatchTimeout))
+
+	end := start.Add(time.Minute)
+	want := []*model.ExportRun{
+		{
+			ConfigID:   1,
+			StartedAt:  start,
+			FinishedAt: end,
+			Batches:    2,
+			Keys:       7,
+			Outcome:    model.ExportRunFailure,
+			Error:      "failed to process batch 4/1: timed out writing export files",
+		},
+		{
+			ConfigID:    2,
+			StartedAt:   start,
+			FinishedAt:  end,
+			Batches:     2,
+			Keys:        15,
+			RevisedKeys: 3,
+			Outcome:     model.ExportRunSuccess,
+		},
+	}
+	if diff := cmp.Diff(want, runs.list(end)); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestInputRegions(t *testing.T) {
+	t.Parallel()
+
+	normalizer, err := publishmodel.NewRegionNormalizer(map[string]string{"CALIFORNIA": "US-CA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		config: &Config{
+			RegionAliases: map[string]string{"US-CA-OLD": "US-CA"},
+		},
+		regionNormalizer: normalizer,
+	}
+
+	eb := &model.ExportBatch{
+		OutputRegion: "US-CA",
+		InputRegions: []string{"us-ca ", "California", "US-WA"},
+	}
+	want := []string{"US-CA", "US-WA", "US-CA-OLD"}
+	if diff := cmp.Diff(want, s.inputRegions(eb)); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestReportTypeFilter(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		eb   *model.ExportBatch
+		want *publishdb.ReportTypeFilter
+	}{
+		{
+			name: "unset",
+			eb:   &model.ExportBatch{DefaultReportType: verifyapi.ReportTypeConfirmed},
+		},
+		{
+			name: "allowed",
+			eb:   &model.ExportBatch{ReportTypes: []string{verifyapi.ReportTypeConfirmed}},
+			want: &publishdb.ReportTypeFilter{Allowed: []string{verifyapi.ReportTypeConfirmed}},
+		},
+		{
+			name: "include_unknown",
+			eb: &model.ExportBatch{
+				ReportTypes:              []string{verifyapi.ReportTypeConfirmed},
+				IncludeUnknownReportType: true,
+			},
+			want: &publishdb.ReportTypeFilter{Allowed: []string{verifyapi.ReportTypeConfirmed}, IncludeUnknown: true},
+		},
+		{
+			name: "allowed_default",
+			eb: &model.ExportBatch{
+				ReportTypes:       []string{verifyapi.ReportTypeConfirmed, verifyapi.ReportTypeClinical},
+				DefaultReportType: verifyapi.ReportTypeClinical,
+			},
+			want: &publishdb.ReportTypeFilter{Allowed: []string{verifyapi.ReportTypeConfirmed, verifyapi.ReportTypeClinical}, IncludeUnknown: true},
+		},
+		{
+			name: "excluded_default",
+			eb: &model.ExportBatch{
+				ReportTypes:       []string{verifyapi.ReportTypeConfirmed},
+				DefaultReportType: verifyapi.ReportTypeSelfReport,
+			},
+			want: &publishdb.ReportTypeFilter{Allowed: []string{verifyapi.ReportTypeConfirmed}},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if diff := cmp.Diff(tc.want, reportTypeFilter(tc.eb)); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
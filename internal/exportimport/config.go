@@ -17,10 +17,13 @@ package exportimport
 import (
 	"time"
 
+	"github.com/google/exposure-notifications-server/internal/httpclient"
+	"github.com/google/exposure-notifications-server/internal/middleware"
 	"github.com/google/exposure-notifications-server/internal/setup"
 	"github.com/google/exposure-notifications-server/pkg/database"
 	"github.com/google/exposure-notifications-server/pkg/observability"
 	"github.com/google/exposure-notifications-server/pkg/secrets"
+	"github.com/google/exposure-notifications-server/pkg/server"
 )
 
 var (
@@ -33,6 +36,9 @@ type Config struct {
 	Database              database.Config
 	ObservabilityExporter observability.Config
 	SecretManager         secrets.Config
+	HTTPClient            httpclient.Config
+	TLS                   server.TLSConfig
+	SecurityHeaders       middleware.SecurityHeadersConfig
 
 	Port string `env:"PORT, default=8080"`
 
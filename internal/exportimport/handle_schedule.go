@@ -36,6 +36,10 @@ import (
 
 const schedulerLockID = "import-scheduler-lock"
 
+// indexPageSuffix is the suffix of the index pages that an index file links
+// to. The other entries are zip files.
+const indexPageSuffix = ".txt"
+
 func (s *Server) handleSchedule() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -101,27 +105,12 @@ func (s *Server) syncOne(ctx context.Context, cfg *model.ExportImport) error {
 	logger.Debugw("syncing index file")
 	defer logger.Debugw("finished syncing index file")
 
-	client := &http.Client{
-		Timeout: s.config.IndexFileDownloadTimeout,
-	}
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cfg.IndexFile, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request to download index file: %w", err)
-	}
-
-	resp, err := client.Do(req)
+	index, err := s.downloadIndex(ctx, cfg)
 	if err != nil {
-		return fmt.Errorf("failed to download index file: %w", err)
+		return err
 	}
-	defer resp.Body.Close()
 
-	bytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read index file: %w", err)
-	}
-
-	numNew, numFailed, err := syncFilesFromIndex(ctx, s.exportImportDB, cfg, string(bytes))
+	numNew, numFailed, err := syncFilesFromIndex(ctx, s.exportImportDB, cfg, index)
 	if err != nil {
 		return fmt.Errorf("failed to sync index file contents: %w", err)
 	}
@@ -131,33 +120,99 @@ func (s *Server) syncOne(ctx context.Context, cfg *model.ExportImport) error {
 	return nil
 }
 
+// downloadIndex downloads the index file and the older index pages it links
+// to, and returns the entries of all of them, one per line. An index that is
+// split into pages ends with the name of the next older page, which ends in
+// .txt and is relative to the export root like the zip files. The oldest
+// pages are deleted once their files expire, so a page that doesn't exist
+// ends the chain.
+func (s *Server) downloadIndex(ctx context.Context, cfg *model.ExportImport) (string, error) {
+	var entries []string
+	seen := make(map[string]struct{})
+	for u := cfg.IndexFile; u != ""; {
+		if _, ok := seen[u]; ok {
+			return "", fmt.Errorf("index page %s is listed twice", u)
+		}
+		primary := len(seen) == 0
+		seen[u] = struct{}{}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to create request to download index file: %w", err)
+		}
+
+		resp, err := s.indexClient.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("failed to download index file: %w", err)
+		}
+		bytes, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return "", fmt.Errorf("failed to read index file: %w", err)
+		}
+		if !primary && resp.StatusCode == http.StatusNotFound {
+			break
+		}
+		if !primary && resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("failed to download index page %s: status %d", u, resp.StatusCode)
+		}
+
+		u = ""
+		for _, line := range strings.Split(string(bytes), "\n") {
+			name := project.TrimSpaceAndNonPrintable(line)
+			if name == "" {
+				continue
+			}
+			if strings.HasSuffix(name, indexPageSuffix) {
+				if u, err = archiveURL(cfg, name); err != nil {
+					return "", err
+				}
+				continue
+			}
+			entries = append(entries, name)
+		}
+	}
+	return strings.Join(entries, "\n"), nil
+}
+
 func buildArchiveURLs(ctx context.Context, config *model.ExportImport, index string) ([]string, error) {
 	zipNames := strings.Split(index, "\n")
 	currentFiles := make([]string, 0, len(zipNames))
 	for _, zipFile := range zipNames {
-		if len(project.TrimSpaceAndNonPrintable(zipFile)) == 0 {
-			// drop blank lines.
+		zipFile = project.TrimSpaceAndNonPrintable(zipFile)
+		if len(zipFile) == 0 || strings.HasSuffix(zipFile, indexPageSuffix) {
+			// drop blank lines and links to index pages.
 			continue
 		}
 
-		// Parse the export root to see if there is a defined path element.
-		base, err := url.Parse(config.ExportRoot)
-		if err != nil {
-			return nil, fmt.Errorf("config.ExportRoot is invalid: %s: %w", config.ExportRoot, err)
-		}
-		base.Path = path.Join(base.Path, "/", project.TrimSpaceAndNonPrintable(zipFile))
-		proposedURL := base.String()
-		// Re-parse combined URL in case there are issues with the filename in the index file.
-		url, err := url.Parse(proposedURL)
+		url, err := archiveURL(config, zipFile)
 		if err != nil {
-			return nil, fmt.Errorf("invalid URL constructed: %s: %w", proposedURL, err)
+			return nil, err
 		}
-		url.Path = path.Clean(url.Path)
-		currentFiles = append(currentFiles, url.String())
+		currentFiles = append(currentFiles, url)
 	}
 	return currentFiles, nil
 }
 
+// archiveURL returns the URL of a file listed in the index, relative to the
+// export root.
+func archiveURL(config *model.ExportImport, name string) (string, error) {
+	// Parse the export root to see if there is a defined path element.
+	base, err := url.Parse(config.ExportRoot)
+	if err != nil {
+		return "", fmt.Errorf("config.ExportRoot is invalid: %s: %w", config.ExportRoot, err)
+	}
+	base.Path = path.Join(base.Path, "/", name)
+	proposedURL := base.String()
+	// Re-parse combined URL in case there are issues with the filename in the index file.
+	u, err := url.Parse(proposedURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL constructed: %s: %w", proposedURL, err)
+	}
+	u.Path = path.Clean(u.Path)
+	return u.String(), nil
+}
+
 func syncFilesFromIndex(ctx context.Context, db *exportimportdb.ExportImportDB, config *model.ExportImport, index string) (int, int, error) {
 	currentFiles, err := buildArchiveURLs(ctx, config, index)
 	if err != nil {
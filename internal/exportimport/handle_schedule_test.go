@@ -16,6 +16,8 @@ package exportimport
 
 import (
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
@@ -54,6 +56,51 @@ func TestSyncFileFromIndexErrorsInExportRoot(t *testing.T) {
 	errcmp.MustMatch(t, err, "invalid URL escape")
 }
 
+func TestDownloadIndex(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/exports/index.txt":
+			fmt.Fprintf(w, "us/5.zip\nus/index-2.txt\n")
+		case "/exports/us/index-2.txt":
+			fmt.Fprintf(w, "us/3.zip\nus/4.zip\nus/index-1.txt\n")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	s := &Server{indexClient: ts.Client()}
+	config := &model.ExportImport{
+		IndexFile:  ts.URL + "/exports/index.txt",
+		ExportRoot: ts.URL + "/exports",
+	}
+
+	index, err := s.downloadIndex(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := index, "us/5.zip\nus/3.zip\nus/4.zip"; got != want {
+		t.Errorf("expected index %q to be %q", got, want)
+	}
+
+	got, err := buildArchiveURLs(ctx, config, index+"\nus/index-1.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		ts.URL + "/exports/us/5.zip",
+		ts.URL + "/exports/us/3.zip",
+		ts.URL + "/exports/us/4.zip",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
 func TestSyncFilenameShapes(t *testing.T) {
 	t.Parallel()
 
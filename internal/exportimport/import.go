@@ -67,16 +67,12 @@ func (s *Server) ImportExportFile(ctx context.Context, ir *ImportRequest) (*Impo
 	logger := logging.FromContext(ctx)
 
 	// Download zip file.
-	httpClient := &http.Client{
-		Timeout: s.config.ExportFileDownloadTimeout,
-	}
-
 	req, err := http.NewRequestWithContext(ctx, "GET", ir.file.ZipFilename, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request to download export file: %w", err)
 	}
 
-	resp, err := httpClient.Do(req)
+	resp, err := s.exportClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("error downloading export file: %w", err)
 	}
@@ -18,8 +18,10 @@ package exportimport
 import (
 	"context"
 	"fmt"
+	"net/http"
 
 	eidb "github.com/google/exposure-notifications-server/internal/exportimport/database"
+	"github.com/google/exposure-notifications-server/internal/httpclient"
 	"github.com/google/exposure-notifications-server/internal/middleware"
 	pubdb "github.com/google/exposure-notifications-server/internal/publish/database"
 	"github.com/google/exposure-notifications-server/internal/serverenv"
@@ -39,6 +41,9 @@ type Server struct {
 	exportImportDB *eidb.ExportImportDB
 	publishDB      *pubdb.PublishDB
 	h              *render.Renderer
+
+	indexClient  *http.Client
+	exportClient *http.Client
 }
 
 // NewServer creates a Server that manages deletion of
@@ -63,6 +68,8 @@ func NewServer(cfg *Config, env *serverenv.ServerEnv) (*Server, error) {
 		exportImportDB: exportImportDB,
 		publishDB:      publishDB,
 		h:              render.NewRenderer(),
+		indexClient:    httpclient.New(&cfg.HTTPClient, cfg.IndexFileDownloadTimeout),
+		exportClient:   httpclient.New(&cfg.HTTPClient, cfg.ExportFileDownloadTimeout),
 	}, nil
 }
 
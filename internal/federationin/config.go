@@ -18,10 +18,13 @@ import (
 	"regexp"
 	"time"
 
+	"github.com/google/exposure-notifications-server/internal/httpclient"
+	"github.com/google/exposure-notifications-server/internal/middleware"
 	"github.com/google/exposure-notifications-server/internal/setup"
 	"github.com/google/exposure-notifications-server/pkg/database"
 	"github.com/google/exposure-notifications-server/pkg/observability"
 	"github.com/google/exposure-notifications-server/pkg/secrets"
+	"github.com/google/exposure-notifications-server/pkg/server"
 )
 
 const (
@@ -49,12 +52,56 @@ type Config struct {
 	SecretManager         secrets.Config
 	ObservabilityExporter observability.Config
 
+	// HTTPClient provides the dial timeout and keep-alive settings used when
+	// connecting to the federation server.
+	HTTPClient httpclient.Config
+
+	// TLS configures TLS termination for the server. It is prefixed with
+	// SERVER_ as TLS_CERT_FILE configures the connection to the federation
+	// server.
+	TLS server.TLSConfig `env:",prefix=SERVER_"`
+
+	SecurityHeaders middleware.SecurityHeadersConfig
+
 	Port                         string        `env:"PORT, default=8080"`
 	Timeout                      time.Duration `env:"RPC_TIMEOUT, default=10m"`
 	TruncateWindow               time.Duration `env:"TRUNCATE_WINDOW, default=1h"`
 	MaxIntervalAge               time.Duration `env:"MAX_INTERVAL_AGE_ON_PUBLISH, default=360h"`
 	MaxMagnitudeSymptomOnsetDays uint          `env:"MAX_SYMPTOM_ONSET_DAYS, default=14"`
 
+	// MissingSymptomOnsetPolicy determines what happens to keys without a days
+	// since symptom onset, or with one outside of MAX_SYMPTOM_ONSET_DAYS:
+	// "keep" imports them without one, "default" assigns
+	// DefaultSymptomOnsetDays and "reject" skips them.
+	MissingSymptomOnsetPolicy MissingSymptomOnsetPolicy `env:"MISSING_SYMPTOM_ONSET_POLICY, default=keep"`
+	DefaultSymptomOnsetDays   int32                     `env:"DEFAULT_SYMPTOM_ONSET_DAYS, default=0"`
+
+	// MaxConcurrentPulls is the number of remotes this instance pulls from at
+	// the same time. Additional sync requests wait for a running pull to finish.
+	// 0 means no limit.
+	MaxConcurrentPulls uint `env:"MAX_CONCURRENT_PULLS, default=10"`
+
+	// DedupWindow is the safety overlap kept before the persisted cursor of a
+	// query. Keys in responses whose cursor is more than DedupWindow before the
+	// persisted cursor were imported by an earlier sync, and are skipped without
+	// being validated or inserted. Keys within the window are still imported,
+	// to catch keys that arrived late at the remote. 0 disables skipping.
+	DedupWindow time.Duration `env:"DEDUP_WINDOW, default=0"`
+
+	// InsertMaxAttempts is the number of attempts to insert a chunk of keys,
+	// with exponential backoff starting at InsertRetryBackoff. If a chunk
+	// still fails, its keys are inserted one at a time. MaxFailedKeys is the
+	// number of keys that may fail to insert during a pull before the pull is
+	// aborted. The keys that failed are not retried by later pulls.
+	InsertMaxAttempts  uint          `env:"INSERT_MAX_ATTEMPTS, default=3"`
+	InsertRetryBackoff time.Duration `env:"INSERT_RETRY_BACKOFF, default=500ms"`
+	MaxFailedKeys      uint          `env:"MAX_FAILED_KEYS, default=0"`
+
+	// EnableCompression, if true, compresses fetch requests with gzip, which
+	// has the federation server compress its responses too. If the server
+	// doesn't support gzip, the pull falls back to uncompressed fetches.
+	EnableCompression bool `env:"ENABLE_COMPRESSION"`
+
 	// Flags for local development and testing. This will cause still valid keys
 	// to not be embargoed.
 	// Normally "still valid" keys can be accepted, but are embargoed.
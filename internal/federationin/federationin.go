@@ -21,6 +21,7 @@ import (
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"sort"
@@ -29,6 +30,8 @@ import (
 
 	"github.com/google/exposure-notifications-server/internal/federationin/database"
 	"github.com/google/exposure-notifications-server/internal/federationin/model"
+	"github.com/google/exposure-notifications-server/internal/federationrequest"
+	"github.com/google/exposure-notifications-server/internal/middleware"
 	"github.com/google/exposure-notifications-server/internal/pb/federation"
 	"github.com/google/exposure-notifications-server/internal/project"
 	publishdb "github.com/google/exposure-notifications-server/internal/publish/database"
@@ -36,11 +39,16 @@ import (
 	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1alpha1"
 	coredb "github.com/google/exposure-notifications-server/pkg/database"
 	"github.com/google/exposure-notifications-server/pkg/logging"
+	"github.com/google/uuid"
 
 	"google.golang.org/api/idtoken"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/oauth"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	"go.opencensus.io/plugin/ocgrpc"
 	"go.opencensus.io/stats"
@@ -96,6 +104,16 @@ func (s *Server) handleSync() http.Handler {
 			return
 		}
 
+		// Wait for a pull slot before taking the lock, so queued requests don't
+		// hold the lock while they wait.
+		release, err := s.pullLimiter.acquire(ctx)
+		if err != nil {
+			stats.Record(ctx, mPullQueueTimeout.M(1))
+			internalErrorf(ctx, w, "Timed out waiting to pull query %q: %v", queryID, err)
+			return
+		}
+		defer release()
+
 		// Obtain lock to make sure there are no other processes working on this batch.
 		lock := "query_" + queryID
 		logger = logger.With("lock", lock)
@@ -145,9 +163,13 @@ func (s *Server) handleSync() http.Handler {
 		}
 
 		tlsConfig := &tls.Config{RootCAs: cp, InsecureSkipVerify: s.config.TLSSkipVerify}
+		dialer := s.config.HTTPClient.Dialer()
 		dialOpts := []grpc.DialOption{
 			grpc.WithStatsHandler(&ocgrpc.ClientHandler{}),
 			grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
+			grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "tcp", addr)
+			}),
 		}
 
 		var clientOpts []idtoken.ClientOption
@@ -187,6 +209,12 @@ func (s *Server) handleSync() http.Handler {
 			maxIntervalStartAge:          s.config.MaxIntervalAge,
 			maxMagnitudeSymptomOnsetDays: s.config.MaxMagnitudeSymptomOnsetDays,
 			debugReleaseSameDay:          s.config.ReleaseSameDayKeys,
+			dedupWindow:                  s.config.DedupWindow,
+			insertMaxAttempts:            s.config.InsertMaxAttempts,
+			insertRetryBackoff:           s.config.InsertRetryBackoff,
+			maxFailedKeys:                s.config.MaxFailedKeys,
+			compress:                     s.config.EnableCompression,
+			config:                       s.config,
 		}
 		if err := pull(timeoutContext, &opts); err != nil {
 			internalErrorf(ctx, w, "Federation query %q failed: %v", queryID, err)
@@ -207,9 +235,34 @@ type pullOptions struct {
 	maxIntervalStartAge          time.Duration
 	maxMagnitudeSymptomOnsetDays uint
 	debugReleaseSameDay          bool
+	dedupWindow                  time.Duration
+	insertMaxAttempts            uint
+	insertRetryBackoff           time.Duration
+	maxFailedKeys                uint
+	compress                     bool
 	config                       *Config
 }
 
+// importedBefore returns the cursor timestamp before which keys were imported
+// by an earlier sync, given the persisted cursor timestamp and the dedup
+// window. It returns 0 if no keys should be skipped.
+func importedBefore(last time.Time, window time.Duration) int64 {
+	if window <= 0 || last.IsZero() {
+		return 0
+	}
+	if before := last.Add(-window).Unix(); before > 0 {
+		return before
+	}
+	return 0
+}
+
+// alreadyImported returns true if all the keys before the cursor were imported
+// by an earlier sync. Keys are returned in cursor order, so the keys of a
+// response are all at or before its next cursor.
+func alreadyImported(cursor *federation.Cursor, before int64) bool {
+	return before > 0 && cursor != nil && cursor.Timestamp < before
+}
+
 // updateTimestamps takes the current known max[revised] timestamps and compares them
 // to the state in the fetch response. If the state in the fetch response has a newer time,
 // then the known max(es) are adjusted forward.
@@ -233,7 +286,7 @@ func updateTimestamps(max, maxRevised time.Time, state *federation.FetchState) (
 }
 
 // converts a federation ExposureKey to a model Exposure.
-func buildExposure(e *federation.ExposureKey, config *Config) (*publishmodel.Exposure, error) {
+func buildExposure(e *federation.ExposureKey, opts *pullOptions) (*publishmodel.Exposure, error) {
 	upperRegions := make([]string, len(e.Regions))
 	for i, r := range e.Regions {
 		upperRegions[i] = strings.ToUpper(project.TrimSpaceAndNonPrintable(r))
@@ -257,13 +310,13 @@ func buildExposure(e *federation.ExposureKey, config *Config) (*publishmodel.Exp
 	case federation.ExposureKey_REVOKED:
 		exposure.ReportType = verifyapi.ReportTypeNegative
 	case federation.ExposureKey_SELF_REPORT:
-		if config.AcceptSelfReport {
+		if opts.config.AcceptSelfReport {
 			exposure.ReportType = verifyapi.ReportTypeClinical
 		} else {
 			return nil, ErrInvalidReportType
 		}
 	case federation.ExposureKey_RECURSIVE:
-		if config.AcceptRecursive {
+		if opts.config.AcceptRecursive {
 			exposure.ReportType = verifyapi.ReportTypeClinical
 		} else {
 			return nil, ErrInvalidReportType
@@ -274,15 +327,30 @@ func buildExposure(e *federation.ExposureKey, config *Config) (*publishmodel.Exp
 	// Maybe backfill transmission risk
 	exposure.TransmissionRisk = publishmodel.ReportTypeTransmissionRisk(exposure.ReportType, exposure.TransmissionRisk)
 
-	if e.HasSymptomOnset {
-		if ds := e.DaysSinceOnsetOfSymptoms; ds >= -1*int32(config.MaxMagnitudeSymptomOnsetDays) && ds <= int32(config.MaxMagnitudeSymptomOnsetDays) {
-			exposure.SetDaysSinceSymptomOnset(ds)
-		}
+	if err := applySymptomOnset(&exposure, e, opts); err != nil {
+		return nil, err
 	}
 
 	return &exposure, nil
 }
 
+// fetchResponse fetches the next response from the federation server,
+// compressed if opts.compress is set. If the server doesn't support
+// compression, the request is sent again uncompressed and compression is turned
+// off for the rest of the pull.
+func fetchResponse(ctx context.Context, opts *pullOptions, request *federation.FederationFetchRequest, header *metadata.MD) (*federation.FederationFetchResponse, error) {
+	if opts.compress {
+		response, err := opts.deps.fetch(ctx, request, grpc.Header(header), grpc.UseCompressor(gzip.Name))
+		if status.Code(err) != codes.Unimplemented {
+			return response, err
+		}
+		logging.FromContext(ctx).Warnw("federation server doesn't support compression, fetching uncompressed",
+			"query_id", opts.query.QueryID, "error", err)
+		opts.compress = false
+	}
+	return opts.deps.fetch(ctx, request, grpc.Header(header))
+}
+
 func pull(ctx context.Context, opts *pullOptions) (err error) {
 	ctx, span := trace.StartSpan(ctx, "federationin.pull")
 	defer func() {
@@ -292,7 +360,19 @@ func pull(ctx context.Context, opts *pullOptions) (err error) {
 		span.End()
 	}()
 
-	logger := logging.FromContext(ctx)
+	// The request id is sent on each fetch so that the partner can correlate
+	// its logs with ours.
+	requestID := middleware.RequestIDFromContext(ctx)
+	if requestID == "" {
+		u, err := uuid.NewRandom()
+		if err != nil {
+			return fmt.Errorf("generating federation request id: %w", err)
+		}
+		requestID = u.String()
+	}
+	ctx = federationrequest.NewOutgoingContext(ctx, requestID)
+
+	logger := logging.FromContext(ctx).With("federation_request_id", requestID)
 	logger.Infof("Processing query %q", opts.query.QueryID)
 
 	request := &federation.FederationFetchRequest{
@@ -310,11 +390,37 @@ func pull(ctx context.Context, opts *pullOptions) (err error) {
 	}
 
 	var maxTimestamp, maxRevisedTimestamp time.Time
-	total := 0
+	total, skipped, stale, invalidSymptomOnset := 0, 0, 0, 0
 	defer func() {
-		logger.Infof("Inserted %d keys", total)
+		logger.Infow("finished federation pull", "inserted", total, "skipped", skipped, "stale", stale,
+			"invalid_symptom_onset", invalidSymptomOnset)
 	}()
 
+	// countInvalidSymptomOnset records keys that are skipped because of their
+	// days since symptom onset, and returns whether err is such an error.
+	countInvalidSymptomOnset := func(err error) bool {
+		if !errors.Is(err, ErrSymptomOnsetOutOfRange) && !errors.Is(err, ErrMissingSymptomOnset) {
+			return false
+		}
+		invalidSymptomOnset++
+		stats.Record(ctx, mPullInvalidSymptomOnset.M(1))
+		return true
+	}
+
+	// Ranges of keys that were imported by an earlier sync are skipped, apart
+	// from a dedup window before the persisted cursors.
+	keysBefore := importedBefore(opts.query.LastTimestamp, opts.dedupWindow)
+	revisedKeysBefore := importedBefore(opts.query.LastRevisedTimestamp, opts.dedupWindow)
+
+	// Transient insert failures are retried, and up to maxFailedKeys keys that
+	// can't be inserted are dropped instead of failing the whole pull.
+	inserter := &keyInserter{
+		insert:      opts.deps.insertExposures,
+		maxAttempts: opts.insertMaxAttempts,
+		backoff:     opts.insertRetryBackoff,
+		maxFailed:   opts.maxFailedKeys,
+	}
+
 	createdAt := publishmodel.TruncateWindow(opts.batchStart, opts.truncateWindow)
 	// Create the transform / validation settings
 	transformSettings := publishmodel.KeyTransform{
@@ -329,6 +435,17 @@ func pull(ctx context.Context, opts *pullOptions) (err error) {
 		BatchWindow:           opts.truncateWindow,
 	}
 
+	// Keys that expire before the minimum window would be rejected by
+	// AdjustAndValidate, they are skipped before doing any other work.
+	isStale := func(key *federation.ExposureKey) bool {
+		if key.IntervalNumber+key.IntervalCount >= transformSettings.MinStartInterval {
+			return false
+		}
+		stale++
+		stats.Record(ctx, mPullStale.M(1))
+		return true
+	}
+
 	partial := true
 	nPartials := int64(0)
 	for partial {
@@ -337,7 +454,11 @@ func pull(ctx context.Context, opts *pullOptions) (err error) {
 
 		// TODO(mikehelmick): react to the context timeout and complete a chunk of work so next invocation can pick up where left off.
 
-		response, err := opts.deps.fetch(ctx, request)
+		var header metadata.MD
+		response, err := fetchResponse(ctx, opts, request, &header)
+		if partnerID := federationrequest.IDFromMetadata(header); partnerID != "" {
+			logger.Infow("federation partner responded", "partner_request_id", partnerID)
+		}
 		if err != nil {
 			return fmt.Errorf("fetching query %s: %w", opts.query.QueryID, err)
 		}
@@ -345,13 +466,21 @@ func pull(ctx context.Context, opts *pullOptions) (err error) {
 		// Advance timestamps based on cursors.
 		maxTimestamp, maxRevisedTimestamp = updateTimestamps(maxTimestamp, maxRevisedTimestamp, response.NextFetchState)
 
-		if len(response.Keys) > 0 {
+		if len(response.Keys) > 0 && alreadyImported(response.NextFetchState.GetKeyCursor(), keysBefore) {
+			logger.Debugw("primary keys already imported, skipping", "count", len(response.Keys))
+			stats.Record(ctx, mPullSkipped.M(int64(len(response.Keys))))
+			skipped += len(response.Keys)
+		} else if len(response.Keys) > 0 {
 			// Build state for new inserts.
 			newExposures := make([]*publishmodel.Exposure, 0, len(response.Keys))
 			for _, key := range response.Keys {
-				exposure, err := buildExposure(key, opts.config)
+				if isStale(key) {
+					continue
+				}
+				exposure, err := buildExposure(key, opts)
 				if err != nil {
 					logger.Debugw("invalid key on federation, skipping", "error", err)
+					countInvalidSymptomOnset(err)
 					continue
 				}
 				// Fill in federation specific items.
@@ -365,7 +494,7 @@ func pull(ctx context.Context, opts *pullOptions) (err error) {
 
 				newExposures = append(newExposures, exposure)
 			}
-			resp, err := opts.deps.insertExposures(ctx, &publishdb.InsertAndReviseExposuresRequest{
+			resp, err := inserter.Insert(ctx, &publishdb.InsertAndReviseExposuresRequest{
 				Incoming:      newExposures,
 				SkipRevisions: true,
 				RequireToken:  false,
@@ -383,13 +512,26 @@ func pull(ctx context.Context, opts *pullOptions) (err error) {
 		}
 
 		// Handle any revised keys.
-		if len(response.RevisedKeys) > 0 {
+		if len(response.RevisedKeys) > 0 && alreadyImported(response.NextFetchState.GetRevisedKeyCursor(), revisedKeysBefore) {
+			logger.Debugw("revised keys already imported, skipping", "count", len(response.RevisedKeys))
+			stats.Record(ctx, mPullSkipped.M(int64(len(response.RevisedKeys))))
+			skipped += len(response.RevisedKeys)
+		} else if len(response.RevisedKeys) > 0 {
 			// Build state for new inserts.
 			revisedExposures := make([]*publishmodel.Exposure, 0, len(response.Keys))
 			for _, key := range response.RevisedKeys {
-				exposure, err := buildExposure(key, opts.config)
+				if isStale(key) {
+					continue
+				}
+				exposure, err := buildExposure(key, opts)
 				if err != nil {
-					return ErrInvalidReportType
+					// Like primary keys, keys with an invalid days since symptom onset
+					// are skipped.
+					if countInvalidSymptomOnset(err) {
+						logger.Warnw("invalid key on federation, skipping", "error", err)
+						continue
+					}
+					return fmt.Errorf("building revised key: %w", err)
 				}
 				// Fill in federation specific items.
 				exposure.FederationSyncID = syncID
@@ -402,7 +544,7 @@ func pull(ctx context.Context, opts *pullOptions) (err error) {
 
 				revisedExposures = append(revisedExposures, exposure)
 			}
-			resp, err := opts.deps.insertExposures(ctx, &publishdb.InsertAndReviseExposuresRequest{
+			resp, err := inserter.Insert(ctx, &publishdb.InsertAndReviseExposuresRequest{
 				Incoming:       revisedExposures,
 				OnlyRevisions:  true,
 				RequireToken:   false,
@@ -21,16 +21,24 @@ import (
 
 	"github.com/google/exposure-notifications-server/internal/federationin/database"
 	"github.com/google/exposure-notifications-server/internal/federationin/model"
+	"github.com/google/exposure-notifications-server/internal/federationrequest"
 	"github.com/google/exposure-notifications-server/internal/project"
 	publishdb "github.com/google/exposure-notifications-server/internal/publish/database"
 	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
 	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
+	"github.com/google/exposure-notifications-server/pkg/logging"
 
 	"github.com/google/exposure-notifications-server/internal/pb/federation"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 var (
@@ -442,6 +450,7 @@ func TestFederationPull(t *testing.T) {
 				truncateWindow:               time.Hour,
 				maxIntervalStartAge:          14 * 24 * time.Hour,
 				maxMagnitudeSymptomOnsetDays: 14,
+				config:                       &Config{},
 			}
 
 			err := pull(ctx, &opts)
@@ -498,3 +507,395 @@ func makeExposure(diagKey *federation.ExposureKey, reportType string, regions []
 		ReportType:       reportType,
 	}
 }
+
+// TestPullRequestID tests that pull sends a federation request id and logs the
+// id echoed by the partner.
+func TestPullRequestID(t *testing.T) {
+	t.Parallel()
+
+	core, logs := observer.New(zap.InfoLevel)
+	ctx := logging.WithLogger(project.TestContext(t), zap.New(core).Sugar())
+
+	var gotIDs []string
+	fetch := func(ctx context.Context, req *federation.FederationFetchRequest, opts ...grpc.CallOption) (*federation.FederationFetchResponse, error) {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		gotIDs = append(gotIDs, md.Get(federationrequest.IDMetadataKey)...)
+
+		// Echo the id back, as a partner running this server does.
+		for _, opt := range opts {
+			if h, ok := opt.(grpc.HeaderCallOption); ok {
+				*h.HeaderAddr = metadata.Pairs(federationrequest.IDMetadataKey, "partner-id")
+			}
+		}
+		return &federation.FederationFetchResponse{
+			NextFetchState: &federation.FetchState{
+				KeyCursor:        &federation.Cursor{},
+				RevisedKeyCursor: &federation.Cursor{},
+			},
+		}, nil
+	}
+
+	idb := publishDB{}
+	sdb := syncDB{}
+	opts := pullOptions{
+		deps: pullDependencies{
+			fetch:               fetch,
+			insertExposures:     idb.insertExposures,
+			startFederationSync: sdb.startFederationSync,
+		},
+		query:          &model.FederationInQuery{QueryID: queryID},
+		batchStart:     time.Now(),
+		truncateWindow: time.Hour,
+		config:         &Config{},
+	}
+	if err := pull(ctx, &opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(gotIDs) != 1 || gotIDs[0] == "" {
+		t.Fatalf("expected a single request id to be sent, got %q", gotIDs)
+	}
+
+	echoed := logs.FilterField(zap.String("partner_request_id", "partner-id")).All()
+	if len(echoed) != 1 {
+		t.Fatalf("expected partner request id to be logged once, got %d", len(echoed))
+	}
+	if diff := cmp.Diff(zap.String("federation_request_id", gotIDs[0]), echoed[0].Context[0]); diff != "" {
+		t.Errorf("expected our request id alongside the partner's (-want, +got):\n%s", diff)
+	}
+}
+
+// TestPullCompressionFallback tests that pull compresses fetches when enabled,
+// and falls back to uncompressed fetches for the rest of the pull when the
+// partner doesn't support compression.
+func TestPullCompressionFallback(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		supported bool
+		want      []string
+	}{
+		{
+			name:      "supported",
+			supported: true,
+			want:      []string{gzip.Name, gzip.Name},
+		},
+		{
+			name:      "unsupported",
+			supported: false,
+			want:      []string{gzip.Name, "", ""},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var got []string
+			responses := 0
+			fetch := func(ctx context.Context, req *federation.FederationFetchRequest, opts ...grpc.CallOption) (*federation.FederationFetchResponse, error) {
+				compressor := ""
+				for _, opt := range opts {
+					if c, ok := opt.(grpc.CompressorCallOption); ok {
+						compressor = c.CompressorType
+					}
+				}
+				got = append(got, compressor)
+
+				if compressor != "" && !tc.supported {
+					return nil, status.Errorf(codes.Unimplemented, "grpc: Decompressor is not installed for grpc-encoding %q", compressor)
+				}
+				// The first response is partial, so two responses are fetched.
+				responses++
+				return &federation.FederationFetchResponse{
+					PartialResponse: responses == 1,
+					NextFetchState: &federation.FetchState{
+						KeyCursor:        &federation.Cursor{Timestamp: 1},
+						RevisedKeyCursor: &federation.Cursor{},
+					},
+				}, nil
+			}
+
+			idb := publishDB{}
+			sdb := syncDB{}
+			opts := pullOptions{
+				deps: pullDependencies{
+					fetch:               fetch,
+					insertExposures:     idb.insertExposures,
+					startFederationSync: sdb.startFederationSync,
+				},
+				query:          &model.FederationInQuery{QueryID: queryID},
+				batchStart:     time.Now(),
+				truncateWindow: time.Hour,
+				compress:       true,
+				config:         &Config{},
+			}
+			if err := pull(project.TestContext(t), &opts); err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("compressors mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestPullSkipsImportedKeys tests that keys before the persisted cursor, minus
+// the dedup window, are skipped while newer keys are imported.
+func TestPullSkipsImportedKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	batchTime := time.Now().Truncate(time.Second)
+	intervalNumber := publishmodel.IntervalNumber(batchTime.Add(-2 * 24 * time.Hour))
+	confirmed := func(e *federation.ExposureKey) *federation.ExposureKey {
+		return setIntervalNumber(setReportType(setRegions(copyExposureKey(e), "US"), federation.ExposureKey_CONFIRMED_TEST), intervalNumber)
+	}
+	wantExposure := func(e *federation.ExposureKey) *publishmodel.Exposure {
+		return makeRemoteExposure(setIntervalNumber(copyExposureKey(e), intervalNumber), "confirmed", []string{"US"}, false, batchTime)
+	}
+
+	// The query was synced up to 1000 and the window reaches back to 900.
+	responses := func() []*federation.FederationFetchResponse {
+		return []*federation.FederationFetchResponse{
+			{
+				// Before the window, already imported.
+				PartialResponse: true,
+				Keys:            []*federation.ExposureKey{confirmed(aaa), confirmed(bbb)},
+				RevisedKeys:     []*federation.ExposureKey{confirmed(aaa)},
+				NextFetchState: &federation.FetchState{
+					KeyCursor:        &federation.Cursor{Timestamp: 850, NextToken: "bbbb"},
+					RevisedKeyCursor: &federation.Cursor{Timestamp: 850, NextToken: "aaaa"},
+				},
+			},
+			{
+				// Within the window, late-arriving keys are imported.
+				PartialResponse: true,
+				Keys:            []*federation.ExposureKey{confirmed(ccc)},
+				NextFetchState: &federation.FetchState{
+					KeyCursor:        &federation.Cursor{Timestamp: 950, NextToken: "cccc"},
+					RevisedKeyCursor: &federation.Cursor{Timestamp: 850},
+				},
+			},
+			{
+				// After the cursor.
+				PartialResponse: false,
+				Keys:            []*federation.ExposureKey{confirmed(ddd)},
+				NextFetchState: &federation.FetchState{
+					KeyCursor:        &federation.Cursor{Timestamp: 1200},
+					RevisedKeyCursor: &federation.Cursor{Timestamp: 850},
+				},
+			},
+		}
+	}
+
+	cases := []struct {
+		name          string
+		dedupWindow   time.Duration
+		wantExposures []*publishmodel.Exposure
+	}{
+		{
+			name:        "skips_imported",
+			dedupWindow: 100 * time.Second,
+			wantExposures: []*publishmodel.Exposure{
+				wantExposure(ccc),
+				wantExposure(ddd),
+			},
+		},
+		{
+			name:        "window_covers_all",
+			dedupWindow: time.Hour,
+			wantExposures: []*publishmodel.Exposure{
+				wantExposure(aaa),
+				wantExposure(bbb),
+				wantExposure(aaa),
+				wantExposure(ccc),
+				wantExposure(ddd),
+			},
+		},
+		{
+			name: "disabled",
+			wantExposures: []*publishmodel.Exposure{
+				wantExposure(aaa),
+				wantExposure(bbb),
+				wantExposure(aaa),
+				wantExposure(ccc),
+				wantExposure(ddd),
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			query := &model.FederationInQuery{
+				QueryID:              queryID,
+				LastTimestamp:        time.Unix(1000, 0).UTC(),
+				LastRevisedTimestamp: time.Unix(1000, 0).UTC(),
+			}
+			remote := remoteFetchServer{responses: responses()}
+			idb := publishDB{}
+			sdb := syncDB{}
+
+			opts := pullOptions{
+				deps: pullDependencies{
+					fetch:               remote.fetch,
+					insertExposures:     idb.insertExposures,
+					startFederationSync: sdb.startFederationSync,
+				},
+				query:                        query,
+				batchStart:                   batchTime,
+				truncateWindow:               time.Hour,
+				maxIntervalStartAge:          14 * 24 * time.Hour,
+				maxMagnitudeSymptomOnsetDays: 14,
+				dedupWindow:                  tc.dedupWindow,
+				config:                       &Config{},
+			}
+			if err := pull(ctx, &opts); err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tc.wantExposures, idb.exposures, cmpopts.IgnoreFields(publishmodel.Exposure{}, "CreatedAt"), cmpopts.IgnoreUnexported(publishmodel.Exposure{})); diff != "" {
+				t.Errorf("exposures mismatch (-want +got):\n%s", diff)
+			}
+			if got, want := len(remote.gotTokens), 3; got != want {
+				t.Errorf("expected %d fetches, got %d", want, got)
+			}
+			if got, want := sdb.totalInserted, len(tc.wantExposures); got != want {
+				t.Errorf("federation sync total inserted got %d, want %d", got, want)
+			}
+			if got, want := sdb.maxTimestamp.Unix(), int64(1200); got != want {
+				t.Errorf("federation sync max timestamp got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestPullSkipsStaleKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	batchTime := time.Now().Truncate(time.Second)
+	freshInterval := publishmodel.IntervalNumber(batchTime.Add(-2 * 24 * time.Hour))
+	// Starts before the maximum interval age, but expires after it.
+	edgeInterval := publishmodel.IntervalNumber(batchTime.Add(-7*24*time.Hour - 12*time.Hour))
+	staleInterval := publishmodel.IntervalNumber(batchTime.Add(-10 * 24 * time.Hour))
+	confirmed := func(e *federation.ExposureKey, interval int32) *federation.ExposureKey {
+		return setIntervalNumber(setReportType(setRegions(copyExposureKey(e), "US"), federation.ExposureKey_CONFIRMED_TEST), interval)
+	}
+	wantExposure := func(e *federation.ExposureKey, interval int32) *publishmodel.Exposure {
+		return makeRemoteExposure(setIntervalNumber(copyExposureKey(e), interval), "confirmed", []string{"US"}, false, batchTime)
+	}
+
+	cases := []struct {
+		name           string
+		maxIntervalAge time.Duration
+		wantExposures  []*publishmodel.Exposure
+	}{
+		{
+			name:           "skips_stale",
+			maxIntervalAge: 7 * 24 * time.Hour,
+			wantExposures: []*publishmodel.Exposure{
+				wantExposure(aaa, freshInterval),
+				wantExposure(bbb, edgeInterval),
+				wantExposure(ccc, freshInterval),
+			},
+		},
+		{
+			name:           "within_age",
+			maxIntervalAge: 14 * 24 * time.Hour,
+			wantExposures: []*publishmodel.Exposure{
+				wantExposure(aaa, freshInterval),
+				wantExposure(bbb, edgeInterval),
+				wantExposure(ccc, freshInterval),
+				wantExposure(ddd, staleInterval),
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			remote := remoteFetchServer{
+				responses: []*federation.FederationFetchResponse{
+					{
+						Keys:        []*federation.ExposureKey{confirmed(aaa, freshInterval), confirmed(bbb, edgeInterval)},
+						RevisedKeys: []*federation.ExposureKey{confirmed(ccc, freshInterval), confirmed(ddd, staleInterval)},
+						NextFetchState: &federation.FetchState{
+							KeyCursor:        &federation.Cursor{Timestamp: 100},
+							RevisedKeyCursor: &federation.Cursor{Timestamp: 100},
+						},
+					},
+				},
+			}
+			idb := publishDB{}
+			sdb := syncDB{}
+
+			opts := pullOptions{
+				deps: pullDependencies{
+					fetch:               remote.fetch,
+					insertExposures:     idb.insertExposures,
+					startFederationSync: sdb.startFederationSync,
+				},
+				query:                        &model.FederationInQuery{QueryID: queryID},
+				batchStart:                   batchTime,
+				truncateWindow:               time.Hour,
+				maxIntervalStartAge:          tc.maxIntervalAge,
+				maxMagnitudeSymptomOnsetDays: 14,
+				config:                       &Config{},
+			}
+			if err := pull(ctx, &opts); err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tc.wantExposures, idb.exposures, cmpopts.IgnoreFields(publishmodel.Exposure{}, "CreatedAt"), cmpopts.IgnoreUnexported(publishmodel.Exposure{})); diff != "" {
+				t.Errorf("exposures mismatch (-want +got):\n%s", diff)
+			}
+			if got, want := sdb.totalInserted, len(tc.wantExposures); got != want {
+				t.Errorf("federation sync total inserted got %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestImportedBefore(t *testing.T) {
+	t.Parallel()
+
+	last := time.Unix(1000, 0).UTC()
+
+	cases := []struct {
+		name   string
+		last   time.Time
+		window time.Duration
+		want   int64
+	}{
+		{name: "disabled", last: last, want: 0},
+		{name: "never_synced", window: time.Minute, want: 0},
+		{name: "window", last: last, window: time.Minute, want: 940},
+		{name: "window_before_epoch", last: last, window: time.Hour, want: 0},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := importedBefore(tc.last, tc.window); got != tc.want {
+				t.Errorf("expected %d, got %d", tc.want, got)
+			}
+		})
+	}
+}
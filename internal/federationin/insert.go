@@ -0,0 +1,119 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationin
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	publishdb "github.com/google/exposure-notifications-server/internal/publish/database"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/pkg/logging"
+	"github.com/sethvargo/go-retry"
+	"go.opencensus.io/stats"
+)
+
+// keyInserter inserts the keys of a pull, retrying failed inserts with
+// backoff. It counts the keys that couldn't be inserted across the pull, and
+// fails once more than maxFailed keys failed.
+type keyInserter struct {
+	insert      insertExposuresFn
+	maxAttempts uint
+	backoff     time.Duration
+	maxFailed   uint
+
+	failed uint
+}
+
+// Insert inserts the keys of the request. If the keys still fail to insert
+// after all attempts, they are inserted one at a time, so only the keys that
+// fail on their own are dropped. An error is returned if more than maxFailed
+// keys failed during the pull, or if the context is done.
+func (ki *keyInserter) Insert(ctx context.Context, req *publishdb.InsertAndReviseExposuresRequest) (*publishdb.InsertAndReviseExposuresResponse, error) {
+	resp, err := ki.insertWithRetry(ctx, req)
+	if err == nil {
+		return resp, nil
+	}
+	if ctx.Err() != nil || len(req.Incoming) <= 1 {
+		return nil, ki.fail(ctx, len(req.Incoming), err)
+	}
+
+	logger := logging.FromContext(ctx).Named("keyInserter")
+	logger.Warnw("failed to insert keys, inserting them one at a time", "count", len(req.Incoming), "error", err)
+
+	total := &publishdb.InsertAndReviseExposuresResponse{}
+	for _, exposure := range req.Incoming {
+		single := *req
+		single.Incoming = []*publishmodel.Exposure{exposure}
+
+		resp, err := ki.insertWithRetry(ctx, &single)
+		if err != nil {
+			if err := ki.fail(ctx, 1, err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		total.Inserted += resp.Inserted
+		total.Revised += resp.Revised
+		total.Dropped += resp.Dropped
+		total.Exposures = append(total.Exposures, resp.Exposures...)
+	}
+	return total, nil
+}
+
+// insertWithRetry inserts the keys of the request, retrying with exponential
+// backoff until maxAttempts attempts failed.
+func (ki *keyInserter) insertWithRetry(ctx context.Context, req *publishdb.InsertAndReviseExposuresRequest) (*publishdb.InsertAndReviseExposuresResponse, error) {
+	if ki.maxAttempts <= 1 {
+		return ki.insert(ctx, req)
+	}
+	b, err := retry.NewExponential(ki.backoff)
+	if err != nil {
+		return nil, fmt.Errorf("invalid insert retry backoff: %w", err)
+	}
+	b = retry.WithMaxRetries(uint64(ki.maxAttempts-1), b)
+
+	var resp *publishdb.InsertAndReviseExposuresResponse
+	if err := retry.Do(ctx, b, func(ctx context.Context) error {
+		var err error
+		resp, err = ki.insert(ctx, req)
+		if err != nil {
+			return retry.RetryableError(err)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// fail records n keys that failed to insert, and returns an error if the pull
+// should be aborted.
+func (ki *keyInserter) fail(ctx context.Context, n int, err error) error {
+	ki.failed += uint(n)
+	stats.Record(ctx, mPullFailedKeys.M(int64(n)))
+
+	if ctx.Err() != nil {
+		return err
+	}
+	if ki.failed > ki.maxFailed {
+		return fmt.Errorf("%d keys failed to insert during the pull, more than the limit of %d: %w", ki.failed, ki.maxFailed, err)
+	}
+
+	logger := logging.FromContext(ctx).Named("keyInserter")
+	logger.Warnw("dropping keys that failed to insert", "count", n, "failed", ki.failed, "error", err)
+	return nil
+}
@@ -0,0 +1,318 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/federationin/model"
+	"github.com/google/exposure-notifications-server/internal/pb/federation"
+	"github.com/google/exposure-notifications-server/internal/project"
+	publishdb "github.com/google/exposure-notifications-server/internal/publish/database"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/pkg/errcmp"
+	"github.com/google/go-cmp/cmp"
+)
+
+var errTransient = errors.New("transient database error")
+
+// flakyPublishDB mocks the database, failing inserts that contain one of the
+// failing keys. Each key fails the given number of times, a negative count
+// always fails.
+type flakyPublishDB struct {
+	failures  map[string]int
+	calls     int
+	exposures []*publishmodel.Exposure
+}
+
+func (db *flakyPublishDB) insertExposures(ctx context.Context, req *publishdb.InsertAndReviseExposuresRequest) (*publishdb.InsertAndReviseExposuresResponse, error) {
+	db.calls++
+	for _, exp := range req.Incoming {
+		key := string(exp.ExposureKey)
+		if n := db.failures[key]; n != 0 {
+			if n > 0 {
+				db.failures[key]--
+			}
+			return nil, errTransient
+		}
+	}
+
+	db.exposures = append(db.exposures, req.Incoming...)
+	return &publishdb.InsertAndReviseExposuresResponse{
+		Exposures: req.Incoming,
+		Inserted:  uint32(len(req.Incoming)),
+	}, nil
+}
+
+func TestKeyInserter(t *testing.T) {
+	t.Parallel()
+
+	keys := []string{"aaa", "bbb", "ccc"}
+
+	cases := []struct {
+		name        string
+		failures    map[string]int
+		maxAttempts uint
+		maxFailed   uint
+		wantKeys    []string
+		wantFailed  uint
+		wantCalls   int
+		err         string
+	}{
+		{
+			name:        "success",
+			maxAttempts: 3,
+			wantKeys:    keys,
+			wantCalls:   1,
+		},
+		{
+			name:        "retries_chunk",
+			failures:    map[string]int{"bbb": 2},
+			maxAttempts: 3,
+			wantKeys:    keys,
+			wantCalls:   3,
+		},
+		{
+			name:        "single_attempt",
+			failures:    map[string]int{"bbb": 1},
+			maxAttempts: 1,
+			wantKeys:    keys,
+			// The chunk fails once and each key is then inserted by itself.
+			wantCalls: 4,
+		},
+		{
+			name:        "drops_failing_key",
+			failures:    map[string]int{"bbb": -1},
+			maxAttempts: 2,
+			maxFailed:   1,
+			wantKeys:    []string{"aaa", "ccc"},
+			wantFailed:  1,
+			wantCalls:   2 + 1 + 2 + 1,
+		},
+		{
+			name:        "exceeds_max_failed",
+			failures:    map[string]int{"bbb": -1},
+			maxAttempts: 2,
+			maxFailed:   0,
+			wantKeys:    []string{"aaa"},
+			wantFailed:  1,
+			wantCalls:   2 + 1 + 2,
+			err:         "1 keys failed to insert during the pull, more than the limit of 0",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := project.TestContext(t)
+
+			db := &flakyPublishDB{failures: tc.failures}
+			ki := &keyInserter{
+				insert:      db.insertExposures,
+				maxAttempts: tc.maxAttempts,
+				backoff:     time.Millisecond,
+				maxFailed:   tc.maxFailed,
+			}
+
+			req := &publishdb.InsertAndReviseExposuresRequest{SkipRevisions: true}
+			for _, k := range keys {
+				req.Incoming = append(req.Incoming, &publishmodel.Exposure{ExposureKey: []byte(k)})
+			}
+
+			resp, err := ki.Insert(ctx, req)
+			errcmp.MustMatch(t, err, tc.err)
+			if err != nil && !errors.Is(err, errTransient) {
+				t.Errorf("expected %v to wrap %v", err, errTransient)
+			}
+			if err == nil {
+				if got, want := int(resp.Inserted), len(tc.wantKeys); got != want {
+					t.Errorf("expected %d inserted, got %d", want, got)
+				}
+			}
+
+			var got []string
+			for _, exp := range db.exposures {
+				got = append(got, string(exp.ExposureKey))
+			}
+			if diff := cmp.Diff(tc.wantKeys, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+			if got, want := ki.failed, tc.wantFailed; got != want {
+				t.Errorf("expected %d failed keys, got %d", want, got)
+			}
+			if got, want := db.calls, tc.wantCalls; got != want {
+				t.Errorf("expected %d insert calls, got %d", want, got)
+			}
+		})
+	}
+}
+
+func TestKeyInserter_ContextDone(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(project.TestContext(t))
+	cancel()
+
+	db := &flakyPublishDB{failures: map[string]int{"aaa": -1}}
+	ki := &keyInserter{
+		insert:      db.insertExposures,
+		maxAttempts: 3,
+		backoff:     time.Millisecond,
+		maxFailed:   10,
+	}
+	req := &publishdb.InsertAndReviseExposuresRequest{
+		Incoming: []*publishmodel.Exposure{
+			{ExposureKey: []byte("aaa")},
+			{ExposureKey: []byte("bbb")},
+		},
+	}
+
+	// The keys aren't inserted one at a time once the context is done.
+	if _, err := ki.Insert(ctx, req); err == nil {
+		t.Fatal("expected error")
+	}
+	if db.calls > 1 {
+		t.Errorf("expected at most 1 insert call, got %d", db.calls)
+	}
+}
+
+// TestPullRetriesInserts tests that a pull completes when inserts fail
+// transiently.
+func TestPullRetriesInserts(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	batchTime := time.Now().Truncate(time.Second)
+	intervalNumber := publishmodel.IntervalNumber(batchTime.Add(-2 * 24 * time.Hour))
+	key := func(e *federation.ExposureKey) *federation.ExposureKey {
+		return setReportType(setRegions(setIntervalNumber(copyExposureKey(e), intervalNumber), "US"), federation.ExposureKey_CONFIRMED_TEST)
+	}
+
+	remote := remoteFetchServer{
+		responses: []*federation.FederationFetchResponse{
+			{
+				PartialResponse: true,
+				Keys:            []*federation.ExposureKey{key(aaa), key(bbb)},
+				NextFetchState: &federation.FetchState{
+					KeyCursor: &federation.Cursor{Timestamp: 100, NextToken: "bbb"},
+				},
+			},
+			{
+				Keys: []*federation.ExposureKey{key(ccc), key(ddd)},
+				NextFetchState: &federation.FetchState{
+					KeyCursor: &federation.Cursor{Timestamp: 200},
+				},
+			},
+		},
+	}
+	// The first chunk fails twice, and a key of the second chunk fails once.
+	idb := &flakyPublishDB{failures: map[string]int{
+		string(aaa.ExposureKey): 2,
+		string(ddd.ExposureKey): 1,
+	}}
+	sdb := syncDB{}
+
+	opts := pullOptions{
+		deps: pullDependencies{
+			fetch:               remote.fetch,
+			insertExposures:     idb.insertExposures,
+			startFederationSync: sdb.startFederationSync,
+		},
+		query:                        &model.FederationInQuery{QueryID: queryID},
+		batchStart:                   batchTime,
+		truncateWindow:               time.Hour,
+		maxIntervalStartAge:          14 * 24 * time.Hour,
+		maxMagnitudeSymptomOnsetDays: 14,
+		insertMaxAttempts:            3,
+		insertRetryBackoff:           time.Millisecond,
+		config:                       &Config{},
+	}
+	if err := pull(ctx, &opts); err != nil {
+		t.Fatalf("pull returned err=%v, want err=nil", err)
+	}
+
+	if got, want := len(idb.exposures), 4; got != want {
+		t.Errorf("expected %d exposures to be inserted, got %d", want, got)
+	}
+	if !sdb.syncCompleted {
+		t.Errorf("federation sync not completed")
+	}
+	if got, want := sdb.totalInserted, 4; got != want {
+		t.Errorf("federation sync total inserted got %d, want %d", got, want)
+	}
+	if got, want := sdb.maxTimestamp.Unix(), int64(200); got != want {
+		t.Errorf("federation sync max timestamp got %v, want %v", got, want)
+	}
+}
+
+// TestPullAbortsOnFailedKeys tests that a pull fails once too many keys can't
+// be inserted.
+func TestPullAbortsOnFailedKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	batchTime := time.Now().Truncate(time.Second)
+	intervalNumber := publishmodel.IntervalNumber(batchTime.Add(-2 * 24 * time.Hour))
+	key := func(e *federation.ExposureKey) *federation.ExposureKey {
+		return setReportType(setRegions(setIntervalNumber(copyExposureKey(e), intervalNumber), "US"), federation.ExposureKey_CONFIRMED_TEST)
+	}
+
+	remote := remoteFetchServer{
+		responses: []*federation.FederationFetchResponse{
+			{
+				Keys: []*federation.ExposureKey{key(aaa), key(bbb), key(ccc)},
+				NextFetchState: &federation.FetchState{
+					KeyCursor: &federation.Cursor{Timestamp: 100},
+				},
+			},
+		},
+	}
+	idb := &flakyPublishDB{failures: map[string]int{
+		string(aaa.ExposureKey): -1,
+		string(ccc.ExposureKey): -1,
+	}}
+	sdb := syncDB{}
+
+	opts := pullOptions{
+		deps: pullDependencies{
+			fetch:               remote.fetch,
+			insertExposures:     idb.insertExposures,
+			startFederationSync: sdb.startFederationSync,
+		},
+		query:                        &model.FederationInQuery{QueryID: queryID},
+		batchStart:                   batchTime,
+		truncateWindow:               time.Hour,
+		maxIntervalStartAge:          14 * 24 * time.Hour,
+		maxMagnitudeSymptomOnsetDays: 14,
+		insertMaxAttempts:            2,
+		insertRetryBackoff:           time.Millisecond,
+		maxFailedKeys:                1,
+		config:                       &Config{},
+	}
+	err := pull(ctx, &opts)
+	errcmp.MustMatch(t, err, "2 keys failed to insert during the pull, more than the limit of 1")
+
+	if sdb.syncCompleted {
+		t.Errorf("expected federation sync to not be completed")
+	}
+}
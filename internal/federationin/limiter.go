@@ -0,0 +1,50 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationin
+
+import (
+	"context"
+)
+
+// pullLimiter bounds the number of remotes pulled from at the same time. Pulls
+// over the limit wait until another pull finishes.
+type pullLimiter struct {
+	slots chan struct{}
+}
+
+// newPullLimiter creates a limiter that allows up to max concurrent pulls. If
+// max is 0, pulls are not limited.
+func newPullLimiter(max uint) *pullLimiter {
+	if max == 0 {
+		return &pullLimiter{}
+	}
+	return &pullLimiter{slots: make(chan struct{}, max)}
+}
+
+// acquire waits for a pull slot. The returned function must be called to free
+// the slot when the pull is done. If the context is done first, an error is
+// returned.
+func (l *pullLimiter) acquire(ctx context.Context) (func(), error) {
+	if l.slots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
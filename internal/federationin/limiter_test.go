@@ -0,0 +1,130 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationin
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/project"
+)
+
+func TestPullLimiter(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		max     uint
+		remotes int
+		want    int
+	}{
+		{name: "limited", max: 3, remotes: 10, want: 3},
+		{name: "fewer_remotes", max: 5, remotes: 2, want: 2},
+		{name: "unlimited", max: 0, remotes: 10, want: 10},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := project.TestContext(t)
+			limiter := newPullLimiter(tc.max)
+
+			var mu sync.Mutex
+			var running, maxRunning, done int
+			// Pulls block until enough are running to reach the limit, so the
+			// limit is observed regardless of scheduling. Once the first pulls
+			// finish, later ones can reach the limit again.
+			reached := make(chan struct{})
+			var reachedOnce sync.Once
+
+			var wg sync.WaitGroup
+			for i := 0; i < tc.remotes; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					release, err := limiter.acquire(ctx)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					defer release()
+
+					mu.Lock()
+					running++
+					if running > maxRunning {
+						maxRunning = running
+					}
+					if running == tc.want {
+						reachedOnce.Do(func() { close(reached) })
+					}
+					mu.Unlock()
+
+					select {
+					case <-reached:
+					case <-time.After(5 * time.Second):
+						t.Error("timed out waiting for concurrent pulls")
+					}
+
+					mu.Lock()
+					running--
+					done++
+					mu.Unlock()
+				}()
+			}
+			wg.Wait()
+
+			if got, want := maxRunning, tc.want; got != want {
+				t.Errorf("expected %d concurrent pulls, got %d", want, got)
+			}
+			if got, want := done, tc.remotes; got != want {
+				t.Errorf("expected %d pulls to finish, got %d", want, got)
+			}
+		})
+	}
+}
+
+func TestPullLimiter_ContextDone(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	limiter := newPullLimiter(1)
+
+	release, err := limiter.acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The only slot is taken, so a queued pull gives up with its context.
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := limiter.acquire(waitCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected %v to be %v", err, context.DeadlineExceeded)
+	}
+
+	// Once released, the slot can be taken again.
+	release()
+	release, err = limiter.acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
@@ -34,6 +34,16 @@ var (
 		"Pull revision", stats.UnitDimensionless)
 	mPullDropped = stats.Int64(publishMetricsPrefix+"pull_dropped",
 		"Pull dropped", stats.UnitDimensionless)
+	mPullSkipped = stats.Int64(publishMetricsPrefix+"pull_skipped",
+		"Pulled keys skipped as already imported", stats.UnitDimensionless)
+	mPullStale = stats.Int64(publishMetricsPrefix+"pull_stale",
+		"Pulled keys skipped as expired before the maximum interval age", stats.UnitDimensionless)
+	mPullQueueTimeout = stats.Int64(publishMetricsPrefix+"pull_queue_timeout",
+		"Pulls that timed out waiting for a concurrency slot", stats.UnitDimensionless)
+	mPullFailedKeys = stats.Int64(publishMetricsPrefix+"pull_failed_keys",
+		"Pulled keys that failed to insert after retries", stats.UnitDimensionless)
+	mPullInvalidSymptomOnset = stats.Int64(publishMetricsPrefix+"pull_invalid_symptom_onset",
+		"Pulled keys skipped for an invalid or missing days since symptom onset", stats.UnitDimensionless)
 )
 
 func init() {
@@ -68,5 +78,35 @@ func init() {
 			Measure:     mPullDropped,
 			Aggregation: view.LastValue(),
 		},
+		{
+			Name:        metrics.MetricRoot + "pull_skipped_count",
+			Description: "Total count of pulled keys skipped as already imported",
+			Measure:     mPullSkipped,
+			Aggregation: view.Sum(),
+		},
+		{
+			Name:        metrics.MetricRoot + "pull_stale_count",
+			Description: "Total count of pulled keys skipped as expired before the maximum interval age",
+			Measure:     mPullStale,
+			Aggregation: view.Sum(),
+		},
+		{
+			Name:        metrics.MetricRoot + "pull_queue_timeout_count",
+			Description: "Total count of pulls that timed out waiting for a concurrency slot",
+			Measure:     mPullQueueTimeout,
+			Aggregation: view.Sum(),
+		},
+		{
+			Name:        metrics.MetricRoot + "pull_failed_keys_count",
+			Description: "Total count of pulled keys that failed to insert after retries",
+			Measure:     mPullFailedKeys,
+			Aggregation: view.Sum(),
+		},
+		{
+			Name:        metrics.MetricRoot + "pull_invalid_symptom_onset_count",
+			Description: "Total count of pulled keys skipped for an invalid or missing days since symptom onset",
+			Measure:     mPullInvalidSymptomOnset,
+			Aggregation: view.Sum(),
+		},
 	}...)
 }
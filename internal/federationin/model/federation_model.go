@@ -87,8 +87,33 @@ type FederationOutAuthorization struct {
 	Issuer  string
 	Subject string
 	// Audience is optional, but will be validated against the OIDC token if provided.
-	Audience       string
-	Note           string
-	IncludeRegions []string
-	ExcludeRegions []string
+	Audience string
+	// AlternateAudiences are also accepted on the OIDC token when Audience is
+	// set, e.g. while a partner moves to a new audience.
+	AlternateAudiences []string
+	Note               string
+	IncludeRegions     []string
+	ExcludeRegions     []string
+}
+
+// AcceptableAudiences returns the audiences that are accepted on the OIDC
+// token, or nil if the audience isn't enforced.
+func (a *FederationOutAuthorization) AcceptableAudiences() []string {
+	if a.Audience == "" {
+		return nil
+	}
+	return append([]string{a.Audience}, a.AlternateAudiences...)
+}
+
+// AcceptsAudience returns true if an OIDC token with the audience is accepted.
+func (a *FederationOutAuthorization) AcceptsAudience(aud string) bool {
+	if a.Audience == "" {
+		return true
+	}
+	for _, want := range a.AcceptableAudiences() {
+		if aud == want {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,87 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationin
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/exposure-notifications-server/internal/pb/federation"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+)
+
+var (
+	// ErrSymptomOnsetOutOfRange is returned for keys whose days since symptom
+	// onset is outside of MAX_SYMPTOM_ONSET_DAYS, if the missing symptom onset
+	// policy rejects them.
+	ErrSymptomOnsetOutOfRange = errors.New("days since symptom onset out of range")
+	// ErrMissingSymptomOnset is returned for keys without days since symptom
+	// onset, if the missing symptom onset policy rejects them.
+	ErrMissingSymptomOnset = errors.New("missing days since symptom onset")
+)
+
+// MissingSymptomOnsetPolicy determines what happens to federated keys that
+// don't have a valid days since symptom onset. Keys with a days since symptom
+// onset outside of MAX_SYMPTOM_ONSET_DAYS are treated as if they had none,
+// which is how they were imported before the policy existed.
+type MissingSymptomOnsetPolicy string
+
+const (
+	// MissingSymptomOnsetKeep imports the keys without a days since symptom
+	// onset, and they are exported without one.
+	MissingSymptomOnsetKeep MissingSymptomOnsetPolicy = "keep"
+	// MissingSymptomOnsetDefault assigns the configured default days since
+	// symptom onset.
+	MissingSymptomOnsetDefault MissingSymptomOnsetPolicy = "default"
+	// MissingSymptomOnsetReject skips the keys.
+	MissingSymptomOnsetReject MissingSymptomOnsetPolicy = "reject"
+)
+
+// Validate returns an error if the policy is unknown.
+func (p MissingSymptomOnsetPolicy) Validate() error {
+	switch p {
+	case MissingSymptomOnsetKeep, MissingSymptomOnsetDefault, MissingSymptomOnsetReject:
+		return nil
+	default:
+		return fmt.Errorf("unknown missing symptom onset policy %q, must be %q, %q or %q",
+			p, MissingSymptomOnsetKeep, MissingSymptomOnsetDefault, MissingSymptomOnsetReject)
+	}
+}
+
+// applySymptomOnset sets the days since symptom onset of the exposure from the
+// federated key. The value is kept as it was sent, it is not recomputed,
+// so that it is exported unchanged. Keys without a days since symptom onset, or
+// with one outside of MAX_SYMPTOM_ONSET_DAYS, are handled according to the
+// missing symptom onset policy.
+func applySymptomOnset(exposure *publishmodel.Exposure, e *federation.ExposureKey, opts *pullOptions) error {
+	errMissing := ErrMissingSymptomOnset
+	if e.HasSymptomOnset {
+		max := int32(opts.maxMagnitudeSymptomOnsetDays)
+		ds := e.DaysSinceOnsetOfSymptoms
+		if ds >= -max && ds <= max {
+			exposure.SetDaysSinceSymptomOnset(ds)
+			return nil
+		}
+		errMissing = fmt.Errorf("%w: %d must be within %d", ErrSymptomOnsetOutOfRange, ds, max)
+	}
+
+	switch opts.config.MissingSymptomOnsetPolicy {
+	case MissingSymptomOnsetDefault:
+		exposure.SetDaysSinceSymptomOnset(opts.config.DefaultSymptomOnsetDays)
+	case MissingSymptomOnsetReject:
+		return errMissing
+	}
+	return nil
+}
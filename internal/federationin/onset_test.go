@@ -0,0 +1,297 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationin
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/export"
+	exportmodel "github.com/google/exposure-notifications-server/internal/export/model"
+	"github.com/google/exposure-notifications-server/internal/federationin/model"
+	"github.com/google/exposure-notifications-server/internal/pb/federation"
+	"github.com/google/exposure-notifications-server/internal/project"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMissingSymptomOnsetPolicy_Validate(t *testing.T) {
+	t.Parallel()
+
+	for _, p := range []MissingSymptomOnsetPolicy{MissingSymptomOnsetKeep, MissingSymptomOnsetDefault, MissingSymptomOnsetReject} {
+		if err := p.Validate(); err != nil {
+			t.Errorf("expected %q to be valid: %v", p, err)
+		}
+	}
+	if err := MissingSymptomOnsetPolicy("backfill").Validate(); err == nil {
+		t.Errorf("expected unknown policy to be invalid")
+	}
+}
+
+func TestApplySymptomOnset(t *testing.T) {
+	t.Parallel()
+
+	withOnset := func(ds int32) *federation.ExposureKey {
+		return &federation.ExposureKey{DaysSinceOnsetOfSymptoms: ds, HasSymptomOnset: true}
+	}
+
+	cases := []struct {
+		name   string
+		key    *federation.ExposureKey
+		policy MissingSymptomOnsetPolicy
+		want   *int32
+		err    error
+	}{
+		{"zero", withOnset(0), MissingSymptomOnsetKeep, int32Ptr(0), nil},
+		{"negative", withOnset(-14), MissingSymptomOnsetKeep, int32Ptr(-14), nil},
+		{"positive", withOnset(14), MissingSymptomOnsetKeep, int32Ptr(14), nil},
+		{"present_not_defaulted", withOnset(3), MissingSymptomOnsetDefault, int32Ptr(3), nil},
+		{"too_low_keep", withOnset(-15), MissingSymptomOnsetKeep, nil, nil},
+		{"too_high_keep", withOnset(15), MissingSymptomOnsetKeep, nil, nil},
+		{"too_high_default", withOnset(15), MissingSymptomOnsetDefault, int32Ptr(-2), nil},
+		{"too_low_reject", withOnset(-15), MissingSymptomOnsetReject, nil, ErrSymptomOnsetOutOfRange},
+		{"too_high_reject", withOnset(15), MissingSymptomOnsetReject, nil, ErrSymptomOnsetOutOfRange},
+		{"missing_keep", &federation.ExposureKey{}, MissingSymptomOnsetKeep, nil, nil},
+		{"missing_default", &federation.ExposureKey{}, MissingSymptomOnsetDefault, int32Ptr(-2), nil},
+		{"missing_reject", &federation.ExposureKey{}, MissingSymptomOnsetReject, nil, ErrMissingSymptomOnset},
+		// The value is ignored if the key says it has no symptom onset.
+		{"value_without_has", &federation.ExposureKey{DaysSinceOnsetOfSymptoms: 4}, MissingSymptomOnsetKeep, nil, nil},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			opts := &pullOptions{
+				maxMagnitudeSymptomOnsetDays: 14,
+				config: &Config{
+					MissingSymptomOnsetPolicy: tc.policy,
+					DefaultSymptomOnsetDays:   -2,
+				},
+			}
+			var exposure publishmodel.Exposure
+			err := applySymptomOnset(&exposure, tc.key, opts)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+			if diff := cmp.Diff(tc.want, exposure.DaysSinceSymptomOnset); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestPullSymptomOnsetRoundTrip imports keys with different days since
+// symptom onset and checks that they are exported unchanged.
+func TestPullSymptomOnsetRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	batchTime := time.Now().Truncate(time.Second)
+	intervalNumber := publishmodel.IntervalNumber(batchTime.Add(-2 * 24 * time.Hour))
+
+	onsets := []*int32{int32Ptr(-14), int32Ptr(-1), int32Ptr(0), int32Ptr(7), int32Ptr(14), nil}
+	keys := make([]*federation.ExposureKey, 0, len(onsets))
+	for i, ds := range onsets {
+		key := &federation.ExposureKey{
+			ExposureKey:    []byte{byte(i), 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+			IntervalNumber: intervalNumber,
+			IntervalCount:  144,
+			ReportType:     federation.ExposureKey_CONFIRMED_TEST,
+			Regions:        []string{"US"},
+		}
+		if ds != nil {
+			key.DaysSinceOnsetOfSymptoms = *ds
+			key.HasSymptomOnset = true
+		}
+		keys = append(keys, key)
+	}
+
+	remote := remoteFetchServer{responses: []*federation.FederationFetchResponse{
+		{
+			Keys: keys,
+			NextFetchState: &federation.FetchState{
+				KeyCursor:        &federation.Cursor{Timestamp: 100},
+				RevisedKeyCursor: &federation.Cursor{},
+			},
+		},
+	}}
+	idb := &publishDB{}
+	sdb := &syncDB{}
+	opts := pullOptions{
+		deps: pullDependencies{
+			fetch:               remote.fetch,
+			insertExposures:     idb.insertExposures,
+			startFederationSync: sdb.startFederationSync,
+		},
+		query:                        &model.FederationInQuery{QueryID: queryID},
+		batchStart:                   batchTime,
+		truncateWindow:               time.Hour,
+		maxIntervalStartAge:          14 * 24 * time.Hour,
+		maxMagnitudeSymptomOnsetDays: 14,
+		config:                       &Config{MissingSymptomOnsetPolicy: MissingSymptomOnsetKeep},
+	}
+	if err := pull(ctx, &opts); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(idb.exposures), len(onsets); got != want {
+		t.Fatalf("expected %d imported keys to be %d", got, want)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := &exportmodel.ExportBatch{
+		StartTimestamp: batchTime.Add(-time.Hour),
+		EndTimestamp:   batchTime,
+		OutputRegion:   "US",
+	}
+	blob, err := export.MarshalExportFile(batch, idb.exposures, nil, 1, false, []*export.Signer{
+		{
+			SignatureInfo: &exportmodel.SignatureInfo{SigningKeyVersion: "1", SigningKeyID: "310"},
+			Signer:        key,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, _, err := export.UnmarshalExportFile(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := make(map[byte]*int32, len(onsets))
+	for i, ds := range onsets {
+		want[byte(i)] = ds
+	}
+	got := make(map[byte]*int32, len(exported.Keys))
+	for _, k := range exported.Keys {
+		got[k.KeyData[0]] = k.DaysSinceOnsetOfSymptoms
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("days since symptom onset mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestPullRevisedKeysSymptomOnset(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	batchTime := time.Now().Truncate(time.Second)
+	intervalNumber := publishmodel.IntervalNumber(batchTime.Add(-2 * 24 * time.Hour))
+
+	revisedKey := func(b byte, reportType federation.ExposureKey_ReportType, ds *int32) *federation.ExposureKey {
+		key := &federation.ExposureKey{
+			ExposureKey:    []byte{b, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+			IntervalNumber: intervalNumber,
+			IntervalCount:  144,
+			ReportType:     reportType,
+			Regions:        []string{"US"},
+		}
+		if ds != nil {
+			key.DaysSinceOnsetOfSymptoms = *ds
+			key.HasSymptomOnset = true
+		}
+		return key
+	}
+
+	cases := []struct {
+		name    string
+		keys    []*federation.ExposureKey
+		want    []byte
+		wantErr error
+	}{
+		{
+			name: "invalid_onset_skipped",
+			keys: []*federation.ExposureKey{
+				revisedKey(0, federation.ExposureKey_CONFIRMED_TEST, int32Ptr(30)),
+				revisedKey(1, federation.ExposureKey_CONFIRMED_TEST, int32Ptr(3)),
+				revisedKey(2, federation.ExposureKey_CONFIRMED_TEST, nil),
+			},
+			want: []byte{1},
+		},
+		{
+			name: "invalid_report_type",
+			keys: []*federation.ExposureKey{
+				revisedKey(0, federation.ExposureKey_SELF_REPORT, int32Ptr(3)),
+			},
+			wantErr: ErrInvalidReportType,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			remote := remoteFetchServer{responses: []*federation.FederationFetchResponse{
+				{
+					RevisedKeys: tc.keys,
+					NextFetchState: &federation.FetchState{
+						KeyCursor:        &federation.Cursor{},
+						RevisedKeyCursor: &federation.Cursor{Timestamp: 100},
+					},
+				},
+			}}
+			idb := &publishDB{}
+			sdb := &syncDB{}
+			opts := pullOptions{
+				deps: pullDependencies{
+					fetch:               remote.fetch,
+					insertExposures:     idb.insertExposures,
+					startFederationSync: sdb.startFederationSync,
+				},
+				query:                        &model.FederationInQuery{QueryID: queryID},
+				batchStart:                   batchTime,
+				truncateWindow:               time.Hour,
+				maxIntervalStartAge:          14 * 24 * time.Hour,
+				maxMagnitudeSymptomOnsetDays: 14,
+				config:                       &Config{MissingSymptomOnsetPolicy: MissingSymptomOnsetReject},
+			}
+			err := pull(ctx, &opts)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]byte, 0, len(idb.exposures))
+			for _, exp := range idb.exposures {
+				got = append(got, exp.ExposureKey[0])
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("revised keys mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
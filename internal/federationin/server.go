@@ -16,6 +16,7 @@ package federationin
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/google/exposure-notifications-server/internal/federationin/database"
 	"github.com/google/exposure-notifications-server/internal/middleware"
@@ -31,14 +32,28 @@ type Server struct {
 	db        *database.FederationInDB
 	publishdb *publishdb.PublishDB
 	config    *Config
+
+	pullLimiter *pullLimiter
 }
 
 func NewServer(cfg *Config, env *serverenv.ServerEnv) (*Server, error) {
+	if cfg.InsertMaxAttempts > 1 && cfg.InsertRetryBackoff <= 0 {
+		return nil, fmt.Errorf("INSERT_RETRY_BACKOFF must be positive to retry inserts")
+	}
+	if err := cfg.MissingSymptomOnsetPolicy.Validate(); err != nil {
+		return nil, fmt.Errorf("MISSING_SYMPTOM_ONSET_POLICY: %w", err)
+	}
+	if max := int32(cfg.MaxMagnitudeSymptomOnsetDays); cfg.DefaultSymptomOnsetDays < -max || cfg.DefaultSymptomOnsetDays > max {
+		return nil, fmt.Errorf("DEFAULT_SYMPTOM_ONSET_DAYS must be within MAX_SYMPTOM_ONSET_DAYS, got: %d", cfg.DefaultSymptomOnsetDays)
+	}
+
 	return &Server{
 		env:       env,
 		db:        database.New(env.Database()),
 		publishdb: publishdb.New(env.Database()),
 		config:    cfg,
+
+		pullLimiter: newPullLimiter(cfg.MaxConcurrentPulls),
 	}, nil
 }
 
@@ -21,6 +21,7 @@ import (
 	"github.com/google/exposure-notifications-server/pkg/database"
 	"github.com/google/exposure-notifications-server/pkg/observability"
 	"github.com/google/exposure-notifications-server/pkg/secrets"
+	"github.com/google/exposure-notifications-server/pkg/server"
 )
 
 // Compile-time check to assert this config matches requirements.
@@ -41,16 +42,17 @@ type Config struct {
 	Timeout        time.Duration `env:"RPC_TIMEOUT, default=5m"`
 	TruncateWindow time.Duration `env:"TRUNCATE_WINDOW, default=1h"`
 
+	// ExcludeHealthAuthorities is a list of health authority issuers whose keys
+	// are never served to federation partners. This is in addition to health
+	// authorities that are marked as NoFederate.
+	ExcludeHealthAuthorities []string `env:"EXCLUDE_HEALTH_AUTHORITIES"`
+
 	// AllowAnyClient, if true, removes authentication requirements on the
 	// federation endpoint. In practice, this is only useful in local testing.
 	AllowAnyClient bool `env:"ALLOW_ANY_CLIENT"`
 
-	// TLSCertFile is the certificate file to use if TLS encryption is enabled on
-	// the server. If present, TLSKeyFile must also be present. These settings
-	// should be left blank on Managed Cloud Run where the TLS termination is
-	// handled by the environment.
-	TLSCertFile string `env:"TLS_CERT_FILE"`
-	TLSKeyFile  string `env:"TLS_KEY_FILE"`
+	// TLS configures TLS encryption on the gRPC server.
+	TLS server.TLSConfig
 }
 
 func (c *Config) DatabaseConfig() *database.Config {
@@ -41,15 +41,15 @@ func (db *FederationOutDB) AddFederationOutAuthorization(ctx context.Context, au
 		q := `
 			INSERT INTO
 				FederationOutAuthorization
-				(oidc_issuer, oidc_subject, oidc_audience, note, include_regions, exclude_regions)
+				(oidc_issuer, oidc_subject, oidc_audience, note, include_regions, exclude_regions, oidc_alternate_audiences)
 			VALUES
-				($1, $2, $3, $4, $5, $6)
+				($1, $2, $3, $4, $5, $6, $7)
 			ON CONFLICT ON CONSTRAINT
 				federation_authorization_pk
 			DO UPDATE
-				SET oidc_audience = $3, note = $4, include_regions = $5, exclude_regions = $6
+				SET oidc_audience = $3, note = $4, include_regions = $5, exclude_regions = $6, oidc_alternate_audiences = $7
 		`
-		_, err := tx.Exec(ctx, q, auth.Issuer, auth.Subject, auth.Audience, auth.Note, auth.IncludeRegions, auth.ExcludeRegions)
+		_, err := tx.Exec(ctx, q, auth.Issuer, auth.Subject, auth.Audience, auth.Note, auth.IncludeRegions, auth.ExcludeRegions, auth.AlternateAudiences)
 		if err != nil {
 			return fmt.Errorf("upserting federation authorization: %w", err)
 		}
@@ -64,7 +64,7 @@ func (db *FederationOutDB) GetFederationOutAuthorization(ctx context.Context, is
 	if err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
 		row := tx.QueryRow(ctx, `
 			SELECT
-				oidc_issuer, oidc_subject, oidc_audience, note, include_regions, exclude_regions
+				oidc_issuer, oidc_subject, oidc_audience, note, include_regions, exclude_regions, oidc_alternate_audiences
 			FROM
 				FederationOutAuthorization
 			WHERE
@@ -74,7 +74,7 @@ func (db *FederationOutDB) GetFederationOutAuthorization(ctx context.Context, is
 			LIMIT 1
 		`, issuer, subject)
 
-		if err := row.Scan(&auth.Issuer, &auth.Subject, &auth.Audience, &auth.Note, &auth.IncludeRegions, &auth.ExcludeRegions); err != nil {
+		if err := row.Scan(&auth.Issuer, &auth.Subject, &auth.Audience, &auth.Note, &auth.IncludeRegions, &auth.ExcludeRegions, &auth.AlternateAudiences); err != nil {
 			if errors.Is(err, pgx.ErrNoRows) {
 				return database.ErrNotFound
 			}
@@ -33,12 +33,13 @@ func TestFederationOutAuthorization(t *testing.T) {
 	testDB, _ := testDatabaseInstance.NewDatabase(t)
 
 	want := &model.FederationOutAuthorization{
-		Issuer:         "iss",
-		Subject:        "sub",
-		Audience:       "aud",
-		Note:           "some note",
-		IncludeRegions: []string{"MX"},
-		ExcludeRegions: []string{"CA"},
+		Issuer:             "iss",
+		Subject:            "sub",
+		Audience:           "aud",
+		AlternateAudiences: []string{"new-aud"},
+		Note:               "some note",
+		IncludeRegions:     []string{"MX"},
+		ExcludeRegions:     []string{"CA"},
 	}
 
 	// GetFederationOutAuthorization should fail if not found.
@@ -59,6 +60,7 @@ func TestFederationOutAuthorization(t *testing.T) {
 
 	// AddFederationOutAuthorization should overwrite.
 	want.Note = "a different note"
+	want.AlternateAudiences = []string{"new-aud", "newer-aud"}
 	if err := New(testDB).AddFederationOutAuthorization(ctx, want); err != nil {
 		t.Fatal(err)
 	}
@@ -26,6 +26,7 @@ import (
 
 	"github.com/google/exposure-notifications-server/internal/federationin/model"
 	"github.com/google/exposure-notifications-server/internal/federationout/database"
+	"github.com/google/exposure-notifications-server/internal/federationrequest"
 	"github.com/google/exposure-notifications-server/internal/pb/federation"
 	"github.com/google/exposure-notifications-server/internal/project"
 	publishdb "github.com/google/exposure-notifications-server/internal/publish/database"
@@ -36,11 +37,16 @@ import (
 	"go.opencensus.io/stats"
 
 	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/uuid"
 	"google.golang.org/api/idtoken"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+
+	// Registers gzip, so fetch responses are compressed for partners that
+	// compress their requests. Other partners are served uncompressed.
+	_ "google.golang.org/grpc/encoding/gzip"
 )
 
 const (
@@ -140,6 +146,10 @@ func (s Server) fetch(ctx context.Context, req *federation.FederationFetchReques
 		OnlyTravelers:       req.OnlyTravelers,
 		OnlyLocalProvenance: req.OnlyLocalProvenance, // Include re-federation?
 		Limit:               maxRecords,
+		// Keys from excluded health authorities are never served, regardless of
+		// the request.
+		ExcludeNoFederate:             true,
+		ExcludeHealthAuthorityIssuers: s.config.ExcludeHealthAuthorities,
 	}
 	// The next token wil be set during the read if the read is incomplete.
 	state.KeyCursor.NextToken = ""
@@ -335,6 +345,30 @@ func buildIteratorFunction(request *BuildIteratorRequest) publishdb.IteratorFunc
 	}
 }
 
+// RequestIDInterceptor reads the federation request id sent by the client, or
+// generates one, and adds it to the logger on the context. The id is echoed in
+// the response header so the client can log it alongside its own.
+func (s Server) RequestIDInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	id := federationrequest.IDFromIncomingContext(ctx)
+	if id == "" {
+		u, err := uuid.NewRandom()
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Internal error")
+		}
+		id = u.String()
+	}
+
+	logger := logging.FromContext(ctx).With("federation_request_id", id)
+	ctx = logging.WithLogger(ctx, logger)
+
+	if err := grpc.SetHeader(ctx, metadata.Pairs(federationrequest.IDMetadataKey, id)); err != nil {
+		logger.Warnw("failed to set federation request id header", "error", err)
+	}
+
+	logger.Infow("federation request", "method", info.FullMethod)
+	return handler(ctx, req)
+}
+
 // AuthInterceptor validates incoming OIDC bearer token and adds corresponding FederationAuthorization record to the context.
 func (s Server) AuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 	logger := logging.FromContext(ctx).Named("federationout.AuthInterceptor")
@@ -364,10 +398,8 @@ func (s Server) AuthInterceptor(ctx context.Context, req interface{}, info *grpc
 		return nil, status.Errorf(codes.Internal, "Internal error")
 	}
 
-	if auth.Audience != "" && auth.Audience != token.Audience {
-		stats.Record(ctx, mFetchInvalidAudience.M(1))
-		logger.Infof("Invalid audience, got %q, want %q", token.Audience, auth.Audience)
-		return nil, status.Errorf(codes.Unauthenticated, "Invalid audience")
+	if err := validateAudience(ctx, auth, token.Audience); err != nil {
+		return nil, err
 	}
 
 	// Store the FederationAuthorization on the context.
@@ -376,6 +408,18 @@ func (s Server) AuthInterceptor(ctx context.Context, req interface{}, info *grpc
 	return handler(ctx, req)
 }
 
+// validateAudience checks the audience of the caller's token against the ones
+// the authorization accepts.
+func validateAudience(ctx context.Context, auth *model.FederationOutAuthorization, aud string) error {
+	if auth.AcceptsAudience(aud) {
+		return nil
+	}
+	stats.Record(ctx, mFetchInvalidAudience.M(1))
+	logging.FromContext(ctx).Infow("Invalid audience",
+		"issuer", auth.Issuer, "subject", auth.Subject, "got", aud, "want", auth.AcceptableAudiences())
+	return status.Errorf(codes.Unauthenticated, "Invalid audience")
+}
+
 func rawToken(ctx context.Context) (string, error) {
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
@@ -17,18 +17,31 @@ package federationout
 import (
 	"context"
 	"fmt"
+	"net"
+	"sync"
 	"testing"
 	"time"
 
+	fedmodel "github.com/google/exposure-notifications-server/internal/federationin/model"
+	"github.com/google/exposure-notifications-server/internal/federationrequest"
 	"github.com/google/exposure-notifications-server/internal/pb/federation"
 	"github.com/google/exposure-notifications-server/internal/project"
 	publishdb "github.com/google/exposure-notifications-server/internal/publish/database"
 	"github.com/google/exposure-notifications-server/internal/publish/model"
 	"github.com/google/exposure-notifications-server/internal/serverenv"
 	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
+	"github.com/google/exposure-notifications-server/pkg/logging"
 
 	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding/gzip"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
 	"google.golang.org/protobuf/testing/protocmp"
 )
 
@@ -546,6 +559,168 @@ func TestFetch(t *testing.T) {
 	}
 }
 
+// TestFetchExcludesHealthAuthorities tests that keys from excluded health
+// authorities are never requested, regardless of the fetch request.
+func TestFetchExcludesHealthAuthorities(t *testing.T) {
+	t.Parallel()
+
+	server := Server{
+		env: serverenv.New(project.TestContext(t)),
+		config: &Config{
+			MaxRecords:               100,
+			ExcludeHealthAuthorities: []string{"excluded"},
+		},
+	}
+	req := &federation.FederationFetchRequest{
+		IncludeRegions: []string{"US"},
+	}
+
+	var got []publishdb.IterateExposuresCriteria
+	itFunc := func(_ context.Context, criteria publishdb.IterateExposuresCriteria, _ publishdb.IteratorFunction) (string, error) {
+		got = append(got, criteria)
+		return "", nil
+	}
+
+	if _, err := server.fetch(project.TestContext(t), req, itFunc, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both the primary and revised keys are read.
+	if got, want := len(got), 2; got != want {
+		t.Fatalf("expected %d iterations, got %d", want, got)
+	}
+	for _, criteria := range got {
+		if !criteria.ExcludeNoFederate {
+			t.Errorf("expected no-federate health authorities to be excluded: %#v", criteria)
+		}
+		if diff := cmp.Diff([]string{"excluded"}, criteria.ExcludeHealthAuthorityIssuers); diff != "" {
+			t.Errorf("excluded issuers mismatch (-want +got):\n%s", diff)
+		}
+	}
+}
+
+// fetchServer serves fetch requests from a fixed set of exposures.
+type fetchServer struct {
+	federation.UnimplementedFederationServer
+
+	server    *Server
+	exposures []*model.Exposure
+}
+
+func (f *fetchServer) Fetch(ctx context.Context, req *federation.FederationFetchRequest) (*federation.FederationFetchResponse, error) {
+	itFunc := func(_ context.Context, criteria publishdb.IterateExposuresCriteria, fn publishdb.IteratorFunction) (string, error) {
+		if criteria.OnlyRevisedKeys {
+			return "", nil
+		}
+		for _, e := range f.exposures {
+			if err := fn(e); err != nil {
+				return "", err
+			}
+		}
+		return "", nil
+	}
+	return f.server.fetch(ctx, req, itFunc, time.Now())
+}
+
+// payloadRecorder is a client stats.Handler that records the sizes of the last
+// response received.
+type payloadRecorder struct {
+	mu         sync.Mutex
+	length     int
+	wireLength int
+}
+
+func (r *payloadRecorder) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (r *payloadRecorder) HandleRPC(_ context.Context, s stats.RPCStats) {
+	if p, ok := s.(*stats.InPayload); ok {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.length, r.wireLength = p.Length, p.WireLength
+	}
+}
+
+func (r *payloadRecorder) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (r *payloadRecorder) HandleConn(context.Context, stats.ConnStats) {}
+
+func (r *payloadRecorder) sizes() (int, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.length, r.wireLength
+}
+
+// TestFetchCompression tests that compressed and uncompressed fetches return
+// the same keys, and that the response is only compressed when the partner
+// compresses its request.
+func TestFetchCompression(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	var exposures []*model.Exposure
+	for i := 0; i < 200; i++ {
+		key := &federation.ExposureKey{ExposureKey: []byte(fmt.Sprintf("key%013d", i)), IntervalNumber: int32(i + 1)}
+		exposures = append(exposures, makeExposure(key, verifyapi.ReportTypeConfirmed, "US", false))
+	}
+
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer()
+	federation.RegisterFederationServer(grpcServer, &fetchServer{
+		server: &Server{
+			env:    serverenv.New(ctx),
+			config: &Config{MaxRecords: 500},
+		},
+		exposures: exposures,
+	})
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	recorder := &payloadRecorder{}
+	conn, err := grpc.DialContext(ctx, "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+		grpc.WithInsecure(),
+		grpc.WithStatsHandler(recorder))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client := federation.NewFederationClient(conn)
+
+	req := &federation.FederationFetchRequest{IncludeRegions: []string{"US"}}
+
+	uncompressed, err := client.Fetch(ctx, req)
+	if err != nil {
+		t.Fatalf("uncompressed fetch: %v", err)
+	}
+	length, wireLength := recorder.sizes()
+	if wireLength < length {
+		t.Errorf("expected uncompressed response, got %d bytes on the wire for %d bytes", wireLength, length)
+	}
+
+	compressed, err := client.Fetch(ctx, req, grpc.UseCompressor(gzip.Name))
+	if err != nil {
+		t.Fatalf("compressed fetch: %v", err)
+	}
+	length, wireLength = recorder.sizes()
+	if wireLength >= length {
+		t.Errorf("expected compressed response, got %d bytes on the wire for %d bytes", wireLength, length)
+	}
+
+	if got, want := len(uncompressed.Keys), len(exposures); got != want {
+		t.Errorf("expected %d keys, got %d", want, got)
+	}
+	if diff := cmp.Diff(uncompressed, compressed, listsAsSets...); diff != "" {
+		t.Errorf("compressed fetch mismatch (-uncompressed +compressed):\n%s", diff)
+	}
+}
+
 // TestRawToken tests rawToken().
 func TestRawToken(t *testing.T) {
 	t.Parallel()
@@ -731,3 +906,131 @@ func TestUnion(t *testing.T) {
 		})
 	}
 }
+
+func TestRequestIDInterceptor(t *testing.T) {
+	t.Parallel()
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/Federation/Fetch"}
+
+	cases := []struct {
+		name string
+		md   metadata.MD
+		want string
+	}{
+		{
+			name: "from_client",
+			md:   metadata.Pairs(federationrequest.IDMetadataKey, "client-id"),
+			want: "client-id",
+		},
+		{
+			name: "generated",
+			md:   metadata.MD{},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			core, logs := observer.New(zap.InfoLevel)
+			ctx := logging.WithLogger(project.TestContext(t), zap.New(core).Sugar())
+			ctx = metadata.NewIncomingContext(ctx, tc.md)
+
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				logging.FromContext(ctx).Info("in handler")
+				return "ok", nil
+			}
+
+			// There is no stream in this context, so the response header can't be
+			// set. That is only logged, the request is still handled.
+			if _, err := (Server{}).RequestIDInterceptor(ctx, nil, info, handler); err != nil {
+				t.Fatal(err)
+			}
+
+			entries := logs.FilterMessage("in handler").All()
+			if len(entries) != 1 {
+				t.Fatalf("expected handler to log once, got %d", len(entries))
+			}
+			var got string
+			for _, f := range entries[0].Context {
+				if f.Key == "federation_request_id" {
+					got = f.String
+				}
+			}
+			if got == "" {
+				t.Fatal("expected federation_request_id on handler logger")
+			}
+			if tc.want != "" && got != tc.want {
+				t.Errorf("expected federation_request_id %q to be %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateAudience(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		auth *fedmodel.FederationOutAuthorization
+		aud  string
+		ok   bool
+	}{
+		{
+			name: "not_enforced",
+			auth: &fedmodel.FederationOutAuthorization{},
+			aud:  "anything",
+			ok:   true,
+		},
+		{
+			name: "primary",
+			auth: &fedmodel.FederationOutAuthorization{Audience: "aud", AlternateAudiences: []string{"new-aud"}},
+			aud:  "aud",
+			ok:   true,
+		},
+		{
+			name: "alternate",
+			auth: &fedmodel.FederationOutAuthorization{Audience: "aud", AlternateAudiences: []string{"new-aud"}},
+			aud:  "new-aud",
+			ok:   true,
+		},
+		{
+			name: "invalid",
+			auth: &fedmodel.FederationOutAuthorization{Audience: "aud", AlternateAudiences: []string{"new-aud"}},
+			aud:  "other-aud",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			core, logs := observer.New(zap.InfoLevel)
+			ctx := logging.WithLogger(project.TestContext(t), zap.New(core).Sugar())
+
+			err := validateAudience(ctx, tc.auth, tc.aud)
+			if tc.ok {
+				if err != nil {
+					t.Fatalf("expected audience %q to be accepted: %v", tc.aud, err)
+				}
+				return
+			}
+			if got := status.Code(err); got != codes.Unauthenticated {
+				t.Fatalf("expected code %v to be %v: %v", got, codes.Unauthenticated, err)
+			}
+
+			// The mismatch is logged with the acceptable audiences.
+			entries := logs.FilterMessage("Invalid audience").All()
+			if len(entries) != 1 {
+				t.Fatalf("expected mismatch to be logged once, got %d", len(entries))
+			}
+			if got, want := fmt.Sprint(entries[0].ContextMap()["want"]), "[aud new-aud]"; got != want {
+				t.Errorf("expected logged audiences %s to be %s", got, want)
+			}
+		})
+	}
+}
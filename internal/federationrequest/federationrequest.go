@@ -0,0 +1,50 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package federationrequest carries the federation request id between the
+// federation client and server.
+package federationrequest
+
+import (
+	"context"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// IDMetadataKey is the gRPC metadata key that carries the federation
+// request id. The client sends it on each fetch and the server echoes the id it
+// used in the response header, so both sides can correlate their logs.
+const IDMetadataKey = "x-federation-request-id"
+
+// NewOutgoingContext returns a context that sends the request id as
+// metadata on outgoing calls.
+func NewOutgoingContext(ctx context.Context, id string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, IDMetadataKey, id)
+}
+
+// IDFromIncomingContext returns the request id sent by the client, or
+// the empty string if there isn't one.
+func IDFromIncomingContext(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return IDFromMetadata(md)
+}
+
+// IDFromMetadata returns the request id from the metadata, or the empty
+// string if there isn't one.
+func IDFromMetadata(md metadata.MD) string {
+	if vals := md.Get(IDMetadataKey); len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
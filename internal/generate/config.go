@@ -18,11 +18,13 @@ package generate
 import (
 	"time"
 
+	"github.com/google/exposure-notifications-server/internal/middleware"
 	"github.com/google/exposure-notifications-server/internal/publish/model"
 	"github.com/google/exposure-notifications-server/internal/setup"
 	"github.com/google/exposure-notifications-server/pkg/database"
 	"github.com/google/exposure-notifications-server/pkg/observability"
 	"github.com/google/exposure-notifications-server/pkg/secrets"
+	"github.com/google/exposure-notifications-server/pkg/server"
 )
 
 // Compile-time check to assert this config matches requirements.
@@ -39,6 +41,8 @@ type Config struct {
 	Database              database.Config
 	SecretManager         secrets.Config
 	ObservabilityExporter observability.Config
+	TLS                   server.TLSConfig
+	SecurityHeaders       middleware.SecurityHeadersConfig
 
 	Port                         string        `env:"PORT, default=8080"`
 	NumExposures                 int           `env:"NUM_EXPOSURES_GENERATED, default=10"`
@@ -90,6 +94,38 @@ func (c *Config) DebugReleaseSameDayKeys() bool {
 	return false
 }
 
+func (c *Config) DefaultReportTypeTransmissionRisks() map[string]int {
+	return nil
+}
+
+func (c *Config) CertificateOnsetMaxDaysBefore() uint {
+	return 0
+}
+
+func (c *Config) CertificateOnsetMaxDaysAfter() uint {
+	return 0
+}
+
+func (c *Config) RejectCertificateOnsetOutliers() bool {
+	return false
+}
+
+func (c *Config) MaxExposureKeyAge() time.Duration {
+	return 0
+}
+
+func (c *Config) RejectTooOldExposureKeys() bool {
+	return false
+}
+
+func (c *Config) MaxRegionsPerKey() uint {
+	return 0
+}
+
+func (c *Config) KeyValidators() []string {
+	return nil
+}
+
 func (c *Config) DatabaseConfig() *database.Config {
 	return &c.Database
 }
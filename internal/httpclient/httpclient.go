@@ -0,0 +1,92 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package httpclient builds HTTP clients for outbound calls with consistent,
+// configurable timeouts and connection pooling.
+package httpclient
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// Config represents the transport settings for outbound HTTP clients. The
+// overall request timeout is provided when the client is created, since it
+// varies by call site.
+type Config struct {
+	// DialTimeout is the maximum amount of time to wait for a TCP connection.
+	DialTimeout time.Duration `env:"HTTP_CLIENT_DIAL_TIMEOUT, default=10s"`
+
+	// KeepAlive is the interval between TCP keep-alive probes. Set to a
+	// negative value to disable keep-alives.
+	KeepAlive time.Duration `env:"HTTP_CLIENT_KEEP_ALIVE, default=30s"`
+
+	// TLSHandshakeTimeout is the maximum amount of time to wait for a TLS
+	// handshake.
+	TLSHandshakeTimeout time.Duration `env:"HTTP_CLIENT_TLS_HANDSHAKE_TIMEOUT, default=10s"`
+
+	// ResponseHeaderTimeout is the maximum amount of time to wait for the
+	// response headers after the request has been written. This does not
+	// include the time to read the response body.
+	ResponseHeaderTimeout time.Duration `env:"HTTP_CLIENT_RESPONSE_HEADER_TIMEOUT, default=30s"`
+
+	// IdleConnTimeout is the maximum amount of time an idle connection is kept
+	// in the pool.
+	IdleConnTimeout time.Duration `env:"HTTP_CLIENT_IDLE_CONN_TIMEOUT, default=90s"`
+
+	// MaxIdleConns and MaxIdleConnsPerHost control the number of idle
+	// connections kept in the pool, overall and per host.
+	MaxIdleConns        int `env:"HTTP_CLIENT_MAX_IDLE_CONNS, default=100"`
+	MaxIdleConnsPerHost int `env:"HTTP_CLIENT_MAX_IDLE_CONNS_PER_HOST, default=10"`
+}
+
+// Dialer returns a dialer with the configured connect timeout and keep-alive.
+func (c *Config) Dialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout:   c.DialTimeout,
+		KeepAlive: c.KeepAlive,
+	}
+}
+
+// Transport returns a new transport with the configured settings. It otherwise
+// matches http.DefaultTransport, including honoring proxy environment
+// variables.
+func (c *Config) Transport() *http.Transport {
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           c.Dialer().DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   c.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: c.ResponseHeaderTimeout,
+		IdleConnTimeout:       c.IdleConnTimeout,
+		MaxIdleConns:          c.MaxIdleConns,
+		MaxIdleConnsPerHost:   c.MaxIdleConnsPerHost,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// New creates an HTTP client using the transport settings in the config and
+// the given overall request timeout. A timeout of 0 means no overall timeout.
+// If cfg is nil, the transport has no additional timeouts.
+func New(cfg *Config, timeout time.Duration) *http.Client {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+
+	return &http.Client{
+		Transport: cfg.Transport(),
+		Timeout:   timeout,
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/sethvargo/go-envconfig"
+)
+
+func TestConfig_Defaults(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	var cfg Config
+	if err := envconfig.ProcessWith(ctx, &cfg, envconfig.MapLookuper(nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	transport := cfg.Transport()
+	if got, want := transport.ResponseHeaderTimeout, 30*time.Second; got != want {
+		t.Errorf("expected response header timeout %v to be %v", got, want)
+	}
+	if got, want := transport.TLSHandshakeTimeout, 10*time.Second; got != want {
+		t.Errorf("expected TLS handshake timeout %v to be %v", got, want)
+	}
+	if got, want := transport.MaxIdleConnsPerHost, 10; got != want {
+		t.Errorf("expected max idle conns per host %v to be %v", got, want)
+	}
+}
+
+func TestNew_ResponseHeaderTimeout(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-time.After(5 * time.Second):
+			case <-done:
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+	// Registered after srv.Close so it runs first and unblocks the handler.
+	t.Cleanup(func() { close(done) })
+
+	client := New(&Config{
+		ResponseHeaderTimeout: 100 * time.Millisecond,
+	}, 10*time.Second)
+
+	// Fast endpoint succeeds.
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/fast", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// Slow endpoint hits the response header timeout well before the overall
+	// client timeout.
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/slow", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	resp, err = client.Do(req)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "timeout awaiting response headers") {
+		t.Errorf("expected response header timeout, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected request to time out quickly, took %v", elapsed)
+	}
+}
+
+func TestNew_NilConfig(t *testing.T) {
+	t.Parallel()
+
+	client := New(nil, time.Minute)
+	if got, want := client.Timeout, time.Minute; got != want {
+		t.Errorf("expected timeout %v to be %v", got, want)
+	}
+	if client.Transport == nil {
+		t.Errorf("expected transport to be set")
+	}
+}
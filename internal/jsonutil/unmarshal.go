@@ -16,6 +16,7 @@
 package jsonutil
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -33,15 +34,71 @@ const (
 
 // Unmarshal provides a common implementation of JSON unmarshalling with well defined error handling.
 func Unmarshal(w http.ResponseWriter, r *http.Request, data interface{}) (int, error) {
+	return UnmarshalWithLimit(w, r, data, maxBodyBytes)
+}
+
+// UnmarshalWithLimit is like Unmarshal, but reads at most maxBytes of the
+// body. Larger bodies are rejected with http.StatusRequestEntityTooLarge
+// before they are fully read. A limit <= 0 uses the default limit of
+// Unmarshal.
+func UnmarshalWithLimit(w http.ResponseWriter, r *http.Request, data interface{}, maxBytes int64) (int, error) {
+	return UnmarshalWithOptions(w, r, data, &UnmarshalOptions{MaxBytes: maxBytes})
+}
+
+// UnmarshalOptions change how UnmarshalWithOptions decodes the body.
+type UnmarshalOptions struct {
+	// MaxBytes is the largest body that is read. A limit <= 0 uses the default
+	// limit of Unmarshal.
+	MaxBytes int64
+
+	// AllowUnknownFields ignores fields of the body that don't match a field of
+	// the data, instead of rejecting the body.
+	AllowUnknownFields bool
+
+	// FieldAliases maps alternate names of top level fields to the name they
+	// are decoded as. Alias names are matched exactly. A body that sets both an
+	// alias and the field it maps to is rejected.
+	FieldAliases map[string]string
+}
+
+// UnmarshalWithOptions is like Unmarshal, but decodes the body as configured
+// by the options.
+func UnmarshalWithOptions(w http.ResponseWriter, r *http.Request, data interface{}, opts *UnmarshalOptions) (int, error) {
+	if opts == nil {
+		opts = &UnmarshalOptions{}
+	}
+	maxBytes := opts.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = maxBodyBytes
+	}
+
 	if t := r.Header.Get("content-type"); len(t) < 16 || t[:16] != "application/json" {
 		return http.StatusUnsupportedMediaType, fmt.Errorf("content-type is not application/json")
 	}
 
 	defer r.Body.Close()
-	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+	var body io.Reader = r.Body
+	if len(opts.FieldAliases) > 0 {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			if err.Error() == "http: request body too large" {
+				return http.StatusRequestEntityTooLarge, err
+			}
+			return http.StatusInternalServerError, fmt.Errorf("failed to read body: %w", err)
+		}
+		b, err = renameFields(b, opts.FieldAliases)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		body = bytes.NewReader(b)
+	}
 
-	d := json.NewDecoder(r.Body)
-	d.DisallowUnknownFields()
+	d := json.NewDecoder(body)
+	if !opts.AllowUnknownFields {
+		d.DisallowUnknownFields()
+	}
 
 	if err := d.Decode(&data); err != nil {
 		var syntaxErr *json.SyntaxError
@@ -70,3 +127,36 @@ func Unmarshal(w http.ResponseWriter, r *http.Request, data interface{}) (int, e
 
 	return http.StatusOK, nil
 }
+
+// renameFields renames the aliased top level fields of the JSON object in b.
+// If b isn't a JSON object, it is returned unchanged so decoding reports the
+// error.
+func renameFields(b []byte, aliases map[string]string) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil || fields == nil {
+		return b, nil
+	}
+
+	renamed := false
+	for alias, name := range aliases {
+		v, ok := fields[alias]
+		if !ok {
+			continue
+		}
+		if _, ok := fields[name]; ok {
+			return nil, fmt.Errorf("field %s cannot be used with %s", alias, name)
+		}
+		delete(fields, alias)
+		fields[name] = v
+		renamed = true
+	}
+	if !renamed {
+		return b, nil
+	}
+
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rename fields: %w", err)
+	}
+	return out, nil
+}
@@ -47,6 +47,47 @@ func TestBodyTooLarge(t *testing.T) {
 	unmarshalTestHelper(t, []string{string(largeJSON)}, errors, http.StatusRequestEntityTooLarge)
 }
 
+func TestUnmarshalWithLimit(t *testing.T) {
+	t.Parallel()
+
+	body := `{"appPackageName": "com.example.app", "padding": "` + strings.Repeat("0", 100) + `"}`
+
+	cases := []struct {
+		name     string
+		maxBytes int64
+		code     int
+		err      string
+	}{
+		{name: "under_limit", maxBytes: int64(len(body)), code: http.StatusOK},
+		{name: "over_limit", maxBytes: int64(len(body)) - 1, code: http.StatusRequestEntityTooLarge, err: "http: request body too large"},
+		{name: "default_limit", maxBytes: 0, code: http.StatusOK},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+			r.Header.Set("content-type", "application/json")
+			w := httptest.NewRecorder()
+
+			code, err := UnmarshalWithLimit(w, r, &verifyapi.Publish{}, tc.maxBytes)
+			if code != tc.code {
+				t.Errorf("unmarshal wanted %v response code, got %v", tc.code, code)
+			}
+			if tc.err == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			} else if err == nil || err.Error() != tc.err {
+				t.Errorf("expected error %q, got: %v", tc.err, err)
+			}
+		})
+	}
+}
+
 func TestInvalidHeader(t *testing.T) {
 	t.Parallel()
 
@@ -210,3 +251,107 @@ func unmarshalTestHelper(t *testing.T, payloads []string, errors []string, expCo
 		}
 	}
 }
+
+func TestUnmarshalWithOptions(t *testing.T) {
+	t.Parallel()
+
+	aliases := map[string]string{
+		"packageName": "appPackageName",
+	}
+
+	cases := []struct {
+		name string
+		body string
+		opts *UnmarshalOptions
+		code int
+		err  string
+		want *verifyapi.Publish
+	}{
+		{
+			name: "nil_options",
+			body: `{"appPackageName": "com.example.app"}`,
+			code: http.StatusOK,
+			want: &verifyapi.Publish{AppPackageName: "com.example.app"},
+		},
+		{
+			name: "alias",
+			body: `{"packageName": "com.example.app", "padding": "abc"}`,
+			opts: &UnmarshalOptions{FieldAliases: aliases},
+			code: http.StatusOK,
+			want: &verifyapi.Publish{AppPackageName: "com.example.app", Padding: "abc"},
+		},
+		{
+			name: "alias_not_enabled",
+			body: `{"packageName": "com.example.app"}`,
+			opts: &UnmarshalOptions{},
+			code: http.StatusBadRequest,
+			err:  `unknown field "packageName"`,
+		},
+		{
+			name: "alias_and_field",
+			body: `{"packageName": "com.example.app", "appPackageName": "com.example.app"}`,
+			opts: &UnmarshalOptions{FieldAliases: aliases},
+			code: http.StatusBadRequest,
+			err:  "field packageName cannot be used with appPackageName",
+		},
+		{
+			name: "alias_malformed",
+			body: `{"packageName": "com.example.app"`,
+			opts: &UnmarshalOptions{FieldAliases: aliases},
+			code: http.StatusBadRequest,
+			err:  "malformed json",
+		},
+		{
+			name: "alias_too_large",
+			body: `{"packageName": "com.example.app"}`,
+			opts: &UnmarshalOptions{MaxBytes: 10, FieldAliases: aliases},
+			code: http.StatusRequestEntityTooLarge,
+			err:  "http: request body too large",
+		},
+		{
+			name: "strict_unknown_field",
+			body: `{"appPackageName": "com.example.app", "unknown": true}`,
+			opts: &UnmarshalOptions{},
+			code: http.StatusBadRequest,
+			err:  `unknown field "unknown"`,
+		},
+		{
+			name: "allow_unknown_field",
+			body: `{"appPackageName": "com.example.app", "unknown": true}`,
+			opts: &UnmarshalOptions{AllowUnknownFields: true},
+			code: http.StatusOK,
+			want: &verifyapi.Publish{AppPackageName: "com.example.app"},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest("POST", "/", strings.NewReader(tc.body))
+			r.Header.Set("content-type", "application/json")
+			w := httptest.NewRecorder()
+
+			got := &verifyapi.Publish{}
+			code, err := UnmarshalWithOptions(w, r, got, tc.opts)
+			if code != tc.code {
+				t.Errorf("unmarshal wanted %v response code, got %v", tc.code, code)
+			}
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			} else if err == nil || err.Error() != tc.err {
+				t.Fatalf("expected error %q, got %v", tc.err, err)
+			}
+
+			if tc.want != nil {
+				if diff := cmp.Diff(tc.want, got); diff != "" {
+					t.Errorf("unmarshal mismatch (-want +got):\n%v", diff)
+				}
+			}
+		})
+	}
+}
@@ -17,10 +17,13 @@ package jwks
 import (
 	"time"
 
+	"github.com/google/exposure-notifications-server/internal/httpclient"
+	"github.com/google/exposure-notifications-server/internal/middleware"
 	"github.com/google/exposure-notifications-server/internal/setup"
 	"github.com/google/exposure-notifications-server/pkg/database"
 	"github.com/google/exposure-notifications-server/pkg/observability"
 	"github.com/google/exposure-notifications-server/pkg/secrets"
+	"github.com/google/exposure-notifications-server/pkg/server"
 )
 
 var (
@@ -33,6 +36,9 @@ type Config struct {
 	Database              database.Config
 	ObservabilityExporter observability.Config
 	SecretManager         secrets.Config
+	HTTPClient            httpclient.Config
+	TLS                   server.TLSConfig
+	SecurityHeaders       middleware.SecurityHeadersConfig
 
 	Port string `env:"PORT, default=8080"`
 
@@ -47,6 +53,10 @@ type Config struct {
 
 	// MaxWorkers is the number of parallel JWKS updates that can occur.
 	MaxWorkers uint `env:"MAX_WORKERS, default=5"`
+
+	// MaxActiveKeyVersions is the maximum number of active (not revoked) key
+	// versions a health authority can have. Zero, the default, means no limit.
+	MaxActiveKeyVersions uint `env:"HEALTH_AUTHORITY_MAX_ACTIVE_KEY_VERSIONS, default=0"`
 }
 
 func (c *Config) DatabaseConfig() *database.Config {
@@ -48,23 +48,28 @@ type Manager struct {
 	client     *http.Client
 	cleanupTTL time.Duration
 	maxWorkers uint
+
+	// maxKeyVersions is the maximum number of active key versions per health
+	// authority, zero means no limit.
+	maxKeyVersions uint
 }
 
-// NewManager creates a new Manager.
-func NewManager(db *database.DB, cleanupTTL, requestTimeout time.Duration, maxWorkers uint) (*Manager, error) {
+// NewManager creates a new Manager. The client is used to fetch the remote
+// JWKS documents.
+func NewManager(db *database.DB, cleanupTTL time.Duration, client *http.Client, maxWorkers, maxKeyVersions uint) (*Manager, error) {
 	if cleanupTTL < 0 {
 		cleanupTTL *= -1
 	}
-
-	client := &http.Client{
-		Timeout: requestTimeout,
+	if client == nil {
+		return nil, fmt.Errorf("missing http client")
 	}
 
 	return &Manager{
-		db:         db,
-		client:     client,
-		cleanupTTL: cleanupTTL,
-		maxWorkers: maxWorkers,
+		db:             db,
+		client:         client,
+		cleanupTTL:     cleanupTTL,
+		maxWorkers:     maxWorkers,
+		maxKeyVersions: maxKeyVersions,
 	}, nil
 }
 
@@ -232,7 +237,7 @@ func (mgr *Manager) updateHA(ctx context.Context, ha *model.HealthAuthority) err
 			From:         time.Now(),
 			PublicKeyPEM: project.TrimSpaceAndNonPrintable(key),
 		}
-		if err := haDB.AddHealthAuthorityKey(ctx, ha, hak); err != nil {
+		if err := haDB.AddHealthAuthorityKeyWithLimit(ctx, ha, hak, mgr.maxKeyVersions); err != nil {
 			return fmt.Errorf("error adding key: %w", err)
 		}
 	}
@@ -23,6 +23,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/google/exposure-notifications-server/internal/httpclient"
 	"github.com/google/exposure-notifications-server/internal/project"
 	hadb "github.com/google/exposure-notifications-server/internal/verification/database"
 	"github.com/google/exposure-notifications-server/internal/verification/model"
@@ -143,7 +144,7 @@ func TestUpdateHA(t *testing.T) {
 			// Set up the tc.
 			ctx := project.TestContext(t)
 			testDB, _ := testDatabaseInstance.NewDatabase(t)
-			mgr, err := NewManager(testDB, time.Minute, 5*time.Second, 2)
+			mgr, err := NewManager(testDB, time.Minute, httpclient.New(nil, 5*time.Second), 2, 0)
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
@@ -288,7 +289,7 @@ func TestUpdateAll(t *testing.T) {
 			ctx := project.TestContext(t)
 			testDB, _ := testDatabaseInstance.NewDatabase(t)
 			haDB := hadb.New(testDB)
-			mgr, err := NewManager(testDB, time.Minute, 1*time.Second, 2)
+			mgr, err := NewManager(testDB, time.Minute, httpclient.New(nil, 1*time.Second), 2, 0)
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
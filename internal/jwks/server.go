@@ -18,6 +18,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/google/exposure-notifications-server/internal/httpclient"
 	"github.com/google/exposure-notifications-server/internal/middleware"
 	"github.com/google/exposure-notifications-server/internal/serverenv"
 	"github.com/google/exposure-notifications-server/pkg/logging"
@@ -41,7 +42,8 @@ func NewServer(cfg *Config, env *serverenv.ServerEnv) (*Server, error) {
 		return nil, fmt.Errorf("missing database in server env")
 	}
 
-	manager, err := NewManager(env.Database(), cfg.KeyCleanupTTL, cfg.RequestTimeout, cfg.MaxWorkers)
+	client := httpclient.New(&cfg.HTTPClient, cfg.RequestTimeout)
+	manager, err := NewManager(env.Database(), cfg.KeyCleanupTTL, client, cfg.MaxWorkers, cfg.MaxActiveKeyVersions)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create manager: %w", err)
 	}
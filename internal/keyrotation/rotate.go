@@ -41,24 +41,12 @@ func (s *Server) handleRotateKeys() http.Handler {
 		logger.Debugw("starting")
 		defer logger.Debugw("finishing")
 
-		unlock, err := s.db.Lock(ctx, lockID, time.Minute)
-		if err != nil {
+		if _, err := s.rotate(ctx); err != nil {
 			if errors.Is(err, database.ErrAlreadyLocked) {
 				logger.Debugw("skipping (already locked)")
 				s.h.RenderJSON(w, http.StatusOK, fmt.Errorf("too early"))
 				return
 			}
-			logger.Errorw("failed to obtain lock", "error", err)
-			s.h.RenderJSON(w, http.StatusInternalServerError, err)
-			return
-		}
-		defer func() {
-			if err := unlock(); err != nil {
-				logger.Errorw("failed to unlock", "error", err)
-			}
-		}()
-
-		if err := s.doRotate(ctx); err != nil {
 			logger.Errorw("failed to rotate", "error", err)
 			s.h.RenderJSON(w, http.StatusInternalServerError, err)
 			return
@@ -69,15 +57,40 @@ func (s *Server) handleRotateKeys() http.Handler {
 	})
 }
 
+// rotate runs doRotate while holding the rotation lock, and records the run
+// for the status endpoint. If another run holds the lock, it returns an error
+// wrapping database.ErrAlreadyLocked and nothing is recorded.
+func (s *Server) rotate(ctx context.Context) (*rotationRun, error) {
+	logger := logging.FromContext(ctx).Named("rotate")
+
+	unlock, err := s.db.Lock(ctx, lockID, time.Minute)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain lock: %w", err)
+	}
+	defer func() {
+		if err := unlock(); err != nil {
+			logger.Errorw("failed to unlock", "error", err)
+		}
+	}()
+
+	startedAt := time.Now().UTC()
+	run, err := s.doRotate(ctx)
+	run.StartedAt = startedAt
+	run.FinishedAt = time.Now().UTC()
+	s.recordRun(run)
+	return run, err
+}
+
 // doRotate rotates the key. It only rotates the key if the appropriate TTL has
 // elapsed. If the key is not due to be rotated, the function returns (no error
-// is returned.)
-func (s *Server) doRotate(ctx context.Context) error {
+// is returned.) The returned run describes what was done, even on error.
+func (s *Server) doRotate(ctx context.Context) (*rotationRun, error) {
 	logger := logging.FromContext(ctx).Named("doRotate")
+	run := &rotationRun{Outcome: outcomeNotDue}
 
 	effectiveID, allowed, err := s.revisionDB.GetAllowedRevisionKeys(ctx)
 	if err != nil {
-		return fmt.Errorf("rotate-keys unable to read revision keys: %w", err)
+		return run.fail(fmt.Errorf("rotate-keys unable to read revision keys: %w", err))
 	}
 
 	// First allowed is newest due to sql orderby.
@@ -86,29 +99,33 @@ func (s *Server) doRotate(ctx context.Context) error {
 		logger.Debugw("creating new revision key")
 		key, err := s.revisionDB.CreateRevisionKey(ctx)
 		if err != nil {
-			return fmt.Errorf("failed to create revision key: %w", err)
+			return run.fail(fmt.Errorf("failed to create revision key: %w", err))
 		}
 		effectiveID = key.KeyID
 		previousCreated = key.CreatedAt
+		run.Outcome = outcomeRotated
+		run.CreatedKeyID = key.KeyIDString()
 		logger.Debugw("finished creating new revision key")
 	} else {
 		previousCreated = allowed[0].CreatedAt
 	}
 
 	var result *multierror.Error
-	deleted := 0
 	for _, key := range allowed {
 		if did, err := s.maybeDeleteKey(ctx, key, effectiveID, previousCreated); err != nil {
 			result = multierror.Append(result, err)
 		} else if did {
-			deleted++
+			run.DeletedKeyIDs = append(run.DeletedKeyIDs, key.KeyIDString())
 		}
 		previousCreated = key.CreatedAt
 	}
-	if deleted > 0 {
-		logger.Debugw("deleted old revision keys", "count", deleted)
+	if len(run.DeletedKeyIDs) > 0 {
+		logger.Debugw("deleted old revision keys", "count", len(run.DeletedKeyIDs))
+	}
+	if err := result.ErrorOrNil(); err != nil {
+		return run.fail(err)
 	}
-	return result.ErrorOrNil()
+	return run, nil
 }
 
 func (s *Server) maybeDeleteKey(ctx context.Context, key *revisiondatabase.RevisionKey, effectiveID int64, previousCreated time.Time) (bool, error) {
@@ -209,7 +209,7 @@ func TestRotateKeys(t *testing.T) {
 				}
 			}
 
-			if err := server.doRotate(ctx); err != nil {
+			if _, err := server.doRotate(ctx); err != nil {
 				t.Fatalf("doRotate failed: %v", err)
 			}
 
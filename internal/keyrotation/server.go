@@ -18,6 +18,8 @@ package keyrotation
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"sync"
 
 	"github.com/google/exposure-notifications-server/internal/middleware"
 	revisiondb "github.com/google/exposure-notifications-server/internal/revision/database"
@@ -36,6 +38,11 @@ type Server struct {
 	db         *database.DB
 	revisionDB *revisiondb.RevisionDB
 	h          *render.Renderer
+
+	// lastRun is the last rotation run of this server, for the status
+	// endpoint.
+	mu      sync.Mutex
+	lastRun *rotationRun
 }
 
 // NewServer creates a Server that manages deletion of
@@ -79,6 +86,8 @@ func (s *Server) Routes(ctx context.Context) *mux.Router {
 
 	r.Handle("/health", server.HandleHealthz(s.env.Database()))
 	r.Handle("/rotate-keys", s.handleRotateKeys())
+	r.Handle("/admin/rotate", s.handleTriggerRotation()).Methods(http.MethodPost)
+	r.Handle("/admin/status", s.handleRotationStatus()).Methods(http.MethodGet)
 
 	return r
 }
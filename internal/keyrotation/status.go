@@ -0,0 +1,180 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keyrotation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/exposure-notifications-server/pkg/database"
+	"github.com/google/exposure-notifications-server/pkg/logging"
+	"go.opencensus.io/stats"
+)
+
+const (
+	// outcomeRotated means a new revision key was created.
+	outcomeRotated = "rotated"
+	// outcomeNotDue means the effective key isn't old enough to be rotated.
+	// Old keys may still have been deleted.
+	outcomeNotDue = "not_due"
+	// outcomeFailed means the run failed, see the error.
+	outcomeFailed = "failed"
+)
+
+// rotationRun is the outcome of a key rotation run.
+type rotationRun struct {
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Outcome    string    `json:"outcome"`
+	Error      string    `json:"error,omitempty"`
+
+	// CreatedKeyID is the ID of the new revision key, if one was created, and
+	// DeletedKeyIDs are the IDs of the old keys that were deleted.
+	CreatedKeyID  string   `json:"createdKeyID,omitempty"`
+	DeletedKeyIDs []string `json:"deletedKeyIDs,omitempty"`
+}
+
+// fail marks the run as failed with err, and returns the run and err.
+func (r *rotationRun) fail(err error) (*rotationRun, error) {
+	r.Outcome = outcomeFailed
+	r.Error = err.Error()
+	return r, err
+}
+
+// recordRun keeps the run as the last run of this server.
+func (s *Server) recordRun(run *rotationRun) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastRun = run
+}
+
+// rotationStatus is the state of the revision keys.
+type rotationStatus struct {
+	// CurrentKey is the effective revision key, new revision tokens are
+	// encrypted with it. It is nil if there are no keys.
+	CurrentKey *keyStatus `json:"currentKey,omitempty"`
+	// PreviousKeys are the older keys that are still allowed, so existing
+	// revision tokens can be decrypted, newest first. They are deleted once
+	// they are past their DeleteAfter time.
+	PreviousKeys []*keyStatus `json:"previousKeys"`
+
+	// LastRotation is when the current key was created, and NextRotation is
+	// the earliest time a run rotates it.
+	LastRotation time.Time `json:"lastRotation,omitempty"`
+	NextRotation time.Time `json:"nextRotation,omitempty"`
+
+	// LastRun is the last rotation run of this server instance, or nil if it
+	// hasn't run one. Runs of other instances aren't included.
+	LastRun *rotationRun `json:"lastRun,omitempty"`
+}
+
+// keyStatus is a revision key that is still allowed.
+type keyStatus struct {
+	KeyID       string    `json:"keyID"`
+	CreatedAt   time.Time `json:"createdAt"`
+	DeleteAfter time.Time `json:"deleteAfter,omitempty"`
+}
+
+// status reads the state of the revision keys.
+func (s *Server) status(ctx context.Context) (*rotationStatus, error) {
+	_, allowed, err := s.revisionDB.GetAllowedRevisionKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read revision keys: %w", err)
+	}
+
+	status := &rotationStatus{
+		PreviousKeys: make([]*keyStatus, 0, len(allowed)),
+	}
+	// Keys are newest first. A key can be deleted once the next newer key was
+	// effective for the delete period, see maybeDeleteKey.
+	for i, key := range allowed {
+		ks := &keyStatus{
+			KeyID:     key.KeyIDString(),
+			CreatedAt: key.CreatedAt.UTC(),
+		}
+		if i == 0 {
+			status.CurrentKey = ks
+			status.LastRotation = ks.CreatedAt
+			status.NextRotation = ks.CreatedAt.Add(s.config.NewKeyPeriod)
+			continue
+		}
+		ks.DeleteAfter = allowed[i-1].CreatedAt.UTC().Add(s.config.DeleteOldKeyPeriod)
+		status.PreviousKeys = append(status.PreviousKeys, ks)
+	}
+
+	s.mu.Lock()
+	status.LastRun = s.lastRun
+	s.mu.Unlock()
+	return status, nil
+}
+
+// handleTriggerRotation runs key rotation and responds with the resulting
+// status. A run only rotates the key if it is due, so it's safe to trigger
+// again. If a run is already in progress, it responds with 409.
+func (s *Server) handleTriggerRotation() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.FromContext(ctx).Named("handleTriggerRotation")
+
+		run, err := s.rotate(ctx)
+		if err != nil && run == nil {
+			if errors.Is(err, database.ErrAlreadyLocked) {
+				s.h.RenderJSON(w, http.StatusConflict, fmt.Errorf("key rotation is already in progress"))
+				return
+			}
+			logger.Errorw("failed to rotate", "error", err)
+			s.h.RenderJSON(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		code := http.StatusOK
+		if err != nil {
+			logger.Errorw("failed to rotate", "error", err)
+			code = http.StatusInternalServerError
+		} else {
+			stats.Record(ctx, mSuccess.M(1))
+		}
+		logger.Infow("triggered key rotation", "outcome", run.Outcome,
+			"created", run.CreatedKeyID, "deleted", run.DeletedKeyIDs)
+
+		status, err := s.status(ctx)
+		if err != nil {
+			logger.Errorw("failed to read rotation status", "error", err)
+			s.h.RenderJSON(w, http.StatusInternalServerError, err)
+			return
+		}
+		s.h.RenderJSON(w, code, status)
+	})
+}
+
+// handleRotationStatus responds with the state of the revision keys and the
+// last rotation run.
+func (s *Server) handleRotationStatus() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		status, err := s.status(ctx)
+		if err != nil {
+			logging.FromContext(ctx).Named("handleRotationStatus").
+				Errorw("failed to read rotation status", "error", err)
+			s.h.RenderJSON(w, http.StatusInternalServerError, err)
+			return
+		}
+		s.h.RenderJSON(w, http.StatusOK, status)
+	})
+}
@@ -0,0 +1,120 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keyrotation
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/exposure-notifications-server/internal/revision"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/exposure-notifications-server/pkg/keys"
+)
+
+func TestHandleTriggerRotation(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	kms := keys.TestKeyManager(t)
+	keyID := keys.TestEncryptionKey(t, kms)
+
+	config := &Config{
+		RevisionToken:      revision.Config{KeyID: keyID},
+		DeleteOldKeyPeriod: 14 * 24 * time.Hour, // two weeks
+		NewKeyPeriod:       24 * time.Hour,      // one day
+	}
+
+	env := serverenv.New(ctx, serverenv.WithKeyManager(kms), serverenv.WithDatabase(testDB))
+	server, err := NewServer(config, env)
+	if err != nil {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	router := server.Routes(ctx)
+
+	do := func(method, path string) *rotationStatus {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		if got, want := w.Code, http.StatusOK; got != want {
+			t.Fatalf("%s %s: expected %d to be %d: %s", method, path, got, want, w.Body.String())
+		}
+		var status rotationStatus
+		if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+			t.Fatal(err)
+		}
+		return &status
+	}
+
+	// Before any run, there are no keys.
+	status := do(http.MethodGet, "/admin/status")
+	if status.CurrentKey != nil || status.LastRun != nil {
+		t.Errorf("expected no key and no run, got %#v", status)
+	}
+
+	// The first run creates a key.
+	status = do(http.MethodPost, "/admin/rotate")
+	if status.CurrentKey == nil {
+		t.Fatalf("expected a current key")
+	}
+	first := status.CurrentKey.KeyID
+	if status.LastRun == nil || status.LastRun.Outcome != outcomeRotated || status.LastRun.CreatedKeyID != first {
+		t.Errorf("expected the run to create key %s, got %#v", first, status.LastRun)
+	}
+	if got, want := status.NextRotation, status.LastRotation.Add(config.NewKeyPeriod); !got.Equal(want) {
+		t.Errorf("expected next rotation %v to be %v", got, want)
+	}
+
+	// The key isn't due, triggering again doesn't change it.
+	status = do(http.MethodPost, "/admin/rotate")
+	if got := status.CurrentKey.KeyID; got != first {
+		t.Errorf("expected current key %s to be %s", got, first)
+	}
+	if status.LastRun.Outcome != outcomeNotDue || status.LastRun.CreatedKeyID != "" {
+		t.Errorf("expected the run to not rotate, got %#v", status.LastRun)
+	}
+	if len(status.PreviousKeys) != 0 {
+		t.Errorf("expected no previous keys, got %v", status.PreviousKeys)
+	}
+
+	// Once the key is due, the next run advances the key, and the old key is
+	// pending deletion.
+	server.config.NewKeyPeriod = 0
+	status = do(http.MethodPost, "/admin/rotate")
+	if status.CurrentKey.KeyID == first {
+		t.Fatalf("expected the key to advance from %s", first)
+	}
+	if status.LastRun.Outcome != outcomeRotated || status.LastRun.CreatedKeyID != status.CurrentKey.KeyID {
+		t.Errorf("expected the run to create key %s, got %#v", status.CurrentKey.KeyID, status.LastRun)
+	}
+	if len(status.PreviousKeys) != 1 || status.PreviousKeys[0].KeyID != first {
+		t.Fatalf("expected previous key %s, got %v", first, status.PreviousKeys)
+	}
+	if got, want := status.PreviousKeys[0].DeleteAfter, status.CurrentKey.CreatedAt.Add(config.DeleteOldKeyPeriod); !got.Equal(want) {
+		t.Errorf("expected delete after %v to be %v", got, want)
+	}
+
+	// The status endpoint reflects the last run.
+	got := do(http.MethodGet, "/admin/status")
+	if got.CurrentKey.KeyID != status.CurrentKey.KeyID || got.LastRun == nil || got.LastRun.CreatedKeyID != status.CurrentKey.KeyID {
+		t.Errorf("expected status to reflect the last run, got %#v", got)
+	}
+}
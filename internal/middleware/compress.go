@@ -0,0 +1,180 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package middleware
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/exposure-notifications-server/pkg/logging"
+	"github.com/gorilla/mux"
+)
+
+// precompressedContentTypes are content types that are already compressed and
+// are never compressed again.
+var precompressedContentTypes = map[string]struct{}{
+	"application/zip":    {},
+	"application/gzip":   {},
+	"application/x-gzip": {},
+}
+
+// Compress gzip compresses responses when the client indicates support via the
+// Accept-Encoding header. Responses smaller than minBytes, responses that
+// already have a Content-Encoding, and responses with an already compressed
+// content type (e.g. export zip files) are sent as-is.
+func Compress(minBytes int) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			gw := &gzipResponseWriter{
+				ResponseWriter: w,
+				minBytes:       minBytes,
+				status:         http.StatusOK,
+			}
+			defer func() {
+				if err := gw.Close(); err != nil {
+					logger := logging.FromContext(r.Context()).Named("middleware.Compress")
+					logger.Errorw("failed to write compressed response", "error", err)
+				}
+			}()
+
+			next.ServeHTTP(gw, r)
+		})
+	}
+}
+
+// acceptsGzip returns true if the Accept-Encoding header value allows a gzip
+// encoded response.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := mime.ParseMediaType(strings.TrimSpace(part))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		if q, ok := params["q"]; ok {
+			if v, err := strconv.ParseFloat(q, 64); err != nil || v == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter buffers the response until it is known whether the
+// response should be compressed.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+
+	minBytes int
+	status   int
+	buf      []byte
+
+	// started is true once the headers have been written to the underlying
+	// ResponseWriter.
+	started bool
+	gz      *gzip.Writer
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.started {
+		return
+	}
+	w.status = code
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if w.started {
+		if w.gz != nil {
+			return w.gz.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+
+	w.buf = append(w.buf, b...)
+	if len(w.buf) < w.minBytes {
+		return len(b), nil
+	}
+	if err := w.start(true); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// start writes the headers and any buffered data to the underlying
+// ResponseWriter. The response is compressed if allowed is true and the
+// response isn't already encoded.
+func (w *gzipResponseWriter) start(allowed bool) error {
+	w.started = true
+
+	h := w.Header()
+	if h.Get("Content-Type") == "" && len(w.buf) > 0 {
+		// Detect the content type now, otherwise it would be detected from the
+		// compressed bytes.
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+
+	if allowed && shouldCompress(h) {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		w.ResponseWriter.WriteHeader(w.status)
+
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+		_, err := w.gz.Write(w.buf)
+		w.buf = nil
+		return err
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+	_, err := w.ResponseWriter.Write(w.buf)
+	w.buf = nil
+	return err
+}
+
+// Close flushes any buffered data. Responses that never reached the minimum
+// size are written uncompressed.
+func (w *gzipResponseWriter) Close() error {
+	if !w.started {
+		return w.start(false)
+	}
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
+
+// shouldCompress returns true if a response with the given headers can be
+// compressed.
+func shouldCompress(h http.Header) bool {
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+
+	contentType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return true
+	}
+	_, ok := precompressedContentTypes[contentType]
+	return !ok
+}
@@ -0,0 +1,169 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package middleware_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/exposure-notifications-server/internal/middleware"
+	"github.com/google/exposure-notifications-server/internal/project"
+)
+
+func TestCompress(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	largeJSON := []byte(`{"files":["` + strings.Repeat("exposureKeyExport-US/1600000000-1600003600-00001.zip", 100) + `"]}`)
+	smallJSON := []byte(`{"files":[]}`)
+
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	f, err := zw.Create("export.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(largeJSON); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zipBody := zipBuf.Bytes()
+
+	handler := func(contentType string, body []byte) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if contentType != "" {
+				w.Header().Set("Content-Type", contentType)
+			}
+			w.WriteHeader(http.StatusOK)
+			// Write in chunks to exercise buffering.
+			for i := 0; i < len(body); i += 100 {
+				end := i + 100
+				if end > len(body) {
+					end = len(body)
+				}
+				if _, err := w.Write(body[i:end]); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+
+	cases := []struct {
+		name           string
+		acceptEncoding string
+		handler        http.Handler
+		body           []byte
+		gzipped        bool
+	}{
+		{
+			name:           "large_json",
+			acceptEncoding: "gzip, deflate",
+			handler:        handler("application/json", largeJSON),
+			body:           largeJSON,
+			gzipped:        true,
+		},
+		{
+			name:           "large_json_not_accepted",
+			acceptEncoding: "",
+			handler:        handler("application/json", largeJSON),
+			body:           largeJSON,
+		},
+		{
+			name:           "large_json_gzip_refused",
+			acceptEncoding: "gzip;q=0, deflate",
+			handler:        handler("application/json", largeJSON),
+			body:           largeJSON,
+		},
+		{
+			name:           "small_json",
+			acceptEncoding: "gzip",
+			handler:        handler("application/json", smallJSON),
+			body:           smallJSON,
+		},
+		{
+			name:           "zip",
+			acceptEncoding: "gzip",
+			handler:        handler("application/zip", zipBody),
+			body:           zipBody,
+		},
+		{
+			name:           "zip_detected",
+			acceptEncoding: "gzip",
+			handler:        handler("", zipBody),
+			body:           zipBody,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			r, err := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.acceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			}
+
+			w := httptest.NewRecorder()
+			middleware.Compress(1024)(tc.handler).ServeHTTP(w, r)
+
+			if got, want := w.Code, http.StatusOK; got != want {
+				t.Errorf("expected %d to be %d", got, want)
+			}
+			if got, want := w.Header().Get("Vary"), "Accept-Encoding"; got != want {
+				t.Errorf("expected vary %q to be %q", got, want)
+			}
+
+			body := w.Body.Bytes()
+			encoding := w.Header().Get("Content-Encoding")
+			if tc.gzipped {
+				if encoding != "gzip" {
+					t.Fatalf("expected gzip content encoding, got %q", encoding)
+				}
+				if len(body) >= len(tc.body) {
+					t.Errorf("expected compressed body (%d bytes) to be smaller than %d bytes", len(body), len(tc.body))
+				}
+
+				gr, err := gzip.NewReader(bytes.NewReader(body))
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, err = io.ReadAll(gr)
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else if encoding != "" {
+				t.Errorf("expected no content encoding, got %q", encoding)
+			}
+
+			if !bytes.Equal(body, tc.body) {
+				t.Errorf("expected body to be %q, got %q", tc.body, body)
+			}
+		})
+	}
+}
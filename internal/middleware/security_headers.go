@@ -0,0 +1,77 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// SecurityHeadersConfig is the security headers set on every response. Each
+// header is disabled by setting its variable to an empty value, or a zero max
+// age for Strict-Transport-Security.
+type SecurityHeadersConfig struct {
+	// HSTSMaxAge is the max-age of the Strict-Transport-Security header.
+	// HSTSIncludeSubdomains adds the includeSubDomains directive.
+	HSTSMaxAge            time.Duration `env:"SECURITY_HEADER_HSTS_MAX_AGE, default=8760h"`
+	HSTSIncludeSubdomains bool          `env:"SECURITY_HEADER_HSTS_INCLUDE_SUBDOMAINS, default=true"`
+
+	ContentTypeOptions string `env:"SECURITY_HEADER_CONTENT_TYPE_OPTIONS, default=nosniff"`
+	FrameOptions       string `env:"SECURITY_HEADER_FRAME_OPTIONS, default=DENY"`
+	ReferrerPolicy     string `env:"SECURITY_HEADER_REFERRER_POLICY, default=no-referrer"`
+}
+
+// headers returns the enabled headers and their values.
+func (c *SecurityHeadersConfig) headers() map[string]string {
+	headers := make(map[string]string, 4)
+	if seconds := int64(c.HSTSMaxAge.Seconds()); seconds > 0 {
+		hsts := fmt.Sprintf("max-age=%d", seconds)
+		if c.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		headers["Strict-Transport-Security"] = hsts
+	}
+	for name, value := range map[string]string{
+		"X-Content-Type-Options": c.ContentTypeOptions,
+		"X-Frame-Options":        c.FrameOptions,
+		"Referrer-Policy":        c.ReferrerPolicy,
+	} {
+		if value != "" {
+			headers[name] = value
+		}
+	}
+	return headers
+}
+
+// SecurityHeaders sets the configured security headers on every response.
+// They are set before the next handler is called, so a handler can still
+// replace or remove them.
+func SecurityHeaders(cfg *SecurityHeadersConfig) mux.MiddlewareFunc {
+	headers := cfg.headers()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			for name, value := range headers {
+				h.Set(name, value)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
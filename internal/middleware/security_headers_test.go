@@ -0,0 +1,127 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/go-cmp/cmp"
+	"github.com/sethvargo/go-envconfig"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	cases := []struct {
+		name string
+		env  map[string]string
+		want map[string]string
+	}{
+		{
+			name: "defaults",
+			want: map[string]string{
+				"Strict-Transport-Security": "max-age=31536000; includeSubDomains",
+				"X-Content-Type-Options":    "nosniff",
+				"X-Frame-Options":           "DENY",
+				"Referrer-Policy":           "no-referrer",
+			},
+		},
+		{
+			name: "configured",
+			env: map[string]string{
+				"SECURITY_HEADER_HSTS_MAX_AGE":            "1h",
+				"SECURITY_HEADER_HSTS_INCLUDE_SUBDOMAINS": "false",
+				"SECURITY_HEADER_FRAME_OPTIONS":           "SAMEORIGIN",
+				"SECURITY_HEADER_REFERRER_POLICY":         "same-origin",
+			},
+			want: map[string]string{
+				"Strict-Transport-Security": "max-age=3600",
+				"X-Content-Type-Options":    "nosniff",
+				"X-Frame-Options":           "SAMEORIGIN",
+				"Referrer-Policy":           "same-origin",
+			},
+		},
+		{
+			name: "disabled",
+			env: map[string]string{
+				"SECURITY_HEADER_HSTS_MAX_AGE":         "0",
+				"SECURITY_HEADER_CONTENT_TYPE_OPTIONS": "",
+				"SECURITY_HEADER_FRAME_OPTIONS":        "",
+			},
+			want: map[string]string{
+				"Referrer-Policy": "no-referrer",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var cfg SecurityHeadersConfig
+			if err := envconfig.ProcessWith(ctx, &cfg, envconfig.MapLookuper(tc.env)); err != nil {
+				t.Fatal(err)
+			}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			SecurityHeaders(&cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})).ServeHTTP(w, r)
+
+			got := make(map[string]string)
+			for _, name := range []string{"Strict-Transport-Security", "X-Content-Type-Options", "X-Frame-Options", "Referrer-Policy"} {
+				if value := w.Header().Get(name); value != "" {
+					got[name] = value
+				}
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSecurityHeaders_HandlerOverride(t *testing.T) {
+	t.Parallel()
+
+	cfg := &SecurityHeadersConfig{
+		HSTSMaxAge:   time.Hour,
+		FrameOptions: "DENY",
+	}
+
+	// A handler can replace or remove the headers.
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	SecurityHeaders(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+		w.Header().Del("Strict-Transport-Security")
+	})).ServeHTTP(w, r)
+
+	if got, want := w.Header().Get("X-Frame-Options"), "SAMEORIGIN"; got != want {
+		t.Errorf("expected X-Frame-Options %q to be %q", got, want)
+	}
+	if got := w.Header().Get("Strict-Transport-Security"); got != "" {
+		t.Errorf("expected no Strict-Transport-Security header, got %q", got)
+	}
+}
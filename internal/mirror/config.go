@@ -17,11 +17,14 @@ package mirror
 import (
 	"time"
 
+	"github.com/google/exposure-notifications-server/internal/httpclient"
+	"github.com/google/exposure-notifications-server/internal/middleware"
 	"github.com/google/exposure-notifications-server/internal/setup"
 	"github.com/google/exposure-notifications-server/internal/storage"
 	"github.com/google/exposure-notifications-server/pkg/database"
 	"github.com/google/exposure-notifications-server/pkg/observability"
 	"github.com/google/exposure-notifications-server/pkg/secrets"
+	"github.com/google/exposure-notifications-server/pkg/server"
 )
 
 var (
@@ -36,6 +39,9 @@ type Config struct {
 	ObservabilityExporter observability.Config
 	SecretManager         secrets.Config
 	Storage               storage.Config
+	HTTPClient            httpclient.Config
+	TLS                   server.TLSConfig
+	SecurityHeaders       middleware.SecurityHeadersConfig
 
 	Port string `env:"PORT, default=8080"`
 
@@ -37,6 +37,13 @@ import (
 
 const mirrorLockPrefix = "mirror-lock"
 
+// indexPageSuffix is the suffix of the index pages that an index file links
+// to. The other entries are zip files.
+const indexPageSuffix = ".txt"
+
+// errFileNotFound is returned by downloadFile if the file doesn't exist.
+var errFileNotFound = errors.New("not found")
+
 type Response struct {
 	Mirrors []*Status `json:"mirrors"`
 }
@@ -260,7 +267,7 @@ func (s *Server) processMirror(ctx context.Context, deadline time.Time, mirror *
 			"file", filename,
 			"download_path", status.DownloadPath)
 
-		b, err := downloadFile(ctx, status.DownloadPath, s.config.ExportFileDownloadTimeout, s.config.MaxZipBytes)
+		b, err := downloadFile(ctx, s.exportClient, status.DownloadPath, s.config.MaxZipBytes)
 		if err != nil {
 			merr = multierror.Append(merr, fmt.Errorf("failed to download export file %s: %w", filename, err))
 			status.Failed = true
@@ -372,13 +379,12 @@ func computeActions(knownFiles []*model.MirrorFile, indexFiles []string) map[str
 	return actions
 }
 
-// downloadFile downloads the file from the given URL u up to maxBytes. If the
-// URL does not return a 200, an error is returned. If the process takes longer
-// than the provided timeout, an error is returned. If more bytes remain after
-// maxBytes, an error is returned. Otherwise, the raw bytes are returned.
-func downloadFile(ctx context.Context, u string, timeout time.Duration, maxBytes int64) ([]byte, error) {
-	client := &http.Client{Timeout: timeout}
-
+// downloadFile downloads the file from the given URL u up to maxBytes using the
+// provided client. If the URL does not return a 200, an error is returned. If
+// the process takes longer than the client's timeout, an error is returned. If
+// more bytes remain after maxBytes, an error is returned. Otherwise, the raw
+// bytes are returned.
+func downloadFile(ctx context.Context, client *http.Client, u string, maxBytes int64) ([]byte, error) {
 	// Start the download.
 	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
 	if err != nil {
@@ -392,7 +398,9 @@ func downloadFile(ctx context.Context, u string, timeout time.Duration, maxBytes
 	defer resp.Body.Close()
 
 	// Ensure a 200 response.
-	if code := resp.StatusCode; code != http.StatusOK {
+	if code := resp.StatusCode; code == http.StatusNotFound {
+		return nil, fmt.Errorf("failed to download %s: %w", u, errFileNotFound)
+	} else if code != http.StatusOK {
 		return nil, fmt.Errorf("failed to download %s: status %d", u, code)
 	}
 
@@ -421,22 +429,44 @@ func downloadFile(ctx context.Context, u string, timeout time.Duration, maxBytes
 //   us/1605818705-1605819005-00003.zip
 //   ...
 //
-// The values are returned in the order in which they appear in the file, joined
-// with the configured mirror ExportRoot.
+// An index that is split into pages ends with the name of the next older page,
+// e.g. "us/index-1.txt", which is downloaded from the ExportRoot like the zip
+// files. The oldest pages are deleted once their files expire, so a page that
+// doesn't exist ends the chain.
+//
+// The values are returned in the order in which they appear in the files,
+// joined with the configured mirror ExportRoot.
 func (s *Server) downloadIndex(ctx context.Context, mirror *model.Mirror) ([]string, error) {
-	b, err := downloadFile(ctx, mirror.IndexFile, s.config.IndexFileDownloadTimeout, s.config.MaxIndexBytes)
-	if err != nil {
-		return nil, err
-	}
-
 	currentFiles := make([]string, 0, 64)
-	scanner := bufio.NewScanner(bytes.NewReader(b))
-	for scanner.Scan() {
-		fullName := urlJoin(mirror.ExportRoot, scanner.Text())
-		currentFiles = append(currentFiles, fullName)
-	}
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("failed to scan index file: %w", err)
+	seen := make(map[string]struct{})
+	for u := mirror.IndexFile; u != ""; {
+		if _, ok := seen[u]; ok {
+			return nil, fmt.Errorf("index page %s is listed twice", u)
+		}
+		primary := len(seen) == 0
+		seen[u] = struct{}{}
+
+		b, err := downloadFile(ctx, s.indexClient, u, s.config.MaxIndexBytes)
+		if err != nil {
+			if !primary && errors.Is(err, errFileNotFound) {
+				break
+			}
+			return nil, err
+		}
+
+		u = ""
+		scanner := bufio.NewScanner(bytes.NewReader(b))
+		for scanner.Scan() {
+			fullName := urlJoin(mirror.ExportRoot, scanner.Text())
+			if strings.HasSuffix(fullName, indexPageSuffix) {
+				u = fullName
+				continue
+			}
+			currentFiles = append(currentFiles, fullName)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to scan index file: %w", err)
+		}
 	}
 
 	return currentFiles, nil
@@ -444,6 +444,48 @@ func TestServer_DownloadIndex(t *testing.T) {
 			}
 		}
 	})
+
+	// Client follows index pages, until a page doesn't exist
+	t.Run("pages", func(t *testing.T) {
+		t.Parallel()
+
+		c := &Config{
+			MaxIndexBytes: 8196,
+		}
+		s, err := NewServer(c, env)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/us/index.txt":
+				fmt.Fprintf(w, "us/5-6-00001.zip\nus/index-2.txt\n")
+			case "/us/index-2.txt":
+				fmt.Fprintf(w, "us/3-4-00001.zip\nus/4-5-00001.zip\nus/index-1.txt\n")
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		t.Cleanup(ts.Close)
+
+		results, err := s.downloadIndex(ctx, &mirrormodel.Mirror{
+			IndexFile:  ts.URL + "/us/index.txt",
+			ExportRoot: ts.URL,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []string{
+			ts.URL + "/us/5-6-00001.zip",
+			ts.URL + "/us/3-4-00001.zip",
+			ts.URL + "/us/4-5-00001.zip",
+		}
+		if diff := cmp.Diff(want, results); diff != "" {
+			t.Errorf("mismatch (-want, +got):\n%s", diff)
+		}
+	})
 }
 
 func TestServer_ComputeActions(t *testing.T) {
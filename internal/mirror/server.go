@@ -18,7 +18,9 @@ package mirror
 import (
 	"context"
 	"fmt"
+	"net/http"
 
+	"github.com/google/exposure-notifications-server/internal/httpclient"
 	"github.com/google/exposure-notifications-server/internal/middleware"
 	mirrordb "github.com/google/exposure-notifications-server/internal/mirror/database"
 	"github.com/google/exposure-notifications-server/internal/serverenv"
@@ -36,6 +38,9 @@ type Server struct {
 	db       *database.DB
 	mirrorDB *mirrordb.MirrorDB
 	h        *render.Renderer
+
+	indexClient  *http.Client
+	exportClient *http.Client
 }
 
 // NewServer creates a Server that manages deletion of
@@ -57,6 +62,9 @@ func NewServer(config *Config, env *serverenv.ServerEnv) (*Server, error) {
 		db:       db,
 		mirrorDB: mdb,
 		h:        render.NewRenderer(),
+
+		indexClient:  httpclient.New(&config.HTTPClient, config.IndexFileDownloadTimeout),
+		exportClient: httpclient.New(&config.HTTPClient, config.ExportFileDownloadTimeout),
 	}, nil
 }
 
@@ -0,0 +1,159 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// certificateThrottle enforces a minimum interval between publish requests
+// with the same verification certificate. Unlike the stats submission rate
+// limit, it applies to single certificates and not to health authorities. The
+// publish times are held in memory, so each publish server instance enforces
+// the interval separately.
+//
+// If maxPerIssuer is set, at most that many certificates are held per health
+// authority, and the least recently published one is forgotten to make room
+// for a new one. A forgotten certificate can be reused before the interval
+// passed, but a new certificate is never rejected because the throttle is
+// full.
+//
+// Only successful publish requests are recorded, so a request that fails can
+// be retried with the same certificate right away.
+type certificateThrottle struct {
+	interval     time.Duration
+	maxPerIssuer int
+
+	mu        sync.Mutex
+	issuers   map[int64]*issuerCertificates
+	lastSweep time.Time
+}
+
+// issuerCertificates are the certificates published by a health authority,
+// from the least to the most recently published.
+type issuerCertificates struct {
+	order    *list.List
+	elements map[string]*list.Element
+}
+
+type certificatePublish struct {
+	id   string
+	last time.Time
+}
+
+// newCertificateThrottle returns a throttle for the interval, or nil if the
+// interval is 0 and publish requests aren't throttled. A maxPerIssuer of 0
+// holds any number of certificates.
+func newCertificateThrottle(interval time.Duration, maxPerIssuer uint) *certificateThrottle {
+	if interval <= 0 {
+		return nil
+	}
+	return &certificateThrottle{
+		interval:     interval,
+		maxPerIssuer: int(maxPerIssuer),
+		issuers:      make(map[int64]*issuerCertificates),
+	}
+}
+
+// wait returns 0 if the certificate of the health authority may be used at
+// now, because its previous publish was at least the interval ago. Otherwise
+// the time until it may be used again is returned. Publish requests are only
+// recorded once they succeed, with record.
+func (t *certificateThrottle) wait(issuer int64, certificateID string, now time.Time) time.Duration {
+	if t == nil {
+		return 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sweep(now)
+
+	certs, ok := t.issuers[issuer]
+	if !ok {
+		return 0
+	}
+	e, ok := certs.elements[certificateID]
+	if !ok {
+		return 0
+	}
+	if wait := t.interval - now.Sub(e.Value.(*certificatePublish).last); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// record records a successful publish request with the certificate of the
+// health authority at now. evicted is true if recording the request forgot a
+// certificate that could not have been used again yet.
+func (t *certificateThrottle) record(issuer int64, certificateID string, now time.Time) (evicted bool) {
+	if t == nil {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sweep(now)
+
+	certs, ok := t.issuers[issuer]
+	if !ok {
+		certs = &issuerCertificates{
+			order:    list.New(),
+			elements: make(map[string]*list.Element),
+		}
+		t.issuers[issuer] = certs
+	}
+
+	if e, ok := certs.elements[certificateID]; ok {
+		e.Value.(*certificatePublish).last = now
+		certs.order.MoveToBack(e)
+		return false
+	}
+
+	if t.maxPerIssuer > 0 && certs.order.Len() >= t.maxPerIssuer {
+		oldest := certs.order.Front()
+		pub := oldest.Value.(*certificatePublish)
+		evicted = now.Sub(pub.last) < t.interval
+		certs.order.Remove(oldest)
+		delete(certs.elements, pub.id)
+	}
+	certs.elements[certificateID] = certs.order.PushBack(&certificatePublish{id: certificateID, last: now})
+	return evicted
+}
+
+// sweep removes certificates that may be used again, at most once per
+// interval. Callers must hold the lock.
+func (t *certificateThrottle) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < t.interval {
+		return
+	}
+	for issuer, certs := range t.issuers {
+		for e := certs.order.Front(); e != nil; e = certs.order.Front() {
+			pub := e.Value.(*certificatePublish)
+			if now.Sub(pub.last) < t.interval {
+				break
+			}
+			certs.order.Remove(e)
+			delete(certs.elements, pub.id)
+		}
+		if certs.order.Len() == 0 {
+			delete(t.issuers, issuer)
+		}
+	}
+	t.lastSweep = now
+}
@@ -0,0 +1,330 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/authorizedapp"
+	aadb "github.com/google/exposure-notifications-server/internal/authorizedapp/database"
+	aamodel "github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	"github.com/google/exposure-notifications-server/internal/project"
+	revisiondb "github.com/google/exposure-notifications-server/internal/revision/database"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	testutil "github.com/google/exposure-notifications-server/internal/utils"
+	vermodel "github.com/google/exposure-notifications-server/internal/verification/model"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
+	"github.com/google/exposure-notifications-server/pkg/keys"
+	"github.com/google/exposure-notifications-server/pkg/timeutils"
+	"github.com/google/exposure-notifications-server/pkg/util"
+	"github.com/sethvargo/go-envconfig"
+)
+
+func TestCertificateThrottle(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	throttle := newCertificateThrottle(10*time.Minute, 0)
+
+	if wait, _ := allow(throttle, 1, "cert-a", now); wait != 0 {
+		t.Fatalf("expected first publish to be allowed, got wait %v", wait)
+	}
+
+	// Too soon for the same certificate, other certificates are not affected.
+	if got, _ := allow(throttle, 1, "cert-a", now.Add(4*time.Minute)); got != 6*time.Minute {
+		t.Errorf("expected re-publish to wait %v, got %v", 6*time.Minute, got)
+	}
+	if wait, _ := allow(throttle, 1, "cert-b", now.Add(4*time.Minute)); wait != 0 {
+		t.Errorf("expected other certificate to be allowed, got wait %v", wait)
+	}
+
+	// Rejected requests don't extend the interval.
+	if wait, _ := allow(throttle, 1, "cert-a", now.Add(10*time.Minute)); wait != 0 {
+		t.Errorf("expected later re-publish to be allowed, got wait %v", wait)
+	}
+	if got, _ := allow(throttle, 1, "cert-a", now.Add(15*time.Minute)); got != 5*time.Minute {
+		t.Errorf("expected re-publish to wait %v, got %v", 5*time.Minute, got)
+	}
+
+	// Certificates that may be used again are removed.
+	allow(throttle, 1, "cert-c", now.Add(30*time.Minute))
+	if got, want := throttle.tracked(), 1; got != want {
+		t.Errorf("expected %d tracked certificates, got %d", want, got)
+	}
+}
+
+func TestCertificateThrottle_MaxPerIssuer(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	throttle := newCertificateThrottle(10*time.Minute, 2)
+
+	for i, id := range []string{"cert-a", "cert-b"} {
+		if wait, evicted := allow(throttle, 1, id, now.Add(time.Duration(i)*time.Minute)); wait != 0 || evicted {
+			t.Fatalf("expected %s to be allowed without eviction, got wait %v, evicted %t", id, wait, evicted)
+		}
+	}
+
+	// A new certificate is allowed when the issuer is full, the least recently
+	// published one is forgotten.
+	wait, evicted := allow(throttle, 1, "cert-c", now.Add(2*time.Minute))
+	if wait != 0 {
+		t.Errorf("expected new certificate to be allowed, got wait %v", wait)
+	}
+	if !evicted {
+		t.Errorf("expected a certificate to be evicted")
+	}
+
+	// The evicted certificate can be reused, the others are still throttled.
+	if wait, _ := allow(throttle, 1, "cert-b", now.Add(3*time.Minute)); wait == 0 {
+		t.Errorf("expected cert-b to still be throttled")
+	}
+	if wait, evicted := allow(throttle, 1, "cert-a", now.Add(3*time.Minute)); wait != 0 || !evicted {
+		t.Errorf("expected evicted cert-a to be allowed and evict cert-b, got wait %v, evicted %t", wait, evicted)
+	}
+
+	// Other issuers have their own limit.
+	for _, id := range []string{"cert-a", "cert-b"} {
+		if wait, evicted := allow(throttle, 2, id, now.Add(3*time.Minute)); wait != 0 || evicted {
+			t.Errorf("expected %s of other issuer to be allowed without eviction, got wait %v, evicted %t", id, wait, evicted)
+		}
+	}
+
+	// Certificates that may be used again are removed first, so nothing that
+	// could still be throttled is evicted.
+	if wait, evicted := allow(throttle, 1, "cert-d", now.Add(12*time.Minute+30*time.Second)); wait != 0 || evicted {
+		t.Errorf("expected cert-d to be allowed without eviction, got wait %v, evicted %t", wait, evicted)
+	}
+	if got, want := throttle.tracked(), 4; got != want {
+		t.Errorf("expected %d tracked certificates, got %d", want, got)
+	}
+}
+
+func TestCertificateThrottle_Disabled(t *testing.T) {
+	t.Parallel()
+
+	throttle := newCertificateThrottle(0, 10)
+	if throttle != nil {
+		t.Fatalf("expected no throttle, got %#v", throttle)
+	}
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if wait, _ := allow(throttle, 1, "cert-a", now); wait != 0 {
+			t.Errorf("expected publish %d to be allowed, got wait %v", i, wait)
+		}
+	}
+}
+
+// allow checks the certificate and records the publish if it may be used, like
+// a publish request that succeeds.
+func allow(t *certificateThrottle, issuer int64, certificateID string, now time.Time) (time.Duration, bool) {
+	if wait := t.wait(issuer, certificateID, now); wait > 0 {
+		return wait, false
+	}
+	return 0, t.record(issuer, certificateID, now)
+}
+
+func TestCertificateThrottle_FailedPublish(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	throttle := newCertificateThrottle(10*time.Minute, 0)
+
+	// A publish that fails after the check isn't recorded, so it can be
+	// retried right away.
+	if wait := throttle.wait(1, "cert-a", now); wait != 0 {
+		t.Fatalf("expected first publish to be allowed, got wait %v", wait)
+	}
+	if wait := throttle.wait(1, "cert-a", now.Add(time.Second)); wait != 0 {
+		t.Errorf("expected retry to be allowed, got wait %v", wait)
+	}
+
+	throttle.record(1, "cert-a", now.Add(time.Second))
+	if got, want := throttle.wait(1, "cert-a", now.Add(2*time.Second)), 10*time.Minute-time.Second; got != want {
+		t.Errorf("expected re-publish after success to wait %v, got %v", want, got)
+	}
+}
+
+// tracked returns the number of certificates held by the throttle.
+func (t *certificateThrottle) tracked() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := 0
+	for _, certs := range t.issuers {
+		n += certs.order.Len()
+	}
+	return n
+}
+
+func TestResponseSetRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name       string
+		retryAfter time.Duration
+		want       string
+	}{
+		{name: "unset", want: ""},
+		{name: "whole_seconds", retryAfter: 2 * time.Minute, want: "120"},
+		{name: "rounded_up", retryAfter: 1500 * time.Millisecond, want: "2"},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			w := httptest.NewRecorder()
+			(&response{retryAfter: tc.retryAfter}).setRetryAfter(w)
+			if got := w.Header().Get("Retry-After"); got != tc.want {
+				t.Errorf("expected Retry-After %q to be %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPublishCertificateThrottle_RetryAfterFailure(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+
+	healthAuthority := &vermodel.HealthAuthority{
+		Issuer:   "gov.state.health",
+		Audience: "unit.test.server",
+		Name:     "State Dept of Health",
+	}
+	healthAuthorityKey := &vermodel.HealthAuthorityKey{
+		Version: "v1",
+		From:    time.Now().Add(-1 * time.Minute),
+	}
+	signingKey := testutil.GetSigningKey(t)
+	testutil.InitializeVerificationDB(ctx, t, testDB, healthAuthority, healthAuthorityKey, signingKey)
+
+	authorizedApp := aamodel.NewAuthorizedApp()
+	authorizedApp.AppPackageName = "gov.state.health"
+	authorizedApp.BypassRevisionToken = true
+	authorizedApp.AllowedRegions["US"] = struct{}{}
+	authorizedApp.AllowedHealthAuthorityIDs[healthAuthority.ID] = struct{}{}
+	if err := aadb.New(testDB).InsertAuthorizedApp(ctx, authorizedApp); err != nil {
+		t.Fatal(err)
+	}
+
+	kms := keys.TestKeyManager(t)
+	keyID := keys.TestEncryptionKey(t, kms)
+	revDB, err := revisiondb.New(testDB, &revisiondb.KMSConfig{WrapperKeyID: keyID, KeyManager: kms})
+	if err != nil {
+		t.Fatalf("unable to create revision DB handle: %v", err)
+	}
+	if _, err := revDB.CreateRevisionKey(ctx); err != nil {
+		t.Fatalf("unable to create revision key: %v", err)
+	}
+
+	config := Config{}
+	if err := envconfig.ProcessWith(ctx, &config, envconfig.OsLookuper()); err != nil {
+		t.Fatal(err)
+	}
+	config.AuthorizedApp.CacheDuration = time.Nanosecond
+	config.CreatedAtTruncateWindow = time.Second
+	config.MaxKeysOnPublish = 20
+	config.MaxSameStartIntervalKeys = 2
+	config.MaxIntervalAge = 14 * 24 * time.Hour
+	config.PublishCertificateMinInterval = time.Hour
+	config.WriteBreakerThreshold = 1
+	config.RevisionToken.AAD = make([]byte, 16)
+	if _, err := rand.Read(config.RevisionToken.AAD); err != nil {
+		t.Fatalf("not enough entropy: %v", err)
+	}
+	config.RevisionToken.KeyID = keyID
+
+	aaProvider, err := authorizedapp.NewDatabaseProvider(ctx, testDB, config.AuthorizedAppConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := serverenv.New(ctx,
+		serverenv.WithDatabase(testDB),
+		serverenv.WithAuthorizedAppProvider(aaProvider),
+		serverenv.WithKeyManager(kms))
+
+	publishServer, err := NewServer(ctx, &config, env)
+	if err != nil {
+		t.Fatalf("unable to create publish handler: %v", err)
+	}
+
+	publish := &verifyapi.Publish{
+		Keys:              util.GenerateExposureKeys(2, 0, false),
+		HealthAuthorityID: healthAuthority.Issuer,
+	}
+	utcDay := timeutils.UTCMidnight(time.Now())
+	verification, salt := testutil.IssueJWT(t, &testutil.JWTConfig{
+		HealthAuthority:      healthAuthority,
+		HealthAuthorityKey:   healthAuthorityKey,
+		ExposureKeys:         publish.Keys,
+		Key:                  signingKey.Key,
+		SymptomOnsetInterval: uint32(utcDay.Unix()/600) - verifyapi.MaxIntervalCount,
+		ReportType:           verifyapi.ReportTypeConfirmed,
+	})
+	publish.VerificationPayload = verification
+	publish.HMACKey = salt
+	body, err := json.Marshal(publish)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publishKeys := func(tb *testing.T) *httptest.ResponseRecorder {
+		tb.Helper()
+
+		request, err := http.NewRequestWithContext(ctx, http.MethodPost, "", strings.NewReader(string(body)))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		request.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		publishServer.handlePublishV1().ServeHTTP(rr, request)
+		return rr
+	}
+
+	// The first request fails after the certificate was checked, while the
+	// write breaker is open.
+	publishServer.writeBreaker.mu.Lock()
+	publishServer.writeBreaker.openUntil = time.Now().Add(time.Hour)
+	publishServer.writeBreaker.mu.Unlock()
+	if rr := publishKeys(t); rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status %d to be %d: %s", rr.Code, http.StatusServiceUnavailable, rr.Body.String())
+	}
+
+	// The retry with the same certificate is allowed.
+	publishServer.writeBreaker.mu.Lock()
+	publishServer.writeBreaker.openUntil = time.Time{}
+	publishServer.writeBreaker.mu.Unlock()
+	if rr := publishKeys(t); rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d to be %d: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+
+	// Once a request succeeded, the certificate is throttled.
+	if rr := publishKeys(t); rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status %d to be %d: %s", rr.Code, http.StatusTooManyRequests, rr.Body.String())
+	}
+}
@@ -24,11 +24,13 @@ import (
 	"github.com/google/exposure-notifications-server/internal/publish/model"
 	"github.com/google/exposure-notifications-server/internal/revision"
 	"github.com/google/exposure-notifications-server/internal/setup"
+	"github.com/google/exposure-notifications-server/internal/statssink"
 	"github.com/google/exposure-notifications-server/internal/verification"
 	"github.com/google/exposure-notifications-server/pkg/database"
 	"github.com/google/exposure-notifications-server/pkg/keys"
 	"github.com/google/exposure-notifications-server/pkg/observability"
 	"github.com/google/exposure-notifications-server/pkg/secrets"
+	"github.com/google/exposure-notifications-server/pkg/server"
 	"github.com/hashicorp/go-multierror"
 )
 
@@ -40,6 +42,7 @@ var (
 	_ setup.ObservabilityExporterConfigProvider = (*Config)(nil)
 	_ model.TransformerConfig                   = (*Config)(nil)
 	_ setup.KeyManagerConfigProvider            = (*Config)(nil)
+	_ setup.StatsSinkConfigProvider             = (*Config)(nil)
 	_ middleware.Maintainable                   = (*Config)(nil)
 )
 
@@ -53,11 +56,36 @@ type Config struct {
 	Verification          verification.Config
 	ObservabilityExporter observability.Config
 	RevisionToken         revision.Config
+	StatsSink             statssink.Config
+	TLS                   server.TLSConfig
+	HTTP                  server.HTTPConfig
+	SecurityHeaders       middleware.SecurityHeadersConfig
 
 	Port        string `env:"PORT, default=8080"`
 	Maintenance bool   `env:"MAINTENANCE_MODE, default=false"`
 
 	MaxKeysOnPublish uint `env:"MAX_KEYS_ON_PUBLISH, default=30"`
+	// MaxPublishBodyBytes is the largest publish request body that is read.
+	// Larger bodies are rejected with a 413 before they are decoded, this
+	// bounds the size of any single field, like the padding.
+	MaxPublishBodyBytes int64 `env:"MAX_PUBLISH_BODY_BYTES, default=64000"`
+	// MaxPublishPaddingBytes, if set, is the largest padding of a publish
+	// request, which is checked right after the request is decoded.
+	// OversizePaddingPolicy is "reject" to reject requests with larger padding
+	// with ErrorPaddingTooLarge, or "truncate" to truncate the padding and
+	// process them as usual.
+	MaxPublishPaddingBytes int64                       `env:"MAX_PUBLISH_PADDING_BYTES, default=0"`
+	OversizePaddingPolicy  model.OversizePaddingPolicy `env:"PUBLISH_OVERSIZE_PADDING_POLICY, default=reject"`
+	// InsertBatchSize, if set, inserts the new keys of a publish request with
+	// multi-row inserts of up to this many keys, at most 500. Zero inserts one
+	// key per statement.
+	InsertBatchSize int `env:"PUBLISH_INSERT_BATCH_SIZE, default=0"`
+	// InsertMaxAttempts is the number of attempts to save the keys of a publish
+	// request when the transaction fails with a serialization failure or
+	// deadlock, with exponential backoff starting at InsertRetryBackoff. Other
+	// errors aren't retried. 0 or 1 disables retries.
+	InsertMaxAttempts  uint          `env:"PUBLISH_INSERT_MAX_ATTEMPTS, default=3"`
+	InsertRetryBackoff time.Duration `env:"PUBLISH_INSERT_RETRY_BACKOFF, default=50ms"`
 	// Provides compatibility w/ 1.5 release.
 	MaxSameStartIntervalKeys uint          `env:"MAX_SAME_START_INTERVAL_KEYS, default=3"`
 	MaxIntervalAge           time.Duration `env:"MAX_INTERVAL_AGE_ON_PUBLISH, default=360h"`
@@ -75,9 +103,55 @@ type Config struct {
 	// then the upload date minus DEFAULT_SYMPTOM_ONSET_DAYS_AGO is used.
 	SymptomOnsetDaysAgo uint `env:"DEFAULT_SYMPTOM_ONSET_DAYS_AGO, default=4"`
 
+	// ReportTypeTransmissionRisks maps a verification certificate report type to
+	// the transmission risk assigned to keys published without one, in the format
+	// "confirmed:2,likely:4,user-report:5". Report types that are not present
+	// use the built in defaults.
+	ReportTypeTransmissionRisks map[string]int `env:"REPORT_TYPE_TRANSMISSION_RISKS"`
+
+	// If the verification certificate contains a symptom onset interval, keys
+	// that start more than CertOnsetMaxDaysBefore days before or more than
+	// CertOnsetMaxDaysAfter days after the onset are outliers. A value of 0
+	// disables that side of the check. Outliers are dropped with a warning,
+	// unless RejectCertOnsetOutliers is set, in which case they are treated
+	// as invalid keys.
+	CertOnsetMaxDaysBefore  uint `env:"CERTIFICATE_ONSET_MAX_DAYS_BEFORE, default=0"`
+	CertOnsetMaxDaysAfter   uint `env:"CERTIFICATE_ONSET_MAX_DAYS_AFTER, default=0"`
+	RejectCertOnsetOutliers bool `env:"REJECT_CERTIFICATE_ONSET_OUTLIERS, default=false"`
+
+	// Keys that start more than MaxKeyAge before the time of the publish
+	// request are too old to be useful and are not saved. Unlike
+	// MaxIntervalAge, this applies to the start of the key, not the end of its
+	// validity. A value of 0 disables the check. Too old keys are dropped with a
+	// warning, and counted in the response, unless RejectTooOldKeys is set, in
+	// which case they are treated as invalid keys.
+	MaxKeyAge        time.Duration `env:"MAX_EXPOSURE_KEY_AGE, default=0"`
+	RejectTooOldKeys bool          `env:"REJECT_TOO_OLD_EXPOSURE_KEYS, default=false"`
+
+	// MaxKeyRegions is the maximum number of regions a key may be tagged with.
+	// Keys with more regions are treated as invalid keys. A value of 0
+	// disables the check.
+	MaxKeyRegions uint `env:"MAX_REGIONS_PER_KEY, default=0"`
+
+	// KeyValidatorNames are the names of custom key validators, registered
+	// with model.RegisterKeyValidator, that keys are checked with after the
+	// built-in checks. Keys that fail a validator are treated as invalid keys.
+	KeyValidatorNames []string `env:"KEY_VALIDATORS"`
+
+	// If EnforceKeyLength is set, a publish request with any key that doesn't
+	// decode to exactly 16 bytes is rejected before the verification
+	// certificate and its HMAC are checked. Otherwise, those keys are dropped
+	// individually.
+	EnforceKeyLength bool `env:"ENFORCE_EXPOSURE_KEY_LENGTH, default=false"`
+
 	ResponsePaddingMinBytes int64 `env:"RESPONSE_PADDING_MIN_BYTES, default=1024"`
 	ResponsePaddingRange    int64 `env:"RESPONSE_PADDING_RANGE, default=1024"`
 
+	// ResponseIncludeCounts adds the number of inserted, revised, and dropped
+	// keys to successful publish responses. This is off by default since the
+	// counts can reveal information about the request to an observer.
+	ResponseIncludeCounts bool `env:"PUBLISH_RESPONSE_INCLUDE_COUNTS, default=false"`
+
 	RevisionKeyCacheDuration time.Duration `env:"REVISION_KEY_CACHE_DURATION, default=1m"`
 
 	// AllowPartialRevisions permits uploading multiple exposure keys with a
@@ -86,15 +160,60 @@ type Config struct {
 	// uploaded and the remainder are discarded.
 	AllowPartialRevisions bool `env:"ALLOW_PARTIAL_REVISIONS, default=false"`
 
+	// KeepHighestReportType changes how a key that was already published is
+	// handled when it is published again with a different report type. It is
+	// only revised if the new report type has a higher authority (self-report,
+	// then likely, then confirmed or negative), otherwise the stored key is
+	// kept and the request doesn't fail.
+	KeepHighestReportType bool `env:"KEEP_HIGHEST_REPORT_TYPE, default=false"`
+
 	// API Versions.
 	EnableV1Alpha1API bool `env:"ENABLE_V1ALPHA1_API, default=false"`
 
+	// EnableDebugVerifierConfig serves the effective configuration of the
+	// health authority verifier at /debug/verifier-config, with sensitive
+	// values redacted. The endpoint isn't authenticated, only enable it where
+	// it can't be reached publicly, e.g. while debugging verification issues.
+	EnableDebugVerifierConfig bool `env:"ENABLE_DEBUG_VERIFIER_CONFIG, default=false"`
+
+	// PublishSchemaVersions are the request body schemas accepted by the v1
+	// publish API, selected by the X-Publish-Schema-Version header. Requests
+	// without the header use the v1 schema, which must then be accepted.
+	PublishSchemaVersions []string `env:"PUBLISH_SCHEMA_VERSIONS, default=v1"`
+
+	// DetachedHMACHeader, if set, is a request header that may carry the
+	// base64 encoded HMAC of the published keys, for clients that don't bind it
+	// into the certificate's 'tekmac' claim. If the certificate has the claim,
+	// the claim takes precedence and the header must match it. Certificates
+	// without the claim don't bind the keys to the certificate, only enable
+	// this for clients that need it.
+	DetachedHMACHeader string `env:"PUBLISH_DETACHED_HMAC_HEADER"`
+
+	// AcceptLegacyFieldNames accepts the legacy field names of v1 publish
+	// requests, see legacyPublishFields, as the fields they were renamed to.
+	AcceptLegacyFieldNames bool `env:"PUBLISH_ACCEPT_LEGACY_FIELD_NAMES, default=false"`
+	// AllowUnknownPublishFields ignores unknown fields of publish requests. By
+	// default, requests with unknown fields are rejected.
+	AllowUnknownPublishFields bool `env:"PUBLISH_ALLOW_UNKNOWN_FIELDS, default=false"`
+
 	// If set and if a publish request has no regions (v1alpha1) and the health authority
 	// has no regions configured, then this default will be assumed.
 	// This is present for an upgrade edgecase where empty region list used to mean "all regions"
 	// Should only be set if a server is being operated in a single region.
 	DefaultRegion string `env:"DEFAULT_REGION"`
 
+	// MissingRegionPolicy is "default" to apply DefaultRegion to publish
+	// requests without a region, or "reject" to reject them with
+	// ErrorHealthAuthorityMissingRegionConfiguration. The export server must
+	// be configured with the same policy.
+	MissingRegionPolicy model.MissingRegionPolicy `env:"MISSING_REGION_POLICY, default=default"`
+
+	// RegionCanonicalMapping maps region codes to their canonical region code,
+	// e.g. "CALIFORNIA:US-CA". Regions of publish requests are trimmed and
+	// uppercased before the mapping is applied. The export server must be
+	// configured with the same mapping.
+	RegionCanonicalMapping map[string]string `env:"REGION_CANONICAL_MAPPING"`
+
 	// LogJSONParseErrors will log errors from parsoning incoming requests if enabled.
 	// The logs are at the WARN log level.
 	LogJSONParseErrors bool `env:"LOG_JSON_PARSE_ERRORS, default=false"`
@@ -116,6 +235,74 @@ type Config struct {
 	StatsEmbargoPeriod           time.Duration `env:"STATS_EMBARGO_PERIOD, default=48h"`
 	StatsResponsePaddingMinBytes int64         `env:"RESPONSE_PADDING_MIN_BYTES, default=2048"`
 	StatsResponsePaddingRange    int64         `env:"RESPONSE_PADDING_RANGE, default=1024"`
+
+	// Stats submission API config
+	// Maximum number of stats submissions a single health authority may make per hour,
+	// with up to StatsSubmitBurst submissions allowed at once.
+	StatsSubmitRateLimit int `env:"STATS_SUBMIT_RATE_LIMIT, default=60"`
+	StatsSubmitBurst     int `env:"STATS_SUBMIT_BURST, default=10"`
+
+	// StatsReadScope and StatsSubmitScope, if set, are the scopes the 'scope'
+	// claim of a stats token must include to read stats and key counts, or to
+	// submit stats. Tokens without the scope are rejected with a 403. If
+	// empty, scopes aren't checked for that endpoint.
+	StatsReadScope   string `env:"STATS_READ_SCOPE"`
+	StatsSubmitScope string `env:"STATS_SUBMIT_SCOPE"`
+
+	// StatsTokenQueryParam, if set, is a query parameter the stats token is
+	// read from when a request has no Authorization header, for clients that
+	// can't set headers. It is less secure, since URLs may be captured in logs
+	// and caches, and its use is counted in a metric. The header takes
+	// precedence.
+	StatsTokenQueryParam string `env:"STATS_TOKEN_QUERY_PARAM"`
+
+	// PublishCertificateMinInterval is the minimum time between publish
+	// requests with the same verification certificate. Requests that reuse a
+	// certificate sooner are rejected with a 429 and a Retry-After header. 0
+	// disables the check.
+	PublishCertificateMinInterval time.Duration `env:"PUBLISH_CERTIFICATE_MIN_INTERVAL, default=0"`
+
+	// WriteBreakerThreshold is the number of database write failures within
+	// WriteBreakerWindow after which publish requests are rejected with a 503
+	// and a Retry-After header for WriteBreakerCooldown, instead of writing to
+	// the failing database. After the cooldown, a single request probes
+	// whether writes succeed again. 0 disables the breaker.
+	WriteBreakerThreshold uint          `env:"WRITE_BREAKER_THRESHOLD, default=0"`
+	WriteBreakerWindow    time.Duration `env:"WRITE_BREAKER_WINDOW, default=1m"`
+	WriteBreakerCooldown  time.Duration `env:"WRITE_BREAKER_COOLDOWN, default=30s"`
+
+	// PublishCertificateMaxPerIssuer is the maximum number of certificates
+	// each health authority has tracked for PublishCertificateMinInterval.
+	// Once reached, the least recently published certificate is forgotten,
+	// and may be reused before the interval passed. This is counted in a
+	// metric, which means the limit should be raised. 0 means no limit.
+	PublishCertificateMaxPerIssuer uint `env:"PUBLISH_CERTIFICATE_MAX_PER_ISSUER, default=100000"`
+
+	// DailyKeyQuota is the maximum number of keys each health authority may
+	// publish per UTC day. Once a health authority reaches it, further publish
+	// requests are rejected until the next day. Only requests with a verified
+	// certificate are counted. 0 disables the quota.
+	DailyKeyQuota uint `env:"DAILY_KEY_QUOTA, default=0"`
+
+	// EnableQuarantine holds the keys of publish requests flagged by the
+	// quarantine heuristics for manual review in the admin console, instead of
+	// saving them. QuarantineQuotaFraction flags requests that bring a health
+	// authority to at least this fraction of DailyKeyQuota, and
+	// QuarantineMaxRegions requests for more regions. 0 disables either
+	// heuristic.
+	EnableQuarantine        bool    `env:"ENABLE_PUBLISH_QUARANTINE, default=false"`
+	QuarantineQuotaFraction float64 `env:"PUBLISH_QUARANTINE_QUOTA_FRACTION, default=0.9"`
+	QuarantineMaxRegions    int     `env:"PUBLISH_QUARANTINE_MAX_REGIONS, default=0"`
+
+	// Key counts API config
+	// If KeyCountsRequireAuth is set, requests must include a valid stats token
+	// from any health authority. Requests may span at most KeyCountsMaxDays days
+	// and return at most KeyCountsPageSize counts per page. Responses are cached
+	// for KeyCountsCacheDuration.
+	KeyCountsRequireAuth   bool          `env:"KEY_COUNTS_REQUIRE_AUTH, default=true"`
+	KeyCountsMaxDays       uint          `env:"KEY_COUNTS_MAX_DAYS, default=31"`
+	KeyCountsPageSize      int           `env:"KEY_COUNTS_PAGE_SIZE, default=500"`
+	KeyCountsCacheDuration time.Duration `env:"KEY_COUNTS_CACHE_DURATION, default=5m"`
 }
 
 func (c *Config) MaintenanceMode() bool {
@@ -139,6 +326,83 @@ func (c *Config) Validate() error {
 			fmt.Errorf("env var `STATS_UPLOAD_MINIMUM` must be >= 10, got: %v", c.StatsUploadMinimum))
 	}
 
+	if c.StatsSubmitRateLimit <= 0 {
+		result = multierror.Append(result,
+			fmt.Errorf("env var `STATS_SUBMIT_RATE_LIMIT` must be > 0, got: %v", c.StatsSubmitRateLimit))
+	}
+	if c.StatsSubmitBurst <= 0 {
+		result = multierror.Append(result,
+			fmt.Errorf("env var `STATS_SUBMIT_BURST` must be > 0, got: %v", c.StatsSubmitBurst))
+	}
+
+	if c.KeyCountsMaxDays == 0 {
+		result = multierror.Append(result,
+			fmt.Errorf("env var `KEY_COUNTS_MAX_DAYS` must be > 0, got: %v", c.KeyCountsMaxDays))
+	}
+	if c.KeyCountsPageSize <= 0 {
+		result = multierror.Append(result,
+			fmt.Errorf("env var `KEY_COUNTS_PAGE_SIZE` must be > 0, got: %v", c.KeyCountsPageSize))
+	}
+	if c.PublishCertificateMinInterval < 0 {
+		result = multierror.Append(result,
+			fmt.Errorf("env var `PUBLISH_CERTIFICATE_MIN_INTERVAL` must be >= 0, got: %v", c.PublishCertificateMinInterval))
+	}
+	if c.WriteBreakerThreshold > 0 {
+		if c.WriteBreakerWindow <= 0 {
+			result = multierror.Append(result,
+				fmt.Errorf("env var `WRITE_BREAKER_WINDOW` must be > 0, got: %v", c.WriteBreakerWindow))
+		}
+		if c.WriteBreakerCooldown <= 0 {
+			result = multierror.Append(result,
+				fmt.Errorf("env var `WRITE_BREAKER_COOLDOWN` must be > 0, got: %v", c.WriteBreakerCooldown))
+		}
+	}
+	if c.QuarantineQuotaFraction < 0 || c.QuarantineQuotaFraction > 1 {
+		result = multierror.Append(result,
+			fmt.Errorf("env var `PUBLISH_QUARANTINE_QUOTA_FRACTION` must be between 0 and 1, got: %v", c.QuarantineQuotaFraction))
+	}
+	if c.QuarantineMaxRegions < 0 {
+		result = multierror.Append(result,
+			fmt.Errorf("env var `PUBLISH_QUARANTINE_MAX_REGIONS` must be >= 0, got: %v", c.QuarantineMaxRegions))
+	}
+	if c.KeyCountsCacheDuration < 0 {
+		result = multierror.Append(result,
+			fmt.Errorf("env var `KEY_COUNTS_CACHE_DURATION` must be >= 0, got: %v", c.KeyCountsCacheDuration))
+	}
+
+	if c.MaxPublishBodyBytes <= 0 {
+		result = multierror.Append(result,
+			fmt.Errorf("env var `MAX_PUBLISH_BODY_BYTES` must be > 0, got: %v", c.MaxPublishBodyBytes))
+	}
+
+	if c.MaxPublishPaddingBytes < 0 {
+		result = multierror.Append(result,
+			fmt.Errorf("env var `MAX_PUBLISH_PADDING_BYTES` cannot be negative, got: %v", c.MaxPublishPaddingBytes))
+	}
+	if err := c.OversizePaddingPolicy.Validate(); err != nil {
+		result = multierror.Append(result,
+			fmt.Errorf("env var `PUBLISH_OVERSIZE_PADDING_POLICY`: %w", err))
+	}
+
+	if c.InsertBatchSize < 0 {
+		result = multierror.Append(result,
+			fmt.Errorf("env var `PUBLISH_INSERT_BATCH_SIZE` cannot be negative, got: %v", c.InsertBatchSize))
+	}
+	if c.InsertMaxAttempts > 1 && c.InsertRetryBackoff <= 0 {
+		result = multierror.Append(result,
+			fmt.Errorf("env var `PUBLISH_INSERT_RETRY_BACKOFF` must be > 0 if `PUBLISH_INSERT_MAX_ATTEMPTS` is greater than 1, got: %v", c.InsertRetryBackoff))
+	}
+
+	if c.MaxKeyAge < 0 {
+		result = multierror.Append(result,
+			fmt.Errorf("env var `MAX_EXPOSURE_KEY_AGE` must be >= 0, got: %v", c.MaxKeyAge))
+	}
+
+	if err := c.MissingRegionPolicy.Validate(); err != nil {
+		result = multierror.Append(result,
+			fmt.Errorf("env var `MISSING_REGION_POLICY`: %w", err))
+	}
+
 	if ep := c.StatsEmbargoPeriod; !(ep >= (48*time.Hour) || ep <= 0) {
 		result = multierror.Append(result,
 			fmt.Errorf("env var `STATS_EMBARGO_PERIOD` must be >= 48h or <= 0 to disable release of stats days below the threshold"))
@@ -179,6 +443,38 @@ func (c *Config) DebugReleaseSameDayKeys() bool {
 	return c.ReleaseSameDayKeys
 }
 
+func (c *Config) DefaultReportTypeTransmissionRisks() map[string]int {
+	return c.ReportTypeTransmissionRisks
+}
+
+func (c *Config) CertificateOnsetMaxDaysBefore() uint {
+	return c.CertOnsetMaxDaysBefore
+}
+
+func (c *Config) CertificateOnsetMaxDaysAfter() uint {
+	return c.CertOnsetMaxDaysAfter
+}
+
+func (c *Config) RejectCertificateOnsetOutliers() bool {
+	return c.RejectCertOnsetOutliers
+}
+
+func (c *Config) MaxExposureKeyAge() time.Duration {
+	return c.MaxKeyAge
+}
+
+func (c *Config) RejectTooOldExposureKeys() bool {
+	return c.RejectTooOldKeys
+}
+
+func (c *Config) MaxRegionsPerKey() uint {
+	return c.MaxKeyRegions
+}
+
+func (c *Config) KeyValidators() []string {
+	return c.KeyValidatorNames
+}
+
 func (c *Config) AuthorizedAppConfig() *authorizedapp.Config {
 	return &c.AuthorizedApp
 }
@@ -198,3 +494,7 @@ func (c *Config) ObservabilityExporterConfig() *observability.Config {
 func (c *Config) KeyManagerConfig() *keys.Config {
 	return &c.KeyManager
 }
+
+func (c *Config) StatsSinkConfig() *statssink.Config {
+	return &c.StatsSink
+}
@@ -0,0 +1,176 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/authorizedapp"
+	aadb "github.com/google/exposure-notifications-server/internal/authorizedapp/database"
+	aamodel "github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/exposure-notifications-server/internal/publish/database"
+	revisiondb "github.com/google/exposure-notifications-server/internal/revision/database"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	testutil "github.com/google/exposure-notifications-server/internal/utils"
+	vermodel "github.com/google/exposure-notifications-server/internal/verification/model"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
+	"github.com/google/exposure-notifications-server/pkg/keys"
+	"github.com/google/exposure-notifications-server/pkg/timeutils"
+	"github.com/google/exposure-notifications-server/pkg/util"
+	"github.com/sethvargo/go-envconfig"
+)
+
+func TestDailyKeyQuota(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+
+	healthAuthority := &vermodel.HealthAuthority{
+		Issuer:   "gov.state.health",
+		Audience: "unit.test.server",
+		Name:     "State Dept of Health",
+	}
+	healthAuthorityKey := &vermodel.HealthAuthorityKey{
+		Version: "v1",
+		From:    time.Now().Add(-1 * time.Minute),
+	}
+	signingKey := testutil.GetSigningKey(t)
+	testutil.InitializeVerificationDB(ctx, t, testDB, healthAuthority, healthAuthorityKey, signingKey)
+
+	authorizedApp := aamodel.NewAuthorizedApp()
+	authorizedApp.AppPackageName = "gov.state.health"
+	authorizedApp.BypassRevisionToken = true
+	authorizedApp.AllowedRegions["US"] = struct{}{}
+	authorizedApp.AllowedHealthAuthorityIDs[healthAuthority.ID] = struct{}{}
+	if err := aadb.New(testDB).InsertAuthorizedApp(ctx, authorizedApp); err != nil {
+		t.Fatal(err)
+	}
+
+	kms := keys.TestKeyManager(t)
+	keyID := keys.TestEncryptionKey(t, kms)
+	revDB, err := revisiondb.New(testDB, &revisiondb.KMSConfig{WrapperKeyID: keyID, KeyManager: kms})
+	if err != nil {
+		t.Fatalf("unable to create revision DB handle: %v", err)
+	}
+	if _, err := revDB.CreateRevisionKey(ctx); err != nil {
+		t.Fatalf("unable to create revision key: %v", err)
+	}
+
+	config := Config{}
+	if err := envconfig.ProcessWith(ctx, &config, envconfig.OsLookuper()); err != nil {
+		t.Fatal(err)
+	}
+	config.AuthorizedApp.CacheDuration = time.Nanosecond
+	config.CreatedAtTruncateWindow = time.Second
+	config.MaxKeysOnPublish = 20
+	config.MaxSameStartIntervalKeys = 2
+	config.MaxIntervalAge = 14 * 24 * time.Hour
+	config.DailyKeyQuota = 3
+	config.RevisionToken.AAD = make([]byte, 16)
+	if _, err := rand.Read(config.RevisionToken.AAD); err != nil {
+		t.Fatalf("not enough entropy: %v", err)
+	}
+	config.RevisionToken.KeyID = keyID
+
+	aaProvider, err := authorizedapp.NewDatabaseProvider(ctx, testDB, config.AuthorizedAppConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := serverenv.New(ctx,
+		serverenv.WithDatabase(testDB),
+		serverenv.WithAuthorizedAppProvider(aaProvider),
+		serverenv.WithKeyManager(kms))
+
+	publishServer, err := NewServer(ctx, &config, env)
+	if err != nil {
+		t.Fatalf("unable to create publish handler: %v", err)
+	}
+
+	// The count of a previous day doesn't apply today.
+	publishDB := database.New(testDB)
+	if _, err := publishDB.IncrementDailyKeyCount(ctx, healthAuthority.ID, time.Now().Add(-24*time.Hour), 100); err != nil {
+		t.Fatal(err)
+	}
+
+	publishKeys := func(tb *testing.T) *httptest.ResponseRecorder {
+		tb.Helper()
+
+		publish := &verifyapi.Publish{
+			Keys:              util.GenerateExposureKeys(2, 0, false),
+			HealthAuthorityID: healthAuthority.Issuer,
+		}
+		utcDay := timeutils.UTCMidnight(time.Now())
+		verification, salt := testutil.IssueJWT(tb, &testutil.JWTConfig{
+			HealthAuthority:      healthAuthority,
+			HealthAuthorityKey:   healthAuthorityKey,
+			ExposureKeys:         publish.Keys,
+			Key:                  signingKey.Key,
+			SymptomOnsetInterval: uint32(utcDay.Unix()/600) - verifyapi.MaxIntervalCount,
+			ReportType:           verifyapi.ReportTypeConfirmed,
+		})
+		publish.VerificationPayload = verification
+		publish.HMACKey = salt
+
+		body, err := json.Marshal(publish)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		request, err := http.NewRequestWithContext(ctx, http.MethodPost, "", strings.NewReader(string(body)))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		request.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		publishServer.handlePublishV1().ServeHTTP(rr, request)
+		return rr
+	}
+
+	// The second request takes the health authority over its quota, but is
+	// accepted since the quota wasn't reached before it.
+	for i := 0; i < 2; i++ {
+		if rr := publishKeys(t); rr.Code != http.StatusOK {
+			t.Fatalf("expected publish %d to succeed, got %d: %s", i, rr.Code, rr.Body.String())
+		}
+	}
+
+	count, err := publishDB.DailyKeyCount(ctx, healthAuthority.ID, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := count, int64(4); got != want {
+		t.Errorf("expected %d keys to be counted, got %d", want, got)
+	}
+
+	rr := publishKeys(t)
+	if got, want := rr.Code, http.StatusTooManyRequests; got != want {
+		t.Fatalf("expected status %d to be %d: %s", got, want, rr.Body.String())
+	}
+	var resp verifyapi.PublishResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resp.Code, verifyapi.ErrorDailyKeyQuotaExceeded; got != want {
+		t.Errorf("expected code %q to be %q", got, want)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// ExposureCleanupCheckpoint is the name of the cleanup checkpoint for the
+// Exposure table.
+const ExposureCleanupCheckpoint = "Exposure"
+
+// GetCleanupCheckpoint returns the created_at of the newest row deleted by a
+// batched cleanup of the named table. All older rows have been deleted. If
+// there is no checkpoint, it returns the zero time.
+func (db *PublishDB) GetCleanupCheckpoint(ctx context.Context, name string) (time.Time, error) {
+	var checkpoint time.Time
+	err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		var err error
+		checkpoint, err = getCleanupCheckpoint(ctx, tx, name)
+		return err
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return checkpoint, nil
+}
+
+func getCleanupCheckpoint(ctx context.Context, tx pgx.Tx, name string) (time.Time, error) {
+	row := tx.QueryRow(ctx, `
+		SELECT
+			last_timestamp
+		FROM
+			CleanupCheckpoint
+		WHERE
+			table_name = $1
+		`, name)
+
+	var checkpoint time.Time
+	if err := row.Scan(&checkpoint); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("reading cleanup checkpoint: %w", err)
+	}
+	return checkpoint, nil
+}
+
+// advanceCleanupCheckpoint moves the checkpoint for the named table forward
+// to t. It never moves backwards.
+func advanceCleanupCheckpoint(ctx context.Context, tx pgx.Tx, name string, t time.Time) error {
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO
+			CleanupCheckpoint (table_name, last_timestamp, updated_at)
+		VALUES
+			($1, $2, $3)
+		ON CONFLICT (table_name) DO UPDATE SET
+			last_timestamp = GREATEST(CleanupCheckpoint.last_timestamp, EXCLUDED.last_timestamp),
+			updated_at = EXCLUDED.updated_at
+		`, name, t, time.Now().UTC()); err != nil {
+		return fmt.Errorf("advancing cleanup checkpoint: %w", err)
+	}
+	return nil
+}
@@ -57,6 +57,10 @@ var (
 	// in it, but the new request is attempting to change the metadata of the key
 	// (intervalNumber/Count).
 	ErrIncomingMetadataMismatch = errors.New("incoming exposure key metadata does not match expected values")
+
+	// ErrInvalidKeyCountsCursor is returned when the cursor passed to
+	// ReadKeyCounts was not returned by a previous call.
+	ErrInvalidKeyCountsCursor = errors.New("invalid key counts cursor")
 )
 
 type PublishDB struct {
@@ -81,13 +85,74 @@ type IterateExposuresCriteria struct {
 	LastCursor       string
 	OnlyRevisedKeys  bool // If true, only revised keys that match will be selected.
 
+	// IncludeMissingRegions also includes records that have no regions, as if
+	// they were in one of the IncludeRegions.
+	IncludeMissingRegions bool
+
 	// OnlyLocalProvenance indicates that only exposures with LocalProvenance=true will be returned.
 	OnlyLocalProvenance bool
 
+	// ExcludeNoFederate excludes exposures published by health authorities
+	// that are marked as NoFederate. ExcludeHealthAuthorityIssuers excludes
+	// exposures published by the health authorities with these issuers.
+	ExcludeNoFederate             bool
+	ExcludeHealthAuthorityIssuers []string
+
+	// OnsetWindow, if set, restricts exposures based on their symptom onset.
+	OnsetWindow *OnsetWindow
+
+	// TransmissionRisk, if set, restricts exposures based on their
+	// transmission risk.
+	TransmissionRisk *TransmissionRiskFilter
+
+	// ReportTypes, if set, restricts exposures based on their report type.
+	ReportTypes *ReportTypeFilter
+
+	// OrderByKey orders the exposures by their decoded key bytes, the same
+	// order as bytes.Compare, instead of by the time they were created or
+	// revised.
+	OrderByKey bool
+
 	// If limit is > 0, a limit query will be set on the database query.
 	Limit uint32
 }
 
+// OnsetWindow restricts exposures to those whose symptom onset is between
+// MinDays and MaxDays (inclusive) days before the Reference time. A nil bound
+// is unbounded. The onset is the revised days since symptom onset, if present.
+type OnsetWindow struct {
+	Reference time.Time
+	MinDays   *int
+	MaxDays   *int
+
+	// ExcludeMissing excludes exposures without symptom onset information,
+	// otherwise they are always included.
+	ExcludeMissing bool
+}
+
+// TransmissionRiskFilter restricts exposures to those with a transmission risk
+// of at least Min. The original transmission risk is used, since that is the
+// one that's exported, also for revised keys.
+type TransmissionRiskFilter struct {
+	Min int
+
+	// IncludeUnknown includes exposures with an unknown (0) transmission risk,
+	// otherwise they are excluded.
+	IncludeUnknown bool
+}
+
+// ReportTypeFilter restricts exposures to those with one of the Allowed report
+// types. Revised keys are also included if their revised report type is
+// allowed, or the one they were revised from, so that keys that were exported
+// are revised too.
+type ReportTypeFilter struct {
+	Allowed []string
+
+	// IncludeUnknown includes exposures without a report type, otherwise they
+	// are excluded.
+	IncludeUnknown bool
+}
+
 type IteratorFunction func(*model.Exposure) error
 
 // IterateExposures calls f on each Exposure in the database that matches the
@@ -189,22 +254,35 @@ func generateExposureQuery(criteria IterateExposuresCriteria) (string, []interfa
 		WHERE 1=1
 	`
 
+	// Keys that have been revoked by the health authority are never exported,
+	// neither as new keys nor as revisions.
+	q += " AND revoked_at IS NULL"
+
 	if len(criteria.IncludeRegions) == 1 {
+		args = append(args, criteria.IncludeRegions)
+		regionMatch := fmt.Sprintf("(regions && $%d)", len(args)) // Operation "&&" means "array overlaps / intersects"
+		if criteria.IncludeMissingRegions {
+			regionMatch = fmt.Sprintf("(%s OR COALESCE(cardinality(regions), 0) = 0)", regionMatch)
+		}
+
 		if criteria.IncludeTravelers {
 			// If the query has include ragions and include travelers set - we want the union of the specified regions and
 			// all "traveler" keys that this server knows about.
-			args = append(args, criteria.IncludeRegions)
 			args = append(args, true)
-			q += fmt.Sprintf(" AND ((regions && $%d) OR traveler = $%d)", len(args)-1, len(args)) // Operation "&&" means "array overlaps / intersects"
+			q += fmt.Sprintf(" AND (%s OR traveler = $%d)", regionMatch, len(args))
 		} else {
-			args = append(args, criteria.IncludeRegions)
-			q += fmt.Sprintf(" AND (regions && $%d)", len(args)) // Operation "&&" means "array overlaps / intersects"
+			q += fmt.Sprintf(" AND %s", regionMatch)
 		}
 	}
 
 	if len(criteria.ExcludeRegions) == 1 {
 		args = append(args, criteria.ExcludeRegions)
-		q += fmt.Sprintf(" AND NOT (regions && $%d)", len(args)) // Operation "&&" means "array overlaps / intersects"
+		if criteria.IncludeMissingRegions {
+			// Records without regions don't overlap, rather than being unknown.
+			q += fmt.Sprintf(" AND NOT COALESCE(regions && $%d, false)", len(args))
+		} else {
+			q += fmt.Sprintf(" AND NOT (regions && $%d)", len(args)) // Operation "&&" means "array overlaps / intersects"
+		}
 	}
 
 	timeField := "created_at"
@@ -238,12 +316,73 @@ func generateExposureQuery(criteria IterateExposuresCriteria) (string, []interfa
 		q += fmt.Sprintf(" AND traveler = $%d", len(args))
 	}
 
+	if criteria.ExcludeNoFederate || len(criteria.ExcludeHealthAuthorityIssuers) > 0 {
+		excluded := make([]string, 0, 2)
+		if criteria.ExcludeNoFederate {
+			excluded = append(excluded, "no_federate")
+		}
+		if len(criteria.ExcludeHealthAuthorityIssuers) > 0 {
+			args = append(args, criteria.ExcludeHealthAuthorityIssuers)
+			excluded = append(excluded, fmt.Sprintf("iss = ANY($%d)", len(args)))
+		}
+		q += fmt.Sprintf(" AND (health_authority_id IS NULL OR health_authority_id NOT IN (SELECT id FROM HealthAuthority WHERE %s))",
+			strings.Join(excluded, " OR "))
+	}
+
+	if w := criteria.OnsetWindow; w != nil {
+		// The onset day is the day (since the unix epoch) of the key's interval,
+		// less the days since symptom onset.
+		const onsetDay = "(interval_number / 144 - COALESCE(revised_days_since_symptom_onset, days_since_symptom_onset))"
+		referenceDay := int(w.Reference.Unix() / int64((24 * time.Hour).Seconds()))
+
+		var bounds []string
+		if w.MaxDays != nil {
+			args = append(args, referenceDay-*w.MaxDays)
+			bounds = append(bounds, fmt.Sprintf("%s >= $%d", onsetDay, len(args)))
+		}
+		if w.MinDays != nil {
+			args = append(args, referenceDay-*w.MinDays)
+			bounds = append(bounds, fmt.Sprintf("%s <= $%d", onsetDay, len(args)))
+		}
+
+		switch {
+		case w.ExcludeMissing:
+			bounds = append([]string{onsetDay + " IS NOT NULL"}, bounds...)
+			q += fmt.Sprintf(" AND (%s)", strings.Join(bounds, " AND "))
+		case len(bounds) > 0:
+			q += fmt.Sprintf(" AND (%s IS NULL OR (%s))", onsetDay, strings.Join(bounds, " AND "))
+		}
+	}
+
+	if f := criteria.TransmissionRisk; f != nil {
+		args = append(args, f.Min)
+		if f.IncludeUnknown {
+			q += fmt.Sprintf(" AND (transmission_risk >= $%d OR transmission_risk = 0)", len(args))
+		} else {
+			q += fmt.Sprintf(" AND transmission_risk >= $%d", len(args))
+		}
+	}
+
+	if f := criteria.ReportTypes; f != nil {
+		args = append(args, f.Allowed)
+		matches := []string{fmt.Sprintf("report_type = ANY($%d)", len(args))}
+		if criteria.OnlyRevisedKeys {
+			matches = append(matches, fmt.Sprintf("revised_report_type = ANY($%d)", len(args)))
+		}
+		if f.IncludeUnknown {
+			matches = append(matches, "report_type = ''")
+		}
+		q += fmt.Sprintf(" AND (%s)", strings.Join(matches, " OR "))
+	}
+
 	if criteria.OnlyNonTravelers {
 		args = append(args, false)
 		q += fmt.Sprintf(" AND traveler = $%d", len(args))
 	}
 
-	if criteria.OnlyRevisedKeys {
+	if criteria.OrderByKey {
+		q += " ORDER BY decode(exposure_key, 'base64')"
+	} else if criteria.OnlyRevisedKeys {
 		q += " ORDER BY revised_at"
 	} else {
 		q += " ORDER BY created_at"
@@ -272,20 +411,43 @@ func generateExposureQuery(criteria IterateExposuresCriteria) (string, []interfa
 // In the return map, the key is the base64 of the ExposureKey.
 // The keys are read for update in a provided transaction.
 func (db *PublishDB) ReadExposures(ctx context.Context, tx pgx.Tx, b64keys []string) (map[string]*model.Exposure, error) {
+	return readExposures(ctx, tx, b64keys, true)
+}
+
+// LookupExposures reads an existing set of exposures from the database without
+// locking them, for callers that only display the keys. In the return map, the
+// key is the base64 of the ExposureKey.
+func (db *PublishDB) LookupExposures(ctx context.Context, b64keys []string) (map[string]*model.Exposure, error) {
+	var exposures map[string]*model.Exposure
+	if err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		var err error
+		exposures, err = readExposures(ctx, tx, b64keys, false)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return exposures, nil
+}
+
+func readExposures(ctx context.Context, tx pgx.Tx, b64keys []string, forUpdate bool) (map[string]*model.Exposure, error) {
 	exposures := make(map[string]*model.Exposure)
 
+	lock := ""
+	if forUpdate {
+		lock = "FOR UPDATE"
+	}
+
 	rows, err := tx.Query(ctx, `
 			SELECT
 				exposure_key, transmission_risk, app_package_name, regions, traveler,
 				interval_number, interval_count, created_at, local_provenance, sync_id,
 				health_authority_id, report_type, days_since_symptom_onset,
 				revised_report_type, revised_at, revised_days_since_symptom_onset,
-				revised_transmission_risk, export_import_id
+				revised_transmission_risk, export_import_id, symptom_onset_interval
 			FROM
 				Exposure
 			WHERE exposure_key = ANY($1)
-			FOR UPDATE
-		`, b64keys)
+			`+lock, b64keys)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list: %w", err)
 	}
@@ -306,7 +468,7 @@ func (db *PublishDB) ReadExposures(ctx context.Context, tx pgx.Tx, b64keys []str
 			&exposure.CreatedAt, &exposure.LocalProvenance, &syncID,
 			&exposure.HealthAuthorityID, &exposure.ReportType, &exposure.DaysSinceSymptomOnset,
 			&exposure.RevisedReportType, &exposure.RevisedAt, &exposure.RevisedDaysSinceSymptomOnset,
-			&exposure.RevisedTransmissionRisk, &exposure.ExportImportID,
+			&exposure.RevisedTransmissionRisk, &exposure.ExportImportID, &exposure.SymptomOnsetInterval,
 		); err != nil {
 			return nil, fmt.Errorf("failed to parse: %w", err)
 		}
@@ -327,6 +489,55 @@ func (db *PublishDB) ReadExposures(ctx context.Context, tx pgx.Tx, b64keys []str
 	return exposures, nil
 }
 
+// ListExposuresByCertificateHash returns the exposures that were published
+// with the verification certificate with the given hash, newest first and at
+// most limit of them. Only the metadata of the exposures is read, the
+// returned exposures have no ExposureKey.
+func (db *PublishDB) ListExposuresByCertificateHash(ctx context.Context, certificateHash string, limit int) ([]*model.Exposure, error) {
+	var exposures []*model.Exposure
+	if err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+			SELECT
+				transmission_risk, app_package_name, regions, traveler,
+				interval_number, interval_count, created_at, local_provenance,
+				health_authority_id, report_type, days_since_symptom_onset,
+				revised_report_type, revised_at, revised_days_since_symptom_onset,
+				revised_transmission_risk, certificate_hash
+			FROM
+				Exposure
+			WHERE certificate_hash = $1
+			ORDER BY created_at DESC
+			LIMIT $2
+			`, certificateHash, limit)
+		if err != nil {
+			return fmt.Errorf("failed to list: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			if err := rows.Err(); err != nil {
+				return fmt.Errorf("failed to iterate: %w", err)
+			}
+
+			var exposure model.Exposure
+			if err := rows.Scan(
+				&exposure.TransmissionRisk, &exposure.AppPackageName, &exposure.Regions, &exposure.Traveler,
+				&exposure.IntervalNumber, &exposure.IntervalCount, &exposure.CreatedAt, &exposure.LocalProvenance,
+				&exposure.HealthAuthorityID, &exposure.ReportType, &exposure.DaysSinceSymptomOnset,
+				&exposure.RevisedReportType, &exposure.RevisedAt, &exposure.RevisedDaysSinceSymptomOnset,
+				&exposure.RevisedTransmissionRisk, &exposure.CertificateHash,
+			); err != nil {
+				return fmt.Errorf("failed to parse: %w", err)
+			}
+			exposures = append(exposures, &exposure)
+		}
+		return rows.Err()
+	}); err != nil {
+		return nil, fmt.Errorf("list exposures by certificate: %w", err)
+	}
+	return exposures, nil
+}
+
 func prepareInsertExposure(ctx context.Context, tx pgx.Tx) (string, error) {
 	const stmtName = "insert exposures"
 	_, err := tx.Prepare(ctx, stmtName, `
@@ -334,31 +545,94 @@ func prepareInsertExposure(ctx context.Context, tx pgx.Tx) (string, error) {
 			Exposure
 				(exposure_key, transmission_risk, app_package_name, regions, traveler, interval_number, interval_count,
 				 created_at, local_provenance, sync_id, sync_query_id, health_authority_id, report_type, days_since_symptom_onset,
-				 export_import_id, import_file_id)
+				 export_import_id, import_file_id, symptom_onset_interval, certificate_hash)
 		VALUES
-			($1, $2, LOWER($3), $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+			($1, $2, LOWER($3), $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
 		ON CONFLICT (exposure_key) DO NOTHING
 	`)
 	return stmtName, err
 }
 
 func executeInsertExposure(ctx context.Context, tx pgx.Tx, stmtName string, exp *model.Exposure) error {
+	_, err := tx.Exec(ctx, stmtName, insertExposureArgs(exp)...)
+	if err != nil {
+		return fmt.Errorf("inserting exposure: %w", err)
+	}
+	return nil
+}
+
+// insertExposureColumns is the number of values inserted per exposure, see
+// insertExposureArgs.
+const insertExposureColumns = 18
+
+// insertExposureArgs returns the values of the exposure in the column order of
+// the insert statements.
+func insertExposureArgs(exp *model.Exposure) []interface{} {
 	var syncID *int64
 	var queryID *string
+	var certificateHash *string
 	if exp.FederationSyncID != 0 {
 		syncID = &exp.FederationSyncID
 	}
 	if exp.FederationQueryID != "" {
 		queryID = &exp.FederationQueryID
 	}
+	if exp.CertificateHash != "" {
+		certificateHash = &exp.CertificateHash
+	}
 
-	_, err := tx.Exec(ctx, stmtName, encodeExposureKey(exp.ExposureKey), exp.TransmissionRisk,
+	return []interface{}{
+		encodeExposureKey(exp.ExposureKey), exp.TransmissionRisk,
 		exp.AppPackageName, exp.Regions, exp.Traveler, exp.IntervalNumber, exp.IntervalCount,
 		exp.CreatedAt, exp.LocalProvenance, syncID, queryID,
 		exp.HealthAuthorityID, exp.ReportType, exp.DaysSinceSymptomOnset,
-		exp.ExportImportID, exp.ImportFileID)
-	if err != nil {
-		return fmt.Errorf("inserting exposure: %w", err)
+		exp.ExportImportID, exp.ImportFileID, exp.SymptomOnsetInterval, certificateHash,
+	}
+}
+
+// executeInsertExposures inserts the exposures with a single multi-row
+// statement. Like executeInsertExposure, exposures whose key already exists
+// are skipped.
+func executeInsertExposures(ctx context.Context, tx pgx.Tx, exps []*model.Exposure) error {
+	if len(exps) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString(`
+		INSERT INTO
+			Exposure
+				(exposure_key, transmission_risk, app_package_name, regions, traveler, interval_number, interval_count,
+				 created_at, local_provenance, sync_id, sync_query_id, health_authority_id, report_type, days_since_symptom_onset,
+				 export_import_id, import_file_id, symptom_onset_interval, certificate_hash)
+		VALUES
+	`)
+	args := make([]interface{}, 0, len(exps)*insertExposureColumns)
+	for i, exp := range exps {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString("(")
+		for j := 1; j <= insertExposureColumns; j++ {
+			if j > 1 {
+				sb.WriteString(", ")
+			}
+			param := fmt.Sprintf("$%d", len(args)+j)
+			// app_package_name is stored in lower case.
+			if j == 3 {
+				param = "LOWER(" + param + ")"
+			}
+			sb.WriteString(param)
+		}
+		sb.WriteString(")")
+		args = append(args, insertExposureArgs(exp)...)
+	}
+	sb.WriteString(`
+		ON CONFLICT (exposure_key) DO NOTHING
+	`)
+
+	if _, err := tx.Exec(ctx, sb.String(), args...); err != nil {
+		return fmt.Errorf("inserting %d exposures: %w", len(exps), err)
 	}
 	return nil
 }
@@ -420,6 +694,23 @@ type InsertAndReviseExposuresRequest struct {
 	RequireQueryID bool
 	// When revising, require matching export-import-ID. For export file based import federation.
 	RequireExportImportID bool
+
+	// KeepHighestReportType only revises existing keys if the incoming report
+	// type ranks higher, see model.ReportTypeAuthority. Otherwise the stored
+	// key is kept, instead of failing on an invalid report type transition.
+	KeepHighestReportType bool
+
+	// InsertBatchSize, if set, inserts new exposures with multi-row inserts of
+	// up to this many exposures, at most InsertExposuresBatchSize, instead of
+	// one statement per exposure. Revisions are always applied one at a time,
+	// since each must match exactly one unrevised key.
+	InsertBatchSize int
+
+	// Quarantine, if set, saves the incoming exposures that would be inserted
+	// or revised in quarantine instead, after the same validation. They are
+	// saved when the quarantine is approved, see ApproveQuarantine. The ID of
+	// the quarantine is set on it.
+	Quarantine *model.Quarantine
 }
 
 // InsertAndReviseExposuresResponse is the response from an
@@ -445,8 +736,6 @@ type InsertAndReviseExposuresResponse struct {
 // InsertAndReviseExposures transactionally revises and inserts a set of keys as
 // necessary.
 func (db *PublishDB) InsertAndReviseExposures(ctx context.Context, req *InsertAndReviseExposuresRequest) (*InsertAndReviseExposuresResponse, error) {
-	logger := logging.FromContext(ctx).Named("InsertAndReviseExposures")
-
 	if req == nil {
 		return nil, fmt.Errorf("missing request")
 	}
@@ -454,234 +743,302 @@ func (db *PublishDB) InsertAndReviseExposures(ctx context.Context, req *InsertAn
 		return nil, fmt.Errorf("configuration paradox: skipRevisions and onlyRevisions are both set to true")
 	}
 
-	// Save a handle to the publish info stats, which may be nil.
-	stats := req.PublishInfo
-
 	// Maintain a record of the number of exposures inserted and updated, and a
 	// record of the exposures that were actually inserted/updated after merge
 	// logic.
 	var resp InsertAndReviseExposuresResponse
 
 	if err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
-		// Build the base64-encoded list of keys - this is needed so we can lookup
-		// the keys in the database. Also build a lookup map by key for validation
-		// later.
-		b64keys := make([]string, len(req.Incoming))
-		incomingMap := make(map[string]*model.Exposure, len(req.Incoming))
-		for i, v := range req.Incoming {
-			b64keys[i] = v.ExposureKeyBase64()
-			incomingMap[v.ExposureKeyBase64()] = v
-		}
-
-		// Lookup the keys in the database and build a lookup map for validation
-		// later.
-		existing, err := db.ReadExposures(ctx, tx, b64keys)
-		if err != nil {
-			return fmt.Errorf("unable to check for existing records: %w", err)
-		}
-		existingMap := make(map[string]*model.Exposure, len(existing))
-		for _, v := range existing {
-			existingMap[v.ExposureKeyBase64()] = v
-		}
+		return db.insertAndReviseExposures(ctx, tx, req, &resp)
+	}); err != nil {
+		return nil, err
+	}
 
-		// For federation - if we ONLY want to process revisions.
-		if req.OnlyRevisions {
-			for k := range incomingMap {
-				if _, ok := existingMap[k]; !ok {
-					delete(incomingMap, k)
-				}
+	return &resp, nil
+}
+
+// insertAndReviseExposures revises and inserts the keys of the request in the
+// transaction, and counts them in resp.
+func (db *PublishDB) insertAndReviseExposures(ctx context.Context, tx pgx.Tx, req *InsertAndReviseExposuresRequest, resp *InsertAndReviseExposuresResponse) error {
+	logger := logging.FromContext(ctx).Named("InsertAndReviseExposures")
+
+	// Save a handle to the publish info stats, which may be nil.
+	stats := req.PublishInfo
+
+	// Build the base64-encoded list of keys - this is needed so we can lookup
+	// the keys in the database. Also build a lookup map by key for validation
+	// later.
+	b64keys := make([]string, len(req.Incoming))
+	incomingMap := make(map[string]*model.Exposure, len(req.Incoming))
+	for i, v := range req.Incoming {
+		b64keys[i] = v.ExposureKeyBase64()
+		incomingMap[v.ExposureKeyBase64()] = v
+	}
+
+	// Lookup the keys in the database and build a lookup map for validation
+	// later.
+	existing, err := db.ReadExposures(ctx, tx, b64keys)
+	if err != nil {
+		return fmt.Errorf("unable to check for existing records: %w", err)
+	}
+	existingMap := make(map[string]*model.Exposure, len(existing))
+	for _, v := range existing {
+		existingMap[v.ExposureKeyBase64()] = v
+	}
+
+	// For federation - if we ONLY want to process revisions.
+	if req.OnlyRevisions {
+		for k := range incomingMap {
+			if _, ok := existingMap[k]; !ok {
+				delete(incomingMap, k)
 			}
 		}
+	}
 
-		// See if the revision token is relevant. We only need to check it if keys
-		// are being revised.
-		if len(existing) > 0 {
-			// Check if a revision token is required.
-			if req.Token == nil {
-				if req.RequireToken {
-					logger.Warnw("attempted to revise keys, but revision token is missing")
-					return ErrNoRevisionToken
-				}
+	// See if the revision token is relevant. We only need to check it if keys
+	// are being revised.
+	if len(existing) > 0 {
+		// Check if a revision token is required.
+		if req.Token == nil {
+			if req.RequireToken {
+				logger.Warnw("attempted to revise keys, but revision token is missing")
+				return ErrNoRevisionToken
 			}
+		}
 
-			// Build a map of allowed revisions for validation and comparison.
-			allowedRevisions := make(map[string]*pb.RevisableKey)
-			if req.Token != nil {
-				// Special handling for the allow bypass scenario where no token was presented.
-				for _, v := range req.Token.RevisableKeys {
-					b := base64.StdEncoding.EncodeToString(v.TemporaryExposureKey)
-					allowedRevisions[b] = v
-				}
+		// Build a map of allowed revisions for validation and comparison.
+		allowedRevisions := make(map[string]*pb.RevisableKey)
+		if req.Token != nil {
+			// Special handling for the allow bypass scenario where no token was presented.
+			for _, v := range req.Token.RevisableKeys {
+				b := base64.StdEncoding.EncodeToString(v.TemporaryExposureKey)
+				allowedRevisions[b] = v
 			}
+		}
 
-			// Check that any existing exposures are present in the token.
-			for k, ex := range existing {
-				// For federation, if a key is rquested for insert.
-				if req.SkipRevisions {
-					logger.Warnw("skipping key: would be revised but revision disabled for request")
-					delete(incomingMap, k)
-					continue
-				}
+		// Check that any existing exposures are present in the token.
+		for k, ex := range existing {
+			// For federation, if a key is rquested for insert.
+			if req.SkipRevisions {
+				logger.Warnw("skipping key: would be revised but revision disabled for request")
+				delete(incomingMap, k)
+				continue
+			}
 
-				// For federation. If the exposure is inbound on the same query, it is allowed.
-				if req.RequireQueryID {
-					if in, ok := incomingMap[k]; ok {
-						if in.FederationQueryID != ex.FederationQueryID {
-							logger.Warnw("key revision attempted on federated key with wrong origin", "queryID", ex.FederationQueryID, "proposedQueryID", in.FederationQueryID)
-							delete(incomingMap, k)
-							continue
-						}
+			// For federation. If the exposure is inbound on the same query, it is allowed.
+			if req.RequireQueryID {
+				if in, ok := incomingMap[k]; ok {
+					if in.FederationQueryID != ex.FederationQueryID {
+						logger.Warnw("key revision attempted on federated key with wrong origin", "queryID", ex.FederationQueryID, "proposedQueryID", in.FederationQueryID)
+						delete(incomingMap, k)
+						continue
 					}
 				}
-				// For export file based federation. Revisions must come from the same export lineage.
-				if req.RequireExportImportID {
-					if in, ok := incomingMap[k]; ok {
-						inID := in.ExportImportID
-						pID := ex.ExportImportID
-						if inID == nil || pID == nil || *inID != *pID {
-							logger.Warnw("key revision attempted on import with wrong origin", "exportImportID", ex.ExportImportID, "proposedexportImportID", in.ExportImportID)
-							delete(incomingMap, k)
-							continue
-						}
+			}
+			// For export file based federation. Revisions must come from the same export lineage.
+			if req.RequireExportImportID {
+				if in, ok := incomingMap[k]; ok {
+					inID := in.ExportImportID
+					pID := ex.ExportImportID
+					if inID == nil || pID == nil || *inID != *pID {
+						logger.Warnw("key revision attempted on import with wrong origin", "exportImportID", ex.ExportImportID, "proposedexportImportID", in.ExportImportID)
+						delete(incomingMap, k)
+						continue
 					}
 				}
+			}
 
-				// Check the incoming values first.
-				if in, ok := incomingMap[k]; ok {
-					if ex.IntervalNumber != in.IntervalNumber || ex.IntervalCount != in.IntervalCount {
-						logger.Errorw("incoming metadata mismatch",
-							"existing_count", ex.IntervalCount,
-							"existing_number", ex.IntervalNumber,
-							"incoming_count", in.IntervalCount,
-							"incoming_number", in.IntervalNumber)
-						if req.RequireToken {
-							return ErrIncomingMetadataMismatch
-						}
+			// Check the incoming values first.
+			if in, ok := incomingMap[k]; ok {
+				if ex.IntervalNumber != in.IntervalNumber || ex.IntervalCount != in.IntervalCount {
+					logger.Errorw("incoming metadata mismatch",
+						"existing_count", ex.IntervalCount,
+						"existing_number", ex.IntervalNumber,
+						"incoming_count", in.IntervalCount,
+						"incoming_number", in.IntervalNumber)
+					if req.RequireToken {
+						return ErrIncomingMetadataMismatch
 					}
 				}
+			}
 
-				// Now check against allowed revisions.
-				if rk, ok := allowedRevisions[k]; ok {
-					if ex.IntervalNumber != rk.IntervalNumber || ex.IntervalCount != rk.IntervalCount {
-						logger.Errorw("token metadata mismatch",
-							"existing_count", ex.IntervalCount,
-							"existing_number", ex.IntervalNumber,
-							"incoming_count", rk.IntervalCount,
-							"incoming_number", rk.IntervalNumber)
-						if req.RequireToken {
-							return ErrRevisionTokenMetadataMismatch
-						}
-					}
-				} else {
-					// The user sent an existing key for which they do not have a revision
-					// token. There's a plausible scenario with roaming where this user
-					// has changed apps in the middle of a roaming period, and thus this
-					// could be legitimate.
-					//
-					// Suppose a user was in California, using the California app. They
-					// feel ill, do a video call and get a "likely" diagnosis. They decide
-					// to drive to Montana to quarantine, downloading the Montana app.
-					// Once in Montanan, their symptoms worsen and they get a clinical
-					// diagnosis as "positive", marking as such in the app. Since the
-					// Montana app does not have a revision token for the keys that were
-					// previously uploaded when the user was in California, some of the
-					// keys cannot be revised.
+			// Now check against allowed revisions.
+			if rk, ok := allowedRevisions[k]; ok {
+				if ex.IntervalNumber != rk.IntervalNumber || ex.IntervalCount != rk.IntervalCount {
+					logger.Errorw("token metadata mismatch",
+						"existing_count", ex.IntervalCount,
+						"existing_number", ex.IntervalNumber,
+						"incoming_count", rk.IntervalCount,
+						"incoming_number", rk.IntervalNumber)
 					if req.RequireToken {
-						if req.AllowPartialRevisions {
-							logger.Warnw("skipping key: not in revision token, but " +
-								"partial revision is permitted")
-							delete(incomingMap, k)
-						} else {
-							logger.Errorw("cannot revise key: not in revision token")
-							return ErrExistingKeyNotInToken
-						}
+						return ErrRevisionTokenMetadataMismatch
+					}
+				}
+			} else {
+				// The user sent an existing key for which they do not have a revision
+				// token. There's a plausible scenario with roaming where this user
+				// has changed apps in the middle of a roaming period, and thus this
+				// could be legitimate.
+				//
+				// Suppose a user was in California, using the California app. They
+				// feel ill, do a video call and get a "likely" diagnosis. They decide
+				// to drive to Montana to quarantine, downloading the Montana app.
+				// Once in Montanan, their symptoms worsen and they get a clinical
+				// diagnosis as "positive", marking as such in the app. Since the
+				// Montana app does not have a revision token for the keys that were
+				// previously uploaded when the user was in California, some of the
+				// keys cannot be revised.
+				if req.RequireToken {
+					if req.AllowPartialRevisions {
+						logger.Warnw("skipping key: not in revision token, but " +
+							"partial revision is permitted")
+						delete(incomingMap, k)
+					} else {
+						logger.Errorw("cannot revise key: not in revision token")
+						return ErrExistingKeyNotInToken
 					}
 				}
 			}
 		}
+	}
 
-		// Calculate the number of dropped responses.
-		resp.Dropped = uint32(len(req.Incoming) - len(incomingMap))
+	// Calculate the number of dropped responses.
+	resp.Dropped = uint32(len(req.Incoming) - len(incomingMap))
 
-		// If we got this far, the revision token is valid for this request, not
-		// required, or bypassed. It's possible that keys were given, but none of
-		// those keys matched the revision token keys and partial responses were
-		// allowed. In that case, stop here.
-		if len(incomingMap) == 0 {
-			return nil
-		}
+	// If we got this far, the revision token is valid for this request, not
+	// required, or bypassed. It's possible that keys were given, but none of
+	// those keys matched the revision token keys and partial responses were
+	// allowed. In that case, stop here.
+	if len(incomingMap) == 0 {
+		return nil
+	}
 
-		// Build the true incoming list from the map. It's possible items were removed
-		// from the map if they did not exist in the revision token and partial
-		// responses were allowed.
-		incoming := make([]*model.Exposure, 0, len(incomingMap))
-		for _, v := range incomingMap {
-			incoming = append(incoming, v)
-		}
+	// Build the true incoming list from the map. It's possible items were removed
+	// from the map if they did not exist in the revision token and partial
+	// responses were allowed.
+	incoming := make([]*model.Exposure, 0, len(incomingMap))
+	for _, v := range incomingMap {
+		incoming = append(incoming, v)
+	}
 
-		// Run through the merge logic.
-		exposures, err := model.ReviseKeys(ctx, existing, incoming)
-		if err != nil {
-			return fmt.Errorf("unable to revise keys: %w", err)
-		}
-		resp.Exposures = exposures
+	// Run through the merge logic.
+	reviseKeys := model.ReviseKeys
+	if req.KeepHighestReportType {
+		reviseKeys = model.ReviseKeysHighestAuthority
+	}
+	exposures, err := reviseKeys(ctx, existing, incoming)
+	if err != nil {
+		return fmt.Errorf("unable to revise keys: %w", err)
+	}
+	resp.Exposures = exposures
 
-		// Prepare the insert and update statements.
-		insertStmt, err := prepareInsertExposure(ctx, tx)
-		if err != nil {
-			return fmt.Errorf("preparing insert statement: %w", err)
-		}
-		updateStmt, err := prepareReviseExposure(ctx, tx)
-		if err != nil {
-			return fmt.Errorf("preparing update statement: %w", err)
-		}
+	// Hold the exposures for review instead, now that they are validated.
+	if req.Quarantine != nil {
+		return quarantineExposures(ctx, tx, req.Quarantine, incoming, exposures, resp)
+	}
 
-		// only possible if all passed in keys are already existing and not revisions.
-		if len(exposures) == 0 {
-			return nil
-		}
+	// Prepare the insert and update statements.
+	insertStmt, err := prepareInsertExposure(ctx, tx)
+	if err != nil {
+		return fmt.Errorf("preparing insert statement: %w", err)
+	}
+	updateStmt, err := prepareReviseExposure(ctx, tx)
+	if err != nil {
+		return fmt.Errorf("preparing update statement: %w", err)
+	}
 
-		healthAuthorityID := exposures[0].HealthAuthorityID
-		for _, exp := range exposures {
-			if exp.RevisedAt == nil {
-				if exp.ReportType == verifyapi.ReportTypeNegative {
-					continue
-				}
-				if err := executeInsertExposure(ctx, tx, insertStmt, exp); err != nil {
-					return err
-				}
-				resp.Inserted++
-			} else {
-				if err := executeReviseExposure(ctx, tx, updateStmt, exp); err != nil {
-					return err
+	// only possible if all passed in keys are already existing and not revisions.
+	if len(exposures) == 0 {
+		return nil
+	}
+
+	batchSize := req.InsertBatchSize
+	if batchSize > InsertExposuresBatchSize {
+		batchSize = InsertExposuresBatchSize
+	}
+	var pending []*model.Exposure
+
+	healthAuthorityID := exposures[0].HealthAuthorityID
+	for _, exp := range exposures {
+		if exp.RevisedAt == nil {
+			if exp.ReportType == verifyapi.ReportTypeNegative {
+				continue
+			}
+			if batchSize > 0 {
+				pending = append(pending, exp)
+				if len(pending) >= batchSize {
+					if err := executeInsertExposures(ctx, tx, pending); err != nil {
+						return err
+					}
+					pending = pending[:0]
 				}
-				resp.Revised++
+			} else if err := executeInsertExposure(ctx, tx, insertStmt, exp); err != nil {
+				return err
 			}
-
-			if (healthAuthorityID == nil && exp.HealthAuthorityID != nil) ||
-				(healthAuthorityID != nil && exp.HealthAuthorityID != nil && *healthAuthorityID != *exp.HealthAuthorityID) {
-				return fmt.Errorf("more than one health authority present in publish")
+			resp.Inserted++
+		} else {
+			if err := executeReviseExposure(ctx, tx, updateStmt, exp); err != nil {
+				return err
 			}
+			resp.Revised++
 		}
 
-		// If requested, update the publish stats for the associated health authority.
-		if stats != nil && healthAuthorityID != nil {
-			// For all practical purposes - this can be no more than a couple hundred TEKs in a single transaction.
-			stats.NumTEKs = int32(resp.Inserted) + int32(resp.Revised)
-			stats.Revision = resp.Revised > 0
-			go func() {
-				if err := db.UpdateStats(context.Background(), stats.CreatedAt, *healthAuthorityID, stats); err != nil {
-					logger.Errorw("failed to update statistics", "error", err)
-				}
-			}()
+		if (healthAuthorityID == nil && exp.HealthAuthorityID != nil) ||
+			(healthAuthorityID != nil && exp.HealthAuthorityID != nil && *healthAuthorityID != *exp.HealthAuthorityID) {
+			return fmt.Errorf("more than one health authority present in publish")
 		}
+	}
+	if err := executeInsertExposures(ctx, tx, pending); err != nil {
+		return err
+	}
+
+	// If requested, update the publish stats for the associated health authority.
+	if stats != nil && healthAuthorityID != nil {
+		// For all practical purposes - this can be no more than a couple hundred TEKs in a single transaction.
+		stats.NumTEKs = int32(resp.Inserted) + int32(resp.Revised)
+		stats.Revision = resp.Revised > 0
+		go func() {
+			if err := db.UpdateStats(context.Background(), stats.CreatedAt, *healthAuthorityID, stats); err != nil {
+				logger.Errorw("failed to update statistics", "error", err)
+			}
+		}()
+	}
+
+	return nil
+}
 
+// RevokeExposures marks the provided keys, which are base64 encoded, as revoked
+// so that they are excluded from all future exports. Only keys that were
+// published by the given health authority are revoked. The key is also revised
+// to the negative (revoked) report type so that it cannot be revised again on
+// a subsequent publish. Keys that have already been exported cannot be
+// recalled.
+//
+// Returns the number of keys that were revoked.
+func (db *PublishDB) RevokeExposures(ctx context.Context, healthAuthorityID int64, b64keys []string, revokedAt time.Time) (int64, error) {
+	if len(b64keys) == 0 {
+		return 0, nil
+	}
+
+	var count int64
+	if err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `
+			UPDATE
+				Exposure
+			SET
+				revoked_at = $1, revised_report_type = $2, revised_at = COALESCE(revised_at, $1)
+			WHERE
+				exposure_key = ANY($3) AND health_authority_id = $4 AND revoked_at IS NULL
+			`, revokedAt, verifyapi.ReportTypeNegative, b64keys, healthAuthorityID)
+		if err != nil {
+			return fmt.Errorf("revoking exposures: %w", err)
+		}
+		count = result.RowsAffected()
 		return nil
 	}); err != nil {
-		return nil, err
+		return 0, err
 	}
-
-	return &resp, nil
+	return count, nil
 }
 
 // DeleteExposuresBefore deletes exposures created before "before" date. Returns the number of records deleted.
@@ -707,6 +1064,53 @@ func (db *PublishDB) DeleteExposuresBefore(ctx context.Context, before time.Time
 	return count, nil
 }
 
+// DeleteExposuresBatchBefore deletes up to limit of the oldest exposures created
+// before the given time, starting at the exposure cleanup checkpoint, and
+// returns the number deleted. The checkpoint is advanced in the same
+// transaction, so it only moves once the batch commits, and a cleanup that is
+// interrupted resumes from the last committed batch instead of rescanning the
+// table.
+func (db *PublishDB) DeleteExposuresBatchBefore(ctx context.Context, before time.Time, limit int) (int64, error) {
+	var count int64
+	// ReadCommitted is sufficient here because we are dealing with historical, immutable rows.
+	err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		checkpoint, err := getCleanupCheckpoint(ctx, tx, ExposureCleanupCheckpoint)
+		if err != nil {
+			return err
+		}
+
+		// Many exposures share a created_at, so the checkpoint is inclusive.
+		row := tx.QueryRow(ctx, `
+			WITH deleted AS (
+				DELETE FROM
+					Exposure
+				WHERE
+					exposure_key IN (
+						SELECT exposure_key FROM Exposure
+						WHERE created_at >= $1 AND created_at < $2
+						ORDER BY created_at
+						LIMIT $3
+					)
+				RETURNING created_at
+			)
+			SELECT COUNT(*), MAX(created_at) FROM deleted
+			`, checkpoint, before, limit)
+
+		var newest *time.Time
+		if err := row.Scan(&count, &newest); err != nil {
+			return fmt.Errorf("deleting exposures: %w", err)
+		}
+		if newest == nil {
+			return nil
+		}
+		return advanceCleanupCheckpoint(ctx, tx, ExposureCleanupCheckpoint, *newest)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
 func encodeCursor(s string) string {
 	return base64.StdEncoding.EncodeToString([]byte(s))
 }
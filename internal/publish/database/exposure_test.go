@@ -29,7 +29,9 @@ import (
 	"github.com/google/exposure-notifications-server/internal/pb"
 	"github.com/google/exposure-notifications-server/internal/project"
 	"github.com/google/exposure-notifications-server/internal/publish/model"
-	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1alpha1"
+	hadb "github.com/google/exposure-notifications-server/internal/verification/database"
+	hamodel "github.com/google/exposure-notifications-server/internal/verification/model"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
 	"github.com/google/exposure-notifications-server/pkg/database"
 	"github.com/google/exposure-notifications-server/pkg/errcmp"
 	pgx "github.com/jackc/pgx/v4"
@@ -107,6 +109,17 @@ func TestReadExposures(t *testing.T) {
 		t.Errorf("ReadExposures mismatch (-want, +got):\n%s", diff)
 	}
 
+	// LookupExposures reads the same keys outside of a transaction.
+	{
+		lookedUp, err := testPublishDB.LookupExposures(ctx, keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(readBack, lookedUp, database.ApproxTime, ignoreUnexportedExposure); diff != "" {
+			t.Errorf("LookupExposures mismatch (-want, +got):\n%s", diff)
+		}
+	}
+
 	// Test ReadExposures of an empty list.
 	{
 		var keys []string
@@ -247,6 +260,10 @@ func TestExposures(t *testing.T) {
 			IterateExposuresCriteria{OnlyLocalProvenance: true, OnlyTravelers: true},
 			[]int{1},
 		},
+		{
+			IterateExposuresCriteria{OrderByKey: true},
+			[]int{2, 3, 0, 1},
+		},
 	} {
 		got, err := listExposures(ctx, testPublishDB, test.criteria)
 		if err != nil {
@@ -349,6 +366,96 @@ func TestInsertAndReviseExposures_ConfigParadox(t *testing.T) {
 	errcmp.MustMatch(t, err, "configuration paradox")
 }
 
+func TestInsertAndReviseExposures_KeepHighestReportType(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	pubDB := New(testDB)
+
+	cases := []struct {
+		name     string
+		first    string
+		second   string
+		want     string
+		revised  uint32
+		inserted uint32
+	}{
+		{
+			name:    "upgrade",
+			first:   verifyapi.ReportTypeClinical,
+			second:  verifyapi.ReportTypeConfirmed,
+			want:    verifyapi.ReportTypeConfirmed,
+			revised: 1,
+		},
+		{
+			name:   "downgrade",
+			first:  verifyapi.ReportTypeConfirmed,
+			second: verifyapi.ReportTypeClinical,
+			want:   verifyapi.ReportTypeConfirmed,
+		},
+		{
+			name:   "same",
+			first:  verifyapi.ReportTypeClinical,
+			second: verifyapi.ReportTypeClinical,
+			want:   verifyapi.ReportTypeClinical,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			exposure := testExposure(t)
+			exposure.ReportType = tc.first
+			if _, err := pubDB.InsertAndReviseExposures(ctx, &InsertAndReviseExposuresRequest{
+				Incoming: []*model.Exposure{exposure},
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			again := *exposure
+			again.ReportType = tc.second
+			again.CreatedAt = exposure.CreatedAt.Add(time.Hour)
+			resp, err := pubDB.InsertAndReviseExposures(ctx, &InsertAndReviseExposuresRequest{
+				Incoming:              []*model.Exposure{&again},
+				KeepHighestReportType: true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := resp.Revised, tc.revised; got != want {
+				t.Errorf("expected %d revised, got %d", want, got)
+			}
+			if got, want := resp.Inserted, tc.inserted; got != want {
+				t.Errorf("expected %d inserted, got %d", want, got)
+			}
+
+			var got *model.Exposure
+			if err := testDB.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+				exposures, err := pubDB.ReadExposures(ctx, tx, []string{exposure.ExposureKeyBase64()})
+				got = exposures[exposure.ExposureKeyBase64()]
+				return err
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if got == nil {
+				t.Fatal("expected exposure to be stored")
+			}
+
+			reportType := got.ReportType
+			if got.RevisedReportType != nil {
+				reportType = *got.RevisedReportType
+			}
+			if reportType != tc.want {
+				t.Errorf("expected stored report type %q to be %q", reportType, tc.want)
+			}
+		})
+	}
+}
+
 func TestReviseExposures(t *testing.T) {
 	t.Parallel()
 
@@ -813,3 +920,880 @@ func TestIterateExposuresCursor(t *testing.T) {
 		t.Fatalf("cursor: got %q, want empty", cursor)
 	}
 }
+
+func TestIterateExposuresExcludeHealthAuthorities(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	testPublishDB := New(testDB)
+
+	testHADB := hadb.New(testDB)
+	haIDs := make([]int64, 0, 3)
+	for _, ha := range []*hamodel.HealthAuthority{
+		{Issuer: "federated", Audience: "federated", Name: "federated"},
+		{Issuer: "no-federate", Audience: "no-federate", Name: "no-federate", NoFederate: true},
+		{Issuer: "excluded", Audience: "excluded", Name: "excluded"},
+	} {
+		if err := testHADB.AddHealthAuthority(ctx, ha); err != nil {
+			t.Fatalf("unable to create health authority: %v", err)
+		}
+		haIDs = append(haIDs, ha.ID)
+	}
+
+	createdAt := time.Now().UTC().Truncate(time.Hour)
+	exposures := []*model.Exposure{
+		{
+			ExposureKey:    randomTEK(t),
+			Regions:        []string{"US"},
+			IntervalNumber: 100,
+			IntervalCount:  144,
+			CreatedAt:      createdAt,
+		},
+	}
+	for i := range haIDs {
+		exposures = append(exposures, &model.Exposure{
+			ExposureKey:       randomTEK(t),
+			Regions:           []string{"US"},
+			IntervalNumber:    100,
+			IntervalCount:     144,
+			CreatedAt:         createdAt,
+			HealthAuthorityID: &haIDs[i],
+		})
+	}
+	if _, err := testPublishDB.InsertAndReviseExposures(ctx, &InsertAndReviseExposuresRequest{
+		Incoming:     exposures,
+		RequireToken: false,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		criteria IterateExposuresCriteria
+		want     []*model.Exposure
+	}{
+		{
+			name:     "no_exclusions",
+			criteria: IterateExposuresCriteria{},
+			want:     exposures,
+		},
+		{
+			name:     "exclude_no_federate",
+			criteria: IterateExposuresCriteria{ExcludeNoFederate: true},
+			want:     []*model.Exposure{exposures[0], exposures[1], exposures[3]},
+		},
+		{
+			name:     "exclude_issuers",
+			criteria: IterateExposuresCriteria{ExcludeHealthAuthorityIssuers: []string{"excluded"}},
+			want:     []*model.Exposure{exposures[0], exposures[1], exposures[2]},
+		},
+		{
+			name: "exclude_both",
+			criteria: IterateExposuresCriteria{
+				ExcludeNoFederate:             true,
+				ExcludeHealthAuthorityIssuers: []string{"excluded"},
+			},
+			want: []*model.Exposure{exposures[0], exposures[1]},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := make(map[string]struct{})
+			if _, err := testPublishDB.IterateExposures(ctx, tc.criteria, func(e *model.Exposure) error {
+				got[e.ExposureKeyBase64()] = struct{}{}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			want := make(map[string]struct{}, len(tc.want))
+			for _, e := range tc.want {
+				want[e.ExposureKeyBase64()] = struct{}{}
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestIterateExposuresMissingRegions(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	testPublishDB := New(testDB)
+
+	createdAt := time.Now().UTC().Truncate(time.Hour)
+	exposures := make([]*model.Exposure, 0, 3)
+	for _, regions := range [][]string{{"US"}, {"CA"}, nil} {
+		exposures = append(exposures, &model.Exposure{
+			ExposureKey:    randomTEK(t),
+			Regions:        regions,
+			IntervalNumber: 100,
+			IntervalCount:  144,
+			CreatedAt:      createdAt,
+		})
+	}
+	if _, err := testPublishDB.InsertAndReviseExposures(ctx, &InsertAndReviseExposuresRequest{
+		Incoming:     exposures,
+		RequireToken: false,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		criteria IterateExposuresCriteria
+		want     []*model.Exposure
+	}{
+		{
+			name:     "regions_only",
+			criteria: IterateExposuresCriteria{IncludeRegions: []string{"US"}},
+			want:     []*model.Exposure{exposures[0]},
+		},
+		{
+			name: "include_missing",
+			criteria: IterateExposuresCriteria{
+				IncludeRegions:        []string{"US"},
+				IncludeMissingRegions: true,
+			},
+			want: []*model.Exposure{exposures[0], exposures[2]},
+		},
+		{
+			name: "include_missing_with_exclude",
+			criteria: IterateExposuresCriteria{
+				IncludeRegions:        []string{"US"},
+				ExcludeRegions:        []string{"CA"},
+				IncludeMissingRegions: true,
+			},
+			want: []*model.Exposure{exposures[0], exposures[2]},
+		},
+		{
+			name: "include_missing_with_travelers",
+			criteria: IterateExposuresCriteria{
+				IncludeRegions:        []string{"US"},
+				IncludeTravelers:      true,
+				IncludeMissingRegions: true,
+			},
+			want: []*model.Exposure{exposures[0], exposures[2]},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := make(map[string]struct{})
+			if _, err := testPublishDB.IterateExposures(ctx, tc.criteria, func(e *model.Exposure) error {
+				got[e.ExposureKeyBase64()] = struct{}{}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			want := make(map[string]struct{}, len(tc.want))
+			for _, e := range tc.want {
+				want[e.ExposureKeyBase64()] = struct{}{}
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestIterateExposuresOnsetWindow(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	testPublishDB := New(testDB)
+
+	// All keys are for the same day, 10 days before the reference time, and
+	// differ in their symptom onset.
+	reference := time.Date(2021, 3, 15, 12, 0, 0, 0, time.UTC)
+	intervalNumber := model.IntervalNumber(reference.Add(-10 * 24 * time.Hour).Truncate(24 * time.Hour))
+	createdAt := reference.Add(-time.Hour).Truncate(time.Hour)
+
+	makeExposure := func(daysSinceOnset *int32) *model.Exposure {
+		return &model.Exposure{
+			ExposureKey:           randomTEK(t),
+			Regions:               []string{"US"},
+			IntervalNumber:        intervalNumber,
+			IntervalCount:         144,
+			CreatedAt:             createdAt,
+			DaysSinceSymptomOnset: daysSinceOnset,
+		}
+	}
+	days := func(d int32) *int32 { return &d }
+	bound := func(d int) *int { return &d }
+
+	onset10 := makeExposure(days(0))        // onset 10 days before
+	onset14 := makeExposure(days(4))        // onset 14 days before
+	onset15 := makeExposure(days(5))        // onset 15 days before
+	onset5 := makeExposure(days(-5))        // onset 5 days before
+	revisedOnset20 := makeExposure(days(0)) // revised below to onset 20 days before
+	noOnset := makeExposure(nil)
+	exposures := []*model.Exposure{onset10, onset14, onset15, onset5, revisedOnset20, noOnset}
+
+	if _, err := testPublishDB.InsertAndReviseExposures(ctx, &InsertAndReviseExposuresRequest{
+		Incoming:     exposures,
+		RequireToken: false,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The revised onset takes precedence over the original onset.
+	if err := testDB.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			UPDATE Exposure
+			SET revised_days_since_symptom_onset = $1, revised_at = $2
+			WHERE exposure_key = $3
+		`, 10, createdAt, revisedOnset20.ExposureKeyBase64())
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		window *OnsetWindow
+		want   []*model.Exposure
+	}{
+		{
+			name:   "no_window",
+			window: nil,
+			want:   exposures,
+		},
+		{
+			name:   "bounded",
+			window: &OnsetWindow{Reference: reference, MinDays: bound(7), MaxDays: bound(14)},
+			want:   []*model.Exposure{onset10, onset14, noOnset},
+		},
+		{
+			name:   "bounded_exclude_missing",
+			window: &OnsetWindow{Reference: reference, MinDays: bound(7), MaxDays: bound(14), ExcludeMissing: true},
+			want:   []*model.Exposure{onset10, onset14},
+		},
+		{
+			name:   "max_only",
+			window: &OnsetWindow{Reference: reference, MaxDays: bound(14)},
+			want:   []*model.Exposure{onset10, onset14, onset5, noOnset},
+		},
+		{
+			name:   "min_only",
+			window: &OnsetWindow{Reference: reference, MinDays: bound(15)},
+			want:   []*model.Exposure{onset15, revisedOnset20, noOnset},
+		},
+		{
+			name:   "exclude_missing_only",
+			window: &OnsetWindow{Reference: reference, ExcludeMissing: true},
+			want:   []*model.Exposure{onset10, onset14, onset15, onset5, revisedOnset20},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := make(map[string]struct{})
+			if _, err := testPublishDB.IterateExposures(ctx, IterateExposuresCriteria{OnsetWindow: tc.window}, func(e *model.Exposure) error {
+				got[e.ExposureKeyBase64()] = struct{}{}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			want := make(map[string]struct{}, len(tc.want))
+			for _, e := range tc.want {
+				want[e.ExposureKeyBase64()] = struct{}{}
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestIterateExposuresTransmissionRisk(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	testPublishDB := New(testDB)
+
+	createdAt := time.Now().UTC().Add(-time.Hour).Truncate(time.Hour)
+	makeExposure := func(tr int) *model.Exposure {
+		return &model.Exposure{
+			ExposureKey:      randomTEK(t),
+			Regions:          []string{"US"},
+			IntervalNumber:   100,
+			IntervalCount:    144,
+			CreatedAt:        createdAt,
+			TransmissionRisk: tr,
+		}
+	}
+
+	unknown := makeExposure(0)
+	low := makeExposure(2)
+	threshold := makeExposure(4)
+	high := makeExposure(6)
+	revisedLow := makeExposure(6) // the revised transmission risk is lowered below
+	exposures := []*model.Exposure{unknown, low, threshold, high, revisedLow}
+
+	if _, err := testPublishDB.InsertAndReviseExposures(ctx, &InsertAndReviseExposuresRequest{
+		Incoming:     exposures,
+		RequireToken: false,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The original transmission risk is exported, so it is the one filtered on.
+	if err := testDB.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			UPDATE Exposure
+			SET revised_transmission_risk = $1, revised_at = $2
+			WHERE exposure_key = $3
+		`, 1, createdAt, revisedLow.ExposureKeyBase64())
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		filter *TransmissionRiskFilter
+		want   []*model.Exposure
+	}{
+		{
+			name: "no_filter",
+			want: exposures,
+		},
+		{
+			name:   "min",
+			filter: &TransmissionRiskFilter{Min: 4},
+			want:   []*model.Exposure{threshold, high, revisedLow},
+		},
+		{
+			name:   "min_include_unknown",
+			filter: &TransmissionRiskFilter{Min: 4, IncludeUnknown: true},
+			want:   []*model.Exposure{unknown, threshold, high, revisedLow},
+		},
+		{
+			name:   "max",
+			filter: &TransmissionRiskFilter{Min: 8},
+			want:   []*model.Exposure{},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := make(map[string]struct{})
+			if _, err := testPublishDB.IterateExposures(ctx, IterateExposuresCriteria{TransmissionRisk: tc.filter}, func(e *model.Exposure) error {
+				got[e.ExposureKeyBase64()] = struct{}{}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			want := make(map[string]struct{}, len(tc.want))
+			for _, e := range tc.want {
+				want[e.ExposureKeyBase64()] = struct{}{}
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestIterateExposuresReportTypes(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	testPublishDB := New(testDB)
+
+	createdAt := time.Now().UTC().Add(-time.Hour).Truncate(time.Hour)
+	makeExposure := func(reportType string) *model.Exposure {
+		return &model.Exposure{
+			ExposureKey:    randomTEK(t),
+			Regions:        []string{"US"},
+			IntervalNumber: 100,
+			IntervalCount:  144,
+			CreatedAt:      createdAt,
+			ReportType:     reportType,
+		}
+	}
+
+	unknown := makeExposure("")
+	confirmed := makeExposure(verifyapi.ReportTypeConfirmed)
+	likely := makeExposure(verifyapi.ReportTypeClinical)
+	selfReport := makeExposure(verifyapi.ReportTypeSelfReport)
+	revisedConfirmed := makeExposure(verifyapi.ReportTypeClinical) // revised to confirmed below
+	revisedNegative := makeExposure(verifyapi.ReportTypeConfirmed) // revised to negative below
+	exposures := []*model.Exposure{unknown, confirmed, likely, selfReport, revisedConfirmed, revisedNegative}
+
+	if _, err := testPublishDB.InsertAndReviseExposures(ctx, &InsertAndReviseExposuresRequest{
+		Incoming:     exposures,
+		RequireToken: false,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := testDB.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		for key, reportType := range map[string]string{
+			revisedConfirmed.ExposureKeyBase64(): verifyapi.ReportTypeConfirmed,
+			revisedNegative.ExposureKeyBase64():  verifyapi.ReportTypeNegative,
+		} {
+			if _, err := tx.Exec(ctx, `
+				UPDATE Exposure
+				SET revised_report_type = $1, revised_at = $2
+				WHERE exposure_key = $3
+			`, reportType, createdAt, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name        string
+		filter      *ReportTypeFilter
+		onlyRevised bool
+		want        []*model.Exposure
+	}{
+		{
+			name: "no_filter",
+			want: exposures,
+		},
+		{
+			name:   "confirmed",
+			filter: &ReportTypeFilter{Allowed: []string{verifyapi.ReportTypeConfirmed}},
+			want:   []*model.Exposure{confirmed, revisedNegative},
+		},
+		{
+			name:   "confirmed_include_unknown",
+			filter: &ReportTypeFilter{Allowed: []string{verifyapi.ReportTypeConfirmed}, IncludeUnknown: true},
+			want:   []*model.Exposure{unknown, confirmed, revisedNegative},
+		},
+		{
+			name:   "confirmed_and_likely",
+			filter: &ReportTypeFilter{Allowed: []string{verifyapi.ReportTypeConfirmed, verifyapi.ReportTypeClinical}},
+			want:   []*model.Exposure{confirmed, likely, revisedConfirmed, revisedNegative},
+		},
+		{
+			name:        "revised_confirmed",
+			filter:      &ReportTypeFilter{Allowed: []string{verifyapi.ReportTypeConfirmed}},
+			onlyRevised: true,
+			want:        []*model.Exposure{revisedConfirmed, revisedNegative},
+		},
+		{
+			name:        "revised_self_report",
+			filter:      &ReportTypeFilter{Allowed: []string{verifyapi.ReportTypeSelfReport}},
+			onlyRevised: true,
+			want:        []*model.Exposure{},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			criteria := IterateExposuresCriteria{ReportTypes: tc.filter, OnlyRevisedKeys: tc.onlyRevised}
+			got := make(map[string]struct{})
+			if _, err := testPublishDB.IterateExposures(ctx, criteria, func(e *model.Exposure) error {
+				got[e.ExposureKeyBase64()] = struct{}{}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			want := make(map[string]struct{}, len(tc.want))
+			for _, e := range tc.want {
+				want[e.ExposureKeyBase64()] = struct{}{}
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRevokeExposures(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	testPublishDB := New(testDB)
+
+	testHADB := hadb.New(testDB)
+	haIDs := make([]int64, 0, 2)
+	for _, name := range []string{"a", "b"} {
+		healthAuthority := &hamodel.HealthAuthority{
+			Issuer:   name,
+			Audience: name,
+			Name:     name,
+		}
+		if err := testHADB.AddHealthAuthority(ctx, healthAuthority); err != nil {
+			t.Fatalf("unable to create health authority: %v", err)
+		}
+		haIDs = append(haIDs, healthAuthority.ID)
+	}
+
+	createdAt := time.Now().UTC().Truncate(time.Hour)
+	exposures := []*model.Exposure{
+		{
+			ExposureKey:       randomTEK(t),
+			Regions:           []string{"US"},
+			IntervalNumber:    100,
+			IntervalCount:     144,
+			CreatedAt:         createdAt,
+			HealthAuthorityID: &haIDs[0],
+			ReportType:        verifyapi.ReportTypeConfirmed,
+		},
+		{
+			ExposureKey:       randomTEK(t),
+			Regions:           []string{"US"},
+			IntervalNumber:    244,
+			IntervalCount:     144,
+			CreatedAt:         createdAt,
+			HealthAuthorityID: &haIDs[0],
+			ReportType:        verifyapi.ReportTypeConfirmed,
+		},
+		{
+			ExposureKey:       randomTEK(t),
+			Regions:           []string{"US"},
+			IntervalNumber:    100,
+			IntervalCount:     144,
+			CreatedAt:         createdAt,
+			HealthAuthorityID: &haIDs[1],
+			ReportType:        verifyapi.ReportTypeConfirmed,
+		},
+	}
+	if _, err := testPublishDB.InsertAndReviseExposures(ctx, &InsertAndReviseExposuresRequest{
+		Incoming:     exposures,
+		RequireToken: false,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Attempt to revoke a key from each health authority, only the key that
+	// belongs to the first health authority should be revoked.
+	toRevoke := []string{exposures[0].ExposureKeyBase64(), exposures[2].ExposureKeyBase64()}
+	revokedAt := createdAt.Add(time.Hour)
+	count, err := testPublishDB.RevokeExposures(ctx, haIDs[0], toRevoke, revokedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := count, int64(1); got != want {
+		t.Errorf("expected %d keys to be revoked, got %d", want, got)
+	}
+
+	// Revoking again is a no-op.
+	count, err = testPublishDB.RevokeExposures(ctx, haIDs[0], toRevoke, revokedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("expected no keys to be revoked, got %d", count)
+	}
+
+	// The revoked key is no longer iterated, either as a new key or as a
+	// revision.
+	for _, onlyRevised := range []bool{false, true} {
+		got := make(map[string]struct{})
+		if _, err := testPublishDB.IterateExposures(ctx, IterateExposuresCriteria{OnlyRevisedKeys: onlyRevised}, func(e *model.Exposure) error {
+			got[e.ExposureKeyBase64()] = struct{}{}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		want := map[string]struct{}{}
+		if !onlyRevised {
+			want[exposures[1].ExposureKeyBase64()] = struct{}{}
+			want[exposures[2].ExposureKeyBase64()] = struct{}{}
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("onlyRevised=%t: mismatch (-want, +got):\n%s", onlyRevised, diff)
+		}
+	}
+
+	// The revoked key is marked as revised so it can't be revised again.
+	var got map[string]*model.Exposure
+	if err := testDB.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		var err error
+		got, err = testPublishDB.ReadExposures(ctx, tx, toRevoke)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	revoked := got[exposures[0].ExposureKeyBase64()]
+	if revoked == nil {
+		t.Fatal("revoked key not found")
+	}
+	if revoked.RevisedReportType == nil || *revoked.RevisedReportType != verifyapi.ReportTypeNegative {
+		t.Errorf("expected revised report type %q, got %v", verifyapi.ReportTypeNegative, revoked.RevisedReportType)
+	}
+	if revoked.RevisedAt == nil || !revoked.RevisedAt.Equal(revokedAt) {
+		t.Errorf("expected revised at %v, got %v", revokedAt, revoked.RevisedAt)
+	}
+	if other := got[exposures[2].ExposureKeyBase64()]; other == nil || other.RevisedAt != nil {
+		t.Errorf("expected key from other health authority to be unchanged, got %#v", other)
+	}
+}
+
+func TestDeleteExposuresBatchBefore(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	testPublishDB := New(testDB)
+
+	batchTime := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	var exposures []*model.Exposure
+	for i := 0; i < 5; i++ {
+		exposures = append(exposures, &model.Exposure{
+			ExposureKey:     []byte(fmt.Sprintf("key-%d", i)),
+			Regions:         []string{"US"},
+			IntervalNumber:  int32(100 * i),
+			IntervalCount:   144,
+			CreatedAt:       batchTime.Add(time.Duration(i) * time.Hour),
+			LocalProvenance: true,
+		})
+	}
+	if _, err := testPublishDB.InsertAndReviseExposures(ctx, &InsertAndReviseExposuresRequest{
+		Incoming:     exposures,
+		RequireToken: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	cutoff := exposures[4].CreatedAt
+
+	checkpoint, err := testPublishDB.GetCleanupCheckpoint(ctx, ExposureCleanupCheckpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checkpoint.IsZero() {
+		t.Errorf("expected no checkpoint, got %v", checkpoint)
+	}
+
+	deleteBatch := func(t *testing.T, wantN int64, wantCheckpoint time.Time) {
+		t.Helper()
+
+		gotN, err := testPublishDB.DeleteExposuresBatchBefore(ctx, cutoff, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gotN != wantN {
+			t.Errorf("deleted %d, want %d", gotN, wantN)
+		}
+
+		checkpoint, err := testPublishDB.GetCleanupCheckpoint(ctx, ExposureCleanupCheckpoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !checkpoint.Equal(wantCheckpoint) {
+			t.Errorf("expected checkpoint %v to be %v", checkpoint, wantCheckpoint)
+		}
+	}
+
+	// The oldest two are deleted, and the checkpoint is the newer of them.
+	deleteBatch(t, 2, exposures[1].CreatedAt)
+
+	// A failed batch doesn't move the checkpoint.
+	canceledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := testPublishDB.DeleteExposuresBatchBefore(canceledCtx, cutoff, 2); err == nil {
+		t.Errorf("expected error")
+	}
+	deleteBatch(t, 2, exposures[3].CreatedAt)
+
+	// The remaining exposure is not expired, so the checkpoint stays.
+	deleteBatch(t, 0, exposures[3].CreatedAt)
+
+	got, err := listExposures(ctx, testPublishDB, IterateExposuresCriteria{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(exposures[4:], got, ignoreUnexportedExposure); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestInsertAndReviseExposures_InsertBatchSize(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	// The same keys are published to a database per batch size, the stored
+	// exposures must match those of the per row inserts.
+	keys := make([][]byte, 10)
+	for i := range keys {
+		keys[i] = testRandomTEK(t)
+	}
+	createdAt := time.Now().UTC().Add(-2 * time.Hour).Truncate(time.Hour)
+
+	exposure := func(i int, reportType string, createdAt time.Time) *model.Exposure {
+		exp := testExposure(t)
+		exp.ExposureKey = keys[i]
+		exp.ReportType = reportType
+		exp.CreatedAt = createdAt
+		exp.AppPackageName = "Foo.Bar"
+		return exp
+	}
+
+	publish := func(t *testing.T, pubDB *PublishDB, batchSize int) ([]*InsertAndReviseExposuresResponse, map[string]*model.Exposure) {
+		t.Helper()
+
+		first := make([]*model.Exposure, 0, 5)
+		for i := 0; i < 5; i++ {
+			first = append(first, exposure(i, verifyapi.ReportTypeClinical, createdAt))
+		}
+
+		// Revise two keys, resend one unchanged, insert four new keys and skip a
+		// negative one.
+		later := createdAt.Add(time.Hour)
+		second := []*model.Exposure{
+			exposure(0, verifyapi.ReportTypeConfirmed, later),
+			exposure(1, verifyapi.ReportTypeConfirmed, later),
+			exposure(2, verifyapi.ReportTypeClinical, later),
+			exposure(5, verifyapi.ReportTypeConfirmed, later),
+			exposure(6, verifyapi.ReportTypeConfirmed, later),
+			exposure(7, verifyapi.ReportTypeClinical, later),
+			exposure(8, verifyapi.ReportTypeClinical, later),
+			exposure(9, verifyapi.ReportTypeNegative, later),
+		}
+
+		var responses []*InsertAndReviseExposuresResponse
+		for _, incoming := range [][]*model.Exposure{first, second} {
+			resp, err := pubDB.InsertAndReviseExposures(ctx, &InsertAndReviseExposuresRequest{
+				Incoming:        incoming,
+				InsertBatchSize: batchSize,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Only the counts are compared, the order of the exposures is random.
+			resp.Exposures = nil
+			responses = append(responses, resp)
+		}
+
+		b64keys := make([]string, len(keys))
+		for i, k := range keys {
+			b64keys[i] = encodeExposureKey(k)
+		}
+		stored, err := pubDB.LookupExposures(ctx, b64keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return responses, stored
+	}
+
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	wantResponses, want := publish(t, New(testDB), 0)
+	if got, want := len(want), 9; got != want {
+		t.Fatalf("expected %d stored exposures, got %d", want, got)
+	}
+
+	for _, batchSize := range []int{1, 3, 4, InsertExposuresBatchSize + 1} {
+		batchSize := batchSize
+
+		t.Run(fmt.Sprintf("batch_%d", batchSize), func(t *testing.T) {
+			t.Parallel()
+
+			testDB, _ := testDatabaseInstance.NewDatabase(t)
+			gotResponses, got := publish(t, New(testDB), batchSize)
+
+			if diff := cmp.Diff(wantResponses, gotResponses); diff != "" {
+				t.Errorf("responses mismatch (-want, +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(want, got, ignoreUnexportedExposure); diff != "" {
+				t.Errorf("stored exposures mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestInsertAndReviseExposures_InsertBatchSizeConflict(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	pubDB := New(testDB)
+
+	existing := testExposure(t)
+	incoming := testExposure(t)
+
+	// The key is inserted after the publish read the existing keys, like a
+	// concurrent publish would. The batched insert skips it, like the per row
+	// insert does, and the other key is inserted.
+	if err := testDB.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		if err := executeInsertExposures(ctx, tx, []*model.Exposure{existing}); err != nil {
+			return err
+		}
+		return executeInsertExposures(ctx, tx, []*model.Exposure{existing, incoming})
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := pubDB.LookupExposures(ctx, []string{existing.ExposureKeyBase64(), incoming.ExposureKeyBase64()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(stored), 2; got != want {
+		t.Errorf("expected %d stored exposures, got %d", want, got)
+	}
+}
+
+func BenchmarkInsertAndReviseExposures(b *testing.B) {
+	ctx := context.Background()
+	testDB, _ := testDatabaseInstance.NewDatabase(b)
+	pubDB := New(testDB)
+
+	for _, n := range []int{30, 500} {
+		n := n
+
+		for _, batchSize := range []int{0, 100, InsertExposuresBatchSize} {
+			batchSize := batchSize
+
+			b.Run(fmt.Sprintf("keys_%d_batch_%d", n, batchSize), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					incoming := make([]*model.Exposure, n)
+					for j := range incoming {
+						incoming[j] = testExposure(b)
+					}
+					b.StartTimer()
+
+					if _, err := pubDB.InsertAndReviseExposures(ctx, &InsertAndReviseExposuresRequest{
+						Incoming:        incoming,
+						InsertBatchSize: batchSize,
+					}); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1alpha1"
+	"github.com/google/exposure-notifications-server/pkg/database"
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// ErrQuarantineReviewed is returned when approving or rejecting a quarantined
+// publish that was already reviewed.
+var ErrQuarantineReviewed = errors.New("quarantined publish was already reviewed")
+
+// quarantineExposures saves the incoming exposures whose keys would be
+// inserted or revised, as listed in exposures, in quarantine. They are counted
+// in resp as if they were saved.
+func quarantineExposures(ctx context.Context, tx pgx.Tx, q *model.Quarantine, incoming, exposures []*model.Exposure, resp *InsertAndReviseExposuresResponse) error {
+	incomingMap := make(map[string]*model.Exposure, len(incoming))
+	for _, in := range incoming {
+		incomingMap[in.ExposureKeyBase64()] = in
+	}
+
+	quarantined := make([]*model.Exposure, 0, len(exposures))
+	for _, exp := range exposures {
+		if exp.RevisedAt == nil {
+			if exp.ReportType == verifyapi.ReportTypeNegative {
+				continue
+			}
+			resp.Inserted++
+		} else {
+			resp.Revised++
+		}
+		// Revised exposures are the existing ones, save what was sent instead so
+		// that the revision is applied to the key as it is on approval.
+		quarantined = append(quarantined, incomingMap[exp.ExposureKeyBase64()])
+	}
+	if len(quarantined) == 0 {
+		return nil
+	}
+
+	q.NumKeys = len(quarantined)
+	q.Status = model.QuarantinePending
+
+	row := tx.QueryRow(ctx, `
+		INSERT INTO
+			PublishQuarantine
+			(app_package_name, health_authority_id, regions, reason, num_keys, status, created_at)
+		VALUES
+			(LOWER($1), $2, $3, $4, $5, $6, $7)
+		RETURNING quarantine_id
+		`, q.AppPackageName, q.HealthAuthorityID, q.Regions, q.Reason, q.NumKeys, string(q.Status), q.CreatedAt)
+	if err := row.Scan(&q.ID); err != nil {
+		return fmt.Errorf("inserting quarantine: %w", err)
+	}
+
+	for _, exp := range quarantined {
+		var certificateHash *string
+		if exp.CertificateHash != "" {
+			certificateHash = &exp.CertificateHash
+		}
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO
+				QuarantinedExposure
+				(quarantine_id, exposure_key, transmission_risk, app_package_name, regions, traveler,
+				 interval_number, interval_count, health_authority_id, report_type, days_since_symptom_onset,
+				 symptom_onset_interval, certificate_hash)
+			VALUES
+				($1, $2, $3, LOWER($4), $5, $6, $7, $8, $9, $10, $11, $12, $13)
+			ON CONFLICT DO NOTHING
+			`, q.ID, encodeExposureKey(exp.ExposureKey), exp.TransmissionRisk, exp.AppPackageName, exp.Regions, exp.Traveler,
+			exp.IntervalNumber, exp.IntervalCount, exp.HealthAuthorityID, exp.ReportType, exp.DaysSinceSymptomOnset,
+			exp.SymptomOnsetInterval, certificateHash); err != nil {
+			return fmt.Errorf("inserting quarantined exposure: %w", err)
+		}
+	}
+	return nil
+}
+
+// readQuarantinedExposures returns the exposures of the quarantined publish.
+func readQuarantinedExposures(ctx context.Context, tx pgx.Tx, id int64) ([]*model.Exposure, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT
+			exposure_key, transmission_risk, app_package_name, regions, traveler,
+			interval_number, interval_count, health_authority_id, report_type, days_since_symptom_onset,
+			symptom_onset_interval, certificate_hash
+		FROM
+			QuarantinedExposure
+		WHERE
+			quarantine_id = $1
+		`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list quarantined exposures: %w", err)
+	}
+	defer rows.Close()
+
+	var exposures []*model.Exposure
+	for rows.Next() {
+		var (
+			exposure        model.Exposure
+			encodedKey      string
+			certificateHash sql.NullString
+		)
+		if err := rows.Scan(&encodedKey, &exposure.TransmissionRisk, &exposure.AppPackageName,
+			&exposure.Regions, &exposure.Traveler, &exposure.IntervalNumber, &exposure.IntervalCount,
+			&exposure.HealthAuthorityID, &exposure.ReportType, &exposure.DaysSinceSymptomOnset,
+			&exposure.SymptomOnsetInterval, &certificateHash); err != nil {
+			return nil, fmt.Errorf("failed to parse: %w", err)
+		}
+		exposure.ExposureKey, err = decodeExposureKey(encodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode key: %w", err)
+		}
+		exposure.CertificateHash = certificateHash.String
+		exposures = append(exposures, &exposure)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate: %w", err)
+	}
+	return exposures, nil
+}
+
+// ListQuarantines returns up to limit quarantined publishes with the status,
+// oldest first.
+func (db *PublishDB) ListQuarantines(ctx context.Context, status model.QuarantineStatus, limit int) ([]*model.Quarantine, error) {
+	var quarantines []*model.Quarantine
+	if err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+			SELECT
+				quarantine_id, app_package_name, health_authority_id, regions, reason, num_keys, status,
+				created_at, reviewed_at
+			FROM
+				PublishQuarantine
+			WHERE
+				status = $1
+			ORDER BY
+				created_at, quarantine_id
+			LIMIT $2
+			`, string(status), limit)
+		if err != nil {
+			return fmt.Errorf("failed to list: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			q, err := scanOneQuarantine(rows)
+			if err != nil {
+				return err
+			}
+			quarantines = append(quarantines, q)
+		}
+		return rows.Err()
+	}); err != nil {
+		return nil, fmt.Errorf("list quarantines: %w", err)
+	}
+	return quarantines, nil
+}
+
+// GetQuarantine returns the quarantined publish, or database.ErrNotFound if it
+// doesn't exist.
+func (db *PublishDB) GetQuarantine(ctx context.Context, id int64) (*model.Quarantine, error) {
+	var q *model.Quarantine
+	if err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		var err error
+		q, err = lockQuarantine(ctx, tx, id, false)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("get quarantine: %w", err)
+	}
+	return q, nil
+}
+
+// ApproveQuarantine saves the exposures of a pending quarantined publish, with
+// the given creation time, and marks it approved at reviewedAt. Like on
+// publish, keys that exist are revised. The revision token was checked when the
+// exposures were quarantined. Keys that were published or revised in the
+// meantime to a report type of the same or a higher authority are left
+// unchanged. It returns the number of exposures that were inserted or revised.
+func (db *PublishDB) ApproveQuarantine(ctx context.Context, id int64, createdAt, reviewedAt time.Time) (int64, error) {
+	var count int64
+	if err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		if _, err := lockQuarantine(ctx, tx, id, true); err != nil {
+			return err
+		}
+
+		exposures, err := readQuarantinedExposures(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		for _, exp := range exposures {
+			exp.CreatedAt = createdAt
+			exp.LocalProvenance = true
+		}
+
+		if len(exposures) > 0 {
+			var resp InsertAndReviseExposuresResponse
+			if err := db.insertAndReviseExposures(ctx, tx, &InsertAndReviseExposuresRequest{
+				Incoming:              exposures,
+				KeepHighestReportType: true,
+			}, &resp); err != nil {
+				return fmt.Errorf("saving exposures: %w", err)
+			}
+			count = int64(resp.Inserted + resp.Revised)
+		}
+
+		return reviewQuarantine(ctx, tx, id, model.QuarantineApproved, reviewedAt)
+	}); err != nil {
+		return 0, fmt.Errorf("approve quarantine: %w", err)
+	}
+	return count, nil
+}
+
+// RejectQuarantine deletes the exposures of a pending quarantined publish and
+// marks it rejected at reviewedAt.
+func (db *PublishDB) RejectQuarantine(ctx context.Context, id int64, reviewedAt time.Time) error {
+	if err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		if _, err := lockQuarantine(ctx, tx, id, true); err != nil {
+			return err
+		}
+		return reviewQuarantine(ctx, tx, id, model.QuarantineRejected, reviewedAt)
+	}); err != nil {
+		return fmt.Errorf("reject quarantine: %w", err)
+	}
+	return nil
+}
+
+// lockQuarantine reads the quarantined publish, locking it for the rest of the
+// transaction if forReview is set. A publish that was already reviewed can't
+// be locked for review.
+func lockQuarantine(ctx context.Context, tx pgx.Tx, id int64, forReview bool) (*model.Quarantine, error) {
+	q := `
+		SELECT
+			quarantine_id, app_package_name, health_authority_id, regions, reason, num_keys, status,
+			created_at, reviewed_at
+		FROM
+			PublishQuarantine
+		WHERE
+			quarantine_id = $1
+		`
+	if forReview {
+		q += " FOR UPDATE"
+	}
+
+	quarantine, err := scanOneQuarantine(tx.QueryRow(ctx, q, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, database.ErrNotFound
+		}
+		return nil, err
+	}
+	if forReview && quarantine.Status != model.QuarantinePending {
+		return nil, ErrQuarantineReviewed
+	}
+	return quarantine, nil
+}
+
+// reviewQuarantine removes the quarantined exposures and records the review.
+func reviewQuarantine(ctx context.Context, tx pgx.Tx, id int64, status model.QuarantineStatus, reviewedAt time.Time) error {
+	if _, err := tx.Exec(ctx, `
+		DELETE FROM
+			QuarantinedExposure
+		WHERE
+			quarantine_id = $1
+		`, id); err != nil {
+		return fmt.Errorf("deleting quarantined exposures: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE
+			PublishQuarantine
+		SET
+			status = $2, reviewed_at = $3
+		WHERE
+			quarantine_id = $1
+		`, id, string(status), reviewedAt); err != nil {
+		return fmt.Errorf("updating quarantine: %w", err)
+	}
+	return nil
+}
+
+func scanOneQuarantine(row pgx.Row) (*model.Quarantine, error) {
+	var (
+		q      model.Quarantine
+		status string
+	)
+	if err := row.Scan(&q.ID, &q.AppPackageName, &q.HealthAuthorityID, &q.Regions, &q.Reason, &q.NumKeys, &status,
+		&q.CreatedAt, &q.ReviewedAt); err != nil {
+		return nil, fmt.Errorf("failed to parse: %w", err)
+	}
+	q.Status = model.QuarantineStatus(status)
+	return &q, nil
+}
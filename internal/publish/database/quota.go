@@ -0,0 +1,105 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// quotaDay returns the UTC day of t, which counters are kept for.
+func quotaDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+// DailyKeyCount returns the number of keys the health authority published on
+// the UTC day of t. Each day starts with a count of 0.
+func (db *PublishDB) DailyKeyCount(ctx context.Context, healthAuthorityID int64, t time.Time) (int64, error) {
+	var count int64
+	if err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		row := tx.QueryRow(ctx, `
+			SELECT
+				keys
+			FROM
+				PublishQuota
+			WHERE
+				health_authority_id = $1 AND day = $2
+			`, healthAuthorityID, quotaDay(t))
+
+		if err := row.Scan(&count); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				count = 0
+				return nil
+			}
+			return fmt.Errorf("reading daily key count: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// IncrementDailyKeyCount adds n keys to the count of the health authority for
+// the UTC day of t, and returns the new count.
+func (db *PublishDB) IncrementDailyKeyCount(ctx context.Context, healthAuthorityID int64, t time.Time, n int64) (int64, error) {
+	var count int64
+	if err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		row := tx.QueryRow(ctx, `
+			INSERT INTO
+				PublishQuota (health_authority_id, day, keys)
+			VALUES
+				($1, $2, $3)
+			ON CONFLICT (health_authority_id, day) DO UPDATE SET
+				keys = PublishQuota.keys + EXCLUDED.keys
+			RETURNING keys
+			`, healthAuthorityID, quotaDay(t), n)
+
+		if err := row.Scan(&count); err != nil {
+			return fmt.Errorf("incrementing daily key count: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// DeleteDailyKeyCountsBefore deletes the counters for the UTC days before the
+// day of t. Those counters are never read again. Returns the number of records
+// deleted.
+func (db *PublishDB) DeleteDailyKeyCountsBefore(ctx context.Context, t time.Time) (int64, error) {
+	var count int64
+	if err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `
+			DELETE FROM
+				PublishQuota
+			WHERE
+				day < $1
+			`, quotaDay(t))
+		if err != nil {
+			return fmt.Errorf("deleting daily key counts: %w", err)
+		}
+		count = result.RowsAffected()
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/project"
+)
+
+func TestDailyKeyCount(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	testPublishDB := New(testDB)
+
+	day := time.Date(2021, 6, 1, 9, 30, 0, 0, time.UTC)
+
+	count, err := testPublishDB.DailyKeyCount(ctx, 1, day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("expected no keys, got %d", count)
+	}
+
+	for _, n := range []int64{10, 4} {
+		if _, err := testPublishDB.IncrementDailyKeyCount(ctx, 1, day, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Other health authorities have their own counter.
+	if _, err := testPublishDB.IncrementDailyKeyCount(ctx, 2, day, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	// Any time during the same UTC day uses the same counter.
+	count, err = testPublishDB.DailyKeyCount(ctx, 1, day.Add(14*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := count, int64(14); got != want {
+		t.Errorf("expected %d keys, got %d", want, got)
+	}
+
+	// The counter resets on the next day.
+	nextDay := day.Add(24 * time.Hour)
+	count, err = testPublishDB.DailyKeyCount(ctx, 1, nextDay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("expected the next day to start with no keys, got %d", count)
+	}
+	count, err = testPublishDB.IncrementDailyKeyCount(ctx, 1, nextDay, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := count, int64(3); got != want {
+		t.Errorf("expected %d keys, got %d", want, got)
+	}
+}
+
+func TestDeleteDailyKeyCountsBefore(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	testPublishDB := New(testDB)
+
+	today := time.Date(2021, 6, 3, 9, 30, 0, 0, time.UTC)
+	for _, day := range []time.Time{today.Add(-48 * time.Hour), today.Add(-24 * time.Hour), today} {
+		if _, err := testPublishDB.IncrementDailyKeyCount(ctx, 1, day, 5); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, err := testPublishDB.DeleteDailyKeyCountsBefore(ctx, today)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := count, int64(2); got != want {
+		t.Errorf("expected %d counters to be deleted, got %d", want, got)
+	}
+
+	// The counter for the current day is kept.
+	count, err = testPublishDB.DailyKeyCount(ctx, 1, today)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := count, int64(5); got != want {
+		t.Errorf("expected %d keys, got %d", want, got)
+	}
+}
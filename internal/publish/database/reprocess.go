@@ -0,0 +1,187 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/pkg/logging"
+
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// DefaultReprocessBatchSize is the number of exposures that are examined in a
+// single transaction when reprocessing.
+const DefaultReprocessBatchSize = 500
+
+// ReprocessSymptomOnsetRequest is the input to ReprocessDaysSinceSymptomOnset.
+type ReprocessSymptomOnsetRequest struct {
+	// SinceTimestamp and UntilTimestamp bound the created_at time of the
+	// exposures to reprocess, [since, until).
+	SinceTimestamp time.Time
+	UntilTimestamp time.Time
+
+	// BatchSize is the maximum number of exposures examined per transaction.
+	// If <= 0, DefaultReprocessBatchSize is used.
+	BatchSize int
+}
+
+// ReprocessSymptomOnsetResponse contains the counts from a reprocess run.
+type ReprocessSymptomOnsetResponse struct {
+	// Examined is the number of exposures that had a stored symptom onset
+	// interval and were considered.
+	Examined int64
+	// Updated is the number of exposures whose days since symptom onset was
+	// changed.
+	Updated int64
+	// Skipped is the number of exposures in the time range that were left
+	// unchanged because they have no stored symptom onset interval.
+	Skipped int64
+}
+
+// ReprocessDaysSinceSymptomOnset re-derives the days_since_symptom_onset
+// column from the stored symptom onset interval for exposures created in the
+// requested time range. Exposures without a stored symptom onset interval
+// (those published before it was recorded, and federated keys) are not
+// modified, they are only counted as skipped. No other columns
+// are changed and nothing is re-exported. The operation is idempotent, running
+// it a second time over the same range updates no rows.
+func (db *PublishDB) ReprocessDaysSinceSymptomOnset(ctx context.Context, req *ReprocessSymptomOnsetRequest) (*ReprocessSymptomOnsetResponse, error) {
+	logger := logging.FromContext(ctx).Named("ReprocessDaysSinceSymptomOnset")
+
+	if req == nil {
+		return nil, fmt.Errorf("missing request")
+	}
+	if req.SinceTimestamp.IsZero() || req.UntilTimestamp.IsZero() {
+		return nil, fmt.Errorf("since and until timestamps are required")
+	}
+	if !req.UntilTimestamp.After(req.SinceTimestamp) {
+		return nil, fmt.Errorf("until timestamp must be after since timestamp")
+	}
+
+	batchSize := req.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultReprocessBatchSize
+	}
+
+	var resp ReprocessSymptomOnsetResponse
+	lastKey := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return &resp, err
+		}
+
+		var examined, updated int64
+		if err := db.db.InTx(ctx, pgx.RepeatableRead, func(tx pgx.Tx) error {
+			examined, updated = 0, 0
+
+			rows, err := tx.Query(ctx, `
+				SELECT
+					exposure_key, interval_number, symptom_onset_interval, days_since_symptom_onset
+				FROM
+					Exposure
+				WHERE
+					created_at >= $1 AND created_at < $2 AND
+					symptom_onset_interval IS NOT NULL AND
+					exposure_key > $3
+				ORDER BY exposure_key
+				LIMIT $4
+				FOR UPDATE
+			`, req.SinceTimestamp, req.UntilTimestamp, lastKey, batchSize)
+			if err != nil {
+				return fmt.Errorf("failed to select exposures: %w", err)
+			}
+
+			type correction struct {
+				key  string
+				days int32
+			}
+			var corrections []*correction
+			for rows.Next() {
+				var key string
+				var intervalNumber, onsetInterval int32
+				var daysSinceOnset *int32
+				if err := rows.Scan(&key, &intervalNumber, &onsetInterval, &daysSinceOnset); err != nil {
+					rows.Close()
+					return fmt.Errorf("failed to parse: %w", err)
+				}
+				examined++
+				lastKey = key
+
+				want := model.DaysBetweenIntervals(onsetInterval, intervalNumber)
+				if daysSinceOnset != nil && *daysSinceOnset == want {
+					continue
+				}
+				corrections = append(corrections, &correction{key: key, days: want})
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return fmt.Errorf("failed to iterate: %w", err)
+			}
+
+			for _, c := range corrections {
+				result, err := tx.Exec(ctx, `
+					UPDATE
+						Exposure
+					SET
+						days_since_symptom_onset = $1
+					WHERE
+						exposure_key = $2
+				`, c.days, c.key)
+				if err != nil {
+					return fmt.Errorf("failed to update exposure: %w", err)
+				}
+				updated += result.RowsAffected()
+			}
+			return nil
+		}); err != nil {
+			return &resp, fmt.Errorf("reprocessing exposures: %w", err)
+		}
+
+		resp.Examined += examined
+		resp.Updated += updated
+		logger.Debugw("reprocessed batch", "examined", examined, "updated", updated)
+
+		if examined < int64(batchSize) {
+			break
+		}
+	}
+
+	if err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		row := tx.QueryRow(ctx, `
+			SELECT
+				COUNT(*)
+			FROM
+				Exposure
+			WHERE
+				created_at >= $1 AND created_at < $2 AND
+				symptom_onset_interval IS NULL
+		`, req.SinceTimestamp, req.UntilTimestamp)
+		return row.Scan(&resp.Skipped)
+	}); err != nil {
+		return &resp, fmt.Errorf("counting skipped exposures: %w", err)
+	}
+
+	logger.Infow("reprocessed days since symptom onset",
+		"since", req.SinceTimestamp,
+		"until", req.UntilTimestamp,
+		"examined", resp.Examined,
+		"updated", resp.Updated,
+		"skipped", resp.Skipped)
+	return &resp, nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
+	"github.com/google/exposure-notifications-server/pkg/database"
+	"github.com/google/exposure-notifications-server/pkg/util"
+	"github.com/google/go-cmp/cmp"
+	pgx "github.com/jackc/pgx/v4"
+)
+
+func TestReprocessDaysSinceSymptomOnset(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	testPublishDB := New(testDB)
+
+	createdAt := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	onsetInterval := model.IntervalNumber(createdAt.Add(-4 * 24 * time.Hour))
+	intervalNumber := onsetInterval + 2*verifyapi.MaxIntervalCount
+
+	newExposure := func(createdAt time.Time, onset *int32, daysSince int32) *model.Exposure {
+		return &model.Exposure{
+			ExposureKey:           randomTEK(t),
+			TransmissionRisk:      verifyapi.TransmissionRiskConfirmedStandard,
+			AppPackageName:        "com.example.app",
+			Regions:               []string{"US"},
+			IntervalNumber:        intervalNumber,
+			IntervalCount:         verifyapi.MaxIntervalCount,
+			CreatedAt:             createdAt,
+			LocalProvenance:       true,
+			ReportType:            verifyapi.ReportTypeConfirmed,
+			DaysSinceSymptomOnset: &daysSince,
+			SymptomOnsetInterval:  onset,
+		}
+	}
+
+	exposures := []*model.Exposure{
+		// In range, mis-set.
+		newExposure(createdAt, &onsetInterval, 7),
+		newExposure(createdAt.Add(time.Hour), &onsetInterval, -3),
+		// In range, already correct.
+		newExposure(createdAt, &onsetInterval, 2),
+		// In range, no stored onset interval.
+		newExposure(createdAt, nil, 9),
+		// Out of range, mis-set.
+		newExposure(createdAt.Add(-48*time.Hour), &onsetInterval, 7),
+	}
+	if _, err := testPublishDB.InsertAndReviseExposures(ctx, &InsertAndReviseExposuresRequest{
+		Incoming:     exposures,
+		RequireToken: false,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := &ReprocessSymptomOnsetRequest{
+		SinceTimestamp: createdAt,
+		UntilTimestamp: createdAt.Add(24 * time.Hour),
+		BatchSize:      2,
+	}
+	resp, err := testPublishDB.ReprocessDaysSinceSymptomOnset(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resp.Examined, int64(3); got != want {
+		t.Errorf("expected %d examined, got %d", want, got)
+	}
+	if got, want := resp.Updated, int64(2); got != want {
+		t.Errorf("expected %d updated, got %d", want, got)
+	}
+	if got, want := resp.Skipped, int64(1); got != want {
+		t.Errorf("expected %d skipped, got %d", want, got)
+	}
+
+	// Only the days since symptom onset on the in range keys should change.
+	want := make([]*model.Exposure, 0, len(exposures))
+	keys := make([]string, 0, len(exposures))
+	for i, e := range exposures {
+		cp := *e
+		if i < 2 {
+			cp.SetDaysSinceSymptomOnset(2)
+		}
+		want = append(want, &cp)
+		keys = append(keys, e.ExposureKeyBase64())
+	}
+
+	var readBack map[string]*model.Exposure
+	if err := testDB.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		var err error
+		readBack, err = testPublishDB.ReadExposures(ctx, tx, keys)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range want {
+		got, ok := readBack[w.ExposureKeyBase64()]
+		if !ok {
+			t.Fatalf("missing exposure %v", w.ExposureKeyBase64())
+		}
+		if diff := cmp.Diff(w, got, database.ApproxTime, ignoreUnexportedExposure); diff != "" {
+			t.Errorf("mismatch (-want, +got):\n%s", diff)
+		}
+	}
+
+	// Running again is a no-op.
+	resp, err = testPublishDB.ReprocessDaysSinceSymptomOnset(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.Updated; got != 0 {
+		t.Errorf("expected no updates on second run, got %d", got)
+	}
+}
+
+// randomTEK is like util.RandomTEK, but handles the error from tb.
+func randomTEK(tb testing.TB) []byte {
+	tb.Helper()
+
+	b, err := util.RandomTEK()
+	if err != nil {
+		tb.Fatalf("failed to generate tek: %v", err)
+	}
+	return b
+}
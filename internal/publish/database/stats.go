@@ -16,7 +16,10 @@ package database
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/exposure-notifications-server/internal/publish/model"
@@ -156,3 +159,229 @@ func (db *PublishDB) UpdateStatsInTx(ctx context.Context, tx pgx.Tx, hour time.T
 
 	return nil
 }
+
+// UpsertSubmittedStats stores the submitted stats for a health authority and
+// day, replacing any stats previously submitted for that same day.
+func (db *PublishDB) UpsertSubmittedStats(ctx context.Context, stats *model.SubmittedStats) error {
+	if stats == nil {
+		return fmt.Errorf("missing stats")
+	}
+	if stats.HealthAuthorityID <= 0 {
+		return fmt.Errorf("missing healthAuthorityID")
+	}
+
+	metrics, err := json.Marshal(stats.Metrics)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metrics: %w", err)
+	}
+
+	return db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO
+				HealthAuthoritySubmittedStats
+				(health_authority_id, day, metrics, updated_at)
+			VALUES
+				($1, $2, $3, $4)
+			ON CONFLICT (health_authority_id, day) DO UPDATE
+				SET metrics = EXCLUDED.metrics, updated_at = EXCLUDED.updated_at
+			`, stats.HealthAuthorityID, timeutils.UTCMidnight(stats.Day), string(metrics), stats.UpdatedAt); err != nil {
+			return fmt.Errorf("upserting submitted stats: %w", err)
+		}
+		return nil
+	})
+}
+
+// ReadSubmittedStats returns all submitted stats for a health authority,
+// ordered in ascending time.
+func (db *PublishDB) ReadSubmittedStats(ctx context.Context, healthAuthorityID int64) ([]*model.SubmittedStats, error) {
+	if healthAuthorityID <= 0 {
+		return nil, fmt.Errorf("missing healthAuthorityID")
+	}
+
+	var results []*model.SubmittedStats
+	err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+		SELECT
+			health_authority_id, day, metrics, updated_at
+		FROM
+			HealthAuthoritySubmittedStats
+		WHERE
+			health_authority_id=$1
+		ORDER BY day ASC
+		`, healthAuthorityID)
+		if err != nil {
+			return fmt.Errorf("read submitted stats: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var stats model.SubmittedStats
+			var metrics []byte
+			if err := rows.Scan(&stats.HealthAuthorityID, &stats.Day, &metrics, &stats.UpdatedAt); err != nil {
+				return fmt.Errorf("failed to scan submitted stats: %w", err)
+			}
+			if err := json.Unmarshal(metrics, &stats.Metrics); err != nil {
+				return fmt.Errorf("failed to unmarshal metrics: %w", err)
+			}
+			results = append(results, &stats)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// ReadKeyCountsCriteria is the criteria for reading a page of key counts.
+type ReadKeyCountsCriteria struct {
+	Window *model.KeyCountsWindow
+	// Cursor is the cursor returned by a previous call with the same window,
+	// or empty to read from the start of the window.
+	Cursor string
+	// Limit is the maximum number of counts to return, it must be > 0.
+	Limit int
+}
+
+// ReadKeyCounts returns the number of exposure keys published per region per
+// UTC day in the criteria window, ordered by day and then region. Revoked keys
+// are not counted. If there are more counts in the window, a cursor to pass to
+// the next call is also returned.
+func (db *PublishDB) ReadKeyCounts(ctx context.Context, criteria *ReadKeyCountsCriteria) ([]*model.KeyCount, string, error) {
+	if criteria == nil || criteria.Window == nil {
+		return nil, "", fmt.Errorf("missing window")
+	}
+	if criteria.Limit <= 0 {
+		return nil, "", fmt.Errorf("limit must be > 0")
+	}
+
+	// The zero cursor sorts before every (day, region) pair.
+	afterDay, afterRegion := time.Unix(0, 0).UTC(), ""
+	if criteria.Cursor != "" {
+		var err error
+		afterDay, afterRegion, err = decodeKeyCountsCursor(criteria.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	// Read one more than the limit to know if there is another page.
+	results := make([]*model.KeyCount, 0, criteria.Limit+1)
+	err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+		SELECT
+			day, region, num_keys
+		FROM (
+			SELECT
+				DATE_TRUNC('day', created_at AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' AS day,
+				region,
+				COUNT(*) AS num_keys
+			FROM
+				Exposure, UNNEST(regions) AS region
+			WHERE
+				created_at >= $1 AND created_at < $2 AND revoked_at IS NULL
+			GROUP BY
+				day, region
+		) AS counts
+		WHERE
+			(day, region) > ($3, $4)
+		ORDER BY day ASC, region ASC
+		LIMIT $5
+		`, criteria.Window.Start, criteria.Window.End, afterDay, afterRegion, criteria.Limit+1)
+		if err != nil {
+			return fmt.Errorf("read key counts: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var count model.KeyCount
+			if err := rows.Scan(&count.Day, &count.Region, &count.Count); err != nil {
+				return fmt.Errorf("failed to scan key count: %w", err)
+			}
+			count.Day = count.Day.UTC()
+			results = append(results, &count)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(results) <= criteria.Limit {
+		return results, "", nil
+	}
+	results = results[:criteria.Limit]
+	last := results[len(results)-1]
+	return results, encodeKeyCountsCursor(last.Day, last.Region), nil
+}
+
+func encodeKeyCountsCursor(day time.Time, region string) string {
+	return encodeCursor(fmt.Sprintf("%d|%s", day.Unix(), region))
+}
+
+func decodeKeyCountsCursor(encoded string) (time.Time, string, error) {
+	s, err := decodeCursor(encoded)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("%w: %v", ErrInvalidKeyCountsCursor, err)
+	}
+	parts := strings.SplitN(s, "|", 2)
+	if len(parts) != 2 {
+		return time.Time{}, "", ErrInvalidKeyCountsCursor
+	}
+	unix, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("%w: %v", ErrInvalidKeyCountsCursor, err)
+	}
+	return time.Unix(unix, 0).UTC(), parts[1], nil
+}
+
+// ReadRevisionTransitions returns the number of exposure keys revised per
+// region, per UTC day and per pair of original and revised report type, for
+// the keys revised between start (inclusive) and end (exclusive). Revoked keys
+// are counted as revised to negative. Only the counts are returned, never the
+// keys. The results are ordered by day, region, original and then revised
+// report type.
+func (db *PublishDB) ReadRevisionTransitions(ctx context.Context, start, end time.Time) ([]*model.RevisionTransition, error) {
+	if !start.Before(end) {
+		return nil, fmt.Errorf("start must be before end")
+	}
+
+	var results []*model.RevisionTransition
+	err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+		SELECT
+			DATE_TRUNC('day', revised_at AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' AS day,
+			region,
+			report_type,
+			revised_report_type,
+			COUNT(*) AS num_keys
+		FROM
+			Exposure, UNNEST(regions) AS region
+		WHERE
+			revised_at >= $1 AND revised_at < $2 AND revised_report_type IS NOT NULL
+		GROUP BY
+			day, region, report_type, revised_report_type
+		ORDER BY
+			day ASC, region ASC, report_type ASC, revised_report_type ASC
+		`, start, end)
+		if err != nil {
+			return fmt.Errorf("read revision transitions: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var transition model.RevisionTransition
+			if err := rows.Scan(&transition.Day, &transition.Region, &transition.From, &transition.To, &transition.Count); err != nil {
+				return fmt.Errorf("failed to scan revision transition: %w", err)
+			}
+			transition.Day = transition.Day.UTC()
+			results = append(results, &transition)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
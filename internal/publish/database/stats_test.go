@@ -15,6 +15,7 @@
 package database
 
 import (
+	"errors"
 	"testing"
 	"time"
 
@@ -22,6 +23,9 @@ import (
 	"github.com/google/exposure-notifications-server/internal/publish/model"
 	hadb "github.com/google/exposure-notifications-server/internal/verification/database"
 	hamodel "github.com/google/exposure-notifications-server/internal/verification/model"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
+	"github.com/google/exposure-notifications-server/pkg/timeutils"
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestDeleteStatsBefore(t *testing.T) {
@@ -134,3 +138,224 @@ func TestReadStats(t *testing.T) {
 		t.Fatalf("added 11 hours of stats, got: %v", len(stats))
 	}
 }
+
+func TestReadKeyCounts(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	testPublishDB := New(testDB)
+
+	testHADB := hadb.New(testDB)
+	healthAuthority := hamodel.HealthAuthority{
+		Issuer:   "a",
+		Audience: "b",
+		Name:     "c",
+	}
+	if err := testHADB.AddHealthAuthority(ctx, &healthAuthority); err != nil {
+		t.Fatalf("unable to create health authority: %v", err)
+	}
+
+	today := timeutils.UTCMidnight(time.Now())
+	day1 := today.AddDate(0, 0, -3)
+	day2 := today.AddDate(0, 0, -2)
+	day3 := today.AddDate(0, 0, -1)
+
+	newExposure := func(createdAt time.Time, regions ...string) *model.Exposure {
+		return &model.Exposure{
+			ExposureKey:       randomTEK(t),
+			Regions:           regions,
+			IntervalNumber:    100,
+			IntervalCount:     144,
+			CreatedAt:         createdAt,
+			HealthAuthorityID: &healthAuthority.ID,
+			ReportType:        verifyapi.ReportTypeConfirmed,
+		}
+	}
+
+	exposures := []*model.Exposure{
+		// Outside of the window.
+		newExposure(day1.Add(-time.Hour), "US"),
+		// day1: US=2, CA=1
+		newExposure(day1, "US", "CA"),
+		newExposure(day1.Add(23*time.Hour), "US"),
+		// day2: MX=1, US=1 (a second US key is revoked below)
+		newExposure(day2.Add(5*time.Hour), "MX"),
+		newExposure(day2.Add(6*time.Hour), "US"),
+		newExposure(day2.Add(7*time.Hour), "US"),
+		// day3: CA=3
+		newExposure(day3.Add(time.Hour), "CA"),
+		newExposure(day3.Add(2*time.Hour), "CA"),
+		newExposure(day3.Add(3*time.Hour), "CA"),
+		// Outside of the window.
+		newExposure(today, "US"),
+	}
+	if _, err := testPublishDB.InsertAndReviseExposures(ctx, &InsertAndReviseExposuresRequest{
+		Incoming:     exposures,
+		RequireToken: false,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testPublishDB.RevokeExposures(ctx, healthAuthority.ID, []string{exposures[5].ExposureKeyBase64()}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	window := &model.KeyCountsWindow{Start: day1, End: today}
+	want := []*model.KeyCount{
+		{Day: day1, Region: "CA", Count: 1},
+		{Day: day1, Region: "US", Count: 2},
+		{Day: day2, Region: "MX", Count: 1},
+		{Day: day2, Region: "US", Count: 1},
+		{Day: day3, Region: "CA", Count: 3},
+	}
+
+	t.Run("all", func(t *testing.T) {
+		t.Parallel()
+
+		got, cursor, err := testPublishDB.ReadKeyCounts(ctx, &ReadKeyCountsCriteria{
+			Window: window,
+			Limit:  100,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cursor != "" {
+			t.Errorf("expected no cursor, got %q", cursor)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("mismatch (-want, +got):\n%s", diff)
+		}
+	})
+
+	t.Run("paginated", func(t *testing.T) {
+		t.Parallel()
+
+		var got []*model.KeyCount
+		var cursor string
+		pages := 0
+		for {
+			page, next, err := testPublishDB.ReadKeyCounts(ctx, &ReadKeyCountsCriteria{
+				Window: window,
+				Cursor: cursor,
+				Limit:  2,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			pages++
+			got = append(got, page...)
+
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+
+		if got, want := pages, 3; got != want {
+			t.Errorf("expected %d pages, got %d", want, got)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("mismatch (-want, +got):\n%s", diff)
+		}
+	})
+
+	t.Run("invalid_cursor", func(t *testing.T) {
+		t.Parallel()
+
+		if _, _, err := testPublishDB.ReadKeyCounts(ctx, &ReadKeyCountsCriteria{
+			Window: window,
+			Cursor: "not-a-cursor",
+			Limit:  2,
+		}); !errors.Is(err, ErrInvalidKeyCountsCursor) {
+			t.Errorf("expected %v to be %v", err, ErrInvalidKeyCountsCursor)
+		}
+	})
+}
+
+func TestReadRevisionTransitions(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	testPublishDB := New(testDB)
+
+	testHADB := hadb.New(testDB)
+	healthAuthority := hamodel.HealthAuthority{
+		Issuer:   "a",
+		Audience: "b",
+		Name:     "c",
+	}
+	if err := testHADB.AddHealthAuthority(ctx, &healthAuthority); err != nil {
+		t.Fatalf("unable to create health authority: %v", err)
+	}
+
+	today := timeutils.UTCMidnight(time.Now())
+	day1 := today.AddDate(0, 0, -2)
+	day2 := today.AddDate(0, 0, -1)
+
+	newExposure := func(reportType string, regions ...string) *model.Exposure {
+		return &model.Exposure{
+			ExposureKey:       randomTEK(t),
+			Regions:           regions,
+			IntervalNumber:    100,
+			IntervalCount:     144,
+			CreatedAt:         day1.Add(-time.Hour),
+			HealthAuthorityID: &healthAuthority.ID,
+			ReportType:        reportType,
+		}
+	}
+	exposures := []*model.Exposure{
+		newExposure(verifyapi.ReportTypeClinical, "US", "CA"),
+		newExposure(verifyapi.ReportTypeClinical, "US"),
+		newExposure(verifyapi.ReportTypeClinical, "US"),
+		newExposure(verifyapi.ReportTypeClinical, "US"),
+		newExposure(verifyapi.ReportTypeClinical, "MX"),
+		// Never revised.
+		newExposure(verifyapi.ReportTypeClinical, "US"),
+	}
+	if _, err := testPublishDB.InsertAndReviseExposures(ctx, &InsertAndReviseExposuresRequest{
+		Incoming: exposures,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	revise := func(exp *model.Exposure, reportType string, revisedAt time.Time) {
+		t.Helper()
+
+		revision := *exp
+		revision.ReportType = reportType
+		revision.CreatedAt = revisedAt
+		if _, err := testPublishDB.InsertAndReviseExposures(ctx, &InsertAndReviseExposuresRequest{
+			Incoming: []*model.Exposure{&revision},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Revised before the window.
+	revise(exposures[0], verifyapi.ReportTypeConfirmed, day1.Add(-time.Minute))
+	// day1: US clinical->confirmed=2, MX clinical->confirmed=1
+	revise(exposures[1], verifyapi.ReportTypeConfirmed, day1.Add(time.Hour))
+	revise(exposures[2], verifyapi.ReportTypeConfirmed, day1.Add(23*time.Hour))
+	revise(exposures[4], verifyapi.ReportTypeConfirmed, day1.Add(2*time.Hour))
+	// day2: US clinical->negative=1, revoked
+	if _, err := testPublishDB.RevokeExposures(ctx, healthAuthority.ID, []string{exposures[3].ExposureKeyBase64()}, day2.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := testPublishDB.ReadRevisionTransitions(ctx, day1, today)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*model.RevisionTransition{
+		{Day: day1, Region: "MX", From: verifyapi.ReportTypeClinical, To: verifyapi.ReportTypeConfirmed, Count: 1},
+		{Day: day1, Region: "US", From: verifyapi.ReportTypeClinical, To: verifyapi.ReportTypeConfirmed, Count: 2},
+		{Day: day2, Region: "US", From: verifyapi.ReportTypeClinical, To: verifyapi.ReportTypeNegative, Count: 1},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	if _, err := testPublishDB.ReadRevisionTransitions(ctx, today, day1); err == nil {
+		t.Errorf("expected an error for an empty range")
+	}
+}
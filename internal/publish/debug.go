@@ -0,0 +1,29 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"net/http"
+
+	"github.com/google/exposure-notifications-server/internal/jsonutil"
+)
+
+// handleDebugVerifierConfig returns the redacted effective configuration of
+// the verifier, see verification.Verifier.DumpConfig.
+func (s *Server) handleDebugVerifierConfig() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jsonutil.MarshalResponse(w, http.StatusOK, s.verifier.DumpConfig())
+	})
+}
@@ -0,0 +1,60 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/exposure-notifications-server/internal/verification"
+)
+
+func TestHandleDebugVerifierConfig(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	verifier, err := verification.New(nil, &verification.Config{
+		CacheDuration:    time.Minute,
+		StatsAudience:    "keyserver",
+		StatsTokenLeeway: 30 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{verifier: verifier}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, "/debug/verifier-config", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	server.handleDebugVerifierConfig().ServeHTTP(w, r)
+
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("expected status %d to be %d: %s", got, want, w.Body.String())
+	}
+	var got verification.VerifierConfigSnapshot
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.StatsAudience != "keyserver" || got.StatsTokenLeeway != 30*time.Second || got.CacheDuration != time.Minute {
+		t.Errorf("expected the configured values, got %#v", got)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/publish/database"
+	coredb "github.com/google/exposure-notifications-server/pkg/database"
+	"github.com/google/exposure-notifications-server/pkg/logging"
+	"github.com/sethvargo/go-retry"
+	"go.opencensus.io/stats"
+)
+
+// insertExposuresFunc saves the exposures of a publish request in a single
+// transaction, like database.PublishDB.InsertAndReviseExposures.
+type insertExposuresFunc func(context.Context, *database.InsertAndReviseExposuresRequest) (*database.InsertAndReviseExposuresResponse, error)
+
+// insertExposures saves the exposures of a publish request, retrying the
+// transaction if it fails with a serialization failure or deadlock.
+func (s *Server) insertExposures(ctx context.Context, req *database.InsertAndReviseExposuresRequest) (*database.InsertAndReviseExposuresResponse, error) {
+	return insertWithRetry(ctx, s.database.InsertAndReviseExposures, req, s.config.InsertMaxAttempts, s.config.InsertRetryBackoff)
+}
+
+// insertWithRetry calls insert until it succeeds, fails with an error that
+// isn't retryable, or maxAttempts attempts failed, with exponential backoff
+// starting at backoff. The failed transactions were rolled back, so the request
+// is sent again as is.
+func insertWithRetry(ctx context.Context, insert insertExposuresFunc, req *database.InsertAndReviseExposuresRequest,
+	maxAttempts uint, backoff time.Duration) (*database.InsertAndReviseExposuresResponse, error) {
+	if maxAttempts <= 1 {
+		return insert(ctx, req)
+	}
+	b, err := retry.NewExponential(backoff)
+	if err != nil {
+		return nil, fmt.Errorf("invalid insert retry backoff: %w", err)
+	}
+	b = retry.WithMaxRetries(uint64(maxAttempts-1), b)
+
+	logger := logging.FromContext(ctx).Named("insertWithRetry")
+
+	var resp *database.InsertAndReviseExposuresResponse
+	attempt := 0
+	if err := retry.Do(ctx, b, func(ctx context.Context) error {
+		attempt++
+		if attempt > 1 {
+			stats.Record(ctx, mInsertRetried.M(1))
+		}
+
+		var err error
+		resp, err = insert(ctx, req)
+		if err != nil && coredb.IsRetryable(err) {
+			if uint(attempt) < maxAttempts {
+				logger.Warnw("publish transaction failed, retrying", "attempt", attempt, "error", err)
+			}
+			return retry.RetryableError(err)
+		}
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
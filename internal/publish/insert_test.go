@@ -0,0 +1,139 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/exposure-notifications-server/internal/publish/database"
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/jackc/pgconn"
+)
+
+// fakeInserter fails the first inserts with the errors, then succeeds.
+type fakeInserter struct {
+	errs  []error
+	calls int
+}
+
+func (f *fakeInserter) insert(_ context.Context, req *database.InsertAndReviseExposuresRequest) (*database.InsertAndReviseExposuresResponse, error) {
+	f.calls++
+	if f.calls <= len(f.errs) {
+		return nil, f.errs[f.calls-1]
+	}
+	return &database.InsertAndReviseExposuresResponse{
+		Inserted:  uint32(len(req.Incoming)),
+		Exposures: req.Incoming,
+	}, nil
+}
+
+func TestInsertWithRetry(t *testing.T) {
+	t.Parallel()
+
+	serializationFailure := fmt.Errorf("committing transaction: %w", &pgconn.PgError{Code: "40001"})
+	deadlock := &pgconn.PgError{Code: "40P01"}
+	otherErr := errors.New("connection refused")
+
+	cases := []struct {
+		name        string
+		errs        []error
+		maxAttempts uint
+		wantCalls   int
+		err         error
+	}{
+		{
+			name:        "success",
+			maxAttempts: 3,
+			wantCalls:   1,
+		},
+		{
+			name:        "serialization_failure_once",
+			errs:        []error{serializationFailure},
+			maxAttempts: 3,
+			wantCalls:   2,
+		},
+		{
+			name:        "deadlock_then_serialization_failure",
+			errs:        []error{deadlock, serializationFailure},
+			maxAttempts: 3,
+			wantCalls:   3,
+		},
+		{
+			name:        "attempts_exhausted",
+			errs:        []error{serializationFailure, serializationFailure, serializationFailure},
+			maxAttempts: 3,
+			wantCalls:   3,
+			err:         serializationFailure,
+		},
+		{
+			name:        "not_retryable",
+			errs:        []error{otherErr},
+			maxAttempts: 3,
+			wantCalls:   1,
+			err:         otherErr,
+		},
+		{
+			name:        "client_error",
+			errs:        []error{database.ErrNoRevisionToken},
+			maxAttempts: 3,
+			wantCalls:   1,
+			err:         database.ErrNoRevisionToken,
+		},
+		{
+			name:        "retries_disabled",
+			errs:        []error{serializationFailure},
+			maxAttempts: 1,
+			wantCalls:   1,
+			err:         serializationFailure,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := project.TestContext(t)
+			req := &database.InsertAndReviseExposuresRequest{
+				Incoming: []*model.Exposure{{ExposureKey: []byte("ABC")}},
+			}
+
+			inserter := &fakeInserter{errs: tc.errs}
+			resp, err := insertWithRetry(ctx, inserter.insert, req, tc.maxAttempts, time.Millisecond)
+			if inserter.calls != tc.wantCalls {
+				t.Errorf("expected %d attempts, got %d", tc.wantCalls, inserter.calls)
+			}
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("expected error %v, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := resp.Inserted, uint32(1); got != want {
+				t.Errorf("expected %d inserted, got %d", want, got)
+			}
+		})
+	}
+}
@@ -32,6 +32,30 @@ var (
 	mJWTNotYetValid = stats.Int64(publishMetricsPrefix+"jwt_not_yet_valid",
 		"a certificate was presented with an IAT or NBF time that is in the past according to thie server", stats.UnitDimensionless)
 
+	mDailyKeyQuotaExceeded = stats.Int64(publishMetricsPrefix+"daily_key_quota_exceeded",
+		"publish requests rejected because the health authority exceeded its daily key quota", stats.UnitDimensionless)
+
+	mCertificateThrottleEvicted = stats.Int64(publishMetricsPrefix+"certificate_throttle_evicted",
+		"certificates forgotten by the publish certificate throttle before they could be used again", stats.UnitDimensionless)
+
+	mPublishQuarantined = stats.Int64(publishMetricsPrefix+"quarantined",
+		"publish requests whose keys were quarantined for manual review", stats.UnitDimensionless)
+
+	mWriteBreakerTripped = stats.Int64(publishMetricsPrefix+"write_breaker_tripped",
+		"times the write breaker opened after sustained database write failures", stats.UnitDimensionless)
+
+	mWriteBreakerRejected = stats.Int64(publishMetricsPrefix+"write_breaker_rejected",
+		"publish requests rejected while the write breaker was open", stats.UnitDimensionless)
+
+	mInsertRetried = stats.Int64(publishMetricsPrefix+"insert_retried",
+		"publish transactions retried after a serialization failure or deadlock", stats.UnitDimensionless)
+
+	mStatsTokenFromQuery = stats.Int64(publishMetricsPrefix+"stats_token_from_query",
+		"stats requests that passed the stats token as a query parameter instead of a header", stats.UnitDimensionless)
+
+	mStatsSinkDropped = stats.Int64(publishMetricsPrefix+"stats_sink_dropped",
+		"stats records dropped because the stats sink failed or timed out", stats.UnitDimensionless)
+
 	mNoPublicKey = stats.Int64(publishMetricsPrefix+"no_public_keys",
 		"uploads where there is no public key", stats.UnitDimensionless)
 
@@ -72,6 +96,7 @@ var (
 	exposuresInserted = exposureType("INSERTED")
 	exposuresRevised  = exposureType("REVISED")
 	exposuresDropped  = exposureType("DROPPED")
+	exposuresTooOld   = exposureType("TOO_OLD")
 )
 
 func init() {
@@ -110,6 +135,57 @@ func init() {
 			Aggregation: view.Sum(),
 			TagKeys:     missingPublicKeyTags,
 		},
+		{
+			Name:        metrics.MetricRoot + "daily_key_quota_exceeded",
+			Description: "Total count of publish requests rejected by the daily key quota",
+			Measure:     mDailyKeyQuotaExceeded,
+			Aggregation: view.Sum(),
+			TagKeys:     missingPublicKeyTags,
+		},
+		{
+			Name:        metrics.MetricRoot + "certificate_throttle_evicted",
+			Description: "Total count of certificates forgotten by the publish certificate throttle because it was full",
+			Measure:     mCertificateThrottleEvicted,
+			Aggregation: view.Sum(),
+			TagKeys:     missingPublicKeyTags,
+		},
+		{
+			Name:        metrics.MetricRoot + "publish_quarantined",
+			Description: "Total count of publish requests quarantined for manual review",
+			Measure:     mPublishQuarantined,
+			Aggregation: view.Sum(),
+			TagKeys:     missingPublicKeyTags,
+		},
+		{
+			Name:        metrics.MetricRoot + "write_breaker_tripped",
+			Description: "Total count of times the publish write breaker opened",
+			Measure:     mWriteBreakerTripped,
+			Aggregation: view.Sum(),
+		},
+		{
+			Name:        metrics.MetricRoot + "write_breaker_rejected",
+			Description: "Total count of publish requests rejected by the open write breaker",
+			Measure:     mWriteBreakerRejected,
+			Aggregation: view.Sum(),
+		},
+		{
+			Name:        metrics.MetricRoot + "insert_retried",
+			Description: "Total count of publish transactions retried after a serialization failure or deadlock",
+			Measure:     mInsertRetried,
+			Aggregation: view.Sum(),
+		},
+		{
+			Name:        metrics.MetricRoot + "stats_token_from_query",
+			Description: "Total count of stats requests with the stats token in a query parameter",
+			Measure:     mStatsTokenFromQuery,
+			Aggregation: view.Sum(),
+		},
+		{
+			Name:        metrics.MetricRoot + "stats_sink_dropped",
+			Description: "Total count of stats records dropped by the stats sink",
+			Measure:     mStatsSinkDropped,
+			Aggregation: view.Sum(),
+		},
 		// v1 and v1alpha1
 		{
 			Name:        metrics.MetricRoot + "padding_failed",
@@ -18,6 +18,7 @@ package model
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"math"
 	"sort"
@@ -26,7 +27,6 @@ import (
 
 	"github.com/google/exposure-notifications-server/internal/pb/export"
 	"github.com/google/exposure-notifications-server/internal/verification"
-	"github.com/google/exposure-notifications-server/pkg/base64util"
 	"github.com/google/exposure-notifications-server/pkg/logging"
 	"github.com/google/exposure-notifications-server/pkg/timeutils"
 	"github.com/hashicorp/go-multierror"
@@ -59,6 +59,104 @@ func (e *ErrorKeyInvalidReportTypeTransition) Error() string {
 		e.from, e.to)
 }
 
+var _ error = (*ErrorKeyInvalidEncoding)(nil)
+
+// ErrorKeyInvalidEncoding is an error returned when a TEK in a publish request
+// isn't valid base64, see DecodeExposureKey.
+type ErrorKeyInvalidEncoding struct {
+	// Index is the position of the key in the publish request.
+	Index int
+	err   error
+}
+
+// Error implements error.
+func (e *ErrorKeyInvalidEncoding) Error() string {
+	return fmt.Sprintf("key %d has invalid encoding: %v", e.Index, e.err)
+}
+
+// Unwrap returns the underlying decoding error.
+func (e *ErrorKeyInvalidEncoding) Unwrap() error {
+	return e.err
+}
+
+var _ error = (*ErrorKeyInvalidLength)(nil)
+
+// ErrorKeyInvalidLength is an error returned when a TEK in a publish request
+// doesn't decode to exactly verifyapi.KeyLength bytes.
+type ErrorKeyInvalidLength struct {
+	// Index is the position of the key in the publish request.
+	Index int
+	// Length is the decoded length of the key.
+	Length int
+}
+
+// Error implements error.
+func (e *ErrorKeyInvalidLength) Error() string {
+	return fmt.Sprintf("key %d has invalid length %d, must be %d bytes", e.Index, e.Length, verifyapi.KeyLength)
+}
+
+var _ error = (*ErrorKeyTooManyRegions)(nil)
+
+// ErrorKeyTooManyRegions is an error returned when a TEK in a publish request
+// is tagged with more regions than the transformer allows.
+type ErrorKeyTooManyRegions struct {
+	// Index is the position of the key in the publish request.
+	Index int
+	// Count is the number of regions of the key.
+	Count int
+	// Max is the maximum number of regions per key.
+	Max int
+}
+
+// Error implements error.
+func (e *ErrorKeyTooManyRegions) Error() string {
+	return fmt.Sprintf("key %d has %d regions, must be at most %d", e.Index, e.Count, e.Max)
+}
+
+// ValidateExposureKeyLengths returns an ErrorKeyInvalidLength for each key
+// that doesn't decode to exactly verifyapi.KeyLength bytes. Keys that can't be
+// decoded are not checked, see DecodeExposureKey.
+func ValidateExposureKeyLengths(keys []verifyapi.ExposureKey) error {
+	var result *multierror.Error
+	for i, key := range keys {
+		binKey, err := DecodeExposureKey(key.Key)
+		if err != nil {
+			continue
+		}
+		if l := len(binKey); l != verifyapi.KeyLength {
+			result = multierror.Append(result, &ErrorKeyInvalidLength{Index: i, Length: l})
+		}
+	}
+	return result.ErrorOrNil()
+}
+
+// DecodeExposureKey decodes a base64 encoded exposure key. The key may use
+// either the standard or the URL-safe alphabet, but not a mix of both. Padding
+// is optional, but if present it must be correct.
+func DecodeExposureKey(s string) ([]byte, error) {
+	if s == "" {
+		return nil, fmt.Errorf("key is empty")
+	}
+	if strings.ContainsAny(s, "\r\n") {
+		return nil, fmt.Errorf("key contains line breaks")
+	}
+
+	std := strings.ContainsAny(s, "+/")
+	url := strings.ContainsAny(s, "-_")
+	if std && url {
+		return nil, fmt.Errorf("key mixes standard and URL-safe base64 alphabets")
+	}
+
+	enc := base64.RawStdEncoding
+	if url {
+		enc = base64.RawURLEncoding
+	}
+	if strings.HasSuffix(s, "=") {
+		enc = enc.WithPadding(base64.StdPadding)
+	}
+	return enc.DecodeString(s)
+}
+
 // Exposure represents the record as stored in the database
 type Exposure struct {
 	ExposureKey       []byte
@@ -82,6 +180,14 @@ type Exposure struct {
 	HealthAuthorityID     *int64
 	ReportType            string
 	DaysSinceSymptomOnset *int32
+	// SymptomOnsetInterval is the onset interval that was used to derive
+	// DaysSinceSymptomOnset at publish time. It is retained so that the days
+	// since symptom onset can be re-derived if necessary.
+	SymptomOnsetInterval *int32
+	// CertificateHash is the hash of the ID of the verification certificate
+	// the key was published with, see verification.CertificateHash. It is
+	// empty for keys published without a certificate.
+	CertificateHash string
 
 	// Fields to support key revision.
 	RevisedReportType            *string
@@ -205,6 +311,41 @@ func ValidReportTypeTransition(from, to string) bool {
 	return false
 }
 
+// reportTypeAuthority ranks report types by authority. When the same key is
+// published with different report types, the higher ranked one wins. Confirmed
+// and negative both come from a test result, so neither replaces the other.
+var reportTypeAuthority = map[string]int{
+	verifyapi.ReportTypeSelfReport: 1,
+	verifyapi.ReportTypeClinical:   2,
+	verifyapi.ReportTypeConfirmed:  3,
+	verifyapi.ReportTypeNegative:   3,
+}
+
+// ReportTypeAuthority returns the rank of the report type, higher ranks are
+// more authoritative. An empty report type is ranked as clinical, like in
+// Revise, and unknown report types rank lowest.
+func ReportTypeAuthority(reportType string) int {
+	if reportType == "" {
+		reportType = verifyapi.ReportTypeClinical
+	}
+	return reportTypeAuthority[reportType]
+}
+
+// ReviseIfHigherAuthority revises the key like Revise, but only if the
+// incoming report type has a higher ReportTypeAuthority than the current
+// report type of the key, which is the revised report type if it has been
+// revised. Otherwise the key is kept unchanged, and no error is returned.
+func (e *Exposure) ReviseIfHigherAuthority(in *Exposure) (bool, error) {
+	current := e.ReportType
+	if e.RevisedReportType != nil {
+		current = *e.RevisedReportType
+	}
+	if ReportTypeAuthority(in.ReportType) <= ReportTypeAuthority(current) {
+		return false, nil
+	}
+	return e.Revise(in)
+}
+
 // Revise updates the Revised fields of a key
 func (e *Exposure) Revise(in *Exposure) (bool, error) {
 	if e.ExposureKeyBase64() != in.ExposureKeyBase64() {
@@ -298,6 +439,12 @@ func (e *Exposure) SetDaysSinceSymptomOnset(d int32) {
 	e.DaysSinceSymptomOnset = &d
 }
 
+// SetSymptomOnsetInterval sets the symptom onset interval field, possibly
+// allocating a new pointer.
+func (e *Exposure) SetSymptomOnsetInterval(i int32) {
+	e.SymptomOnsetInterval = &i
+}
+
 // HasHealthAuthorityID returns true if this Exposure has a health authority ID.
 func (e *Exposure) HasHealthAuthorityID() bool {
 	return e.HealthAuthorityID != nil
@@ -421,6 +568,18 @@ type TransformerConfig interface {
 	MaxValidSymptomOnsetReportDays() uint
 	DefaultSymptomOnsetDaysAgo() uint
 	DebugReleaseSameDayKeys() bool
+	DefaultReportTypeTransmissionRisks() map[string]int
+	CertificateOnsetMaxDaysBefore() uint
+	CertificateOnsetMaxDaysAfter() uint
+	RejectCertificateOnsetOutliers() bool
+	MaxExposureKeyAge() time.Duration
+	RejectTooOldExposureKeys() bool
+	// MaxRegionsPerKey is the maximum number of regions a key may be tagged
+	// with, 0 for no limit.
+	MaxRegionsPerKey() uint
+	// KeyValidators are the names of registered key validators to use after
+	// the built-in ones, see RegisterKeyValidator.
+	KeyValidators() []string
 }
 
 // Transformer represents a configured Publish -> Exposure[] transformer.
@@ -433,6 +592,13 @@ type Transformer struct {
 	maxValidSymptomOnsetReportDays uint
 	defaultSymptomOnsetDaysAgo     uint
 	debugReleaseSameDay            bool // If true, still valid keys are not embargoed.
+	// Report type -> transmission risk to use when a key is published without
+	// a transmission risk. Report types not present use ReportTypeTransmissionRisk.
+	reportTypeTransmissionRisks map[string]int
+	// Maximum number of regions per key, 0 for no limit.
+	maxRegionsPerKey int
+	// Validators run on each key, the built-in ones first.
+	keyValidators []KeyValidator
 }
 
 // NewTransformer creates a transformer for turning publish API requests into
@@ -445,6 +611,25 @@ func NewTransformer(config TransformerConfig) (*Transformer, error) {
 	if config.MaxSameDayKeys() < 1 {
 		return nil, fmt.Errorf("maxSameDayKeys must be >= 1, got %v", config.MaxSameDayKeys())
 	}
+
+	reportTypeTransmissionRisks := make(map[string]int, len(config.DefaultReportTypeTransmissionRisks()))
+	for reportType, tr := range config.DefaultReportTypeTransmissionRisks() {
+		if !verifyapi.ValidReportTypes[reportType] {
+			return nil, fmt.Errorf("invalid report type for default transmission risk: %q", reportType)
+		}
+		if tr < verifyapi.MinTransmissionRisk || tr > verifyapi.MaxTransmissionRisk {
+			return nil, fmt.Errorf("default transmission risk for %q must be >= %d && <= %d, got %d",
+				reportType, verifyapi.MinTransmissionRisk, verifyapi.MaxTransmissionRisk, tr)
+		}
+		reportTypeTransmissionRisks[reportType] = tr
+	}
+
+	customValidators, err := keyValidatorsFor(config.KeyValidators())
+	if err != nil {
+		return nil, err
+	}
+	keyValidators := append(defaultKeyValidators(config), customValidators...)
+
 	return &Transformer{
 		maxExposureKeys:                int(config.MaxExposureKeys()),
 		maxSameDayKeys:                 int(config.MaxSameDayKeys()),
@@ -454,6 +639,9 @@ func NewTransformer(config TransformerConfig) (*Transformer, error) {
 		maxValidSymptomOnsetReportDays: config.MaxValidSymptomOnsetReportDays(),
 		defaultSymptomOnsetDaysAgo:     config.DefaultSymptomOnsetDaysAgo(),
 		debugReleaseSameDay:            config.DebugReleaseSameDayKeys(),
+		reportTypeTransmissionRisks:    reportTypeTransmissionRisks,
+		maxRegionsPerKey:               int(config.MaxRegionsPerKey()),
+		keyValidators:                  keyValidators,
 	}, nil
 }
 
@@ -470,11 +658,12 @@ type KeyTransform struct {
 // TransformExposureKey converts individual key data to an exposure entity.
 // Validations during the transform include:
 //
+// * exposure keys are valid base64, see DecodeExposureKey
 // * exposure keys are exactly 16 bytes in length after base64 decoding
 // * minInterval <= interval number +intervalCount <= maxInterval
 // * MinIntervalCount <= interval count <= MaxIntervalCount
 func TransformExposureKey(exposureKey verifyapi.ExposureKey, appPackageName string, uppercaseRegions []string, settings *KeyTransform) (*Exposure, error) {
-	binKey, err := base64util.DecodeString(exposureKey.Key)
+	binKey, err := DecodeExposureKey(exposureKey.Key)
 	if err != nil {
 		return nil, err
 	}
@@ -500,6 +689,18 @@ func TransformExposureKey(exposureKey verifyapi.ExposureKey, appPackageName stri
 // Only keys that need to be revised or are being created for the first time
 // are returned in the output set.
 func ReviseKeys(ctx context.Context, existing map[string]*Exposure, incoming []*Exposure) ([]*Exposure, error) {
+	return reviseKeys(existing, incoming, (*Exposure).Revise)
+}
+
+// ReviseKeysHighestAuthority is like ReviseKeys, but existing keys are only
+// revised if the incoming report type has a higher authority, see
+// ReviseIfHigherAuthority. Existing keys with an equal or higher authority
+// report type are kept as is.
+func ReviseKeysHighestAuthority(ctx context.Context, existing map[string]*Exposure, incoming []*Exposure) ([]*Exposure, error) {
+	return reviseKeys(existing, incoming, (*Exposure).ReviseIfHigherAuthority)
+}
+
+func reviseKeys(existing map[string]*Exposure, incoming []*Exposure, revise func(prev, in *Exposure) (bool, error)) ([]*Exposure, error) {
 	output := make([]*Exposure, 0, len(incoming))
 
 	// Iterate over incoming keys.
@@ -515,7 +716,7 @@ func ReviseKeys(ctx context.Context, existing map[string]*Exposure, incoming []*
 		}
 
 		// Attempt to revise this key.
-		keyRevised, err := prevExposure.Revise(inExposure)
+		keyRevised, err := revise(prevExposure, inExposure)
 		if err != nil {
 			return nil, err
 		}
@@ -555,10 +756,38 @@ func ReportTypeTransmissionRisk(reportType string, providedTR int) int {
 	return verifyapi.TransmissionRiskUnknown
 }
 
+// transmissionRisk returns the transmission risk to save for a key with the
+// provided report type. A non-zero provided transmission risk is always used,
+// otherwise the configured default for the report type is used, falling back
+// to ReportTypeTransmissionRisk.
+func (t *Transformer) transmissionRisk(reportType string, providedTR int) int {
+	if providedTR != 0 {
+		return providedTR
+	}
+	if tr, ok := t.reportTypeTransmissionRisks[reportType]; ok {
+		return tr
+	}
+	return ReportTypeTransmissionRisk(reportType, providedTR)
+}
+
+// validateKey runs the key validators in order, and returns the error of the
+// first that fails.
+func (t *Transformer) validateKey(ctx context.Context, in *KeyValidationInput) error {
+	for _, v := range t.keyValidators {
+		if err := v.ValidateKey(ctx, in); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type TransformPublishResult struct {
 	Exposures   []*Exposure
 	PublishInfo *PublishInfo
 	Warnings    []string
+	// TooOld is the number of keys dropped for being older than the maximum
+	// exposure key age.
+	TooOld int
 }
 
 // TransformPublish converts incoming key data to a list of exposure entities.
@@ -589,6 +818,25 @@ func (t *Transformer) TransformPublish(ctx context.Context, inData *verifyapi.Pu
 		return &TransformPublishResult{}, fmt.Errorf(msg)
 	}
 
+	// Validate the encoding and length of every key up front, so a malformed
+	// key is reported by its index. The remaining keys are still transformed.
+	var transformErrors *multierror.Error
+	invalidKeys := make(map[int]struct{})
+	for i, exposureKey := range inData.Keys {
+		binKey, err := DecodeExposureKey(exposureKey.Key)
+		if err != nil {
+			logger.Debugw("invalid key encoding", "key", i, "error", err)
+			transformErrors = multierror.Append(transformErrors, &ErrorKeyInvalidEncoding{Index: i, err: err})
+			invalidKeys[i] = struct{}{}
+			continue
+		}
+		if l := len(binKey); l != verifyapi.KeyLength {
+			logger.Debugw("invalid key length", "key", i, "length", l)
+			transformErrors = multierror.Append(transformErrors, &ErrorKeyInvalidLength{Index: i, Length: l})
+			invalidKeys[i] = struct{}{}
+		}
+	}
+
 	defaultCreatedAt := TruncateWindow(batchTime, t.truncateWindow)
 	entities := make([]*Exposure, 0, len(inData.Keys))
 
@@ -656,8 +904,19 @@ func (t *Transformer) TransformPublish(ctx context.Context, inData *verifyapi.Pu
 	}
 
 	var transformWarnings []string
-	var transformErrors *multierror.Error
+	tooOld := 0
 	for i, exposureKey := range inData.Keys {
+		if _, ok := invalidKeys[i]; ok {
+			continue
+		}
+
+		// Keys without regions are handled by the missing region policy on
+		// export, not here.
+		if max := t.maxRegionsPerKey; max > 0 && len(uppercaseRegions) > max {
+			transformErrors = multierror.Append(transformErrors, &ErrorKeyTooManyRegions{Index: i, Count: len(uppercaseRegions), Max: max})
+			continue
+		}
+
 		exposure, err := TransformExposureKey(exposureKey, inData.HealthAuthorityID, uppercaseRegions, &settings)
 		if err != nil {
 			logger.Debugw("individual key transform failed", "error", err)
@@ -669,10 +928,30 @@ func (t *Transformer) TransformPublish(ctx context.Context, inData *verifyapi.Pu
 			if claims.ReportType != "" {
 				exposure.ReportType = claims.ReportType
 			}
-			exposure.TransmissionRisk = ReportTypeTransmissionRisk(claims.ReportType, exposure.TransmissionRisk)
+			exposure.TransmissionRisk = t.transmissionRisk(claims.ReportType, exposure.TransmissionRisk)
 			if claims.HealthAuthorityID > 0 {
 				exposure.SetHealthAuthorityID(claims.HealthAuthorityID)
 			}
+			if claims.CertificateID != "" {
+				exposure.CertificateHash = verification.CertificateHash(claims.CertificateID)
+			}
+		}
+
+		// Run the built-in and custom key validators, like the maximum key age
+		// and the certificate onset window.
+		if err := t.validateKey(ctx, &KeyValidationInput{Exposure: exposure, Claims: claims, BatchTime: batchTime}); err != nil {
+			logger.Debugw("key failed validation", "key", i, "reason", err)
+			var warning *KeyWarning
+			var tooOldErr *errorKeyTooOld
+			if errors.As(err, &warning) {
+				transformWarnings = append(transformWarnings, fmt.Sprintf("key %d %s - saving without this key", i, warning.Reason))
+				if errors.As(err, &tooOldErr) {
+					tooOld++
+				}
+			} else {
+				transformErrors = multierror.Append(transformErrors, fmt.Errorf("key %d cannot be imported: %w", i, err))
+			}
+			continue
 		}
 		// Set days since onset, either from the API or from the verified claims (see above).
 		if onsetInterval > 0 {
@@ -689,6 +968,7 @@ func (t *Transformer) TransformPublish(ctx context.Context, inData *verifyapi.Pu
 
 			// The value is within acceptable range, save it.
 			exposure.SetDaysSinceSymptomOnset(daysSince)
+			exposure.SetSymptomOnsetInterval(onsetInterval)
 		}
 
 		// Check and see many days old the key is.
@@ -704,6 +984,7 @@ func (t *Transformer) TransformPublish(ctx context.Context, inData *verifyapi.Pu
 		// All keys in the batch are invalid.
 		return &TransformPublishResult{
 			Warnings: transformWarnings,
+			TooOld:   tooOld,
 		}, transformErrors.ErrorOrNil()
 	}
 
@@ -763,5 +1044,6 @@ func (t *Transformer) TransformPublish(ctx context.Context, inData *verifyapi.Pu
 		Exposures:   entities,
 		PublishInfo: stats,
 		Warnings:    transformWarnings,
+		TooOld:      tooOld,
 	}, transformErrors.ErrorOrNil()
 }
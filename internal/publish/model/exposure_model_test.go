@@ -51,6 +51,14 @@ type testConfig struct {
 	maxValidSymptomOnsetReportDays uint
 	defaultSymptomOnsetDays        uint
 	debugReleaseSameDay            bool
+	reportTypeTransmissionRisks    map[string]int
+	certOnsetMaxDaysBefore         uint
+	certOnsetMaxDaysAfter          uint
+	rejectCertOnsetOutliers        bool
+	maxExposureKeyAge              time.Duration
+	rejectTooOldExposureKeys       bool
+	maxRegionsPerKey               uint
+	keyValidators                  []string
 }
 
 func (c *testConfig) MaxExposureKeys() uint {
@@ -85,6 +93,38 @@ func (c *testConfig) DebugReleaseSameDayKeys() bool {
 	return c.debugReleaseSameDay
 }
 
+func (c *testConfig) DefaultReportTypeTransmissionRisks() map[string]int {
+	return c.reportTypeTransmissionRisks
+}
+
+func (c *testConfig) CertificateOnsetMaxDaysBefore() uint {
+	return c.certOnsetMaxDaysBefore
+}
+
+func (c *testConfig) CertificateOnsetMaxDaysAfter() uint {
+	return c.certOnsetMaxDaysAfter
+}
+
+func (c *testConfig) RejectCertificateOnsetOutliers() bool {
+	return c.rejectCertOnsetOutliers
+}
+
+func (c *testConfig) MaxExposureKeyAge() time.Duration {
+	return c.maxExposureKeyAge
+}
+
+func (c *testConfig) RejectTooOldExposureKeys() bool {
+	return c.rejectTooOldExposureKeys
+}
+
+func (c *testConfig) MaxRegionsPerKey() uint {
+	return c.maxRegionsPerKey
+}
+
+func (c *testConfig) KeyValidators() []string {
+	return c.keyValidators
+}
+
 func TestIntervalNumber(t *testing.T) {
 	t.Parallel()
 
@@ -152,7 +192,264 @@ func TestInvalidBase64(t *testing.T) {
 	batchTime := time.Date(2020, 3, 1, 10, 43, 1, 0, time.UTC)
 
 	_, err = transformer.TransformPublish(ctx, source, regions, nil, batchTime)
-	errcmp.MustMatch(t, err, `key 0 cannot be imported: illegal base64 data at input byte 4`)
+	errcmp.MustMatch(t, err, `key 0 has invalid encoding: illegal base64 data at input byte 4`)
+
+	var errInvalidEncoding *ErrorKeyInvalidEncoding
+	if !errors.As(err, &errInvalidEncoding) {
+		t.Fatalf("expected %T, got %#v", errInvalidEncoding, err)
+	}
+	if got, want := errInvalidEncoding.Index, 0; got != want {
+		t.Errorf("expected index %d to be %d", got, want)
+	}
+}
+
+func TestInvalidBase64AmongValidKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	transformer, err := NewTransformer(&testConfig{
+		maxExposureKeys:     3,
+		maxSameDayKeys:      3,
+		maxIntervalStartAge: time.Hour * 24 * 5,
+		truncateWindow:      time.Hour,
+		maxSymptomOnsetDays: maxSymptomOnsetDays,
+	})
+	if err != nil {
+		t.Fatalf("error creating transformer: %v", err)
+	}
+
+	batchTime := time.Date(2020, 3, 1, 10, 43, 1, 0, time.UTC)
+	intervalNumber := IntervalNumber(batchTime.Add(-48 * time.Hour))
+	validKeys := [][]byte{generateKey(t), generateKey(t)}
+	source := &verifyapi.Publish{
+		Keys: []verifyapi.ExposureKey{
+			{
+				Key:            encodeKey(validKeys[0]),
+				IntervalNumber: intervalNumber,
+				IntervalCount:  verifyapi.MaxIntervalCount,
+			},
+			{
+				Key:            "not*base64!",
+				IntervalNumber: intervalNumber + verifyapi.MaxIntervalCount,
+				IntervalCount:  verifyapi.MaxIntervalCount,
+			},
+			{
+				Key:            base64.RawURLEncoding.EncodeToString(validKeys[1]),
+				IntervalNumber: intervalNumber + 2*verifyapi.MaxIntervalCount,
+				IntervalCount:  verifyapi.MaxIntervalCount,
+			},
+		},
+		HealthAuthorityID: "State Health Dept",
+	}
+
+	result, err := transformer.TransformPublish(ctx, source, []string{"US"}, nil, batchTime)
+	errcmp.MustMatch(t, err, `key 1 has invalid encoding: illegal base64 data at input byte 3`)
+
+	var errInvalidEncoding *ErrorKeyInvalidEncoding
+	if !errors.As(err, &errInvalidEncoding) {
+		t.Fatalf("expected %T, got %#v", errInvalidEncoding, err)
+	}
+	if got, want := errInvalidEncoding.Index, 1; got != want {
+		t.Errorf("expected index %d to be %d", got, want)
+	}
+
+	// The valid keys are still transformed.
+	got := make([][]byte, 0, len(result.Exposures))
+	for _, e := range result.Exposures {
+		got = append(got, e.ExposureKey)
+	}
+	if diff := cmp.Diff(validKeys, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestInvalidKeyLength(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	transformer, err := NewTransformer(&testConfig{
+		maxExposureKeys:     3,
+		maxSameDayKeys:      3,
+		maxIntervalStartAge: time.Hour * 24 * 5,
+		truncateWindow:      time.Hour,
+		maxSymptomOnsetDays: maxSymptomOnsetDays,
+	})
+	if err != nil {
+		t.Fatalf("error creating transformer: %v", err)
+	}
+
+	batchTime := time.Date(2020, 3, 1, 10, 43, 1, 0, time.UTC)
+	intervalNumber := IntervalNumber(batchTime.Add(-72 * time.Hour))
+	validKey := generateKey(t)
+	source := &verifyapi.Publish{
+		Keys: []verifyapi.ExposureKey{
+			{
+				Key:            encodeKey(validKey[:verifyapi.KeyLength-1]),
+				IntervalNumber: intervalNumber,
+				IntervalCount:  verifyapi.MaxIntervalCount,
+			},
+			{
+				Key:            encodeKey(append(generateKey(t), 0x01)),
+				IntervalNumber: intervalNumber + verifyapi.MaxIntervalCount,
+				IntervalCount:  verifyapi.MaxIntervalCount,
+			},
+			{
+				Key:            encodeKey(validKey),
+				IntervalNumber: intervalNumber + 2*verifyapi.MaxIntervalCount,
+				IntervalCount:  verifyapi.MaxIntervalCount,
+			},
+		},
+		HealthAuthorityID: "State Health Dept",
+	}
+
+	result, err := transformer.TransformPublish(ctx, source, []string{"US"}, nil, batchTime)
+	errcmp.MustMatch(t, err, `key 0 has invalid length 15, must be 16 bytes`)
+	errcmp.MustMatch(t, err, `key 1 has invalid length 17, must be 16 bytes`)
+
+	var errInvalidLength *ErrorKeyInvalidLength
+	if !errors.As(err, &errInvalidLength) {
+		t.Fatalf("expected %T, got %#v", errInvalidLength, err)
+	}
+	if got, want := errInvalidLength.Index, 0; got != want {
+		t.Errorf("expected index %d to be %d", got, want)
+	}
+
+	// The correct length key is still transformed.
+	if got, want := len(result.Exposures), 1; got != want {
+		t.Fatalf("expected %d exposures, got %d", want, got)
+	}
+	if diff := cmp.Diff(validKey, result.Exposures[0].ExposureKey); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestValidateExposureKeyLengths(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		key  string
+		err  string
+	}{
+		{
+			name: "short",
+			key:  encodeKey(make([]byte, verifyapi.KeyLength-1)),
+			err:  "key 0 has invalid length 15, must be 16 bytes",
+		},
+		{
+			name: "long",
+			key:  encodeKey(make([]byte, verifyapi.KeyLength+16)),
+			err:  "key 0 has invalid length 32, must be 16 bytes",
+		},
+		{
+			name: "correct",
+			key:  encodeKey(make([]byte, verifyapi.KeyLength)),
+		},
+		{
+			// Encoding errors are reported by the transform.
+			name: "invalid_encoding",
+			key:  "not*base64!",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := ValidateExposureKeyLengths([]verifyapi.ExposureKey{{Key: tc.key}})
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			errcmp.MustMatch(t, err, tc.err)
+
+			var errInvalidLength *ErrorKeyInvalidLength
+			if !errors.As(err, &errInvalidLength) {
+				t.Fatalf("expected %T, got %#v", errInvalidLength, err)
+			}
+		})
+	}
+}
+
+func TestDecodeExposureKey(t *testing.T) {
+	t.Parallel()
+
+	// 0xfb 0xff produces both '+' and '/' in the standard alphabet.
+	raw := []byte{0xfb, 0xff, 0xbf, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d}
+	std := base64.StdEncoding.EncodeToString(raw)
+
+	cases := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{
+			name:  "std_padded",
+			input: std,
+		},
+		{
+			name:  "std_unpadded",
+			input: base64.RawStdEncoding.EncodeToString(raw),
+		},
+		{
+			name:  "url_padded",
+			input: base64.URLEncoding.EncodeToString(raw),
+		},
+		{
+			name:  "url_unpadded",
+			input: base64.RawURLEncoding.EncodeToString(raw),
+		},
+		{
+			name:  "empty",
+			input: "",
+			err:   "key is empty",
+		},
+		{
+			name:  "mixed_alphabets",
+			input: "-" + std[1:],
+			err:   "key mixes standard and URL-safe base64 alphabets",
+		},
+		{
+			name:  "short_padding",
+			input: std[:len(std)-1],
+			err:   "illegal base64 data at input byte 23",
+		},
+		{
+			name:  "extra_padding",
+			input: std + "=",
+			err:   "illegal base64 data at input byte 24",
+		},
+		{
+			name:  "line_break",
+			input: std[:8] + "\n" + std[8:],
+			err:   "key contains line breaks",
+		},
+		{
+			name:  "invalid_character",
+			input: "*" + std[1:],
+			err:   "illegal base64 data at input byte 0",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := DecodeExposureKey(tc.input)
+			errcmp.MustMatch(t, err, tc.err)
+			if tc.err == "" {
+				if diff := cmp.Diff(raw, got); diff != "" {
+					t.Errorf("mismatch (-want, +got):\n%s", diff)
+				}
+			}
+		})
+	}
 }
 
 func TestDifferentEncodings(t *testing.T) {
@@ -252,7 +549,7 @@ func TestPublishValidation(t *testing.T) {
 					{Key: encodeKey(generateKey(t)[0 : verifyapi.KeyLength-2])},
 				},
 			},
-			m: fmt.Sprintf("invalid key length, %v, must be %v", verifyapi.KeyLength-2, verifyapi.KeyLength),
+			m: fmt.Sprintf("key 0 has invalid length %v, must be %v bytes", verifyapi.KeyLength-2, verifyapi.KeyLength),
 		},
 		{
 			name: "interval count too small",
@@ -488,6 +785,462 @@ func TestReportTypeToTransmissionRisk(t *testing.T) {
 	}
 }
 
+func TestTransformReportTypeTransmissionRiskDefaults(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	batchTime := time.Date(2020, 3, 7, 11, 15, 1, 0, time.UTC)
+	intervalNumber := IntervalNumber(batchTime.Add(-48 * time.Hour))
+
+	transformer, err := NewTransformer(&testConfig{
+		maxExposureKeys:                10,
+		maxSameDayKeys:                 1,
+		maxIntervalStartAge:            14 * 24 * time.Hour,
+		truncateWindow:                 time.Hour,
+		maxSymptomOnsetDays:            maxSymptomOnsetDays,
+		maxValidSymptomOnsetReportDays: maxValidSymptomOnsetReportDays,
+		defaultSymptomOnsetDays:        4,
+		reportTypeTransmissionRisks: map[string]int{
+			verifyapi.ReportTypeConfirmed:  3,
+			verifyapi.ReportTypeSelfReport: 6,
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewTransformer returned unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		name       string
+		reportType string
+		inTR       int
+		wantTR     int
+	}{
+		{"confirmed_default", verifyapi.ReportTypeConfirmed, 0, 3},
+		{"self_report_default", verifyapi.ReportTypeSelfReport, 0, 6},
+		{"not_configured_builtin", verifyapi.ReportTypeClinical, 0, verifyapi.TransmissionRiskClinical},
+		{"confirmed_client_provided", verifyapi.ReportTypeConfirmed, 7, 7},
+		{"self_report_client_provided", verifyapi.ReportTypeSelfReport, 1, 1},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			publish := &verifyapi.Publish{
+				Keys: []verifyapi.ExposureKey{
+					{
+						Key:              encodeKey(generateKey(t)),
+						IntervalNumber:   intervalNumber,
+						IntervalCount:    verifyapi.MaxIntervalCount,
+						TransmissionRisk: tc.inTR,
+					},
+				},
+				HealthAuthorityID: "State Health Dept",
+			}
+			claims := &verification.VerifiedClaims{
+				ReportType: tc.reportType,
+			}
+
+			result, err := transformer.TransformPublish(ctx, publish, []string{"US"}, claims, batchTime)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if l := len(result.Exposures); l != 1 {
+				t.Fatalf("expected 1 exposure, got %d", l)
+			}
+			if got := result.Exposures[0].TransmissionRisk; got != tc.wantTR {
+				t.Errorf("wrong transmission risk, want: %v got %v", tc.wantTR, got)
+			}
+		})
+	}
+}
+
+func TestTransformCertificateHash(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	batchTime := time.Date(2020, 3, 7, 11, 15, 1, 0, time.UTC)
+	intervalNumber := IntervalNumber(batchTime.Add(-48 * time.Hour))
+
+	transformer, err := NewTransformer(&testConfig{
+		maxExposureKeys:                10,
+		maxSameDayKeys:                 1,
+		maxIntervalStartAge:            14 * 24 * time.Hour,
+		truncateWindow:                 time.Hour,
+		maxSymptomOnsetDays:            maxSymptomOnsetDays,
+		maxValidSymptomOnsetReportDays: maxValidSymptomOnsetReportDays,
+	})
+	if err != nil {
+		t.Fatalf("NewTransformer returned unexpected error: %v", err)
+	}
+
+	certificateID := verification.JTICertificateID("doh.mystate.gov", "abc123")
+	cases := []struct {
+		name   string
+		claims *verification.VerifiedClaims
+		want   string
+	}{
+		{"no_claims", nil, ""},
+		{"no_certificate_id", &verification.VerifiedClaims{ReportType: verifyapi.ReportTypeConfirmed}, ""},
+		{"certificate_id", &verification.VerifiedClaims{ReportType: verifyapi.ReportTypeConfirmed, CertificateID: certificateID}, verification.CertificateHash(certificateID)},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			publish := &verifyapi.Publish{
+				Keys: []verifyapi.ExposureKey{
+					{
+						Key:            encodeKey(generateKey(t)),
+						IntervalNumber: intervalNumber,
+						IntervalCount:  verifyapi.MaxIntervalCount,
+					},
+				},
+				HealthAuthorityID: "State Health Dept",
+			}
+
+			result, err := transformer.TransformPublish(ctx, publish, []string{"US"}, tc.claims, batchTime)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if l := len(result.Exposures); l != 1 {
+				t.Fatalf("expected 1 exposure, got %d", l)
+			}
+			if got := result.Exposures[0].CertificateHash; got != tc.want {
+				t.Errorf("wrong certificate hash, want: %q got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestTransformCertificateOnsetWindow(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	batchTime := time.Date(2020, 3, 20, 11, 15, 1, 0, time.UTC)
+	onset := timeutils.UTCMidnight(batchTime).Add(-10 * 24 * time.Hour)
+	onsetInterval := IntervalNumber(onset)
+	dayInterval := func(days int) int32 {
+		return onsetInterval + int32(days)*verifyapi.MaxIntervalCount
+	}
+
+	// Keys relative to the onset day.
+	keyDays := []int{-3, -1, 2, 6}
+	publish := &verifyapi.Publish{
+		HealthAuthorityID: "State Health Dept",
+	}
+	for _, d := range keyDays {
+		publish.Keys = append(publish.Keys, verifyapi.ExposureKey{
+			Key:            encodeKey(generateKey(t)),
+			IntervalNumber: dayInterval(d),
+			IntervalCount:  verifyapi.MaxIntervalCount,
+		})
+	}
+	claims := &verification.VerifiedClaims{
+		ReportType:           verifyapi.ReportTypeConfirmed,
+		SymptomOnsetInterval: uint32(onsetInterval),
+	}
+
+	cases := []struct {
+		name          string
+		reject        bool
+		certClaims    *verification.VerifiedClaims
+		wantIntervals []int32
+		wantWarnings  int
+		wantErr       string
+	}{
+		{
+			name:          "drop_outliers",
+			certClaims:    claims,
+			wantIntervals: []int32{dayInterval(-1), dayInterval(2)},
+			wantWarnings:  2,
+		},
+		{
+			name:          "reject_outliers",
+			reject:        true,
+			certClaims:    claims,
+			wantIntervals: []int32{dayInterval(-1), dayInterval(2)},
+			wantErr:       "starts 3 days before the certificate symptom onset",
+		},
+		{
+			name: "no_certificate_onset",
+			certClaims: &verification.VerifiedClaims{
+				ReportType: verifyapi.ReportTypeConfirmed,
+			},
+			wantIntervals: []int32{dayInterval(-3), dayInterval(-1), dayInterval(2), dayInterval(6)},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			transformer, err := NewTransformer(&testConfig{
+				maxExposureKeys:                10,
+				maxSameDayKeys:                 1,
+				maxIntervalStartAge:            14 * 24 * time.Hour,
+				truncateWindow:                 time.Hour,
+				maxSymptomOnsetDays:            maxSymptomOnsetDays,
+				maxValidSymptomOnsetReportDays: maxValidSymptomOnsetReportDays,
+				defaultSymptomOnsetDays:        4,
+				certOnsetMaxDaysBefore:         2,
+				certOnsetMaxDaysAfter:          5,
+				rejectCertOnsetOutliers:        tc.reject,
+			})
+			if err != nil {
+				t.Fatalf("NewTransformer returned unexpected error: %v", err)
+			}
+
+			result, err := transformer.TransformPublish(ctx, publish, []string{"US"}, tc.certClaims, batchTime)
+			errcmp.MustMatch(t, err, tc.wantErr)
+
+			got := make([]int32, 0, len(result.Exposures))
+			for _, e := range result.Exposures {
+				got = append(got, e.IntervalNumber)
+			}
+			if diff := cmp.Diff(tc.wantIntervals, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+			if l := len(result.Warnings); l != tc.wantWarnings {
+				t.Errorf("expected %d warnings, got %d: %v", tc.wantWarnings, l, result.Warnings)
+			}
+		})
+	}
+}
+
+func TestTransformMaxExposureKeyAge(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	batchTime := time.Date(2020, 3, 20, 11, 15, 1, 0, time.UTC)
+	dayInterval := func(daysAgo int) int32 {
+		return IntervalNumber(timeutils.UTCMidnight(batchTime)) - int32(daysAgo)*verifyapi.MaxIntervalCount
+	}
+
+	// Keys by days ago, all within the max interval start age.
+	keyDays := []int{12, 10, 3, 1}
+	publish := &verifyapi.Publish{
+		HealthAuthorityID: "State Health Dept",
+	}
+	for _, d := range keyDays {
+		publish.Keys = append(publish.Keys, verifyapi.ExposureKey{
+			Key:            encodeKey(generateKey(t)),
+			IntervalNumber: dayInterval(d),
+			IntervalCount:  verifyapi.MaxIntervalCount,
+		})
+	}
+
+	cases := []struct {
+		name          string
+		maxAge        time.Duration
+		reject        bool
+		wantIntervals []int32
+		wantTooOld    int
+		wantWarnings  int
+		wantErr       string
+	}{
+		{
+			name:          "disabled",
+			wantIntervals: []int32{dayInterval(12), dayInterval(10), dayInterval(3), dayInterval(1)},
+		},
+		{
+			name:          "drop_old_keys",
+			maxAge:        7 * 24 * time.Hour,
+			wantIntervals: []int32{dayInterval(3), dayInterval(1)},
+			wantTooOld:    2,
+			wantWarnings:  2,
+		},
+		{
+			name:          "reject_old_keys",
+			maxAge:        7 * 24 * time.Hour,
+			reject:        true,
+			wantIntervals: []int32{dayInterval(3), dayInterval(1)},
+			wantErr:       "older than the maximum key age of 168h0m0s",
+		},
+		{
+			name:          "fresh_keys_kept",
+			maxAge:        14 * 24 * time.Hour,
+			wantIntervals: []int32{dayInterval(12), dayInterval(10), dayInterval(3), dayInterval(1)},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			transformer, err := NewTransformer(&testConfig{
+				maxExposureKeys:                10,
+				maxSameDayKeys:                 1,
+				maxIntervalStartAge:            14 * 24 * time.Hour,
+				truncateWindow:                 time.Hour,
+				maxSymptomOnsetDays:            maxSymptomOnsetDays,
+				maxValidSymptomOnsetReportDays: maxValidSymptomOnsetReportDays,
+				defaultSymptomOnsetDays:        4,
+				maxExposureKeyAge:              tc.maxAge,
+				rejectTooOldExposureKeys:       tc.reject,
+			})
+			if err != nil {
+				t.Fatalf("NewTransformer returned unexpected error: %v", err)
+			}
+
+			result, err := transformer.TransformPublish(ctx, publish, []string{"US"}, nil, batchTime)
+			errcmp.MustMatch(t, err, tc.wantErr)
+
+			got := make([]int32, 0, len(result.Exposures))
+			for _, e := range result.Exposures {
+				got = append(got, e.IntervalNumber)
+			}
+			if diff := cmp.Diff(tc.wantIntervals, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+			if result.TooOld != tc.wantTooOld {
+				t.Errorf("expected %d too old keys, got %d", tc.wantTooOld, result.TooOld)
+			}
+			if l := len(result.Warnings); l != tc.wantWarnings {
+				t.Errorf("expected %d warnings, got %d: %v", tc.wantWarnings, l, result.Warnings)
+			}
+		})
+	}
+}
+
+func TestTransformMaxRegionsPerKey(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	batchTime := time.Date(2020, 3, 20, 11, 15, 1, 0, time.UTC)
+	publish := &verifyapi.Publish{
+		HealthAuthorityID: "State Health Dept",
+		Keys: []verifyapi.ExposureKey{
+			{
+				Key:            encodeKey(generateKey(t)),
+				IntervalNumber: IntervalNumber(timeutils.UTCMidnight(batchTime)) - 2*verifyapi.MaxIntervalCount,
+				IntervalCount:  verifyapi.MaxIntervalCount,
+			},
+			{
+				Key:            encodeKey(generateKey(t)),
+				IntervalNumber: IntervalNumber(timeutils.UTCMidnight(batchTime)) - verifyapi.MaxIntervalCount,
+				IntervalCount:  verifyapi.MaxIntervalCount,
+			},
+		},
+	}
+
+	cases := []struct {
+		name     string
+		max      uint
+		regions  []string
+		wantKeys int
+		wantErr  string
+	}{
+		{
+			name:     "disabled",
+			regions:  []string{"US", "CA", "MX"},
+			wantKeys: 2,
+		},
+		{
+			name:     "at_limit",
+			max:      3,
+			regions:  []string{"US", "CA", "MX"},
+			wantKeys: 2,
+		},
+		{
+			name:    "over_limit",
+			max:     2,
+			regions: []string{"US", "CA", "MX"},
+			wantErr: "key 1 has 3 regions, must be at most 2",
+		},
+		{
+			// Keys without regions are left to the missing region policy.
+			name:     "no_regions",
+			max:      2,
+			wantKeys: 2,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			transformer, err := NewTransformer(&testConfig{
+				maxExposureKeys:                10,
+				maxSameDayKeys:                 1,
+				maxIntervalStartAge:            14 * 24 * time.Hour,
+				truncateWindow:                 time.Hour,
+				maxSymptomOnsetDays:            maxSymptomOnsetDays,
+				maxValidSymptomOnsetReportDays: maxValidSymptomOnsetReportDays,
+				defaultSymptomOnsetDays:        4,
+				maxRegionsPerKey:               tc.max,
+			})
+			if err != nil {
+				t.Fatalf("NewTransformer returned unexpected error: %v", err)
+			}
+
+			result, err := transformer.TransformPublish(ctx, publish, tc.regions, nil, batchTime)
+			errcmp.MustMatch(t, err, tc.wantErr)
+			if got := len(result.Exposures); got != tc.wantKeys {
+				t.Errorf("expected %d keys to be %d", got, tc.wantKeys)
+			}
+
+			if tc.wantErr != "" {
+				var errTooManyRegions *ErrorKeyTooManyRegions
+				if !errors.As(err, &errTooManyRegions) {
+					t.Fatalf("expected %v to be an ErrorKeyTooManyRegions", err)
+				}
+				if got, want := errTooManyRegions.Count, len(tc.regions); got != want {
+					t.Errorf("expected count %d to be %d", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewTransformer_InvalidTransmissionRiskDefaults(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		risks   map[string]int
+		message string
+	}{
+		{"valid", map[string]int{verifyapi.ReportTypeConfirmed: 2}, ""},
+		{"invalid_report_type", map[string]int{"positive": 2}, "invalid report type for default transmission risk"},
+		{"too_high", map[string]int{verifyapi.ReportTypeConfirmed: 9}, "default transmission risk for \"confirmed\" must be"},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewTransformer(&testConfig{
+				maxExposureKeys:             1,
+				maxSameDayKeys:              1,
+				maxIntervalStartAge:         time.Hour,
+				truncateWindow:              time.Hour,
+				maxSymptomOnsetDays:         maxSymptomOnsetDays,
+				reportTypeTransmissionRisks: tc.risks,
+			})
+			errcmp.MustMatch(t, err, tc.message)
+		})
+	}
+}
+
 func intPtr(v int) *int              { return &v }
 func int32Ptr(v int32) *int32        { return &v }
 func int64Ptr(v int64) *int64        { return &v }
@@ -1041,7 +1794,9 @@ func TestTransform(t *testing.T) {
 				}
 			}
 
-			if diff := cmp.Diff(tc.Want, result.Exposures, cmpopts.IgnoreUnexported(Exposure{})); diff != "" {
+			// The stored symptom onset interval is covered by TestDefaultSymptomOnset.
+			ignoreOnsetInterval := cmpopts.IgnoreFields(Exposure{}, "SymptomOnsetInterval")
+			if diff := cmp.Diff(tc.Want, result.Exposures, cmpopts.IgnoreUnexported(Exposure{}), ignoreOnsetInterval); diff != "" {
 				t.Errorf("TransformPublish mismatch (-want +got):\n%v", diff)
 			}
 			if diff := cmp.Diff(tc.WantStats, result.PublishInfo, cmpopts.IgnoreUnexported(Exposure{})); diff != "" {
@@ -1139,6 +1894,11 @@ func TestDefaultSymptomOnset(t *testing.T) {
 			if diff := cmp.Diff(result.Exposures[0].DaysSinceSymptomOnset, tc.wantDaysSinceOnset); diff != "" {
 				t.Errorf("mismatch (-want, +got):\n%s", diff)
 			}
+
+			wantOnsetInterval := IntervalNumber(timeutils.SubtractDays(now, onsetDaysAgo))
+			if got := result.Exposures[0].SymptomOnsetInterval; got == nil || *got != wantOnsetInterval {
+				t.Errorf("expected symptom onset interval %d, got %v", wantOnsetInterval, got)
+			}
 		})
 	}
 }
@@ -1649,6 +2409,172 @@ func TestReviseKeys(t *testing.T) {
 	}
 }
 
+func TestExposure_ReviseIfHigherAuthority(t *testing.T) {
+	t.Parallel()
+
+	createdAt := time.Now().UTC().Add(-2 * time.Hour).Truncate(time.Hour)
+	revisedAt := time.Now().UTC().Truncate(time.Hour)
+
+	cases := []struct {
+		name     string
+		existing string
+		revised  string
+		incoming string
+		// want is the report type of the key afterwards.
+		want        string
+		wantRevised bool
+		err         string
+	}{
+		{
+			name:        "self_report_to_likely",
+			existing:    verifyapi.ReportTypeSelfReport,
+			incoming:    verifyapi.ReportTypeClinical,
+			want:        verifyapi.ReportTypeClinical,
+			wantRevised: true,
+		},
+		{
+			name:        "likely_to_confirmed",
+			existing:    verifyapi.ReportTypeClinical,
+			incoming:    verifyapi.ReportTypeConfirmed,
+			want:        verifyapi.ReportTypeConfirmed,
+			wantRevised: true,
+		},
+		{
+			name:        "empty_to_confirmed",
+			existing:    "",
+			incoming:    verifyapi.ReportTypeConfirmed,
+			want:        verifyapi.ReportTypeConfirmed,
+			wantRevised: true,
+		},
+		{
+			name:        "likely_to_negative",
+			existing:    verifyapi.ReportTypeClinical,
+			incoming:    verifyapi.ReportTypeNegative,
+			want:        verifyapi.ReportTypeNegative,
+			wantRevised: true,
+		},
+		{
+			name:     "likely_to_self_report",
+			existing: verifyapi.ReportTypeClinical,
+			incoming: verifyapi.ReportTypeSelfReport,
+			want:     verifyapi.ReportTypeClinical,
+		},
+		{
+			// Revise would fail on this transition.
+			name:     "confirmed_to_likely",
+			existing: verifyapi.ReportTypeConfirmed,
+			incoming: verifyapi.ReportTypeClinical,
+			want:     verifyapi.ReportTypeConfirmed,
+		},
+		{
+			name:     "confirmed_to_negative",
+			existing: verifyapi.ReportTypeConfirmed,
+			incoming: verifyapi.ReportTypeNegative,
+			want:     verifyapi.ReportTypeConfirmed,
+		},
+		{
+			name:     "revised_to_confirmed_then_likely",
+			existing: verifyapi.ReportTypeClinical,
+			revised:  verifyapi.ReportTypeConfirmed,
+			incoming: verifyapi.ReportTypeClinical,
+			want:     verifyapi.ReportTypeConfirmed,
+		},
+		{
+			// There can only be one revision.
+			name:     "revised_to_likely_then_confirmed",
+			existing: verifyapi.ReportTypeSelfReport,
+			revised:  verifyapi.ReportTypeClinical,
+			incoming: verifyapi.ReportTypeConfirmed,
+			want:     verifyapi.ReportTypeClinical,
+			err:      ErrorKeyAlreadyRevised.Error(),
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			key := []byte{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}
+			existing := &Exposure{
+				ExposureKey:     key,
+				Regions:         []string{"US"},
+				IntervalNumber:  7,
+				IntervalCount:   144,
+				CreatedAt:       createdAt,
+				LocalProvenance: true,
+				ReportType:      tc.existing,
+			}
+			if tc.revised != "" {
+				existing.RevisedReportType = stringPtr(tc.revised)
+				existing.RevisedAt = &createdAt
+			}
+			incoming := &Exposure{
+				ExposureKey:     key,
+				Regions:         []string{"US"},
+				IntervalNumber:  7,
+				IntervalCount:   144,
+				CreatedAt:       revisedAt,
+				LocalProvenance: true,
+				ReportType:      tc.incoming,
+			}
+
+			revised, err := existing.ReviseIfHigherAuthority(incoming)
+			errcmp.MustMatch(t, err, tc.err)
+			if got, want := revised, tc.wantRevised; got != want {
+				t.Errorf("expected revised to be %t, got %t", want, got)
+			}
+
+			got := existing.ReportType
+			if existing.RevisedReportType != nil {
+				got = *existing.RevisedReportType
+			}
+			if got == "" {
+				got = verifyapi.ReportTypeClinical
+			}
+			if got != tc.want {
+				t.Errorf("expected report type %q to be %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReviseKeysHighestAuthority(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	createdAt := time.Now().UTC().Add(-2 * time.Hour).Truncate(time.Hour)
+
+	confirmed := &Exposure{
+		ExposureKey:     []byte{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1},
+		IntervalNumber:  7,
+		IntervalCount:   144,
+		CreatedAt:       createdAt,
+		LocalProvenance: true,
+		ReportType:      verifyapi.ReportTypeConfirmed,
+	}
+	existing := map[string]*Exposure{confirmed.ExposureKeyBase64(): confirmed}
+
+	likely := *confirmed
+	likely.ReportType = verifyapi.ReportTypeClinical
+
+	// ReviseKeys rejects the downgrade, ReviseKeysHighestAuthority ignores it.
+	if _, err := ReviseKeys(ctx, existing, []*Exposure{&likely}); err == nil {
+		t.Errorf("expected error")
+	}
+	got, err := ReviseKeysHighestAuthority(ctx, existing, []*Exposure{&likely})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("expected no keys to be revised, got %d", len(got))
+	}
+	if confirmed.RevisedReportType != nil {
+		t.Errorf("expected existing key to be unchanged, revised to %q", *confirmed.RevisedReportType)
+	}
+}
+
 func TestExposureReview(t *testing.T) {
 	t.Parallel()
 
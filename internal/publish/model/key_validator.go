@@ -0,0 +1,207 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/verification"
+)
+
+// KeyValidator validates a single exposure key of a publish request in
+// TransformPublish. Keys are validated after they are decoded and the verified
+// claims are applied, and before the symptom onset is set.
+type KeyValidator interface {
+	// ValidateKey returns nil if the key is valid. Otherwise the error is the
+	// reason the key is invalid, and the key is treated like any other invalid
+	// key. If the error is a *KeyWarning, the key is dropped with the reason as
+	// a warning instead.
+	ValidateKey(ctx context.Context, in *KeyValidationInput) error
+}
+
+// KeyValidatorFunc is a func that implements KeyValidator.
+type KeyValidatorFunc func(ctx context.Context, in *KeyValidationInput) error
+
+// ValidateKey calls f.
+func (f KeyValidatorFunc) ValidateKey(ctx context.Context, in *KeyValidationInput) error {
+	return f(ctx, in)
+}
+
+// KeyValidationInput is a key to validate and the publish request it is part
+// of.
+type KeyValidationInput struct {
+	Exposure *Exposure
+	// Claims are the verified claims of the request, which may be nil.
+	Claims *verification.VerifiedClaims
+	// BatchTime is the time the publish request is processed at.
+	BatchTime time.Time
+}
+
+// KeyWarning is returned by a KeyValidator to drop the key from the publish
+// request with a warning, without treating it as invalid.
+type KeyWarning struct {
+	Reason string
+	err    error
+}
+
+func (w *KeyWarning) Error() string {
+	return w.Reason
+}
+
+// Unwrap returns the underlying error, if any.
+func (w *KeyWarning) Unwrap() error {
+	return w.err
+}
+
+// keyValidators is the list of registered key validators.
+var (
+	keyValidators     = make(map[string]KeyValidator)
+	keyValidatorsLock sync.RWMutex
+)
+
+// RegisterKeyValidator registers a custom key validator with the given name.
+// Registered validators are used by transformers that are configured with
+// the name, after the built-in validators. If a key validator is already
+// registered with the given name, it panics. Key validators are usually
+// registered via an init function.
+func RegisterKeyValidator(name string, v KeyValidator) {
+	keyValidatorsLock.Lock()
+	defer keyValidatorsLock.Unlock()
+
+	if _, ok := keyValidators[name]; ok {
+		panic(fmt.Sprintf("key validator %q is already registered", name))
+	}
+	keyValidators[name] = v
+}
+
+// RegisteredKeyValidators returns the list of the names of the registered key
+// validators.
+func RegisteredKeyValidators() []string {
+	keyValidatorsLock.RLock()
+	defer keyValidatorsLock.RUnlock()
+
+	list := make([]string, 0, len(keyValidators))
+	for k := range keyValidators {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// keyValidatorsFor returns the registered key validators with the given
+// names, or an error if one does not exist.
+func keyValidatorsFor(names []string) ([]KeyValidator, error) {
+	keyValidatorsLock.RLock()
+	defer keyValidatorsLock.RUnlock()
+
+	validators := make([]KeyValidator, 0, len(names))
+	for _, name := range names {
+		v, ok := keyValidators[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or uncompiled key validator %q", name)
+		}
+		validators = append(validators, v)
+	}
+	return validators, nil
+}
+
+// defaultKeyValidators returns the built-in key validators for the config.
+func defaultKeyValidators(config TransformerConfig) []KeyValidator {
+	return []KeyValidator{
+		&maxKeyAgeValidator{
+			maxAge: config.MaxExposureKeyAge(),
+			reject: config.RejectTooOldExposureKeys(),
+		},
+		&certificateOnsetValidator{
+			maxDaysBefore: config.CertificateOnsetMaxDaysBefore(),
+			maxDaysAfter:  config.CertificateOnsetMaxDaysAfter(),
+			reject:        config.RejectCertificateOnsetOutliers(),
+		},
+	}
+}
+
+// errorKeyTooOld is the reason a key is older than the maximum key age, so
+// that the dropped keys can be counted.
+type errorKeyTooOld struct {
+	msg string
+}
+
+func (e *errorKeyTooOld) Error() string {
+	return e.msg
+}
+
+// maxKeyAgeValidator checks that keys don't start more than maxAge before the
+// batch time. A maxAge of 0 disables the check. Too old keys are dropped with
+// a warning, unless reject is set.
+type maxKeyAgeValidator struct {
+	maxAge time.Duration
+	reject bool
+}
+
+func (v *maxKeyAgeValidator) ValidateKey(ctx context.Context, in *KeyValidationInput) error {
+	if v.maxAge <= 0 {
+		return nil
+	}
+	if minKeyInterval := IntervalNumber(in.BatchTime.Add(-1 * v.maxAge)); in.Exposure.IntervalNumber >= minKeyInterval {
+		return nil
+	}
+
+	err := &errorKeyTooOld{
+		msg: fmt.Sprintf("starts at interval %d, older than the maximum key age of %v", in.Exposure.IntervalNumber, v.maxAge),
+	}
+	if v.reject {
+		return err
+	}
+	return &KeyWarning{Reason: err.msg, err: err}
+}
+
+// certificateOnsetValidator checks that keys start within the window around
+// the symptom onset interval in the verification certificate. Keys are not
+// checked if the certificate doesn't contain a symptom onset interval, and a
+// value of 0 disables that side of the window. Outliers are dropped with a
+// warning, unless reject is set.
+type certificateOnsetValidator struct {
+	maxDaysBefore uint
+	maxDaysAfter  uint
+	reject        bool
+}
+
+func (v *certificateOnsetValidator) ValidateKey(ctx context.Context, in *KeyValidationInput) error {
+	claims := in.Claims
+	if claims == nil || claims.SymptomOnsetInterval == 0 {
+		return nil
+	}
+
+	var msg string
+	days := DaysBetweenIntervals(int32(claims.SymptomOnsetInterval), in.Exposure.IntervalNumber)
+	if max := v.maxDaysBefore; max > 0 && days < -int32(max) {
+		msg = fmt.Sprintf("starts %d days before the certificate symptom onset, max of %d is allowed", -days, max)
+	} else if max := v.maxDaysAfter; max > 0 && days > int32(max) {
+		msg = fmt.Sprintf("starts %d days after the certificate symptom onset, max of %d is allowed", days, max)
+	}
+	if msg == "" {
+		return nil
+	}
+
+	if v.reject {
+		return errors.New(msg)
+	}
+	return &KeyWarning{Reason: msg}
+}
@@ -0,0 +1,159 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/project"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
+	"github.com/google/exposure-notifications-server/pkg/errcmp"
+	"github.com/google/exposure-notifications-server/pkg/timeutils"
+)
+
+func init() {
+	// Rejects keys for the region "XX" and drops keys for "YY".
+	RegisterKeyValidator("test-region", KeyValidatorFunc(func(ctx context.Context, in *KeyValidationInput) error {
+		for _, r := range in.Exposure.Regions {
+			switch r {
+			case "XX":
+				return fmt.Errorf("region %s is not accepted", r)
+			case "YY":
+				return &KeyWarning{Reason: fmt.Sprintf("region %s is ignored", r)}
+			}
+		}
+		return nil
+	}))
+}
+
+func TestTransformKeyValidators(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	batchTime := time.Date(2020, 3, 20, 11, 15, 1, 0, time.UTC)
+	publish := &verifyapi.Publish{
+		HealthAuthorityID: "State Health Dept",
+		Keys: []verifyapi.ExposureKey{
+			{
+				Key:            encodeKey(generateKey(t)),
+				IntervalNumber: IntervalNumber(timeutils.UTCMidnight(batchTime)) - 2*verifyapi.MaxIntervalCount,
+				IntervalCount:  verifyapi.MaxIntervalCount,
+			},
+		},
+	}
+
+	cases := []struct {
+		name         string
+		validators   []string
+		regions      []string
+		wantKeys     int
+		wantWarnings []string
+		wantErr      string
+	}{
+		{
+			name:     "accepted",
+			regions:  []string{"US"},
+			wantKeys: 1,
+		},
+		{
+			name:       "accepted_region",
+			validators: []string{"test-region"},
+			regions:    []string{"US"},
+			wantKeys:   1,
+		},
+		{
+			name:     "not_configured",
+			regions:  []string{"XX"},
+			wantKeys: 1,
+		},
+		{
+			name:       "rejected_region",
+			validators: []string{"test-region"},
+			regions:    []string{"XX"},
+			wantErr:    "key 0 cannot be imported: region XX is not accepted",
+		},
+		{
+			name:         "dropped_region",
+			validators:   []string{"test-region"},
+			regions:      []string{"YY"},
+			wantWarnings: []string{"key 0 region YY is ignored - saving without this key"},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			transformer, err := NewTransformer(&testConfig{
+				maxExposureKeys:                10,
+				maxSameDayKeys:                 1,
+				maxIntervalStartAge:            14 * 24 * time.Hour,
+				truncateWindow:                 time.Hour,
+				maxSymptomOnsetDays:            maxSymptomOnsetDays,
+				maxValidSymptomOnsetReportDays: maxValidSymptomOnsetReportDays,
+				defaultSymptomOnsetDays:        4,
+				keyValidators:                  tc.validators,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := transformer.TransformPublish(ctx, publish, tc.regions, nil, batchTime)
+			errcmp.MustMatch(t, err, tc.wantErr)
+
+			if got, want := len(result.Exposures), tc.wantKeys; got != want {
+				t.Errorf("expected %d keys, got %d", want, got)
+			}
+			if got, want := len(result.Warnings), len(tc.wantWarnings); got != want {
+				t.Fatalf("expected %d warnings, got %d: %v", want, got, result.Warnings)
+			}
+			for i, want := range tc.wantWarnings {
+				if got := result.Warnings[i]; got != want {
+					t.Errorf("expected warning %q to be %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewTransformer_UnknownKeyValidator(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewTransformer(&testConfig{
+		maxExposureKeys: 10,
+		maxSameDayKeys:  1,
+		keyValidators:   []string{"does-not-exist"},
+	})
+	errcmp.MustMatch(t, err, `unknown or uncompiled key validator "does-not-exist"`)
+}
+
+func TestRegisterKeyValidator_Duplicate(t *testing.T) {
+	t.Parallel()
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("expected panic")
+		}
+	}()
+	RegisterKeyValidator("test-region", KeyValidatorFunc(func(context.Context, *KeyValidationInput) error {
+		return nil
+	}))
+}
@@ -0,0 +1,39 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import "fmt"
+
+// OversizePaddingPolicy determines what happens to publish requests whose
+// padding is larger than the configured maximum.
+type OversizePaddingPolicy string
+
+const (
+	// OversizePaddingReject rejects the request.
+	OversizePaddingReject OversizePaddingPolicy = "reject"
+	// OversizePaddingTruncate truncates the padding to the maximum and
+	// processes the request as usual.
+	OversizePaddingTruncate OversizePaddingPolicy = "truncate"
+)
+
+// Validate returns an error if the policy is unknown.
+func (p OversizePaddingPolicy) Validate() error {
+	switch p {
+	case OversizePaddingReject, OversizePaddingTruncate:
+		return nil
+	default:
+		return fmt.Errorf("unknown oversize padding policy %q, must be %q or %q", p, OversizePaddingReject, OversizePaddingTruncate)
+	}
+}
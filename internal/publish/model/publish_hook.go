@@ -0,0 +1,54 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"context"
+	"time"
+)
+
+// PublishEvent describes the keys accepted by a publish request. It never
+// contains the keys themselves.
+type PublishEvent struct {
+	// AppPackageName is the health authority ID of the publish request.
+	AppPackageName string
+	// HealthAuthorityID is the ID of the health authority that verified the
+	// keys, or 0 if verification was bypassed.
+	HealthAuthorityID int64
+	Regions           []string
+	Platform          string
+
+	Inserted int
+	Revised  int
+	Dropped  int
+
+	PublishedAt time.Time
+}
+
+// PublishHook is notified after the keys of a publish request are saved, for
+// example to emit events to a message queue. Callers must treat errors as
+// non-fatal; a failing hook must never fail the publish request.
+type PublishHook interface {
+	OnPublish(ctx context.Context, event *PublishEvent) error
+}
+
+// NoopPublishHook is a PublishHook that does nothing. It is used if no other
+// hook is configured.
+type NoopPublishHook struct{}
+
+// OnPublish implements PublishHook.
+func (NoopPublishHook) OnPublish(context.Context, *PublishEvent) error {
+	return nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// QuarantineStatus is the review state of a quarantined publish.
+type QuarantineStatus string
+
+const (
+	QuarantinePending  QuarantineStatus = "PENDING"
+	QuarantineApproved QuarantineStatus = "APPROVED"
+	QuarantineRejected QuarantineStatus = "REJECTED"
+)
+
+// Quarantine is a publish request whose keys are held for manual review
+// instead of being saved. The keys are excluded from exports until an
+// operator approves the publish.
+type Quarantine struct {
+	ID                int64
+	AppPackageName    string
+	HealthAuthorityID *int64
+	Regions           []string
+	Reason            string
+	NumKeys           int
+	Status            QuarantineStatus
+	CreatedAt         time.Time
+	ReviewedAt        *time.Time
+}
+
+// PublishCandidate describes the keys of a publish request that are about to
+// be saved. It never contains the keys themselves.
+type PublishCandidate struct {
+	AppPackageName string
+	// HealthAuthorityID is the ID of the health authority that verified the
+	// keys, or 0 if verification was bypassed.
+	HealthAuthorityID int64
+	Regions           []string
+	Keys              int
+
+	// DailyKeyCount is the number of keys the health authority already
+	// published today, and DailyKeyQuota its quota. Both are 0 if the quota
+	// isn't enforced for the request.
+	DailyKeyCount int64
+	DailyKeyQuota int64
+}
+
+// QuarantinePolicy decides if the keys of a publish request are quarantined
+// for manual review. It returns the reason, or the empty string if the keys
+// are saved as usual.
+type QuarantinePolicy interface {
+	Quarantine(ctx context.Context, c *PublishCandidate) string
+}
+
+// QuarantineHeuristics is a QuarantinePolicy that flags publish requests that
+// come close to the daily key quota or spread over an unusual number of
+// regions.
+type QuarantineHeuristics struct {
+	// QuotaFraction flags requests that bring the health authority to at
+	// least this fraction of its daily key quota. 0 disables the check.
+	QuotaFraction float64
+	// MaxRegions flags requests for more than this many regions. 0 disables
+	// the check.
+	MaxRegions int
+}
+
+// Quarantine implements QuarantinePolicy.
+func (h *QuarantineHeuristics) Quarantine(_ context.Context, c *PublishCandidate) string {
+	if h.QuotaFraction > 0 && c.DailyKeyQuota > 0 {
+		total := c.DailyKeyCount + int64(c.Keys)
+		if float64(total) >= h.QuotaFraction*float64(c.DailyKeyQuota) {
+			return fmt.Sprintf("publish brings the health authority to %d of its daily quota of %d keys", total, c.DailyKeyQuota)
+		}
+	}
+	if h.MaxRegions > 0 && len(c.Regions) > h.MaxRegions {
+		return fmt.Sprintf("publish is for %d regions, more than %d", len(c.Regions), h.MaxRegions)
+	}
+	return ""
+}
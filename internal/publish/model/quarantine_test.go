@@ -0,0 +1,68 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"context"
+	"testing"
+)
+
+func TestQuarantineHeuristics(t *testing.T) {
+	t.Parallel()
+
+	h := &QuarantineHeuristics{QuotaFraction: 0.9, MaxRegions: 2}
+
+	cases := []struct {
+		name      string
+		candidate *PublishCandidate
+		flagged   bool
+	}{
+		{
+			name:      "ok",
+			candidate: &PublishCandidate{Regions: []string{"US"}, Keys: 2, DailyKeyCount: 10, DailyKeyQuota: 100},
+		},
+		{
+			name:      "quota_near_miss",
+			candidate: &PublishCandidate{Regions: []string{"US"}, Keys: 2, DailyKeyCount: 88, DailyKeyQuota: 100},
+			flagged:   true,
+		},
+		{
+			name:      "no_quota",
+			candidate: &PublishCandidate{Regions: []string{"US"}, Keys: 2, DailyKeyCount: 88},
+		},
+		{
+			name:      "region_spread",
+			candidate: &PublishCandidate{Regions: []string{"US", "CA", "MX"}, Keys: 2},
+			flagged:   true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			reason := h.Quarantine(context.Background(), tc.candidate)
+			if got := reason != ""; got != tc.flagged {
+				t.Errorf("expected flagged to be %t, got reason %q", tc.flagged, reason)
+			}
+		})
+	}
+
+	if reason := (&QuarantineHeuristics{}).Quarantine(context.Background(), &PublishCandidate{Regions: []string{"US", "CA", "MX"}}); reason != "" {
+		t.Errorf("expected disabled heuristics to not flag, got %q", reason)
+	}
+}
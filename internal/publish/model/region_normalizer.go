@@ -0,0 +1,100 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RegionNormalizer maps region codes to their canonical form, so that keys
+// published with variants of a region code like "us-ca " are stored and
+// exported as "US-CA". Region codes are trimmed and uppercased, then replaced
+// using the canonical mapping, if any. No other characters are changed, so
+// distinct region codes like "US-CA" and "USCA" only have the same canonical
+// form if the mapping says so.
+//
+// A nil RegionNormalizer only trims and uppercases.
+type RegionNormalizer struct {
+	mapping map[string]string
+}
+
+// NewRegionNormalizer creates a RegionNormalizer with the canonical mapping of
+// region codes. The keys and values of the mapping are normalized first. It is
+// an error for two keys to have different canonical regions once normalized,
+// or for a canonical region to be mapped to another region itself, since the
+// result would depend on the order the mapping is applied.
+func NewRegionNormalizer(mapping map[string]string) (*RegionNormalizer, error) {
+	normalized := make(map[string]string, len(mapping))
+	for from, to := range mapping {
+		nFrom, nTo := normalizeRegion(from), normalizeRegion(to)
+		if nFrom == "" || nTo == "" {
+			return nil, fmt.Errorf("invalid canonical region mapping %q:%q, regions cannot be empty", from, to)
+		}
+		if existing, ok := normalized[nFrom]; ok && existing != nTo {
+			return nil, fmt.Errorf("region %q is mapped to both %q and %q", nFrom, existing, nTo)
+		}
+		normalized[nFrom] = nTo
+	}
+
+	for from, to := range normalized {
+		if next, ok := normalized[to]; ok && next != to {
+			return nil, fmt.Errorf("region %q is mapped to %q, which is mapped to %q", from, to, next)
+		}
+	}
+
+	return &RegionNormalizer{mapping: normalized}, nil
+}
+
+// Normalize returns the canonical form of region, or the empty string if the
+// region is blank.
+func (n *RegionNormalizer) Normalize(region string) string {
+	region = normalizeRegion(region)
+	if n == nil {
+		return region
+	}
+	if canonical, ok := n.mapping[region]; ok {
+		return canonical
+	}
+	return region
+}
+
+// NormalizeAll returns the canonical form of regions. Blank regions are
+// dropped and regions with the same canonical form are only returned once, in
+// the order they were first seen.
+func (n *RegionNormalizer) NormalizeAll(regions []string) []string {
+	if len(regions) == 0 {
+		return regions
+	}
+
+	seen := make(map[string]struct{}, len(regions))
+	result := make([]string, 0, len(regions))
+	for _, r := range regions {
+		r = n.Normalize(r)
+		if r == "" {
+			continue
+		}
+		if _, ok := seen[r]; ok {
+			continue
+		}
+		seen[r] = struct{}{}
+		result = append(result, r)
+	}
+	return result
+}
+
+func normalizeRegion(region string) string {
+	return strings.ToUpper(strings.TrimSpace(region))
+}
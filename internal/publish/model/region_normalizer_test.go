@@ -0,0 +1,134 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/google/exposure-notifications-server/pkg/errcmp"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNewRegionNormalizer(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		mapping map[string]string
+		err     string
+	}{
+		{
+			name: "nil",
+		},
+		{
+			name:    "valid",
+			mapping: map[string]string{"us-ca": "US-CA", "CALIFORNIA": "us-ca ", "us-ca-old": "us-ca"},
+		},
+		{
+			name:    "empty_source",
+			mapping: map[string]string{" ": "US-CA"},
+			err:     "regions cannot be empty",
+		},
+		{
+			name:    "empty_target",
+			mapping: map[string]string{"CALIFORNIA": ""},
+			err:     "regions cannot be empty",
+		},
+		{
+			name:    "conflicting",
+			mapping: map[string]string{"ca": "US-CA", "CA ": "CA-ON"},
+			err:     `region "CA" is mapped to both`,
+		},
+		{
+			name:    "chained",
+			mapping: map[string]string{"CALIFORNIA": "US-CA", "US-CA": "USA"},
+			err:     `region "CALIFORNIA" is mapped to "US-CA", which is mapped to "USA"`,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewRegionNormalizer(tc.mapping)
+			errcmp.MustMatch(t, err, tc.err)
+		})
+	}
+}
+
+func TestRegionNormalizer_Normalize(t *testing.T) {
+	t.Parallel()
+
+	n, err := NewRegionNormalizer(map[string]string{
+		"california": "us-ca",
+		"US-CA-OLD":  "US-CA",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		region string
+		want   string
+	}{
+		{"US-CA", "US-CA"},
+		{"us-ca", "US-CA"},
+		{"US-CA ", "US-CA"},
+		{" \tUs-Ca\n", "US-CA"},
+		{"California", "US-CA"},
+		{"us-ca-old", "US-CA"},
+		// Distinct regions are not collapsed into each other.
+		{"USCA", "USCA"},
+		{"US_CA", "US_CA"},
+		{"US-CAL", "US-CAL"},
+		{"   ", ""},
+	}
+
+	for _, tc := range cases {
+		if got, want := n.Normalize(tc.region), tc.want; got != want {
+			t.Errorf("Normalize(%q): expected %q to be %q", tc.region, got, want)
+		}
+	}
+}
+
+func TestRegionNormalizer_Nil(t *testing.T) {
+	t.Parallel()
+
+	var n *RegionNormalizer
+	if got, want := n.Normalize(" us-ca "), "US-CA"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+}
+
+func TestRegionNormalizer_NormalizeAll(t *testing.T) {
+	t.Parallel()
+
+	n, err := NewRegionNormalizer(map[string]string{"CALIFORNIA": "US-CA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := n.NormalizeAll([]string{"us-wa", "US-CA ", "", "us-ca", "California", "US-WA", "USCA"})
+	want := []string{"US-WA", "US-CA", "USCA"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	if got := n.NormalizeAll(nil); got != nil {
+		t.Errorf("expected nil, got %v", got)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import "fmt"
+
+// MissingRegionPolicy determines what happens to exposure keys that don't
+// have a region, either from the publish request or the authorized app.
+type MissingRegionPolicy string
+
+const (
+	// MissingRegionDefault assigns the configured default region. If there is
+	// no default region, the keys are rejected.
+	MissingRegionDefault MissingRegionPolicy = "default"
+	// MissingRegionReject rejects the keys, even if a default region is
+	// configured.
+	MissingRegionReject MissingRegionPolicy = "reject"
+)
+
+// Validate returns an error if the policy is unknown.
+func (p MissingRegionPolicy) Validate() error {
+	switch p {
+	case MissingRegionDefault, MissingRegionReject:
+		return nil
+	default:
+		return fmt.Errorf("unknown missing region policy %q, must be %q or %q", p, MissingRegionDefault, MissingRegionReject)
+	}
+}
+
+// Region returns the region that keys without a region belong to, or the empty
+// string if they are rejected.
+func (p MissingRegionPolicy) Region(defaultRegion string) string {
+	if p == MissingRegionDefault {
+		return defaultRegion
+	}
+	return ""
+}
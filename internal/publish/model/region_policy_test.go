@@ -0,0 +1,64 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/google/exposure-notifications-server/pkg/errcmp"
+)
+
+func TestMissingRegionPolicy(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		policy MissingRegionPolicy
+		want   string
+		err    string
+	}{
+		{
+			name:   "default",
+			policy: MissingRegionDefault,
+			want:   "US",
+		},
+		{
+			name:   "reject",
+			policy: MissingRegionReject,
+			want:   "",
+		},
+		{
+			name:   "unknown",
+			policy: "banana",
+			err:    `unknown missing region policy "banana"`,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			errcmp.MustMatch(t, tc.policy.Validate(), tc.err)
+			if tc.err != "" {
+				return
+			}
+			if got := tc.policy.Region("US"); got != tc.want {
+				t.Errorf("expected region %q to be %q", got, tc.want)
+			}
+		})
+	}
+}
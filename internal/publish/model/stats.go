@@ -16,6 +16,8 @@
 package model
 
 import (
+	"fmt"
+	"regexp"
 	"sort"
 	"time"
 
@@ -180,3 +182,133 @@ func (has *HealthAuthorityStats) AddPublish(info *PublishInfo) {
 		}
 	}
 }
+
+const (
+	// MaxSubmittedMetrics is the maximum number of distinct metrics that may be
+	// submitted for a single day.
+	MaxSubmittedMetrics = 100
+	// MaxSubmittedMetricNameLength is the maximum length of a submitted metric name.
+	MaxSubmittedMetricNameLength = 64
+)
+
+var submittedMetricNameRe = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// SubmittedStats represents aggregate stats that a health authority computed
+// outside of this server and submitted for storage. There is at most one
+// SubmittedStats per health authority per day.
+type SubmittedStats struct {
+	HealthAuthorityID int64
+	Day               time.Time
+	Metrics           map[string]int64
+	UpdatedAt         time.Time
+}
+
+// NewSubmittedStats validates the provided request and converts it to
+// SubmittedStats for the given health authority. Stats may not be submitted
+// for days in the future relative to now.
+func NewSubmittedStats(healthAuthorityID int64, req *verifyapi.StatsSubmitRequest, now time.Time) (*SubmittedStats, error) {
+	if healthAuthorityID <= 0 {
+		return nil, fmt.Errorf("missing health authority ID")
+	}
+	if req == nil {
+		return nil, fmt.Errorf("missing request")
+	}
+
+	day, err := time.Parse("2006-01-02", req.Day)
+	if err != nil {
+		return nil, fmt.Errorf("day must be in the format YYYY-MM-DD: %w", err)
+	}
+	day = timeutils.UTCMidnight(day)
+	if day.After(timeutils.UTCMidnight(now)) {
+		return nil, fmt.Errorf("day %s is in the future", req.Day)
+	}
+
+	if len(req.Metrics) == 0 {
+		return nil, fmt.Errorf("at least one metric is required")
+	}
+	if l := len(req.Metrics); l > MaxSubmittedMetrics {
+		return nil, fmt.Errorf("too many metrics: %d, max of %d is allowed", l, MaxSubmittedMetrics)
+	}
+
+	metrics := make(map[string]int64, len(req.Metrics))
+	for name, value := range req.Metrics {
+		if l := len(name); l == 0 || l > MaxSubmittedMetricNameLength {
+			return nil, fmt.Errorf("metric name %q must be between 1 and %d characters", name, MaxSubmittedMetricNameLength)
+		}
+		if !submittedMetricNameRe.MatchString(name) {
+			return nil, fmt.Errorf("metric name %q may only contain lowercase letters, numbers, and underscores", name)
+		}
+		if value < 0 {
+			return nil, fmt.Errorf("metric %q must be >= 0, got %d", name, value)
+		}
+		metrics[name] = value
+	}
+
+	return &SubmittedStats{
+		HealthAuthorityID: healthAuthorityID,
+		Day:               day,
+		Metrics:           metrics,
+		UpdatedAt:         now.UTC(),
+	}, nil
+}
+
+// KeyCount is the number of exposure keys published for a region on a UTC day.
+type KeyCount struct {
+	Day    time.Time
+	Region string
+	Count  int64
+}
+
+// KeyCountsWindow is the range of days to read key counts for. Start is
+// inclusive and End is exclusive, both are UTC midnight.
+type KeyCountsWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+// NewKeyCountsWindow validates the days in the provided request and converts
+// them to a KeyCountsWindow. The window may not start in the future relative
+// to now, and may not be longer than maxDays.
+func NewKeyCountsWindow(req *verifyapi.KeyCountsRequest, now time.Time, maxDays uint) (*KeyCountsWindow, error) {
+	if req == nil {
+		return nil, fmt.Errorf("missing request")
+	}
+
+	start, err := time.Parse("2006-01-02", req.StartDay)
+	if err != nil {
+		return nil, fmt.Errorf("startDay must be in the format YYYY-MM-DD: %w", err)
+	}
+	end, err := time.Parse("2006-01-02", req.EndDay)
+	if err != nil {
+		return nil, fmt.Errorf("endDay must be in the format YYYY-MM-DD: %w", err)
+	}
+	start, end = timeutils.UTCMidnight(start), timeutils.UTCMidnight(end)
+
+	if end.Before(start) {
+		return nil, fmt.Errorf("endDay %s is before startDay %s", req.EndDay, req.StartDay)
+	}
+	if start.After(timeutils.UTCMidnight(now)) {
+		return nil, fmt.Errorf("startDay %s is in the future", req.StartDay)
+	}
+	// The end day is inclusive, move it to the start of the next day.
+	end = end.AddDate(0, 0, 1)
+	if days := uint(end.Sub(start).Hours() / 24); days > maxDays {
+		return nil, fmt.Errorf("too many days requested: %d, max of %d is allowed", days, maxDays)
+	}
+
+	return &KeyCountsWindow{
+		Start: start,
+		End:   end,
+	}, nil
+}
+
+// RevisionTransition is the number of exposure keys in a region whose report
+// type was revised from one report type to another on a UTC day. Keys that
+// were published without a report type have an empty From.
+type RevisionTransition struct {
+	Day    time.Time
+	Region string
+	From   string
+	To     string
+	Count  int64
+}
@@ -15,6 +15,8 @@
 package model
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -270,3 +272,187 @@ func TestReduce(t *testing.T) {
 		t.Fatalf("mismatch (-want, +got):\n%s", diff)
 	}
 }
+
+func TestNewSubmittedStats(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2021, 5, 10, 15, 0, 0, 0, time.UTC)
+
+	tooMany := make(map[string]int64, MaxSubmittedMetrics+1)
+	for i := 0; i <= MaxSubmittedMetrics; i++ {
+		tooMany[fmt.Sprintf("metric_%d", i)] = 1
+	}
+
+	cases := []struct {
+		name    string
+		req     *verifyapi.StatsSubmitRequest
+		want    *SubmittedStats
+		wantErr string
+	}{
+		{
+			name: "valid",
+			req: &verifyapi.StatsSubmitRequest{
+				Day:     "2021-05-09",
+				Metrics: map[string]int64{"codes_issued": 10, "codes_claimed": 0},
+			},
+			want: &SubmittedStats{
+				HealthAuthorityID: 1,
+				Day:               time.Date(2021, 5, 9, 0, 0, 0, 0, time.UTC),
+				Metrics:           map[string]int64{"codes_issued": 10, "codes_claimed": 0},
+				UpdatedAt:         now,
+			},
+		},
+		{
+			name: "today",
+			req: &verifyapi.StatsSubmitRequest{
+				Day:     "2021-05-10",
+				Metrics: map[string]int64{"codes_issued": 1},
+			},
+			want: &SubmittedStats{
+				HealthAuthorityID: 1,
+				Day:               time.Date(2021, 5, 10, 0, 0, 0, 0, time.UTC),
+				Metrics:           map[string]int64{"codes_issued": 1},
+				UpdatedAt:         now,
+			},
+		},
+		{
+			name:    "bad_day",
+			req:     &verifyapi.StatsSubmitRequest{Day: "05/09/2021", Metrics: map[string]int64{"a": 1}},
+			wantErr: "day must be in the format YYYY-MM-DD",
+		},
+		{
+			name:    "future_day",
+			req:     &verifyapi.StatsSubmitRequest{Day: "2021-05-11", Metrics: map[string]int64{"a": 1}},
+			wantErr: "is in the future",
+		},
+		{
+			name:    "no_metrics",
+			req:     &verifyapi.StatsSubmitRequest{Day: "2021-05-09"},
+			wantErr: "at least one metric is required",
+		},
+		{
+			name:    "too_many_metrics",
+			req:     &verifyapi.StatsSubmitRequest{Day: "2021-05-09", Metrics: tooMany},
+			wantErr: "too many metrics",
+		},
+		{
+			name:    "bad_name",
+			req:     &verifyapi.StatsSubmitRequest{Day: "2021-05-09", Metrics: map[string]int64{"Codes-Issued": 1}},
+			wantErr: "may only contain lowercase letters",
+		},
+		{
+			name:    "negative_value",
+			req:     &verifyapi.StatsSubmitRequest{Day: "2021-05-09", Metrics: map[string]int64{"codes_issued": -1}},
+			wantErr: "must be >= 0",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := NewSubmittedStats(1, tc.req, now)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error containing %q, got: %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNewKeyCountsWindow(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2021, 5, 10, 15, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name    string
+		req     *verifyapi.KeyCountsRequest
+		want    *KeyCountsWindow
+		wantErr string
+	}{
+		{
+			name: "valid",
+			req:  &verifyapi.KeyCountsRequest{StartDay: "2021-05-01", EndDay: "2021-05-03"},
+			want: &KeyCountsWindow{
+				Start: time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+				End:   time.Date(2021, 5, 4, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "single_day",
+			req:  &verifyapi.KeyCountsRequest{StartDay: "2021-05-10", EndDay: "2021-05-10"},
+			want: &KeyCountsWindow{
+				Start: time.Date(2021, 5, 10, 0, 0, 0, 0, time.UTC),
+				End:   time.Date(2021, 5, 11, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "max_days",
+			req:  &verifyapi.KeyCountsRequest{StartDay: "2021-05-01", EndDay: "2021-05-07"},
+			want: &KeyCountsWindow{
+				Start: time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+				End:   time.Date(2021, 5, 8, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:    "bad_start_day",
+			req:     &verifyapi.KeyCountsRequest{StartDay: "05/01/2021", EndDay: "2021-05-03"},
+			wantErr: "startDay must be in the format YYYY-MM-DD",
+		},
+		{
+			name:    "bad_end_day",
+			req:     &verifyapi.KeyCountsRequest{StartDay: "2021-05-01"},
+			wantErr: "endDay must be in the format YYYY-MM-DD",
+		},
+		{
+			name:    "end_before_start",
+			req:     &verifyapi.KeyCountsRequest{StartDay: "2021-05-03", EndDay: "2021-05-01"},
+			wantErr: "is before startDay",
+		},
+		{
+			name:    "future_start",
+			req:     &verifyapi.KeyCountsRequest{StartDay: "2021-05-11", EndDay: "2021-05-12"},
+			wantErr: "is in the future",
+		},
+		{
+			name:    "too_many_days",
+			req:     &verifyapi.KeyCountsRequest{StartDay: "2021-05-01", EndDay: "2021-05-08"},
+			wantErr: "too many days requested: 8",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := NewKeyCountsWindow(tc.req, now, 7)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error containing %q, got: %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
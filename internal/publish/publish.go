@@ -23,6 +23,9 @@ import (
 	"fmt"
 	"math/big"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"go.opencensus.io/stats"
@@ -30,6 +33,7 @@ import (
 	"go.opencensus.io/trace"
 
 	"github.com/google/exposure-notifications-server/internal/authorizedapp"
+	aamodel "github.com/google/exposure-notifications-server/internal/authorizedapp/model"
 	"github.com/google/exposure-notifications-server/internal/middleware"
 	"github.com/google/exposure-notifications-server/internal/pb"
 	"github.com/google/exposure-notifications-server/internal/publish/database"
@@ -37,10 +41,12 @@ import (
 	"github.com/google/exposure-notifications-server/internal/revision"
 	revisiondb "github.com/google/exposure-notifications-server/internal/revision/database"
 	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/exposure-notifications-server/internal/statssink"
 	"github.com/google/exposure-notifications-server/internal/verification"
 	verifydb "github.com/google/exposure-notifications-server/internal/verification/database"
 	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
 	"github.com/google/exposure-notifications-server/pkg/base64util"
+	"github.com/google/exposure-notifications-server/pkg/cache"
 	"github.com/google/exposure-notifications-server/pkg/logging"
 	obs "github.com/google/exposure-notifications-server/pkg/observability"
 	"github.com/google/exposure-notifications-server/pkg/server"
@@ -50,18 +56,51 @@ import (
 
 const (
 	HeaderAPIVersion = "x-api-version"
+
+	// HeaderSchemaVersion selects the request body schema of a v1 publish
+	// request.
+	HeaderSchemaVersion = "x-publish-schema-version"
 )
 
 type Server struct {
 	config                *Config
 	env                   *serverenv.ServerEnv
 	transformer           *model.Transformer
+	regionNormalizer      *model.RegionNormalizer
 	database              *database.PublishDB
 	tokenManager          *revision.TokenManager
 	tracker               *chaff.Tracker
 	tokenAAD              []byte
 	authorizedAppProvider authorizedapp.Provider
 	verifier              *verification.Verifier
+	statsSink             statssink.StatsSink
+	publishHook           model.PublishHook
+
+	// statsSubmitLimiters holds the per health authority rate limiters for the
+	// stats submission API, keyed by health authority ID. Idle rate limiters
+	// are removed.
+	statsSubmitLimiters      map[int64]*statsSubmitLimiter
+	statsSubmitLimitersSweep time.Time
+	statsSubmitLimitersLock  sync.Mutex
+
+	// certificateThrottle rejects publish requests that reuse a verification
+	// certificate too soon, nil if disabled.
+	certificateThrottle *certificateThrottle
+
+	// writeBreaker pauses writes after sustained database write failures, nil
+	// if disabled.
+	writeBreaker *writeBreaker
+
+	// quarantinePolicy flags publish requests whose keys are held for manual
+	// review, nil if disabled.
+	quarantinePolicy model.QuarantinePolicy
+
+	// keyCountsCache caches pages of the key counts API.
+	keyCountsCache *cache.Cache
+
+	// statsRecords tracks the stats sink records that are still being written
+	// in the background.
+	statsRecords sync.WaitGroup
 }
 
 func NewServer(ctx context.Context, cfg *Config, env *serverenv.ServerEnv) (*Server, error) {
@@ -79,6 +118,12 @@ func NewServer(ctx context.Context, cfg *Config, env *serverenv.ServerEnv) (*Ser
 	if env.Database() == nil {
 		return nil, fmt.Errorf("missing database in server environment")
 	}
+	for _, v := range cfg.PublishSchemaVersions {
+		if !validSchemaVersion(v) {
+			return nil, fmt.Errorf("PUBLISH_SCHEMA_VERSIONS: unknown schema version %q, must be one of %s",
+				v, strings.Join(knownSchemaVersions, ", "))
+		}
+	}
 	if env.AuthorizedAppProvider() == nil {
 		return nil, fmt.Errorf("missing AuthorizedApp provider in server environment")
 	}
@@ -88,10 +133,23 @@ func NewServer(ctx context.Context, cfg *Config, env *serverenv.ServerEnv) (*Ser
 		return nil, fmt.Errorf("model.NewTransformer: %w", err)
 	}
 
+	regionNormalizer, err := model.NewRegionNormalizer(cfg.RegionCanonicalMapping)
+	if err != nil {
+		return nil, fmt.Errorf("REGION_CANONICAL_MAPPING: %w", err)
+	}
+
 	verifier, err := verification.New(verifydb.New(env.Database()), &cfg.Verification)
 	if err != nil {
 		return nil, fmt.Errorf("verification.New: %w", err)
 	}
+	if err := verifier.CheckDuplicateKIDs(ctx); err != nil {
+		return nil, fmt.Errorf("verifier.CheckDuplicateKIDs: %w", err)
+	}
+	verifier.StartJWKSRefresher(ctx)
+	if cfg.Verification.CacheMaintenanceMode {
+		verifier.SetCacheMaintenanceMode(ctx, true)
+	}
+	verifier.StartCacheMaintenanceModeRefresher(ctx)
 
 	aadBytes := cfg.RevisionToken.AAD
 	if len(aadBytes) == 0 {
@@ -114,6 +172,31 @@ func NewServer(ctx context.Context, cfg *Config, env *serverenv.ServerEnv) (*Ser
 		return nil, fmt.Errorf("config validation: %w", err)
 	}
 
+	// Default to aggregating stats in the database if no other sink is
+	// configured.
+	statsSink := env.StatsSink()
+	if statsSink == nil {
+		statsSink = statssink.NewPostgres(env.Database())
+	}
+
+	publishHook := env.PublishHook()
+	if publishHook == nil {
+		publishHook = model.NoopPublishHook{}
+	}
+
+	quarantinePolicy := env.QuarantinePolicy()
+	if quarantinePolicy == nil && cfg.EnableQuarantine {
+		quarantinePolicy = &model.QuarantineHeuristics{
+			QuotaFraction: cfg.QuarantineQuotaFraction,
+			MaxRegions:    cfg.QuarantineMaxRegions,
+		}
+	}
+
+	keyCountsCache, err := cache.New(cfg.KeyCountsCacheDuration)
+	if err != nil {
+		return nil, fmt.Errorf("cache.New: %w", err)
+	}
+
 	chaffer, err := chaff.NewTracker(chaff.NewJSONResponder(chaffPublishResponse), chaff.DefaultCapacity)
 	if err != nil {
 		return nil, fmt.Errorf("error making chaffer: %w", err)
@@ -122,6 +205,7 @@ func NewServer(ctx context.Context, cfg *Config, env *serverenv.ServerEnv) (*Ser
 	return &Server{
 		env:                   env,
 		transformer:           transformer,
+		regionNormalizer:      regionNormalizer,
 		config:                cfg,
 		database:              database.New(env.Database()),
 		tracker:               chaffer,
@@ -129,6 +213,13 @@ func NewServer(ctx context.Context, cfg *Config, env *serverenv.ServerEnv) (*Ser
 		tokenAAD:              aadBytes,
 		authorizedAppProvider: env.AuthorizedAppProvider(),
 		verifier:              verifier,
+		statsSink:             statsSink,
+		publishHook:           publishHook,
+		statsSubmitLimiters:   make(map[int64]*statsSubmitLimiter),
+		certificateThrottle:   newCertificateThrottle(cfg.PublishCertificateMinInterval, cfg.PublishCertificateMaxPerIssuer),
+		writeBreaker:          newWriteBreaker(cfg.WriteBreakerThreshold, cfg.WriteBreakerWindow, cfg.WriteBreakerCooldown),
+		quarantinePolicy:      quarantinePolicy,
+		keyCountsCache:        keyCountsCache,
 	}, nil
 }
 
@@ -143,20 +234,31 @@ func (s *Server) Routes(ctx context.Context) *mux.Router {
 	r.Use(middleware.PopulateLogger(logger))
 	r.Use(middleware.ProcessMaintenance(s.config))
 
+	// Unknown paths and methods get the same JSON error bodies as the API.
+	r.NotFoundHandler = server.HandleNotFound()
+	r.MethodNotAllowedHandler = server.HandleMethodNotAllowed()
+
 	r.Handle("/health", server.HandleHealthz(s.env.Database()))
 
 	// Handle v1 API - this route has to come before the v1alpha route because of
 	// path matching.
-	r.Handle("/v1/publish", s.handlePublishV1())
-	r.Handle("/v1/publish/", http.NotFoundHandler())
+	r.Handle("/v1/publish", s.handlePublishV1()).Methods(http.MethodPost)
+	r.Handle("/v1/publish/", server.HandleNotFound())
 
-	// Handle stats retrieval API
-	r.Handle("/v1/stats", s.handleStats())
-	r.Handle("/v1/stats/", http.NotFoundHandler())
+	// Handle stats retrieval API. These responses are padded so that their size
+	// doesn't reveal their contents, so they are never compressed.
+	r.Handle("/v1/stats", s.handleStats()).Methods(http.MethodPost)
+	r.Handle("/v1/stats/submit", s.handleStatsSubmit()).Methods(http.MethodPost)
+	r.Handle("/v1/stats/keycounts", s.handleKeyCounts()).Methods(http.MethodPost)
+	r.Handle("/v1/stats/", server.HandleNotFound())
+
+	if s.config.EnableDebugVerifierConfig {
+		r.Handle("/debug/verifier-config", s.handleDebugVerifierConfig()).Methods(http.MethodGet)
+	}
 
 	// Serving of v1alpha1 is on by default, but can be disabled through env var.
 	if s.config.EnableV1Alpha1API {
-		r.Handle("/", s.handlePublishV1Alpha1())
+		r.Handle("/", s.handlePublishV1Alpha1()).Methods(http.MethodPost)
 	}
 
 	return r
@@ -165,6 +267,29 @@ func (s *Server) Routes(ctx context.Context) *mux.Router {
 type response struct {
 	status      int
 	pubResponse *verifyapi.PublishResponse
+	// retryAfter is sent in the Retry-After header, if set.
+	retryAfter time.Duration
+}
+
+// setRetryAfter sets the Retry-After header of the response in whole seconds,
+// rounded up.
+func (r *response) setRetryAfter(w http.ResponseWriter) {
+	if r.retryAfter <= 0 {
+		return
+	}
+	seconds := int64((r.retryAfter + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+}
+
+// recordWrite reports the outcome of a database write to the write breaker.
+func (s *Server) recordWrite(ctx context.Context, failed bool) {
+	if s.writeBreaker.done(time.Now(), failed) {
+		logging.FromContext(ctx).Errorw("database writes failing, write breaker tripped",
+			"threshold", s.config.WriteBreakerThreshold,
+			"window", s.config.WriteBreakerWindow,
+			"cooldown", s.config.WriteBreakerCooldown)
+		stats.Record(ctx, mWriteBreakerTripped.M(1))
+	}
 }
 
 func generatePadding(minPadding, paddingRange int64) (string, error) {
@@ -208,9 +333,20 @@ func newVersionBridge(regions []string) *versionBridge {
 	return &b
 }
 
+// detachedHMAC returns the HMAC of the keys sent in the configured header, or
+// the empty string if the header isn't configured.
+func (s *Server) detachedHMAC(r *http.Request) string {
+	if s.config.DetachedHMACHeader == "" {
+		return ""
+	}
+	return strings.TrimSpace(r.Header.Get(s.config.DetachedHMACHeader))
+}
+
 // process runs the publish business logic over a "v1" version of the publish request
-// and knows how to join in data from previous versions (the provided versionBridge)
-func (s *Server) process(ctx context.Context, data *verifyapi.Publish, platform string, bridge *versionBridge) *response {
+// and knows how to join in data from previous versions (the provided versionBridge).
+// The detachedHMAC is the HMAC sent outside of the certificate, if any, see
+// detachedHMAC.
+func (s *Server) process(ctx context.Context, data *verifyapi.Publish, platform, detachedHMAC string, bridge *versionBridge) *response {
 	ctx, span := trace.StartSpan(ctx, "(*publish.PublishHandler).process")
 	defer span.End()
 
@@ -223,6 +359,13 @@ func (s *Server) process(ctx context.Context, data *verifyapi.Publish, platform
 
 	logger.Info("publish API request")
 
+	if resp := s.checkPadding(data); resp != nil {
+		span.SetStatus(trace.Status{Code: trace.StatusCodeInvalidArgument, Message: resp.pubResponse.ErrorMessage})
+		blame = obs.BlameClient
+		obsResult = obs.ResultError("PADDING_TOO_LARGE")
+		return resp
+	}
+
 	appConfig, err := s.authorizedAppProvider.AppConfig(ctx, data.HealthAuthorityID)
 	if err != nil {
 		// Config loaded, but app with that name isn't registered. This can also
@@ -263,7 +406,7 @@ func (s *Server) process(ctx context.Context, data *verifyapi.Publish, platform
 	// In the v1 API - regions aren't passed. They may be passed from v1alpha1
 	var regions []string
 	if bridge != nil && len(bridge.AdditionalRegions) > 0 {
-		regions = bridge.AdditionalRegions
+		regions = s.regionNormalizer.NormalizeAll(bridge.AdditionalRegions)
 		// Authorized regions only need to checked if they are coming in from the
 		// v1alpha1 version of the API.
 		for _, r := range regions {
@@ -285,11 +428,14 @@ func (s *Server) process(ctx context.Context, data *verifyapi.Publish, platform
 	// If regions is still empty (normal for v1 request), copy the regions from
 	// the authorized app config.
 	if len(regions) == 0 {
-		regions = appConfig.AllAllowedRegions()
+		regions = s.regionNormalizer.NormalizeAll(appConfig.AllAllowedRegions())
 	}
-	// And - worse case, still no regions and server default set.
-	if len(regions) == 0 && s.config.DefaultRegion != "" {
-		regions = append(regions, s.config.DefaultRegion)
+	// And - worse case, still no regions and the missing region policy assigns
+	// the server default.
+	if len(regions) == 0 {
+		if r := s.regionNormalizer.Normalize(s.config.MissingRegionPolicy.Region(s.config.DefaultRegion)); r != "" {
+			regions = append(regions, r)
+		}
 	}
 
 	// Verify that there is at least one region set by API call or by one of the
@@ -297,8 +443,12 @@ func (s *Server) process(ctx context.Context, data *verifyapi.Publish, platform
 	// won't be retrievable, so we ensure there is something set.
 	if len(regions) == 0 {
 		logger.Errorw("no regions present in request or configured for HealthAuthorityID",
-			"health_authority_id", data.HealthAuthorityID)
+			"health_authority_id", data.HealthAuthorityID,
+			"missing_region_policy", s.config.MissingRegionPolicy)
 		message := fmt.Sprintf("unknown health authority regions for %v", data.HealthAuthorityID)
+		if s.config.MissingRegionPolicy == model.MissingRegionReject {
+			message = fmt.Sprintf("no regions for %v, requests without a region are rejected", data.HealthAuthorityID)
+		}
 		span.SetStatus(trace.Status{Code: trace.StatusCodePermissionDenied, Message: message})
 		blame = obs.BlameClient
 		obsResult = obs.ResultError("ERROR_REGION_NOT_SPECIFIED")
@@ -311,8 +461,28 @@ func (s *Server) process(ctx context.Context, data *verifyapi.Publish, platform
 		}
 	}
 
+	// A key with the wrong length can't match the certificate HMAC, so report
+	// it by index instead of as a verification failure.
+	if s.config.EnforceKeyLength {
+		if err := model.ValidateExposureKeyLengths(data.Keys); err != nil {
+			message := fmt.Sprintf("unable to read request data: %v", err)
+			logger.Debugw(message)
+			span.SetStatus(trace.Status{Code: trace.StatusCodeInvalidArgument, Message: message})
+			blame = obs.BlameClient
+			obsResult = obs.ResultError("INVALID_KEY_LENGTH")
+			return &response{
+				status: http.StatusBadRequest,
+				pubResponse: &verifyapi.PublishResponse{
+					ErrorMessage: message,
+					Code:         verifyapi.ErrorInvalidKeyLength,
+				},
+			}
+		}
+	}
+
 	// Perform health authority certificate verification.
-	verifiedClaims, err := s.verifier.VerifyDiagnosisCertificate(ctx, appConfig, data)
+	verifiedClaims, err := s.verifier.VerifyDiagnosisCertificateWithHMAC(ctx, appConfig, data, detachedHMAC)
+	s.recordVerification(ctx, appConfig, data, err)
 	if err != nil {
 		if appConfig.BypassHealthAuthorityVerification {
 			logger.Warnf("bypassing health authority certificate verification health authority: %v", appConfig.AppPackageName)
@@ -354,6 +524,38 @@ func (s *Server) process(ctx context.Context, data *verifyapi.Publish, platform
 		}
 	}
 
+	// Reject certificates that were used too recently. Certificates are only
+	// known if they were verified.
+	if verifiedClaims != nil {
+		if wait := s.certificateThrottle.wait(verifiedClaims.HealthAuthorityID, verifiedClaims.CertificateID, time.Now()); wait > 0 {
+			logger.Infow("verification certificate republished too soon", "healthAuthorityID", verifiedClaims.HealthAuthorityID, "retry_after", wait)
+			message := "verification certificate was used too recently, try again later"
+			span.SetStatus(trace.Status{Code: trace.StatusCodeResourceExhausted, Message: message})
+			blame = obs.BlameClient
+			obsResult = obs.ResultError("CERTIFICATE_REPUBLISHED_TOO_SOON")
+			return &response{
+				status: http.StatusTooManyRequests,
+				pubResponse: &verifyapi.PublishResponse{
+					ErrorMessage: message,
+					Code:         verifyapi.ErrorCertificateRepublishedTooSoon,
+				},
+				retryAfter: wait,
+			}
+		}
+	}
+
+	// Reject health authorities that already published their daily quota of
+	// keys. Like certificates, health authorities are only known if they were
+	// verified.
+	if verifiedClaims != nil && s.config.DailyKeyQuota > 0 {
+		if resp := s.checkDailyKeyQuota(ctx, verifiedClaims.HealthAuthorityID, time.Now()); resp != nil {
+			span.SetStatus(trace.Status{Code: trace.StatusCodeResourceExhausted, Message: resp.pubResponse.ErrorMessage})
+			blame = obs.BlameClient
+			obsResult = obs.ResultError("DAILY_KEY_QUOTA_EXCEEDED")
+			return resp
+		}
+	}
+
 	// Examine the revision token. It is expected that it is missing in most cases.
 	var token *pb.RevisionTokenData
 	decryptFail := false
@@ -386,11 +588,26 @@ func (s *Server) process(ctx context.Context, data *verifyapi.Publish, platform
 		span.SetStatus(trace.Status{Code: trace.StatusCodeInvalidArgument, Message: message})
 		blame = obs.BlameClient
 		obsResult = obs.ResultError("TRANSFORM_FAILED")
+		errorCode := verifyapi.ErrorBadRequest
+		var errInvalidKeyEncoding *model.ErrorKeyInvalidEncoding
+		var errInvalidKeyLength *model.ErrorKeyInvalidLength
+		var errTooManyRegions *model.ErrorKeyTooManyRegions
+		switch {
+		case errors.As(transformError, &errInvalidKeyEncoding):
+			obsResult = obs.ResultError("INVALID_KEY_ENCODING")
+			errorCode = verifyapi.ErrorInvalidKeyEncoding
+		case errors.As(transformError, &errInvalidKeyLength):
+			obsResult = obs.ResultError("INVALID_KEY_LENGTH")
+			errorCode = verifyapi.ErrorInvalidKeyLength
+		case errors.As(transformError, &errTooManyRegions):
+			obsResult = obs.ResultError("TOO_MANY_REGIONS")
+			errorCode = verifyapi.ErrorTooManyRegions
+		}
 		return &response{
 			status: http.StatusBadRequest,
 			pubResponse: &verifyapi.PublishResponse{
 				ErrorMessage: message,
-				Code:         verifyapi.ErrorBadRequest,
+				Code:         errorCode,
 				Warnings:     transformWarnings,
 			},
 		}
@@ -401,17 +618,48 @@ func (s *Server) process(ctx context.Context, data *verifyapi.Publish, platform
 		publishInfo.Platform = platform
 	}
 
-	resp, err := s.database.InsertAndReviseExposures(ctx, &database.InsertAndReviseExposuresRequest{
-		Incoming:    exposures,
-		Token:       token,
-		PublishInfo: publishInfo,
+	// Don't write while the database is failing writes, after it failed too
+	// many of them.
+	if wait := s.writeBreaker.allow(time.Now()); wait > 0 {
+		message := "the server is temporarily unable to save keys, try again later"
+		logger.Warnw("write breaker open, rejecting publish", "retry_after", wait)
+		span.SetStatus(trace.Status{Code: trace.StatusCodeUnavailable, Message: message})
+		blame = obs.BlameServer
+		obsResult = obs.ResultError("WRITE_BREAKER_OPEN")
+		stats.Record(ctx, mWriteBreakerRejected.M(1))
+		return &response{
+			status: http.StatusServiceUnavailable,
+			pubResponse: &verifyapi.PublishResponse{
+				ErrorMessage: message,
+				Code:         verifyapi.ErrorServiceUnavailable,
+				Warnings:     transformWarnings,
+			},
+			retryAfter: wait,
+		}
+	}
+
+	// Publish stats are recorded through the stats sink after the exposures are
+	// saved, instead of by the database.
+	insertRequest := &database.InsertAndReviseExposuresRequest{
+		Incoming: exposures,
+		Token:    token,
 
 		RequireToken:          !appConfig.BypassRevisionToken,
 		AllowPartialRevisions: s.config.AllowPartialRevisions,
-	})
+		KeepHighestReportType: s.config.KeepHighestReportType,
+		InsertBatchSize:       s.config.InsertBatchSize,
+	}
+	// Hold the keys for manual review instead of saving them, if the request is
+	// flagged by the quarantine policy.
+	if reason := s.quarantineReason(ctx, data.HealthAuthorityID, regions, verifiedClaims, len(exposures), batchTime); reason != "" {
+		insertRequest.Quarantine = newQuarantine(data.HealthAuthorityID, regions, verifiedClaims, reason, batchTime)
+	}
+	resp, err := s.insertExposures(ctx, insertRequest)
 	if err != nil {
 		status := http.StatusBadRequest
 		var logMessage, errorMessage, errorCode string
+		// Only server errors count as failed writes for the write breaker.
+		writeFailed := false
 		var errInvalidReportTypeTransition *model.ErrorKeyInvalidReportTypeTransition
 		switch {
 		case decryptFail || errors.Is(err, database.ErrExistingKeyNotInToken) || errors.Is(err, database.ErrRevisionTokenMetadataMismatch):
@@ -445,7 +693,9 @@ func (s *Server) process(ctx context.Context, data *verifyapi.Publish, platform
 			logger.Errorw("publish error", "error", logMessage)
 			blame = obs.BlameServer
 			obsResult = obs.ResultError("ERROR_DB_WRITE")
+			writeFailed = true
 		}
+		s.recordWrite(ctx, writeFailed)
 		logger.Debugw("publish error", "error", logMessage)
 		span.SetStatus(trace.Status{Code: trace.StatusCodeInternal, Message: logMessage})
 		return &response{
@@ -457,56 +707,63 @@ func (s *Server) process(ctx context.Context, data *verifyapi.Publish, platform
 			},
 		}
 	}
+	s.recordWrite(ctx, false)
+	s.recordCertificate(ctx, verifiedClaims)
 
-	// Build the new revision token. Union of existing token take + new exposures.
-	var keep pb.RevisionTokenData
-	if token != nil {
-		// put existing tokens that aren't too old back in the token.
-		retainInterval := model.IntervalNumber(batchTime.Add(-1 * s.config.MaxIntervalAge))
-		for _, rk := range token.RevisableKeys {
-			if rk.IntervalNumber+rk.IntervalCount >= retainInterval {
-				keep.RevisableKeys = append(keep.RevisableKeys, rk)
-			}
-		}
-	}
+	newToken := s.makeRevisionToken(ctx, token, resp.Exposures, batchTime)
 
-	newToken := make([]byte, 0)
-	if len(keep.RevisableKeys) != 0 || len(resp.Exposures) != 0 {
-		var err error
-		newToken, err = s.tokenManager.MakeRevisionToken(ctx, &keep, resp.Exposures, s.tokenAAD)
-		if err != nil {
-			// Something failed with the revision token generation or encryption.
-			logger.Errorw("failed to make updated revision token", "error", err)
-		}
+	quarantined := insertRequest.Quarantine != nil
+	if quarantined {
+		s.recordQuarantine(ctx, insertRequest.Quarantine, verifiedClaims)
+	} else {
+		s.recordPublish(ctx, publishInfo, resp)
+		s.notifyPublish(ctx, data, regions, platform, resp)
+	}
+	if verifiedClaims != nil && s.config.DailyKeyQuota > 0 {
+		s.countDailyKeys(ctx, verifiedClaims.HealthAuthorityID, batchTime, resp)
 	}
 
 	span.AddAttributes(trace.Int64Attribute("exposures_inserted", int64(resp.Inserted)))
 	span.AddAttributes(trace.Int64Attribute("exposures_revised", int64(resp.Revised)))
 	span.AddAttributes(trace.Int64Attribute("exposures_dropped", int64(resp.Dropped)))
+	span.AddAttributes(trace.Int64Attribute("exposures_too_old", int64(result.TooOld)))
 	logger.Infow("published exposures",
 		"inserted", resp.Inserted,
 		"updated", resp.Revised,
-		"dropped", resp.Dropped)
+		"dropped", resp.Dropped,
+		"too_old", result.TooOld,
+		"quarantined", quarantined)
 
 	publishResponse := verifyapi.PublishResponse{
 		RevisionToken:     base64.StdEncoding.EncodeToString(newToken),
 		InsertedExposures: int(resp.Inserted),
 		Warnings:          transformWarnings,
 	}
+	if s.config.ResponseIncludeCounts {
+		publishResponse.Counts = &verifyapi.PublishCounts{
+			Inserted: int(resp.Inserted),
+			Revised:  int(resp.Revised),
+			Dropped:  int(resp.Dropped) + result.TooOld,
+		}
+	}
 	// If there was a partial failure on transform, add that information back into the success response.
 	if transformError != nil {
 		publishResponse.Code = verifyapi.ErrorPartialFailure
 		publishResponse.ErrorMessage = transformError.Error()
 	}
 
-	exposureCounts := map[tag.Mutator]uint32{
-		exposuresInserted: resp.Inserted,
-		exposuresRevised:  resp.Revised,
-		exposuresDropped:  resp.Dropped,
-	}
-	for t, n := range exposureCounts {
-		if err := stats.RecordWithTags(ctx, []tag.Mutator{t}, mExposuresCount.M(int64(n))); err != nil {
-			logger.Errorw("failed to record stats", "error", err)
+	// Quarantined keys weren't saved, so they aren't counted.
+	if !quarantined {
+		exposureCounts := map[tag.Mutator]uint32{
+			exposuresInserted: resp.Inserted,
+			exposuresRevised:  resp.Revised,
+			exposuresDropped:  resp.Dropped,
+			exposuresTooOld:   uint32(result.TooOld),
+		}
+		for t, n := range exposureCounts {
+			if err := stats.RecordWithTags(ctx, []tag.Mutator{t}, mExposuresCount.M(int64(n))); err != nil {
+				logger.Errorw("failed to record stats", "error", err)
+			}
 		}
 	}
 
@@ -516,6 +773,207 @@ func (s *Server) process(ctx context.Context, data *verifyapi.Publish, platform
 	}
 }
 
+// makeRevisionToken builds the new revision token, the union of the keys of the
+// existing token that aren't too old and the new exposures.
+func (s *Server) makeRevisionToken(ctx context.Context, token *pb.RevisionTokenData, exposures []*model.Exposure, batchTime time.Time) []byte {
+	var keep pb.RevisionTokenData
+	if token != nil {
+		// put existing tokens that aren't too old back in the token.
+		retainInterval := model.IntervalNumber(batchTime.Add(-1 * s.config.MaxIntervalAge))
+		for _, rk := range token.RevisableKeys {
+			if rk.IntervalNumber+rk.IntervalCount >= retainInterval {
+				keep.RevisableKeys = append(keep.RevisableKeys, rk)
+			}
+		}
+	}
+
+	newToken := make([]byte, 0)
+	if len(keep.RevisableKeys) != 0 || len(exposures) != 0 {
+		var err error
+		newToken, err = s.tokenManager.MakeRevisionToken(ctx, &keep, exposures, s.tokenAAD)
+		if err != nil {
+			// Something failed with the revision token generation or encryption.
+			logging.FromContext(ctx).Errorw("failed to make updated revision token", "error", err)
+		}
+	}
+	return newToken
+}
+
+// recordCertificate records the successful publish with the verification
+// certificate in the certificate throttle. Certificates are only known if they
+// were verified.
+func (s *Server) recordCertificate(ctx context.Context, claims *verification.VerifiedClaims) {
+	if claims == nil {
+		return
+	}
+	if !s.certificateThrottle.record(claims.HealthAuthorityID, claims.CertificateID, time.Now()) {
+		return
+	}
+	tags := []tag.Mutator{tag.Upsert(healthAuthorityIDTag, strconv.FormatInt(claims.HealthAuthorityID, 10))}
+	if err := stats.RecordWithTags(ctx, tags, mCertificateThrottleEvicted.M(1)); err != nil {
+		logging.FromContext(ctx).Named("recordCertificate").
+			Errorw("failed to record certificate throttle eviction", "error", err)
+	}
+}
+
+// checkPadding enforces MaxPublishPaddingBytes. Oversize padding is truncated
+// or the request is rejected, depending on OversizePaddingPolicy. It returns
+// nil if the request can be processed.
+func (s *Server) checkPadding(data *verifyapi.Publish) *response {
+	max := s.config.MaxPublishPaddingBytes
+	if max <= 0 || int64(len(data.Padding)) <= max {
+		return nil
+	}
+
+	if s.config.OversizePaddingPolicy == model.OversizePaddingTruncate {
+		data.Padding = data.Padding[:max]
+		return nil
+	}
+
+	return &response{
+		status: http.StatusBadRequest,
+		pubResponse: &verifyapi.PublishResponse{
+			ErrorMessage: fmt.Sprintf("padding is %d bytes, more than the limit of %d", len(data.Padding), max),
+			Code:         verifyapi.ErrorPaddingTooLarge,
+		},
+	}
+}
+
+// checkDailyKeyQuota returns the response rejecting the request if the health
+// authority already published its daily key quota, or nil if it may publish.
+// Failing to read the count doesn't reject the request.
+func (s *Server) checkDailyKeyQuota(ctx context.Context, healthAuthorityID int64, now time.Time) *response {
+	logger := logging.FromContext(ctx).Named("checkDailyKeyQuota")
+
+	count, err := s.database.DailyKeyCount(ctx, healthAuthorityID, now)
+	if err != nil {
+		logger.Errorw("failed to read daily key count", "error", err, "healthAuthorityID", healthAuthorityID)
+		return nil
+	}
+	if count < int64(s.config.DailyKeyQuota) {
+		return nil
+	}
+
+	logger.Warnw("health authority exceeded daily key quota", "healthAuthorityID", healthAuthorityID, "keys", count)
+	tags := []tag.Mutator{tag.Upsert(healthAuthorityIDTag, strconv.FormatInt(healthAuthorityID, 10))}
+	if err := stats.RecordWithTags(ctx, tags, mDailyKeyQuotaExceeded.M(1)); err != nil {
+		logger.Errorw("failed to record stats for daily key quota", "error", err, "healthAuthorityID", healthAuthorityID)
+	}
+	return &response{
+		status: http.StatusTooManyRequests,
+		pubResponse: &verifyapi.PublishResponse{
+			ErrorMessage: fmt.Sprintf("health authority published its daily quota of %d keys, try again tomorrow", s.config.DailyKeyQuota),
+			Code:         verifyapi.ErrorDailyKeyQuotaExceeded,
+		},
+	}
+}
+
+// countDailyKeys adds the inserted keys to the daily key count of the health
+// authority. Failures are logged, but never fail the request.
+func (s *Server) countDailyKeys(ctx context.Context, healthAuthorityID int64, now time.Time, resp *database.InsertAndReviseExposuresResponse) {
+	if resp.Inserted == 0 {
+		return
+	}
+	if _, err := s.database.IncrementDailyKeyCount(ctx, healthAuthorityID, now, int64(resp.Inserted)); err != nil {
+		logging.FromContext(ctx).Named("countDailyKeys").
+			Errorw("failed to update daily key count", "error", err, "healthAuthorityID", healthAuthorityID)
+	}
+}
+
+// recordVerification records the outcome of certificate verification in the
+// stats sink in the background. Failures are logged, but never fail the
+// request.
+func (s *Server) recordVerification(ctx context.Context, appConfig *aamodel.AuthorizedApp, data *verifyapi.Publish, verifyErr error) {
+	record := &statssink.VerificationRecord{
+		HealthAuthorityID: data.HealthAuthorityID,
+		AppPackageName:    appConfig.AppPackageName,
+		Success:           verifyErr == nil,
+		CreatedAt:         time.Now().UTC(),
+	}
+	if verifyErr != nil {
+		record.Error = verifyErr.Error()
+		record.Bypassed = appConfig.BypassHealthAuthorityVerification
+	}
+
+	s.recordStats(ctx, "recordVerification", func(ctx context.Context) error {
+		return s.statsSink.RecordVerification(ctx, record)
+	})
+}
+
+// recordPublish records the publish stats in the stats sink. Stats are only
+// recorded if the request included at least one new or revised key with a
+// known health authority. The stats are recorded in the background. Failures
+// are logged, but never fail the request.
+func (s *Server) recordPublish(ctx context.Context, info *model.PublishInfo, resp *database.InsertAndReviseExposuresResponse) {
+	if info == nil || len(resp.Exposures) == 0 {
+		return
+	}
+	healthAuthorityID := resp.Exposures[0].HealthAuthorityID
+	if healthAuthorityID == nil {
+		return
+	}
+
+	// For all practical purposes - this can be no more than a couple hundred TEKs in a single request.
+	info.NumTEKs = int32(resp.Inserted) + int32(resp.Revised)
+	info.Revision = resp.Revised > 0
+
+	haID := *healthAuthorityID
+	s.recordStats(ctx, "recordPublish", func(ctx context.Context) error {
+		return s.statsSink.RecordPublish(ctx, haID, info)
+	})
+}
+
+// recordStats writes a stats record to the stats sink in the background so
+// that a slow sink never holds up the request. The record is written with a
+// context that outlives the request, bounded by the stats sink timeout. If it
+// can't be written, the record is dropped, which is counted and logged.
+func (s *Server) recordStats(ctx context.Context, name string, record func(ctx context.Context) error) {
+	logger := logging.FromContext(ctx).Named(name)
+
+	s.statsRecords.Add(1)
+	go func() {
+		defer s.statsRecords.Done()
+
+		ctx := logging.WithLogger(context.Background(), logger)
+		if timeout := s.config.StatsSink.Timeout; timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		if err := record(ctx); err != nil {
+			stats.Record(ctx, mStatsSinkDropped.M(1))
+			logger.Errorw("failed to record stats, dropping record", "error", err)
+		}
+	}()
+}
+
+// notifyPublish invokes the publish hook if the request inserted or revised
+// any keys. Failures are logged, but never fail the request.
+func (s *Server) notifyPublish(ctx context.Context, data *verifyapi.Publish, regions []string, platform string, resp *database.InsertAndReviseExposuresResponse) {
+	if resp.Inserted == 0 && resp.Revised == 0 {
+		return
+	}
+
+	event := &model.PublishEvent{
+		AppPackageName: data.HealthAuthorityID,
+		Regions:        regions,
+		Platform:       platform,
+		Inserted:       int(resp.Inserted),
+		Revised:        int(resp.Revised),
+		Dropped:        int(resp.Dropped),
+		PublishedAt:    time.Now().UTC(),
+	}
+	if len(resp.Exposures) > 0 && resp.Exposures[0].HealthAuthorityID != nil {
+		event.HealthAuthorityID = *resp.Exposures[0].HealthAuthorityID
+	}
+
+	if err := s.publishHook.OnPublish(ctx, event); err != nil {
+		logger := logging.FromContext(ctx).Named("notifyPublish")
+		logger.Errorw("publish hook failed", "error", err)
+	}
+}
+
 // chaffPushResponse takes a chaffing string, and builds a chaff response.
 func chaffPublishResponse(s string) interface{} {
 	return verifyapi.PublishResponse{Padding: s}
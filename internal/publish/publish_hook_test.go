@@ -0,0 +1,229 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/authorizedapp"
+	aadb "github.com/google/exposure-notifications-server/internal/authorizedapp/database"
+	aamodel "github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	revisiondb "github.com/google/exposure-notifications-server/internal/revision/database"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	testutil "github.com/google/exposure-notifications-server/internal/utils"
+	vermodel "github.com/google/exposure-notifications-server/internal/verification/model"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
+	"github.com/google/exposure-notifications-server/pkg/keys"
+	"github.com/google/exposure-notifications-server/pkg/timeutils"
+	"github.com/google/exposure-notifications-server/pkg/util"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/sethvargo/go-envconfig"
+)
+
+// recordingPublishHook records the events it is called with, and returns err.
+type recordingPublishHook struct {
+	mu     sync.Mutex
+	events []*model.PublishEvent
+	err    error
+}
+
+func (h *recordingPublishHook) OnPublish(_ context.Context, event *model.PublishEvent) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.events = append(h.events, event)
+	return h.err
+}
+
+func (h *recordingPublishHook) Events() []*model.PublishEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]*model.PublishEvent(nil), h.events...)
+}
+
+func TestPublishHook(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name       string
+		hookErr    error
+		badPayload bool
+		status     int
+		wantEvent  bool
+	}{
+		{
+			name:      "success",
+			status:    http.StatusOK,
+			wantEvent: true,
+		},
+		{
+			// Failures in the hook never fail the publish request.
+			name:      "failing_hook",
+			hookErr:   fmt.Errorf("queue unavailable"),
+			status:    http.StatusOK,
+			wantEvent: true,
+		},
+		{
+			name:       "rejected",
+			badPayload: true,
+			status:     http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := project.TestContext(t)
+			testDB, _ := testDatabaseInstance.NewDatabase(t)
+
+			healthAuthority := &vermodel.HealthAuthority{
+				Issuer:   "gov.state.health",
+				Audience: "unit.test.server",
+				Name:     "State Dept of Health",
+			}
+			healthAuthorityKey := &vermodel.HealthAuthorityKey{
+				Version: "v1",
+				From:    time.Now().Add(-1 * time.Minute),
+			}
+			signingKey := testutil.GetSigningKey(t)
+			testutil.InitializeVerificationDB(ctx, t, testDB, healthAuthority, healthAuthorityKey, signingKey)
+
+			authorizedApp := aamodel.NewAuthorizedApp()
+			authorizedApp.AppPackageName = "gov.state.health"
+			authorizedApp.BypassRevisionToken = true
+			authorizedApp.AllowedRegions["US"] = struct{}{}
+			authorizedApp.AllowedHealthAuthorityIDs[healthAuthority.ID] = struct{}{}
+			if err := aadb.New(testDB).InsertAuthorizedApp(ctx, authorizedApp); err != nil {
+				t.Fatal(err)
+			}
+
+			kms := keys.TestKeyManager(t)
+			keyID := keys.TestEncryptionKey(t, kms)
+			revDB, err := revisiondb.New(testDB, &revisiondb.KMSConfig{WrapperKeyID: keyID, KeyManager: kms})
+			if err != nil {
+				t.Fatalf("unable to create revision DB handle: %v", err)
+			}
+			if _, err := revDB.CreateRevisionKey(ctx); err != nil {
+				t.Fatalf("unable to create revision key: %v", err)
+			}
+
+			config := Config{}
+			if err := envconfig.ProcessWith(ctx, &config, envconfig.OsLookuper()); err != nil {
+				t.Fatal(err)
+			}
+			config.AuthorizedApp.CacheDuration = time.Nanosecond
+			config.CreatedAtTruncateWindow = time.Second
+			config.MaxKeysOnPublish = 20
+			config.MaxSameStartIntervalKeys = 2
+			config.MaxIntervalAge = 14 * 24 * time.Hour
+			config.RevisionToken.AAD = make([]byte, 16)
+			if _, err := rand.Read(config.RevisionToken.AAD); err != nil {
+				t.Fatalf("not enough entropy: %v", err)
+			}
+			config.RevisionToken.KeyID = keyID
+
+			aaProvider, err := authorizedapp.NewDatabaseProvider(ctx, testDB, config.AuthorizedAppConfig())
+			if err != nil {
+				t.Fatal(err)
+			}
+			hook := &recordingPublishHook{err: tc.hookErr}
+			env := serverenv.New(ctx,
+				serverenv.WithDatabase(testDB),
+				serverenv.WithAuthorizedAppProvider(aaProvider),
+				serverenv.WithKeyManager(kms),
+				serverenv.WithPublishHook(hook))
+
+			publishServer, err := NewServer(ctx, &config, env)
+			if err != nil {
+				t.Fatalf("unable to create publish handler: %v", err)
+			}
+
+			publish := &verifyapi.Publish{
+				Keys:              util.GenerateExposureKeys(2, 0, false),
+				HealthAuthorityID: healthAuthority.Issuer,
+			}
+			utcDay := timeutils.UTCMidnight(time.Now())
+			verification, salt := testutil.IssueJWT(t, &testutil.JWTConfig{
+				HealthAuthority:      healthAuthority,
+				HealthAuthorityKey:   healthAuthorityKey,
+				ExposureKeys:         publish.Keys,
+				Key:                  signingKey.Key,
+				SymptomOnsetInterval: uint32(utcDay.Unix()/600) - verifyapi.MaxIntervalCount,
+				ReportType:           verifyapi.ReportTypeConfirmed,
+			})
+			publish.VerificationPayload = verification
+			publish.HMACKey = salt
+			if tc.badPayload {
+				publish.VerificationPayload = "not-a-jwt"
+			}
+
+			body, err := json.Marshal(publish)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request, err := http.NewRequestWithContext(ctx, http.MethodPost, "", strings.NewReader(string(body)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			request.Header.Set("Content-Type", "application/json")
+
+			rr := httptest.NewRecorder()
+			publishServer.handlePublishV1().ServeHTTP(rr, request)
+
+			if got, want := rr.Code, tc.status; got != want {
+				t.Fatalf("expected status %d to be %d: %s", got, want, rr.Body.String())
+			}
+
+			events := hook.Events()
+			if !tc.wantEvent {
+				if len(events) != 0 {
+					t.Fatalf("expected no publish events, got %#v", events)
+				}
+				return
+			}
+
+			if got, want := len(events), 1; got != want {
+				t.Fatalf("expected %d publish events, got %d", want, got)
+			}
+			want := &model.PublishEvent{
+				AppPackageName:    healthAuthority.Issuer,
+				HealthAuthorityID: healthAuthority.ID,
+				Regions:           []string{"US"},
+				Platform:          model.PlatformUnknown,
+				Inserted:          2,
+			}
+			if diff := cmp.Diff(want, events[0], cmpopts.IgnoreFields(model.PublishEvent{}, "PublishedAt")); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+			if events[0].PublishedAt.IsZero() {
+				t.Errorf("expected PublishedAt to be set")
+			}
+		})
+	}
+}
@@ -32,6 +32,7 @@ import (
 	"github.com/google/exposure-notifications-server/internal/authorizedapp"
 	aadb "github.com/google/exposure-notifications-server/internal/authorizedapp/database"
 	aamodel "github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	"github.com/google/exposure-notifications-server/internal/jsonutil"
 	"github.com/google/exposure-notifications-server/internal/pb"
 	"github.com/google/exposure-notifications-server/internal/project"
 	pubdb "github.com/google/exposure-notifications-server/internal/publish/database"
@@ -1266,3 +1267,1231 @@ func TestKeyRevision(t *testing.T) {
 		})
 	}
 }
+
+func TestPublishResponseCounts(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name          string
+		includeCounts bool
+		existing      int
+		want          *verifyapi.PublishCounts
+	}{
+		{
+			name:          "disabled",
+			includeCounts: false,
+			existing:      1,
+			want:          nil,
+		},
+		{
+			name:          "inserted",
+			includeCounts: true,
+			want:          &verifyapi.PublishCounts{Inserted: 3},
+		},
+		{
+			name:          "revised",
+			includeCounts: true,
+			existing:      1,
+			want:          &verifyapi.PublishCounts{Inserted: 2, Revised: 1},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := project.TestContext(t)
+			testDB, _ := testDatabaseInstance.NewDatabase(t)
+
+			healthAuthority := &vermodel.HealthAuthority{
+				Issuer:   "gov.state.health",
+				Audience: "unit.test.server",
+				Name:     "State Dept of Health",
+			}
+			healthAuthorityKey := &vermodel.HealthAuthorityKey{
+				Version: "v1",
+				From:    time.Now().Add(-1 * time.Minute),
+			}
+			signingKey := testutil.GetSigningKey(t)
+			testutil.InitializeVerificationDB(ctx, t, testDB, healthAuthority, healthAuthorityKey, signingKey)
+
+			authorizedApp := aamodel.NewAuthorizedApp()
+			authorizedApp.AppPackageName = "gov.state.health"
+			authorizedApp.BypassRevisionToken = true
+			authorizedApp.AllowedRegions["US"] = struct{}{}
+			authorizedApp.AllowedHealthAuthorityIDs[healthAuthority.ID] = struct{}{}
+			if err := aadb.New(testDB).InsertAuthorizedApp(ctx, authorizedApp); err != nil {
+				t.Fatal(err)
+			}
+
+			kms := keys.TestKeyManager(t)
+			keyID := keys.TestEncryptionKey(t, kms)
+			revDB, err := revisiondb.New(testDB, &revisiondb.KMSConfig{WrapperKeyID: keyID, KeyManager: kms})
+			if err != nil {
+				t.Fatalf("unable to create revision DB handle: %v", err)
+			}
+			if _, err := revDB.CreateRevisionKey(ctx); err != nil {
+				t.Fatalf("unable to create revision key: %v", err)
+			}
+
+			config := Config{}
+			if err := envconfig.ProcessWith(ctx, &config, envconfig.OsLookuper()); err != nil {
+				t.Fatal(err)
+			}
+			config.AuthorizedApp.CacheDuration = time.Nanosecond
+			config.CreatedAtTruncateWindow = time.Second
+			config.MaxKeysOnPublish = 20
+			config.MaxSameStartIntervalKeys = 2
+			config.MaxIntervalAge = 14 * 24 * time.Hour
+			config.ResponseIncludeCounts = tc.includeCounts
+			config.RevisionToken.AAD = make([]byte, 16)
+			if _, err := rand.Read(config.RevisionToken.AAD); err != nil {
+				t.Fatalf("not enough entropy: %v", err)
+			}
+			config.RevisionToken.KeyID = keyID
+
+			aaProvider, err := authorizedapp.NewDatabaseProvider(ctx, testDB, config.AuthorizedAppConfig())
+			if err != nil {
+				t.Fatal(err)
+			}
+			env := serverenv.New(ctx,
+				serverenv.WithDatabase(testDB),
+				serverenv.WithAuthorizedAppProvider(aaProvider),
+				serverenv.WithKeyManager(kms))
+
+			publishServer, err := NewServer(ctx, &config, env)
+			if err != nil {
+				t.Fatalf("unable to create publish handler: %v", err)
+			}
+
+			publish := &verifyapi.Publish{
+				Keys:              util.GenerateExposureKeys(3, 0, false),
+				HealthAuthorityID: healthAuthority.Issuer,
+			}
+
+			// Insert some of the keys ahead of time as likely, so the publish
+			// revises them to confirmed.
+			var existing []*model.Exposure
+			for _, key := range publish.Keys[:tc.existing] {
+				b, err := base64util.DecodeString(key.Key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				existing = append(existing, &model.Exposure{
+					ExposureKey:     b,
+					IntervalNumber:  key.IntervalNumber,
+					IntervalCount:   key.IntervalCount,
+					ReportType:      verifyapi.ReportTypeClinical,
+					Regions:         []string{"US"},
+					LocalProvenance: true,
+					CreatedAt:       time.Now().UTC().Truncate(time.Hour),
+				})
+			}
+			if len(existing) > 0 {
+				if _, err := pubdb.New(testDB).InsertAndReviseExposures(ctx, &pubdb.InsertAndReviseExposuresRequest{
+					Incoming: existing,
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			utcDay := timeutils.UTCMidnight(time.Now())
+			verification, salt := testutil.IssueJWT(t, &testutil.JWTConfig{
+				HealthAuthority:      healthAuthority,
+				HealthAuthorityKey:   healthAuthorityKey,
+				ExposureKeys:         publish.Keys,
+				Key:                  signingKey.Key,
+				SymptomOnsetInterval: uint32(utcDay.Unix()/600) - verifyapi.MaxIntervalCount,
+				ReportType:           verifyapi.ReportTypeConfirmed,
+			})
+			publish.VerificationPayload = verification
+			publish.HMACKey = salt
+
+			body, err := json.Marshal(publish)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request, err := http.NewRequestWithContext(ctx, http.MethodPost, "", bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			request.Header.Set("Content-Type", "application/json")
+
+			rr := httptest.NewRecorder()
+			publishServer.handlePublishV1().ServeHTTP(rr, request)
+			if got, want := rr.Code, http.StatusOK; got != want {
+				t.Fatalf("expected status %d to be %d: %s", got, want, rr.Body.String())
+			}
+
+			var response verifyapi.PublishResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, response.Counts); diff != "" {
+				t.Errorf("counts mismatch (-want, +got):\n%s", diff)
+			}
+
+			// The counts are never in the response when disabled.
+			if !tc.includeCounts && strings.Contains(rr.Body.String(), `"counts"`) {
+				t.Errorf("expected no counts in response: %s", rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestPublishInvalidKeyEncoding(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name         string
+		malformed    []int
+		wantStatus   int
+		wantCode     string
+		wantInserted int
+	}{
+		{
+			name:         "malformed_among_valid",
+			malformed:    []int{1},
+			wantStatus:   http.StatusOK,
+			wantCode:     verifyapi.ErrorPartialFailure,
+			wantInserted: 2,
+		},
+		{
+			name:       "all_malformed",
+			malformed:  []int{0, 1, 2},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   verifyapi.ErrorInvalidKeyEncoding,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := project.TestContext(t)
+			testDB, _ := testDatabaseInstance.NewDatabase(t)
+
+			healthAuthority := &vermodel.HealthAuthority{
+				Issuer:   "gov.state.health",
+				Audience: "unit.test.server",
+				Name:     "State Dept of Health",
+			}
+			healthAuthorityKey := &vermodel.HealthAuthorityKey{
+				Version: "v1",
+				From:    time.Now().Add(-1 * time.Minute),
+			}
+			signingKey := testutil.GetSigningKey(t)
+			testutil.InitializeVerificationDB(ctx, t, testDB, healthAuthority, healthAuthorityKey, signingKey)
+
+			authorizedApp := aamodel.NewAuthorizedApp()
+			authorizedApp.AppPackageName = "gov.state.health"
+			authorizedApp.BypassRevisionToken = true
+			authorizedApp.AllowedRegions["US"] = struct{}{}
+			authorizedApp.AllowedHealthAuthorityIDs[healthAuthority.ID] = struct{}{}
+			if err := aadb.New(testDB).InsertAuthorizedApp(ctx, authorizedApp); err != nil {
+				t.Fatal(err)
+			}
+
+			kms := keys.TestKeyManager(t)
+			keyID := keys.TestEncryptionKey(t, kms)
+			revDB, err := revisiondb.New(testDB, &revisiondb.KMSConfig{WrapperKeyID: keyID, KeyManager: kms})
+			if err != nil {
+				t.Fatalf("unable to create revision DB handle: %v", err)
+			}
+			if _, err := revDB.CreateRevisionKey(ctx); err != nil {
+				t.Fatalf("unable to create revision key: %v", err)
+			}
+
+			config := Config{}
+			if err := envconfig.ProcessWith(ctx, &config, envconfig.OsLookuper()); err != nil {
+				t.Fatal(err)
+			}
+			config.AuthorizedApp.CacheDuration = time.Nanosecond
+			config.CreatedAtTruncateWindow = time.Second
+			config.MaxKeysOnPublish = 20
+			config.MaxSameStartIntervalKeys = 2
+			config.MaxIntervalAge = 14 * 24 * time.Hour
+			config.RevisionToken.AAD = make([]byte, 16)
+			if _, err := rand.Read(config.RevisionToken.AAD); err != nil {
+				t.Fatalf("not enough entropy: %v", err)
+			}
+			config.RevisionToken.KeyID = keyID
+
+			aaProvider, err := authorizedapp.NewDatabaseProvider(ctx, testDB, config.AuthorizedAppConfig())
+			if err != nil {
+				t.Fatal(err)
+			}
+			env := serverenv.New(ctx,
+				serverenv.WithDatabase(testDB),
+				serverenv.WithAuthorizedAppProvider(aaProvider),
+				serverenv.WithKeyManager(kms))
+
+			publishServer, err := NewServer(ctx, &config, env)
+			if err != nil {
+				t.Fatalf("unable to create publish handler: %v", err)
+			}
+
+			publish := &verifyapi.Publish{
+				Keys:              util.GenerateExposureKeys(3, 0, false),
+				HealthAuthorityID: healthAuthority.Issuer,
+			}
+			for _, i := range tc.malformed {
+				publish.Keys[i].Key = "not*base64!" + publish.Keys[i].Key
+			}
+
+			utcDay := timeutils.UTCMidnight(time.Now())
+			verification, salt := testutil.IssueJWT(t, &testutil.JWTConfig{
+				HealthAuthority:      healthAuthority,
+				HealthAuthorityKey:   healthAuthorityKey,
+				ExposureKeys:         publish.Keys,
+				Key:                  signingKey.Key,
+				SymptomOnsetInterval: uint32(utcDay.Unix()/600) - verifyapi.MaxIntervalCount,
+				ReportType:           verifyapi.ReportTypeConfirmed,
+			})
+			publish.VerificationPayload = verification
+			publish.HMACKey = salt
+
+			body, err := json.Marshal(publish)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request, err := http.NewRequestWithContext(ctx, http.MethodPost, "", bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			request.Header.Set("Content-Type", "application/json")
+
+			rr := httptest.NewRecorder()
+			publishServer.handlePublishV1().ServeHTTP(rr, request)
+			if got, want := rr.Code, tc.wantStatus; got != want {
+				t.Fatalf("expected status %d to be %d: %s", got, want, rr.Body.String())
+			}
+
+			var response verifyapi.PublishResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := response.Code, tc.wantCode; got != want {
+				t.Errorf("expected code %q to be %q", got, want)
+			}
+			if got, want := response.InsertedExposures, tc.wantInserted; got != want {
+				t.Errorf("expected %d inserted exposures to be %d", got, want)
+			}
+
+			// Computing the HMAC sorts the keys, so find where the malformed keys
+			// ended up.
+			for i, k := range publish.Keys {
+				if !strings.HasPrefix(k.Key, "not*base64!") {
+					continue
+				}
+				if want := fmt.Sprintf("key %d has invalid encoding", i); !strings.Contains(response.ErrorMessage, want) {
+					t.Errorf("expected error message %q to contain %q", response.ErrorMessage, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPublishEnforceKeyLength(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name         string
+		enforce      bool
+		wantStatus   int
+		wantCode     string
+		wantInserted int
+	}{
+		{
+			name:         "not_enforced",
+			wantStatus:   http.StatusOK,
+			wantCode:     verifyapi.ErrorPartialFailure,
+			wantInserted: 2,
+		},
+		{
+			name:       "enforced",
+			enforce:    true,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   verifyapi.ErrorInvalidKeyLength,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := project.TestContext(t)
+			testDB, _ := testDatabaseInstance.NewDatabase(t)
+
+			healthAuthority := &vermodel.HealthAuthority{
+				Issuer:   "gov.state.health",
+				Audience: "unit.test.server",
+				Name:     "State Dept of Health",
+			}
+			healthAuthorityKey := &vermodel.HealthAuthorityKey{
+				Version: "v1",
+				From:    time.Now().Add(-1 * time.Minute),
+			}
+			signingKey := testutil.GetSigningKey(t)
+			testutil.InitializeVerificationDB(ctx, t, testDB, healthAuthority, healthAuthorityKey, signingKey)
+
+			authorizedApp := aamodel.NewAuthorizedApp()
+			authorizedApp.AppPackageName = "gov.state.health"
+			authorizedApp.BypassRevisionToken = true
+			authorizedApp.AllowedRegions["US"] = struct{}{}
+			authorizedApp.AllowedHealthAuthorityIDs[healthAuthority.ID] = struct{}{}
+			if err := aadb.New(testDB).InsertAuthorizedApp(ctx, authorizedApp); err != nil {
+				t.Fatal(err)
+			}
+
+			kms := keys.TestKeyManager(t)
+			keyID := keys.TestEncryptionKey(t, kms)
+			revDB, err := revisiondb.New(testDB, &revisiondb.KMSConfig{WrapperKeyID: keyID, KeyManager: kms})
+			if err != nil {
+				t.Fatalf("unable to create revision DB handle: %v", err)
+			}
+			if _, err := revDB.CreateRevisionKey(ctx); err != nil {
+				t.Fatalf("unable to create revision key: %v", err)
+			}
+
+			config := Config{}
+			if err := envconfig.ProcessWith(ctx, &config, envconfig.OsLookuper()); err != nil {
+				t.Fatal(err)
+			}
+			config.AuthorizedApp.CacheDuration = time.Nanosecond
+			config.CreatedAtTruncateWindow = time.Second
+			config.MaxKeysOnPublish = 20
+			config.MaxSameStartIntervalKeys = 2
+			config.MaxIntervalAge = 14 * 24 * time.Hour
+			config.EnforceKeyLength = tc.enforce
+			config.RevisionToken.AAD = make([]byte, 16)
+			if _, err := rand.Read(config.RevisionToken.AAD); err != nil {
+				t.Fatalf("not enough entropy: %v", err)
+			}
+			config.RevisionToken.KeyID = keyID
+
+			aaProvider, err := authorizedapp.NewDatabaseProvider(ctx, testDB, config.AuthorizedAppConfig())
+			if err != nil {
+				t.Fatal(err)
+			}
+			env := serverenv.New(ctx,
+				serverenv.WithDatabase(testDB),
+				serverenv.WithAuthorizedAppProvider(aaProvider),
+				serverenv.WithKeyManager(kms))
+
+			publishServer, err := NewServer(ctx, &config, env)
+			if err != nil {
+				t.Fatalf("unable to create publish handler: %v", err)
+			}
+
+			publish := &verifyapi.Publish{
+				Keys:              util.GenerateExposureKeys(3, 0, false),
+				HealthAuthorityID: healthAuthority.Issuer,
+			}
+			publish.Keys[1].Key = base64.StdEncoding.EncodeToString(make([]byte, verifyapi.KeyLength+1))
+
+			utcDay := timeutils.UTCMidnight(time.Now())
+			verification, salt := testutil.IssueJWT(t, &testutil.JWTConfig{
+				HealthAuthority:      healthAuthority,
+				HealthAuthorityKey:   healthAuthorityKey,
+				ExposureKeys:         publish.Keys,
+				Key:                  signingKey.Key,
+				SymptomOnsetInterval: uint32(utcDay.Unix()/600) - verifyapi.MaxIntervalCount,
+				ReportType:           verifyapi.ReportTypeConfirmed,
+			})
+			publish.VerificationPayload = verification
+			publish.HMACKey = salt
+
+			body, err := json.Marshal(publish)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request, err := http.NewRequestWithContext(ctx, http.MethodPost, "", bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			request.Header.Set("Content-Type", "application/json")
+
+			rr := httptest.NewRecorder()
+			publishServer.handlePublishV1().ServeHTTP(rr, request)
+			if got, want := rr.Code, tc.wantStatus; got != want {
+				t.Fatalf("expected status %d to be %d: %s", got, want, rr.Body.String())
+			}
+
+			var response verifyapi.PublishResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := response.Code, tc.wantCode; got != want {
+				t.Errorf("expected code %q to be %q", got, want)
+			}
+			if got, want := response.InsertedExposures, tc.wantInserted; got != want {
+				t.Errorf("expected %d inserted exposures to be %d", got, want)
+			}
+
+			// Computing the HMAC sorts the keys, so find where the long key ended
+			// up.
+			for i, k := range publish.Keys {
+				if k.Key != base64.StdEncoding.EncodeToString(make([]byte, verifyapi.KeyLength+1)) {
+					continue
+				}
+				if want := fmt.Sprintf("key %d has invalid length 17", i); !strings.Contains(response.ErrorMessage, want) {
+					t.Errorf("expected error message %q to contain %q", response.ErrorMessage, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPublishMissingRegion(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name          string
+		policy        model.MissingRegionPolicy
+		defaultRegion string
+		wantStatus    int
+		wantCode      string
+		wantRegions   []string
+	}{
+		{
+			name:          "default_applied",
+			policy:        model.MissingRegionDefault,
+			defaultRegion: "US",
+			wantStatus:    http.StatusOK,
+			wantRegions:   []string{"US"},
+		},
+		{
+			name:       "default_not_configured",
+			policy:     model.MissingRegionDefault,
+			wantStatus: http.StatusInternalServerError,
+			wantCode:   verifyapi.ErrorHealthAuthorityMissingRegionConfiguration,
+		},
+		{
+			name:          "reject",
+			policy:        model.MissingRegionReject,
+			defaultRegion: "US",
+			wantStatus:    http.StatusInternalServerError,
+			wantCode:      verifyapi.ErrorHealthAuthorityMissingRegionConfiguration,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := project.TestContext(t)
+			testDB, _ := testDatabaseInstance.NewDatabase(t)
+
+			healthAuthority := &vermodel.HealthAuthority{
+				Issuer:   "gov.state.health",
+				Audience: "unit.test.server",
+				Name:     "State Dept of Health",
+			}
+			healthAuthorityKey := &vermodel.HealthAuthorityKey{
+				Version: "v1",
+				From:    time.Now().Add(-1 * time.Minute),
+			}
+			signingKey := testutil.GetSigningKey(t)
+			testutil.InitializeVerificationDB(ctx, t, testDB, healthAuthority, healthAuthorityKey, signingKey)
+
+			// The app has no regions, so the publish relies on the policy.
+			authorizedApp := aamodel.NewAuthorizedApp()
+			authorizedApp.AppPackageName = "gov.state.health"
+			authorizedApp.BypassRevisionToken = true
+			authorizedApp.AllowedHealthAuthorityIDs[healthAuthority.ID] = struct{}{}
+			if err := aadb.New(testDB).InsertAuthorizedApp(ctx, authorizedApp); err != nil {
+				t.Fatal(err)
+			}
+
+			kms := keys.TestKeyManager(t)
+			keyID := keys.TestEncryptionKey(t, kms)
+			revDB, err := revisiondb.New(testDB, &revisiondb.KMSConfig{WrapperKeyID: keyID, KeyManager: kms})
+			if err != nil {
+				t.Fatalf("unable to create revision DB handle: %v", err)
+			}
+			if _, err := revDB.CreateRevisionKey(ctx); err != nil {
+				t.Fatalf("unable to create revision key: %v", err)
+			}
+
+			config := Config{}
+			if err := envconfig.ProcessWith(ctx, &config, envconfig.OsLookuper()); err != nil {
+				t.Fatal(err)
+			}
+			config.AuthorizedApp.CacheDuration = time.Nanosecond
+			config.CreatedAtTruncateWindow = time.Second
+			config.MaxKeysOnPublish = 20
+			config.MaxSameStartIntervalKeys = 2
+			config.MaxIntervalAge = 14 * 24 * time.Hour
+			config.DefaultRegion = tc.defaultRegion
+			config.MissingRegionPolicy = tc.policy
+			config.RevisionToken.AAD = make([]byte, 16)
+			if _, err := rand.Read(config.RevisionToken.AAD); err != nil {
+				t.Fatalf("not enough entropy: %v", err)
+			}
+			config.RevisionToken.KeyID = keyID
+
+			aaProvider, err := authorizedapp.NewDatabaseProvider(ctx, testDB, config.AuthorizedAppConfig())
+			if err != nil {
+				t.Fatal(err)
+			}
+			env := serverenv.New(ctx,
+				serverenv.WithDatabase(testDB),
+				serverenv.WithAuthorizedAppProvider(aaProvider),
+				serverenv.WithKeyManager(kms))
+
+			publishServer, err := NewServer(ctx, &config, env)
+			if err != nil {
+				t.Fatalf("unable to create publish handler: %v", err)
+			}
+
+			publish := &verifyapi.Publish{
+				Keys:              util.GenerateExposureKeys(3, 0, false),
+				HealthAuthorityID: healthAuthority.Issuer,
+			}
+			utcDay := timeutils.UTCMidnight(time.Now())
+			verification, salt := testutil.IssueJWT(t, &testutil.JWTConfig{
+				HealthAuthority:      healthAuthority,
+				HealthAuthorityKey:   healthAuthorityKey,
+				ExposureKeys:         publish.Keys,
+				Key:                  signingKey.Key,
+				SymptomOnsetInterval: uint32(utcDay.Unix()/600) - verifyapi.MaxIntervalCount,
+				ReportType:           verifyapi.ReportTypeConfirmed,
+			})
+			publish.VerificationPayload = verification
+			publish.HMACKey = salt
+
+			body, err := json.Marshal(publish)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request, err := http.NewRequestWithContext(ctx, http.MethodPost, "", bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			request.Header.Set("Content-Type", "application/json")
+
+			rr := httptest.NewRecorder()
+			publishServer.handlePublishV1().ServeHTTP(rr, request)
+			if got, want := rr.Code, tc.wantStatus; got != want {
+				t.Fatalf("expected status %d to be %d: %s", got, want, rr.Body.String())
+			}
+
+			var response verifyapi.PublishResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := response.Code, tc.wantCode; got != want {
+				t.Errorf("expected code %q to be %q: %s", got, want, response.ErrorMessage)
+			}
+
+			var count int
+			if _, err := pubdb.New(testDB).IterateExposures(ctx, pubdb.IterateExposuresCriteria{}, func(e *model.Exposure) error {
+				count++
+				if diff := cmp.Diff(tc.wantRegions, e.Regions); diff != "" {
+					t.Errorf("regions mismatch (-want, +got):\n%s", diff)
+				}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if tc.wantStatus != http.StatusOK && count != 0 {
+				t.Errorf("expected no exposures to be saved, got %d", count)
+			}
+			if tc.wantStatus == http.StatusOK && count != len(publish.Keys) {
+				t.Errorf("expected %d exposures to be saved, got %d", len(publish.Keys), count)
+			}
+		})
+	}
+}
+
+func TestPublishMaxBodyBytes(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	// The padding alone is larger than the limit. A body under the limit is
+	// decoded, so the unknown field is reported instead.
+	oversize := `{"appPackageName": "com.example.app", "padding": "` + strings.Repeat("0", 1024) + `"}`
+	undersize := `{"appPackageName": "com.example.app", "notAField": true}`
+
+	cases := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantError  string
+	}{
+		{
+			name:       "oversize",
+			body:       oversize,
+			wantStatus: http.StatusRequestEntityTooLarge,
+			wantError:  "request body too large",
+		},
+		{
+			name:       "undersize",
+			body:       undersize,
+			wantStatus: http.StatusBadRequest,
+			wantError:  "unknown field",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := &Server{config: &Config{MaxPublishBodyBytes: 512}}
+			for name, handler := range map[string]http.Handler{
+				"v1":       s.handlePublishV1(),
+				"v1alpha1": s.handlePublishV1Alpha1(),
+			} {
+				request, err := http.NewRequestWithContext(ctx, http.MethodPost, "", strings.NewReader(tc.body))
+				if err != nil {
+					t.Fatal(err)
+				}
+				request.Header.Set("Content-Type", "application/json")
+
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, request)
+				if got, want := rr.Code, tc.wantStatus; got != want {
+					t.Errorf("%s: expected status %d to be %d: %s", name, got, want, rr.Body.String())
+				}
+				if !strings.Contains(rr.Body.String(), tc.wantError) {
+					t.Errorf("%s: expected body %s to contain %q", name, rr.Body.String(), tc.wantError)
+				}
+			}
+		})
+	}
+}
+
+func TestPublishMaxPaddingBytes(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	// The padding is under the body limit, but over the padding limit.
+	body := `{"healthAuthorityID": "com.example.app", "padding": "` + strings.Repeat("0", 300) + `"}`
+	s := &Server{config: &Config{
+		MaxPublishBodyBytes:    1024,
+		MaxPublishPaddingBytes: 256,
+		OversizePaddingPolicy:  model.OversizePaddingReject,
+	}}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, "", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	s.handlePublishV1().ServeHTTP(rr, request)
+	if got, want := rr.Code, http.StatusBadRequest; got != want {
+		t.Errorf("expected status %d to be %d: %s", got, want, rr.Body.String())
+	}
+
+	var response verifyapi.PublishResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := response.Code, verifyapi.ErrorPaddingTooLarge; got != want {
+		t.Errorf("expected code %q to be %q", got, want)
+	}
+	if got, want := response.ErrorMessage, "padding is 300 bytes, more than the limit of 256"; got != want {
+		t.Errorf("expected error %q to be %q", got, want)
+	}
+}
+
+func TestCheckPadding(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name        string
+		max         int64
+		policy      model.OversizePaddingPolicy
+		padding     int
+		wantPadding int
+		wantError   bool
+	}{
+		{
+			name:        "no_limit",
+			padding:     2048,
+			policy:      model.OversizePaddingReject,
+			wantPadding: 2048,
+		},
+		{
+			name:        "within_limit",
+			max:         1024,
+			policy:      model.OversizePaddingReject,
+			padding:     1024,
+			wantPadding: 1024,
+		},
+		{
+			name:      "reject",
+			max:       1024,
+			policy:    model.OversizePaddingReject,
+			padding:   1025,
+			wantError: true,
+		},
+		{
+			name:        "truncate",
+			max:         1024,
+			policy:      model.OversizePaddingTruncate,
+			padding:     4096,
+			wantPadding: 1024,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := &Server{config: &Config{
+				MaxPublishPaddingBytes: tc.max,
+				OversizePaddingPolicy:  tc.policy,
+			}}
+			data := &verifyapi.Publish{Padding: strings.Repeat("0", tc.padding)}
+
+			resp := s.checkPadding(data)
+			if tc.wantError {
+				if resp == nil {
+					t.Fatal("expected request to be rejected")
+				}
+				if got, want := resp.pubResponse.Code, verifyapi.ErrorPaddingTooLarge; got != want {
+					t.Errorf("expected code %q to be %q", got, want)
+				}
+				return
+			}
+			if resp != nil {
+				t.Fatalf("expected request to be accepted, got %#v", resp.pubResponse)
+			}
+			if got, want := len(data.Padding), tc.wantPadding; got != want {
+				t.Errorf("expected padding of %d bytes, got %d", want, got)
+			}
+		})
+	}
+}
+
+func TestPublishSchemaVersions(t *testing.T) {
+	t.Parallel()
+
+	exposureKeys := util.GenerateExposureKeys(3, 0, false)
+	b64keys := make([]string, 0, len(exposureKeys))
+	for _, k := range exposureKeys {
+		b64keys = append(b64keys, k.Key)
+	}
+
+	// publishWithSchema publishes the keys to a new database with the given
+	// schema version and returns the stored exposures, sorted by key.
+	publishWithSchema := func(t *testing.T, schemaVersion string) []*model.Exposure {
+		t.Helper()
+
+		ctx := project.TestContext(t)
+		testDB, _ := testDatabaseInstance.NewDatabase(t)
+
+		healthAuthority := &vermodel.HealthAuthority{
+			Issuer:   "gov.state.health",
+			Audience: "unit.test.server",
+			Name:     "State Dept of Health",
+		}
+		healthAuthorityKey := &vermodel.HealthAuthorityKey{
+			Version: "v1",
+			From:    time.Now().Add(-1 * time.Minute),
+		}
+		signingKey := testutil.GetSigningKey(t)
+		testutil.InitializeVerificationDB(ctx, t, testDB, healthAuthority, healthAuthorityKey, signingKey)
+
+		authorizedApp := aamodel.NewAuthorizedApp()
+		authorizedApp.AppPackageName = "gov.state.health"
+		authorizedApp.BypassRevisionToken = true
+		authorizedApp.AllowedRegions["US"] = struct{}{}
+		authorizedApp.AllowedHealthAuthorityIDs[healthAuthority.ID] = struct{}{}
+		if err := aadb.New(testDB).InsertAuthorizedApp(ctx, authorizedApp); err != nil {
+			t.Fatal(err)
+		}
+
+		kms := keys.TestKeyManager(t)
+		keyID := keys.TestEncryptionKey(t, kms)
+		revDB, err := revisiondb.New(testDB, &revisiondb.KMSConfig{WrapperKeyID: keyID, KeyManager: kms})
+		if err != nil {
+			t.Fatalf("unable to create revision DB handle: %v", err)
+		}
+		if _, err := revDB.CreateRevisionKey(ctx); err != nil {
+			t.Fatalf("unable to create revision key: %v", err)
+		}
+
+		config := Config{}
+		if err := envconfig.ProcessWith(ctx, &config, envconfig.OsLookuper()); err != nil {
+			t.Fatal(err)
+		}
+		config.AuthorizedApp.CacheDuration = time.Nanosecond
+		config.CreatedAtTruncateWindow = time.Second
+		config.MaxKeysOnPublish = 20
+		config.MaxSameStartIntervalKeys = 2
+		config.MaxIntervalAge = 14 * 24 * time.Hour
+		config.PublishSchemaVersions = []string{SchemaVersionV1, SchemaVersionV1Alpha1}
+		config.RevisionToken.AAD = make([]byte, 16)
+		if _, err := rand.Read(config.RevisionToken.AAD); err != nil {
+			t.Fatalf("not enough entropy: %v", err)
+		}
+		config.RevisionToken.KeyID = keyID
+
+		aaProvider, err := authorizedapp.NewDatabaseProvider(ctx, testDB, config.AuthorizedAppConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		env := serverenv.New(ctx,
+			serverenv.WithDatabase(testDB),
+			serverenv.WithAuthorizedAppProvider(aaProvider),
+			serverenv.WithKeyManager(kms))
+
+		publishServer, err := NewServer(ctx, &config, env)
+		if err != nil {
+			t.Fatalf("unable to create publish handler: %v", err)
+		}
+
+		utcDay := timeutils.UTCMidnight(time.Now())
+		verification, salt := testutil.IssueJWT(t, &testutil.JWTConfig{
+			HealthAuthority:      healthAuthority,
+			HealthAuthorityKey:   healthAuthorityKey,
+			ExposureKeys:         exposureKeys,
+			Key:                  signingKey.Key,
+			SymptomOnsetInterval: uint32(utcDay.Unix()/600) - verifyapi.MaxIntervalCount,
+			ReportType:           verifyapi.ReportTypeConfirmed,
+		})
+
+		var payload interface{}
+		switch schemaVersion {
+		case SchemaVersionV1Alpha1:
+			alpha1Keys := make([]v1alpha1.ExposureKey, 0, len(exposureKeys))
+			for _, k := range exposureKeys {
+				alpha1Keys = append(alpha1Keys, v1alpha1.ExposureKey{
+					Key:              k.Key,
+					IntervalNumber:   k.IntervalNumber,
+					IntervalCount:    k.IntervalCount,
+					TransmissionRisk: k.TransmissionRisk,
+				})
+			}
+			payload = &v1alpha1.Publish{
+				Keys:                alpha1Keys,
+				Regions:             []string{"US"},
+				AppPackageName:      healthAuthority.Issuer,
+				VerificationPayload: verification,
+				HMACKey:             salt,
+			}
+		default:
+			payload = &verifyapi.Publish{
+				Keys:                exposureKeys,
+				HealthAuthorityID:   healthAuthority.Issuer,
+				VerificationPayload: verification,
+				HMACKey:             salt,
+			}
+		}
+
+		body, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request, err := http.NewRequestWithContext(ctx, http.MethodPost, "", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set(HeaderSchemaVersion, schemaVersion)
+
+		rr := httptest.NewRecorder()
+		publishServer.handlePublishV1().ServeHTTP(rr, request)
+		if got, want := rr.Code, http.StatusOK; got != want {
+			t.Fatalf("%s: expected status %d to be %d: %s", schemaVersion, got, want, rr.Body.String())
+		}
+
+		exposures, err := pubdb.New(testDB).LookupExposures(ctx, b64keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]*model.Exposure, 0, len(exposures))
+		for _, e := range exposures {
+			got = append(got, e)
+		}
+		sort.Slice(got, func(i, j int) bool {
+			return got[i].ExposureKeyBase64() < got[j].ExposureKeyBase64()
+		})
+		return got
+	}
+
+	v1 := publishWithSchema(t, SchemaVersionV1)
+	if got, want := len(v1), len(exposureKeys); got != want {
+		t.Fatalf("expected %d stored exposures, got %d", want, got)
+	}
+	alpha1 := publishWithSchema(t, SchemaVersionV1Alpha1)
+
+	if diff := cmp.Diff(v1, alpha1,
+		cmpopts.IgnoreFields(model.Exposure{}, "CreatedAt", "HealthAuthorityID"),
+		cmpopts.IgnoreUnexported(model.Exposure{})); diff != "" {
+		t.Errorf("stored exposures mismatch (-v1, +v1alpha1):\n%s", diff)
+	}
+}
+
+func TestPublishUnsupportedSchemaVersion(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	cases := []struct {
+		name          string
+		versions      []string
+		schemaVersion string
+		wantError     string
+	}{
+		{
+			name:          "unknown",
+			versions:      []string{SchemaVersionV1, SchemaVersionV1Alpha1},
+			schemaVersion: "v2",
+			wantError:     `unsupported publish schema version \"v2\", must be one of v1, v1alpha1`,
+		},
+		{
+			name:          "not_enabled",
+			schemaVersion: SchemaVersionV1Alpha1,
+			wantError:     `unsupported publish schema version \"v1alpha1\", must be one of v1`,
+		},
+		{
+			name:      "default_not_enabled",
+			versions:  []string{SchemaVersionV1Alpha1},
+			wantError: `unsupported publish schema version \"v1\", must be one of v1alpha1`,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := &Server{config: &Config{PublishSchemaVersions: tc.versions}}
+
+			request, err := http.NewRequestWithContext(ctx, http.MethodPost, "", strings.NewReader(`{}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			request.Header.Set("Content-Type", "application/json")
+			if tc.schemaVersion != "" {
+				request.Header.Set(HeaderSchemaVersion, tc.schemaVersion)
+			}
+
+			rr := httptest.NewRecorder()
+			s.handlePublishV1().ServeHTTP(rr, request)
+			if got, want := rr.Code, http.StatusBadRequest; got != want {
+				t.Errorf("expected status %d to be %d: %s", got, want, rr.Body.String())
+			}
+
+			var response verifyapi.PublishResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := response.Code, verifyapi.ErrorUnsupportedSchemaVersion; got != want {
+				t.Errorf("expected code %q to be %q", got, want)
+			}
+			if !strings.Contains(rr.Body.String(), tc.wantError) {
+				t.Errorf("expected body %s to contain %q", rr.Body.String(), tc.wantError)
+			}
+		})
+	}
+}
+
+func TestRoutes_Errors(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	s := &Server{config: &Config{}, env: serverenv.New(ctx)}
+	r := s.Routes(ctx)
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		code   string
+	}{
+		{
+			name:   "unknown_path",
+			method: http.MethodPost,
+			path:   "/v2/publish",
+			status: http.StatusNotFound,
+			code:   verifyapi.ErrorNotFound,
+		},
+		{
+			name:   "unknown_subpath",
+			method: http.MethodPost,
+			path:   "/v1/publish/",
+			status: http.StatusNotFound,
+			code:   verifyapi.ErrorNotFound,
+		},
+		{
+			name:   "wrong_method",
+			method: http.MethodGet,
+			path:   "/v1/publish",
+			status: http.StatusMethodNotAllowed,
+			code:   verifyapi.ErrorMethodNotAllowed,
+		},
+		{
+			name:   "wrong_method_stats",
+			method: http.MethodPut,
+			path:   "/v1/stats",
+			status: http.StatusMethodNotAllowed,
+			code:   verifyapi.ErrorMethodNotAllowed,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+
+			if got, want := w.Code, tc.status; got != want {
+				t.Fatalf("expected status %d to be %d", got, want)
+			}
+			if got, want := w.Header().Get("Content-Type"), "application/json"; got != want {
+				t.Errorf("expected content-type %q to be %q", got, want)
+			}
+			var got verifyapi.ErrorResponse
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := got.Code, tc.code; got != want {
+				t.Errorf("expected code %q to be %q", got, want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalOptions(t *testing.T) {
+	t.Parallel()
+
+	body := `{"appPackageName": "ha.example", "keys": [{"key": "ABC", "rollingStartNumber": 100, "rollingPeriod": 144}], "padding": "abc"}`
+
+	cases := []struct {
+		name          string
+		config        *Config
+		schemaVersion string
+		body          string
+		wantCode      int
+		wantError     string
+		want          *verifyapi.Publish
+	}{
+		{
+			name:          "legacy_names",
+			config:        &Config{AcceptLegacyFieldNames: true},
+			schemaVersion: SchemaVersionV1,
+			body:          body,
+			wantCode:      http.StatusOK,
+			want: &verifyapi.Publish{
+				Keys: []verifyapi.ExposureKey{
+					{Key: "ABC", IntervalNumber: 100, IntervalCount: 144},
+				},
+				HealthAuthorityID: "ha.example",
+				Padding:           "abc",
+			},
+		},
+		{
+			name:          "legacy_names_disabled",
+			config:        &Config{},
+			schemaVersion: SchemaVersionV1,
+			body:          body,
+			wantCode:      http.StatusBadRequest,
+			wantError:     `unknown field "appPackageName"`,
+		},
+		{
+			name:          "legacy_names_not_v1alpha1",
+			config:        &Config{AcceptLegacyFieldNames: true},
+			schemaVersion: SchemaVersionV1Alpha1,
+			body:          body,
+			wantCode:      http.StatusBadRequest,
+			wantError:     `unknown field "keys"`,
+		},
+		{
+			name:          "strict_unknown_field",
+			config:        &Config{AcceptLegacyFieldNames: true},
+			schemaVersion: SchemaVersionV1,
+			body:          `{"healthAuthorityID": "ha.example", "deviceModel": "phone"}`,
+			wantCode:      http.StatusBadRequest,
+			wantError:     `unknown field "deviceModel"`,
+		},
+		{
+			name:          "allow_unknown_field",
+			config:        &Config{AllowUnknownPublishFields: true},
+			schemaVersion: SchemaVersionV1,
+			body:          `{"healthAuthorityID": "ha.example", "deviceModel": "phone"}`,
+			wantCode:      http.StatusOK,
+			want:          &verifyapi.Publish{HealthAuthorityID: "ha.example"},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := &Server{config: tc.config}
+
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.body))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			var got verifyapi.Publish
+			var code int
+			var err error
+			if tc.schemaVersion == SchemaVersionV1Alpha1 {
+				code, err = jsonutil.UnmarshalWithOptions(w, r, &v1alpha1.Publish{}, s.unmarshalOptions(tc.schemaVersion))
+			} else {
+				code, err = jsonutil.UnmarshalWithOptions(w, r, &got, s.unmarshalOptions(tc.schemaVersion))
+			}
+			if code != tc.wantCode {
+				t.Errorf("expected code %d to be %d", code, tc.wantCode)
+			}
+			if tc.wantError != "" {
+				if err == nil || err.Error() != tc.wantError {
+					t.Fatalf("expected error %q, got %v", tc.wantError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, &got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDetachedHMAC(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set("X-TEK-HMAC", " aGVsbG8= ")
+
+	s := &Server{config: &Config{}}
+	if got := s.detachedHMAC(r); got != "" {
+		t.Errorf("expected no detached HMAC without a header, got %q", got)
+	}
+
+	s = &Server{config: &Config{DetachedHMACHeader: "X-TEK-HMAC"}}
+	if got, want := s.detachedHMAC(r), "aGVsbG8="; got != want {
+		t.Errorf("expected detached HMAC %q to be %q", got, want)
+	}
+}
@@ -18,6 +18,7 @@ package publish
 import (
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"go.opencensus.io/stats"
@@ -25,22 +26,116 @@ import (
 
 	"github.com/google/exposure-notifications-server/internal/jsonutil"
 	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
+	"github.com/google/exposure-notifications-server/pkg/api/v1alpha1"
 	"github.com/google/exposure-notifications-server/pkg/logging"
 	obs "github.com/google/exposure-notifications-server/pkg/observability"
 )
 
+const (
+	// SchemaVersionV1 is the v1 publish request body, verifyapi.Publish. It is
+	// used when a v1 publish request doesn't set a schema version.
+	SchemaVersionV1 = "v1"
+	// SchemaVersionV1Alpha1 is the v1alpha1 publish request body,
+	// v1alpha1.Publish, sent to the v1 publish API. The response is v1.
+	SchemaVersionV1Alpha1 = "v1alpha1"
+)
+
+// legacyPublishFields maps the legacy field names of v1 publish requests, sent
+// by older clients, to the fields they were renamed to. They are only accepted
+// if AcceptLegacyFieldNames is set.
+var legacyPublishFields = map[string]string{
+	"appPackageName": "healthAuthorityID",
+	"exposureKeys":   "temporaryExposureKeys",
+	"keys":           "temporaryExposureKeys",
+}
+
+var knownSchemaVersions = []string{SchemaVersionV1, SchemaVersionV1Alpha1}
+
+func validSchemaVersion(v string) bool {
+	for _, known := range knownSchemaVersions {
+		if v == known {
+			return true
+		}
+	}
+	return false
+}
+
+// schemaVersions returns the accepted publish request schema versions.
+func (s *Server) schemaVersions() []string {
+	if len(s.config.PublishSchemaVersions) == 0 {
+		return []string{SchemaVersionV1}
+	}
+	return s.config.PublishSchemaVersions
+}
+
+// acceptsSchemaVersion returns true if publish requests with the schema
+// version are accepted.
+func (s *Server) acceptsSchemaVersion(v string) bool {
+	for _, accepted := range s.schemaVersions() {
+		if v == accepted {
+			return true
+		}
+	}
+	return false
+}
+
+// unmarshalOptions returns the options for decoding publish requests with the
+// schema version.
+func (s *Server) unmarshalOptions(schemaVersion string) *jsonutil.UnmarshalOptions {
+	opts := &jsonutil.UnmarshalOptions{
+		MaxBytes:           s.config.MaxPublishBodyBytes,
+		AllowUnknownFields: s.config.AllowUnknownPublishFields,
+	}
+	if schemaVersion == SchemaVersionV1 && s.config.AcceptLegacyFieldNames {
+		opts.FieldAliases = legacyPublishFields
+	}
+	return opts
+}
+
 func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) *response {
 	ctx, span := trace.StartSpan(r.Context(), "(*publish.PublishHandler).handleRequest")
 	defer span.End()
 
 	w.Header().Set(HeaderAPIVersion, "v1")
 
-	var data verifyapi.Publish
-	code, err := jsonutil.Unmarshal(w, r, &data)
+	schemaVersion := r.Header.Get(HeaderSchemaVersion)
+	if schemaVersion == "" {
+		schemaVersion = SchemaVersionV1
+	}
+	if !s.acceptsSchemaVersion(schemaVersion) {
+		blame := obs.BlameClient
+		obsResult := obs.ResultError("UNSUPPORTED_SCHEMA_VERSION")
+		defer obs.RecordLatency(ctx, time.Now(), mLatencyMs, &blame, &obsResult)
+		message := fmt.Sprintf("unsupported publish schema version %q, must be one of %s",
+			schemaVersion, strings.Join(s.schemaVersions(), ", "))
+		span.SetStatus(trace.Status{Code: trace.StatusCodeInvalidArgument, Message: message})
+		return &response{
+			status: http.StatusBadRequest,
+			pubResponse: &verifyapi.PublishResponse{
+				ErrorMessage: message,
+				Code:         verifyapi.ErrorUnsupportedSchemaVersion,
+			},
+		}
+	}
+
+	var data *verifyapi.Publish
+	var bridge *versionBridge
+	var code int
+	var err error
+	switch schemaVersion {
+	case SchemaVersionV1Alpha1:
+		var alpha1 v1alpha1.Publish
+		code, err = jsonutil.UnmarshalWithOptions(w, r, &alpha1, s.unmarshalOptions(schemaVersion))
+		data, bridge = upconvertV1Alpha1(&alpha1)
+	default:
+		data = new(verifyapi.Publish)
+		code, err = jsonutil.UnmarshalWithOptions(w, r, data, s.unmarshalOptions(schemaVersion))
+		bridge = newVersionBridge([]string{})
+	}
 	if err != nil {
 		if s.config.LogJSONParseErrors {
 			logger := logging.FromContext(ctx).Named("handlePublishV1.handleRequest")
-			logger.Warnw("v1 unmarshal failure", "error", err)
+			logger.Warnw("v1 unmarshal failure", "schema_version", schemaVersion, "error", err)
 		}
 
 		blame := obs.BlameClient
@@ -62,7 +157,7 @@ func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) *response
 	}
 
 	clientPlatform := platform(r.UserAgent())
-	return s.process(ctx, &data, clientPlatform, newVersionBridge([]string{}))
+	return s.process(ctx, data, clientPlatform, s.detachedHMAC(r), bridge)
 }
 
 // handlePublishV1 returns an http.Handler that can process V1 publish requests.
@@ -81,6 +176,7 @@ func (s *Server) handlePublishV1() http.Handler {
 			response.pubResponse.Padding = padding
 		}
 
+		response.setRetryAfter(w)
 		jsonutil.MarshalResponse(w, response.status, response.pubResponse)
 	})
 }
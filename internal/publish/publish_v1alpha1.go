@@ -37,7 +37,7 @@ func (s *Server) handleV1Apha1Request(w http.ResponseWriter, r *http.Request) *r
 	w.Header().Set(HeaderAPIVersion, "v1alpha")
 
 	var data v1alpha1.Publish
-	code, err := jsonutil.Unmarshal(w, r, &data)
+	code, err := jsonutil.UnmarshalWithOptions(w, r, &data, s.unmarshalOptions(SchemaVersionV1Alpha1))
 	if err != nil {
 		if s.config.LogJSONParseErrors {
 			logger := logging.FromContext(ctx).Named("handleV1Apha1Request")
@@ -55,6 +55,15 @@ func (s *Server) handleV1Apha1Request(w http.ResponseWriter, r *http.Request) *r
 		}
 	}
 
+	publish, bridge := upconvertV1Alpha1(&data)
+
+	clientPlatform := platform(r.UserAgent())
+	return s.process(ctx, publish, clientPlatform, s.detachedHMAC(r), bridge)
+}
+
+// upconvertV1Alpha1 converts a v1alpha1 publish request to v1. The regions of
+// the request are returned in the version bridge.
+func upconvertV1Alpha1(data *v1alpha1.Publish) (*verifyapi.Publish, *versionBridge) {
 	// Upconvert the exposure key records.
 	v1keys := make([]verifyapi.ExposureKey, len(data.Keys))
 	for i, k := range data.Keys {
@@ -67,7 +76,7 @@ func (s *Server) handleV1Apha1Request(w http.ResponseWriter, r *http.Request) *r
 	}
 
 	// Upconvert v1alpha1 to verifyapi.
-	publish := verifyapi.Publish{
+	publish := &verifyapi.Publish{
 		Keys:                 v1keys,
 		HealthAuthorityID:    data.AppPackageName,
 		VerificationPayload:  data.VerificationPayload,
@@ -77,10 +86,7 @@ func (s *Server) handleV1Apha1Request(w http.ResponseWriter, r *http.Request) *r
 		RevisionToken:        data.RevisionToken,
 		Padding:              data.Padding,
 	}
-	bridge := newVersionBridge(data.Regions)
-
-	clientPlatform := platform(r.UserAgent())
-	return s.process(ctx, &publish, clientPlatform, bridge)
+	return publish, newVersionBridge(data.Regions)
 }
 
 func (s *Server) handlePublishV1Alpha1() http.Handler {
@@ -107,6 +113,7 @@ func (s *Server) handlePublishV1Alpha1() http.Handler {
 			Warnings:          response.pubResponse.Warnings,
 		}
 
+		response.setRetryAfter(w)
 		jsonutil.MarshalResponse(w, response.status, alpha1Response)
 	})
 }
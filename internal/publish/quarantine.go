@@ -0,0 +1,92 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/verification"
+	"github.com/google/exposure-notifications-server/pkg/logging"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+// quarantineReason returns why the quarantine policy flags the publish request,
+// or the empty string if its keys are saved as usual.
+func (s *Server) quarantineReason(ctx context.Context, appPackageName string, regions []string, claims *verification.VerifiedClaims, keys int, now time.Time) string {
+	if s.quarantinePolicy == nil || keys == 0 {
+		return ""
+	}
+
+	candidate := &model.PublishCandidate{
+		AppPackageName: appPackageName,
+		Regions:        regions,
+		Keys:           keys,
+	}
+	if claims != nil {
+		candidate.HealthAuthorityID = claims.HealthAuthorityID
+		if s.config.DailyKeyQuota > 0 {
+			count, err := s.database.DailyKeyCount(ctx, claims.HealthAuthorityID, now)
+			if err != nil {
+				logging.FromContext(ctx).Named("quarantineReason").
+					Errorw("failed to read daily key count", "error", err, "healthAuthorityID", claims.HealthAuthorityID)
+			} else {
+				candidate.DailyKeyCount = count
+				candidate.DailyKeyQuota = int64(s.config.DailyKeyQuota)
+			}
+		}
+	}
+	return s.quarantinePolicy.Quarantine(ctx, candidate)
+}
+
+// newQuarantine returns the quarantine that the exposures of the publish
+// request are held in for manual review instead of being saved. The exposures
+// are validated like any other publish, and the response is the same as if
+// they were saved, so clients can't tell that they are quarantined.
+// Quarantined keys count towards the daily key quota.
+func newQuarantine(appPackageName string, regions []string, claims *verification.VerifiedClaims, reason string, batchTime time.Time) *model.Quarantine {
+	q := &model.Quarantine{
+		AppPackageName: appPackageName,
+		Regions:        regions,
+		Reason:         reason,
+		CreatedAt:      batchTime.UTC(),
+	}
+	if claims != nil {
+		id := claims.HealthAuthorityID
+		q.HealthAuthorityID = &id
+	}
+	return q
+}
+
+// recordQuarantine logs and records the metric for a quarantined publish. No
+// quarantine is saved if none of the keys would have been saved.
+func (s *Server) recordQuarantine(ctx context.Context, q *model.Quarantine, claims *verification.VerifiedClaims) {
+	if q.ID == 0 {
+		return
+	}
+
+	logger := logging.FromContext(ctx).Named("quarantine")
+	logger.Warnw("publish quarantined for review", "quarantine_id", q.ID, "reason", q.Reason, "keys", q.NumKeys)
+	var tags []tag.Mutator
+	if claims != nil {
+		tags = []tag.Mutator{tag.Upsert(healthAuthorityIDTag, strconv.FormatInt(claims.HealthAuthorityID, 10))}
+	}
+	if err := stats.RecordWithTags(ctx, tags, mPublishQuarantined.M(1)); err != nil {
+		logger.Errorw("failed to record stats for quarantined publish", "error", err)
+	}
+}
@@ -0,0 +1,445 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/authorizedapp"
+	aadb "github.com/google/exposure-notifications-server/internal/authorizedapp/database"
+	aamodel "github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/exposure-notifications-server/internal/publish/database"
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	revisiondb "github.com/google/exposure-notifications-server/internal/revision/database"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	testutil "github.com/google/exposure-notifications-server/internal/utils"
+	vermodel "github.com/google/exposure-notifications-server/internal/verification/model"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
+	"github.com/google/exposure-notifications-server/pkg/keys"
+	"github.com/google/exposure-notifications-server/pkg/timeutils"
+	"github.com/google/exposure-notifications-server/pkg/util"
+	"github.com/sethvargo/go-envconfig"
+)
+
+func TestPublishQuarantine(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+
+	healthAuthority := &vermodel.HealthAuthority{
+		Issuer:   "gov.state.health",
+		Audience: "unit.test.server",
+		Name:     "State Dept of Health",
+	}
+	healthAuthorityKey := &vermodel.HealthAuthorityKey{
+		Version: "v1",
+		From:    time.Now().Add(-1 * time.Minute),
+	}
+	signingKey := testutil.GetSigningKey(t)
+	testutil.InitializeVerificationDB(ctx, t, testDB, healthAuthority, healthAuthorityKey, signingKey)
+
+	authorizedApp := aamodel.NewAuthorizedApp()
+	authorizedApp.AppPackageName = "gov.state.health"
+	authorizedApp.BypassRevisionToken = true
+	authorizedApp.AllowedRegions["US"] = struct{}{}
+	authorizedApp.AllowedHealthAuthorityIDs[healthAuthority.ID] = struct{}{}
+	if err := aadb.New(testDB).InsertAuthorizedApp(ctx, authorizedApp); err != nil {
+		t.Fatal(err)
+	}
+
+	kms := keys.TestKeyManager(t)
+	keyID := keys.TestEncryptionKey(t, kms)
+	revDB, err := revisiondb.New(testDB, &revisiondb.KMSConfig{WrapperKeyID: keyID, KeyManager: kms})
+	if err != nil {
+		t.Fatalf("unable to create revision DB handle: %v", err)
+	}
+	if _, err := revDB.CreateRevisionKey(ctx); err != nil {
+		t.Fatalf("unable to create revision key: %v", err)
+	}
+
+	config := Config{}
+	if err := envconfig.ProcessWith(ctx, &config, envconfig.OsLookuper()); err != nil {
+		t.Fatal(err)
+	}
+	config.AuthorizedApp.CacheDuration = time.Nanosecond
+	config.CreatedAtTruncateWindow = time.Second
+	config.MaxKeysOnPublish = 20
+	config.MaxSameStartIntervalKeys = 2
+	config.MaxIntervalAge = 14 * 24 * time.Hour
+	// The second publish of 2 keys comes within 90% of the quota.
+	config.DailyKeyQuota = 4
+	config.EnableQuarantine = true
+	config.QuarantineQuotaFraction = 0.9
+	config.RevisionToken.AAD = make([]byte, 16)
+	if _, err := rand.Read(config.RevisionToken.AAD); err != nil {
+		t.Fatalf("not enough entropy: %v", err)
+	}
+	config.RevisionToken.KeyID = keyID
+
+	aaProvider, err := authorizedapp.NewDatabaseProvider(ctx, testDB, config.AuthorizedAppConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := serverenv.New(ctx,
+		serverenv.WithDatabase(testDB),
+		serverenv.WithAuthorizedAppProvider(aaProvider),
+		serverenv.WithKeyManager(kms))
+
+	publishServer, err := NewServer(ctx, &config, env)
+	if err != nil {
+		t.Fatalf("unable to create publish handler: %v", err)
+	}
+
+	publishKeys := func(tb *testing.T) *verifyapi.PublishResponse {
+		tb.Helper()
+
+		publish := &verifyapi.Publish{
+			Keys:              util.GenerateExposureKeys(2, 0, false),
+			HealthAuthorityID: healthAuthority.Issuer,
+		}
+		utcDay := timeutils.UTCMidnight(time.Now())
+		verification, salt := testutil.IssueJWT(tb, &testutil.JWTConfig{
+			HealthAuthority:      healthAuthority,
+			HealthAuthorityKey:   healthAuthorityKey,
+			ExposureKeys:         publish.Keys,
+			Key:                  signingKey.Key,
+			SymptomOnsetInterval: uint32(utcDay.Unix()/600) - verifyapi.MaxIntervalCount,
+			ReportType:           verifyapi.ReportTypeConfirmed,
+		})
+		publish.VerificationPayload = verification
+		publish.HMACKey = salt
+
+		body, err := json.Marshal(publish)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		request, err := http.NewRequestWithContext(ctx, http.MethodPost, "", strings.NewReader(string(body)))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		request.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		publishServer.handlePublishV1().ServeHTTP(rr, request)
+		if rr.Code != http.StatusOK {
+			tb.Fatalf("expected publish to succeed, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var resp verifyapi.PublishResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			tb.Fatal(err)
+		}
+		return &resp
+	}
+
+	publishDB := database.New(testDB)
+	exportable := func(tb *testing.T) int {
+		tb.Helper()
+
+		var count int
+		if _, err := publishDB.IterateExposures(ctx, database.IterateExposuresCriteria{
+			IncludeRegions: []string{"US"},
+			UntilTimestamp: time.Now().Add(time.Hour),
+		}, func(*model.Exposure) error {
+			count++
+			return nil
+		}); err != nil {
+			tb.Fatal(err)
+		}
+		return count
+	}
+
+	// The first publish is saved as usual.
+	publishKeys(t)
+	if got, want := exportable(t), 2; got != want {
+		t.Fatalf("expected %d exportable keys, got %d", want, got)
+	}
+
+	// The second one is quarantined. The client can't tell.
+	resp := publishKeys(t)
+	if got, want := resp.InsertedExposures, 2; got != want {
+		t.Errorf("expected %d inserted exposures, got %d", want, got)
+	}
+	if resp.RevisionToken == "" {
+		t.Errorf("expected a revision token for the quarantined keys")
+	}
+	if got, want := exportable(t), 2; got != want {
+		t.Fatalf("expected quarantined keys to not be exportable, got %d exportable keys", got)
+	}
+
+	quarantines, err := publishDB.ListQuarantines(ctx, model.QuarantinePending, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(quarantines), 1; got != want {
+		t.Fatalf("expected %d quarantines, got %d", want, got)
+	}
+	q := quarantines[0]
+	if q.NumKeys != 2 || q.HealthAuthorityID == nil || *q.HealthAuthorityID != healthAuthority.ID {
+		t.Errorf("unexpected quarantine %#v", q)
+	}
+	if !strings.Contains(q.Reason, "daily quota") {
+		t.Errorf("expected quota reason, got %q", q.Reason)
+	}
+
+	// Approval moves the keys into the export pool.
+	now := time.Now().UTC()
+	approved, err := publishDB.ApproveQuarantine(ctx, q.ID, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := approved, int64(2); got != want {
+		t.Errorf("expected %d approved keys, got %d", want, got)
+	}
+	if got, want := exportable(t), 4; got != want {
+		t.Errorf("expected %d exportable keys after approval, got %d", want, got)
+	}
+
+	// A quarantine can only be reviewed once.
+	if err := publishDB.RejectQuarantine(ctx, q.ID, now); !errors.Is(err, database.ErrQuarantineReviewed) {
+		t.Errorf("expected %v, got %v", database.ErrQuarantineReviewed, err)
+	}
+	got, err := publishDB.GetQuarantine(ctx, q.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != model.QuarantineApproved || got.ReviewedAt == nil {
+		t.Errorf("expected quarantine to be approved, got %#v", got)
+	}
+}
+
+// testQuarantinePolicy quarantines every publish request while reason is set.
+type testQuarantinePolicy struct {
+	reason string
+}
+
+func (p *testQuarantinePolicy) Quarantine(context.Context, *model.PublishCandidate) string {
+	return p.reason
+}
+
+func TestPublishQuarantineRevisions(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+
+	healthAuthority := &vermodel.HealthAuthority{
+		Issuer:   "gov.state.health",
+		Audience: "unit.test.server",
+		Name:     "State Dept of Health",
+	}
+	healthAuthorityKey := &vermodel.HealthAuthorityKey{
+		Version: "v1",
+		From:    time.Now().Add(-1 * time.Minute),
+	}
+	signingKey := testutil.GetSigningKey(t)
+	testutil.InitializeVerificationDB(ctx, t, testDB, healthAuthority, healthAuthorityKey, signingKey)
+
+	authorizedApp := aamodel.NewAuthorizedApp()
+	authorizedApp.AppPackageName = "gov.state.health"
+	authorizedApp.AllowedRegions["US"] = struct{}{}
+	authorizedApp.AllowedHealthAuthorityIDs[healthAuthority.ID] = struct{}{}
+	if err := aadb.New(testDB).InsertAuthorizedApp(ctx, authorizedApp); err != nil {
+		t.Fatal(err)
+	}
+
+	kms := keys.TestKeyManager(t)
+	keyID := keys.TestEncryptionKey(t, kms)
+	revDB, err := revisiondb.New(testDB, &revisiondb.KMSConfig{WrapperKeyID: keyID, KeyManager: kms})
+	if err != nil {
+		t.Fatalf("unable to create revision DB handle: %v", err)
+	}
+	if _, err := revDB.CreateRevisionKey(ctx); err != nil {
+		t.Fatalf("unable to create revision key: %v", err)
+	}
+
+	config := Config{}
+	if err := envconfig.ProcessWith(ctx, &config, envconfig.OsLookuper()); err != nil {
+		t.Fatal(err)
+	}
+	config.AuthorizedApp.CacheDuration = time.Nanosecond
+	config.CreatedAtTruncateWindow = time.Second
+	config.MaxKeysOnPublish = 20
+	config.MaxSameStartIntervalKeys = 2
+	config.MaxIntervalAge = 14 * 24 * time.Hour
+	config.RevisionToken.AAD = make([]byte, 16)
+	if _, err := rand.Read(config.RevisionToken.AAD); err != nil {
+		t.Fatalf("not enough entropy: %v", err)
+	}
+	config.RevisionToken.KeyID = keyID
+
+	aaProvider, err := authorizedapp.NewDatabaseProvider(ctx, testDB, config.AuthorizedAppConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &testQuarantinePolicy{}
+	env := serverenv.New(ctx,
+		serverenv.WithDatabase(testDB),
+		serverenv.WithAuthorizedAppProvider(aaProvider),
+		serverenv.WithKeyManager(kms),
+		serverenv.WithQuarantinePolicy(policy))
+
+	publishServer, err := NewServer(ctx, &config, env)
+	if err != nil {
+		t.Fatalf("unable to create publish handler: %v", err)
+	}
+
+	exposureKeys := util.GenerateExposureKeys(2, 0, false)
+	publishKeys := func(tb *testing.T, reportType, revisionToken string) (int, *verifyapi.PublishResponse) {
+		tb.Helper()
+
+		publish := &verifyapi.Publish{
+			Keys:              exposureKeys,
+			HealthAuthorityID: healthAuthority.Issuer,
+			RevisionToken:     revisionToken,
+		}
+		utcDay := timeutils.UTCMidnight(time.Now())
+		verification, salt := testutil.IssueJWT(tb, &testutil.JWTConfig{
+			HealthAuthority:      healthAuthority,
+			HealthAuthorityKey:   healthAuthorityKey,
+			ExposureKeys:         publish.Keys,
+			Key:                  signingKey.Key,
+			SymptomOnsetInterval: uint32(utcDay.Unix()/600) - verifyapi.MaxIntervalCount,
+			ReportType:           reportType,
+		})
+		publish.VerificationPayload = verification
+		publish.HMACKey = salt
+
+		body, err := json.Marshal(publish)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		request, err := http.NewRequestWithContext(ctx, http.MethodPost, "", strings.NewReader(string(body)))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		request.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		publishServer.handlePublishV1().ServeHTTP(rr, request)
+		var resp verifyapi.PublishResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			tb.Fatal(err)
+		}
+		return rr.Code, &resp
+	}
+
+	publishDB := database.New(testDB)
+	b64keys := make([]string, 0, len(exposureKeys))
+	for _, k := range exposureKeys {
+		b64keys = append(b64keys, k.Key)
+	}
+	checkReportTypes := func(tb *testing.T, want string) {
+		tb.Helper()
+
+		exposures, err := publishDB.LookupExposures(ctx, b64keys)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		if got := len(exposures); got != len(b64keys) {
+			tb.Fatalf("expected %d exposures, got %d", len(b64keys), got)
+		}
+		for _, exp := range exposures {
+			got := exp.ReportType
+			if exp.RevisedReportType != nil {
+				got = *exp.RevisedReportType
+			}
+			if got != want {
+				tb.Errorf("expected report type %q, got %q", want, got)
+			}
+		}
+	}
+	pendingQuarantines := func(tb *testing.T) []*model.Quarantine {
+		tb.Helper()
+
+		quarantines, err := publishDB.ListQuarantines(ctx, model.QuarantinePending, 10)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return quarantines
+	}
+
+	// The keys are first saved as usual.
+	code, resp := publishKeys(t, verifyapi.ReportTypeClinical, "")
+	if code != http.StatusOK {
+		t.Fatalf("expected publish to succeed, got %d: %#v", code, resp)
+	}
+	revisionToken := resp.RevisionToken
+
+	// Revisions are validated before they are quarantined.
+	policy.reason = "test"
+	badToken := make([]byte, 64)
+	if _, err := rand.Read(badToken); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name  string
+		token string
+		code  string
+	}{
+		{
+			name: "missing_token",
+			code: verifyapi.ErrorMissingRevisionToken,
+		},
+		{
+			name:  "bad_token",
+			token: base64.StdEncoding.EncodeToString(badToken),
+			code:  verifyapi.ErrorInvalidRevisionToken,
+		},
+	}
+	for _, tc := range cases {
+		code, resp := publishKeys(t, verifyapi.ReportTypeConfirmed, tc.token)
+		if code != http.StatusBadRequest || resp.Code != tc.code {
+			t.Errorf("%s: expected %d %q, got %d: %#v", tc.name, http.StatusBadRequest, tc.code, code, resp)
+		}
+	}
+	if got := pendingQuarantines(t); len(got) != 0 {
+		t.Fatalf("expected rejected revisions to not be quarantined, got %d quarantines", len(got))
+	}
+
+	// A valid revision is quarantined, and the keys are revised on approval.
+	code, resp = publishKeys(t, verifyapi.ReportTypeConfirmed, revisionToken)
+	if code != http.StatusOK {
+		t.Fatalf("expected publish to succeed, got %d: %#v", code, resp)
+	}
+	quarantines := pendingQuarantines(t)
+	if got, want := len(quarantines), 1; got != want {
+		t.Fatalf("expected %d quarantines, got %d", want, got)
+	}
+	if got, want := quarantines[0].NumKeys, len(exposureKeys); got != want {
+		t.Errorf("expected %d quarantined keys, got %d", want, got)
+	}
+	checkReportTypes(t, verifyapi.ReportTypeClinical)
+
+	now := time.Now().UTC()
+	approved, err := publishDB.ApproveQuarantine(ctx, quarantines[0].ID, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := approved, int64(len(exposureKeys)); got != want {
+		t.Errorf("expected %d approved keys, got %d", want, got)
+	}
+	checkReportTypes(t, verifyapi.ReportTypeConfirmed)
+}
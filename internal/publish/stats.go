@@ -15,19 +15,30 @@
 package publish
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/google/exposure-notifications-server/internal/jsonutil"
+	"github.com/google/exposure-notifications-server/internal/publish/database"
 	"github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/verification"
 	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
 	"github.com/google/exposure-notifications-server/pkg/logging"
+	"go.opencensus.io/stats"
 	"go.opencensus.io/trace"
+	"golang.org/x/time/rate"
 )
 
+// maxStatsSubmitBodyBytes is the largest stats submission that is read. It
+// matches the limit of jsonutil.Unmarshal.
+const maxStatsSubmitBodyBytes = 64_000
+
 func (s *Server) handleStats() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx, span := trace.StartSpan(r.Context(), "(*publish.HandleStats)")
@@ -51,13 +62,30 @@ func (s *Server) handleStats() http.Handler {
 			return
 		}
 
-		response, status := s.handleMetricsRequest(ctx, r.Header.Get("Authorization"), &request)
+		response, status := s.handleMetricsRequest(ctx, s.statsAuthorization(ctx, r), &request)
 		s.addMetricsPadding(ctx, response)
 
 		jsonutil.MarshalResponse(w, status, response)
 	})
 }
 
+// statsAuthorization returns the Authorization header of the stats request.
+// If it has none and StatsTokenQueryParam is set, the token from the query
+// parameter is returned as a bearer token instead.
+func (s *Server) statsAuthorization(ctx context.Context, r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" || s.config.StatsTokenQueryParam == "" {
+		return header
+	}
+	token := r.URL.Query().Get(s.config.StatsTokenQueryParam)
+	if token == "" {
+		return ""
+	}
+
+	logging.FromContext(ctx).Warnw("reading stats token from query parameter", "param", s.config.StatsTokenQueryParam)
+	stats.Record(ctx, mStatsTokenFromQuery.M(1))
+	return "Bearer " + token
+}
+
 func (s *Server) addMetricsPadding(ctx context.Context, response *verifyapi.StatsResponse) {
 	logger := logging.FromContext(ctx).Named("addMetricsPadding")
 
@@ -82,13 +110,20 @@ func (s *Server) handleMetricsRequest(ctx context.Context, bearerToken string, r
 	bearerToken = bearerToken[7:]
 
 	// Validate JWT - if valid, the health authority ID (based on issuer) is returned.
-	healthAuthorityID, err := s.verifier.AuthenticateStatsToken(ctx, bearerToken)
+	details, err := s.verifier.AuthenticateStatsTokenDetailed(ctx, bearerToken)
 	if err != nil {
 		logger.Infow("stats authorization failure", "error", err)
 		response.ErrorMessage = err.Error()
 		response.ErrorCode = verifyapi.ErrorUnauthorized
 		return response, http.StatusUnauthorized
 	}
+	if err := details.RequireScope(s.config.StatsReadScope); err != nil {
+		logger.Infow("stats authorization failure", "error", err)
+		response.ErrorMessage = err.Error()
+		response.ErrorCode = verifyapi.ErrorInsufficientScope
+		return response, http.StatusForbidden
+	}
+	healthAuthorityID := details.HealthAuthorityID
 
 	// retrieve stats
 	stats, err := s.database.ReadStats(ctx, healthAuthorityID)
@@ -108,3 +143,286 @@ func (s *Server) handleMetricsRequest(ctx context.Context, bearerToken string, r
 	// return
 	return response, http.StatusOK
 }
+
+func (s *Server) handleStatsSubmit() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := trace.StartSpan(r.Context(), "(*publish.HandleStatsSubmit)")
+		defer span.End()
+
+		// Keep the body, the stats token may be bound to it.
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxStatsSubmitBodyBytes))
+		if err != nil {
+			response := &verifyapi.StatsSubmitResponse{
+				ErrorMessage: fmt.Sprintf("error reading request body: %v", err),
+				ErrorCode:    verifyapi.ErrorBadRequest,
+			}
+			s.addStatsSubmitPadding(ctx, response)
+			jsonutil.MarshalResponse(w, http.StatusRequestEntityTooLarge, response)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var request verifyapi.StatsSubmitRequest
+		code, err := jsonutil.Unmarshal(w, r, &request)
+		if err != nil {
+			message := fmt.Sprintf("error unmarshalling API call, code: %v: %v", code, err)
+			span.SetStatus(trace.Status{Code: trace.StatusCodeInternal, Message: message})
+			errorCode := verifyapi.ErrorBadRequest
+			if code == http.StatusInternalServerError {
+				errorCode = verifyapi.ErrorInternalError
+			}
+			response := &verifyapi.StatsSubmitResponse{
+				ErrorMessage: message,
+				ErrorCode:    errorCode,
+			}
+			s.addStatsSubmitPadding(ctx, response)
+			jsonutil.MarshalResponse(w, http.StatusBadRequest, response)
+			return
+		}
+
+		response, status := s.handleStatsSubmitRequest(ctx, s.statsAuthorization(ctx, r), body, &request)
+		s.addStatsSubmitPadding(ctx, response)
+
+		jsonutil.MarshalResponse(w, status, response)
+	})
+}
+
+func (s *Server) addStatsSubmitPadding(ctx context.Context, response *verifyapi.StatsSubmitResponse) {
+	logger := logging.FromContext(ctx).Named("addStatsSubmitPadding")
+
+	if padding, err := generatePadding(s.config.StatsResponsePaddingMinBytes, s.config.StatsResponsePaddingRange); err != nil {
+		logger.Errorw("failed to pad response", "error", err)
+	} else {
+		response.Padding = padding
+	}
+}
+
+func (s *Server) handleStatsSubmitRequest(ctx context.Context, bearerToken string, body []byte, request *verifyapi.StatsSubmitRequest) (*verifyapi.StatsSubmitResponse, int) {
+	logger := logging.FromContext(ctx).Named("handleStatsSubmitRequest")
+
+	response := &verifyapi.StatsSubmitResponse{}
+
+	if !strings.HasPrefix(bearerToken, "Bearer ") {
+		response.ErrorMessage = "Authorization header is not in `Bearer <token>` format"
+		response.ErrorCode = verifyapi.ErrorUnauthorized
+		return response, http.StatusUnauthorized
+	}
+	// Remove 'Bearer ' from the token.
+	bearerToken = bearerToken[7:]
+
+	// Validate JWT - if valid, the health authority ID (based on issuer) is returned.
+	details, err := s.verifier.AuthenticateStatsTokenBodyDetailed(ctx, bearerToken, body)
+	if err != nil {
+		logger.Infow("stats authorization failure", "error", err)
+		response.ErrorMessage = err.Error()
+		response.ErrorCode = verifyapi.ErrorUnauthorized
+		if errors.Is(err, verification.ErrBodyHashMismatch) {
+			response.ErrorCode = verifyapi.ErrorBodyHashMismatch
+		}
+		return response, http.StatusUnauthorized
+	}
+	if err := details.RequireScope(s.config.StatsSubmitScope); err != nil {
+		logger.Infow("stats authorization failure", "error", err)
+		response.ErrorMessage = err.Error()
+		response.ErrorCode = verifyapi.ErrorInsufficientScope
+		return response, http.StatusForbidden
+	}
+	healthAuthorityID := details.HealthAuthorityID
+
+	if !s.statsSubmitLimiter(healthAuthorityID, time.Now()).Allow() {
+		logger.Infow("stats submission rate limited", "healthAuthorityID", healthAuthorityID)
+		response.ErrorMessage = "too many stats submissions, try again later"
+		response.ErrorCode = verifyapi.ErrorTooManyRequests
+		return response, http.StatusTooManyRequests
+	}
+
+	stats, err := model.NewSubmittedStats(healthAuthorityID, request, time.Now())
+	if err != nil {
+		response.ErrorMessage = err.Error()
+		response.ErrorCode = verifyapi.ErrorBadRequest
+		return response, http.StatusBadRequest
+	}
+
+	if err := s.database.UpsertSubmittedStats(ctx, stats); err != nil {
+		logger.Errorw("error saving submitted stats", "error", err)
+		response.ErrorMessage = "error saving stats"
+		response.ErrorCode = verifyapi.ErrorInternalError
+		return response, http.StatusInternalServerError
+	}
+
+	return response, http.StatusOK
+}
+
+// statsSubmitLimiter is the rate limiter of a health authority for the stats
+// submission API, and when it was last used.
+type statsSubmitLimiter struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+// statsSubmitLimiter returns the rate limiter for the provided health
+// authority, creating it if necessary.
+func (s *Server) statsSubmitLimiter(healthAuthorityID int64, now time.Time) *rate.Limiter {
+	s.statsSubmitLimitersLock.Lock()
+	defer s.statsSubmitLimitersLock.Unlock()
+
+	every := time.Hour / time.Duration(s.config.StatsSubmitRateLimit)
+	s.sweepStatsSubmitLimiters(every*time.Duration(s.config.StatsSubmitBurst), now)
+
+	l, ok := s.statsSubmitLimiters[healthAuthorityID]
+	if !ok {
+		l = &statsSubmitLimiter{
+			limiter: rate.NewLimiter(rate.Every(every), s.config.StatsSubmitBurst),
+		}
+		s.statsSubmitLimiters[healthAuthorityID] = l
+	}
+	l.lastUsed = now
+	return l.limiter
+}
+
+// sweepStatsSubmitLimiters removes the rate limiters that weren't used for the
+// refill duration, at most once per refill duration. They allow the full burst
+// again, like a new rate limiter. Callers must hold the lock.
+func (s *Server) sweepStatsSubmitLimiters(refill time.Duration, now time.Time) {
+	if now.Sub(s.statsSubmitLimitersSweep) < refill {
+		return
+	}
+	for id, l := range s.statsSubmitLimiters {
+		if now.Sub(l.lastUsed) >= refill {
+			delete(s.statsSubmitLimiters, id)
+		}
+	}
+	s.statsSubmitLimitersSweep = now
+}
+
+// keyCountsPage is a cached page of the key counts API.
+type keyCountsPage struct {
+	counts        []*verifyapi.KeyCount
+	nextPageToken string
+}
+
+func (s *Server) handleKeyCounts() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := trace.StartSpan(r.Context(), "(*publish.HandleKeyCounts)")
+		defer span.End()
+
+		var request verifyapi.KeyCountsRequest
+		code, err := jsonutil.Unmarshal(w, r, &request)
+		if err != nil {
+			message := fmt.Sprintf("error unmarshalling API call, code: %v: %v", code, err)
+			span.SetStatus(trace.Status{Code: trace.StatusCodeInternal, Message: message})
+			errorCode := verifyapi.ErrorBadRequest
+			if code == http.StatusInternalServerError {
+				errorCode = verifyapi.ErrorInternalError
+			}
+			response := &verifyapi.KeyCountsResponse{
+				ErrorMessage: message,
+				ErrorCode:    errorCode,
+			}
+			s.addKeyCountsPadding(ctx, response)
+			jsonutil.MarshalResponse(w, http.StatusBadRequest, response)
+			return
+		}
+
+		response, status := s.handleKeyCountsRequest(ctx, s.statsAuthorization(ctx, r), &request)
+		s.addKeyCountsPadding(ctx, response)
+
+		jsonutil.MarshalResponse(w, status, response)
+	})
+}
+
+func (s *Server) addKeyCountsPadding(ctx context.Context, response *verifyapi.KeyCountsResponse) {
+	logger := logging.FromContext(ctx).Named("addKeyCountsPadding")
+
+	if padding, err := generatePadding(s.config.StatsResponsePaddingMinBytes, s.config.StatsResponsePaddingRange); err != nil {
+		logger.Errorw("failed to pad response", "error", err)
+	} else {
+		response.Padding = padding
+	}
+}
+
+func (s *Server) handleKeyCountsRequest(ctx context.Context, bearerToken string, request *verifyapi.KeyCountsRequest) (*verifyapi.KeyCountsResponse, int) {
+	logger := logging.FromContext(ctx).Named("handleKeyCountsRequest")
+
+	response := &verifyapi.KeyCountsResponse{}
+
+	if s.config.KeyCountsRequireAuth {
+		if !strings.HasPrefix(bearerToken, "Bearer ") {
+			response.ErrorMessage = "Authorization header is not in `Bearer <token>` format"
+			response.ErrorCode = verifyapi.ErrorUnauthorized
+			return response, http.StatusUnauthorized
+		}
+		// Remove 'Bearer ' from the token.
+		bearerToken = bearerToken[7:]
+
+		// Any health authority with access to the stats API may read the counts,
+		// they are not specific to the health authority. This includes issuers
+		// that aren't registered, if inline certificate chains are enabled.
+		authenticate := s.verifier.AuthenticateStatsTokenDetailed
+		if s.verifier.InlineX5CEnabled() && verification.IsInlineX5CToken(bearerToken) {
+			authenticate = s.verifier.AuthenticateInlineX5CStatsToken
+		}
+		details, err := authenticate(ctx, bearerToken)
+		if err != nil {
+			logger.Infow("key counts authorization failure", "error", err)
+			response.ErrorMessage = err.Error()
+			response.ErrorCode = verifyapi.ErrorUnauthorized
+			return response, http.StatusUnauthorized
+		}
+		if err := details.RequireScope(s.config.StatsReadScope); err != nil {
+			logger.Infow("key counts authorization failure", "error", err)
+			response.ErrorMessage = err.Error()
+			response.ErrorCode = verifyapi.ErrorInsufficientScope
+			return response, http.StatusForbidden
+		}
+	}
+
+	window, err := model.NewKeyCountsWindow(request, time.Now(), s.config.KeyCountsMaxDays)
+	if err != nil {
+		response.ErrorMessage = err.Error()
+		response.ErrorCode = verifyapi.ErrorBadRequest
+		return response, http.StatusBadRequest
+	}
+
+	cacheKey := fmt.Sprintf("%d:%d:%s", window.Start.Unix(), window.End.Unix(), request.PageToken)
+	cached, err := s.keyCountsCache.WriteThruLookup(cacheKey, func() (interface{}, error) {
+		counts, next, err := s.database.ReadKeyCounts(ctx, &database.ReadKeyCountsCriteria{
+			Window: window,
+			Cursor: request.PageToken,
+			Limit:  s.config.KeyCountsPageSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		page := &keyCountsPage{
+			counts:        make([]*verifyapi.KeyCount, 0, len(counts)),
+			nextPageToken: next,
+		}
+		for _, c := range counts {
+			page.counts = append(page.counts, &verifyapi.KeyCount{
+				Day:    c.Day.Format("2006-01-02"),
+				Region: c.Region,
+				Count:  c.Count,
+			})
+		}
+		return page, nil
+	})
+	if err != nil {
+		if errors.Is(err, database.ErrInvalidKeyCountsCursor) {
+			response.ErrorMessage = "invalid page token"
+			response.ErrorCode = verifyapi.ErrorBadRequest
+			return response, http.StatusBadRequest
+		}
+
+		logger.Errorw("error reading key counts", "error", err)
+		response.ErrorMessage = "error reading key counts"
+		response.ErrorCode = verifyapi.ErrorInternalError
+		return response, http.StatusInternalServerError
+	}
+
+	page := cached.(*keyCountsPage)
+	response.Counts = page.counts
+	response.NextPageToken = page.nextPageToken
+	return response, http.StatusOK
+}
@@ -0,0 +1,309 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/authorizedapp"
+	aadb "github.com/google/exposure-notifications-server/internal/authorizedapp/database"
+	aamodel "github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/exposure-notifications-server/internal/publish/database"
+	"github.com/google/exposure-notifications-server/internal/publish/model"
+	revisiondb "github.com/google/exposure-notifications-server/internal/revision/database"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/exposure-notifications-server/internal/statssink"
+	testutil "github.com/google/exposure-notifications-server/internal/utils"
+	vermodel "github.com/google/exposure-notifications-server/internal/verification/model"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
+	"github.com/google/exposure-notifications-server/pkg/keys"
+	"github.com/google/exposure-notifications-server/pkg/timeutils"
+	"github.com/google/exposure-notifications-server/pkg/util"
+	"github.com/sethvargo/go-envconfig"
+)
+
+// failingStatsSink is a stats sink that always fails.
+type failingStatsSink struct{}
+
+func (f *failingStatsSink) RecordPublish(context.Context, int64, *model.PublishInfo) error {
+	return fmt.Errorf("publish sink failure")
+}
+
+func (f *failingStatsSink) RecordExport(context.Context, *statssink.ExportRecord) error {
+	return fmt.Errorf("export sink failure")
+}
+
+func (f *failingStatsSink) RecordVerification(context.Context, *statssink.VerificationRecord) error {
+	return fmt.Errorf("verification sink failure")
+}
+
+func TestPublishStatsSink(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		sink statssink.StatsSink
+	}{
+		{
+			name: "memory",
+			sink: statssink.NewMemory(),
+		},
+		{
+			name: "failing",
+			sink: &failingStatsSink{},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := project.TestContext(t)
+			testDB, _ := testDatabaseInstance.NewDatabase(t)
+
+			healthAuthority := &vermodel.HealthAuthority{
+				Issuer:   "gov.state.health",
+				Audience: "unit.test.server",
+				Name:     "State Dept of Health",
+			}
+			healthAuthorityKey := &vermodel.HealthAuthorityKey{
+				Version: "v1",
+				From:    time.Now().Add(-1 * time.Minute),
+			}
+			signingKey := testutil.GetSigningKey(t)
+			testutil.InitializeVerificationDB(ctx, t, testDB, healthAuthority, healthAuthorityKey, signingKey)
+
+			authorizedApp := aamodel.NewAuthorizedApp()
+			authorizedApp.AppPackageName = "gov.state.health"
+			authorizedApp.BypassRevisionToken = true
+			authorizedApp.AllowedRegions["US"] = struct{}{}
+			authorizedApp.AllowedHealthAuthorityIDs[healthAuthority.ID] = struct{}{}
+			if err := aadb.New(testDB).InsertAuthorizedApp(ctx, authorizedApp); err != nil {
+				t.Fatal(err)
+			}
+
+			kms := keys.TestKeyManager(t)
+			keyID := keys.TestEncryptionKey(t, kms)
+			revDB, err := revisiondb.New(testDB, &revisiondb.KMSConfig{WrapperKeyID: keyID, KeyManager: kms})
+			if err != nil {
+				t.Fatalf("unable to create revision DB handle: %v", err)
+			}
+			if _, err := revDB.CreateRevisionKey(ctx); err != nil {
+				t.Fatalf("unable to create revision key: %v", err)
+			}
+
+			config := Config{}
+			if err := envconfig.ProcessWith(ctx, &config, envconfig.OsLookuper()); err != nil {
+				t.Fatal(err)
+			}
+			config.AuthorizedApp.CacheDuration = time.Nanosecond
+			config.CreatedAtTruncateWindow = time.Second
+			config.MaxKeysOnPublish = 20
+			config.MaxSameStartIntervalKeys = 2
+			config.MaxIntervalAge = 14 * 24 * time.Hour
+			config.RevisionToken.AAD = make([]byte, 16)
+			if _, err := rand.Read(config.RevisionToken.AAD); err != nil {
+				t.Fatalf("not enough entropy: %v", err)
+			}
+			config.RevisionToken.KeyID = keyID
+
+			aaProvider, err := authorizedapp.NewDatabaseProvider(ctx, testDB, config.AuthorizedAppConfig())
+			if err != nil {
+				t.Fatal(err)
+			}
+			env := serverenv.New(ctx,
+				serverenv.WithDatabase(testDB),
+				serverenv.WithAuthorizedAppProvider(aaProvider),
+				serverenv.WithKeyManager(kms),
+				serverenv.WithStatsSink(tc.sink))
+
+			publishServer, err := NewServer(ctx, &config, env)
+			if err != nil {
+				t.Fatalf("unable to create publish handler: %v", err)
+			}
+
+			publish := &verifyapi.Publish{
+				Keys:              util.GenerateExposureKeys(2, 0, false),
+				HealthAuthorityID: healthAuthority.Issuer,
+			}
+			utcDay := timeutils.UTCMidnight(time.Now())
+			verification, salt := testutil.IssueJWT(t, &testutil.JWTConfig{
+				HealthAuthority:      healthAuthority,
+				HealthAuthorityKey:   healthAuthorityKey,
+				ExposureKeys:         publish.Keys,
+				Key:                  signingKey.Key,
+				SymptomOnsetInterval: uint32(utcDay.Unix()/600) - verifyapi.MaxIntervalCount,
+				ReportType:           verifyapi.ReportTypeConfirmed,
+			})
+			publish.VerificationPayload = verification
+			publish.HMACKey = salt
+
+			body, err := json.Marshal(publish)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request, err := http.NewRequestWithContext(ctx, http.MethodPost, "", strings.NewReader(string(body)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			request.Header.Set("Content-Type", "application/json")
+
+			rr := httptest.NewRecorder()
+			publishServer.handlePublishV1().ServeHTTP(rr, request)
+
+			// Failures in the stats sink never fail the publish request.
+			if got, want := rr.Code, http.StatusOK; got != want {
+				t.Fatalf("expected status %d to be %d: %s", got, want, rr.Body.String())
+			}
+
+			// Stats are recorded in the background.
+			publishServer.statsRecords.Wait()
+
+			memory, ok := tc.sink.(*statssink.Memory)
+			if !ok {
+				return
+			}
+
+			verifications := memory.Verifications()
+			if got, want := len(verifications), 1; got != want {
+				t.Fatalf("expected %d verification records, got %d", want, got)
+			}
+			if v := verifications[0]; !v.Success || v.HealthAuthorityID != healthAuthority.Issuer || v.AppPackageName != authorizedApp.AppPackageName {
+				t.Errorf("unexpected verification record: %#v", v)
+			}
+
+			publishes := memory.Publishes()
+			if got, want := len(publishes), 1; got != want {
+				t.Fatalf("expected %d publish records, got %d", want, got)
+			}
+			if got, want := publishes[0].HealthAuthorityID, healthAuthority.ID; got != want {
+				t.Errorf("expected health authority %d to be %d", got, want)
+			}
+			if got, want := publishes[0].Info.NumTEKs, int32(2); got != want {
+				t.Errorf("expected %d TEKs to be %d", got, want)
+			}
+			if publishes[0].Info.Revision {
+				t.Errorf("expected publish to not be a revision")
+			}
+		})
+	}
+}
+
+// blockingStatsSink is a stats sink that blocks until the context is done.
+type blockingStatsSink struct{}
+
+func (b *blockingStatsSink) RecordPublish(ctx context.Context, _ int64, _ *model.PublishInfo) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (b *blockingStatsSink) RecordExport(ctx context.Context, _ *statssink.ExportRecord) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (b *blockingStatsSink) RecordVerification(ctx context.Context, _ *statssink.VerificationRecord) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestStatsSinkTimeout(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	s := &Server{
+		config: &Config{
+			StatsSink: statssink.Config{Timeout: 10 * time.Millisecond},
+		},
+		statsSink: &blockingStatsSink{},
+	}
+
+	healthAuthorityID := int64(4)
+	resp := &database.InsertAndReviseExposuresResponse{
+		Inserted:  1,
+		Exposures: []*model.Exposure{{HealthAuthorityID: &healthAuthorityID}},
+	}
+
+	// The request context is canceled as soon as the request is done, which
+	// must not cut the stats records short.
+	reqCtx, cancel := context.WithCancel(ctx)
+	s.recordVerification(reqCtx, aamodel.NewAuthorizedApp(), &verifyapi.Publish{}, nil)
+	s.recordPublish(reqCtx, &model.PublishInfo{}, resp)
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.statsRecords.Wait()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the stats sink calls to time out")
+	}
+}
+
+// requestScopedStatsSink is a stats sink that fails if the context it is
+// given is already canceled.
+type requestScopedStatsSink struct {
+	*statssink.Memory
+}
+
+func (r *requestScopedStatsSink) RecordPublish(ctx context.Context, haID int64, info *model.PublishInfo) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.Memory.RecordPublish(ctx, haID, info)
+}
+
+func TestStatsSinkOutlivesRequest(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	sink := &requestScopedStatsSink{Memory: statssink.NewMemory()}
+	s := &Server{
+		config: &Config{
+			StatsSink: statssink.Config{Timeout: 5 * time.Second},
+		},
+		statsSink: sink,
+	}
+
+	healthAuthorityID := int64(4)
+	resp := &database.InsertAndReviseExposuresResponse{
+		Inserted:  1,
+		Exposures: []*model.Exposure{{HealthAuthorityID: &healthAuthorityID}},
+	}
+
+	reqCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	s.recordPublish(reqCtx, &model.PublishInfo{}, resp)
+	s.statsRecords.Wait()
+
+	if got, want := len(sink.Publishes()), 1; got != want {
+		t.Fatalf("expected %d publish records, got %d", want, got)
+	}
+}
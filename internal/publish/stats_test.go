@@ -21,17 +21,21 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt"
 	"github.com/google/exposure-notifications-server/internal/authorizedapp"
 	"github.com/google/exposure-notifications-server/internal/project"
 	pubdb "github.com/google/exposure-notifications-server/internal/publish/database"
 	"github.com/google/exposure-notifications-server/internal/publish/model"
 	"github.com/google/exposure-notifications-server/internal/serverenv"
 	testutil "github.com/google/exposure-notifications-server/internal/utils"
+	"github.com/google/exposure-notifications-server/internal/verification"
 	vermodel "github.com/google/exposure-notifications-server/internal/verification/model"
+	"github.com/google/exposure-notifications-server/internal/verification/verifytest"
 	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
 	"github.com/google/exposure-notifications-server/pkg/keys"
 	"github.com/google/exposure-notifications-server/pkg/timeutils"
@@ -221,6 +225,7 @@ func TestRetrieveMetrics_AuthErrors(t *testing.T) {
 	}
 	config.RevisionToken.AAD = tokenAAD
 	config.RevisionToken.KeyID = keyID
+	config.StatsTokenQueryParam = "token"
 	env := serverenv.New(ctx,
 		serverenv.WithDatabase(testDB),
 		serverenv.WithAuthorizedAppProvider(aaProvider),
@@ -257,6 +262,7 @@ func TestRetrieveMetrics_AuthErrors(t *testing.T) {
 	cases := []struct {
 		Name          string
 		Authorization string
+		QueryToken    string
 		ErrorMessage  string
 		ErrorCode     string
 	}{
@@ -277,6 +283,19 @@ func TestRetrieveMetrics_AuthErrors(t *testing.T) {
 			ErrorMessage: "Authorization header is not in `Bearer <token>` format",
 			ErrorCode:    "unauthorized",
 		},
+		{
+			Name:         "bad_query_token",
+			QueryToken:   token,
+			ErrorMessage: "unauthorized, audience mismatch",
+			ErrorCode:    "unauthorized",
+		},
+		{
+			Name:          "header_over_query_token",
+			Authorization: "LET ME IN",
+			QueryToken:    token,
+			ErrorMessage:  "Authorization header is not in `Bearer <token>` format",
+			ErrorCode:     "unauthorized",
+		},
 	}
 
 	for _, tc := range cases {
@@ -291,7 +310,11 @@ func TestRetrieveMetrics_AuthErrors(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
-			httpRequest, err := http.NewRequestWithContext(ctx, "POST", "", strings.NewReader(string(jsonString)))
+			target := ""
+			if tc.QueryToken != "" {
+				target = "/?" + url.Values{"token": {tc.QueryToken}}.Encode()
+			}
+			httpRequest, err := http.NewRequestWithContext(ctx, "POST", target, strings.NewReader(string(jsonString)))
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -329,3 +352,583 @@ func TestRetrieveMetrics_AuthErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestStatsAuthorization(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		param  string
+		header string
+		query  string
+		want   string
+	}{
+		{
+			name:   "header",
+			param:  "token",
+			header: "Bearer header-token",
+			want:   "Bearer header-token",
+		},
+		{
+			name:  "query_without_header",
+			param: "token",
+			query: "token=query-token",
+			want:  "Bearer query-token",
+		},
+		{
+			name:   "header_wins",
+			param:  "token",
+			header: "Bearer header-token",
+			query:  "token=query-token",
+			want:   "Bearer header-token",
+		},
+		{
+			name:  "other_param",
+			param: "token",
+			query: "jwt=query-token",
+			want:  "",
+		},
+		{
+			name:  "disabled",
+			query: "token=query-token",
+			want:  "",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := &Server{config: &Config{StatsTokenQueryParam: tc.param}}
+			r := httptest.NewRequest(http.MethodPost, "/v1/stats?"+tc.query, nil)
+			if tc.header != "" {
+				r.Header.Set("Authorization", tc.header)
+			}
+			if got := s.statsAuthorization(project.TestContext(t), r); got != tc.want {
+				t.Errorf("expected %q to be %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSubmitStats(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	authKey := testutil.GetSigningKey(t)
+	kms := keys.TestKeyManager(t)
+	keyID := keys.TestEncryptionKey(t, kms)
+
+	tokenAAD := make([]byte, 16)
+	if _, err := rand.Read(tokenAAD); err != nil {
+		t.Fatalf("not enough entropy: %v", err)
+	}
+
+	// load default config to ensure that what we need is there.
+	var config Config
+	if err := envconfig.Process(ctx, &config); err != nil {
+		t.Fatal(err)
+	}
+	aaProvider, err := authorizedapp.NewDatabaseProvider(ctx, testDB, config.AuthorizedAppConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.RevisionToken.AAD = tokenAAD
+	config.RevisionToken.KeyID = keyID
+	config.StatsSubmitBurst = 2
+	env := serverenv.New(ctx,
+		serverenv.WithDatabase(testDB),
+		serverenv.WithAuthorizedAppProvider(aaProvider),
+		serverenv.WithKeyManager(kms))
+
+	// Create a health authority with a public key.
+	healthAuthority := &vermodel.HealthAuthority{
+		Issuer:         "health-authority",
+		Audience:       "n/a",
+		Name:           "health-authority",
+		EnableStatsAPI: true,
+	}
+	healthAuthorityKey := &vermodel.HealthAuthorityKey{
+		Version: "v1",
+		From:    time.Now().Add(-1 * time.Minute),
+	}
+	healthAuthorityID := testutil.InitializeVerificationDB(ctx, t, testDB, healthAuthority, healthAuthorityKey, authKey)
+
+	publishServer, err := NewServer(ctx, &config, env)
+	if err != nil {
+		t.Fatalf("unable to create publish handler: %v", err)
+	}
+	submitHandler := publishServer.handleStatsSubmit()
+
+	jwtConfig := &testutil.StatsJWTConfig{
+		HealthAuthority:    healthAuthority,
+		HealthAuthorityKey: healthAuthorityKey,
+		Key:                authKey.Key,
+		Audience:           config.Verification.StatsAudience,
+	}
+	token := jwtConfig.IssueStatsJWT(t)
+
+	badJWTConfig := *jwtConfig
+	badJWTConfig.Audience = config.Verification.StatsAudience + "WRONG"
+	badToken := badJWTConfig.IssueStatsJWT(t)
+
+	submit := func(t *testing.T, token string, request *verifyapi.StatsSubmitRequest) (int, *verifyapi.StatsSubmitResponse) {
+		t.Helper()
+
+		jsonString, err := json.Marshal(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpRequest, err := http.NewRequestWithContext(ctx, "POST", "", strings.NewReader(string(jsonString)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpRequest.Header.Set("Content-Type", "application/json")
+		httpRequest.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		rr := httptest.NewRecorder()
+		submitHandler.ServeHTTP(rr, httpRequest)
+		resp := rr.Result()
+
+		defer resp.Body.Close()
+		respBytes, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got verifyapi.StatsSubmitResponse
+		if err := json.Unmarshal(respBytes, &got); err != nil {
+			t.Fatalf("unable to unmarshal response body: %v; data: %v", err, string(respBytes))
+		}
+		if got.Padding == "" {
+			t.Errorf("response is missing padding")
+		}
+		return resp.StatusCode, &got
+	}
+
+	yesterday := timeutils.UTCMidnight(time.Now().UTC()).Add(-24 * time.Hour)
+	day := yesterday.Format("2006-01-02")
+
+	// Unauthorized requests are rejected and nothing is stored.
+	status, resp := submit(t, badToken, &verifyapi.StatsSubmitRequest{
+		Day:     day,
+		Metrics: map[string]int64{"codes_issued": 1},
+	})
+	if status != http.StatusUnauthorized || resp.ErrorCode != verifyapi.ErrorUnauthorized {
+		t.Errorf("expected unauthorized, got %d: %#v", status, resp)
+	}
+
+	// Initial submission.
+	status, resp = submit(t, token, &verifyapi.StatsSubmitRequest{
+		Day:     day,
+		Metrics: map[string]int64{"codes_issued": 10, "codes_claimed": 5},
+	})
+	if status != http.StatusOK || resp.ErrorCode != "" {
+		t.Fatalf("expected success, got %d: %#v", status, resp)
+	}
+
+	// Same day submission replaces the previous values. The token is bound to
+	// the request body.
+	replace := &verifyapi.StatsSubmitRequest{
+		Day:     day,
+		Metrics: map[string]int64{"codes_issued": 12},
+	}
+	replaceBody, err := json.Marshal(replace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	boundJWTConfig := *jwtConfig
+	boundJWTConfig.BodyHash = verification.StatsBodyHash(replaceBody)
+	boundToken := boundJWTConfig.IssueStatsJWT(t)
+
+	// A bound token can't be used for a different body.
+	status, resp = submit(t, boundToken, &verifyapi.StatsSubmitRequest{
+		Day:     day,
+		Metrics: map[string]int64{"codes_issued": 1000},
+	})
+	if status != http.StatusUnauthorized || resp.ErrorCode != verifyapi.ErrorBodyHashMismatch {
+		t.Errorf("expected body hash mismatch, got %d: %#v", status, resp)
+	}
+
+	status, resp = submit(t, boundToken, replace)
+	if status != http.StatusOK || resp.ErrorCode != "" {
+		t.Fatalf("expected success, got %d: %#v", status, resp)
+	}
+
+	got, err := pubdb.New(testDB).ReadSubmittedStats(ctx, healthAuthorityID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*model.SubmittedStats{
+		{
+			HealthAuthorityID: healthAuthorityID,
+			Day:               yesterday,
+			Metrics:           map[string]int64{"codes_issued": 12},
+		},
+	}
+	ignoreUpdatedAt := cmpopts.IgnoreFields(model.SubmittedStats{}, "UpdatedAt")
+	if diff := cmp.Diff(want, got, ignoreUpdatedAt, cmpopts.EquateApproxTime(time.Second)); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Burst is exhausted, additional requests are rate limited.
+	status, resp = submit(t, token, &verifyapi.StatsSubmitRequest{
+		Day:     day,
+		Metrics: map[string]int64{"codes_issued": 13},
+	})
+	if status != http.StatusTooManyRequests || resp.ErrorCode != verifyapi.ErrorTooManyRequests {
+		t.Errorf("expected too many requests, got %d: %#v", status, resp)
+	}
+}
+
+func TestKeyCounts(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	authKey := testutil.GetSigningKey(t)
+	kms := keys.TestKeyManager(t)
+	keyID := keys.TestEncryptionKey(t, kms)
+
+	tokenAAD := make([]byte, 16)
+	if _, err := rand.Read(tokenAAD); err != nil {
+		t.Fatalf("not enough entropy: %v", err)
+	}
+
+	var config Config
+	if err := envconfig.Process(ctx, &config); err != nil {
+		t.Fatal(err)
+	}
+	aaProvider, err := authorizedapp.NewDatabaseProvider(ctx, testDB, config.AuthorizedAppConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.RevisionToken.AAD = tokenAAD
+	config.RevisionToken.KeyID = keyID
+	config.KeyCountsPageSize = 2
+	config.StatsTokenQueryParam = "token"
+	env := serverenv.New(ctx,
+		serverenv.WithDatabase(testDB),
+		serverenv.WithAuthorizedAppProvider(aaProvider),
+		serverenv.WithKeyManager(kms))
+
+	healthAuthority := &vermodel.HealthAuthority{
+		Issuer:         "health-authority",
+		Audience:       "n/a",
+		Name:           "health-authority",
+		EnableStatsAPI: true,
+	}
+	healthAuthorityKey := &vermodel.HealthAuthorityKey{
+		Version: "v1",
+		From:    time.Now().Add(-1 * time.Minute),
+	}
+	healthAuthorityID := testutil.InitializeVerificationDB(ctx, t, testDB, healthAuthority, healthAuthorityKey, authKey)
+
+	// Seed keys across days and regions.
+	today := timeutils.UTCMidnight(time.Now())
+	yesterday := today.AddDate(0, 0, -1)
+	twoDaysAgo := today.AddDate(0, 0, -2)
+	seed := []struct {
+		createdAt time.Time
+		regions   []string
+	}{
+		{twoDaysAgo.Add(time.Hour), []string{"US"}},
+		{twoDaysAgo.Add(2 * time.Hour), []string{"US", "CA"}},
+		{yesterday.Add(time.Hour), []string{"CA"}},
+		{yesterday.Add(2 * time.Hour), []string{"CA"}},
+		{yesterday.Add(3 * time.Hour), []string{"MX"}},
+	}
+	pubDB := pubdb.New(testDB)
+	exposures := make([]*model.Exposure, 0, len(seed))
+	for _, s := range seed {
+		key := make([]byte, verifyapi.KeyLength)
+		if _, err := rand.Read(key); err != nil {
+			t.Fatal(err)
+		}
+		exposures = append(exposures, &model.Exposure{
+			ExposureKey:       key,
+			Regions:           s.regions,
+			IntervalNumber:    100,
+			IntervalCount:     144,
+			CreatedAt:         s.createdAt,
+			HealthAuthorityID: &healthAuthorityID,
+			ReportType:        verifyapi.ReportTypeConfirmed,
+		})
+	}
+	if _, err := pubDB.InsertAndReviseExposures(ctx, &pubdb.InsertAndReviseExposuresRequest{
+		Incoming:     exposures,
+		RequireToken: false,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	publishServer, err := NewServer(ctx, &config, env)
+	if err != nil {
+		t.Fatalf("unable to create publish handler: %v", err)
+	}
+	handler := publishServer.handleKeyCounts()
+
+	jwtConfig := &testutil.StatsJWTConfig{
+		HealthAuthority:    healthAuthority,
+		HealthAuthorityKey: healthAuthorityKey,
+		Key:                authKey.Key,
+		Audience:           config.Verification.StatsAudience,
+	}
+	token := jwtConfig.IssueStatsJWT(t)
+
+	doRequestURL := func(t *testing.T, target, auth string, request *verifyapi.KeyCountsRequest) (int, *verifyapi.KeyCountsResponse) {
+		t.Helper()
+
+		b, err := json.Marshal(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpRequest, err := http.NewRequestWithContext(ctx, "POST", target, strings.NewReader(string(b)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpRequest.Header.Set("Content-Type", "application/json")
+		if auth != "" {
+			httpRequest.Header.Set("Authorization", auth)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httpRequest)
+
+		var response verifyapi.KeyCountsResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		return rr.Code, &response
+	}
+	doRequest := func(t *testing.T, auth string, request *verifyapi.KeyCountsRequest) (int, *verifyapi.KeyCountsResponse) {
+		t.Helper()
+		return doRequestURL(t, "", auth, request)
+	}
+
+	window := &verifyapi.KeyCountsRequest{
+		StartDay: twoDaysAgo.Format("2006-01-02"),
+		EndDay:   yesterday.Format("2006-01-02"),
+	}
+
+	t.Run("unauthorized", func(t *testing.T) {
+		t.Parallel()
+
+		code, response := doRequest(t, "", window)
+		if got, want := code, http.StatusUnauthorized; got != want {
+			t.Errorf("expected code %d to be %d", got, want)
+		}
+		if got, want := response.ErrorCode, verifyapi.ErrorUnauthorized; got != want {
+			t.Errorf("expected error code %q to be %q", got, want)
+		}
+	})
+
+	t.Run("query_param_token", func(t *testing.T) {
+		t.Parallel()
+
+		code, response := doRequestURL(t, "/v1/stats/keycounts?token="+token, "", window)
+		if code != http.StatusOK {
+			t.Errorf("expected code %d to be %d: %s", code, http.StatusOK, response.ErrorMessage)
+		}
+	})
+
+	t.Run("bad_window", func(t *testing.T) {
+		t.Parallel()
+
+		code, response := doRequest(t, "Bearer "+token, &verifyapi.KeyCountsRequest{
+			StartDay: yesterday.Format("2006-01-02"),
+			EndDay:   twoDaysAgo.Format("2006-01-02"),
+		})
+		if got, want := code, http.StatusBadRequest; got != want {
+			t.Errorf("expected code %d to be %d", got, want)
+		}
+		if got, want := response.ErrorCode, verifyapi.ErrorBadRequest; got != want {
+			t.Errorf("expected error code %q to be %q", got, want)
+		}
+	})
+
+	t.Run("bad_page_token", func(t *testing.T) {
+		t.Parallel()
+
+		code, _ := doRequest(t, "Bearer "+token, &verifyapi.KeyCountsRequest{
+			StartDay:  window.StartDay,
+			EndDay:    window.EndDay,
+			PageToken: "nope",
+		})
+		if got, want := code, http.StatusBadRequest; got != want {
+			t.Errorf("expected code %d to be %d", got, want)
+		}
+	})
+
+	t.Run("paginated", func(t *testing.T) {
+		t.Parallel()
+
+		var got []*verifyapi.KeyCount
+		request := *window
+		for pages := 0; ; pages++ {
+			if pages > 5 {
+				t.Fatalf("too many pages")
+			}
+
+			code, response := doRequest(t, "Bearer "+token, &request)
+			if code != http.StatusOK {
+				t.Fatalf("expected code %d to be %d: %s", code, http.StatusOK, response.ErrorMessage)
+			}
+			if l := len(response.Counts); l > config.KeyCountsPageSize {
+				t.Errorf("page has %d counts, max of %d expected", l, config.KeyCountsPageSize)
+			}
+			got = append(got, response.Counts...)
+
+			if response.NextPageToken == "" {
+				break
+			}
+			request.PageToken = response.NextPageToken
+		}
+
+		want := []*verifyapi.KeyCount{
+			{Day: twoDaysAgo.Format("2006-01-02"), Region: "CA", Count: 1},
+			{Day: twoDaysAgo.Format("2006-01-02"), Region: "US", Count: 2},
+			{Day: yesterday.Format("2006-01-02"), Region: "CA", Count: 2},
+			{Day: yesterday.Format("2006-01-02"), Region: "MX", Count: 1},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("mismatch (-want, +got):\n%s", diff)
+		}
+	})
+}
+
+func TestStatsScopes(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	statsAudience := "test-stats-aud"
+
+	ha := verifytest.NewHealthAuthority(t, "scopes.test.health")
+	ha.HealthAuthority.ID = 3
+	resolver := make(verifytest.Resolver)
+	ha.Cache(t, resolver)
+
+	verifier, err := verification.NewWithResolver(resolver, &verification.Config{
+		CacheDuration: time.Hour,
+		StatsAudience: statsAudience,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signToken := func(t *testing.T, scope string) string {
+		t.Helper()
+
+		now := time.Now().UTC()
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, &verification.StatsClaims{
+			StandardClaims: jwt.StandardClaims{
+				Audience:  statsAudience,
+				ExpiresAt: now.Add(time.Minute).Unix(),
+				IssuedAt:  now.Unix(),
+				Issuer:    ha.HealthAuthority.Issuer,
+				NotBefore: now.Unix(),
+			},
+			Scope: scope,
+		})
+		token.Header["kid"] = ha.Key.Version
+		signed, err := token.SignedString(ha.PrivateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "Bearer " + signed
+	}
+
+	s := &Server{
+		config: &Config{
+			StatsReadScope:       "stats:read",
+			StatsSubmitScope:     "stats:submit",
+			KeyCountsRequireAuth: true,
+			StatsSubmitRateLimit: 60,
+			StatsSubmitBurst:     10,
+		},
+		verifier:            verifier,
+		statsSubmitLimiters: make(map[int64]*statsSubmitLimiter),
+	}
+
+	t.Run("read", func(t *testing.T) {
+		t.Parallel()
+
+		resp, status := s.handleMetricsRequest(ctx, signToken(t, "stats:submit"), &verifyapi.StatsRequest{})
+		if got, want := status, http.StatusForbidden; got != want {
+			t.Errorf("expected status %d to be %d", got, want)
+		}
+		if got, want := resp.ErrorCode, verifyapi.ErrorInsufficientScope; got != want {
+			t.Errorf("expected error code %q to be %q", got, want)
+		}
+	})
+
+	t.Run("key_counts", func(t *testing.T) {
+		t.Parallel()
+
+		resp, status := s.handleKeyCountsRequest(ctx, signToken(t, ""), &verifyapi.KeyCountsRequest{})
+		if got, want := status, http.StatusForbidden; got != want {
+			t.Errorf("expected status %d to be %d", got, want)
+		}
+		if got, want := resp.ErrorCode, verifyapi.ErrorInsufficientScope; got != want {
+			t.Errorf("expected error code %q to be %q", got, want)
+		}
+	})
+
+	t.Run("submit", func(t *testing.T) {
+		t.Parallel()
+
+		resp, status := s.handleStatsSubmitRequest(ctx, signToken(t, "stats:read"), nil, &verifyapi.StatsSubmitRequest{})
+		if got, want := status, http.StatusForbidden; got != want {
+			t.Errorf("expected status %d to be %d", got, want)
+		}
+		if got, want := resp.ErrorCode, verifyapi.ErrorInsufficientScope; got != want {
+			t.Errorf("expected error code %q to be %q", got, want)
+		}
+
+		// With the scope, the request gets past authorization and is rejected
+		// as invalid.
+		resp, status = s.handleStatsSubmitRequest(ctx, signToken(t, "stats:read stats:submit"), nil, &verifyapi.StatsSubmitRequest{})
+		if got, want := status, http.StatusBadRequest; got != want {
+			t.Errorf("expected status %d to be %d: %v", got, want, resp.ErrorMessage)
+		}
+	})
+}
+
+func TestStatsSubmitLimiterSweep(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{
+		config: &Config{
+			StatsSubmitRateLimit: 60,
+			StatsSubmitBurst:     10,
+		},
+		statsSubmitLimiters: make(map[int64]*statsSubmitLimiter),
+	}
+	// A rate limiter refills in 10 minutes.
+	refill := 10 * time.Minute
+
+	now := time.Now()
+	first := s.statsSubmitLimiter(1, now)
+	s.statsSubmitLimiter(2, now)
+	if got, want := len(s.statsSubmitLimiters), 2; got != want {
+		t.Fatalf("expected %d rate limiters, got %d", want, got)
+	}
+
+	// Health authority 1 keeps submitting, 2 is idle.
+	now = now.Add(refill / 2)
+	if got := s.statsSubmitLimiter(1, now); got != first {
+		t.Errorf("expected the rate limiter to be reused")
+	}
+
+	now = now.Add(refill / 2)
+	if got := s.statsSubmitLimiter(1, now); got != first {
+		t.Errorf("expected the rate limiter in use to be kept")
+	}
+	if _, ok := s.statsSubmitLimiters[2]; ok {
+		t.Errorf("expected the idle rate limiter to be removed")
+	}
+	if got, want := len(s.statsSubmitLimiters), 1; got != want {
+		t.Errorf("expected %d rate limiters, got %d", want, got)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"sync"
+	"time"
+)
+
+// writeBreaker stops publish requests from writing to the database after
+// sustained write failures, e.g. when the disk is full or during a failover.
+// Once threshold writes failed within the window, the breaker opens and
+// requests are rejected for the cooldown. After the cooldown a single request
+// is let through to probe the database. If its write succeeds the breaker
+// closes, otherwise it opens for another cooldown.
+//
+// Like the certificate throttle, the state is held in memory, so each publish
+// server instance trips separately.
+type writeBreaker struct {
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  []time.Time
+	openUntil time.Time
+	probing   bool
+}
+
+// newWriteBreaker returns a breaker, or nil if the threshold is 0 and writes
+// are never paused.
+func newWriteBreaker(threshold uint, window, cooldown time.Duration) *writeBreaker {
+	if threshold == 0 {
+		return nil
+	}
+	return &writeBreaker{
+		threshold: int(threshold),
+		window:    window,
+		cooldown:  cooldown,
+	}
+}
+
+// allow returns 0 if a write may be attempted at now. The caller must report
+// the outcome of the write with done. Otherwise the breaker is open, and the
+// time until writes are probed again is returned.
+func (b *writeBreaker) allow(now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openUntil.IsZero() {
+		return 0
+	}
+	if wait := b.openUntil.Sub(now); wait > 0 {
+		return wait
+	}
+	if b.probing {
+		// Another request is probing the database, wait for its outcome.
+		return b.cooldown
+	}
+	b.probing = true
+	return 0
+}
+
+// done records the outcome of a write that was allowed at now. It returns true
+// if the failure tripped the breaker, and it opened.
+func (b *writeBreaker) done(now time.Time, failed bool) (tripped bool) {
+	if b == nil {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.openUntil.IsZero() {
+		// Writes that started before the breaker opened are ignored until a
+		// probe is let through.
+		if !b.probing {
+			return false
+		}
+		b.probing = false
+		if failed {
+			b.openUntil = now.Add(b.cooldown)
+			return false
+		}
+		b.openUntil = time.Time{}
+		b.failures = b.failures[:0]
+		return false
+	}
+
+	if !failed {
+		return false
+	}
+
+	// Forget the failures that are outside of the window.
+	start := now.Add(-b.window)
+	i := 0
+	for i < len(b.failures) && !b.failures[i].After(start) {
+		i++
+	}
+	b.failures = append(b.failures[i:], now)
+
+	if len(b.failures) >= b.threshold {
+		b.openUntil = now.Add(b.cooldown)
+		b.failures = b.failures[:0]
+		return true
+	}
+	return false
+}
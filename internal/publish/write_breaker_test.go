@@ -0,0 +1,130 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"testing"
+	"time"
+)
+
+// failWrites records n failed writes, a second apart from now, and returns
+// whether the last one tripped the breaker.
+func failWrites(t *testing.T, b *writeBreaker, now time.Time, n int) bool {
+	t.Helper()
+
+	tripped := false
+	for i := 0; i < n; i++ {
+		at := now.Add(time.Duration(i) * time.Second)
+		if wait := b.allow(at); wait != 0 {
+			t.Fatalf("expected write %d to be allowed, got wait %v", i, wait)
+		}
+		tripped = b.done(at, true)
+	}
+	return tripped
+}
+
+func TestWriteBreaker(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	breaker := newWriteBreaker(3, time.Minute, 30*time.Second)
+
+	// Failures below the threshold, and successes, don't trip it.
+	if failWrites(t, breaker, now, 2) {
+		t.Fatalf("expected breaker to not trip below the threshold")
+	}
+	if breaker.done(now.Add(2*time.Second), false) {
+		t.Fatalf("expected success to not trip the breaker")
+	}
+
+	// Sustained failures trip it.
+	if !failWrites(t, breaker, now.Add(3*time.Second), 1) {
+		t.Fatalf("expected breaker to trip at the threshold")
+	}
+	trippedAt := now.Add(3 * time.Second)
+
+	// Writes are rejected for the cooldown.
+	if got, want := breaker.allow(trippedAt.Add(10*time.Second)), 20*time.Second; got != want {
+		t.Errorf("expected wait %v to be %v", got, want)
+	}
+
+	// After the cooldown, a single write probes the database.
+	probeAt := trippedAt.Add(30 * time.Second)
+	if wait := breaker.allow(probeAt); wait != 0 {
+		t.Fatalf("expected probe to be allowed, got wait %v", wait)
+	}
+	if wait := breaker.allow(probeAt); wait == 0 {
+		t.Errorf("expected concurrent write to be rejected while probing")
+	}
+
+	// A failed probe opens it again.
+	breaker.done(probeAt, true)
+	if got, want := breaker.allow(probeAt.Add(time.Second)), 29*time.Second; got != want {
+		t.Errorf("expected wait %v to be %v", got, want)
+	}
+
+	// A successful probe closes it.
+	probeAt = probeAt.Add(30 * time.Second)
+	if wait := breaker.allow(probeAt); wait != 0 {
+		t.Fatalf("expected probe to be allowed, got wait %v", wait)
+	}
+	breaker.done(probeAt, false)
+	for i := 0; i < 3; i++ {
+		if wait := breaker.allow(probeAt.Add(time.Second)); wait != 0 {
+			t.Fatalf("expected writes to be allowed after recovery, got wait %v", wait)
+		}
+	}
+
+	// The failures before the breaker tripped are forgotten.
+	if failWrites(t, breaker, probeAt.Add(time.Second), 2) {
+		t.Errorf("expected breaker to not trip below the threshold after recovery")
+	}
+}
+
+func TestWriteBreaker_Window(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	breaker := newWriteBreaker(3, time.Minute, 30*time.Second)
+
+	// Failures spread out over more than the window don't trip it.
+	for i := 0; i < 10; i++ {
+		at := now.Add(time.Duration(i) * 31 * time.Second)
+		if breaker.done(at, true) {
+			t.Fatalf("expected failure %d to not trip the breaker", i)
+		}
+	}
+	if wait := breaker.allow(now.Add(10 * 31 * time.Second)); wait != 0 {
+		t.Errorf("expected writes to be allowed, got wait %v", wait)
+	}
+}
+
+func TestWriteBreaker_Disabled(t *testing.T) {
+	t.Parallel()
+
+	breaker := newWriteBreaker(0, time.Minute, 30*time.Second)
+	if breaker != nil {
+		t.Fatalf("expected no breaker for a threshold of 0")
+	}
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		if breaker.done(now, true) {
+			t.Fatalf("expected disabled breaker to never trip")
+		}
+	}
+	if wait := breaker.allow(now); wait != 0 {
+		t.Errorf("expected disabled breaker to allow writes, got wait %v", wait)
+	}
+}
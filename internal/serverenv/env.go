@@ -22,6 +22,8 @@ import (
 
 	"github.com/google/exposure-notifications-server/internal/authorizedapp"
 	"github.com/google/exposure-notifications-server/internal/metrics"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/internal/statssink"
 	"github.com/google/exposure-notifications-server/internal/storage"
 	"github.com/google/exposure-notifications-server/pkg/database"
 	"github.com/google/exposure-notifications-server/pkg/keys"
@@ -41,6 +43,9 @@ type ServerEnv struct {
 	keyManager            keys.KeyManager
 	secretManager         secrets.SecretManager
 	observabilityExporter observability.Exporter
+	statsSink             statssink.StatsSink
+	publishHook           publishmodel.PublishHook
+	quarantinePolicy      publishmodel.QuarantinePolicy
 }
 
 // Option defines function types to modify the ServerEnv on creation.
@@ -118,6 +123,32 @@ func WithObservabilityExporter(oe observability.Exporter) Option {
 	}
 }
 
+// WithStatsSink creates an Option to install a specific stats sink.
+func WithStatsSink(sink statssink.StatsSink) Option {
+	return func(s *ServerEnv) *ServerEnv {
+		s.statsSink = sink
+		return s
+	}
+}
+
+// WithPublishHook creates an Option to install a hook that is notified of
+// accepted publish requests.
+func WithPublishHook(hook publishmodel.PublishHook) Option {
+	return func(s *ServerEnv) *ServerEnv {
+		s.publishHook = hook
+		return s
+	}
+}
+
+// WithQuarantinePolicy creates an Option to install a policy that decides
+// which publish requests are quarantined for manual review.
+func WithQuarantinePolicy(policy publishmodel.QuarantinePolicy) Option {
+	return func(s *ServerEnv) *ServerEnv {
+		s.quarantinePolicy = policy
+		return s
+	}
+}
+
 func (s *ServerEnv) SecretManager() secrets.SecretManager {
 	return s.secretManager
 }
@@ -142,6 +173,18 @@ func (s *ServerEnv) ObservabilityExporter() observability.Exporter {
 	return s.observabilityExporter
 }
 
+func (s *ServerEnv) StatsSink() statssink.StatsSink {
+	return s.statsSink
+}
+
+func (s *ServerEnv) PublishHook() publishmodel.PublishHook {
+	return s.publishHook
+}
+
+func (s *ServerEnv) QuarantinePolicy() publishmodel.QuarantinePolicy {
+	return s.quarantinePolicy
+}
+
 func (s *ServerEnv) GetKeyManager() keys.KeyManager {
 	return s.keyManager
 }
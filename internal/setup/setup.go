@@ -22,6 +22,7 @@ import (
 	"github.com/google/exposure-notifications-server/internal/authorizedapp"
 	"github.com/google/exposure-notifications-server/internal/metrics"
 	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/exposure-notifications-server/internal/statssink"
 	"github.com/google/exposure-notifications-server/internal/storage"
 	"github.com/google/exposure-notifications-server/pkg/database"
 	"github.com/google/exposure-notifications-server/pkg/keys"
@@ -67,6 +68,12 @@ type SecretManagerConfigProvider interface {
 	SecretManagerConfig() *secrets.Config
 }
 
+// StatsSinkConfigProvider signals that the config knows how to configure a
+// stats sink.
+type StatsSinkConfigProvider interface {
+	StatsSinkConfig() *statssink.Config
+}
+
 // Setup runs common initialization code for all servers. See SetupWith.
 func Setup(ctx context.Context, config interface{}) (*serverenv.ServerEnv, error) {
 	return SetupWith(ctx, config, envconfig.OsLookuper())
@@ -205,11 +212,13 @@ func SetupWith(ctx context.Context, config interface{}, l envconfig.Lookuper) (*
 	}
 
 	// Setup the database connection.
+	var db *database.DB
 	if provider, ok := config.(DatabaseConfigProvider); ok {
 		logger.Info("configuring database")
 
 		dbConfig := provider.DatabaseConfig()
-		db, err := database.NewFromEnv(ctx, dbConfig)
+		var err error
+		db, err = database.NewFromEnv(ctx, dbConfig)
 		if err != nil {
 			return nil, fmt.Errorf("unable to connect to database: %w", err)
 		}
@@ -238,5 +247,26 @@ func SetupWith(ctx context.Context, config interface{}, l envconfig.Lookuper) (*
 		}
 	}
 
+	// Configure the stats sink. This must come after database setup since the
+	// default sink is backed by the database.
+	if provider, ok := config.(StatsSinkConfigProvider); ok {
+		logger.Info("configuring stats sink")
+
+		ssConfig := provider.StatsSinkConfig()
+		sink, err := statssink.StatsSinkFor(ctx, ssConfig, db)
+		if err != nil {
+			if db != nil {
+				// Ensure the database is closed on an error.
+				defer db.Close(ctx)
+			}
+			return nil, fmt.Errorf("unable to create stats sink: %w", err)
+		}
+
+		// Update serverEnv setup.
+		serverEnvOpts = append(serverEnvOpts, serverenv.WithStatsSink(sink))
+
+		logger.Infow("stats sink", "config", ssConfig)
+	}
+
 	return serverenv.New(ctx, serverEnvOpts...), nil
 }
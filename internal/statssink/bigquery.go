@@ -0,0 +1,161 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build google all
+
+package statssink
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/pkg/database"
+)
+
+func init() {
+	RegisterStatsSink("BIGQUERY", NewBigQuery)
+}
+
+// Compile-time check to verify implements interface.
+var _ StatsSink = (*BigQuery)(nil)
+
+// inserter is the subset of *bigquery.Inserter that is used, so it can be
+// replaced in tests.
+type inserter interface {
+	Put(ctx context.Context, src interface{}) error
+}
+
+// BigQuery implements StatsSink by streaming each record as a row into a
+// BigQuery table. Aggregation is left to the queries run against the tables.
+type BigQuery struct {
+	publish      inserter
+	export       inserter
+	verification inserter
+}
+
+// NewBigQuery creates a BigQuery client, suitable for use with
+// serverenv.ServerEnv.
+func NewBigQuery(ctx context.Context, cfg *Config, _ *database.DB) (StatsSink, error) {
+	if cfg.BigQueryProjectID == "" {
+		return nil, fmt.Errorf("STATS_SINK_BIGQUERY_PROJECT_ID is required")
+	}
+	if cfg.BigQueryDataset == "" {
+		return nil, fmt.Errorf("STATS_SINK_BIGQUERY_DATASET is required")
+	}
+
+	client, err := bigquery.NewClient(ctx, cfg.BigQueryProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("bigquery.NewClient: %w", err)
+	}
+
+	dataset := client.Dataset(cfg.BigQueryDataset)
+	return &BigQuery{
+		publish:      dataset.Table(cfg.BigQueryPublishTable).Inserter(),
+		export:       dataset.Table(cfg.BigQueryExportTable).Inserter(),
+		verification: dataset.Table(cfg.BigQueryVerificationTable).Inserter(),
+	}, nil
+}
+
+// bigQueryPublishRow is a row in the publish table.
+type bigQueryPublishRow struct {
+	HealthAuthorityID int64     `bigquery:"health_authority_id"`
+	CreatedAt         time.Time `bigquery:"created_at"`
+	Platform          string    `bigquery:"platform"`
+	NumTEKs           int64     `bigquery:"num_teks"`
+	Revision          bool      `bigquery:"revision"`
+	OldestDays        int64     `bigquery:"oldest_days"`
+	OnsetDaysAgo      int64     `bigquery:"onset_days_ago"`
+	MissingOnset      bool      `bigquery:"missing_onset"`
+}
+
+// bigQueryExportRow is a row in the export table.
+type bigQueryExportRow struct {
+	BatchID        int64     `bigquery:"batch_id"`
+	ConfigID       int64     `bigquery:"config_id"`
+	FilenameRoot   string    `bigquery:"filename_root"`
+	OutputRegion   string    `bigquery:"output_region"`
+	StartTimestamp time.Time `bigquery:"start_timestamp"`
+	EndTimestamp   time.Time `bigquery:"end_timestamp"`
+	NumFiles       int64     `bigquery:"num_files"`
+	NumKeys        int64     `bigquery:"num_keys"`
+	NumRevisedKeys int64     `bigquery:"num_revised_keys"`
+	CreatedAt      time.Time `bigquery:"created_at"`
+}
+
+// bigQueryVerificationRow is a row in the verification table.
+type bigQueryVerificationRow struct {
+	HealthAuthorityID string    `bigquery:"health_authority_id"`
+	AppPackageName    string    `bigquery:"app_package_name"`
+	Success           bool      `bigquery:"success"`
+	Bypassed          bool      `bigquery:"bypassed"`
+	Error             string    `bigquery:"error"`
+	CreatedAt         time.Time `bigquery:"created_at"`
+}
+
+// RecordPublish inserts a row into the publish table.
+func (b *BigQuery) RecordPublish(ctx context.Context, healthAuthorityID int64, info *publishmodel.PublishInfo) error {
+	row := &bigQueryPublishRow{
+		HealthAuthorityID: healthAuthorityID,
+		CreatedAt:         info.CreatedAt,
+		Platform:          info.Platform,
+		NumTEKs:           int64(info.NumTEKs),
+		Revision:          info.Revision,
+		OldestDays:        int64(info.OldestDays),
+		OnsetDaysAgo:      int64(info.OnsetDaysAgo),
+		MissingOnset:      info.MissingOnset,
+	}
+	if err := b.publish.Put(ctx, row); err != nil {
+		return fmt.Errorf("failed to insert publish row: %w", err)
+	}
+	return nil
+}
+
+// RecordExport inserts a row into the export table.
+func (b *BigQuery) RecordExport(ctx context.Context, record *ExportRecord) error {
+	row := &bigQueryExportRow{
+		BatchID:        record.BatchID,
+		ConfigID:       record.ConfigID,
+		FilenameRoot:   record.FilenameRoot,
+		OutputRegion:   record.OutputRegion,
+		StartTimestamp: record.StartTimestamp,
+		EndTimestamp:   record.EndTimestamp,
+		NumFiles:       int64(record.NumFiles),
+		NumKeys:        int64(record.NumKeys),
+		NumRevisedKeys: int64(record.NumRevisedKeys),
+		CreatedAt:      record.CreatedAt,
+	}
+	if err := b.export.Put(ctx, row); err != nil {
+		return fmt.Errorf("failed to insert export row: %w", err)
+	}
+	return nil
+}
+
+// RecordVerification inserts a row into the verification table.
+func (b *BigQuery) RecordVerification(ctx context.Context, record *VerificationRecord) error {
+	row := &bigQueryVerificationRow{
+		HealthAuthorityID: record.HealthAuthorityID,
+		AppPackageName:    record.AppPackageName,
+		Success:           record.Success,
+		Bypassed:          record.Bypassed,
+		Error:             record.Error,
+		CreatedAt:         record.CreatedAt,
+	}
+	if err := b.verification.Put(ctx, row); err != nil {
+		return fmt.Errorf("failed to insert verification row: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build google all
+
+package statssink
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/project"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/go-cmp/cmp"
+)
+
+type fakeInserter struct {
+	rows []interface{}
+	err  error
+}
+
+func (f *fakeInserter) Put(_ context.Context, src interface{}) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.rows = append(f.rows, src)
+	return nil
+}
+
+func TestBigQuery_NewBigQuery(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	if _, err := NewBigQuery(ctx, &Config{BigQueryDataset: "stats"}, nil); err == nil {
+		t.Errorf("expected error for missing project ID")
+	}
+	if _, err := NewBigQuery(ctx, &Config{BigQueryProjectID: "project"}, nil); err == nil {
+		t.Errorf("expected error for missing dataset")
+	}
+}
+
+func TestBigQuery_Record(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	now := time.Now().UTC().Truncate(time.Hour)
+
+	publish, export, verification := &fakeInserter{}, &fakeInserter{}, &fakeInserter{}
+	sink := &BigQuery{
+		publish:      publish,
+		export:       export,
+		verification: verification,
+	}
+
+	if err := sink.RecordPublish(ctx, 7, &publishmodel.PublishInfo{
+		CreatedAt:    now,
+		Platform:     "android",
+		NumTEKs:      14,
+		OldestDays:   13,
+		OnsetDaysAgo: 3,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.RecordExport(ctx, &ExportRecord{
+		BatchID:        1,
+		ConfigID:       2,
+		FilenameRoot:   "root",
+		OutputRegion:   "US",
+		StartTimestamp: now.Add(-time.Hour),
+		EndTimestamp:   now,
+		NumFiles:       3,
+		NumKeys:        40,
+		NumRevisedKeys: 4,
+		CreatedAt:      now,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.RecordVerification(ctx, &VerificationRecord{
+		HealthAuthorityID: "gov.state.health",
+		AppPackageName:    "app",
+		Error:             "expired",
+		CreatedAt:         now,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	wantPublish := []interface{}{&bigQueryPublishRow{
+		HealthAuthorityID: 7,
+		CreatedAt:         now,
+		Platform:          "android",
+		NumTEKs:           14,
+		OldestDays:        13,
+		OnsetDaysAgo:      3,
+	}}
+	if diff := cmp.Diff(wantPublish, publish.rows); diff != "" {
+		t.Errorf("publish rows mismatch (-want, +got):\n%s", diff)
+	}
+
+	wantExport := []interface{}{&bigQueryExportRow{
+		BatchID:        1,
+		ConfigID:       2,
+		FilenameRoot:   "root",
+		OutputRegion:   "US",
+		StartTimestamp: now.Add(-time.Hour),
+		EndTimestamp:   now,
+		NumFiles:       3,
+		NumKeys:        40,
+		NumRevisedKeys: 4,
+		CreatedAt:      now,
+	}}
+	if diff := cmp.Diff(wantExport, export.rows); diff != "" {
+		t.Errorf("export rows mismatch (-want, +got):\n%s", diff)
+	}
+
+	wantVerification := []interface{}{&bigQueryVerificationRow{
+		HealthAuthorityID: "gov.state.health",
+		AppPackageName:    "app",
+		Error:             "expired",
+		CreatedAt:         now,
+	}}
+	if diff := cmp.Diff(wantVerification, verification.rows); diff != "" {
+		t.Errorf("verification rows mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestBigQuery_RecordError(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	putErr := errors.New("quota exceeded")
+	failing := &fakeInserter{err: putErr}
+	sink := &BigQuery{
+		publish:      failing,
+		export:       failing,
+		verification: failing,
+	}
+
+	if err := sink.RecordPublish(ctx, 1, &publishmodel.PublishInfo{}); !errors.Is(err, putErr) {
+		t.Errorf("expected %v to wrap %v", err, putErr)
+	}
+	if err := sink.RecordExport(ctx, &ExportRecord{}); !errors.Is(err, putErr) {
+		t.Errorf("expected %v to wrap %v", err, putErr)
+	}
+	if err := sink.RecordVerification(ctx, &VerificationRecord{}); !errors.Is(err, putErr) {
+		t.Errorf("expected %v to wrap %v", err, putErr)
+	}
+}
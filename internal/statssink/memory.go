@@ -0,0 +1,103 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statssink
+
+import (
+	"context"
+	"sync"
+
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/pkg/database"
+)
+
+func init() {
+	RegisterStatsSink("MEMORY", func(_ context.Context, _ *Config, _ *database.DB) (StatsSink, error) {
+		return NewMemory(), nil
+	})
+}
+
+// Compile-time check to verify implements interface.
+var _ StatsSink = (*Memory)(nil)
+
+// Memory implements StatsSink by keeping all records in memory. It is intended
+// for local development and testing.
+type Memory struct {
+	lock          sync.Mutex
+	publishes     []*PublishRecord
+	exports       []*ExportRecord
+	verifications []*VerificationRecord
+}
+
+// PublishRecord is a publish recorded by the Memory stats sink.
+type PublishRecord struct {
+	HealthAuthorityID int64
+	Info              *publishmodel.PublishInfo
+}
+
+// NewMemory creates a new in-memory stats sink.
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// RecordPublish stores a copy of the publish info.
+func (m *Memory) RecordPublish(_ context.Context, healthAuthorityID int64, info *publishmodel.PublishInfo) error {
+	cp := *info
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.publishes = append(m.publishes, &PublishRecord{HealthAuthorityID: healthAuthorityID, Info: &cp})
+	return nil
+}
+
+// RecordExport stores a copy of the export record.
+func (m *Memory) RecordExport(_ context.Context, record *ExportRecord) error {
+	cp := *record
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.exports = append(m.exports, &cp)
+	return nil
+}
+
+// RecordVerification stores a copy of the verification record.
+func (m *Memory) RecordVerification(_ context.Context, record *VerificationRecord) error {
+	cp := *record
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.verifications = append(m.verifications, &cp)
+	return nil
+}
+
+// Publishes returns the recorded publishes.
+func (m *Memory) Publishes() []*PublishRecord {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]*PublishRecord(nil), m.publishes...)
+}
+
+// Exports returns the recorded exports.
+func (m *Memory) Exports() []*ExportRecord {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]*ExportRecord(nil), m.exports...)
+}
+
+// Verifications returns the recorded verifications.
+func (m *Memory) Verifications() []*VerificationRecord {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]*VerificationRecord(nil), m.verifications...)
+}
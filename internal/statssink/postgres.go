@@ -0,0 +1,69 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statssink
+
+import (
+	"context"
+	"fmt"
+
+	publishdb "github.com/google/exposure-notifications-server/internal/publish/database"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/pkg/database"
+)
+
+func init() {
+	RegisterStatsSink("POSTGRES", func(_ context.Context, _ *Config, db *database.DB) (StatsSink, error) {
+		if db == nil {
+			return nil, fmt.Errorf("POSTGRES stats sink requires a database")
+		}
+		return NewPostgres(db), nil
+	})
+}
+
+// Compile-time check to verify implements interface.
+var _ StatsSink = (*Postgres)(nil)
+
+// Postgres implements StatsSink by aggregating publish stats in the
+// HealthAuthorityStats table, which is what backs the stats API. Export and
+// verification outcomes are not stored in the database.
+type Postgres struct {
+	db *publishdb.PublishDB
+}
+
+// NewPostgres creates a stats sink backed by the given database.
+func NewPostgres(db *database.DB) *Postgres {
+	return &Postgres{
+		db: publishdb.New(db),
+	}
+}
+
+// RecordPublish updates the hourly stats for the health authority.
+func (p *Postgres) RecordPublish(ctx context.Context, healthAuthorityID int64, info *publishmodel.PublishInfo) error {
+	if err := p.db.UpdateStats(ctx, info.CreatedAt, healthAuthorityID, info); err != nil {
+		return fmt.Errorf("failed to update stats: %w", err)
+	}
+	return nil
+}
+
+// RecordExport is a no-op, export stats are not stored in the database.
+func (p *Postgres) RecordExport(_ context.Context, _ *ExportRecord) error {
+	return nil
+}
+
+// RecordVerification is a no-op, verification outcomes are not stored in the
+// database.
+func (p *Postgres) RecordVerification(_ context.Context, _ *VerificationRecord) error {
+	return nil
+}
@@ -0,0 +1,141 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package statssink is an interface over the backends that publish, export,
+// and verification stats are aggregated in.
+package statssink
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+	"github.com/google/exposure-notifications-server/pkg/database"
+)
+
+// Config defines the configuration for a stats sink.
+type Config struct {
+	// Type is the type of stats sink.
+	Type string `env:"STATS_SINK, default=POSTGRES"`
+
+	// Timeout is how long the stats of a publish request may take to be
+	// recorded. Stats are recorded in the background, so this never delays the
+	// request. Records that take longer are dropped and counted in the
+	// stats_sink_dropped metric. Zero waits indefinitely.
+	Timeout time.Duration `env:"STATS_SINK_TIMEOUT, default=500ms"`
+
+	// BigQuery configuration, only used if the type is BIGQUERY.
+	BigQueryProjectID         string `env:"STATS_SINK_BIGQUERY_PROJECT_ID"`
+	BigQueryDataset           string `env:"STATS_SINK_BIGQUERY_DATASET"`
+	BigQueryPublishTable      string `env:"STATS_SINK_BIGQUERY_PUBLISH_TABLE, default=publish"`
+	BigQueryExportTable       string `env:"STATS_SINK_BIGQUERY_EXPORT_TABLE, default=export"`
+	BigQueryVerificationTable string `env:"STATS_SINK_BIGQUERY_VERIFICATION_TABLE, default=verification"`
+}
+
+// StatsSink defines the interface for recording stats. Callers must treat
+// errors as non-fatal; a failure to record stats must never fail the request
+// that generated them.
+type StatsSink interface {
+	// RecordPublish records a successful publish request for a health authority.
+	RecordPublish(ctx context.Context, healthAuthorityID int64, info *publishmodel.PublishInfo) error
+
+	// RecordExport records a completed export batch.
+	RecordExport(ctx context.Context, record *ExportRecord) error
+
+	// RecordVerification records the outcome of verifying a diagnosis
+	// certificate on publish.
+	RecordVerification(ctx context.Context, record *VerificationRecord) error
+}
+
+// ExportRecord is the summary of a completed export batch.
+type ExportRecord struct {
+	BatchID        int64
+	ConfigID       int64
+	FilenameRoot   string
+	OutputRegion   string
+	StartTimestamp time.Time
+	EndTimestamp   time.Time
+	NumFiles       int
+	NumKeys        int
+	NumRevisedKeys int
+	CreatedAt      time.Time
+}
+
+// VerificationRecord is the outcome of a single diagnosis certificate
+// verification.
+type VerificationRecord struct {
+	// HealthAuthorityID is the issuer the client claimed, it may not exist.
+	HealthAuthorityID string
+	AppPackageName    string
+	Success           bool
+	// Bypassed is true if verification failed, but the app is configured to
+	// bypass health authority verification.
+	Bypassed  bool
+	Error     string
+	CreatedAt time.Time
+}
+
+// StatsSinkFunc is a func that returns a stats sink or error. The database
+// may be nil if the calling service doesn't have a database configured.
+type StatsSinkFunc func(context.Context, *Config, *database.DB) (StatsSink, error)
+
+// sinks is the list of registered stats sinks.
+var (
+	sinks     = make(map[string]StatsSinkFunc)
+	sinksLock sync.RWMutex
+)
+
+// RegisterStatsSink registers a new stats sink with the given name. If a stats
+// sink is already registered with the given name, it panics. Stats sinks are
+// usually registered via an init function.
+func RegisterStatsSink(name string, fn StatsSinkFunc) {
+	sinksLock.Lock()
+	defer sinksLock.Unlock()
+
+	if _, ok := sinks[name]; ok {
+		panic(fmt.Sprintf("stats sink %q is already registered", name))
+	}
+	sinks[name] = fn
+}
+
+// RegisteredStatsSinks returns the list of the names of the registered stats
+// sinks.
+func RegisteredStatsSinks() []string {
+	sinksLock.RLock()
+	defer sinksLock.RUnlock()
+
+	list := make([]string, 0, len(sinks))
+	for k := range sinks {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// StatsSinkFor returns the stats sink with the given name, or an error if one
+// does not exist.
+func StatsSinkFor(ctx context.Context, cfg *Config, db *database.DB) (StatsSink, error) {
+	sinksLock.RLock()
+	defer sinksLock.RUnlock()
+
+	name := cfg.Type
+	fn, ok := sinks[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown or uncompiled stats sink %q", name)
+	}
+	return fn(ctx, cfg, db)
+}
@@ -0,0 +1,82 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statssink
+
+import (
+	"testing"
+
+	"github.com/google/exposure-notifications-server/internal/project"
+	publishmodel "github.com/google/exposure-notifications-server/internal/publish/model"
+)
+
+func TestStatsSinkFor(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	if _, err := StatsSinkFor(ctx, &Config{Type: "NOPE"}, nil); err == nil {
+		t.Errorf("expected error for unknown stats sink")
+	}
+	if _, err := StatsSinkFor(ctx, &Config{Type: "POSTGRES"}, nil); err == nil {
+		t.Errorf("expected error for postgres stats sink without a database")
+	}
+
+	sink, err := StatsSinkFor(ctx, &Config{Type: "MEMORY"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sink.(*Memory); !ok {
+		t.Errorf("expected %T to be *Memory", sink)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	sink := NewMemory()
+
+	info := &publishmodel.PublishInfo{NumTEKs: 3}
+	if err := sink.RecordPublish(ctx, 5, info); err != nil {
+		t.Fatal(err)
+	}
+	// Mutating the input must not change what was recorded.
+	info.NumTEKs = 10
+
+	if err := sink.RecordExport(ctx, &ExportRecord{BatchID: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.RecordVerification(ctx, &VerificationRecord{Success: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	publishes := sink.Publishes()
+	if got, want := len(publishes), 1; got != want {
+		t.Fatalf("expected %d publishes, got %d", want, got)
+	}
+	if got, want := publishes[0].HealthAuthorityID, int64(5); got != want {
+		t.Errorf("expected health authority %d to be %d", got, want)
+	}
+	if got, want := publishes[0].Info.NumTEKs, int32(3); got != want {
+		t.Errorf("expected %d TEKs to be %d", got, want)
+	}
+
+	if exports := sink.Exports(); len(exports) != 1 || exports[0].BatchID != 2 {
+		t.Errorf("unexpected exports: %#v", exports)
+	}
+	if verifications := sink.Verifications(); len(verifications) != 1 || !verifications[0].Success {
+		t.Errorf("unexpected verifications: %#v", verifications)
+	}
+}
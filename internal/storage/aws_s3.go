@@ -59,19 +59,23 @@ func NewAWSS3(ctx context.Context, _ *Config) (Blobstore, error) {
 
 // CreateObject creates a new S3 object or overwrites an existing one.
 func (s *AWSS3) CreateObject(ctx context.Context, bucket, key string, contents []byte, cacheable bool, contentType string) error {
-	cacheControl := "public, max-age=86400"
-	if !cacheable {
-		cacheControl = "no-cache, max-age=0"
-	}
+	return s.CreateObjectWithAttrs(ctx, bucket, key, contents, DefaultObjectAttrs(cacheable, contentType))
+}
 
+// CreateObjectWithAttrs creates a new S3 object or overwrites an existing one,
+// with the given headers.
+func (s *AWSS3) CreateObjectWithAttrs(ctx context.Context, bucket, key string, contents []byte, attrs *ObjectAttrs) error {
 	putInput := s3.PutObjectInput{
 		Bucket:       aws.String(bucket),
 		Key:          aws.String(key),
-		CacheControl: aws.String(cacheControl),
+		CacheControl: aws.String(attrs.CacheControl),
 		Body:         bytes.NewReader(contents),
 	}
-	if contentType != "" {
-		putInput.ContentType = aws.String(contentType)
+	if attrs.ContentType != "" {
+		putInput.ContentType = aws.String(attrs.ContentType)
+	}
+	if attrs.ContentDisposition != "" {
+		putInput.ContentDisposition = aws.String(attrs.ContentDisposition)
 	}
 	if _, err := s.svc.PutObjectWithContext(ctx, &putInput); err != nil {
 		return fmt.Errorf("storage.CreateObject: %w", err)
@@ -114,3 +118,31 @@ func (s *AWSS3) GetObject(ctx context.Context, bucket, key string) ([]byte, erro
 
 	return b, nil
 }
+
+// ListObjects lists the objects in the bucket that begin with the given prefix.
+func (s *AWSS3) ListObjects(ctx context.Context, bucket, prefix, pageToken string) ([]string, string, error) {
+	input := s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int64(ListObjectsPageSize),
+	}
+	if pageToken != "" {
+		input.ContinuationToken = aws.String(pageToken)
+	}
+
+	o, err := s.svc.ListObjectsV2WithContext(ctx, &input)
+	if err != nil {
+		return nil, "", fmt.Errorf("storage.ListObjects: %w", err)
+	}
+
+	names := make([]string, 0, len(o.Contents))
+	for _, obj := range o.Contents {
+		names = append(names, aws.StringValue(obj.Key))
+	}
+
+	var nextToken string
+	if aws.BoolValue(o.IsTruncated) {
+		nextToken = aws.StringValue(o.NextContinuationToken)
+	}
+	return names, nextToken, nil
+}
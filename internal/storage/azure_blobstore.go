@@ -134,17 +134,19 @@ func NewAzureBlobstore(ctx context.Context, _ *Config) (Blobstore, error) {
 
 // CreateObject creates a new blobstore object or overwrites an existing one.
 func (s *AzureBlobstore) CreateObject(ctx context.Context, container, name string, contents []byte, cacheable bool, contentType string) error {
-	cacheControl := "public, max-age=86400"
-	if !cacheable {
-		cacheControl = "no-cache, max-age=0"
-	}
+	return s.CreateObjectWithAttrs(ctx, container, name, contents, DefaultObjectAttrs(cacheable, contentType))
+}
 
+// CreateObjectWithAttrs creates a new blobstore object or overwrites an
+// existing one, with the given headers.
+func (s *AzureBlobstore) CreateObjectWithAttrs(ctx context.Context, container, name string, contents []byte, attrs *ObjectAttrs) error {
 	blobURL := s.serviceURL.NewContainerURL(container).NewBlockBlobURL(name)
 	headers := azblob.BlobHTTPHeaders{
-		CacheControl: cacheControl,
+		CacheControl:       attrs.CacheControl,
+		ContentDisposition: attrs.ContentDisposition,
 	}
-	if contentType != "" {
-		headers.ContentType = contentType
+	if attrs.ContentType != "" {
+		headers.ContentType = attrs.ContentType
 	}
 	if _, err := azblob.UploadBufferToBlockBlob(ctx, contents, blobURL, azblob.UploadToBlockBlobOptions{
 		BlobHTTPHeaders: headers,
@@ -188,3 +190,33 @@ func (s *AzureBlobstore) GetObject(ctx context.Context, container, name string)
 
 	return b.Bytes(), nil
 }
+
+// ListObjects lists the objects in the container that begin with the given
+// prefix.
+func (s *AzureBlobstore) ListObjects(ctx context.Context, container, prefix, pageToken string) ([]string, string, error) {
+	containerURL := s.serviceURL.NewContainerURL(container)
+
+	marker := azblob.Marker{}
+	if pageToken != "" {
+		marker.Val = &pageToken
+	}
+
+	resp, err := containerURL.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{
+		Prefix:     prefix,
+		MaxResults: ListObjectsPageSize,
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("storage.ListObjects: %w", err)
+	}
+
+	names := make([]string, 0, len(resp.Segment.BlobItems))
+	for _, item := range resp.Segment.BlobItems {
+		names = append(names, item.Name)
+	}
+
+	var nextToken string
+	if resp.NextMarker.NotDone() {
+		nextToken = *resp.NextMarker.Val
+	}
+	return names, nextToken, nil
+}
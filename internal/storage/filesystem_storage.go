@@ -18,8 +18,11 @@ package storage
 import (
 	"context"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 )
 
 func init() {
@@ -31,12 +34,16 @@ var _ Blobstore = (*FilesystemStorage)(nil)
 
 // FilesystemStorage implements Blobstore and provides the ability
 // write files to the filesystem.
-type FilesystemStorage struct{}
+type FilesystemStorage struct {
+	pageSize int
+}
 
 // NewFilesystemStorage creates a Blobsstore compatible storage for the
 // filesystem.
 func NewFilesystemStorage(ctx context.Context, _ *Config) (Blobstore, error) {
-	return &FilesystemStorage{}, nil
+	return &FilesystemStorage{
+		pageSize: ListObjectsPageSize,
+	}, nil
 }
 
 // CreateObject creates a new object on the filesystem or overwrites an existing
@@ -50,6 +57,12 @@ func (s *FilesystemStorage) CreateObject(ctx context.Context, folder, filename s
 	return nil
 }
 
+// CreateObjectWithAttrs creates a new object on the filesystem or overwrites an
+// existing one. The headers are ignored for this storage implementation.
+func (s *FilesystemStorage) CreateObjectWithAttrs(ctx context.Context, folder, filename string, contents []byte, _ *ObjectAttrs) error {
+	return s.CreateObject(ctx, folder, filename, contents, false, "")
+}
+
 // DeleteObject deletes an object from the filesystem. It returns nil if the
 // object was deleted or if the object no longer exists.
 func (s *FilesystemStorage) DeleteObject(ctx context.Context, folder, filename string) error {
@@ -73,3 +86,33 @@ func (s *FilesystemStorage) GetObject(ctx context.Context, folder, filename stri
 	}
 	return b, nil
 }
+
+// ListObjects lists the files under the folder, recursively, whose path
+// relative to the folder begins with the given prefix. Names always use forward
+// slashes. The page token is the last name returned in the previous page.
+func (s *FilesystemStorage) ListObjects(ctx context.Context, folder, prefix, pageToken string) ([]string, string, error) {
+	var names []string
+	if err := filepath.WalkDir(folder, func(pth string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(folder, pth)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if strings.HasPrefix(name, prefix) && name > pageToken {
+			names = append(names, name)
+		}
+		return nil
+	}); err != nil {
+		return nil, "", fmt.Errorf("failed to list objects: %w", err)
+	}
+	sort.Strings(names)
+
+	return paginateNames(names, s.pageSize)
+}
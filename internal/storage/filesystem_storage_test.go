@@ -21,6 +21,7 @@ import (
 	"testing"
 
 	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestFilesystemStorage_CreateObject(t *testing.T) {
@@ -187,3 +188,55 @@ func TestFilesystemStorage_GetObject(t *testing.T) {
 		})
 	}
 }
+
+func TestFilesystemStorage_ListObjects(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	tmp, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmp) })
+
+	if err := os.MkdirAll(filepath.Join(tmp, "exports", "nested"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"exports/a", "exports/b", "exports/c", "exports/nested/d", "other"} {
+		if err := os.WriteFile(filepath.Join(tmp, filepath.FromSlash(name)), []byte("contents"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	storage := &FilesystemStorage{pageSize: 2}
+
+	var got []string
+	var pages int
+	pageToken := ""
+	for {
+		names, nextToken, err := storage.ListObjects(ctx, tmp, "exports/", pageToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, names...)
+		pages++
+
+		if nextToken == "" {
+			break
+		}
+		pageToken = nextToken
+	}
+
+	want := []string{"exports/a", "exports/b", "exports/c", "exports/nested/d"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+	if pages != 2 {
+		t.Errorf("expected 2 pages, got %d", pages)
+	}
+
+	if _, _, err := storage.ListObjects(ctx, "/path/that/definitely/doesnt/exist", "", ""); err == nil {
+		t.Errorf("expected error listing missing folder")
+	}
+}
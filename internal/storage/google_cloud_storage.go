@@ -24,6 +24,7 @@ import (
 	"io"
 
 	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
 )
 
 func init() {
@@ -51,15 +52,17 @@ func NewGoogleCloudStorage(ctx context.Context, _ *Config) (Blobstore, error) {
 
 // CreateObject creates a new cloud storage object or overwrites an existing one.
 func (s *GoogleCloudStorage) CreateObject(ctx context.Context, bucket, objectName string, contents []byte, cacheable bool, contentType string) error {
-	cacheControl := "public, max-age=86400"
-	if !cacheable {
-		cacheControl = "no-cache, max-age=0"
-	}
+	return s.CreateObjectWithAttrs(ctx, bucket, objectName, contents, DefaultObjectAttrs(cacheable, contentType))
+}
 
+// CreateObjectWithAttrs creates a new cloud storage object or overwrites an
+// existing one, with the given headers.
+func (s *GoogleCloudStorage) CreateObjectWithAttrs(ctx context.Context, bucket, objectName string, contents []byte, attrs *ObjectAttrs) error {
 	wc := s.client.Bucket(bucket).Object(objectName).NewWriter(ctx)
-	wc.CacheControl = cacheControl
-	if contentType != "" {
-		wc.ContentType = contentType
+	wc.CacheControl = attrs.CacheControl
+	wc.ContentDisposition = attrs.ContentDisposition
+	if attrs.ContentType != "" {
+		wc.ContentType = attrs.ContentType
 	}
 
 	if _, err := wc.Write(contents); err != nil {
@@ -102,3 +105,21 @@ func (s *GoogleCloudStorage) GetObject(ctx context.Context, bucket, object strin
 
 	return b.Bytes(), nil
 }
+
+// ListObjects lists the objects in the bucket that begin with the given prefix.
+func (s *GoogleCloudStorage) ListObjects(ctx context.Context, bucket, prefix, pageToken string) ([]string, string, error) {
+	it := s.client.Bucket(bucket).Objects(ctx, &storage.Query{Prefix: prefix})
+	pager := iterator.NewPager(it, ListObjectsPageSize, pageToken)
+
+	var attrs []*storage.ObjectAttrs
+	nextToken, err := pager.NextPage(&attrs)
+	if err != nil {
+		return nil, "", fmt.Errorf("storage.ListObjects: %w", err)
+	}
+
+	names := make([]string, 0, len(attrs))
+	for _, a := range attrs {
+		names = append(names, a.Name)
+	}
+	return names, nextToken, nil
+}
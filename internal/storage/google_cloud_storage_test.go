@@ -233,3 +233,68 @@ func TestGoogleCloudStorage_DeleteObject(t *testing.T) {
 		})
 	}
 }
+
+func TestGoogleCloudStorage_ListObjects(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	client := testGoogleCloudStorageClient(t)
+	bucket := testGoogleCloudStorageBucket(t)
+	prefix := testName(t) + "/"
+
+	// Create more than a single page of objects under the prefix.
+	want := make([]string, 0, ListObjectsPageSize+1)
+	for i := 0; i <= ListObjectsPageSize; i++ {
+		name := fmt.Sprintf("%s%05d", prefix, i)
+		w := client.Bucket(bucket).Object(name).NewWriter(ctx)
+		if _, err := w.Write([]byte("contents")); err != nil {
+			t.Fatalf("failed to create object: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("failed to close writer: %v", err)
+		}
+		want = append(want, name)
+	}
+
+	t.Cleanup(func() {
+		for _, name := range want {
+			if err := client.Bucket(bucket).Object(name).Delete(ctx); err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+				t.Errorf("failed cleaning up %s: %v", name, err)
+			}
+		}
+	})
+
+	gcsStorage, err := NewGoogleCloudStorage(ctx, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	var pages int
+	pageToken := ""
+	for {
+		names, nextToken, err := gcsStorage.ListObjects(ctx, bucket, prefix, pageToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, names...)
+		pages++
+
+		if nextToken == "" {
+			break
+		}
+		pageToken = nextToken
+	}
+
+	if pages != 2 {
+		t.Errorf("expected 2 pages, got %d", pages)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d objects, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected object %d to be %q, got %q", i, want[i], got[i])
+		}
+	}
+}
@@ -17,6 +17,8 @@ package storage
 import (
 	"context"
 	"path"
+	"sort"
+	"strings"
 	"sync"
 )
 
@@ -30,28 +32,52 @@ var _ Blobstore = (*Memory)(nil)
 // Memory implements Blobstore and provides the ability write files to
 // memory.
 type Memory struct {
-	lock sync.Mutex
-	data map[string][]byte
+	lock     sync.Mutex
+	data     map[string][]byte
+	attrs    map[string]*ObjectAttrs
+	pageSize int
 }
 
 // NewMemory creates a Blobstore that writes data in memory.
 func NewMemory(_ context.Context, _ *Config) (Blobstore, error) {
 	return &Memory{
-		data: make(map[string][]byte),
+		data:     make(map[string][]byte),
+		attrs:    make(map[string]*ObjectAttrs),
+		pageSize: ListObjectsPageSize,
 	}, nil
 }
 
 // CreateObject creates a new object.
-// contentType is ignored in this implementation.
-func (s *Memory) CreateObject(_ context.Context, folder, filename string, contents []byte, cacheable bool, contentType string) error {
+func (s *Memory) CreateObject(ctx context.Context, folder, filename string, contents []byte, cacheable bool, contentType string) error {
+	return s.CreateObjectWithAttrs(ctx, folder, filename, contents, DefaultObjectAttrs(cacheable, contentType))
+}
+
+// CreateObjectWithAttrs creates a new object. The headers are kept so they can
+// be inspected with Attrs.
+func (s *Memory) CreateObjectWithAttrs(_ context.Context, folder, filename string, contents []byte, attrs *ObjectAttrs) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
 	pth := path.Join(folder, filename)
 	s.data[pth] = contents
+	s.attrs[pth] = attrs
 	return nil
 }
 
+// Attrs returns the headers the given object was written with. If the object
+// does not exist, it returns ErrNotFound.
+func (s *Memory) Attrs(folder, filename string) (*ObjectAttrs, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	pth := path.Join(folder, filename)
+	v, ok := s.attrs[pth]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return v, nil
+}
+
 // DeleteObject deletes an object. It returns nil if the object was deleted or
 // if the object no longer exists.
 func (s *Memory) DeleteObject(_ context.Context, folder, filename string) error {
@@ -60,6 +86,7 @@ func (s *Memory) DeleteObject(_ context.Context, folder, filename string) error
 
 	pth := path.Join(folder, filename)
 	delete(s.data, pth)
+	delete(s.attrs, pth)
 	return nil
 }
 
@@ -76,3 +103,28 @@ func (s *Memory) GetObject(_ context.Context, folder, filename string) ([]byte,
 	}
 	return v, nil
 }
+
+// ListObjects lists the objects in the folder with the given prefix. The page
+// token is the last object name returned in the previous page.
+func (s *Memory) ListObjects(_ context.Context, folder, prefix, pageToken string) ([]string, string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// CreateObject joins the folder and filename, so names are relative to the
+	// cleaned folder path.
+	folder = path.Clean(folder) + "/"
+
+	var names []string
+	for pth := range s.data {
+		if !strings.HasPrefix(pth, folder) {
+			continue
+		}
+		name := strings.TrimPrefix(pth, folder)
+		if strings.HasPrefix(name, prefix) && name > pageToken {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return paginateNames(names, s.pageSize)
+}
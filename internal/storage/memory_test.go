@@ -0,0 +1,148 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMemory_Attrs(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	blobstore, err := NewMemory(ctx, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage := blobstore.(*Memory)
+
+	if err := storage.CreateObject(ctx, "bucket", "index.txt", []byte("contents"), false, ContentTypeTextPlain); err != nil {
+		t.Fatal(err)
+	}
+	want := &ObjectAttrs{
+		ContentType:        ContentTypeZip,
+		CacheControl:       "public, max-age=3600",
+		ContentDisposition: "attachment",
+	}
+	if err := storage.CreateObjectWithAttrs(ctx, "bucket", "export.zip", []byte("contents"), want); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := storage.Attrs("bucket", "index.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(DefaultObjectAttrs(false, ContentTypeTextPlain), got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	got, err = storage.Attrs("bucket", "export.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	if err := storage.DeleteObject(ctx, "bucket", "export.zip"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Attrs("bucket", "export.zip"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected %v, got %v", ErrNotFound, err)
+	}
+}
+
+func TestMemory_ListObjects(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	storage := &Memory{
+		data:     make(map[string][]byte),
+		attrs:    make(map[string]*ObjectAttrs),
+		pageSize: 2,
+	}
+	for _, name := range []string{"v1/a", "v1/b", "v1/c", "v1/d", "v1/e", "v2/f"} {
+		if err := storage.CreateObject(ctx, "bucket", name, []byte("contents"), false, ContentTypeZip); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := storage.CreateObject(ctx, "other-bucket", "v1/g", []byte("contents"), false, ContentTypeZip); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name      string
+		prefix    string
+		want      []string
+		wantPages int
+	}{
+		{
+			name:      "prefix",
+			prefix:    "v1/",
+			want:      []string{"v1/a", "v1/b", "v1/c", "v1/d", "v1/e"},
+			wantPages: 3,
+		},
+		{
+			name:      "all",
+			prefix:    "",
+			want:      []string{"v1/a", "v1/b", "v1/c", "v1/d", "v1/e", "v2/f"},
+			wantPages: 3,
+		},
+		{
+			name:      "no_match",
+			prefix:    "v3/",
+			want:      nil,
+			wantPages: 1,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var got []string
+			var pages int
+			pageToken := ""
+			for {
+				names, nextToken, err := storage.ListObjects(ctx, "bucket", tc.prefix, pageToken)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, names...)
+				pages++
+
+				if nextToken == "" {
+					break
+				}
+				pageToken = nextToken
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+			if pages != tc.wantPages {
+				t.Errorf("expected %d pages, got %d", tc.wantPages, pages)
+			}
+		})
+	}
+}
@@ -25,21 +25,70 @@ import (
 var ErrNotFound = fmt.Errorf("storage object not found")
 
 const (
+	ContentTypeCBOR      = "application/cbor"
+	ContentTypeJSON      = "application/json"
 	ContentTypeTextPlain = "text/plain"
 	ContentTypeZip       = "application/zip"
 )
 
+const (
+	// CacheControlCacheable and CacheControlNoCache are the default
+	// cache-control headers of cacheable and non-cacheable objects.
+	CacheControlCacheable = "public, max-age=86400"
+	CacheControlNoCache   = "no-cache, max-age=0"
+)
+
+// ListObjectsPageSize is the maximum number of object names returned in a
+// single call to ListObjects.
+const ListObjectsPageSize = 1000
+
 // Blobstore defines the minimum interface for a blob storage system.
 type Blobstore interface {
 	// CreateObject creates or overwrites an object in the storage system.
 	// If contentType is blank, the default for the chosen storage implementation is used.
 	CreateObject(ctx context.Context, parent, name string, contents []byte, cacheable bool, contentType string) error
 
+	// CreateObjectWithAttrs is like CreateObject, but sets the given headers on
+	// the object. Implementations that don't support headers ignore them.
+	CreateObjectWithAttrs(ctx context.Context, parent, name string, contents []byte, attrs *ObjectAttrs) error
+
 	// DeleteObject deletes an object or does nothing if the object doesn't exist.
 	DeleteObject(ctx context.Context, parent, bame string) error
 
 	// GetObject fetches the object's contents.
 	GetObject(ctx context.Context, parent, name string) ([]byte, error)
+
+	// ListObjects returns the names of objects in parent that begin with prefix,
+	// in lexicographical order. Results are paginated; the returned nextToken is
+	// passed as pageToken to fetch the next page and is empty when there are no
+	// more results.
+	ListObjects(ctx context.Context, parent, prefix, pageToken string) (names []string, nextToken string, err error)
+}
+
+// ObjectAttrs are the headers set on an object when it is written.
+type ObjectAttrs struct {
+	// ContentType is the content type of the object. If blank, the default for
+	// the chosen storage implementation is used.
+	ContentType string
+
+	// CacheControl is the cache-control header of the object.
+	CacheControl string
+
+	// ContentDisposition is the content-disposition header of the object. It is
+	// optional.
+	ContentDisposition string
+}
+
+// DefaultObjectAttrs returns the headers that CreateObject sets.
+func DefaultObjectAttrs(cacheable bool, contentType string) *ObjectAttrs {
+	cacheControl := CacheControlCacheable
+	if !cacheable {
+		cacheControl = CacheControlNoCache
+	}
+	return &ObjectAttrs{
+		ContentType:  contentType,
+		CacheControl: cacheControl,
+	}
 }
 
 // BlobstoreFunc is a func that returns a blobstore or error.
@@ -91,3 +140,17 @@ func BlobstoreFor(ctx context.Context, cfg *Config) (Blobstore, error) {
 	}
 	return fn(ctx, cfg)
 }
+
+// paginateNames returns the first pageSize names and the token (the last name
+// returned) to use for the next page, if there are more names. The names must
+// already be sorted and filtered to those after the current page token.
+func paginateNames(names []string, pageSize int) ([]string, string, error) {
+	if pageSize <= 0 {
+		pageSize = ListObjectsPageSize
+	}
+	if len(names) <= pageSize {
+		return names, "", nil
+	}
+	names = names[:pageSize]
+	return names, names[len(names)-1], nil
+}
@@ -37,6 +37,7 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt"
+	"github.com/google/exposure-notifications-server/internal/verification"
 	vdb "github.com/google/exposure-notifications-server/internal/verification/database"
 	vm "github.com/google/exposure-notifications-server/internal/verification/model"
 	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
@@ -58,6 +59,8 @@ type StatsJWTConfig struct {
 	Key                *ecdsa.PrivateKey
 	Audience           string
 	JWTWarp            time.Duration
+	// BodyHash is the optional x-hmac claim binding the token to a request body.
+	BodyHash string
 }
 
 // IssueStatsJWT issues an auth token to call the stats API.
@@ -67,11 +70,14 @@ func (c *StatsJWTConfig) IssueStatsJWT(t testing.TB) string {
 	t.Helper()
 
 	now := time.Now().UTC()
-	claims := &jwt.StandardClaims{
-		Audience:  c.Audience,
-		ExpiresAt: now.Add(time.Minute).Unix(),
-		IssuedAt:  now.Unix(),
-		Issuer:    c.HealthAuthority.Issuer,
+	claims := &verification.StatsClaims{
+		StandardClaims: jwt.StandardClaims{
+			Audience:  c.Audience,
+			ExpiresAt: now.Add(time.Minute).Unix(),
+			IssuedAt:  now.Unix(),
+			Issuer:    c.HealthAuthority.Issuer,
+		},
+		BodyHash: c.BodyHash,
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
 	token.Header["kid"] = c.HealthAuthorityKey.Version
@@ -16,92 +16,454 @@ package verification
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt"
-	"github.com/google/exposure-notifications-server/internal/verification/database"
 	"github.com/google/exposure-notifications-server/internal/verification/model"
+	"github.com/google/exposure-notifications-server/pkg/logging"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
 )
 
+var (
+	// ErrBodyHashMismatch indicates a stats token is bound to a different
+	// request body than the one it was submitted with.
+	ErrBodyHashMismatch = errors.New("request body does not match token x-hmac claim")
+	// ErrIssuedInFuture indicates the 'iat' claim of a stats token is further in
+	// the future than the configured leeway, which points to a misconfigured
+	// clock on the issuer or a tampered token.
+	ErrIssuedInFuture = errors.New("token 'iat' claim is in the future")
+	// ErrMissingScope indicates a stats token doesn't have a scope required by
+	// the endpoint it was used with.
+	ErrMissingScope = errors.New("token is missing required scope")
+	// ErrKeyOutsideValidity indicates the key version matching a stats token
+	// is not valid now, with the configured leeway.
+	ErrKeyOutsideValidity = errors.New("key version is outside its validity window")
+	// ErrKeyMissingValidity indicates the key version matching a stats token
+	// has no start time, and StatsRequireKeyValidity is set.
+	ErrKeyMissingValidity = errors.New("key version has no validity window")
+	// ErrTokenLifetime indicates a stats token expires further in the future
+	// than StatsMaxTokenLifetime allows, or doesn't expire.
+	ErrTokenLifetime = errors.New("token lifetime exceeds the maximum")
+	// ErrSigningMethod indicates a stats token is signed with an algorithm
+	// that the server or its health authority doesn't accept.
+	ErrSigningMethod = errors.New("unsupported signing method")
+)
+
+// StatsClaims are the claims of a stats API token.
+type StatsClaims struct {
+	jwt.StandardClaims
+
+	// BodyHash optionally binds the token to a request body, so a stolen token
+	// can't be used to submit other data. It is the StatsBodyHash of the body.
+	BodyHash string `json:"x-hmac,omitempty"`
+
+	// Scope optionally limits the token to some endpoints. It is a space
+	// delimited list of scopes, like "stats:read stats:submit".
+	Scope string `json:"scope,omitempty"`
+}
+
+// StatsBodyHash returns the base64 encoded SHA-256 hash of a request body, for
+// the x-hmac claim of a stats token.
+func StatsBodyHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// StatsTokenDetails describes the health authority key that validated a stats
+// token, for auditing.
+type StatsTokenDetails struct {
+	// HealthAuthorityID is 0 for tokens verified with an inline certificate
+	// chain, whose health authority isn't registered. Issuer is set instead.
+	HealthAuthorityID int64
+	Issuer            string
+	// KeyVersion is the 'kid' of the key, or the default key version if the
+	// token has no 'kid'.
+	KeyVersion string
+	// Fingerprint is the hex encoded SHA-256 hash of the DER encoded public
+	// key.
+	Fingerprint string
+	// From and Thru are the validity window of the key. Thru is zero if the key
+	// doesn't expire.
+	From time.Time
+	Thru time.Time
+	// Scopes are the scopes of the token's 'scope' claim, or nil if it has
+	// none.
+	Scopes []string
+}
+
+// RequireScope returns ErrMissingScope if the token doesn't have the scope. An
+// empty scope is always satisfied, so scopes are only enforced where they are
+// configured.
+func (d *StatsTokenDetails) RequireScope(scope string) error {
+	if scope == "" {
+		return nil
+	}
+	for _, s := range d.Scopes {
+		if s == scope {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %v", ErrMissingScope, scope)
+}
+
 // AuthenticateStatsToken parse the provided JWT and determines if it is an authorized stats request
 // and returns the authorized health authority ID.
-func (v *Verifier) AuthenticateStatsToken(ctx context.Context, rawToken string) (int64, error) {
-	var healthAuthorityID int64
-	var claims *jwt.StandardClaims
+func (v *Verifier) AuthenticateStatsToken(ctx context.Context, rawToken string) (id int64, err error) {
+	details, err := v.AuthenticateStatsTokenDetailed(ctx, rawToken)
+	if err != nil {
+		return 0, err
+	}
+	return details.HealthAuthorityID, nil
+}
+
+// AuthenticateStatsTokenDetailed is like AuthenticateStatsToken, but returns
+// the details of the key that validated the token.
+func (v *Verifier) AuthenticateStatsTokenDetailed(ctx context.Context, rawToken string) (*StatsTokenDetails, error) {
+	details, err := v.authenticateStatsToken(ctx, rawToken, nil, false)
+	v.shadowStatsToken(ctx, rawToken, nil, false, err)
+	return details, err
+}
+
+// AuthenticateStatsTokenBody is like AuthenticateStatsToken, but if the token
+// has an x-hmac claim, it must match the hash of the request body. A mismatch
+// returns ErrBodyHashMismatch.
+func (v *Verifier) AuthenticateStatsTokenBody(ctx context.Context, rawToken string, body []byte) (id int64, err error) {
+	details, err := v.AuthenticateStatsTokenBodyDetailed(ctx, rawToken, body)
+	if err != nil {
+		return 0, err
+	}
+	return details.HealthAuthorityID, nil
+}
+
+// AuthenticateStatsTokenBodyDetailed is like AuthenticateStatsTokenBody, but
+// returns the details of the key that validated the token.
+func (v *Verifier) AuthenticateStatsTokenBodyDetailed(ctx context.Context, rawToken string, body []byte) (*StatsTokenDetails, error) {
+	details, err := v.authenticateStatsToken(ctx, rawToken, body, true)
+	v.shadowStatsToken(ctx, rawToken, body, true, err)
+	return details, err
+}
+
+// validateKID checks the format of a token's 'kid' header against the
+// configured length and pattern. The kid isn't included in the error, since it
+// could contain anything.
+func (v *Verifier) validateKID(kid string) error {
+	if max := v.config.StatsKIDMaxLength; max > 0 && uint(len(kid)) > max {
+		return fmt.Errorf("%w: length %d exceeds %d", ErrMalformedKID, len(kid), max)
+	}
+	if v.kidPattern != nil && !v.kidPattern.MatchString(kid) {
+		return fmt.Errorf("%w: does not match %q", ErrMalformedKID, v.kidPattern.String())
+	}
+	return nil
+}
 
-	token, err := jwt.ParseWithClaims(rawToken, &jwt.StandardClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if method, ok := token.Method.(*jwt.SigningMethodECDSA); !ok || method.Name != jwt.SigningMethodES256.Name {
-			return nil, fmt.Errorf("unsupported signing method, must be %v", jwt.SigningMethodES256.Name)
+// validateStatsSigningMethod checks that the token is signed with one of the
+// configured algorithms, and returns the algorithm.
+func (v *Verifier) validateStatsSigningMethod(token *jwt.Token) (string, error) {
+	algs := v.config.statsAlgorithms()
+	if method, ok := token.Method.(*jwt.SigningMethodECDSA); ok {
+		for _, alg := range algs {
+			if method.Name == alg {
+				return alg, nil
+			}
 		}
+	}
+	return "", fmt.Errorf("%w, must be %v", ErrSigningMethod, strings.Join(algs, ", "))
+}
 
-		kidHeader, ok := token.Header["kid"]
-		if !ok {
-			err := errors.New("missing 'kid' header in token")
-			return nil, err
+// validateTokenType checks the 'typ' header of a token against the configured
+// token types, if any. Like the kid, the typ isn't included in the error.
+func (v *Verifier) validateTokenType(token *jwt.Token) error {
+	if len(v.config.StatsTokenTypes) == 0 {
+		return nil
+	}
+
+	typ, ok := token.Header["typ"].(string)
+	if !ok {
+		return fmt.Errorf("%w: missing or invalid", ErrTokenType)
+	}
+	for _, accepted := range v.config.StatsTokenTypes {
+		if strings.EqualFold(typ, accepted) {
+			return nil
 		}
+	}
+	return fmt.Errorf("%w: must be one of %s", ErrTokenType, strings.Join(v.config.StatsTokenTypes, ", "))
+}
 
-		kid, ok := kidHeader.(string)
-		if !ok {
-			err := errors.New("invalid 'kid' field in token")
-			return nil, err
+// validateStatsClaims checks the time based claims of a stats token, allowing
+// for up to StatsTokenLeeway of clock skew. The leeway is symmetric, a token is
+// valid from the leeway before its 'nbf' until the leeway after its 'exp'.
+// Claims that aren't set aren't checked, except that when StatsMaxTokenLifetime
+// is set, tokens must expire within it.
+func (v *Verifier) validateStatsClaims(claims *StatsClaims, now time.Time) error {
+	leeway := v.config.StatsTokenLeeway
+	// Claims have a resolution of seconds, a token expiring at a second is
+	// still valid during it.
+	now = now.Truncate(time.Second)
+
+	if claims.ExpiresAt != 0 {
+		if delta := now.Sub(time.Unix(claims.ExpiresAt, 0)); delta > leeway {
+			return fmt.Errorf("token is expired by %v", delta)
+		}
+	}
+	if claims.NotBefore != 0 {
+		if delta := time.Unix(claims.NotBefore, 0).Sub(now); delta > leeway {
+			return fmt.Errorf("token is not valid yet, 'nbf' is %v in the future", delta)
 		}
+	}
+	if claims.IssuedAt != 0 {
+		if delta := time.Unix(claims.IssuedAt, 0).Sub(now); delta > leeway {
+			return fmt.Errorf("%w by %v", ErrIssuedInFuture, delta)
+		}
+	}
 
-		claims, ok = token.Claims.(*jwt.StandardClaims)
-		if !ok {
-			return nil, fmt.Errorf("token does not contain expected claim set")
+	if max := v.config.StatsMaxTokenLifetime; max > 0 {
+		if claims.ExpiresAt == 0 {
+			return fmt.Errorf("%w: token has no 'exp' claim", ErrTokenLifetime)
+		}
+		start := now
+		if claims.IssuedAt != 0 {
+			start = time.Unix(claims.IssuedAt, 0)
 		}
+		if lifetime := time.Unix(claims.ExpiresAt, 0).Sub(start); lifetime > max {
+			return fmt.Errorf("%w: %v is more than %v", ErrTokenLifetime, lifetime, max)
+		}
+	}
+	return nil
+}
 
-		lookup := func() (interface{}, error) {
-			// Based on issuer, load the key versions.
-			ha, err := v.db.GetHealthAuthority(ctx, claims.Issuer)
-			// Special case not found so that we can cache it.
-			if errors.Is(err, database.ErrHealthAuthorityNotFound) {
-				return nil, nil
-			}
-			if err != nil {
-				return nil, fmt.Errorf("error looking up issuer: %v : %w", claims.Issuer, err)
-			}
-			return ha, nil
+// isAbsoluteURI returns true if s is an absolute URI, with a scheme and either
+// a host or an opaque part, like https://keyserver.example.com or
+// urn:keyserver.
+func isAbsoluteURI(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil || !u.IsAbs() {
+		return false
+	}
+	return u.Host != "" || u.Opaque != ""
+}
+
+// acceptDeprecatedAudience returns true if the token is for one of the
+// deprecated stats audiences. Accepted tokens are logged and recorded in a
+// metric.
+func (v *Verifier) acceptDeprecatedAudience(ctx context.Context, claims *StatsClaims) bool {
+	for _, aud := range v.config.StatsDeprecatedAudiences {
+		if aud == "" || !claims.VerifyAudience(aud, true) {
+			continue
+		}
+		if v.isShadow {
+			return true
+		}
+
+		logger := logging.FromContext(ctx)
+		logger.Warnw("accepted stats token with deprecated audience", "iss", claims.Issuer, "aud", aud)
+		tags := []tag.Mutator{tag.Upsert(issuerTag, claims.Issuer), tag.Upsert(audienceTag, aud)}
+		if err := stats.RecordWithTags(ctx, tags, mDeprecatedStatsAudience.M(1)); err != nil {
+			logger.Errorw("failed to record stats for deprecated audience", "error", err, "iss", claims.Issuer)
+		}
+		return true
+	}
+	return false
+}
+
+// validateStatsKey returns an error if the key may not verify stats tokens
+// at now. The key must be within its From and Thru times, extended by the
+// configured leeway. This is checked here instead of relying on
+// HealthAuthorityKey.IsValid, so the window is enforced for stats tokens
+// whatever else that checks. A key without a From time has no validity window,
+// it is rejected if StatsRequireKeyValidity is set.
+func (v *Verifier) validateStatsKey(key *model.HealthAuthorityKey, now time.Time) error {
+	leeway := v.config.StatsTokenLeeway
+
+	if key.From.IsZero() {
+		if v.config.StatsRequireKeyValidity {
+			return fmt.Errorf("%w: kid: %v", ErrKeyMissingValidity, key.Version)
 		}
-		cacheVal, err := v.haCache.WriteThruLookup(claims.Issuer, lookup)
+	} else if !now.After(key.From.Add(-leeway)) {
+		return fmt.Errorf("%w: kid: %v is not valid until %v", ErrKeyOutsideValidity, key.Version, key.From.UTC().Format(time.RFC3339))
+	}
+	if !key.Thru.IsZero() && !key.Thru.Add(leeway).After(now) {
+		return fmt.Errorf("%w: kid: %v was valid through %v", ErrKeyOutsideValidity, key.Version, key.Thru.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+func (v *Verifier) authenticateStatsToken(ctx context.Context, rawToken string, body []byte, checkBody bool) (details *StatsTokenDetails, err error) {
+	var healthAuthority *model.HealthAuthority
+	var matchedKey *model.HealthAuthorityKey
+	var claims *StatsClaims
+
+	cache := cacheNoneTag
+	if !v.isShadow {
+		defer recordStatsTokenLatency(ctx, time.Now(), &cache, &err)
+	}
+
+	// The time based claims are validated after parsing, with the configured
+	// leeway.
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.ParseWithClaims(rawToken, &StatsClaims{}, func(token *jwt.Token) (interface{}, error) {
+		alg, err := v.validateStatsSigningMethod(token)
 		if err != nil {
 			return nil, err
 		}
+		if err := v.validateTokenType(token); err != nil {
+			return nil, err
+		}
 
-		if cacheVal == nil {
-			return nil, fmt.Errorf("issuer not found: %v", claims.Issuer)
+		// A missing 'kid' is only accepted if the health authority has a default
+		// key version, which is checked once it is loaded.
+		var kid string
+		kidHeader, hasKID := token.Header["kid"]
+		if hasKID {
+			var ok bool
+			kid, ok = kidHeader.(string)
+			if !ok {
+				err := errors.New("invalid 'kid' field in token")
+				return nil, err
+			}
+			if err := v.validateKID(kid); err != nil {
+				return nil, err
+			}
 		}
 
-		healthAuthority, ok := cacheVal.(*model.HealthAuthority)
+		var ok bool
+		claims, ok = token.Claims.(*StatsClaims)
 		if !ok {
-			return nil, fmt.Errorf("incorrect type in cache: %w", err)
+			return nil, fmt.Errorf("token does not contain expected claim set")
+		}
+
+		var source haSource
+		healthAuthority, source, err = v.lookupHealthAuthorityCached(ctx, claims.Issuer)
+		switch source {
+		case haSourceCache:
+			cache = cacheHitTag
+		case haSourceSecondary:
+			cache = cacheSecondaryTag
+		case haSourceResolver:
+			cache = cacheResolverTag
+		default:
+			cache = cacheMissTag
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := v.checkHTTPSIssuer(ctx, healthAuthority); err != nil {
+			return nil, err
 		}
 		// check that the API is enabled for this HA.
 		if !healthAuthority.EnableStatsAPI {
 			return nil, fmt.Errorf("API access forbidden")
 		}
+		if !healthAuthority.AllowsStatsAlgorithm(alg) {
+			return nil, fmt.Errorf("%w: %v is not allowed for iss: %v, must be one of %s",
+				ErrSigningMethod, alg, claims.Issuer, strings.Join(healthAuthority.StatsAlgorithms, ", "))
+		}
+
+		if !hasKID {
+			if healthAuthority.DefaultStatsKeyVersion == nil {
+				err := errors.New("missing 'kid' header in token")
+				return nil, err
+			}
+			kid = *healthAuthority.DefaultStatsKeyVersion
+		}
+
+		if v.config.RejectDuplicateKIDs {
+			for _, version := range healthAuthority.DuplicateKeyVersions() {
+				if version == kid {
+					return nil, fmt.Errorf("%w: kid: %v iss: %v", ErrDuplicateKID, kid, claims.Issuer)
+				}
+			}
+		}
 
-		// Look for the matching 'kid'
+		// Look for the matching 'kid'. If there are keys with the version, but
+		// none are valid now, the reason the first one is rejected is returned.
+		now := v.clock.Now()
+		var keyErr error
 		for _, key := range healthAuthority.Keys {
-			if key.Version == kid && key.IsValid() {
-				healthAuthorityID = healthAuthority.ID
-				return key.PublicKey()
+			if key.Version != kid {
+				continue
+			}
+			if err := v.validateStatsKey(key, now); err != nil {
+				if keyErr == nil {
+					keyErr = err
+				}
+				continue
+			}
+			if !key.AllowsUsage(model.KeyUsageStats) {
+				return nil, fmt.Errorf("%w: kid: %v usage: %v", ErrKeyUsage, kid, key.Usage)
 			}
+			matchedKey = key
+			return key.PublicKey()
+		}
+		if keyErr != nil {
+			return nil, keyErr
 		}
 		return nil, fmt.Errorf("key not found: kid: %v iss: %v ", kid, claims.Issuer)
 	})
 	if err != nil {
-		return 0, fmt.Errorf("unauthorized: %w", err)
+		return nil, fmt.Errorf("unauthorized: %w", err)
 	}
 
 	if !token.Valid {
-		return 0, fmt.Errorf("authentication token invalid")
+		return nil, fmt.Errorf("authentication token invalid")
+	}
+
+	if err := v.validateStatsClaims(claims, v.clock.Now()); err != nil {
+		return nil, fmt.Errorf("unauthorized: %w", err)
 	}
 
-	if !claims.VerifyAudience(v.config.StatsAudience, true) {
-		return 0, fmt.Errorf("unauthorized, audience mismatch")
+	if v.config.StatsRequireURIAudience && !isAbsoluteURI(claims.Audience) {
+		return nil, fmt.Errorf("unauthorized, audience %q is not an absolute URI", claims.Audience)
 	}
 
-	return healthAuthorityID, nil
+	// The health authority may have its own audience, which is only accepted
+	// for tokens it issued.
+	if !claims.VerifyAudience(v.config.StatsAudience, true) &&
+		(healthAuthority.StatsAudience == nil || !claims.VerifyAudience(*healthAuthority.StatsAudience, true)) {
+		if !v.acceptDeprecatedAudience(ctx, claims) {
+			return nil, fmt.Errorf("unauthorized, audience mismatch")
+		}
+	}
+
+	if checkBody && claims.BodyHash != "" {
+		if subtle.ConstantTimeCompare([]byte(claims.BodyHash), []byte(StatsBodyHash(body))) != 1 {
+			return nil, fmt.Errorf("unauthorized: %w", ErrBodyHashMismatch)
+		}
+	}
+
+	fingerprint, err := matchedKey.Fingerprint()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fingerprint key: %w", err)
+	}
+	details = &StatsTokenDetails{
+		HealthAuthorityID: healthAuthority.ID,
+		KeyVersion:        matchedKey.Version,
+		Fingerprint:       fingerprint,
+		From:              matchedKey.From,
+		Thru:              matchedKey.Thru,
+	}
+	if scopes := strings.Fields(claims.Scope); len(scopes) > 0 {
+		details.Scopes = scopes
+	}
+	return details, nil
+}
+
+// recordStatsTokenLatency records the time since start, tagged with whether the
+// token was accepted and whether the health authority was cached.
+func recordStatsTokenLatency(ctx context.Context, start time.Time, cache *tag.Mutator, err *error) {
+	outcome := outcomeSuccess
+	if *err != nil {
+		outcome = outcomeFailure
+	}
+	ms := float64(time.Since(start)) / float64(time.Millisecond)
+	if err := stats.RecordWithTags(ctx, []tag.Mutator{outcome, *cache}, mStatsTokenLatencyMs.M(ms)); err != nil {
+		logging.FromContext(ctx).Errorw("failed to record stats token latency", "error", err)
+	}
 }
@@ -0,0 +1,63 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verification
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Clock tells the verifier the current time. Every time based check of tokens
+// and keys uses it, so tests can control the time instead of depending on the
+// wall clock.
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock is the Clock of the wall clock.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// SetClock replaces the clock of the verifier, and of its shadow verifier and
+// background JWKS refresher. It must be called before the verifier is used.
+func (v *Verifier) SetClock(clock Clock) {
+	v.clock = clock
+	if v.shadow != nil {
+		v.shadow.clock = clock
+	}
+	if v.jwks != nil {
+		v.jwks.clock = clock
+	}
+}
+
+// validateCertificateTime checks the time based claims of a verification
+// certificate at now, like jwt.StandardClaims.Valid does at the wall clock
+// time. A certificate that is issued or valid only in the future is rejected
+// with ErrNotValidYet.
+func validateCertificateTime(claims *jwt.StandardClaims, now time.Time) error {
+	unix := now.Unix()
+	if !claims.VerifyIssuedAt(unix, false) || !claims.VerifyNotBefore(unix, false) {
+		return ErrNotValidYet
+	}
+	if !claims.VerifyExpiresAt(unix, false) {
+		return fmt.Errorf("token is expired by %v", time.Unix(unix, 0).Sub(time.Unix(claims.ExpiresAt, 0)))
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verification
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	aamodel "github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/exposure-notifications-server/internal/verification/model"
+	"github.com/google/exposure-notifications-server/internal/verification/verifytest"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
+	"github.com/google/exposure-notifications-server/pkg/errcmp"
+	utils "github.com/google/exposure-notifications-server/pkg/verification"
+)
+
+// fakeClock is a Clock that is always at the same time.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+// clockBase is the time the tokens in the clock tests are issued at.
+var clockBase = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func TestAuthenticateStatsToken_Clock(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	statsAudience := "test-stats-aud"
+
+	// The token is valid from clockBase for five minutes, the key from an hour
+	// before until an hour after, both ends excluded.
+	cases := []struct {
+		name   string
+		now    time.Time
+		leeway time.Duration
+		err    string
+	}{
+		{
+			name: "issued",
+			now:  clockBase,
+		},
+		{
+			name: "last_second",
+			now:  clockBase.Add(5*time.Minute + 999*time.Millisecond),
+		},
+		{
+			name: "expired",
+			now:  clockBase.Add(5*time.Minute + time.Second),
+			err:  "token is expired by 1s",
+		},
+		{
+			name:   "expired_within_leeway",
+			now:    clockBase.Add(5*time.Minute + 30*time.Second),
+			leeway: 30 * time.Second,
+		},
+		{
+			name:   "expired_beyond_leeway",
+			now:    clockBase.Add(5*time.Minute + 31*time.Second),
+			leeway: 30 * time.Second,
+			err:    "token is expired by 31s",
+		},
+		{
+			name: "not_valid_yet",
+			now:  clockBase.Add(-time.Second),
+			err:  "token is not valid yet",
+		},
+		{
+			name:   "not_valid_yet_within_leeway",
+			now:    clockBase.Add(-30 * time.Second),
+			leeway: 30 * time.Second,
+		},
+		{
+			name: "key_not_active",
+			now:  clockBase.Add(-time.Hour),
+			err:  ErrKeyOutsideValidity.Error(),
+		},
+		{
+			name: "key_expired",
+			now:  clockBase.Add(time.Hour),
+			err:  ErrKeyOutsideValidity.Error(),
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			verifier, err := New(nil, &Config{
+				CacheDuration:    time.Hour,
+				StatsAudience:    statsAudience,
+				StatsTokenLeeway: tc.leeway,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			verifier.SetClock(&fakeClock{now: tc.now})
+
+			ha := verifytest.NewHealthAuthority(t, "clock.test.health")
+			ha.HealthAuthority.ID = 31
+			ha.Key.From = clockBase.Add(-time.Hour)
+			ha.Key.Thru = clockBase.Add(time.Hour)
+			ha.Cache(t, verifier)
+
+			token := jwt.NewWithClaims(jwt.SigningMethodES256, &jwt.StandardClaims{
+				Audience:  statsAudience,
+				ExpiresAt: clockBase.Add(5 * time.Minute).Unix(),
+				IssuedAt:  clockBase.Unix(),
+				Issuer:    ha.HealthAuthority.Issuer,
+				NotBefore: clockBase.Unix(),
+			})
+			token.Header["kid"] = ha.Key.Version
+			signed, err := token.SignedString(ha.PrivateKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			id, err := verifier.AuthenticateStatsToken(ctx, signed)
+			errcmp.MustMatch(t, err, tc.err)
+			if tc.err == "" {
+				if got, want := id, ha.HealthAuthority.ID; got != want {
+					t.Errorf("expected health authority %d to be %d", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyCertificate_Clock(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	hmacKey := make([]byte, 32)
+	if _, err := rand.Read(hmacKey); err != nil {
+		t.Fatal(err)
+	}
+	keys := []verifyapi.ExposureKey{
+		{
+			Key:              "IRgYIhYiy4WMl9z68bMk6w==",
+			IntervalNumber:   2650032,
+			IntervalCount:    144,
+			TransmissionRisk: 4,
+		},
+	}
+	allHMACs, err := utils.CalculateAllAllowedExposureKeyHMAC(keys, hmacKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The certificate is issued at clockBase and expires five minutes later,
+	// the key is valid from an hour before, excluded.
+	cases := []struct {
+		name string
+		now  time.Time
+		err  string
+	}{
+		{
+			name: "issued",
+			now:  clockBase,
+		},
+		{
+			name: "expires",
+			now:  clockBase.Add(5 * time.Minute),
+		},
+		{
+			name: "expired",
+			now:  clockBase.Add(5*time.Minute + time.Second),
+			err:  "token is expired by 1s",
+		},
+		{
+			name: "not_valid_yet",
+			now:  clockBase.Add(-time.Second),
+			err:  ErrNotValidYet.Error(),
+		},
+		{
+			name: "key_not_active",
+			now:  clockBase.Add(-time.Hour),
+			err:  ErrNoPublicKeys.Error(),
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			verifier, err := New(nil, &Config{CacheDuration: time.Hour})
+			if err != nil {
+				t.Fatal(err)
+			}
+			verifier.SetClock(&fakeClock{now: tc.now})
+
+			ha := verifytest.NewHealthAuthority(t, "clock-cert.test.health")
+			ha.HealthAuthority.ID = 32
+			ha.Key.From = clockBase.Add(-time.Hour)
+			ha.Cache(t, verifier)
+
+			authApp := aamodel.NewAuthorizedApp()
+			authApp.AllowedHealthAuthorityIDs[ha.HealthAuthority.ID] = struct{}{}
+
+			claims := verifyapi.NewVerificationClaims()
+			claims.Audience = ha.HealthAuthority.Audience
+			claims.Issuer = ha.HealthAuthority.Issuer
+			claims.IssuedAt = clockBase.Unix()
+			claims.ExpiresAt = clockBase.Add(5 * time.Minute).Unix()
+			claims.SignedMAC = base64.StdEncoding.EncodeToString(allHMACs[0])
+			claims.ReportType = verifyapi.ReportTypeConfirmed
+
+			token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+			token.Header[verifyapi.KeyIDHeader] = verifytest.DefaultKeyVersion
+			payload, err := token.SignedString(ha.PrivateKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			publish := verifyapi.Publish{
+				Keys:                keys,
+				HMACKey:             base64.StdEncoding.EncodeToString(hmacKey),
+				VerificationPayload: payload,
+			}
+			verifiedClaims, err := verifier.VerifyDiagnosisCertificate(ctx, authApp, &publish)
+			errcmp.MustMatch(t, err, tc.err)
+			if tc.err == "" {
+				if got, want := verifiedClaims.HealthAuthorityID, ha.HealthAuthority.ID; got != want {
+					t.Errorf("expected health authority %d to be %d", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestJWKSRefresher_Clock(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	clock := &fakeClock{now: clockBase}
+
+	verifier, err := New(nil, &Config{
+		CacheDuration:       time.Hour,
+		JWKSRefreshInterval: time.Minute,
+		JWKSStaleGrace:      time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier.SetClock(clock)
+
+	ha := newJWKSHealthAuthority(t, "clock-jwks.test.health", 33)
+	fake := &fakeHealthAuthorities{has: []*model.HealthAuthority{ha.HealthAuthority}}
+	verifier.jwks.list = fake.list
+	if err := verifier.RefreshJWKS(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The refreshed health authorities are used for the interval plus the
+	// stale grace.
+	clock.now = clockBase.Add(2 * time.Minute)
+	if _, _, ok := verifier.jwks.lookup(ha.HealthAuthority.Issuer); !ok {
+		t.Errorf("expected the health authority at the end of the stale grace")
+	}
+	clock.now = clockBase.Add(2*time.Minute + time.Nanosecond)
+	if _, _, ok := verifier.jwks.lookup(ha.HealthAuthority.Issuer); ok {
+		t.Errorf("expected no health authority after the stale grace")
+	}
+}
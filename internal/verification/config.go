@@ -14,13 +14,225 @@
 
 package verification
 
-import "time"
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
 
 // Config represents the available configuration for the public health authority
 // verification piece.
 type Config struct {
 	CacheDuration time.Duration `env:"VERIFICATION_CACHE_DURATION, default=5m"`
 
+	// CacheStaleGrace is how long past CacheDuration a cached health authority
+	// may still be used if refreshing it from the database fails. Zero
+	// disables serving stale values.
+	CacheStaleGrace time.Duration `env:"VERIFICATION_CACHE_STALE_GRACE, default=0"`
+
+	// CacheMaxSize is the maximum number of issuers in the health authority
+	// cache, including issuers that weren't found. It bounds memory when tokens
+	// are sent with many distinct issuers. Beyond it, the least recently used
+	// issuers are evicted and looked up again on their next use. Zero removes
+	// the limit.
+	CacheMaxSize uint `env:"VERIFICATION_CACHE_MAX_SIZE, default=1000"`
+
+	// CacheMaintenanceMode serves cached health authorities however long ago
+	// they expired if refreshing them from the database fails, e.g. during
+	// planned database maintenance. Health authorities that aren't cached
+	// still fail. Clear it once the maintenance is over.
+	//
+	// The mode can also be enabled from the admin console, without a restart.
+	// Servers read it from the database every
+	// CacheMaintenanceModeRefreshInterval, so enable it at least that long
+	// before the maintenance starts. Zero only uses this setting.
+	CacheMaintenanceMode                bool          `env:"VERIFICATION_CACHE_MAINTENANCE_MODE, default=false"`
+	CacheMaintenanceModeRefreshInterval time.Duration `env:"VERIFICATION_CACHE_MAINTENANCE_MODE_REFRESH_INTERVAL, default=1m"`
+
+	// JWKSRefreshInterval, if set, reloads the health authorities that have a
+	// JWKS URI in the background at this interval, so verifying their tokens
+	// never waits on a lookup. If a refresh fails, the previously loaded keys
+	// are used for up to JWKSStaleGrace past the interval. Zero disables the
+	// background refresh, and those health authorities are cached like others.
+	JWKSRefreshInterval time.Duration `env:"VERIFICATION_JWKS_REFRESH_INTERVAL, default=0"`
+	JWKSStaleGrace      time.Duration `env:"VERIFICATION_JWKS_STALE_GRACE, default=5m"`
+
+	// SecondaryKeyStoreFile, if set, is a JSON snapshot of the health
+	// authorities and their keys, the JSON encoding of a list of
+	// model.HealthAuthority. It is read once on startup, and consulted only
+	// when a health authority can't be read from the database and no cached
+	// value can be used, so verification survives database outages. Each use
+	// is logged and counted in a metric.
+	SecondaryKeyStoreFile string `env:"VERIFICATION_SECONDARY_KEY_STORE_FILE"`
+
 	// StatsAudience is the expected JWT 'aud' value when calling the /v1/stats API.
 	StatsAudience string `env:"STATS_AUDIENCE, default=keyserver"`
+
+	// StatsDeprecatedAudiences are previous values of StatsAudience that are
+	// still accepted while health authorities update their tokens. Each token
+	// accepted with one of them is logged and counted in a metric, by issuer,
+	// so the remaining health authorities can be followed up with. Remove an
+	// audience once nothing uses it.
+	StatsDeprecatedAudiences []string `env:"STATS_DEPRECATED_AUDIENCES"`
+
+	// StatsRequireURIAudience rejects stats API tokens whose 'aud' isn't an
+	// absolute URI, like https://keyserver.example.com or urn:keyserver, before
+	// it is compared with the accepted audiences. The accepted audiences should
+	// then be URIs too.
+	StatsRequireURIAudience bool `env:"STATS_REQUIRE_URI_AUDIENCE, default=false"`
+
+	// StatsInlineX5CTrustAnchorsFile, if set, is a bundle of PEM encoded CA
+	// certificates that Verifier.AuthenticateInlineX5CStatsToken validates the
+	// x5c certificate chain of stats API tokens against. This accepts tokens
+	// from issuers that aren't registered, for controlled federation setups.
+	// The issuer is the common name of the leaf certificate. Registered health
+	// authorities never use these anchors.
+	StatsInlineX5CTrustAnchorsFile string `env:"STATS_INLINE_X5C_TRUST_ANCHORS_FILE"`
+
+	// MaxActiveKeyVersions is the maximum number of active (not revoked) key
+	// versions a health authority is expected to have. Health authorities that
+	// exceed this are reported via a metric when they are loaded. Zero, the
+	// default, means no limit.
+	MaxActiveKeyVersions uint `env:"HEALTH_AUTHORITY_MAX_ACTIVE_KEY_VERSIONS, default=0"`
+
+	// RejectDuplicateKIDs rejects stats API tokens whose 'kid' matches more
+	// than one key version of the health authority. New duplicate versions
+	// can't be added, this only applies to existing data. Otherwise, the token
+	// is verified with the first matching key, and a metric is recorded when
+	// the health authority is loaded.
+	RejectDuplicateKIDs bool `env:"REJECT_DUPLICATE_KIDS, default=false"`
+
+	// CheckDuplicateKIDsOnStartup scans the keys of all health authorities for
+	// duplicate key versions when the server starts, and logs and records a
+	// metric for each health authority that has them. With
+	// StrictDuplicateKIDs, the server also fails to start until they are
+	// cleaned up.
+	CheckDuplicateKIDsOnStartup bool `env:"CHECK_DUPLICATE_KIDS_ON_STARTUP, default=false"`
+	StrictDuplicateKIDs         bool `env:"STRICT_DUPLICATE_KIDS, default=false"`
+
+	// StatsKIDPattern is a regular expression the 'kid' header of a stats API
+	// token must match, and StatsKIDMaxLength is its maximum length in bytes.
+	// Tokens with a malformed 'kid' are rejected before the health authority
+	// is looked up. An empty pattern or a zero length disables that check,
+	// both checks are disabled by default. For example, "^[[:graph:]]+$" and
+	// 64 reject kids that are empty, contain whitespace or are too long.
+	StatsKIDPattern   string `env:"STATS_KID_PATTERN"`
+	StatsKIDMaxLength uint   `env:"STATS_KID_MAX_LENGTH, default=0"`
+
+	// StatsTokenTypes, if set, are the accepted values of the 'typ' header of
+	// stats API tokens, compared case-insensitively. Tokens with any other or
+	// no 'typ' are rejected. If empty, the header is not checked.
+	StatsTokenTypes []string `env:"STATS_TOKEN_TYPES"`
+
+	// StatsAlgorithms are the signing algorithms accepted on stats API tokens,
+	// from ES256, ES384 and ES512. A health authority can restrict its tokens
+	// further, see model.HealthAuthority.StatsAlgorithms, tokens must then use
+	// an algorithm allowed by both. If empty, only ES256 is accepted.
+	StatsAlgorithms []string `env:"STATS_TOKEN_ALGORITHMS, default=ES256"`
+
+	// StatsTokenLeeway is the allowed clock skew when checking the 'exp',
+	// 'nbf' and 'iat' claims of stats API tokens. It applies in both
+	// directions: a token is accepted up to the leeway after its 'exp' and up
+	// to the leeway before its 'nbf', so pre-provisioned tokens that become
+	// valid slightly in the future are accepted. Tokens with an 'iat' more than
+	// the leeway in the future are rejected.
+	StatsTokenLeeway time.Duration `env:"STATS_TOKEN_LEEWAY, default=0"`
+
+	// StatsMaxTokenLifetime, if set, rejects stats API tokens whose 'exp' is
+	// more than this after their 'iat', or after now if they have no 'iat',
+	// so tokens can't be used as long-lived credentials. Tokens without an
+	// 'exp' are rejected too. Zero disables the check.
+	StatsMaxTokenLifetime time.Duration `env:"STATS_MAX_TOKEN_LIFETIME, default=0"`
+
+	// StatsRequireKeyValidity rejects stats API tokens whose key version has
+	// no start time, instead of treating the key as valid since forever. Keys
+	// with a start time are only used within their validity window, extended
+	// by StatsTokenLeeway. Keys without an end time are valid until revoked.
+	StatsRequireKeyValidity bool `env:"STATS_REQUIRE_KEY_VALIDITY, default=false"`
+
+	// RequireHTTPSIssuers rejects saving health authorities in the admin
+	// console whose issuer isn't an https:// URL. Existing health authorities
+	// are only checked when they are saved again. HTTPSIssuerPolicy applies the
+	// same check when verifying tokens, see HTTPSIssuerPolicy.
+	RequireHTTPSIssuers bool              `env:"HEALTH_AUTHORITY_REQUIRE_HTTPS_ISSUER, default=false"`
+	HTTPSIssuerPolicy   HTTPSIssuerPolicy `env:"VERIFICATION_HTTPS_ISSUER_POLICY, default=allow"`
+
+	// Shadow are candidate stats API token settings that are evaluated next to
+	// the settings above, see ShadowConfig.
+	Shadow ShadowConfig `env:",prefix=VERIFICATION_SHADOW_"`
+}
+
+// ShadowConfig are stats API token settings to try out before enforcing them.
+// When enabled, every stats API token is verified a second time with these
+// settings replacing the live ones, and whether both accepted or rejected the
+// token is recorded in a metric. Tokens where they differ are also logged.
+// The outcome with the shadow settings never changes the response.
+//
+// Settings that are empty or false are the same as the live setting.
+type ShadowConfig struct {
+	Enabled bool `env:"ENABLED, default=false"`
+
+	StatsAudience           string   `env:"STATS_AUDIENCE"`
+	StatsTokenTypes         []string `env:"STATS_TOKEN_TYPES"`
+	StatsKIDPattern         string   `env:"STATS_KID_PATTERN"`
+	RejectDuplicateKIDs     bool     `env:"REJECT_DUPLICATE_KIDS"`
+	StatsRequireKeyValidity bool     `env:"STATS_REQUIRE_KEY_VALIDITY"`
+	StatsRequireURIAudience bool     `env:"STATS_REQUIRE_URI_AUDIENCE"`
+}
+
+// shadowConfig returns the config with the shadow settings applied, or nil if
+// shadow verification is disabled.
+func (c *Config) shadowConfig() *Config {
+	if !c.Shadow.Enabled {
+		return nil
+	}
+
+	shadow := *c
+	shadow.Shadow = ShadowConfig{}
+	if c.Shadow.StatsAudience != "" {
+		shadow.StatsAudience = c.Shadow.StatsAudience
+	}
+	if len(c.Shadow.StatsTokenTypes) > 0 {
+		shadow.StatsTokenTypes = c.Shadow.StatsTokenTypes
+	}
+	if c.Shadow.StatsKIDPattern != "" {
+		shadow.StatsKIDPattern = c.Shadow.StatsKIDPattern
+	}
+	shadow.RejectDuplicateKIDs = c.RejectDuplicateKIDs || c.Shadow.RejectDuplicateKIDs
+	shadow.StatsRequireKeyValidity = c.StatsRequireKeyValidity || c.Shadow.StatsRequireKeyValidity
+	shadow.StatsRequireURIAudience = c.StatsRequireURIAudience || c.Shadow.StatsRequireURIAudience
+	return &shadow
+}
+
+// statsAlgorithms returns the signing algorithms accepted on stats API tokens.
+func (c *Config) statsAlgorithms() []string {
+	if len(c.StatsAlgorithms) == 0 {
+		return []string{jwt.SigningMethodES256.Name}
+	}
+	return c.StatsAlgorithms
+}
+
+// HTTPSIssuerPolicy determines what happens to the certificates and stats API
+// tokens of health authorities whose issuer isn't an https:// URL.
+type HTTPSIssuerPolicy string
+
+const (
+	// HTTPSIssuerAllow accepts the tokens without checking the issuer.
+	HTTPSIssuerAllow HTTPSIssuerPolicy = "allow"
+	// HTTPSIssuerWarn accepts the tokens, but logs them and records a metric.
+	HTTPSIssuerWarn HTTPSIssuerPolicy = "warn"
+	// HTTPSIssuerReject rejects the tokens.
+	HTTPSIssuerReject HTTPSIssuerPolicy = "reject"
+)
+
+// Validate returns an error if the policy is unknown. The empty policy is the
+// same as HTTPSIssuerAllow.
+func (p HTTPSIssuerPolicy) Validate() error {
+	switch p {
+	case "", HTTPSIssuerAllow, HTTPSIssuerWarn, HTTPSIssuerReject:
+		return nil
+	default:
+		return fmt.Errorf("unknown https issuer policy %q, must be %q, %q or %q", p, HTTPSIssuerAllow, HTTPSIssuerWarn, HTTPSIssuerReject)
+	}
 }
@@ -0,0 +1,116 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verification
+
+import (
+	"sort"
+	"time"
+)
+
+// redacted replaces configured values that must not be exposed in a
+// VerifierConfigSnapshot.
+const redacted = "[REDACTED]"
+
+// VerifierConfigSnapshot is the effective configuration of a verifier, for
+// diagnostics. Values that must not be exposed, like file paths, are replaced
+// with "[REDACTED]" if they are set, so the snapshot only tells whether they
+// are configured.
+type VerifierConfigSnapshot struct {
+	// StatsAlgorithms are the signing algorithms accepted on stats API tokens,
+	// and X5CAlgorithms those of certificates that carry a certificate chain.
+	StatsAlgorithms []string `json:"statsAlgorithms"`
+	X5CAlgorithms   []string `json:"x5cAlgorithms"`
+
+	StatsAudience            string        `json:"statsAudience"`
+	StatsDeprecatedAudiences []string      `json:"statsDeprecatedAudiences"`
+	StatsTokenTypes          []string      `json:"statsTokenTypes"`
+	StatsKIDPattern          string        `json:"statsKIDPattern"`
+	StatsKIDMaxLength        uint          `json:"statsKIDMaxLength"`
+	StatsTokenLeeway         time.Duration `json:"statsTokenLeeway"`
+	StatsMaxTokenLifetime    time.Duration `json:"statsMaxTokenLifetime"`
+	StatsRequireKeyValidity  bool          `json:"statsRequireKeyValidity"`
+	StatsRequireURIAudience  bool          `json:"statsRequireURIAudience"`
+	RejectDuplicateKIDs      bool          `json:"rejectDuplicateKIDs"`
+	MaxActiveKeyVersions     uint          `json:"maxActiveKeyVersions"`
+	RequireHTTPSIssuers      bool          `json:"requireHTTPSIssuers"`
+	HTTPSIssuerPolicy        string        `json:"httpsIssuerPolicy"`
+
+	CacheDuration   time.Duration `json:"cacheDuration"`
+	CacheStaleGrace time.Duration `json:"cacheStaleGrace"`
+	CacheMaxSize    uint          `json:"cacheMaxSize"`
+	// CacheMaintenanceMode is the current mode, which may have been changed at
+	// runtime.
+	CacheMaintenanceMode bool `json:"cacheMaintenanceMode"`
+
+	JWKSRefreshInterval time.Duration `json:"jwksRefreshInterval"`
+	JWKSStaleGrace      time.Duration `json:"jwksStaleGrace"`
+
+	// SecondaryKeyStoreFile is redacted.
+	SecondaryKeyStoreFile string `json:"secondaryKeyStoreFile"`
+	// CustomResolver is true if health authorities are resolved with a
+	// HealthAuthorityResolver instead of the database.
+	CustomResolver bool `json:"customResolver"`
+
+	// Shadow is the snapshot of the shadow verifier, or nil if shadow
+	// verification is disabled.
+	Shadow *VerifierConfigSnapshot `json:"shadow,omitempty"`
+}
+
+// DumpConfig returns a snapshot of the configuration the verifier applies, with
+// sensitive values redacted.
+func (v *Verifier) DumpConfig() VerifierConfigSnapshot {
+	c := v.config
+	_, dbResolver := v.resolver.(*databaseResolver)
+	snapshot := VerifierConfigSnapshot{
+		StatsAlgorithms: append([]string(nil), c.statsAlgorithms()...),
+		X5CAlgorithms:   make([]string, 0, len(x5cSigningMethods)),
+
+		StatsAudience:            c.StatsAudience,
+		StatsDeprecatedAudiences: append([]string(nil), c.StatsDeprecatedAudiences...),
+		StatsTokenTypes:          append([]string(nil), c.StatsTokenTypes...),
+		StatsKIDPattern:          c.StatsKIDPattern,
+		StatsKIDMaxLength:        c.StatsKIDMaxLength,
+		StatsTokenLeeway:         c.StatsTokenLeeway,
+		StatsMaxTokenLifetime:    c.StatsMaxTokenLifetime,
+		StatsRequireKeyValidity:  c.StatsRequireKeyValidity,
+		StatsRequireURIAudience:  c.StatsRequireURIAudience,
+		RejectDuplicateKIDs:      c.RejectDuplicateKIDs,
+		MaxActiveKeyVersions:     c.MaxActiveKeyVersions,
+		RequireHTTPSIssuers:      c.RequireHTTPSIssuers,
+		HTTPSIssuerPolicy:        string(c.HTTPSIssuerPolicy),
+
+		CacheDuration:        c.CacheDuration,
+		CacheStaleGrace:      c.CacheStaleGrace,
+		CacheMaxSize:         c.CacheMaxSize,
+		CacheMaintenanceMode: v.CacheMaintenanceMode(),
+
+		JWKSRefreshInterval: c.JWKSRefreshInterval,
+		JWKSStaleGrace:      c.JWKSStaleGrace,
+
+		CustomResolver: !dbResolver,
+	}
+	for alg := range x5cSigningMethods {
+		snapshot.X5CAlgorithms = append(snapshot.X5CAlgorithms, alg)
+	}
+	sort.Strings(snapshot.X5CAlgorithms)
+	if c.SecondaryKeyStoreFile != "" {
+		snapshot.SecondaryKeyStoreFile = redacted
+	}
+	if v.shadow != nil {
+		shadow := v.shadow.DumpConfig()
+		snapshot.Shadow = &shadow
+	}
+	return snapshot
+}
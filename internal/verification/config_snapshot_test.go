@@ -0,0 +1,144 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verification
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/exposure-notifications-server/internal/verification/verifytest"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDumpConfig(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	snapshotFile := writeSnapshot(t, verifytest.NewHealthAuthority(t, "dump.test.health"))
+	verifier, err := New(nil, &Config{
+		CacheDuration:            5 * time.Minute,
+		CacheStaleGrace:          time.Minute,
+		CacheMaxSize:             1000,
+		JWKSStaleGrace:           5 * time.Minute,
+		SecondaryKeyStoreFile:    snapshotFile,
+		StatsAudience:            "keyserver",
+		StatsDeprecatedAudiences: []string{"old-keyserver"},
+		MaxActiveKeyVersions:     10,
+		RejectDuplicateKIDs:      true,
+		StatsKIDPattern:          "^[[:graph:]]+$",
+		StatsKIDMaxLength:        64,
+		StatsTokenTypes:          []string{"JWT"},
+		StatsTokenLeeway:         30 * time.Second,
+		StatsMaxTokenLifetime:    time.Hour,
+		HTTPSIssuerPolicy:        HTTPSIssuerWarn,
+		Shadow: ShadowConfig{
+			Enabled:                 true,
+			StatsAudience:           "new-keyserver",
+			StatsRequireKeyValidity: true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Changes at runtime are reflected.
+	verifier.SetCacheMaintenanceMode(ctx, true)
+
+	x5cAlgorithms := []string{"ES256", "ES384", "ES512", "PS256", "PS384", "PS512", "RS256", "RS384", "RS512"}
+	want := VerifierConfigSnapshot{
+		StatsAlgorithms:          []string{"ES256"},
+		X5CAlgorithms:            x5cAlgorithms,
+		StatsAudience:            "keyserver",
+		StatsDeprecatedAudiences: []string{"old-keyserver"},
+		StatsTokenTypes:          []string{"JWT"},
+		StatsKIDPattern:          "^[[:graph:]]+$",
+		StatsKIDMaxLength:        64,
+		StatsTokenLeeway:         30 * time.Second,
+		StatsMaxTokenLifetime:    time.Hour,
+		RejectDuplicateKIDs:      true,
+		MaxActiveKeyVersions:     10,
+		HTTPSIssuerPolicy:        "warn",
+		CacheDuration:            5 * time.Minute,
+		CacheStaleGrace:          time.Minute,
+		CacheMaxSize:             1000,
+		CacheMaintenanceMode:     true,
+		JWKSStaleGrace:           5 * time.Minute,
+		SecondaryKeyStoreFile:    "[REDACTED]",
+		Shadow: &VerifierConfigSnapshot{
+			StatsAlgorithms:          []string{"ES256"},
+			X5CAlgorithms:            x5cAlgorithms,
+			StatsAudience:            "new-keyserver",
+			StatsDeprecatedAudiences: []string{"old-keyserver"},
+			StatsTokenTypes:          []string{"JWT"},
+			StatsKIDPattern:          "^[[:graph:]]+$",
+			StatsKIDMaxLength:        64,
+			StatsTokenLeeway:         30 * time.Second,
+			StatsMaxTokenLifetime:    time.Hour,
+			StatsRequireKeyValidity:  true,
+			RejectDuplicateKIDs:      true,
+			MaxActiveKeyVersions:     10,
+			HTTPSIssuerPolicy:        "warn",
+			CacheDuration:            5 * time.Minute,
+			CacheStaleGrace:          time.Minute,
+			CacheMaxSize:             1000,
+			// The shadow verifier shares the cache.
+			CacheMaintenanceMode:  true,
+			JWKSStaleGrace:        5 * time.Minute,
+			SecondaryKeyStoreFile: "[REDACTED]",
+		},
+	}
+	got := verifier.DumpConfig()
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// The secondary key store path is never exposed.
+	b, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), snapshotFile) {
+		t.Errorf("expected snapshot to not contain the key store path: %s", b)
+	}
+
+	// Modifying the snapshot doesn't change the verifier.
+	got.StatsDeprecatedAudiences[0] = "modified"
+	if aud := verifier.DumpConfig().StatsDeprecatedAudiences[0]; aud != "old-keyserver" {
+		t.Errorf("expected the verifier config to be unchanged, got %q", aud)
+	}
+}
+
+func TestDumpConfig_Unset(t *testing.T) {
+	t.Parallel()
+
+	verifier, err := NewWithResolver(newMemoryResolver(), &Config{CacheDuration: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := verifier.DumpConfig()
+	if got.SecondaryKeyStoreFile != "" {
+		t.Errorf("expected no secondary key store, got %q", got.SecondaryKeyStoreFile)
+	}
+	if !got.CustomResolver {
+		t.Errorf("expected a custom resolver")
+	}
+	if got.Shadow != nil {
+		t.Errorf("expected no shadow snapshot, got %#v", got.Shadow)
+	}
+}
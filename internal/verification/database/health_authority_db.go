@@ -19,6 +19,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/exposure-notifications-server/internal/verification/model"
@@ -27,7 +28,17 @@ import (
 	pgx "github.com/jackc/pgx/v4"
 )
 
-var ErrHealthAuthorityNotFound = errors.New("health authority not found")
+var (
+	ErrHealthAuthorityNotFound = errors.New("health authority not found")
+
+	// ErrTooManyKeyVersions indicates that adding a key would exceed the
+	// maximum number of active key versions for a health authority.
+	ErrTooManyKeyVersions = errors.New("too many active key versions for health authority")
+
+	// ErrDuplicateKeyVersion indicates that the health authority already has a
+	// key with the same version, which is the 'kid' of its tokens.
+	ErrDuplicateKeyVersion = errors.New("health authority already has a key with this version")
+)
 
 // HealthAuthorityDB allows for opreations against authorized health authorities
 // for diagnosis signature verification.
@@ -56,11 +67,11 @@ func (db *HealthAuthorityDB) AddHealthAuthority(ctx context.Context, ha *model.H
 		row := tx.QueryRow(ctx, `
 			INSERT INTO
 				HealthAuthority
-				(iss, aud, name, jwks_uri, enable_stats)
+				(iss, aud, name, jwks_uri, enable_stats, trust_anchors, no_federate, stats_aud, default_stats_kid, stats_algorithms)
 			VALUES
-				($1, $2, $3, $4, $5)
+				($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 			RETURNING id
-			`, ha.Issuer, ha.Audience, ha.Name, ha.JwksURI, ha.EnableStatsAPI)
+			`, ha.Issuer, ha.Audience, ha.Name, ha.JwksURI, ha.EnableStatsAPI, ha.TrustAnchorsPEM, ha.NoFederate, ha.StatsAudience, ha.DefaultStatsKeyVersion, ha.StatsAlgorithms)
 		if err := row.Scan(&ha.ID); err != nil {
 			return fmt.Errorf("inserting healthauthority: %w", err)
 		}
@@ -81,10 +92,12 @@ func (db *HealthAuthorityDB) UpdateHealthAuthority(ctx context.Context, ha *mode
 		result, err := tx.Exec(ctx, `
 			UPDATE HealthAuthority
 			SET
-				iss = $1, aud = $2, name = $3, jwks_uri = $4, enable_stats = $5
+				iss = $1, aud = $2, name = $3, jwks_uri = $4, enable_stats = $5,
+				trust_anchors = $6, no_federate = $7, stats_aud = $8, default_stats_kid = $9,
+				stats_algorithms = $10
 			WHERE
-				id = $6
-			`, ha.Issuer, ha.Audience, ha.Name, ha.JwksURI, ha.EnableStatsAPI, ha.ID)
+				id = $11
+			`, ha.Issuer, ha.Audience, ha.Name, ha.JwksURI, ha.EnableStatsAPI, ha.TrustAnchorsPEM, ha.NoFederate, ha.StatsAudience, ha.DefaultStatsKeyVersion, ha.StatsAlgorithms, ha.ID)
 		if err != nil {
 			return fmt.Errorf("updating health authority: %w", err)
 		}
@@ -101,7 +114,7 @@ func (db *HealthAuthorityDB) GetHealthAuthorityByID(ctx context.Context, id int6
 	if err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
 		row := tx.QueryRow(ctx, `
 			SELECT
-				id, iss, aud, name, jwks_uri, enable_stats
+				id, iss, aud, name, jwks_uri, enable_stats, trust_anchors, no_federate, stats_aud, default_stats_kid, stats_algorithms
 			FROM
 				HealthAuthority
 			WHERE
@@ -134,7 +147,7 @@ func (db *HealthAuthorityDB) GetHealthAuthority(ctx context.Context, issuer stri
 	if err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
 		row := tx.QueryRow(ctx, `
 			SELECT
-				id, iss, aud, name, jwks_uri, enable_stats
+				id, iss, aud, name, jwks_uri, enable_stats, trust_anchors, no_federate, stats_aud, default_stats_kid, stats_algorithms
 			FROM
 				HealthAuthority
 			WHERE
@@ -171,7 +184,7 @@ func (db *HealthAuthorityDB) ListAllHealthAuthoritiesWithoutKeys(ctx context.Con
 	if err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
 		rows, err := tx.Query(ctx, `
 			SELECT
-				id, iss, aud, name, jwks_uri, enable_stats
+				id, iss, aud, name, jwks_uri, enable_stats, trust_anchors, no_federate, stats_aud, default_stats_kid, stats_algorithms
 			FROM
 				HealthAuthority
 			ORDER BY iss ASC
@@ -200,15 +213,140 @@ func (db *HealthAuthorityDB) ListAllHealthAuthoritiesWithoutKeys(ctx context.Con
 	return has, nil
 }
 
+// ListHealthAuthoritiesByAudience retrieves the health authorities whose
+// audience or stats audience is audience or, if prefix is true, starts with
+// audience. Keys are not populated. Support function for admin console.
+func (db *HealthAuthorityDB) ListHealthAuthoritiesByAudience(ctx context.Context, audience string, prefix bool) ([]*model.HealthAuthority, error) {
+	var has []*model.HealthAuthority
+
+	if err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+			SELECT
+				id, iss, aud, name, jwks_uri, enable_stats, trust_anchors, no_federate, stats_aud, default_stats_kid, stats_algorithms
+			FROM
+				HealthAuthority
+			WHERE
+				(NOT $2 AND (aud = $1 OR stats_aud = $1)) OR
+				($2 AND (aud LIKE $3 OR stats_aud LIKE $3))
+			ORDER BY iss ASC
+		`, audience, prefix, escapeLike(audience)+"%")
+		if err != nil {
+			return fmt.Errorf("failed to list: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			ha, err := scanOneHealthAuthority(rows)
+			if err != nil {
+				return fmt.Errorf("failed to parse: %w", err)
+			}
+			has = append(has, ha)
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to iterate: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("list health authorities by audience: %w", err)
+	}
+
+	return has, nil
+}
+
+// escapeLike escapes the LIKE wildcards in s, so it's matched literally.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// ListAllHealthAuthoritiesWithKeys retrieves all known health authorities in
+// the system with their keys populated. Unlike calling GetHealthAuthorityKeys
+// for each health authority, this reads everything with two queries.
+// Support function for admin console.
+func (db *HealthAuthorityDB) ListAllHealthAuthoritiesWithKeys(ctx context.Context) ([]*model.HealthAuthority, error) {
+	var has []*model.HealthAuthority
+
+	if err := db.db.InTx(ctx, pgx.RepeatableRead, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+			SELECT
+				id, iss, aud, name, jwks_uri, enable_stats, trust_anchors, no_federate, stats_aud, default_stats_kid, stats_algorithms
+			FROM
+				HealthAuthority
+			ORDER BY iss ASC
+		`)
+		if err != nil {
+			return fmt.Errorf("failed to list: %w", err)
+		}
+		defer rows.Close()
+
+		byID := make(map[int64]*model.HealthAuthority)
+		for rows.Next() {
+			ha, err := scanOneHealthAuthority(rows)
+			if err != nil {
+				return fmt.Errorf("failed to parse: %w", err)
+			}
+			has = append(has, ha)
+			byID[ha.ID] = ha
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to iterate: %w", err)
+		}
+		rows.Close()
+
+		keyRows, err := tx.Query(ctx, `
+			SELECT
+				health_authority_id, version, from_timestamp, thru_timestamp, public_key, key_usage
+			FROM
+				HealthAuthorityKey
+			ORDER BY
+				health_authority_id, version
+		`)
+		if err != nil {
+			return fmt.Errorf("failed to list keys: %w", err)
+		}
+		defer keyRows.Close()
+
+		for keyRows.Next() {
+			key, err := scanOneHealthAuthorityKey(keyRows)
+			if err != nil {
+				return fmt.Errorf("failed to parse key: %w", err)
+			}
+			if ha, ok := byID[key.AuthorityID]; ok {
+				ha.Keys = append(ha.Keys, key)
+			}
+		}
+		if err := keyRows.Err(); err != nil {
+			return fmt.Errorf("failed to iterate keys: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("list health authorities with keys: %w", err)
+	}
+
+	return has, nil
+}
+
 func scanOneHealthAuthority(row pgx.Row) (*model.HealthAuthority, error) {
 	var ha model.HealthAuthority
-	if err := row.Scan(&ha.ID, &ha.Issuer, &ha.Audience, &ha.Name, &ha.JwksURI, &ha.EnableStatsAPI); err != nil {
+	if err := row.Scan(&ha.ID, &ha.Issuer, &ha.Audience, &ha.Name, &ha.JwksURI, &ha.EnableStatsAPI, &ha.TrustAnchorsPEM, &ha.NoFederate, &ha.StatsAudience, &ha.DefaultStatsKeyVersion, &ha.StatsAlgorithms); err != nil {
 		return nil, err
 	}
 	return &ha, nil
 }
 
+// AddHealthAuthorityKey adds a new key version to a health authority, without
+// any limit on the number of active key versions.
 func (db *HealthAuthorityDB) AddHealthAuthorityKey(ctx context.Context, ha *model.HealthAuthority, hak *model.HealthAuthorityKey) error {
+	return db.AddHealthAuthorityKeyWithLimit(ctx, ha, hak, 0)
+}
+
+// AddHealthAuthorityKeyWithLimit adds a new key version to a health authority.
+// If maxActive is non-zero and the health authority already has maxActive
+// active (not revoked) key versions, ErrTooManyKeyVersions is returned. If the
+// health authority already has a key with the same version, including a
+// revoked one, ErrDuplicateKeyVersion is returned.
+func (db *HealthAuthorityDB) AddHealthAuthorityKeyWithLimit(ctx context.Context, ha *model.HealthAuthority, hak *model.HealthAuthorityKey, maxActive uint) error {
 	if ha == nil {
 		return errors.New("provided HealthAuthority cannot be nil")
 	}
@@ -226,13 +364,53 @@ func (db *HealthAuthorityDB) AddHealthAuthorityKey(ctx context.Context, ha *mode
 	hak.AuthorityID = ha.ID
 	thru := database.NullableTime(hak.Thru)
 	return db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		var exists bool
+		row := tx.QueryRow(ctx, `
+			SELECT EXISTS (
+				SELECT 1 FROM HealthAuthorityKey WHERE health_authority_id = $1 AND version = $2
+			)
+			`, ha.ID, hak.Version)
+		if err := row.Scan(&exists); err != nil {
+			return fmt.Errorf("checking healthauthoritykey version: %w", err)
+		}
+		if exists {
+			return fmt.Errorf("%w: %q", ErrDuplicateKeyVersion, hak.Version)
+		}
+
+		if maxActive > 0 && hak.IsActive() {
+			// Lock the health authority so concurrent adds can't both pass the
+			// check.
+			if _, err := tx.Exec(ctx, `
+				SELECT id FROM HealthAuthority WHERE id = $1 FOR UPDATE
+				`, ha.ID); err != nil {
+				return fmt.Errorf("locking healthauthority: %w", err)
+			}
+
+			var active uint
+			row := tx.QueryRow(ctx, `
+				SELECT
+					COUNT(*)
+				FROM
+					HealthAuthorityKey
+				WHERE
+					health_authority_id = $1 AND
+					(thru_timestamp IS NULL OR thru_timestamp > $2)
+				`, ha.ID, time.Now().UTC())
+			if err := row.Scan(&active); err != nil {
+				return fmt.Errorf("counting active healthauthoritykeys: %w", err)
+			}
+			if active >= maxActive {
+				return fmt.Errorf("%w: %d active, maximum is %d", ErrTooManyKeyVersions, active, maxActive)
+			}
+		}
+
 		result, err := tx.Exec(ctx, `
 			INSERT INTO
 				HealthAuthorityKey
-				(health_authority_id, version, from_timestamp, thru_timestamp, public_key)
+				(health_authority_id, version, from_timestamp, thru_timestamp, public_key, key_usage)
 			VALUES
-				($1, $2, $3, $4, $5)
-			`, hak.AuthorityID, hak.Version, hak.From, thru, hak.PublicKeyPEM)
+				($1, $2, $3, $4, $5, $6)
+			`, hak.AuthorityID, hak.Version, hak.From, thru, hak.PublicKeyPEM, string(hak.Usage))
 		if err != nil {
 			return fmt.Errorf("inserting healthauthoritykey: %w", err)
 		}
@@ -243,7 +421,15 @@ func (db *HealthAuthorityDB) AddHealthAuthorityKey(ctx context.Context, ha *mode
 	})
 }
 
+// PurgeHealthAuthorityKeys deletes the key versions of the health authority
+// that expired before purgeBefore, in a single transaction, and returns the
+// number of deleted versions. Keys without an expiry are never deleted. A
+// purgeBefore in the future is treated as now, so valid keys are never deleted.
 func (db *HealthAuthorityDB) PurgeHealthAuthorityKeys(ctx context.Context, ha *model.HealthAuthority, purgeBefore time.Time) (int64, error) {
+	if now := time.Now().UTC(); purgeBefore.After(now) {
+		purgeBefore = now
+	}
+
 	purgeCount := int64(0)
 	err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
 		result, err := tx.Exec(ctx, `
@@ -264,7 +450,7 @@ func (db *HealthAuthorityDB) PurgeHealthAuthorityKeys(ctx context.Context, ha *m
 }
 
 func (db *HealthAuthorityDB) UpdateHealthAuthorityKey(ctx context.Context, hak *model.HealthAuthorityKey) error {
-	if _, err := hak.PublicKey(); err != nil {
+	if err := hak.Validate(); err != nil {
 		return err
 	}
 
@@ -273,10 +459,10 @@ func (db *HealthAuthorityDB) UpdateHealthAuthorityKey(ctx context.Context, hak *
 		result, err := tx.Exec(ctx, `
 			UPDATE HealthAuthorityKey
 			SET
-				from_timestamp = $1, thru_timestamp = $2, public_key = $3
+				from_timestamp = $1, thru_timestamp = $2, public_key = $3, key_usage = $4
 			WHERE
-				health_authority_id = $4 AND version = $5
-			`, hak.From, thru, hak.PublicKeyPEM, hak.AuthorityID, hak.Version)
+				health_authority_id = $5 AND version = $6
+			`, hak.From, thru, hak.PublicKeyPEM, string(hak.Usage), hak.AuthorityID, hak.Version)
 		if err != nil {
 			return fmt.Errorf("updating health authority key: %w", err)
 		}
@@ -293,7 +479,7 @@ func (db *HealthAuthorityDB) GetHealthAuthorityKeys(ctx context.Context, ha *mod
 	if err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
 		rows, err := tx.Query(ctx, `
 			SELECT
-				health_authority_id, version, from_timestamp, thru_timestamp, public_key
+				health_authority_id, version, from_timestamp, thru_timestamp, public_key, key_usage
 			FROM
 				HealthAuthorityKey
 			WHERE
@@ -311,15 +497,11 @@ func (db *HealthAuthorityDB) GetHealthAuthorityKeys(ctx context.Context, ha *mod
 				return fmt.Errorf("failed to iterate: %w", err)
 			}
 
-			var key model.HealthAuthorityKey
-			var thru *time.Time
-			if err := rows.Scan(&key.AuthorityID, &key.Version, &key.From, &thru, &key.PublicKeyPEM); err != nil {
+			key, err := scanOneHealthAuthorityKey(rows)
+			if err != nil {
 				return fmt.Errorf("failed to parse: %w", err)
 			}
-			if thru != nil {
-				key.Thru = *thru
-			}
-			keys = append(keys, &key)
+			keys = append(keys, key)
 		}
 
 		return nil
@@ -329,3 +511,17 @@ func (db *HealthAuthorityDB) GetHealthAuthorityKeys(ctx context.Context, ha *mod
 
 	return keys, nil
 }
+
+func scanOneHealthAuthorityKey(row pgx.Row) (*model.HealthAuthorityKey, error) {
+	var key model.HealthAuthorityKey
+	var thru *time.Time
+	var usage string
+	if err := row.Scan(&key.AuthorityID, &key.Version, &key.From, &thru, &key.PublicKeyPEM, &usage); err != nil {
+		return nil, err
+	}
+	key.Usage = model.KeyUsage(usage)
+	if thru != nil {
+		key.Thru = *thru
+	}
+	return &key, nil
+}
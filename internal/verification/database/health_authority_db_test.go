@@ -15,13 +15,20 @@
 package database
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/google/exposure-notifications-server/internal/project"
 	"github.com/google/exposure-notifications-server/internal/verification/model"
+	"github.com/google/exposure-notifications-server/pkg/database"
 	"github.com/google/exposure-notifications-server/pkg/errcmp"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
 	"google.golang.org/protobuf/proto"
 
 	"github.com/google/go-cmp/cmp"
@@ -135,9 +142,22 @@ func TestAddRetrieveHealthAuthority(t *testing.T) {
 	}
 
 	want.EnableStatsAPI = true
+	want.NoFederate = true
+	want.SetStatsAudience("stats.mystate.gov")
+	want.SetDefaultStatsKeyVersion("v1")
+	want.SetStatsAlgorithms("ES256")
 	if err := haDB.UpdateHealthAuthority(ctx, want); err != nil {
 		t.Fatal(err)
 	}
+
+	got, err = haDB.GetHealthAuthorityByID(ctx, want.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("mismatch (-want, +got):\n%s", diff)
+	}
 }
 
 func TestAddRetrieveHealthAuthorityKeys(t *testing.T) {
@@ -163,6 +183,7 @@ func TestAddRetrieveHealthAuthorityKeys(t *testing.T) {
 			Version:      "v1",
 			From:         time.Now().Add(-1 * time.Minute).Truncate(time.Second),
 			PublicKeyPEM: validPEM,
+			Usage:        model.KeyUsageStats,
 		},
 	}
 
@@ -209,6 +230,211 @@ func TestAddRetrieveHealthAuthorityKeys(t *testing.T) {
 	}
 }
 
+func TestPurgeHealthAuthorityKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+
+	ha := &model.HealthAuthority{
+		Issuer:   "doh.mystate.gov",
+		Audience: "ens.usacovid.org",
+		Name:     "My State Department of Healthiness",
+	}
+	haDB := New(testDB)
+	if err := haDB.AddHealthAuthority(ctx, ha); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	keys := []*model.HealthAuthorityKey{
+		{Version: "valid", From: now.Add(-90 * 24 * time.Hour)},
+		{Version: "expired-long-ago", From: now.Add(-90 * 24 * time.Hour), Thru: now.Add(-60 * 24 * time.Hour)},
+		{Version: "expired-recently", From: now.Add(-90 * 24 * time.Hour), Thru: now.Add(-time.Hour)},
+		{Version: "expires-later", From: now.Add(-90 * 24 * time.Hour), Thru: now.Add(24 * time.Hour)},
+	}
+	for _, key := range keys {
+		key.PublicKeyPEM = validPEM
+		if err := haDB.AddHealthAuthorityKey(ctx, ha, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	versions := func(tb testing.TB) []string {
+		tb.Helper()
+
+		got, err := haDB.GetHealthAuthorityKeys(ctx, ha)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		versions := make([]string, 0, len(got))
+		for _, key := range got {
+			versions = append(versions, key.Version)
+		}
+		sort.Strings(versions)
+		return versions
+	}
+
+	count, err := haDB.PurgeHealthAuthorityKeys(ctx, ha, now.Add(-30*24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := count, int64(1); got != want {
+		t.Errorf("expected %d keys to be purged, got %d", want, got)
+	}
+	if diff := cmp.Diff([]string{"expired-recently", "expires-later", "valid"}, versions(t)); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Keys that are still valid are never purged, even if they expire before
+	// purgeBefore.
+	count, err = haDB.PurgeHealthAuthorityKeys(ctx, ha, now.Add(365*24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := count, int64(1); got != want {
+		t.Errorf("expected %d keys to be purged, got %d", want, got)
+	}
+	if diff := cmp.Diff([]string{"expires-later", "valid"}, versions(t)); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestAddHealthAuthorityKeyWithLimit(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+
+	ha := &model.HealthAuthority{
+		Issuer:   "doh.mystate.gov",
+		Audience: "ens.usacovid.org",
+		Name:     "My State Department of Healthiness",
+	}
+
+	haDB := New(testDB)
+	if err := haDB.AddHealthAuthority(ctx, ha); err != nil {
+		t.Fatal(err)
+	}
+
+	newKey := func(version string) *model.HealthAuthorityKey {
+		return &model.HealthAuthorityKey{
+			Version:      version,
+			From:         time.Now().Add(-1 * time.Minute).Truncate(time.Second),
+			PublicKeyPEM: validPEM,
+		}
+	}
+
+	const limit = 2
+
+	v1 := newKey("v1")
+	if err := haDB.AddHealthAuthorityKeyWithLimit(ctx, ha, v1, limit); err != nil {
+		t.Fatal(err)
+	}
+	if err := haDB.AddHealthAuthorityKeyWithLimit(ctx, ha, newKey("v2"), limit); err != nil {
+		t.Fatal(err)
+	}
+
+	// Adding a key beyond the limit is rejected.
+	if err := haDB.AddHealthAuthorityKeyWithLimit(ctx, ha, newKey("v3"), limit); !errors.Is(err, ErrTooManyKeyVersions) {
+		t.Fatalf("expected %v, got %v", ErrTooManyKeyVersions, err)
+	}
+
+	// Keys that are already revoked don't count against the limit.
+	revoked := newKey("v0")
+	revoked.Revoke()
+	if err := haDB.AddHealthAuthorityKeyWithLimit(ctx, ha, revoked, limit); err != nil {
+		t.Fatal(err)
+	}
+
+	// Revoking a key frees up space for a new version.
+	v1.Revoke()
+	if err := haDB.UpdateHealthAuthorityKey(ctx, v1); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := haDB.AddHealthAuthorityKeyWithLimit(ctx, ha, newKey("v3"), limit); err != nil {
+		t.Fatal(err)
+	}
+
+	// A limit of zero means no limit.
+	if err := haDB.AddHealthAuthorityKeyWithLimit(ctx, ha, newKey("v4"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := haDB.GetHealthAuthorityKeys(ctx, ha)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(got), 5; got != want {
+		t.Errorf("expected %d keys, got %d", want, got)
+	}
+}
+
+func TestAddHealthAuthorityKey_DuplicateVersion(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+
+	ha := &model.HealthAuthority{
+		Issuer:   "doh.mystate.gov",
+		Audience: "ens.usacovid.org",
+		Name:     "My State Department of Healthiness",
+	}
+
+	haDB := New(testDB)
+	if err := haDB.AddHealthAuthority(ctx, ha); err != nil {
+		t.Fatal(err)
+	}
+
+	newKey := func(version string) *model.HealthAuthorityKey {
+		return &model.HealthAuthorityKey{
+			Version:      version,
+			From:         time.Now().Add(-1 * time.Minute).Truncate(time.Second),
+			PublicKeyPEM: validPEM,
+		}
+	}
+
+	v1 := newKey("v1")
+	if err := haDB.AddHealthAuthorityKey(ctx, ha, v1); err != nil {
+		t.Fatal(err)
+	}
+	if err := haDB.AddHealthAuthorityKey(ctx, ha, newKey("v1")); !errors.Is(err, ErrDuplicateKeyVersion) {
+		t.Fatalf("expected %v, got %v", ErrDuplicateKeyVersion, err)
+	}
+
+	// Revoked versions can't be reused either.
+	v1.Revoke()
+	if err := haDB.UpdateHealthAuthorityKey(ctx, v1); err != nil {
+		t.Fatal(err)
+	}
+	if err := haDB.AddHealthAuthorityKeyWithLimit(ctx, ha, newKey("v1"), 10); !errors.Is(err, ErrDuplicateKeyVersion) {
+		t.Fatalf("expected %v, got %v", ErrDuplicateKeyVersion, err)
+	}
+
+	// The same version on another health authority is fine.
+	other := &model.HealthAuthority{
+		Issuer:   "doh.otherstate.gov",
+		Audience: "ens.usacovid.org",
+		Name:     "Other State Department of Healthiness",
+	}
+	if err := haDB.AddHealthAuthority(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	if err := haDB.AddHealthAuthorityKey(ctx, other, newKey("v1")); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := haDB.GetHealthAuthorityKeys(ctx, ha)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(got), 1; got != want {
+		t.Errorf("expected %d keys, got %d", want, got)
+	}
+}
+
 func TestListAllHealthAuthoritiesWithoutKeys(t *testing.T) {
 	t.Parallel()
 
@@ -247,3 +473,229 @@ func TestListAllHealthAuthoritiesWithoutKeys(t *testing.T) {
 		t.Fatalf("mismatch (-want, +got):\n%s", diff)
 	}
 }
+
+func TestListHealthAuthoritiesByAudience(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	haDB := New(testDB)
+
+	statsAudience := func(ha *model.HealthAuthority, aud string) *model.HealthAuthority {
+		ha.SetStatsAudience(aud)
+		return ha
+	}
+
+	for _, ha := range []*model.HealthAuthority{
+		{Issuer: "a.doh.mystate.gov", Audience: "ens.usacovid.org", Name: "A"},
+		{Issuer: "b.doh.mystate.gov", Audience: "ens.usacovid.org", Name: "B"},
+		{Issuer: "c.doh.mystate.gov", Audience: "ens.usacovid.org/stats", Name: "C"},
+		{Issuer: "d.doh.mystate.gov", Audience: "ens_usacovid.org", Name: "D"},
+		{Issuer: "e.doh.mystate.gov", Audience: "other.ens.usacovid.org", Name: "E"},
+		{Issuer: "f.doh.mystate.gov", Audience: "ens%", Name: "F"},
+		statsAudience(&model.HealthAuthority{Issuer: "g.doh.mystate.gov", Audience: "other.aud", Name: "G"}, "ens.usacovid.org"),
+		statsAudience(&model.HealthAuthority{Issuer: "h.doh.mystate.gov", Audience: "other.aud", Name: "H"}, "stats.ens.usacovid.org"),
+	} {
+		if err := haDB.AddHealthAuthority(ctx, ha); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name     string
+		audience string
+		prefix   bool
+		want     []string
+	}{
+		{
+			name:     "exact",
+			audience: "ens.usacovid.org",
+			want:     []string{"a.doh.mystate.gov", "b.doh.mystate.gov", "g.doh.mystate.gov"},
+		},
+		{
+			name:     "exact_stats_audience",
+			audience: "stats.ens.usacovid.org",
+			want:     []string{"h.doh.mystate.gov"},
+		},
+		{
+			name:     "exact_no_match",
+			audience: "ens.usacovid",
+		},
+		{
+			name:     "prefix",
+			audience: "ens.usacovid",
+			prefix:   true,
+			want:     []string{"a.doh.mystate.gov", "b.doh.mystate.gov", "c.doh.mystate.gov", "g.doh.mystate.gov"},
+		},
+		{
+			name:     "prefix_stats_audience",
+			audience: "stats.",
+			prefix:   true,
+			want:     []string{"h.doh.mystate.gov"},
+		},
+		{
+			name:     "prefix_underscore_is_literal",
+			audience: "ens_",
+			prefix:   true,
+			want:     []string{"d.doh.mystate.gov"},
+		},
+		{
+			name:     "prefix_percent_is_literal",
+			audience: "ens%",
+			prefix:   true,
+			want:     []string{"f.doh.mystate.gov"},
+		},
+		{
+			name:     "prefix_no_match",
+			audience: "usacovid",
+			prefix:   true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			has, err := haDB.ListHealthAuthoritiesByAudience(ctx, tc.audience, tc.prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, ha := range has {
+				got = append(got, ha.Issuer)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	t.Parallel()
+
+	if got, want := escapeLike(`ens_100%\aud`), `ens\_100\%\\aud`; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+}
+
+// queryCounter is a pgx logger that counts the queries that are run.
+type queryCounter struct {
+	count int64
+}
+
+func (q *queryCounter) Log(_ context.Context, _ pgx.LogLevel, msg string, _ map[string]interface{}) {
+	if msg == "Query" {
+		atomic.AddInt64(&q.count, 1)
+	}
+}
+
+func (q *queryCounter) reset() {
+	atomic.StoreInt64(&q.count, 0)
+}
+
+func (q *queryCounter) get() int64 {
+	return atomic.LoadInt64(&q.count)
+}
+
+func TestListAllHealthAuthoritiesWithKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+
+	// Open a second pool to the same database that counts queries.
+	pgxConfig, err := pgxpool.ParseConfig(testDB.Pool.Config().ConnString())
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter := &queryCounter{}
+	pgxConfig.ConnConfig.Logger = counter
+	pgxConfig.ConnConfig.LogLevel = pgx.LogLevelInfo
+	pool, err := pgxpool.ConnectConfig(ctx, pgxConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+
+	haDB := New(&database.DB{Pool: pool})
+
+	const numAuthorities = 10
+	from := time.Now().Add(-1 * time.Minute).Truncate(time.Second)
+
+	want := make([]*model.HealthAuthority, 0, numAuthorities+1)
+	for i := 0; i < numAuthorities; i++ {
+		ha := &model.HealthAuthority{
+			Issuer:   fmt.Sprintf("doh%02d.mystate.gov", i),
+			Audience: "ens.usacovid.org",
+			Name:     fmt.Sprintf("My State Department of Healthiness %d", i),
+		}
+		if err := haDB.AddHealthAuthority(ctx, ha); err != nil {
+			t.Fatal(err)
+		}
+
+		for j := 0; j <= i%3; j++ {
+			key := &model.HealthAuthorityKey{
+				Version:      fmt.Sprintf("v%d", j+1),
+				From:         from,
+				PublicKeyPEM: validPEM,
+			}
+			if err := haDB.AddHealthAuthorityKey(ctx, ha, key); err != nil {
+				t.Fatal(err)
+			}
+			ha.Keys = append(ha.Keys, key)
+		}
+		want = append(want, ha)
+	}
+
+	// An authority without keys is still listed.
+	noKeys := &model.HealthAuthority{
+		Issuer:   "nokeys.mystate.gov",
+		Audience: "ens.usacovid.org",
+		Name:     "No Keys Department of Health",
+	}
+	if err := haDB.AddHealthAuthority(ctx, noKeys); err != nil {
+		t.Fatal(err)
+	}
+	want = append(want, noKeys)
+
+	counter.reset()
+	got, err := haDB.ListAllHealthAuthoritiesWithKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batchedQueries := counter.get()
+
+	if diff := cmp.Diff(want, got, cmpopts.EquateApproxTime(time.Second)); diff != "" {
+		t.Fatalf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Do the same thing one authority at a time.
+	counter.reset()
+	has, err := haDB.ListAllHealthAuthoritiesWithoutKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ha := range has {
+		keys, err := haDB.GetHealthAuthorityKeys(ctx, ha)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ha.Keys = keys
+	}
+	perAuthorityQueries := counter.get()
+
+	if diff := cmp.Diff(has, got, cmpopts.EquateApproxTime(time.Second)); diff != "" {
+		t.Fatalf("per-authority mismatch (-want, +got):\n%s", diff)
+	}
+
+	if got, want := batchedQueries, int64(2); got != want {
+		t.Errorf("expected batched fetch to use %d queries, got %d", want, got)
+	}
+	if got, want := perAuthorityQueries, int64(len(has)+1); got != want {
+		t.Errorf("expected per-authority fetch to use %d queries, got %d", want, got)
+	}
+}
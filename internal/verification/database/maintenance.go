@@ -0,0 +1,73 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// cacheMaintenanceModeFlag is the RuntimeFlag that enables the verification
+// cache maintenance mode of all servers.
+const cacheMaintenanceModeFlag = "verification_cache_maintenance_mode"
+
+// CacheMaintenanceMode returns true if the verification cache maintenance mode
+// is enabled, and when it was last changed. It is disabled, with a zero time,
+// if it has never been set.
+func (db *HealthAuthorityDB) CacheMaintenanceMode(ctx context.Context) (bool, time.Time, error) {
+	var enabled bool
+	var updatedAt time.Time
+
+	if err := db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		row := tx.QueryRow(ctx, `
+			SELECT
+				enabled, updated_at
+			FROM
+				RuntimeFlag
+			WHERE
+				name = $1
+		`, cacheMaintenanceModeFlag)
+		return row.Scan(&enabled, &updatedAt)
+	}); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, time.Time{}, nil
+		}
+		return false, time.Time{}, fmt.Errorf("get cache maintenance mode: %w", err)
+	}
+	return enabled, updatedAt, nil
+}
+
+// SetCacheMaintenanceMode enables or disables the verification cache
+// maintenance mode. Servers pick up the change the next time they refresh it.
+func (db *HealthAuthorityDB) SetCacheMaintenanceMode(ctx context.Context, enabled bool, now time.Time) error {
+	return db.db.InTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO
+				RuntimeFlag
+				(name, enabled, updated_at)
+			VALUES
+				($1, $2, $3)
+			ON CONFLICT (name) DO UPDATE
+				SET enabled = EXCLUDED.enabled, updated_at = EXCLUDED.updated_at
+		`, cacheMaintenanceModeFlag, enabled, now); err != nil {
+			return fmt.Errorf("set cache maintenance mode: %w", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,58 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/project"
+)
+
+func TestCacheMaintenanceMode(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	haDB := New(testDB)
+
+	// Never set.
+	enabled, updatedAt, err := haDB.CacheMaintenanceMode(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enabled || !updatedAt.IsZero() {
+		t.Errorf("expected maintenance mode to be disabled, got %t at %v", enabled, updatedAt)
+	}
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	for i, want := range []bool{true, false} {
+		at := now.Add(time.Duration(i) * time.Minute)
+		if err := haDB.SetCacheMaintenanceMode(ctx, want, at); err != nil {
+			t.Fatal(err)
+		}
+
+		enabled, updatedAt, err := haDB.CacheMaintenanceMode(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if enabled != want {
+			t.Errorf("expected maintenance mode %t to be %t", enabled, want)
+		}
+		if !updatedAt.Equal(at) {
+			t.Errorf("expected updated at %v to be %v", updatedAt, at)
+		}
+	}
+}
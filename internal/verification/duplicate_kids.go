@@ -0,0 +1,62 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrDuplicateKIDsOnStartup is returned by CheckDuplicateKIDs in strict mode
+// if a health authority has more than one key for the same version.
+var ErrDuplicateKIDsOnStartup = errors.New("health authorities have duplicate key versions")
+
+// CheckDuplicateKIDs scans the keys of all health authorities for duplicate
+// key versions, if CheckDuplicateKIDsOnStartup is set. Each health authority
+// with duplicates is logged and recorded like when it is loaded. If
+// StrictDuplicateKIDs is set, an error naming them is returned.
+func (v *Verifier) CheckDuplicateKIDs(ctx context.Context) error {
+	if !v.config.CheckDuplicateKIDsOnStartup {
+		return nil
+	}
+	if v.db == nil {
+		return fmt.Errorf("checking duplicate key versions requires a database")
+	}
+	return v.checkDuplicateKIDs(ctx, v.db.ListAllHealthAuthoritiesWithKeys)
+}
+
+func (v *Verifier) checkDuplicateKIDs(ctx context.Context, list listHealthAuthoritiesFn) error {
+	has, err := list(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list health authorities: %w", err)
+	}
+
+	var found []string
+	for _, ha := range has {
+		duplicates := ha.DuplicateKeyVersions()
+		if len(duplicates) == 0 {
+			continue
+		}
+		v.checkDuplicateKeyVersions(ctx, ha)
+		found = append(found, fmt.Sprintf("%s (%s)", ha.Issuer, strings.Join(duplicates, ", ")))
+	}
+
+	if len(found) > 0 && v.config.StrictDuplicateKIDs {
+		return fmt.Errorf("%w: %s", ErrDuplicateKIDsOnStartup, strings.Join(found, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verification
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/exposure-notifications-server/internal/verification/model"
+	"github.com/google/exposure-notifications-server/pkg/errcmp"
+	"github.com/google/exposure-notifications-server/pkg/logging"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestCheckDuplicateKIDs(t *testing.T) {
+	t.Parallel()
+
+	has := []*model.HealthAuthority{
+		{
+			Issuer: "clean.test.health",
+			Keys: []*model.HealthAuthorityKey{
+				{Version: "v1"}, {Version: "v2"},
+			},
+		},
+		{
+			Issuer: "legacy.test.health",
+			Keys: []*model.HealthAuthorityKey{
+				{Version: "v1"}, {Version: "v2"}, {Version: "v1"}, {Version: "v2"},
+			},
+		},
+		{
+			Issuer: "other.test.health",
+			Keys: []*model.HealthAuthorityKey{
+				{Version: "v7"}, {Version: "v7"},
+			},
+		},
+	}
+
+	cases := []struct {
+		name     string
+		disabled bool
+		strict   bool
+		has      []*model.HealthAuthority
+		err      string
+		warnings int
+	}{
+		{
+			name:     "warn",
+			has:      has,
+			warnings: 2,
+		},
+		{
+			name:     "strict",
+			strict:   true,
+			has:      has,
+			err:      "health authorities have duplicate key versions: legacy.test.health (v1, v2); other.test.health (v7)",
+			warnings: 2,
+		},
+		{
+			name:   "strict_no_duplicates",
+			strict: true,
+			has:    has[:1],
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			core, logs := observer.New(zap.InfoLevel)
+			ctx := logging.WithLogger(project.TestContext(t), zap.New(core).Sugar())
+
+			verifier, err := New(nil, &Config{
+				CacheDuration:               time.Hour,
+				CheckDuplicateKIDsOnStartup: true,
+				StrictDuplicateKIDs:         tc.strict,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = verifier.checkDuplicateKIDs(ctx, func(context.Context) ([]*model.HealthAuthority, error) {
+				return tc.has, nil
+			})
+			errcmp.MustMatch(t, err, tc.err)
+			if tc.err != "" && !errors.Is(err, ErrDuplicateKIDsOnStartup) {
+				t.Errorf("expected %v to wrap %v", err, ErrDuplicateKIDsOnStartup)
+			}
+			if got, want := logs.FilterMessage("health authority has duplicate key versions").Len(), tc.warnings; got != want {
+				t.Errorf("expected %d warnings for duplicate key versions, got %d", want, got)
+			}
+		})
+	}
+}
+
+func TestCheckDuplicateKIDs_Disabled(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	// The database isn't needed when the check is disabled.
+	verifier, err := New(nil, &Config{CacheDuration: time.Hour, StrictDuplicateKIDs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.CheckDuplicateKIDs(ctx); err != nil {
+		t.Errorf("expected no error when disabled, got %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verification
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/verification/model"
+)
+
+// HAEffectiveConfig is the configuration the verifier applies to the tokens of
+// a health authority, combining the health authority with the server config.
+type HAEffectiveConfig struct {
+	ID     int64
+	Issuer string
+	Name   string
+
+	// CertificateAudience is the audience of verification certificates.
+	CertificateAudience string
+	// StatsAudiences are the accepted audiences of stats API tokens, the
+	// global audience followed by the health authority's audience, if any.
+	StatsAudiences []string
+	// DeprecatedStatsAudiences are also accepted on stats API tokens, but are
+	// counted as deprecated.
+	DeprecatedStatsAudiences []string
+	// StatsAPIEnabled is false if all stats API tokens are rejected.
+	StatsAPIEnabled bool
+	// DefaultStatsKeyVersion is the key version of stats API tokens without a
+	// 'kid', or empty if those tokens are rejected.
+	DefaultStatsKeyVersion string
+	// StatsAlgorithms are the signing algorithms accepted on stats API tokens,
+	// those allowed by both the server and the health authority.
+	StatsAlgorithms []string
+
+	// JWKSURI is the URI keys are discovered from, or empty.
+	JWKSURI string
+	// X5CEnabled is true if certificates may carry a certificate chain.
+	X5CEnabled bool
+
+	// ActiveKeyVersions are the key versions that are valid now.
+	ActiveKeyVersions []string
+	// DuplicateKeyVersions are the key versions with more than one key.
+	DuplicateKeyVersions []string
+	// Keys are all keys of the health authority, including future and revoked
+	// keys.
+	Keys []*HAEffectiveKey
+}
+
+// HAEffectiveKey is a key version of a health authority and whether the
+// verifier accepts it.
+type HAEffectiveKey struct {
+	Version string
+	From    time.Time
+	Thru    time.Time
+	Usage   model.KeyUsage
+
+	// Valid is true if the key is valid now.
+	Valid bool
+	// Certificates and Stats are true if the key may verify that kind of
+	// token, when it is valid.
+	Certificates bool
+	Stats        bool
+}
+
+// EffectiveConfig returns the configuration the verifier applies to the tokens
+// of the issuer. The health authority is looked up like it is when verifying a
+// token, so a cached health authority is returned as it was cached.
+func (v *Verifier) EffectiveConfig(ctx context.Context, issuer string) (*HAEffectiveConfig, error) {
+	ha, err := v.lookupHealthAuthority(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+	return v.effectiveConfig(ha, v.clock.Now()), nil
+}
+
+func (v *Verifier) effectiveConfig(ha *model.HealthAuthority, now time.Time) *HAEffectiveConfig {
+	cfg := &HAEffectiveConfig{
+		ID:                   ha.ID,
+		Issuer:               ha.Issuer,
+		Name:                 ha.Name,
+		CertificateAudience:  ha.Audience,
+		StatsAPIEnabled:      ha.EnableStatsAPI,
+		X5CEnabled:           ha.X5CEnabled(),
+		DuplicateKeyVersions: ha.DuplicateKeyVersions(),
+		Keys:                 make([]*HAEffectiveKey, 0, len(ha.Keys)),
+	}
+
+	if v.config.StatsAudience != "" {
+		cfg.StatsAudiences = append(cfg.StatsAudiences, v.config.StatsAudience)
+	}
+	if ha.StatsAudience != nil && *ha.StatsAudience != v.config.StatsAudience {
+		cfg.StatsAudiences = append(cfg.StatsAudiences, *ha.StatsAudience)
+	}
+	for _, aud := range v.config.StatsDeprecatedAudiences {
+		if aud != "" {
+			cfg.DeprecatedStatsAudiences = append(cfg.DeprecatedStatsAudiences, aud)
+		}
+	}
+	if ha.DefaultStatsKeyVersion != nil {
+		cfg.DefaultStatsKeyVersion = *ha.DefaultStatsKeyVersion
+	}
+	for _, alg := range v.config.statsAlgorithms() {
+		if ha.AllowsStatsAlgorithm(alg) {
+			cfg.StatsAlgorithms = append(cfg.StatsAlgorithms, alg)
+		}
+	}
+	if ha.JWKSEnabled() {
+		cfg.JWKSURI = *ha.JwksURI
+	}
+
+	for _, k := range ha.Keys {
+		key := &HAEffectiveKey{
+			Version:      k.Version,
+			From:         k.From,
+			Thru:         k.Thru,
+			Usage:        k.Usage,
+			Valid:        k.IsValidAt(now),
+			Certificates: k.AllowsUsage(model.KeyUsageCertificate),
+			Stats:        k.AllowsUsage(model.KeyUsageStats),
+		}
+		if key.Valid {
+			cfg.ActiveKeyVersions = append(cfg.ActiveKeyVersions, k.Version)
+		}
+		cfg.Keys = append(cfg.Keys, key)
+	}
+	return cfg
+}
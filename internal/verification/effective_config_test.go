@@ -0,0 +1,124 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verification
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/exposure-notifications-server/internal/verification/model"
+	"github.com/google/exposure-notifications-server/internal/verification/verifytest"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEffectiveConfig(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	verifier, err := New(nil, &Config{
+		CacheDuration:            time.Hour,
+		StatsAudience:            "keyserver",
+		StatsDeprecatedAudiences: []string{"old-keyserver"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+
+	test := verifytest.NewHealthAuthority(t, "effective.test.health")
+	ha := *test.HealthAuthority
+	ha.ID = 7
+	ha.SetStatsAudience("ha-stats")
+	ha.SetDefaultStatsKeyVersion("v1")
+	ha.SetJWKS("https://effective.test.health/.well-known/jwks.json")
+	ha.Keys = []*model.HealthAuthorityKey{
+		{Version: "v1", From: now.Add(-time.Hour), PublicKeyPEM: test.Key.PublicKeyPEM},
+		{Version: "v2", From: now.Add(-time.Hour), PublicKeyPEM: test.Key.PublicKeyPEM, Usage: model.KeyUsageStats},
+		{Version: "v0", From: now.Add(-2 * time.Hour), Thru: now.Add(-time.Hour), PublicKeyPEM: test.Key.PublicKeyPEM},
+		{Version: "v3", From: now.Add(time.Hour), PublicKeyPEM: test.Key.PublicKeyPEM, Usage: model.KeyUsageCertificate},
+	}
+	if err := verifier.CacheHealthAuthority(&ha); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := verifier.EffectiveConfig(ctx, ha.Issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &HAEffectiveConfig{
+		ID:                       7,
+		Issuer:                   "effective.test.health",
+		Name:                     ha.Name,
+		CertificateAudience:      "aud-effective.test.health",
+		StatsAudiences:           []string{"keyserver", "ha-stats"},
+		DeprecatedStatsAudiences: []string{"old-keyserver"},
+		StatsAPIEnabled:          true,
+		DefaultStatsKeyVersion:   "v1",
+		StatsAlgorithms:          []string{"ES256"},
+		JWKSURI:                  "https://effective.test.health/.well-known/jwks.json",
+		ActiveKeyVersions:        []string{"v1", "v2"},
+		Keys: []*HAEffectiveKey{
+			{Version: "v1", From: now.Add(-time.Hour), Valid: true, Certificates: true, Stats: true},
+			{Version: "v2", From: now.Add(-time.Hour), Usage: model.KeyUsageStats, Valid: true, Stats: true},
+			{Version: "v0", From: now.Add(-2 * time.Hour), Thru: now.Add(-time.Hour), Certificates: true, Stats: true},
+			{Version: "v3", From: now.Add(time.Hour), Usage: model.KeyUsageCertificate, Certificates: true},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestEffectiveConfig_Defaults(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	verifier, err := New(nil, &Config{
+		CacheDuration: time.Hour,
+		StatsAudience: "keyserver",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test := verifytest.NewHealthAuthority(t, "defaults.test.health")
+	test.HealthAuthority.EnableStatsAPI = false
+	// An override that matches the global audience is only listed once.
+	test.HealthAuthority.SetStatsAudience("keyserver")
+	test.Cache(t, verifier)
+
+	got, err := verifier.EffectiveConfig(ctx, test.HealthAuthority.Issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.StatsAPIEnabled {
+		t.Errorf("expected stats API to be disabled")
+	}
+	if diff := cmp.Diff([]string{"keyserver"}, got.StatsAudiences); diff != "" {
+		t.Errorf("stats audiences mismatch (-want, +got):\n%s", diff)
+	}
+	if got.JWKSURI != "" || got.DefaultStatsKeyVersion != "" || got.X5CEnabled {
+		t.Errorf("expected no JWKS URI, default key version or x5c, got %#v", got)
+	}
+	if diff := cmp.Diff([]string{verifytest.DefaultKeyVersion}, got.ActiveKeyVersions); diff != "" {
+		t.Errorf("active key versions mismatch (-want, +got):\n%s", diff)
+	}
+}
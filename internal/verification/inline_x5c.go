@@ -0,0 +1,148 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verification
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/exposure-notifications-server/pkg/logging"
+)
+
+// ErrInlineX5CNotEnabled indicates a stats token was presented with an inline
+// certificate chain, but STATS_INLINE_X5C_TRUST_ANCHORS_FILE isn't set.
+var ErrInlineX5CNotEnabled = errors.New("inline certificate chains are not enabled")
+
+// loadTrustAnchors reads the bundle of PEM encoded CA certificates at path.
+func loadTrustAnchors(path string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trust anchors: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, errors.New("no valid certificates in trust anchors")
+	}
+	return pool, nil
+}
+
+// InlineX5CEnabled returns true if stats API tokens of issuers that aren't
+// registered are accepted with an inline certificate chain.
+func (v *Verifier) InlineX5CEnabled() bool {
+	return v.inlineX5CRoots != nil
+}
+
+// IsInlineX5CToken returns true if the stats API token has an x5c header. The
+// token isn't verified.
+func IsInlineX5CToken(rawToken string) bool {
+	token, _, err := new(jwt.Parser).ParseUnverified(rawToken, &StatsClaims{})
+	return err == nil && hasX5C(token)
+}
+
+// AuthenticateInlineX5CStatsToken authenticates a stats API token signed by
+// the leaf of the certificate chain in its x5c header, which must validate
+// against StatsInlineX5CTrustAnchorsFile. The issuer doesn't need to be
+// registered, it is the common name of the leaf certificate. The token's 'iss'
+// claim may be omitted, but if set it must match. Otherwise, the token is
+// checked like registered stats tokens, and the returned details don't have a
+// HealthAuthorityID.
+func (v *Verifier) AuthenticateInlineX5CStatsToken(ctx context.Context, rawToken string) (*StatsTokenDetails, error) {
+	if v.inlineX5CRoots == nil {
+		return nil, ErrInlineX5CNotEnabled
+	}
+
+	var leaf *x509.Certificate
+	var claims *StatsClaims
+
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.ParseWithClaims(rawToken, &StatsClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, err := v.validateStatsSigningMethod(token); err != nil {
+			return nil, err
+		}
+		if err := v.validateTokenType(token); err != nil {
+			return nil, err
+		}
+		if !hasX5C(token) {
+			return nil, fmt.Errorf("missing %q header in token", x5cHeader)
+		}
+
+		var ok bool
+		claims, ok = token.Claims.(*StatsClaims)
+		if !ok {
+			return nil, fmt.Errorf("token does not contain expected claim set")
+		}
+
+		var err error
+		leaf, err = verifyX5C(token, v.inlineX5CRoots, v.clock.Now())
+		if err != nil {
+			return nil, err
+		}
+		return leaf.PublicKey, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unauthorized: %w", err)
+	}
+
+	if !token.Valid {
+		return nil, fmt.Errorf("authentication token invalid")
+	}
+
+	issuer := leaf.Subject.CommonName
+	if issuer == "" {
+		return nil, fmt.Errorf("unauthorized, certificate subject has no common name")
+	}
+	if claims.Issuer != "" && claims.Issuer != issuer {
+		return nil, fmt.Errorf("unauthorized, issuer %q does not match certificate subject %q", claims.Issuer, issuer)
+	}
+	claims.Issuer = issuer
+
+	if err := v.validateStatsClaims(claims, v.clock.Now()); err != nil {
+		return nil, fmt.Errorf("unauthorized: %w", err)
+	}
+
+	if v.config.StatsRequireURIAudience && !isAbsoluteURI(claims.Audience) {
+		return nil, fmt.Errorf("unauthorized, audience %q is not an absolute URI", claims.Audience)
+	}
+	if !claims.VerifyAudience(v.config.StatsAudience, true) && !v.acceptDeprecatedAudience(ctx, claims) {
+		return nil, fmt.Errorf("unauthorized, audience mismatch")
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(leaf.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fingerprint key: %w", err)
+	}
+	sum := sha256.Sum256(der)
+
+	details := &StatsTokenDetails{
+		Issuer:      issuer,
+		Fingerprint: hex.EncodeToString(sum[:]),
+		From:        leaf.NotBefore,
+		Thru:        leaf.NotAfter,
+	}
+	if scopes := strings.Fields(claims.Scope); len(scopes) > 0 {
+		details.Scopes = scopes
+	}
+
+	logging.FromContext(ctx).Infow("accepted stats token with inline certificate chain",
+		"iss", issuer, "fingerprint", details.Fingerprint)
+	return details, nil
+}
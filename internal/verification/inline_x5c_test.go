@@ -0,0 +1,219 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verification
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/exposure-notifications-server/pkg/errcmp"
+	"github.com/google/go-cmp/cmp"
+)
+
+// writeTrustAnchors writes the certificates as a PEM bundle and returns its
+// path.
+func writeTrustAnchors(t *testing.T, certs ...*testCert) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "anchors.pem")
+	if err := os.WriteFile(path, []byte(certPEM(certs...)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAuthenticateInlineX5CStatsToken(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	statsAudience := "test-stats-aud"
+	issuer := "https://partner.test.health"
+
+	root, intermediate := newTestCA(t, "Test")
+	otherRoot, otherIntermediate := newTestCA(t, "Other")
+
+	key := testECDSAKey(t)
+	issueLeaf := func(ca *testCert, commonName string) *testCert {
+		return issueTestCert(t, &x509.Certificate{
+			Subject:  pkix.Name{CommonName: commonName},
+			KeyUsage: x509.KeyUsageDigitalSignature,
+		}, key, ca)
+	}
+	leaf := issueLeaf(intermediate, issuer)
+	otherLeaf := issueLeaf(otherIntermediate, issuer)
+	noNameLeaf := issueLeaf(intermediate, "")
+
+	signToken := func(t *testing.T, iss string, chain []string) string {
+		t.Helper()
+
+		now := time.Now()
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, &StatsClaims{
+			StandardClaims: jwt.StandardClaims{
+				Audience:  statsAudience,
+				Issuer:    iss,
+				IssuedAt:  now.Unix(),
+				ExpiresAt: now.Add(5 * time.Minute).Unix(),
+			},
+			Scope: "stats:read",
+		})
+		if chain != nil {
+			token.Header[x5cHeader] = chain
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	verifier, err := NewWithResolver(newMemoryResolver(), &Config{
+		StatsAudience:                  statsAudience,
+		StatsInlineX5CTrustAnchorsFile: writeTrustAnchors(t, root),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name  string
+		token string
+		err   string
+	}{
+		{
+			name:  "trusted_anchor",
+			token: signToken(t, issuer, x5cChain(leaf, intermediate)),
+		},
+		{
+			name:  "no_issuer_claim",
+			token: signToken(t, "", x5cChain(leaf, intermediate)),
+		},
+		{
+			name:  "untrusted_anchor",
+			token: signToken(t, issuer, x5cChain(otherLeaf, otherIntermediate)),
+			err:   "invalid certificate chain",
+		},
+		{
+			name:  "unrelated_trusted_intermediate",
+			token: signToken(t, issuer, x5cChain(otherLeaf, intermediate, otherRoot)),
+			err:   "invalid certificate chain",
+		},
+		{
+			name:  "issuer_mismatch",
+			token: signToken(t, "https://other.test.health", x5cChain(leaf, intermediate)),
+			err:   "does not match certificate subject",
+		},
+		{
+			name:  "no_common_name",
+			token: signToken(t, "", x5cChain(noNameLeaf, intermediate)),
+			err:   "certificate subject has no common name",
+		},
+		{
+			name:  "no_chain",
+			token: signToken(t, issuer, nil),
+			err:   `missing "x5c" header`,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			details, err := verifier.AuthenticateInlineX5CStatsToken(ctx, tc.token)
+			errcmp.MustMatch(t, err, tc.err)
+			if err != nil {
+				return
+			}
+
+			if details.HealthAuthorityID != 0 {
+				t.Errorf("expected no health authority ID, got %d", details.HealthAuthorityID)
+			}
+			if got, want := details.Issuer, issuer; got != want {
+				t.Errorf("expected issuer %q from the certificate subject, got %q", want, got)
+			}
+			if diff := cmp.Diff([]string{"stats:read"}, details.Scopes); diff != "" {
+				t.Errorf("scopes mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+
+	// The registered key path ignores the anchors, the issuer isn't registered.
+	_, err = verifier.AuthenticateStatsToken(ctx, signToken(t, issuer, x5cChain(leaf, intermediate)))
+	errcmp.MustMatch(t, err, "not found")
+}
+
+func TestAuthenticateInlineX5CStatsToken_NotEnabled(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	verifier, err := New(nil, &Config{StatsAudience: "test-stats-aud"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verifier.InlineX5CEnabled() {
+		t.Errorf("expected inline certificate chains to be disabled")
+	}
+
+	_, err = verifier.AuthenticateInlineX5CStatsToken(ctx, "token")
+	if !errors.Is(err, ErrInlineX5CNotEnabled) {
+		t.Errorf("expected %v, got %v", ErrInlineX5CNotEnabled, err)
+	}
+
+	badFile := filepath.Join(t.TempDir(), "anchors.pem")
+	if err := os.WriteFile(badFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = New(nil, &Config{StatsInlineX5CTrustAnchorsFile: badFile})
+	errcmp.MustMatch(t, err, "invalid STATS_INLINE_X5C_TRUST_ANCHORS_FILE")
+}
+
+func TestIsInlineX5CToken(t *testing.T) {
+	t.Parallel()
+
+	key := testECDSAKey(t)
+	sign := func(t *testing.T, header map[string]interface{}) string {
+		t.Helper()
+
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, &StatsClaims{})
+		for k, v := range header {
+			token.Header[k] = v
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	if !IsInlineX5CToken(sign(t, map[string]interface{}{x5cHeader: []string{"cert"}})) {
+		t.Errorf("expected token with x5c header to be an inline x5c token")
+	}
+	if IsInlineX5CToken(sign(t, map[string]interface{}{"kid": "v1"})) {
+		t.Errorf("expected token without x5c header to not be an inline x5c token")
+	}
+	if IsInlineX5CToken("not a token") {
+		t.Errorf("expected malformed token to not be an inline x5c token")
+	}
+}
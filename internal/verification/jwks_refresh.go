@@ -0,0 +1,164 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verification
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/verification/model"
+	"github.com/google/exposure-notifications-server/pkg/logging"
+	"go.opencensus.io/stats"
+)
+
+type listHealthAuthoritiesFn func(context.Context) ([]*model.HealthAuthority, error)
+
+// jwksRefresher holds the health authorities that have a JWKS URI, as of the
+// last successful refresh. The jwks service keeps their keys up to date in the
+// database, the refresher reloads them from there.
+type jwksRefresher struct {
+	list       listHealthAuthoritiesFn
+	interval   time.Duration
+	staleGrace time.Duration
+	clock      Clock
+
+	mu                sync.RWMutex
+	healthAuthorities map[string]*model.HealthAuthority
+	refreshedAt       time.Time
+	lastErr           error
+}
+
+func newJWKSRefresher(list listHealthAuthoritiesFn, interval, staleGrace time.Duration, clock Clock) *jwksRefresher {
+	return &jwksRefresher{
+		list:       list,
+		interval:   interval,
+		staleGrace: staleGrace,
+		clock:      clock,
+	}
+}
+
+// refresh reloads the health authorities with a JWKS URI. If that fails, the
+// previously loaded ones are kept.
+func (r *jwksRefresher) refresh(ctx context.Context) ([]*model.HealthAuthority, error) {
+	has, err := r.list(ctx)
+	if err != nil {
+		r.mu.Lock()
+		r.lastErr = err
+		r.mu.Unlock()
+		return nil, fmt.Errorf("failed to refresh JWKS health authorities: %w", err)
+	}
+
+	jwksHAs := make(map[string]*model.HealthAuthority)
+	var refreshed []*model.HealthAuthority
+	for _, ha := range has {
+		if ha.JwksURI == nil || *ha.JwksURI == "" {
+			continue
+		}
+		jwksHAs[ha.Issuer] = ha
+		refreshed = append(refreshed, ha)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.healthAuthorities = jwksHAs
+	r.refreshedAt = r.clock.Now()
+	r.lastErr = nil
+	return refreshed, nil
+}
+
+// lookup returns the health authority for the issuer, if it has a JWKS URI and
+// the last successful refresh is recent enough. The returned bool is true if a
+// refresh failed since, and the health authority may be stale.
+func (r *jwksRefresher) lookup(issuer string) (*model.HealthAuthority, bool, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.refreshedAt.IsZero() || r.clock.Now().Sub(r.refreshedAt) > r.interval+r.staleGrace {
+		return nil, false, false
+	}
+	ha, ok := r.healthAuthorities[issuer]
+	if !ok {
+		return nil, false, false
+	}
+	return ha, r.lastErr != nil, true
+}
+
+// RefreshJWKS reloads the health authorities that have a JWKS URI. It does
+// nothing if background refreshes are disabled.
+func (v *Verifier) RefreshJWKS(ctx context.Context) error {
+	if v.jwks == nil {
+		return nil
+	}
+
+	has, err := v.jwks.refresh(ctx)
+	if err != nil {
+		stats.Record(ctx, mJWKSRefreshErrors.M(1))
+		return err
+	}
+	for _, ha := range has {
+		v.checkKeyVersions(ctx, ha)
+	}
+	return nil
+}
+
+// StartJWKSRefresher refreshes the health authorities that have a JWKS URI
+// now, and then in the background at the configured interval until the context
+// is done. It does nothing if background refreshes are disabled.
+func (v *Verifier) StartJWKSRefresher(ctx context.Context) {
+	if v.jwks == nil {
+		return
+	}
+
+	logger := logging.FromContext(ctx).Named("StartJWKSRefresher")
+	if err := v.RefreshJWKS(ctx); err != nil {
+		logger.Errorw("failed to refresh JWKS health authorities", "error", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(v.jwks.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if err := v.RefreshJWKS(ctx); err != nil {
+				logger.Errorw("failed to refresh JWKS health authorities", "error", err)
+			}
+		}
+	}()
+}
+
+// lookupJWKS returns the health authority for the issuer if it was loaded by
+// the background refresher.
+func (r *databaseResolver) lookupJWKS(ctx context.Context, issuer string) (*model.HealthAuthority, bool) {
+	if r.jwks == nil {
+		return nil, false
+	}
+
+	ha, stale, ok := r.jwks.lookup(issuer)
+	if !ok {
+		return nil, false
+	}
+	if stale {
+		logging.FromContext(ctx).Warnw("failed to refresh JWKS health authorities, using stale value", "iss", issuer)
+	}
+	return ha, true
+}
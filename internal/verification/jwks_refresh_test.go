@@ -0,0 +1,220 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verification
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/exposure-notifications-server/internal/verification/model"
+	"github.com/google/exposure-notifications-server/internal/verification/verifytest"
+	"github.com/google/exposure-notifications-server/pkg/errcmp"
+)
+
+// fakeHealthAuthorities serves health authorities to the refresher, failing
+// once err is set.
+type fakeHealthAuthorities struct {
+	mu    sync.Mutex
+	has   []*model.HealthAuthority
+	err   error
+	calls int
+}
+
+func (f *fakeHealthAuthorities) list(context.Context) ([]*model.HealthAuthority, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.has, nil
+}
+
+func newJWKSHealthAuthority(t *testing.T, issuer string, id int64) *verifytest.HealthAuthority {
+	t.Helper()
+
+	uri := "https://" + issuer + "/.well-known/jwks.json"
+	ha := verifytest.NewHealthAuthority(t, issuer)
+	ha.HealthAuthority.ID = id
+	ha.HealthAuthority.JwksURI = &uri
+	ha.HealthAuthority.Keys = []*model.HealthAuthorityKey{ha.Key}
+	return ha
+}
+
+func TestJWKSRefresher_UsesRefreshedKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	statsAudience := "test-stats-aud"
+
+	jwksHA := newJWKSHealthAuthority(t, "jwks.test.health", 21)
+	plainHA := verifytest.NewHealthAuthority(t, "plain.test.health")
+	plainHA.HealthAuthority.ID = 22
+	plainHA.HealthAuthority.Keys = []*model.HealthAuthorityKey{plainHA.Key}
+
+	// There is no database, a lookup that isn't served by the refresher would
+	// fail.
+	verifier, err := New(nil, &Config{
+		CacheDuration:       time.Hour,
+		StatsAudience:       statsAudience,
+		JWKSRefreshInterval: time.Hour,
+		JWKSStaleGrace:      time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeHealthAuthorities{has: []*model.HealthAuthority{jwksHA.HealthAuthority, plainHA.HealthAuthority}}
+	verifier.jwks.list = fake.list
+
+	if err := verifier.RefreshJWKS(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		id, err := verifier.AuthenticateStatsToken(ctx, jwksHA.StatsToken(t, statsAudience))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := id, jwksHA.HealthAuthority.ID; got != want {
+			t.Errorf("expected health authority %d to be %d", got, want)
+		}
+	}
+	if got, want := fake.calls, 1; got != want {
+		t.Errorf("expected %d list calls, got %d", want, got)
+	}
+
+	// Health authorities without a JWKS URI are left to the cache.
+	if _, _, ok := verifier.jwks.lookup(plainHA.HealthAuthority.Issuer); ok {
+		t.Errorf("expected %q to not be served by the refresher", plainHA.HealthAuthority.Issuer)
+	}
+}
+
+func TestJWKSRefresher_StaleOnError(t *testing.T) {
+	t.Parallel()
+
+	statsAudience := "test-stats-aud"
+	interval := time.Minute
+	staleGrace := time.Hour
+
+	cases := []struct {
+		name string
+		age  time.Duration
+		ok   bool
+	}{
+		{
+			name: "within_interval",
+			age:  interval / 2,
+			ok:   true,
+		},
+		{
+			name: "within_grace",
+			age:  interval + staleGrace/2,
+			ok:   true,
+		},
+		{
+			name: "after_grace",
+			age:  interval + staleGrace + time.Minute,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := project.TestContext(t)
+
+			verifier, err := New(nil, &Config{
+				CacheDuration:       time.Hour,
+				StatsAudience:       statsAudience,
+				JWKSRefreshInterval: interval,
+				JWKSStaleGrace:      staleGrace,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ha := newJWKSHealthAuthority(t, "stale-jwks.test.health", 23)
+			fake := &fakeHealthAuthorities{has: []*model.HealthAuthority{ha.HealthAuthority}}
+			verifier.jwks.list = fake.list
+
+			if err := verifier.RefreshJWKS(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			fake.err = errors.New("database unavailable")
+			errcmp.MustMatch(t, verifier.RefreshJWKS(ctx), "database unavailable")
+
+			// Age the last successful refresh.
+			verifier.jwks.mu.Lock()
+			verifier.jwks.refreshedAt = time.Now().Add(-tc.age)
+			verifier.jwks.mu.Unlock()
+
+			got, stale, ok := verifier.jwks.lookup(ha.HealthAuthority.Issuer)
+			if ok != tc.ok {
+				t.Fatalf("expected lookup to be %t, got %t", tc.ok, ok)
+			}
+			if !ok {
+				return
+			}
+			if !stale {
+				t.Errorf("expected the health authority to be stale after a failed refresh")
+			}
+			if got != ha.HealthAuthority {
+				t.Errorf("expected the previously refreshed health authority")
+			}
+
+			// The stale keys still verify tokens without a lookup.
+			id, err := verifier.AuthenticateStatsToken(ctx, ha.StatsToken(t, statsAudience))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := id, ha.HealthAuthority.ID; got != want {
+				t.Errorf("expected health authority %d to be %d", got, want)
+			}
+		})
+	}
+}
+
+func TestJWKSRefresher_Disabled(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	verifier, err := New(nil, &Config{CacheDuration: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verifier.jwks != nil {
+		t.Fatal("expected no refresher")
+	}
+	if err := verifier.RefreshJWKS(ctx); err != nil {
+		t.Fatal(err)
+	}
+	verifier.StartJWKSRefresher(ctx)
+}
+
+func TestNew_NegativeJWKSStaleGrace(t *testing.T) {
+	t.Parallel()
+
+	_, err := New(nil, &Config{JWKSRefreshInterval: time.Minute, JWKSStaleGrace: -1})
+	errcmp.MustMatch(t, err, "VERIFICATION_JWKS_STALE_GRACE cannot be negative")
+}
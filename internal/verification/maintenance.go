@@ -0,0 +1,73 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verification
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/exposure-notifications-server/pkg/logging"
+)
+
+// RefreshCacheMaintenanceMode applies the cache maintenance mode that is set
+// in the database through the admin console. It is always enabled if
+// CacheMaintenanceMode is configured. If the mode can't be read, for example
+// because the database maintenance has already started, the current mode is
+// kept.
+func (v *Verifier) RefreshCacheMaintenanceMode(ctx context.Context) error {
+	enabled, _, err := v.db.CacheMaintenanceMode(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to refresh cache maintenance mode: %w", err)
+	}
+
+	enabled = enabled || v.config.CacheMaintenanceMode
+	if enabled != v.CacheMaintenanceMode() {
+		v.SetCacheMaintenanceMode(ctx, enabled)
+	}
+	return nil
+}
+
+// StartCacheMaintenanceModeRefresher refreshes the cache maintenance mode now,
+// and then in the background at the configured interval until the context is
+// done. It does nothing if the refresh interval is zero.
+func (v *Verifier) StartCacheMaintenanceModeRefresher(ctx context.Context) {
+	interval := v.config.CacheMaintenanceModeRefreshInterval
+	if interval <= 0 {
+		return
+	}
+
+	logger := logging.FromContext(ctx).Named("StartCacheMaintenanceModeRefresher")
+	if err := v.RefreshCacheMaintenanceMode(ctx); err != nil {
+		logger.Errorw("failed to refresh cache maintenance mode", "error", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if err := v.RefreshCacheMaintenanceMode(ctx); err != nil {
+				logger.Errorw("failed to refresh cache maintenance mode", "error", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,145 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verification
+
+import (
+	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/pkg/observability"
+	"go.opencensus.io/plugin/ochttp"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+var (
+	verificationMetricsPrefix = metrics.MetricRoot + "verification/"
+
+	mTooManyKeyVersions = stats.Int64(verificationMetricsPrefix+"too_many_key_versions",
+		"health authorities loaded with more active key versions than allowed", stats.UnitDimensionless)
+
+	mDuplicateKeyVersions = stats.Int64(verificationMetricsPrefix+"duplicate_key_versions",
+		"health authorities loaded with more than one key for the same version", stats.UnitDimensionless)
+
+	mJWKSRefreshErrors = stats.Int64(verificationMetricsPrefix+"jwks_refresh_errors",
+		"failed background refreshes of health authorities with a JWKS URI", stats.UnitDimensionless)
+
+	mDeprecatedStatsAudience = stats.Int64(verificationMetricsPrefix+"deprecated_stats_audience",
+		"stats API tokens accepted with a deprecated audience", stats.UnitDimensionless)
+
+	mStatsTokenLatencyMs = stats.Float64(verificationMetricsPrefix+"stats_token_latency",
+		"stats API token verification latency", stats.UnitMilliseconds)
+
+	mShadowStatsToken = stats.Int64(verificationMetricsPrefix+"shadow_stats_token",
+		"stats API tokens verified with the shadow config", stats.UnitDimensionless)
+
+	mSecondaryKeyStoreLookup = stats.Int64(verificationMetricsPrefix+"secondary_key_store_lookup",
+		"health authorities looked up in the secondary key store", stats.UnitDimensionless)
+
+	mCacheEviction = stats.Int64(verificationMetricsPrefix+"cache_eviction",
+		"health authorities evicted from the cache because it was full", stats.UnitDimensionless)
+
+	mInsecureIssuer = stats.Int64(verificationMetricsPrefix+"insecure_issuer",
+		"tokens of health authorities whose issuer isn't an https:// URL", stats.UnitDimensionless)
+
+	issuerTag   = tag.MustNewKey("issuer")
+	audienceTag = tag.MustNewKey("audience")
+	outcomeTag  = tag.MustNewKey("outcome")
+	cacheTag    = tag.MustNewKey("cache")
+	liveTag     = tag.MustNewKey("live")
+	shadowTag   = tag.MustNewKey("shadow")
+)
+
+// The tag mutators are created once, so that recording latency doesn't
+// allocate them on every request.
+var (
+	outcomeSuccess = tag.Upsert(outcomeTag, "SUCCESS")
+	outcomeFailure = tag.Upsert(outcomeTag, "FAILURE")
+
+	// cacheNoneTag is used when the token was rejected before the health
+	// authority was looked up, cacheSecondaryTag when it was read from the
+	// secondary key store and cacheResolverTag when it was resolved by a
+	// HealthAuthorityResolver.
+	cacheHitTag       = tag.Upsert(cacheTag, "HIT")
+	cacheMissTag      = tag.Upsert(cacheTag, "MISS")
+	cacheNoneTag      = tag.Upsert(cacheTag, "NONE")
+	cacheSecondaryTag = tag.Upsert(cacheTag, "SECONDARY")
+	cacheResolverTag  = tag.Upsert(cacheTag, "RESOLVER")
+)
+
+func init() {
+	observability.CollectViews([]*view.View{
+		{
+			Name:        verificationMetricsPrefix + "too_many_key_versions_count",
+			Description: "Total count of health authorities loaded with more active key versions than allowed",
+			TagKeys:     []tag.Key{issuerTag},
+			Measure:     mTooManyKeyVersions,
+			Aggregation: view.Count(),
+		},
+		{
+			Name:        verificationMetricsPrefix + "duplicate_key_versions_count",
+			Description: "Total count of health authorities loaded with more than one key for the same version",
+			TagKeys:     []tag.Key{issuerTag},
+			Measure:     mDuplicateKeyVersions,
+			Aggregation: view.Count(),
+		},
+		{
+			Name:        verificationMetricsPrefix + "jwks_refresh_errors_count",
+			Description: "Total count of failed background refreshes of health authorities with a JWKS URI",
+			Measure:     mJWKSRefreshErrors,
+			Aggregation: view.Count(),
+		},
+		{
+			Name:        verificationMetricsPrefix + "deprecated_stats_audience_count",
+			Description: "Total count of stats API tokens accepted with a deprecated audience",
+			TagKeys:     []tag.Key{issuerTag, audienceTag},
+			Measure:     mDeprecatedStatsAudience,
+			Aggregation: view.Count(),
+		},
+		{
+			Name:        verificationMetricsPrefix + "shadow_stats_token_count",
+			Description: "Total count of stats API tokens verified with the shadow config, by live and shadow outcome",
+			TagKeys:     []tag.Key{liveTag, shadowTag},
+			Measure:     mShadowStatsToken,
+			Aggregation: view.Count(),
+		},
+		{
+			Name:        verificationMetricsPrefix + "secondary_key_store_lookup_count",
+			Description: "Total count of health authorities looked up in the secondary key store because the database was unavailable, by outcome",
+			TagKeys:     []tag.Key{issuerTag, outcomeTag},
+			Measure:     mSecondaryKeyStoreLookup,
+			Aggregation: view.Count(),
+		},
+		{
+			Name:        verificationMetricsPrefix + "cache_eviction_count",
+			Description: "Total count of health authorities evicted from the cache because it held VERIFICATION_CACHE_MAX_SIZE issuers",
+			Measure:     mCacheEviction,
+			Aggregation: view.Count(),
+		},
+		{
+			Name:        verificationMetricsPrefix + "insecure_issuer_count",
+			Description: "Total count of tokens of health authorities whose issuer isn't an https:// URL, by outcome",
+			TagKeys:     []tag.Key{issuerTag, outcomeTag},
+			Measure:     mInsecureIssuer,
+			Aggregation: view.Count(),
+		},
+		{
+			Name:        verificationMetricsPrefix + "stats_token_latency",
+			Description: "Latency distribution of stats API token verification",
+			TagKeys:     []tag.Key{outcomeTag, cacheTag},
+			Measure:     mStatsTokenLatencyMs,
+			Aggregation: ochttp.DefaultLatencyDistribution,
+		},
+	}...)
+}
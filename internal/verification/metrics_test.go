@@ -0,0 +1,290 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verification
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/exposure-notifications-server/internal/verification/database"
+	"github.com/google/exposure-notifications-server/internal/verification/verifytest"
+	"github.com/google/exposure-notifications-server/pkg/observability"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+// fakeExporter keeps the latest exported rows for each view.
+type fakeExporter struct {
+	mu   sync.Mutex
+	rows map[string][]*view.Row
+}
+
+func (e *fakeExporter) ExportView(d *view.Data) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rows[d.View.Name] = d.Rows
+}
+
+// count returns the number of measurements exported for the view with exactly
+// the given tags.
+func (e *fakeExporter) count(name string, tags map[tag.Key]string) int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var total int64
+	for _, row := range e.rows[name] {
+		if len(row.Tags) != len(tags) {
+			continue
+		}
+		match := true
+		for _, t := range row.Tags {
+			if tags[t.Key] != t.Value {
+				match = false
+			}
+		}
+		if !match {
+			continue
+		}
+		switch d := row.Data.(type) {
+		case *view.DistributionData:
+			total += d.Count
+		case *view.CountData:
+			total += d.Value
+		}
+	}
+	return total
+}
+
+func TestAuthenticateStatsToken_LatencyMetric(t *testing.T) {
+	// Not parallel, the exporter and reporting period are global.
+
+	ctx := project.TestContext(t)
+	statsAudience := "test-stats-aud"
+	viewName := verificationMetricsPrefix + "stats_token_latency"
+
+	var latencyView *view.View
+	for _, v := range observability.AllViews() {
+		if v.Name == viewName {
+			latencyView = v
+		}
+	}
+	if latencyView == nil {
+		t.Fatalf("view %q is not collected", viewName)
+	}
+	if err := view.Register(latencyView); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { view.Unregister(latencyView) })
+
+	exporter := &fakeExporter{rows: make(map[string][]*view.Row)}
+	view.RegisterExporter(exporter)
+	t.Cleanup(func() { view.UnregisterExporter(exporter) })
+	view.SetReportingPeriod(10 * time.Millisecond)
+	t.Cleanup(func() { view.SetReportingPeriod(time.Minute) })
+
+	// waitForCount waits for the exported count to reach want.
+	waitForCount := func(t *testing.T, tags map[tag.Key]string, want int64) {
+		t.Helper()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			got := exporter.count(viewName, tags)
+			if got >= want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d measurements with tags %v, got %d", want, tags, got)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	t.Run("cache_hit", func(t *testing.T) {
+		ha := verifytest.NewHealthAuthority(t, "hit.test.health")
+		ha.HealthAuthority.ID = 7
+		verifier, err := New(nil, &Config{CacheDuration: time.Hour, StatsAudience: statsAudience})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ha.Cache(t, verifier)
+
+		hit := map[tag.Key]string{outcomeTag: "SUCCESS", cacheTag: "HIT"}
+		before := exporter.count(viewName, hit)
+		if _, err := verifier.AuthenticateStatsToken(ctx, ha.StatsToken(t, statsAudience)); err != nil {
+			t.Fatal(err)
+		}
+		waitForCount(t, hit, before+1)
+
+		failed := map[tag.Key]string{outcomeTag: "FAILURE", cacheTag: "NONE"}
+		before = exporter.count(viewName, failed)
+		if _, err := verifier.AuthenticateStatsToken(ctx, "not a token"); err == nil {
+			t.Fatal("expected error")
+		}
+		waitForCount(t, failed, before+1)
+	})
+
+	t.Run("cache_miss", func(t *testing.T) {
+		testDB, _ := testDatabaseInstance.NewDatabase(t)
+
+		ha := verifytest.NewHealthAuthority(t, "miss.test.health")
+		ha.Store(ctx, t, database.New(testDB))
+		verifier, err := New(database.New(testDB), &Config{CacheDuration: time.Hour, StatsAudience: statsAudience})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		miss := map[tag.Key]string{outcomeTag: "SUCCESS", cacheTag: "MISS"}
+		before := exporter.count(viewName, miss)
+		if _, err := verifier.AuthenticateStatsToken(ctx, ha.StatsToken(t, statsAudience)); err != nil {
+			t.Fatal(err)
+		}
+		waitForCount(t, miss, before+1)
+	})
+}
+
+func TestAuthenticateStatsToken_DeprecatedAudienceMetric(t *testing.T) {
+	// Not parallel, the exporter and reporting period are global.
+
+	ctx := project.TestContext(t)
+	viewName := verificationMetricsPrefix + "deprecated_stats_audience_count"
+
+	var audienceView *view.View
+	for _, v := range observability.AllViews() {
+		if v.Name == viewName {
+			audienceView = v
+		}
+	}
+	if audienceView == nil {
+		t.Fatalf("view %q is not collected", viewName)
+	}
+	if err := view.Register(audienceView); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { view.Unregister(audienceView) })
+
+	exporter := &fakeExporter{rows: make(map[string][]*view.Row)}
+	view.RegisterExporter(exporter)
+	t.Cleanup(func() { view.UnregisterExporter(exporter) })
+	view.SetReportingPeriod(10 * time.Millisecond)
+	t.Cleanup(func() { view.SetReportingPeriod(time.Minute) })
+
+	ha := verifytest.NewHealthAuthority(t, "laggard.test.health")
+	ha.HealthAuthority.ID = 8
+	verifier, err := New(nil, &Config{
+		CacheDuration:            time.Hour,
+		StatsAudience:            "new-aud",
+		StatsDeprecatedAudiences: []string{"old-aud", "older-aud"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ha.Cache(t, verifier)
+
+	// Tokens for the current audience are not counted.
+	if _, err := verifier.AuthenticateStatsToken(ctx, ha.StatsToken(t, "new-aud")); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := verifier.AuthenticateStatsToken(ctx, ha.StatsToken(t, "older-aud"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := id, ha.HealthAuthority.ID; got != want {
+		t.Errorf("expected health authority %d to be %d", got, want)
+	}
+
+	if _, err := verifier.AuthenticateStatsToken(ctx, ha.WrongAudienceStatsToken(t)); err == nil {
+		t.Fatal("expected error for unknown audience")
+	}
+
+	tags := map[tag.Key]string{issuerTag: "laggard.test.health", audienceTag: "older-aud"}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if got := exporter.count(viewName, tags); got == 1 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("expected 1 deprecated audience measurement, got %d", got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := exporter.count(viewName, map[tag.Key]string{issuerTag: "laggard.test.health", audienceTag: "new-aud"}); got != 0 {
+		t.Errorf("expected no measurements for the current audience, got %d", got)
+	}
+}
+
+func TestSecondaryKeyStoreLookupMetric(t *testing.T) {
+	// Not parallel, the exporter and reporting period are global.
+
+	ctx := project.TestContext(t)
+	viewName := verificationMetricsPrefix + "secondary_key_store_lookup_count"
+
+	var lookupView *view.View
+	for _, v := range observability.AllViews() {
+		if v.Name == viewName {
+			lookupView = v
+		}
+	}
+	if lookupView == nil {
+		t.Fatalf("view %q is not collected", viewName)
+	}
+	if err := view.Register(lookupView); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { view.Unregister(lookupView) })
+
+	exporter := &fakeExporter{rows: make(map[string][]*view.Row)}
+	view.RegisterExporter(exporter)
+	t.Cleanup(func() { view.UnregisterExporter(exporter) })
+	view.SetReportingPeriod(10 * time.Millisecond)
+	t.Cleanup(func() { view.SetReportingPeriod(time.Minute) })
+
+	ha := verifytest.NewHealthAuthority(t, "secondary-metric.test.health")
+	verifier, err := New(nil, &Config{SecondaryKeyStoreFile: writeSnapshot(t, ha)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := verifier.resolver.(*databaseResolver)
+	primaryErr := errors.New("database unavailable")
+	if _, err := resolver.lookupSecondary(ctx, "secondary-metric.test.health", primaryErr); err != nil {
+		t.Fatal(err)
+	}
+	// Issuers from tokens that don't belong to a health authority aren't used
+	// as tags.
+	for _, issuer := range []string{"made-up-1.test.health", "made-up-2.test.health"} {
+		if _, err := resolver.lookupSecondary(ctx, issuer, primaryErr); !errors.Is(err, primaryErr) {
+			t.Fatalf("expected %v to be %v", err, primaryErr)
+		}
+	}
+
+	found := map[tag.Key]string{issuerTag: "secondary-metric.test.health", outcomeTag: "SUCCESS"}
+	notFound := map[tag.Key]string{issuerTag: unknownIssuer, outcomeTag: "FAILURE"}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if exporter.count(viewName, found) == 1 && exporter.count(viewName, notFound) == 2 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("expected 1 successful and 2 unknown lookups, got %d and %d",
+				exporter.count(viewName, found), exporter.count(viewName, notFound))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := exporter.count(viewName, map[tag.Key]string{issuerTag: "made-up-1.test.health", outcomeTag: "FAILURE"}); got != 0 {
+		t.Errorf("expected no measurements tagged with the unknown issuer, got %d", got)
+	}
+}
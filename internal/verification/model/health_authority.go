@@ -17,8 +17,13 @@ package model
 
 import (
 	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/google/exposure-notifications-server/internal/project"
@@ -35,6 +40,46 @@ type HealthAuthority struct {
 	Keys           []*HealthAuthorityKey
 	JwksURI        *string
 	EnableStatsAPI bool
+
+	// TrustAnchorsPEM is an optional bundle of PEM encoded CA certificates. If
+	// set, verification certificates may carry an x5c certificate chain that
+	// is validated against these anchors instead of referencing a registered
+	// key version.
+	TrustAnchorsPEM string
+
+	// NoFederate prevents keys published by this health authority from being
+	// served to federation partners.
+	NoFederate bool
+
+	// StatsAudience is an optional audience accepted on stats API tokens from
+	// this health authority, in addition to the globally configured audience.
+	StatsAudience *string
+
+	// DefaultStatsKeyVersion is an optional key version used to verify stats
+	// API tokens from this health authority that omit the 'kid' header. This
+	// only exists for legacy integrations, other tokens must set 'kid'.
+	DefaultStatsKeyVersion *string
+
+	// StatsAlgorithms optionally restricts the signing algorithms of stats API
+	// tokens from this health authority, e.g. to pin it to ES256. Tokens must
+	// use an algorithm that is both in this list and allowed by the server. If
+	// empty, all algorithms the server allows are accepted.
+	StatsAlgorithms []string
+}
+
+// StatsSigningAlgorithms are the signing algorithms that stats API tokens can
+// be signed with. Health authority keys are ECDSA keys.
+var StatsSigningAlgorithms = []string{"ES256", "ES384", "ES512"}
+
+// IsStatsSigningAlgorithm returns true if alg is one of
+// StatsSigningAlgorithms.
+func IsStatsSigningAlgorithm(alg string) bool {
+	for _, a := range StatsSigningAlgorithms {
+		if a == alg {
+			return true
+		}
+	}
+	return false
 }
 
 // JWKSEnabled returns true if JWKS discovery is enabled for this health authority.
@@ -52,6 +97,116 @@ func (ha *HealthAuthority) SetJWKS(uri string) {
 	ha.JwksURI = &uri
 }
 
+// SetStatsAudience sets the optional StatsAudience property of the
+// HealthAuthority.
+func (ha *HealthAuthority) SetStatsAudience(aud string) {
+	aud = project.TrimSpaceAndNonPrintable(aud)
+	if aud == "" {
+		ha.StatsAudience = nil
+		return
+	}
+	ha.StatsAudience = &aud
+}
+
+// SetDefaultStatsKeyVersion sets the optional DefaultStatsKeyVersion property
+// of the HealthAuthority.
+func (ha *HealthAuthority) SetDefaultStatsKeyVersion(version string) {
+	version = project.TrimSpaceAndNonPrintable(version)
+	if version == "" {
+		ha.DefaultStatsKeyVersion = nil
+		return
+	}
+	ha.DefaultStatsKeyVersion = &version
+}
+
+// SetStatsAlgorithms sets the optional StatsAlgorithms property of the
+// HealthAuthority from a comma or space separated list. Algorithm names are
+// case-insensitive.
+func (ha *HealthAuthority) SetStatsAlgorithms(algs string) {
+	fields := strings.FieldsFunc(project.TrimSpaceAndNonPrintable(algs), func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+	if len(fields) == 0 {
+		ha.StatsAlgorithms = nil
+		return
+	}
+	ha.StatsAlgorithms = make([]string, 0, len(fields))
+	for _, f := range fields {
+		ha.StatsAlgorithms = append(ha.StatsAlgorithms, strings.ToUpper(f))
+	}
+}
+
+// AllowsStatsAlgorithm returns true if stats API tokens from this health
+// authority may be signed with alg. Whether the server allows alg is checked
+// separately.
+func (ha *HealthAuthority) AllowsStatsAlgorithm(alg string) bool {
+	if len(ha.StatsAlgorithms) == 0 {
+		return true
+	}
+	for _, a := range ha.StatsAlgorithms {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// ActiveKeyVersions returns the number of key versions that have not been
+// revoked. This includes keys that are not yet valid.
+func (ha *HealthAuthority) ActiveKeyVersions() int {
+	count := 0
+	for _, k := range ha.Keys {
+		if k.IsActive() {
+			count++
+		}
+	}
+	return count
+}
+
+// DuplicateKeyVersions returns the key versions that the health authority has
+// more than one key for, in the order they are first duplicated. Tokens with
+// such a 'kid' are verified with whichever key is found first.
+func (ha *HealthAuthority) DuplicateKeyVersions() []string {
+	seen := make(map[string]int, len(ha.Keys))
+	var duplicates []string
+	for _, k := range ha.Keys {
+		seen[k.Version]++
+		if seen[k.Version] == 2 {
+			duplicates = append(duplicates, k.Version)
+		}
+	}
+	return duplicates
+}
+
+// X5CEnabled returns true if certificate chains are accepted for this health
+// authority.
+func (ha *HealthAuthority) X5CEnabled() bool {
+	return strings.TrimSpace(ha.TrustAnchorsPEM) != ""
+}
+
+// TrustAnchors parses the TrustAnchorsPEM bundle into a certificate pool.
+func (ha *HealthAuthority) TrustAnchors() (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(ha.TrustAnchorsPEM)) {
+		return nil, errors.New("no valid certificates in trust anchors")
+	}
+	return pool, nil
+}
+
+// ErrInsecureIssuer indicates the issuer of a health authority is not an
+// https:// URL.
+var ErrInsecureIssuer = errors.New("issuer is not an https:// URL")
+
+// ValidateHTTPSIssuer returns ErrInsecureIssuer if the issuer is not an
+// absolute https:// URL with a host.
+func (ha *HealthAuthority) ValidateHTTPSIssuer() error {
+	u, err := url.Parse(ha.Issuer)
+	if err != nil || !strings.EqualFold(u.Scheme, "https") || u.Host == "" {
+		return fmt.Errorf("%w: %q", ErrInsecureIssuer, ha.Issuer)
+	}
+	return nil
+}
+
 // Validate returns an error if the HealthAuthority struct is not valid.
 func (ha *HealthAuthority) Validate() error {
 	if ha.Issuer == "" {
@@ -63,9 +218,33 @@ func (ha *HealthAuthority) Validate() error {
 	if ha.Name == "" {
 		return errors.New("name cannot be empty")
 	}
+	if ha.X5CEnabled() {
+		if _, err := ha.TrustAnchors(); err != nil {
+			return fmt.Errorf("invalid trust anchors: %w", err)
+		}
+	}
+	for _, alg := range ha.StatsAlgorithms {
+		if !IsStatsSigningAlgorithm(alg) {
+			return fmt.Errorf("unsupported stats API algorithm %q, must be one of %s", alg, strings.Join(StatsSigningAlgorithms, ", "))
+		}
+	}
 	return nil
 }
 
+// KeyUsage restricts what a health authority key version may be used to
+// verify.
+type KeyUsage string
+
+const (
+	// KeyUsageAny allows the key to verify both certificates and stats tokens.
+	KeyUsageAny KeyUsage = ""
+	// KeyUsageCertificate only allows the key to verify diagnosis verification
+	// certificates.
+	KeyUsageCertificate KeyUsage = "certificate"
+	// KeyUsageStats only allows the key to verify stats API tokens.
+	KeyUsageStats KeyUsage = "stats"
+)
+
 // HealthAuthorityKey represents a public key version for a given health authority.
 type HealthAuthorityKey struct {
 	AuthorityID  int64
@@ -73,6 +252,7 @@ type HealthAuthorityKey struct {
 	From         time.Time
 	Thru         time.Time
 	PublicKeyPEM string
+	Usage        KeyUsage
 }
 
 // Validate returns an error if the HealthAuthorityKey is not valid.
@@ -80,13 +260,29 @@ func (k *HealthAuthorityKey) Validate() error {
 	if _, err := k.PublicKey(); err != nil {
 		return fmt.Errorf("invalid public key PEM block: %w", err)
 	}
+	switch k.Usage {
+	case KeyUsageAny, KeyUsageCertificate, KeyUsageStats:
+	default:
+		return fmt.Errorf("invalid key usage %q", k.Usage)
+	}
 	return nil
 }
 
+// AllowsUsage returns true if the key may be used for the given purpose.
+func (k *HealthAuthorityKey) AllowsUsage(usage KeyUsage) bool {
+	return k.Usage == KeyUsageAny || k.Usage == usage
+}
+
 func (k *HealthAuthorityKey) IsFuture() bool {
 	return k.From.After(time.Now())
 }
 
+// IsActive returns true if the key has not been revoked. An active key may not
+// be valid yet.
+func (k *HealthAuthorityKey) IsActive() bool {
+	return k.Thru.IsZero() || k.Thru.After(time.Now())
+}
+
 // IsValid returns true if the key is valid based on the current time.
 func (k *HealthAuthorityKey) IsValid() bool {
 	return k.IsValidAt(time.Now())
@@ -111,3 +307,18 @@ func (k *HealthAuthorityKey) Revoke() {
 func (k *HealthAuthorityKey) PublicKey() (*ecdsa.PublicKey, error) {
 	return keys.ParseECDSAPublicKey(k.PublicKeyPEM)
 }
+
+// Fingerprint returns the hex encoded SHA-256 hash of the DER encoded public
+// key, which identifies the key independent of its version.
+func (k *HealthAuthorityKey) Fingerprint() (string, error) {
+	publicKey, err := k.PublicKey()
+	if err != nil {
+		return "", err
+	}
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal public key: %w", err)
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
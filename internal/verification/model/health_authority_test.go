@@ -15,6 +15,7 @@
 package model
 
 import (
+	"errors"
 	"testing"
 	"time"
 
@@ -148,6 +149,123 @@ func TestIsValid(t *testing.T) {
 	}
 }
 
+func TestActiveKeyVersions(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now().UTC()
+	ha := HealthAuthority{
+		Keys: []*HealthAuthorityKey{
+			{Version: "v1", From: now.Add(-2 * time.Minute), Thru: now.Add(-1 * time.Minute)},
+			{Version: "v2", From: now.Add(-1 * time.Minute)},
+			{Version: "v3", From: now.Add(-1 * time.Minute), Thru: now.Add(time.Minute)},
+			{Version: "v4", From: now.Add(time.Minute)},
+		},
+	}
+
+	if got, want := ha.ActiveKeyVersions(), 3; got != want {
+		t.Errorf("ActiveKeyVersions: want: %v got: %v", want, got)
+	}
+}
+
+func TestDuplicateKeyVersions(t *testing.T) {
+	t.Parallel()
+
+	ha := HealthAuthority{
+		Keys: []*HealthAuthorityKey{
+			{Version: "v1"},
+			{Version: "v2"},
+			{Version: "v1"},
+			{Version: "v3"},
+			{Version: "v1"},
+			{Version: "v3"},
+		},
+	}
+	if diff := cmp.Diff([]string{"v1", "v3"}, ha.DuplicateKeyVersions()); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	ha.Keys = ha.Keys[:2]
+	if got := ha.DuplicateKeyVersions(); len(got) != 0 {
+		t.Errorf("expected no duplicates, got %v", got)
+	}
+}
+
+func TestValidateTrustAnchors(t *testing.T) {
+	t.Parallel()
+
+	ha := HealthAuthority{
+		Issuer:          "iss",
+		Audience:        "aud",
+		Name:            "name",
+		TrustAnchorsPEM: "-----BEGIN CERTIFICATE-----\nbm9wZQ==\n-----END CERTIFICATE-----\n",
+	}
+	if !ha.X5CEnabled() {
+		t.Errorf("expected certificate chains to be enabled")
+	}
+	errcmp.MustMatch(t, ha.Validate(), "invalid trust anchors")
+
+	ha.TrustAnchorsPEM = ""
+	if ha.X5CEnabled() {
+		t.Errorf("expected certificate chains to be disabled")
+	}
+	if err := ha.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestStatsAlgorithms(t *testing.T) {
+	t.Parallel()
+
+	ha := HealthAuthority{Issuer: "iss", Audience: "aud", Name: "name"}
+	if !ha.AllowsStatsAlgorithm("ES384") {
+		t.Errorf("expected all algorithms to be allowed without a restriction")
+	}
+
+	ha.SetStatsAlgorithms(" es256, ES384 ")
+	if diff := cmp.Diff([]string{"ES256", "ES384"}, ha.StatsAlgorithms); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+	if !ha.AllowsStatsAlgorithm("ES384") || ha.AllowsStatsAlgorithm("ES512") {
+		t.Errorf("expected only ES256 and ES384 to be allowed, got %v", ha.StatsAlgorithms)
+	}
+	if err := ha.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	ha.SetStatsAlgorithms("ES256 HS256")
+	errcmp.MustMatch(t, ha.Validate(), `unsupported stats API algorithm "HS256"`)
+
+	ha.SetStatsAlgorithms("  ")
+	if ha.StatsAlgorithms != nil {
+		t.Errorf("expected no restriction, got %v", ha.StatsAlgorithms)
+	}
+}
+
+func TestValidateHTTPSIssuer(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		issuer string
+		err    bool
+	}{
+		{issuer: "https://doh.mystate.gov"},
+		{issuer: "HTTPS://doh.mystate.gov/issuer"},
+		{issuer: "http://doh.mystate.gov", err: true},
+		{issuer: "doh.mystate.gov", err: true},
+		{issuer: "https://", err: true},
+		{issuer: "https:/doh.mystate.gov", err: true},
+		{issuer: "", err: true},
+	}
+
+	for _, tc := range cases {
+		ha := &HealthAuthority{Issuer: tc.issuer}
+		err := ha.ValidateHTTPSIssuer()
+		if tc.err != errors.Is(err, ErrInsecureIssuer) {
+			t.Errorf("issuer %q: expected error %t, got %v", tc.issuer, tc.err, err)
+		}
+	}
+}
+
 func TestPublicKeyParse(t *testing.T) {
 	t.Parallel()
 
@@ -203,3 +321,28 @@ NTG3+oqI0Q6a3kPOuAAAupr373j7O1YXrM2KAix966EPwTNlK7YCcJa0m6PKz9DT
 		})
 	}
 }
+
+func TestFingerprint(t *testing.T) {
+	t.Parallel()
+
+	hak := HealthAuthorityKey{
+		PublicKeyPEM: `-----BEGIN PUBLIC KEY-----
+MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEA+k9YktDK3UpOhBIy+O17biuwd/g
+IBSEEHOdgpAynz0yrHpkWL6vxjNHxRdWcImZxPgL0NVHMdY4TlsL7qaxBQ==
+-----END PUBLIC KEY-----`,
+	}
+
+	got, err := hak.Fingerprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The SHA-256 hash of the DER bytes in the PEM block.
+	if want := "aeeeb807f2e11276f79313ad1f85ba93a206e98ce659f74fd24ca3f22e648ec0"; got != want {
+		t.Errorf("expected fingerprint %q to be %q", got, want)
+	}
+
+	hak.PublicKeyPEM = "invalid"
+	if _, err := hak.Fingerprint(); err == nil {
+		t.Errorf("expected error for invalid key")
+	}
+}
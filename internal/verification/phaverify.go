@@ -20,8 +20,13 @@ package verification
 import (
 	"context"
 	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"regexp"
+	"strings"
 
 	aamodel "github.com/google/exposure-notifications-server/internal/authorizedapp/model"
 	"github.com/google/exposure-notifications-server/internal/verification/database"
@@ -33,12 +38,29 @@ import (
 	utils "github.com/google/exposure-notifications-server/pkg/verification"
 
 	"github.com/golang-jwt/jwt"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
 )
 
 var (
 	// ErrNoPublicKeys indicates no public keys were found when verifying the certificate.
 	ErrNoPublicKeys = errors.New("no active public keys for health authority")
 	ErrNotValidYet  = errors.New("not valid yet (NBF or IAT) in the future")
+	// ErrKeyUsage indicates the matching key version may not be used to verify
+	// this kind of token.
+	ErrKeyUsage = errors.New("key version is not authorized for this use")
+	// ErrMalformedKID indicates the 'kid' header of a token is not in the
+	// expected format.
+	ErrMalformedKID = errors.New("malformed 'kid' header in token")
+	// ErrDuplicateKID indicates the health authority has more than one key
+	// version matching the 'kid' of a token.
+	ErrDuplicateKID = errors.New("more than one key version matches 'kid'")
+	// ErrTokenType indicates the 'typ' header of a token is missing or not one
+	// of the accepted types.
+	ErrTokenType = errors.New("'typ' header in token is not accepted")
+	// ErrDetachedHMACMismatch indicates the HMAC sent alongside a publish
+	// request doesn't match the certificate's HMAC or the published keys.
+	ErrDetachedHMACMismatch = errors.New("detached HMAC does not match")
 )
 
 // Verifier can be used to verify public health authority diagnosis verification certificates.
@@ -46,15 +68,193 @@ type Verifier struct {
 	db      *database.HealthAuthorityDB
 	config  *Config
 	haCache *cache.Cache
+
+	// kidPattern is the compiled config.StatsKIDPattern, or nil if there is
+	// no pattern.
+	kidPattern *regexp.Regexp
+
+	// jwks holds the health authorities with a JWKS URI that are refreshed in
+	// the background, or nil if background refreshes are disabled.
+	jwks *jwksRefresher
+
+	// inlineX5CRoots are the trust anchors for stats API tokens of issuers that
+	// aren't registered, or nil if they aren't accepted.
+	inlineX5CRoots *x509.CertPool
+
+	// shadow verifies stats API tokens with the shadow config, or is nil if
+	// shadow verification is disabled. It shares the health authority cache.
+	// isShadow is true for the shadow verifier itself, which doesn't record
+	// metrics of its own.
+	shadow   *Verifier
+	isShadow bool
+
+	// clock is the time tokens and keys are checked against.
+	clock Clock
+
+	// resolver resolves the health authorities of tokens. It's a
+	// *databaseResolver unless it was replaced with NewWithResolver.
+	resolver HealthAuthorityResolver
 }
 
 // New creates a new verifier, based on this DB handle.
 func New(db *database.HealthAuthorityDB, config *Config) (*Verifier, error) {
-	cache, err := cache.New(config.CacheDuration)
+	cache, err := cache.NewWithStaleGrace(config.CacheDuration, config.CacheStaleGrace)
 	if err != nil {
 		return nil, err
 	}
-	return &Verifier{db, config, cache}, nil
+	cache.SetServeStale(config.CacheMaintenanceMode)
+	if config.CacheMaxSize > 0 {
+		// Evictions aren't tagged by issuer, as they are caused by floods of
+		// distinct issuers.
+		cache.SetMaxSize(int(config.CacheMaxSize), func(string) {
+			stats.Record(context.Background(), mCacheEviction.M(1))
+		})
+	}
+
+	if config.StatsTokenLeeway < 0 {
+		return nil, fmt.Errorf("STATS_TOKEN_LEEWAY cannot be negative")
+	}
+	if config.StatsMaxTokenLifetime < 0 {
+		return nil, fmt.Errorf("STATS_MAX_TOKEN_LIFETIME cannot be negative")
+	}
+	if err := config.HTTPSIssuerPolicy.Validate(); err != nil {
+		return nil, fmt.Errorf("VERIFICATION_HTTPS_ISSUER_POLICY: %w", err)
+	}
+	for _, alg := range config.StatsAlgorithms {
+		if !model.IsStatsSigningAlgorithm(alg) {
+			return nil, fmt.Errorf("invalid STATS_TOKEN_ALGORITHMS: unsupported algorithm %q, must be one of %s",
+				alg, strings.Join(model.StatsSigningAlgorithms, ", "))
+		}
+	}
+
+	kidPattern, err := compileKIDPattern(config.StatsKIDPattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid STATS_KID_PATTERN: %w", err)
+	}
+
+	v := &Verifier{
+		db:         db,
+		config:     config,
+		haCache:    cache,
+		kidPattern: kidPattern,
+		clock:      realClock{},
+	}
+	if config.JWKSRefreshInterval > 0 {
+		if config.JWKSStaleGrace < 0 {
+			return nil, fmt.Errorf("VERIFICATION_JWKS_STALE_GRACE cannot be negative")
+		}
+		v.jwks = newJWKSRefresher(db.ListAllHealthAuthoritiesWithKeys, config.JWKSRefreshInterval, config.JWKSStaleGrace, v.clock)
+	}
+	var secondary secondaryKeyStore
+	if config.SecondaryKeyStoreFile != "" {
+		snapshot, err := loadSnapshotKeyStore(config.SecondaryKeyStoreFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid VERIFICATION_SECONDARY_KEY_STORE_FILE: %w", err)
+		}
+		secondary = snapshot
+	}
+	v.resolver = &databaseResolver{
+		db:        db,
+		cache:     cache,
+		jwks:      v.jwks,
+		secondary: secondary,
+		loaded:    v.checkKeyVersions,
+	}
+	if config.StatsInlineX5CTrustAnchorsFile != "" {
+		roots, err := loadTrustAnchors(config.StatsInlineX5CTrustAnchorsFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid STATS_INLINE_X5C_TRUST_ANCHORS_FILE: %w", err)
+		}
+		v.inlineX5CRoots = roots
+	}
+
+	if shadowConfig := config.shadowConfig(); shadowConfig != nil {
+		shadowPattern, err := compileKIDPattern(shadowConfig.StatsKIDPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid VERIFICATION_SHADOW_STATS_KID_PATTERN: %w", err)
+		}
+		v.shadow = &Verifier{
+			db:         db,
+			config:     shadowConfig,
+			haCache:    cache,
+			kidPattern: shadowPattern,
+			jwks:       v.jwks,
+			isShadow:   true,
+			clock:      v.clock,
+		}
+		v.shadow.resolver = &databaseResolver{
+			db:        db,
+			cache:     cache,
+			jwks:      v.jwks,
+			secondary: secondary,
+			loaded:    v.shadow.checkKeyVersions,
+		}
+	}
+	return v, nil
+}
+
+// checkHTTPSIssuer applies the HTTPSIssuerPolicy to a token of the health
+// authority.
+func (v *Verifier) checkHTTPSIssuer(ctx context.Context, ha *model.HealthAuthority) error {
+	policy := v.config.HTTPSIssuerPolicy
+	if policy == "" || policy == HTTPSIssuerAllow {
+		return nil
+	}
+	err := ha.ValidateHTTPSIssuer()
+	if err == nil {
+		return nil
+	}
+	reject := policy == HTTPSIssuerReject
+
+	// The shadow verifier has the same policy, the token is only counted once.
+	if !v.isShadow {
+		logger := logging.FromContext(ctx)
+		outcome := outcomeSuccess
+		if reject {
+			outcome = outcomeFailure
+		}
+		tags := []tag.Mutator{tag.Upsert(issuerTag, ha.Issuer), outcome}
+		if err := stats.RecordWithTags(ctx, tags, mInsecureIssuer.M(1)); err != nil {
+			logger.Errorw("failed to record stats for insecure issuer", "error", err, "iss", ha.Issuer)
+		}
+		logger.Warnw("token of health authority without an https issuer", "iss", ha.Issuer, "rejected", reject)
+	}
+
+	if reject {
+		return err
+	}
+	return nil
+}
+
+// compileKIDPattern compiles the pattern 'kid' headers must match, or returns
+// nil if the pattern is empty.
+func compileKIDPattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile(pattern)
+}
+
+// SetCacheMaintenanceMode enables or disables serving cached health
+// authorities regardless of their age when the database is unavailable. When
+// disabled, the cache duration and stale grace apply again. If the cache
+// maintenance mode refresher is running, the mode in the database replaces it
+// on the next refresh, see RefreshCacheMaintenanceMode.
+func (v *Verifier) SetCacheMaintenanceMode(ctx context.Context, enabled bool) {
+	logger := logging.FromContext(ctx)
+
+	v.haCache.SetServeStale(enabled)
+	if enabled {
+		logger.Warnw("verification cache maintenance mode enabled, cached health authorities are used regardless of age if the database is unavailable")
+		return
+	}
+	logger.Infow("verification cache maintenance mode disabled")
+}
+
+// CacheMaintenanceMode returns true if cached health authorities are used
+// regardless of age when the database is unavailable.
+func (v *Verifier) CacheMaintenanceMode() bool {
+	return v.haCache.ServeStale()
 }
 
 // VerifiedClaims represents the relevant claims extracted from a verified
@@ -63,68 +263,97 @@ type VerifiedClaims struct {
 	HealthAuthorityID    int64
 	ReportType           string // blank indicates no report type was present.
 	SymptomOnsetInterval uint32 // 0 indicates no symptom onset interval present. This should be checked for "reasonable" value before application.
+
+	// CertificateID is a stable identifier of the verification certificate,
+	// see certificateID.
+	CertificateID string
+
+	// FromSecondaryKeyStore is true if the health authority's keys were read
+	// from the secondary key store, because the database was unavailable.
+	FromSecondaryKeyStore bool
 }
 
 // VerifyDiagnosisCertificate accepts a publish request (from which is extracts the JWT),
 // fully verifies the JWT and signture against what the passed in authorrized app is allowed
 // to use. Returns any transmission risk overrides if they are present.
 func (v *Verifier) VerifyDiagnosisCertificate(ctx context.Context, authApp *aamodel.AuthorizedApp, publish *verifyapi.Publish) (*VerifiedClaims, error) {
-	logger := logging.FromContext(ctx)
+	return v.VerifyDiagnosisCertificateWithHMAC(ctx, authApp, publish, "")
+}
+
+// VerifyDiagnosisCertificateWithHMAC is like VerifyDiagnosisCertificate, but
+// also accepts the base64 encoded HMAC of the keys sent outside of the
+// certificate, e.g. in a request header, by clients that don't bind it into
+// the 'tekmac' claim. An empty detachedHMAC is ignored.
+//
+// If the certificate has a 'tekmac' claim, the claim takes precedence: it must
+// match the keys, and a detached HMAC must be the same as the claim. Only if
+// the certificate has no 'tekmac' claim is the detached HMAC checked against
+// the keys instead. Such a certificate doesn't bind the keys it was issued
+// for, callers should only pass a detached HMAC if that is acceptable.
+func (v *Verifier) VerifyDiagnosisCertificateWithHMAC(ctx context.Context, authApp *aamodel.AuthorizedApp, publish *verifyapi.Publish, detachedHMAC string) (*VerifiedClaims, error) {
 	// These get assigned during the ParseWithClaims closure.
 	var healthAuthorityID int64
 	var claims *verifyapi.VerificationClaims
+	var source haSource
 
-	// Unpack JWT so we can determine issuer and key version.
-	// ParseWithClaims also calls .Valid() on the parsed token.
-	token, err := jwt.ParseWithClaims(publish.VerificationPayload, &verifyapi.VerificationClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if method, ok := token.Method.(*jwt.SigningMethodECDSA); !ok || method.Name != jwt.SigningMethodES256.Name {
-			return nil, fmt.Errorf("unsupported signing method, must be %v", jwt.SigningMethodES256.Name)
-		}
+	// Unpack JWT so we can determine issuer and key version. The time based
+	// claims are validated after parsing, at the time of the verifier's clock.
+	now := v.clock.Now()
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.ParseWithClaims(publish.VerificationPayload, &verifyapi.VerificationClaims{}, func(token *jwt.Token) (interface{}, error) {
+		// Certificates that carry a certificate chain are checked against the
+		// health authority's trust anchors, after the issuer is known.
+		x5c := hasX5C(token)
 
-		var ok bool
-		kid, ok := token.Header[verifyapi.KeyIDHeader]
-		if !ok {
-			return nil, fmt.Errorf("missing required header field, 'kid' indicating key id")
+		var kid interface{}
+		if !x5c {
+			if method, ok := token.Method.(*jwt.SigningMethodECDSA); !ok || method.Name != jwt.SigningMethodES256.Name {
+				return nil, fmt.Errorf("unsupported signing method, must be %v", jwt.SigningMethodES256.Name)
+			}
+
+			var ok bool
+			kid, ok = token.Header[verifyapi.KeyIDHeader]
+			if !ok {
+				return nil, fmt.Errorf("missing required header field, 'kid' indicating key id")
+			}
 		}
 
+		var ok bool
 		claims, ok = token.Claims.(*verifyapi.VerificationClaims)
 		if !ok {
 			return nil, fmt.Errorf("does not contain expected claim set")
 		}
 
-		lookup := func() (interface{}, error) {
-			// Based on issuer, load the key versions.
-			ha, err := v.db.GetHealthAuthority(ctx, claims.Issuer)
-			// Special case not found so that we can cache it.
-			if errors.Is(err, database.ErrHealthAuthorityNotFound) {
-				logger.Warnw("requested issuer not found", "iss", claims.Issuer)
-				return nil, nil
-			}
-			if err != nil {
-				return nil, fmt.Errorf("error looking up issuer: %v : %w", claims.Issuer, err)
-			}
-			return ha, nil
-		}
-		cacheVal, err := v.haCache.WriteThruLookup(claims.Issuer, lookup)
+		ha, haSource, err := v.lookupHealthAuthorityCached(ctx, claims.Issuer)
 		if err != nil {
 			return nil, err
 		}
-
-		if cacheVal == nil {
-			return nil, fmt.Errorf("issuer not found: %v", claims.Issuer)
+		source = haSource
+		if err := v.checkHTTPSIssuer(ctx, ha); err != nil {
+			return nil, err
 		}
 
-		ha := cacheVal.(*model.HealthAuthority)
-
 		// Advisory check the aud.
 		if claims.Audience != ha.Audience {
 			return nil, fmt.Errorf("audience mismatch for issuer: %v (+%s, -%s)", ha.Issuer, claims.Audience, ha.Audience)
 		}
 
+		if x5c {
+			key, err := x5cPublicKey(token, ha, now)
+			if err != nil {
+				return nil, err
+			}
+			healthAuthorityID = ha.ID
+			return key, nil
+		}
+
 		// Find a key version.
 		for _, hak := range ha.Keys {
 			// Key version matches and the key is valid based on the current time.
-			if hak.Version == kid && hak.IsValid() {
+			if hak.Version == kid && hak.IsValidAt(now) {
+				if !hak.AllowsUsage(model.KeyUsageCertificate) {
+					return nil, fmt.Errorf("%w: kid: %v usage: %v", ErrKeyUsage, kid, hak.Usage)
+				}
 				healthAuthorityID = ha.ID
 				// Extract the public key from the PEM block.
 				return hak.PublicKey()
@@ -133,20 +362,15 @@ func (v *Verifier) VerifyDiagnosisCertificate(ctx context.Context, authApp *aamo
 		return nil, ErrNoPublicKeys
 	})
 	if err != nil {
-		// Check for specific errors in the bitmask that may exist and
-		// convert them to application local errors.
-		validationError := new(jwt.ValidationError)
-		if errors.As(err, &validationError) {
-			if mask := validationError.Errors; mask&jwt.ValidationErrorIssuedAt != 0 || mask&jwt.ValidationErrorNotValidYet != 0 {
-				return nil, ErrNotValidYet
-			}
-		}
 		return nil, err
 	}
 
 	if !token.Valid {
 		return nil, fmt.Errorf("invalid verificationPayload")
 	}
+	if err := validateCertificateTime(&claims.StandardClaims, now); err != nil {
+		return nil, err
+	}
 
 	// JWT is valid and signature is valid.
 	// This is chacked after the signature verification to prevent timing attacks.
@@ -160,9 +384,9 @@ func (v *Verifier) VerifyDiagnosisCertificate(ctx context.Context, authApp *aamo
 	}
 
 	// Verify the HMAC.
-	jwtHMAC, err := base64util.DecodeString(claims.SignedMAC)
+	jwtHMAC, err := selectHMAC(claims.SignedMAC, detachedHMAC)
 	if err != nil {
-		return nil, fmt.Errorf("error decoding HMAC from claims: %w", err)
+		return nil, err
 	}
 	secret, err := base64util.DecodeString(publish.HMACKey)
 	if err != nil {
@@ -179,13 +403,150 @@ func (v *Verifier) VerifyDiagnosisCertificate(ctx context.Context, authApp *aamo
 		valid = valid || hmac.Equal(wantHMAC, jwtHMAC)
 	}
 	if !valid {
+		if claims.SignedMAC == "" && detachedHMAC != "" {
+			return nil, fmt.Errorf("%w: publish request does not match detached HMAC", ErrDetachedHMACMismatch)
+		}
 		return nil, fmt.Errorf("HMAC mismatch, publish request does not match disgnosis verification certificate")
 	}
 
 	// Everything looks good. Return the relevant verified claims.
 	return &VerifiedClaims{
-		HealthAuthorityID:    healthAuthorityID,
-		ReportType:           claims.ReportType,
-		SymptomOnsetInterval: claims.SymptomOnsetInterval,
+		HealthAuthorityID:     healthAuthorityID,
+		ReportType:            claims.ReportType,
+		SymptomOnsetInterval:  claims.SymptomOnsetInterval,
+		CertificateID:         certificateID(claims, publish.VerificationPayload),
+		FromSecondaryKeyStore: source == haSourceSecondary,
 	}, nil
 }
+
+// selectHMAC returns the HMAC the keys of a publish request must match, see
+// VerifyDiagnosisCertificateWithHMAC for the precedence of the claim and the
+// detached HMAC.
+func selectHMAC(claimHMAC, detachedHMAC string) ([]byte, error) {
+	var detached []byte
+	if detachedHMAC != "" {
+		var err error
+		detached, err = base64util.DecodeString(detachedHMAC)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding detached HMAC: %w", err)
+		}
+	}
+
+	if claimHMAC == "" && detached != nil {
+		return detached, nil
+	}
+
+	jwtHMAC, err := base64util.DecodeString(claimHMAC)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding HMAC from claims: %w", err)
+	}
+	if detached != nil && !hmac.Equal(jwtHMAC, detached) {
+		return nil, fmt.Errorf("%w: certificate HMAC differs from detached HMAC", ErrDetachedHMACMismatch)
+	}
+	return jwtHMAC, nil
+}
+
+// certificateID returns the issuer and 'jti' claim of the certificate if it has
+// one. Otherwise it returns the SHA-256 digest of the certificate, which is
+// the same every time the certificate is used.
+func certificateID(claims *verifyapi.VerificationClaims, certificate string) string {
+	if claims.Id != "" {
+		return JTICertificateID(claims.Issuer, claims.Id)
+	}
+	digest := sha256.Sum256([]byte(certificate))
+	return "sha256:" + hex.EncodeToString(digest[:])
+}
+
+// JTICertificateID returns the certificate ID of a certificate from the issuer
+// with the given 'jti' claim.
+func JTICertificateID(issuer, jti string) string {
+	return "jti:" + issuer + ":" + jti
+}
+
+// CertificateHash returns the hex encoded SHA-256 digest of a certificate ID.
+// It is stored with the published exposures, so they can be found by
+// certificate without storing the certificate or its claims.
+func CertificateHash(certificateID string) string {
+	digest := sha256.Sum256([]byte(certificateID))
+	return hex.EncodeToString(digest[:])
+}
+
+// lookupHealthAuthority returns the health authority and its keys for the
+// issuer from the verifier's HealthAuthorityResolver. If the issuer is
+// unknown, an error is returned.
+func (v *Verifier) lookupHealthAuthority(ctx context.Context, issuer string) (*model.HealthAuthority, error) {
+	ha, _, err := v.lookupHealthAuthorityCached(ctx, issuer)
+	return ha, err
+}
+
+// lookupHealthAuthorityCached is lookupHealthAuthority, but also returns where
+// the health authority was read from.
+func (v *Verifier) lookupHealthAuthorityCached(ctx context.Context, issuer string) (*model.HealthAuthority, haSource, error) {
+	var ha *model.HealthAuthority
+	var err error
+	source := haSourceResolver
+	if r, ok := v.resolver.(sourceResolver); ok {
+		ha, source, err = r.resolveHealthAuthorityWithSource(ctx, issuer)
+	} else {
+		ha, err = v.resolver.ResolveHealthAuthority(ctx, issuer)
+	}
+
+	if errors.Is(err, database.ErrHealthAuthorityNotFound) || (err == nil && ha == nil) {
+		return nil, source, fmt.Errorf("issuer not found: %v", issuer)
+	}
+	if err != nil {
+		return nil, source, fmt.Errorf("error looking up issuer: %v : %w", issuer, err)
+	}
+	if ha.Issuer != issuer {
+		return nil, source, fmt.Errorf("health authority resolver returned issuer %v for %v", ha.Issuer, issuer)
+	}
+	return ha, source, nil
+}
+
+// checkKeyVersions records a metric if the health authority has more active key
+// versions than configured, or more than one key for the same version. The
+// health authority is still usable, this only surfaces existing data that
+// would no longer be accepted.
+func (v *Verifier) checkKeyVersions(ctx context.Context, ha *model.HealthAuthority) {
+	v.checkDuplicateKeyVersions(ctx, ha)
+
+	max := v.config.MaxActiveKeyVersions
+	if max == 0 {
+		return
+	}
+
+	active := ha.ActiveKeyVersions()
+	if active <= int(max) {
+		return
+	}
+
+	logger := logging.FromContext(ctx)
+	logger.Warnw("health authority has too many active key versions",
+		"iss", ha.Issuer,
+		"active", active,
+		"max", max)
+	tags := []tag.Mutator{tag.Upsert(issuerTag, ha.Issuer)}
+	if err := stats.RecordWithTags(ctx, tags, mTooManyKeyVersions.M(1)); err != nil {
+		logger.Errorw("failed to record stats for too many key versions", "error", err, "iss", ha.Issuer)
+	}
+}
+
+// checkDuplicateKeyVersions logs a warning and records a metric if the health
+// authority has more than one key for the same version. Tokens with that 'kid'
+// are verified with whichever key is found first, unless RejectDuplicateKIDs
+// is set.
+func (v *Verifier) checkDuplicateKeyVersions(ctx context.Context, ha *model.HealthAuthority) {
+	duplicates := ha.DuplicateKeyVersions()
+	if len(duplicates) == 0 {
+		return
+	}
+
+	logger := logging.FromContext(ctx)
+	logger.Warnw("health authority has duplicate key versions",
+		"iss", ha.Issuer,
+		"versions", duplicates)
+	tags := []tag.Mutator{tag.Upsert(issuerTag, ha.Issuer)}
+	if err := stats.RecordWithTags(ctx, tags, mDuplicateKeyVersions.M(1)); err != nil {
+		logger.Errorw("failed to record stats for duplicate key versions", "error", err, "iss", ha.Issuer)
+	}
+}
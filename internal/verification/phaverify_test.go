@@ -25,6 +25,7 @@ import (
 	"encoding/base64"
 	"encoding/pem"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -34,6 +35,7 @@ import (
 	"github.com/google/exposure-notifications-server/internal/project"
 	"github.com/google/exposure-notifications-server/internal/verification/database"
 	"github.com/google/exposure-notifications-server/internal/verification/model"
+	"github.com/google/exposure-notifications-server/internal/verification/verifytest"
 	"github.com/google/go-cmp/cmp"
 
 	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
@@ -245,7 +247,7 @@ func TestVerifyCertificate(t *testing.T) {
 					publish.HMACKey = tc.MacKeyAdjustment + hmacKeyB64
 
 					// Actually test the verify code.
-					verifier, err := New(haDB, &Config{time.Nanosecond, "audience"})
+					verifier, err := New(haDB, &Config{CacheDuration: time.Nanosecond, StatsAudience: "audience"})
 					if err != nil {
 						t.Fatal(err)
 					}
@@ -261,6 +263,7 @@ func TestVerifyCertificate(t *testing.T) {
 							HealthAuthorityID:    healthAuthority.ID,
 							ReportType:           "confirmed",
 							SymptomOnsetInterval: 250250,
+							CertificateID:        certificateID(&verifyapi.VerificationClaims{}, jwtText),
 						}
 						if diff := cmp.Diff(want, verifiedClaims); diff != "" {
 							t.Errorf("claims mismatch (-want, +got):\n%s", diff)
@@ -271,3 +274,411 @@ func TestVerifyCertificate(t *testing.T) {
 		}
 	}
 }
+
+func TestVerifyKeyUsage(t *testing.T) {
+	t.Parallel()
+
+	statsAudience := "test-stats-aud"
+
+	cases := []struct {
+		name     string
+		usage    model.KeyUsage
+		certErr  string
+		statsErr string
+	}{
+		{
+			name:  "any",
+			usage: model.KeyUsageAny,
+		},
+		{
+			name:     "certificate_only",
+			usage:    model.KeyUsageCertificate,
+			statsErr: ErrKeyUsage.Error(),
+		},
+		{
+			name:    "stats_only",
+			usage:   model.KeyUsageStats,
+			certErr: ErrKeyUsage.Error(),
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := project.TestContext(t)
+
+			ha := verifytest.NewHealthAuthority(t, "usage-"+tc.name+".test.health")
+			ha.HealthAuthority.ID = 9
+			ha.Key.Usage = tc.usage
+
+			verifier, err := New(nil, &Config{CacheDuration: time.Hour, StatsAudience: statsAudience})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ha.Cache(t, verifier)
+
+			authApp := aamodel.NewAuthorizedApp()
+			authApp.AllowedHealthAuthorityIDs[ha.HealthAuthority.ID] = struct{}{}
+
+			hmacKey := make([]byte, 32)
+			if _, err := rand.Read(hmacKey); err != nil {
+				t.Fatal(err)
+			}
+			publish := verifyapi.Publish{
+				Keys: []verifyapi.ExposureKey{
+					{
+						Key:              "IRgYIhYiy4WMl9z68bMk6w==",
+						IntervalNumber:   2650032,
+						IntervalCount:    144,
+						TransmissionRisk: 4,
+					},
+				},
+				HMACKey: base64.StdEncoding.EncodeToString(hmacKey),
+			}
+			allHMACs, err := utils.CalculateAllAllowedExposureKeyHMAC(publish.Keys, hmacKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			claims := verifyapi.NewVerificationClaims()
+			claims.Audience = ha.HealthAuthority.Audience
+			claims.Issuer = ha.HealthAuthority.Issuer
+			claims.IssuedAt = time.Now().Unix()
+			claims.ExpiresAt = time.Now().Add(5 * time.Minute).Unix()
+			claims.SignedMAC = base64.StdEncoding.EncodeToString(allHMACs[0])
+			claims.ReportType = verifyapi.ReportTypeConfirmed
+
+			token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+			token.Header[verifyapi.KeyIDHeader] = verifytest.DefaultKeyVersion
+			publish.VerificationPayload, err = token.SignedString(ha.PrivateKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = verifier.VerifyDiagnosisCertificate(ctx, authApp, &publish)
+			errcmp.MustMatch(t, err, tc.certErr)
+
+			_, err = verifier.AuthenticateStatsToken(ctx, ha.StatsToken(t, statsAudience))
+			errcmp.MustMatch(t, err, tc.statsErr)
+		})
+	}
+}
+
+func TestCertificateID(t *testing.T) {
+	t.Parallel()
+
+	withJTI := verifyapi.NewVerificationClaims()
+	withJTI.Issuer = "doh.mystate.gov"
+	withJTI.Id = "abc123"
+	if got, want := certificateID(withJTI, "header.payload.signature"), "jti:doh.mystate.gov:abc123"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+
+	withoutJTI := verifyapi.NewVerificationClaims()
+	withoutJTI.Issuer = "doh.mystate.gov"
+	got := certificateID(withoutJTI, "header.payload.signature")
+	if want := "sha256:"; !strings.HasPrefix(got, want) {
+		t.Errorf("expected %q to start with %q", got, want)
+	}
+	if again := certificateID(withoutJTI, "header.payload.signature"); got != again {
+		t.Errorf("expected the same certificate to have the same ID, got %q and %q", got, again)
+	}
+	if other := certificateID(withoutJTI, "header.payload.signature2"); got == other {
+		t.Errorf("expected different certificates to have different IDs, got %q", got)
+	}
+}
+
+func TestCertificateHash(t *testing.T) {
+	t.Parallel()
+
+	id := JTICertificateID("doh.mystate.gov", "abc123")
+	got := CertificateHash(id)
+	if len(got) != 64 {
+		t.Errorf("expected a hex encoded SHA-256 digest, got %q", got)
+	}
+	if strings.Contains(got, "abc123") {
+		t.Errorf("expected %q to not contain the certificate ID", got)
+	}
+	if again := CertificateHash(id); got != again {
+		t.Errorf("expected the same certificate ID to have the same hash, got %q and %q", got, again)
+	}
+	if other := CertificateHash(JTICertificateID("doh.mystate.gov", "abc124")); got == other {
+		t.Errorf("expected different certificate IDs to have different hashes, got %q", got)
+	}
+}
+
+func TestVerifyCertificate_DetachedHMAC(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	ha := verifytest.NewHealthAuthority(t, "detached-hmac.test.health")
+	ha.HealthAuthority.ID = 12
+
+	verifier, err := New(nil, &Config{CacheDuration: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ha.Cache(t, verifier)
+
+	authApp := aamodel.NewAuthorizedApp()
+	authApp.AllowedHealthAuthorityIDs[ha.HealthAuthority.ID] = struct{}{}
+
+	hmacKey := make([]byte, 32)
+	if _, err := rand.Read(hmacKey); err != nil {
+		t.Fatal(err)
+	}
+	keys := []verifyapi.ExposureKey{
+		{
+			Key:              "IRgYIhYiy4WMl9z68bMk6w==",
+			IntervalNumber:   2650032,
+			IntervalCount:    144,
+			TransmissionRisk: 4,
+		},
+	}
+	allHMACs, err := utils.CalculateAllAllowedExposureKeyHMAC(keys, hmacKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validHMAC := base64.StdEncoding.EncodeToString(allHMACs[0])
+	otherHMAC := base64.StdEncoding.EncodeToString(make([]byte, len(allHMACs[0])))
+
+	cases := []struct {
+		name     string
+		claim    string
+		detached string
+		err      string
+	}{
+		{
+			name:  "claim_only",
+			claim: validHMAC,
+		},
+		{
+			name:     "detached_only",
+			detached: validHMAC,
+		},
+		{
+			name:     "detached_only_mismatch",
+			detached: otherHMAC,
+			err:      ErrDetachedHMACMismatch.Error(),
+		},
+		{
+			name:     "detached_only_invalid",
+			detached: "not base64!",
+			err:      "error decoding detached HMAC",
+		},
+		{
+			name:     "both_match",
+			claim:    validHMAC,
+			detached: validHMAC,
+		},
+		{
+			// The claim takes precedence, a detached HMAC can't replace it.
+			name:     "both_differ",
+			claim:    validHMAC,
+			detached: otherHMAC,
+			err:      ErrDetachedHMACMismatch.Error(),
+		},
+		{
+			// Even if the detached HMAC matches the keys, the claim must.
+			name:     "both_claim_mismatch",
+			claim:    otherHMAC,
+			detached: validHMAC,
+			err:      ErrDetachedHMACMismatch.Error(),
+		},
+		{
+			name:  "neither",
+			claim: "",
+			err:   "HMAC mismatch",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			claims := verifyapi.NewVerificationClaims()
+			claims.Audience = ha.HealthAuthority.Audience
+			claims.Issuer = ha.HealthAuthority.Issuer
+			claims.IssuedAt = time.Now().Unix()
+			claims.ExpiresAt = time.Now().Add(5 * time.Minute).Unix()
+			claims.SignedMAC = tc.claim
+			claims.ReportType = verifyapi.ReportTypeConfirmed
+
+			token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+			token.Header[verifyapi.KeyIDHeader] = verifytest.DefaultKeyVersion
+			payload, err := token.SignedString(ha.PrivateKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			publish := verifyapi.Publish{
+				Keys:                keys,
+				HMACKey:             base64.StdEncoding.EncodeToString(hmacKey),
+				VerificationPayload: payload,
+			}
+			verifiedClaims, err := verifier.VerifyDiagnosisCertificateWithHMAC(ctx, authApp, &publish, tc.detached)
+			errcmp.MustMatch(t, err, tc.err)
+			if tc.err == "" {
+				if got, want := verifiedClaims.HealthAuthorityID, ha.HealthAuthority.ID; got != want {
+					t.Errorf("expected health authority %d to be %d", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPSIssuerPolicy(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	statsAudience := "test-stats-aud"
+
+	_, err := New(nil, &Config{HTTPSIssuerPolicy: "banana"})
+	errcmp.MustMatch(t, err, "unknown https issuer policy")
+
+	hmacKey := make([]byte, 32)
+	if _, err := rand.Read(hmacKey); err != nil {
+		t.Fatal(err)
+	}
+	keys := []verifyapi.ExposureKey{
+		{
+			Key:              "IRgYIhYiy4WMl9z68bMk6w==",
+			IntervalNumber:   2650032,
+			IntervalCount:    144,
+			TransmissionRisk: 4,
+		},
+	}
+	allHMACs, err := utils.CalculateAllAllowedExposureKeyHMAC(keys, hmacKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		issuer string
+		policy HTTPSIssuerPolicy
+		err    string
+	}{
+		{name: "default_http", issuer: "http://doh.mystate.gov"},
+		{name: "allow_http", issuer: "http://doh.mystate.gov", policy: HTTPSIssuerAllow},
+		{name: "warn_http", issuer: "http://doh.mystate.gov", policy: HTTPSIssuerWarn},
+		{name: "reject_http", issuer: "http://doh.mystate.gov", policy: HTTPSIssuerReject, err: model.ErrInsecureIssuer.Error()},
+		{name: "reject_not_url", issuer: "doh.mystate.gov", policy: HTTPSIssuerReject, err: model.ErrInsecureIssuer.Error()},
+		{name: "reject_https", issuer: "https://doh.mystate.gov", policy: HTTPSIssuerReject},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			verifier, err := New(nil, &Config{
+				CacheDuration:     time.Hour,
+				StatsAudience:     statsAudience,
+				HTTPSIssuerPolicy: tc.policy,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ha := verifytest.NewHealthAuthority(t, tc.issuer)
+			ha.HealthAuthority.ID = 41
+			ha.Cache(t, verifier)
+
+			// Stats API tokens.
+			_, err = verifier.AuthenticateStatsToken(ctx, ha.StatsToken(t, statsAudience))
+			errcmp.MustMatch(t, err, tc.err)
+
+			// Verification certificates.
+			authApp := aamodel.NewAuthorizedApp()
+			authApp.AllowedHealthAuthorityIDs[ha.HealthAuthority.ID] = struct{}{}
+
+			claims := verifyapi.NewVerificationClaims()
+			claims.Audience = ha.HealthAuthority.Audience
+			claims.Issuer = ha.HealthAuthority.Issuer
+			claims.IssuedAt = time.Now().Unix()
+			claims.ExpiresAt = time.Now().Add(5 * time.Minute).Unix()
+			claims.SignedMAC = base64.StdEncoding.EncodeToString(allHMACs[0])
+			claims.ReportType = verifyapi.ReportTypeConfirmed
+
+			token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+			token.Header[verifyapi.KeyIDHeader] = verifytest.DefaultKeyVersion
+			payload, err := token.SignedString(ha.PrivateKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = verifier.VerifyDiagnosisCertificate(ctx, authApp, &verifyapi.Publish{
+				Keys:                keys,
+				HMACKey:             base64.StdEncoding.EncodeToString(hmacKey),
+				VerificationPayload: payload,
+			})
+			errcmp.MustMatch(t, err, tc.err)
+		})
+	}
+}
+
+// TestCacheMaxSize tests that the health authority cache never holds more than
+// CacheMaxSize issuers, and that evicted health authorities are read from the
+// database again instead of being rejected.
+func TestCacheMaxSize(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	statsAudience := "test-stats-aud"
+
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	haDB := database.New(testDB)
+
+	verifier, err := New(haDB, &Config{
+		CacheDuration: time.Hour,
+		CacheMaxSize:  2,
+		StatsAudience: statsAudience,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	has := make([]*verifytest.HealthAuthority, 0, 3)
+	for i := 0; i < 3; i++ {
+		ha := verifytest.NewHealthAuthority(t, fmt.Sprintf("cache%d.test.health", i))
+		ha.Store(ctx, t, haDB)
+		has = append(has, ha)
+	}
+
+	checkSize := func(t *testing.T) {
+		t.Helper()
+		if got := verifier.haCache.Size(); got > 2 {
+			t.Fatalf("expected at most 2 cached issuers, got %d", got)
+		}
+	}
+
+	// Flood the cache with unknown issuers.
+	for i := 0; i < 10; i++ {
+		unknown := verifytest.NewHealthAuthority(t, fmt.Sprintf("unknown%d.test.health", i))
+		_, err := verifier.AuthenticateStatsToken(ctx, unknown.StatsToken(t, statsAudience))
+		errcmp.MustMatch(t, err, "issuer not found")
+		checkSize(t)
+	}
+
+	// Known health authorities evict each other, but are still accepted.
+	for round := 0; round < 2; round++ {
+		for _, ha := range has {
+			id, err := verifier.AuthenticateStatsToken(ctx, ha.StatsToken(t, statsAudience))
+			if err != nil {
+				t.Fatalf("round %d: %s: %v", round, ha.HealthAuthority.Issuer, err)
+			}
+			if id != ha.HealthAuthority.ID {
+				t.Errorf("round %d: %s: expected health authority %d, got %d", round, ha.HealthAuthority.Issuer, ha.HealthAuthority.ID, id)
+			}
+			checkSize(t)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/exposure-notifications-server/internal/verification/database"
+	"github.com/google/exposure-notifications-server/internal/verification/model"
+	"github.com/google/exposure-notifications-server/pkg/cache"
+	"github.com/google/exposure-notifications-server/pkg/logging"
+)
+
+// HealthAuthorityResolver resolves the issuer of a token to the health
+// authority and its keys. The verifier looks up every health authority with
+// its resolver. By default that is the database, through the verifier's
+// cache, but it can be replaced with NewWithResolver, e.g. to use a central
+// identity service. The verifier doesn't cache what a replacement returns,
+// caching is up to the resolver. An unknown issuer is reported with
+// database.ErrHealthAuthorityNotFound.
+type HealthAuthorityResolver interface {
+	ResolveHealthAuthority(ctx context.Context, issuer string) (*model.HealthAuthority, error)
+}
+
+// sourceResolver is a HealthAuthorityResolver that also reports where the
+// health authority was read from, for metrics. Health authorities from other
+// resolvers are reported as haSourceResolver.
+type sourceResolver interface {
+	resolveHealthAuthorityWithSource(ctx context.Context, issuer string) (*model.HealthAuthority, haSource, error)
+}
+
+// databaseResolver is the default HealthAuthorityResolver. It reads health
+// authorities from the database, through the cache. If refreshing an expired
+// cache entry fails, the expired entry is used for up to the configured stale
+// grace period, and after that the secondary key store is consulted, if there
+// is one. Health authorities loaded by the JWKS refresher are used as is.
+type databaseResolver struct {
+	db        *database.HealthAuthorityDB
+	cache     *cache.Cache
+	jwks      *jwksRefresher
+	secondary secondaryKeyStore
+
+	// loaded is called with each health authority read from the database.
+	loaded func(ctx context.Context, ha *model.HealthAuthority)
+}
+
+// ResolveHealthAuthority implements HealthAuthorityResolver.
+func (r *databaseResolver) ResolveHealthAuthority(ctx context.Context, issuer string) (*model.HealthAuthority, error) {
+	ha, _, err := r.resolveHealthAuthorityWithSource(ctx, issuer)
+	return ha, err
+}
+
+func (r *databaseResolver) resolveHealthAuthorityWithSource(ctx context.Context, issuer string) (*model.HealthAuthority, haSource, error) {
+	logger := logging.FromContext(ctx)
+
+	if ha, ok := r.lookupJWKS(ctx, issuer); ok {
+		return ha, haSourceCache, nil
+	}
+
+	source := haSourceCache
+	lookup := func() (interface{}, error) {
+		source = haSourceDatabase
+		// Based on issuer, load the key versions.
+		ha, err := r.db.GetHealthAuthority(ctx, issuer)
+		// Special case not found so that we can cache it.
+		if errors.Is(err, database.ErrHealthAuthorityNotFound) {
+			logger.Warnw("requested issuer not found", "iss", issuer)
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if r.loaded != nil {
+			r.loaded(ctx, ha)
+		}
+		return ha, nil
+	}
+	cacheVal, stale, err := r.cache.WriteThruLookupWithStale(issuer, lookup)
+	if err != nil {
+		ha, err := r.lookupSecondary(ctx, issuer, err)
+		if err != nil {
+			return nil, source, err
+		}
+		return ha, haSourceSecondary, nil
+	}
+	if stale {
+		if r.cache.ServeStale() {
+			logger.Warnw("failed to refresh health authority in maintenance mode, using stale cached value", "iss", issuer)
+		} else {
+			logger.Warnw("failed to refresh health authority, using stale cached value", "iss", issuer)
+		}
+	}
+
+	if cacheVal == nil {
+		return nil, source, database.ErrHealthAuthorityNotFound
+	}
+
+	ha, ok := cacheVal.(*model.HealthAuthority)
+	if !ok {
+		return nil, source, fmt.Errorf("incorrect type in cache: %T", cacheVal)
+	}
+	return ha, source, nil
+}
+
+// NewWithResolver creates a new verifier that resolves health authorities
+// with the resolver, instead of reading them from the database through the
+// cache. The database specific features, JWKS refreshes and the secondary key
+// store, can't be used with it.
+func NewWithResolver(resolver HealthAuthorityResolver, config *Config) (*Verifier, error) {
+	if resolver == nil {
+		return nil, fmt.Errorf("missing health authority resolver")
+	}
+	if config.JWKSRefreshInterval > 0 {
+		return nil, fmt.Errorf("VERIFICATION_JWKS_REFRESH_INTERVAL cannot be used with a health authority resolver")
+	}
+	if config.SecondaryKeyStoreFile != "" {
+		return nil, fmt.Errorf("VERIFICATION_SECONDARY_KEY_STORE_FILE cannot be used with a health authority resolver")
+	}
+
+	v, err := New(nil, config)
+	if err != nil {
+		return nil, err
+	}
+	v.resolver = resolver
+	if v.shadow != nil {
+		v.shadow.resolver = resolver
+	}
+	return v, nil
+}
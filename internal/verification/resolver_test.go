@@ -0,0 +1,281 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verification
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	aamodel "github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/exposure-notifications-server/internal/verification/database"
+	"github.com/google/exposure-notifications-server/internal/verification/model"
+	"github.com/google/exposure-notifications-server/internal/verification/verifytest"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
+	"github.com/google/exposure-notifications-server/pkg/errcmp"
+	utils "github.com/google/exposure-notifications-server/pkg/verification"
+)
+
+// memoryResolver is a HealthAuthorityResolver of health authorities held in
+// memory. It counts the lookups of each issuer.
+type memoryResolver struct {
+	mu      sync.Mutex
+	has     map[string]*model.HealthAuthority
+	lookups map[string]int
+	err     error
+}
+
+func newMemoryResolver(has ...*verifytest.HealthAuthority) *memoryResolver {
+	r := &memoryResolver{
+		has:     make(map[string]*model.HealthAuthority, len(has)),
+		lookups: make(map[string]int),
+	}
+	for _, h := range has {
+		ha := *h.HealthAuthority
+		ha.Keys = []*model.HealthAuthorityKey{h.Key}
+		r.has[ha.Issuer] = &ha
+	}
+	return r
+}
+
+func (r *memoryResolver) ResolveHealthAuthority(_ context.Context, issuer string) (*model.HealthAuthority, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lookups[issuer]++
+	if r.err != nil {
+		return nil, r.err
+	}
+	ha, ok := r.has[issuer]
+	if !ok {
+		return nil, database.ErrHealthAuthorityNotFound
+	}
+	return ha, nil
+}
+
+func (r *memoryResolver) lookupCount(issuer string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lookups[issuer]
+}
+
+func TestNewWithResolver(t *testing.T) {
+	t.Parallel()
+
+	resolver := newMemoryResolver()
+
+	_, err := NewWithResolver(nil, &Config{CacheDuration: time.Minute})
+	errcmp.MustMatch(t, err, "missing health authority resolver")
+
+	_, err = NewWithResolver(resolver, &Config{CacheDuration: time.Minute, JWKSRefreshInterval: time.Minute})
+	errcmp.MustMatch(t, err, "VERIFICATION_JWKS_REFRESH_INTERVAL cannot be used")
+
+	_, err = NewWithResolver(resolver, &Config{CacheDuration: time.Minute, SecondaryKeyStoreFile: "snapshot.json"})
+	errcmp.MustMatch(t, err, "VERIFICATION_SECONDARY_KEY_STORE_FILE cannot be used")
+
+	verifier, err := NewWithResolver(resolver, &Config{
+		CacheDuration: time.Minute,
+		Shadow:        ShadowConfig{Enabled: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verifier.shadow == nil || verifier.shadow.resolver != resolver {
+		t.Errorf("expected the shadow verifier to use the resolver")
+	}
+}
+
+func TestNew_DatabaseResolver(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	verifier, err := New(nil, &Config{
+		CacheDuration: time.Minute,
+		Shadow:        ShadowConfig{Enabled: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver, ok := verifier.resolver.(*databaseResolver)
+	if !ok {
+		t.Fatalf("expected the default resolver to be a *databaseResolver, got %T", verifier.resolver)
+	}
+	if _, ok := verifier.shadow.resolver.(*databaseResolver); !ok {
+		t.Fatalf("expected the shadow resolver to be a *databaseResolver, got %T", verifier.shadow.resolver)
+	}
+
+	// Cached health authorities are resolved through the interface, without
+	// reading the database.
+	ha := verifytest.NewHealthAuthority(t, "cached.test.health")
+	ha.Cache(t, verifier)
+
+	got, err := resolver.ResolveHealthAuthority(ctx, "cached.test.health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Issuer != "cached.test.health" || len(got.Keys) != 1 {
+		t.Errorf("expected the cached health authority, got %#v", got)
+	}
+	if _, err := verifier.shadow.resolver.ResolveHealthAuthority(ctx, "cached.test.health"); err != nil {
+		t.Errorf("expected the shadow resolver to share the cache: %v", err)
+	}
+}
+
+func TestAuthenticateStatsToken_Resolver(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	statsAudience := "test-stats-aud"
+
+	ha := verifytest.NewHealthAuthority(t, "resolver.test.health")
+	ha.HealthAuthority.ID = 41
+	unknown := verifytest.NewHealthAuthority(t, "unknown.test.health")
+	resolver := newMemoryResolver(ha)
+
+	verifier, err := NewWithResolver(resolver, &Config{
+		CacheDuration: time.Minute,
+		StatsAudience: statsAudience,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The cache isn't consulted, every token is verified with the keys of the
+	// resolver.
+	for i := 1; i <= 2; i++ {
+		id, err := verifier.AuthenticateStatsToken(ctx, ha.StatsToken(t, statsAudience))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := id, ha.HealthAuthority.ID; got != want {
+			t.Errorf("expected health authority %d to be %d", got, want)
+		}
+		if got, want := resolver.lookupCount(ha.HealthAuthority.Issuer), i; got != want {
+			t.Errorf("expected %d lookups, got %d", want, got)
+		}
+	}
+
+	_, err = verifier.AuthenticateStatsToken(ctx, ha.InvalidStatsToken(t, statsAudience))
+	errcmp.MustMatch(t, err, "crypto/ecdsa: verification error")
+
+	_, err = verifier.AuthenticateStatsToken(ctx, unknown.StatsToken(t, statsAudience))
+	errcmp.MustMatch(t, err, "issuer not found: unknown.test.health")
+
+	// A health authority in the cache doesn't matter either.
+	unknown.Cache(t, verifier)
+	_, err = verifier.AuthenticateStatsToken(ctx, unknown.StatsToken(t, statsAudience))
+	errcmp.MustMatch(t, err, "issuer not found: unknown.test.health")
+
+	resolver.mu.Lock()
+	resolver.err = errors.New("identity service unavailable")
+	resolver.mu.Unlock()
+	_, err = verifier.AuthenticateStatsToken(ctx, ha.StatsToken(t, statsAudience))
+	errcmp.MustMatch(t, err, "identity service unavailable")
+}
+
+func TestAuthenticateStatsToken_ResolverIssuerMismatch(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	statsAudience := "test-stats-aud"
+
+	// The resolver returns another health authority with the same key.
+	ha := verifytest.NewHealthAuthority(t, "mismatch.test.health")
+	resolver := newMemoryResolver(ha)
+	other := *resolver.has[ha.HealthAuthority.Issuer]
+	other.Issuer = "other.test.health"
+	resolver.has[ha.HealthAuthority.Issuer] = &other
+
+	verifier, err := NewWithResolver(resolver, &Config{
+		CacheDuration: time.Minute,
+		StatsAudience: statsAudience,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = verifier.AuthenticateStatsToken(ctx, ha.StatsToken(t, statsAudience))
+	errcmp.MustMatch(t, err, "health authority resolver returned issuer other.test.health for mismatch.test.health")
+}
+
+func TestVerifyCertificate_Resolver(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+
+	ha := verifytest.NewHealthAuthority(t, "resolver-cert.test.health")
+	ha.HealthAuthority.ID = 42
+	verifier, err := NewWithResolver(newMemoryResolver(ha), &Config{CacheDuration: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hmacKey := make([]byte, 32)
+	if _, err := rand.Read(hmacKey); err != nil {
+		t.Fatal(err)
+	}
+	keys := []verifyapi.ExposureKey{
+		{
+			Key:              "IRgYIhYiy4WMl9z68bMk6w==",
+			IntervalNumber:   2650032,
+			IntervalCount:    144,
+			TransmissionRisk: 4,
+		},
+	}
+	allHMACs, err := utils.CalculateAllAllowedExposureKeyHMAC(keys, hmacKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := verifyapi.NewVerificationClaims()
+	claims.Audience = ha.HealthAuthority.Audience
+	claims.Issuer = ha.HealthAuthority.Issuer
+	claims.IssuedAt = time.Now().Unix()
+	claims.ExpiresAt = time.Now().Add(5 * time.Minute).Unix()
+	claims.SignedMAC = base64.StdEncoding.EncodeToString(allHMACs[0])
+	claims.ReportType = verifyapi.ReportTypeConfirmed
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header[verifyapi.KeyIDHeader] = verifytest.DefaultKeyVersion
+	payload, err := token.SignedString(ha.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authApp := aamodel.NewAuthorizedApp()
+	authApp.AllowedHealthAuthorityIDs[ha.HealthAuthority.ID] = struct{}{}
+
+	verifiedClaims, err := verifier.VerifyDiagnosisCertificate(ctx, authApp, &verifyapi.Publish{
+		Keys:                keys,
+		HMACKey:             base64.StdEncoding.EncodeToString(hmacKey),
+		VerificationPayload: payload,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := verifiedClaims.HealthAuthorityID, ha.HealthAuthority.ID; got != want {
+		t.Errorf("expected health authority %d to be %d", got, want)
+	}
+	if verifiedClaims.FromSecondaryKeyStore {
+		t.Errorf("expected the health authority to not be from the secondary key store")
+	}
+}
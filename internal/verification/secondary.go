@@ -0,0 +1,123 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verification
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/google/exposure-notifications-server/internal/verification/database"
+	"github.com/google/exposure-notifications-server/internal/verification/model"
+	"github.com/google/exposure-notifications-server/pkg/logging"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+// haSource is where a health authority was looked up from.
+type haSource int
+
+const (
+	haSourceCache haSource = iota
+	haSourceDatabase
+	haSourceSecondary
+	haSourceResolver
+)
+
+// unknownIssuer is the issuer tag of secondary key store lookups that failed.
+const unknownIssuer = "unknown"
+
+// secondaryKeyStore is a read-only source of health authorities that is
+// consulted when they can't be read from the database. A
+// *database.HealthAuthorityDB for a read replica also implements it.
+type secondaryKeyStore interface {
+	GetHealthAuthority(ctx context.Context, issuer string) (*model.HealthAuthority, error)
+}
+
+// snapshotKeyStore is a secondaryKeyStore backed by a JSON snapshot of the
+// health authorities and their keys, the JSON encoding of a list of
+// model.HealthAuthority. The snapshot is read once, when it is loaded.
+type snapshotKeyStore struct {
+	byIssuer map[string]*model.HealthAuthority
+}
+
+// loadSnapshotKeyStore reads the snapshot file at path.
+func loadSnapshotKeyStore(path string) (*snapshotKeyStore, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key store snapshot: %w", err)
+	}
+	var has []*model.HealthAuthority
+	if err := json.Unmarshal(b, &has); err != nil {
+		return nil, fmt.Errorf("failed to parse key store snapshot: %w", err)
+	}
+
+	s := &snapshotKeyStore{byIssuer: make(map[string]*model.HealthAuthority, len(has))}
+	for i, ha := range has {
+		if ha == nil || ha.Issuer == "" {
+			return nil, fmt.Errorf("key store snapshot: health authority %d has no issuer", i)
+		}
+		if _, ok := s.byIssuer[ha.Issuer]; ok {
+			return nil, fmt.Errorf("key store snapshot: duplicate issuer %q", ha.Issuer)
+		}
+		s.byIssuer[ha.Issuer] = ha
+	}
+	return s, nil
+}
+
+// GetHealthAuthority returns the health authority for the issuer, or
+// database.ErrHealthAuthorityNotFound if it isn't in the snapshot.
+func (s *snapshotKeyStore) GetHealthAuthority(_ context.Context, issuer string) (*model.HealthAuthority, error) {
+	ha, ok := s.byIssuer[issuer]
+	if !ok {
+		return nil, database.ErrHealthAuthorityNotFound
+	}
+	return ha, nil
+}
+
+// lookupSecondary looks up the health authority in the secondary key store,
+// after the database lookup failed with primaryErr and no cached value could
+// be used. The health authority isn't cached, so the database is tried again
+// on the next lookup. If there's no secondary key store or it doesn't have the
+// issuer either, primaryErr is returned.
+func (r *databaseResolver) lookupSecondary(ctx context.Context, issuer string, primaryErr error) (*model.HealthAuthority, error) {
+	if r.secondary == nil {
+		return nil, primaryErr
+	}
+
+	logger := logging.FromContext(ctx)
+	ha, err := r.secondary.GetHealthAuthority(ctx, issuer)
+
+	// The issuer comes from the unverified token, so it's only used as a tag
+	// once it's known to belong to a health authority.
+	outcome, issuerValue := outcomeSuccess, issuer
+	if err != nil {
+		outcome, issuerValue = outcomeFailure, unknownIssuer
+	}
+	tags := []tag.Mutator{tag.Upsert(issuerTag, issuerValue), outcome}
+	if err := stats.RecordWithTags(ctx, tags, mSecondaryKeyStoreLookup.M(1)); err != nil {
+		logger.Errorw("failed to record stats for secondary key store lookup", "error", err, "iss", issuer)
+	}
+
+	if err != nil {
+		logger.Errorw("failed to look up health authority in the secondary key store",
+			"iss", issuer, "error", err, "primaryError", primaryErr)
+		return nil, primaryErr
+	}
+	logger.Warnw("failed to look up health authority, using the secondary key store",
+		"iss", issuer, "primaryError", primaryErr)
+	return ha, nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verification
+
+import (
+	"context"
+
+	"github.com/google/exposure-notifications-server/pkg/logging"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+const (
+	shadowAccept = "ACCEPT"
+	shadowReject = "REJECT"
+)
+
+// shadowStatsToken verifies the stats token again with the shadow config, if
+// enabled, and records the outcome next to the live one. liveErr is the result
+// of the live verification, which is what the caller responds with.
+func (v *Verifier) shadowStatsToken(ctx context.Context, rawToken string, body []byte, checkBody bool, liveErr error) {
+	if v.shadow == nil {
+		return
+	}
+
+	_, shadowErr := v.shadow.authenticateStatsToken(ctx, rawToken, body, checkBody)
+
+	live, shadow := shadowOutcome(liveErr), shadowOutcome(shadowErr)
+	logger := logging.FromContext(ctx)
+	tags := []tag.Mutator{tag.Upsert(liveTag, live), tag.Upsert(shadowTag, shadow)}
+	if err := stats.RecordWithTags(ctx, tags, mShadowStatsToken.M(1)); err != nil {
+		logger.Errorw("failed to record stats for shadow verification", "error", err)
+	}
+	if live != shadow {
+		logger.Warnw("shadow stats token verification differs from live verification",
+			"live", live, "liveError", liveErr,
+			"shadow", shadow, "shadowError", shadowErr)
+	}
+}
+
+// shadowOutcome returns the outcome of a verification for the shadow metric.
+func shadowOutcome(err error) string {
+	if err != nil {
+		return shadowReject
+	}
+	return shadowAccept
+}
@@ -0,0 +1,167 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verification
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/exposure-notifications-server/internal/verification/verifytest"
+	"github.com/google/exposure-notifications-server/pkg/errcmp"
+	"github.com/google/exposure-notifications-server/pkg/observability"
+	"github.com/google/go-cmp/cmp"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+func TestConfig_ShadowConfig(t *testing.T) {
+	t.Parallel()
+
+	if got := (&Config{StatsAudience: "aud"}).shadowConfig(); got != nil {
+		t.Errorf("expected no shadow config when disabled, got %#v", got)
+	}
+
+	live := &Config{
+		CacheDuration:   time.Minute,
+		StatsAudience:   "aud",
+		StatsKIDPattern: "^v[0-9]+$",
+		Shadow: ShadowConfig{
+			Enabled:                 true,
+			StatsAudience:           "new-aud",
+			StatsTokenTypes:         []string{"stats+jwt"},
+			StatsRequireKeyValidity: true,
+			StatsRequireURIAudience: true,
+		},
+	}
+	want := &Config{
+		CacheDuration:           time.Minute,
+		StatsAudience:           "new-aud",
+		StatsKIDPattern:         "^v[0-9]+$",
+		StatsTokenTypes:         []string{"stats+jwt"},
+		StatsRequireKeyValidity: true,
+		StatsRequireURIAudience: true,
+	}
+	if diff := cmp.Diff(want, live.shadowConfig()); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestNew_InvalidShadowKIDPattern(t *testing.T) {
+	t.Parallel()
+
+	_, err := New(nil, &Config{Shadow: ShadowConfig{Enabled: true, StatsKIDPattern: "("}})
+	errcmp.MustMatch(t, err, "invalid VERIFICATION_SHADOW_STATS_KID_PATTERN")
+}
+
+func TestAuthenticateStatsToken_Shadow(t *testing.T) {
+	// Not parallel, the exporter and reporting period are global.
+
+	ctx := project.TestContext(t)
+	viewName := verificationMetricsPrefix + "shadow_stats_token_count"
+
+	var shadowView *view.View
+	for _, v := range observability.AllViews() {
+		if v.Name == viewName {
+			shadowView = v
+		}
+	}
+	if shadowView == nil {
+		t.Fatalf("view %q is not collected", viewName)
+	}
+	if err := view.Register(shadowView); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { view.Unregister(shadowView) })
+
+	exporter := &fakeExporter{rows: make(map[string][]*view.Row)}
+	view.RegisterExporter(exporter)
+	t.Cleanup(func() { view.UnregisterExporter(exporter) })
+	view.SetReportingPeriod(10 * time.Millisecond)
+	t.Cleanup(func() { view.SetReportingPeriod(time.Minute) })
+
+	// The live config accepts the old audience, the shadow config only the new
+	// one.
+	ha := verifytest.NewHealthAuthority(t, "shadow.test.health")
+	ha.HealthAuthority.ID = 9
+	verifier, err := New(nil, &Config{
+		CacheDuration: time.Hour,
+		StatsAudience: "old-aud",
+		Shadow: ShadowConfig{
+			Enabled:       true,
+			StatsAudience: "new-aud",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ha.Cache(t, verifier)
+
+	cases := []struct {
+		name   string
+		token  string
+		err    string
+		live   string
+		shadow string
+	}{
+		{
+			name:   "diverges_rejected_by_shadow",
+			token:  ha.StatsToken(t, "old-aud"),
+			live:   shadowAccept,
+			shadow: shadowReject,
+		},
+		{
+			name:   "diverges_accepted_by_shadow",
+			token:  ha.StatsToken(t, "new-aud"),
+			err:    "unauthorized, audience mismatch",
+			live:   shadowReject,
+			shadow: shadowAccept,
+		},
+		{
+			name:   "agrees",
+			token:  ha.InvalidStatsToken(t, "old-aud"),
+			err:    "unauthorized",
+			live:   shadowReject,
+			shadow: shadowReject,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tags := map[tag.Key]string{liveTag: tc.live, shadowTag: tc.shadow}
+			before := exporter.count(viewName, tags)
+
+			// The live decision is what's returned.
+			id, err := verifier.AuthenticateStatsToken(ctx, tc.token)
+			errcmp.MustMatch(t, err, tc.err)
+			if tc.err == "" {
+				if got, want := id, ha.HealthAuthority.ID; got != want {
+					t.Errorf("expected health authority %d, got %d", want, got)
+				}
+			}
+
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				if got := exporter.count(viewName, tags); got >= before+1 {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("expected shadow outcome with tags %v to be recorded", tags)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
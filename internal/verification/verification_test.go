@@ -17,6 +17,7 @@ package verification
 import (
 	"testing"
 
+	"github.com/google/exposure-notifications-server/internal/verification/model"
 	"github.com/google/exposure-notifications-server/pkg/database"
 )
 
@@ -27,3 +28,11 @@ func TestMain(m *testing.M) {
 	defer testDatabaseInstance.MustClose()
 	m.Run()
 }
+
+// CacheHealthAuthority stores the health authority in the verifier's cache, as
+// if it had been read from the database. It is replaced on the next lookup
+// after the cache duration expires. It is only available to tests, e.g. for
+// verifytest.HealthAuthority.Cache.
+func (v *Verifier) CacheHealthAuthority(ha *model.HealthAuthority) error {
+	return v.haCache.Set(ha.Issuer, ha)
+}
@@ -0,0 +1,218 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package verifytest provides helpers for creating health authorities with
+// known keys and minting tokens signed by them. It is only intended for use in
+// tests.
+package verifytest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/exposure-notifications-server/internal/verification/database"
+	"github.com/google/exposure-notifications-server/internal/verification/model"
+)
+
+const (
+	// DefaultKeyVersion is the key version (kid) of keys created by
+	// NewHealthAuthority.
+	DefaultKeyVersion = "v1"
+
+	// tokenLifetime is how long minted tokens are valid for.
+	tokenLifetime = 5 * time.Minute
+)
+
+// NewSigningKey deterministically derives an ECDSA P-256 private key from the
+// given seed. The same seed always returns the same key.
+func NewSigningKey(tb testing.TB, seed string) *ecdsa.PrivateKey {
+	tb.Helper()
+
+	curve := elliptic.P256()
+	n := curve.Params().N
+
+	// Hash the seed with an increasing counter until the result is a valid
+	// scalar, this almost always succeeds on the first iteration.
+	for i := uint32(0); i < 100; i++ {
+		var counter [4]byte
+		binary.BigEndian.PutUint32(counter[:], i)
+		sum := sha256.Sum256(append([]byte(seed), counter[:]...))
+
+		d := new(big.Int).SetBytes(sum[:])
+		if d.Sign() == 0 || d.Cmp(n) >= 0 {
+			continue
+		}
+
+		key := &ecdsa.PrivateKey{D: d}
+		key.PublicKey.Curve = curve
+		key.PublicKey.X, key.PublicKey.Y = curve.ScalarBaseMult(d.Bytes())
+		return key
+	}
+
+	tb.Fatalf("failed to derive signing key from seed %q", seed)
+	return nil
+}
+
+// PublicKeyPEM returns the PEM encoding of the public key.
+func PublicKeyPEM(tb testing.TB, key *ecdsa.PublicKey) string {
+	tb.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		tb.Fatalf("failed to marshal public key: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// HealthAuthority is a health authority with a single key version, along with
+// the private key for that version.
+type HealthAuthority struct {
+	HealthAuthority *model.HealthAuthority
+	Key             *model.HealthAuthorityKey
+	PrivateKey      *ecdsa.PrivateKey
+}
+
+// NewHealthAuthority creates a health authority with the given issuer that has
+// the stats API enabled and a single valid key version, DefaultKeyVersion. The
+// signing key is derived from the issuer, so it is the same across runs. The
+// health authority is not saved, see Store and Cache.
+func NewHealthAuthority(tb testing.TB, issuer string) *HealthAuthority {
+	tb.Helper()
+
+	privateKey := NewSigningKey(tb, issuer)
+
+	return &HealthAuthority{
+		HealthAuthority: &model.HealthAuthority{
+			Issuer:         issuer,
+			Audience:       "aud-" + issuer,
+			Name:           fmt.Sprintf("Test Health Authority %s", issuer),
+			EnableStatsAPI: true,
+		},
+		Key: &model.HealthAuthorityKey{
+			Version:      DefaultKeyVersion,
+			From:         time.Now().Add(-1 * time.Hour).UTC().Truncate(time.Second),
+			PublicKeyPEM: PublicKeyPEM(tb, &privateKey.PublicKey),
+		},
+		PrivateKey: privateKey,
+	}
+}
+
+// Store saves the health authority and its key in the database. The IDs of the
+// health authority and key are updated.
+func (h *HealthAuthority) Store(ctx context.Context, tb testing.TB, db *database.HealthAuthorityDB) {
+	tb.Helper()
+
+	if err := db.AddHealthAuthority(ctx, h.HealthAuthority); err != nil {
+		tb.Fatalf("failed to add health authority: %v", err)
+	}
+	if err := db.AddHealthAuthorityKey(ctx, h.HealthAuthority, h.Key); err != nil {
+		tb.Fatalf("failed to add health authority key: %v", err)
+	}
+	h.HealthAuthority.Keys = []*model.HealthAuthorityKey{h.Key}
+}
+
+// Resolver is an in-memory cache of health authorities, keyed by issuer. It
+// resolves the health authorities for a verifier created with
+// verification.NewWithResolver, without requiring a database.
+type Resolver map[string]*model.HealthAuthority
+
+// CacheHealthAuthority stores the health authority.
+func (r Resolver) CacheHealthAuthority(ha *model.HealthAuthority) error {
+	r[ha.Issuer] = ha
+	return nil
+}
+
+// ResolveHealthAuthority implements verification.HealthAuthorityResolver.
+func (r Resolver) ResolveHealthAuthority(_ context.Context, issuer string) (*model.HealthAuthority, error) {
+	ha, ok := r[issuer]
+	if !ok {
+		return nil, database.ErrHealthAuthorityNotFound
+	}
+	return ha, nil
+}
+
+// Cache saves the health authority with its key in a cache, such as a
+// Resolver, without requiring a database. The cache is keyed by issuer.
+func (h *HealthAuthority) Cache(tb testing.TB, cache interface {
+	CacheHealthAuthority(ha *model.HealthAuthority) error
+}) {
+	tb.Helper()
+
+	ha := *h.HealthAuthority
+	ha.Keys = []*model.HealthAuthorityKey{h.Key}
+	if err := cache.CacheHealthAuthority(&ha); err != nil {
+		tb.Fatalf("failed to cache health authority: %v", err)
+	}
+}
+
+// StatsToken mints a valid stats API token for the given audience.
+func (h *HealthAuthority) StatsToken(tb testing.TB, audience string) string {
+	tb.Helper()
+	return h.signStatsToken(tb, h.statsClaims(audience, time.Now()), h.PrivateKey)
+}
+
+// ExpiredStatsToken mints a stats API token that has already expired.
+func (h *HealthAuthority) ExpiredStatsToken(tb testing.TB, audience string) string {
+	tb.Helper()
+	return h.signStatsToken(tb, h.statsClaims(audience, time.Now().Add(-2*tokenLifetime)), h.PrivateKey)
+}
+
+// WrongAudienceStatsToken mints a stats API token for an audience that no
+// server expects.
+func (h *HealthAuthority) WrongAudienceStatsToken(tb testing.TB) string {
+	tb.Helper()
+	return h.signStatsToken(tb, h.statsClaims("wrong-audience", time.Now()), h.PrivateKey)
+}
+
+// InvalidStatsToken mints a stats API token that is otherwise valid, but is
+// signed by a key that doesn't belong to the health authority.
+func (h *HealthAuthority) InvalidStatsToken(tb testing.TB, audience string) string {
+	tb.Helper()
+	otherKey := NewSigningKey(tb, "invalid-"+h.HealthAuthority.Issuer)
+	return h.signStatsToken(tb, h.statsClaims(audience, time.Now()), otherKey)
+}
+
+func (h *HealthAuthority) statsClaims(audience string, issuedAt time.Time) *jwt.StandardClaims {
+	issuedAt = issuedAt.UTC()
+	return &jwt.StandardClaims{
+		Audience:  audience,
+		ExpiresAt: issuedAt.Add(tokenLifetime).Unix(),
+		IssuedAt:  issuedAt.Unix(),
+		Issuer:    h.HealthAuthority.Issuer,
+		NotBefore: issuedAt.Unix(),
+	}
+}
+
+func (h *HealthAuthority) signStatsToken(tb testing.TB, claims *jwt.StandardClaims, key *ecdsa.PrivateKey) string {
+	tb.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = h.Key.Version
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		tb.Fatalf("failed to sign token: %v", err)
+	}
+	return signed
+}
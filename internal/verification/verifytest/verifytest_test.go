@@ -0,0 +1,173 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verifytest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/exposure-notifications-server/internal/verification"
+	"github.com/google/exposure-notifications-server/internal/verification/database"
+	"github.com/google/exposure-notifications-server/pkg/errcmp"
+	"github.com/google/go-cmp/cmp"
+
+	pgdatabase "github.com/google/exposure-notifications-server/pkg/database"
+)
+
+const testAudience = "test-stats-aud"
+
+var testDatabaseInstance *pgdatabase.TestInstance
+
+func TestMain(m *testing.M) {
+	testDatabaseInstance = pgdatabase.MustTestInstance()
+	defer testDatabaseInstance.MustClose()
+	m.Run()
+}
+
+func TestNewSigningKey(t *testing.T) {
+	t.Parallel()
+
+	a := NewSigningKey(t, "seed")
+	b := NewSigningKey(t, "seed")
+	if a.D.Cmp(b.D) != 0 || a.X.Cmp(b.X) != 0 || a.Y.Cmp(b.Y) != 0 {
+		t.Errorf("expected the same seed to derive the same key")
+	}
+	if !a.Curve.IsOnCurve(a.X, a.Y) {
+		t.Errorf("expected public key to be on the curve")
+	}
+
+	c := NewSigningKey(t, "other-seed")
+	if a.D.Cmp(c.D) == 0 {
+		t.Errorf("expected different seeds to derive different keys")
+	}
+
+	if got, want := PublicKeyPEM(t, &a.PublicKey), PublicKeyPEM(t, &b.PublicKey); got != want {
+		t.Errorf("expected PEM %q to be %q", got, want)
+	}
+}
+
+// tokenCases are the token varieties and the error they produce.
+func tokenCases(ha *HealthAuthority) []struct {
+	name  string
+	token func(t testing.TB) string
+	err   string
+} {
+	return []struct {
+		name  string
+		token func(t testing.TB) string
+		err   string
+	}{
+		{
+			name:  "valid",
+			token: func(t testing.TB) string { return ha.StatsToken(t, testAudience) },
+		},
+		{
+			name:  "invalid",
+			token: func(t testing.TB) string { return ha.InvalidStatsToken(t, testAudience) },
+			err:   "crypto/ecdsa: verification error",
+		},
+		{
+			name:  "expired",
+			token: func(t testing.TB) string { return ha.ExpiredStatsToken(t, testAudience) },
+			err:   "token is expired by ",
+		},
+		{
+			name:  "wrong_audience",
+			token: func(t testing.TB) string { return ha.WrongAudienceStatsToken(t) },
+			err:   "unauthorized, audience mismatch",
+		},
+	}
+}
+
+func TestHealthAuthority_Cache(t *testing.T) {
+	t.Parallel()
+
+	ha := NewHealthAuthority(t, "cache.test.health")
+	ha.HealthAuthority.ID = 15
+
+	resolver := make(Resolver)
+	ha.Cache(t, resolver)
+
+	verifier, err := verification.NewWithResolver(resolver, &verification.Config{
+		CacheDuration: time.Hour,
+		StatsAudience: testAudience,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range tokenCases(ha) {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := project.TestContext(t)
+			gotID, err := verifier.AuthenticateStatsToken(ctx, tc.token(t))
+			errcmp.MustMatch(t, err, tc.err)
+
+			if tc.err == "" {
+				if got, want := gotID, ha.HealthAuthority.ID; got != want {
+					t.Errorf("expected health authority %d to be %d", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthAuthority_Store(t *testing.T) {
+	t.Parallel()
+
+	ctx := project.TestContext(t)
+	testDB, _ := testDatabaseInstance.NewDatabase(t)
+	haDB := database.New(testDB)
+
+	ha := NewHealthAuthority(t, "store.test.health")
+	ha.Store(ctx, t, haDB)
+
+	got, err := haDB.GetHealthAuthority(ctx, ha.HealthAuthority.Issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(ha.HealthAuthority, got); diff != "" {
+		t.Fatalf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	verifier, err := verification.New(haDB, &verification.Config{
+		CacheDuration: time.Nanosecond,
+		StatsAudience: testAudience,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range tokenCases(ha) {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			gotID, err := verifier.AuthenticateStatsToken(ctx, tc.token(t))
+			errcmp.MustMatch(t, err, tc.err)
+
+			if tc.err == "" {
+				if got, want := gotID, ha.HealthAuthority.ID; got != want {
+					t.Errorf("expected health authority %d to be %d", got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verification
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/exposure-notifications-server/internal/verification/model"
+)
+
+const (
+	// x5cHeader is the JWS header that carries the certificate chain of the
+	// signing key, as defined in RFC 7515 section 4.1.6.
+	x5cHeader = "x5c"
+
+	// maxX5CChainLength is the maximum number of certificates accepted in a
+	// chain, including the leaf.
+	maxX5CChainLength = 5
+)
+
+// ErrX5CNotEnabled indicates a certificate chain was presented for a health
+// authority that has no trust anchors configured.
+var ErrX5CNotEnabled = errors.New("certificate chains are not enabled for health authority")
+
+// x5cSigningMethods are the signing methods that are accepted when the signing
+// key is presented as a certificate chain.
+var x5cSigningMethods = map[string]struct{}{
+	jwt.SigningMethodES256.Name: {},
+	jwt.SigningMethodES384.Name: {},
+	jwt.SigningMethodES512.Name: {},
+	jwt.SigningMethodRS256.Name: {},
+	jwt.SigningMethodRS384.Name: {},
+	jwt.SigningMethodRS512.Name: {},
+	jwt.SigningMethodPS256.Name: {},
+	jwt.SigningMethodPS384.Name: {},
+	jwt.SigningMethodPS512.Name: {},
+}
+
+// hasX5C returns true if the token presents a certificate chain.
+func hasX5C(token *jwt.Token) bool {
+	_, ok := token.Header[x5cHeader]
+	return ok
+}
+
+// x5cPublicKey validates the certificate chain in the token's x5c header
+// against the health authority's trust anchors at the given time and returns
+// the public key of the leaf certificate. The leaf must be allowed to create
+// digital signatures and its key must match the token's signing method.
+func x5cPublicKey(token *jwt.Token, ha *model.HealthAuthority, now time.Time) (interface{}, error) {
+	if !ha.X5CEnabled() {
+		return nil, ErrX5CNotEnabled
+	}
+
+	roots, err := ha.TrustAnchors()
+	if err != nil {
+		return nil, fmt.Errorf("health authority %v: %w", ha.Issuer, err)
+	}
+	leaf, err := verifyX5C(token, roots, now)
+	if err != nil {
+		return nil, err
+	}
+	return leaf.PublicKey, nil
+}
+
+// verifyX5C validates the certificate chain in the token's x5c header against
+// the roots at the given time and returns the leaf certificate, with the same
+// checks as x5cPublicKey.
+func verifyX5C(token *jwt.Token, roots *x509.CertPool, now time.Time) (*x509.Certificate, error) {
+	if _, ok := x5cSigningMethods[token.Method.Alg()]; !ok {
+		return nil, fmt.Errorf("unsupported signing method for certificate chain: %v", token.Method.Alg())
+	}
+
+	certs, err := parseX5C(token.Header[x5cHeader])
+	if err != nil {
+		return nil, err
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	leaf := certs[0]
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		// Verification certificates aren't used for TLS, so don't require a
+		// specific extended key usage. The key usage is checked below.
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, fmt.Errorf("invalid certificate chain: %w", err)
+	}
+
+	if leaf.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		return nil, fmt.Errorf("invalid certificate chain: leaf certificate does not allow digital signatures")
+	}
+
+	if err := checkX5CKeyType(token.Method, leaf.PublicKey); err != nil {
+		return nil, err
+	}
+	return leaf, nil
+}
+
+// parseX5C parses the value of an x5c header, which is an array of base64 (not
+// base64url) encoded DER certificates, leaf first.
+func parseX5C(header interface{}) ([]*x509.Certificate, error) {
+	values, ok := header.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid %q header, must be an array", x5cHeader)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("invalid %q header, must not be empty", x5cHeader)
+	}
+	if len(values) > maxX5CChainLength {
+		return nil, fmt.Errorf("invalid %q header, chain length %d exceeds maximum of %d", x5cHeader, len(values), maxX5CChainLength)
+	}
+
+	certs := make([]*x509.Certificate, 0, len(values))
+	for i, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid %q header, entry %d is not a string", x5cHeader, i)
+		}
+		der, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %q header, entry %d: %w", x5cHeader, i, err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %q header, entry %d: %w", x5cHeader, i, err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// checkX5CKeyType returns an error if the public key can't be used with the
+// signing method.
+func checkX5CKeyType(method jwt.SigningMethod, publicKey interface{}) error {
+	switch m := method.(type) {
+	case *jwt.SigningMethodECDSA:
+		key, ok := publicKey.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("signing method %v requires an ECDSA key", m.Alg())
+		}
+		if key.Curve.Params().BitSize != m.CurveBits {
+			return fmt.Errorf("signing method %v does not match key curve %v", m.Alg(), key.Curve.Params().Name)
+		}
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		if _, ok := publicKey.(*rsa.PublicKey); !ok {
+			return fmt.Errorf("signing method %v requires an RSA key", method.Alg())
+		}
+	default:
+		return fmt.Errorf("unsupported signing method for certificate chain: %v", method.Alg())
+	}
+	return nil
+}
@@ -0,0 +1,413 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verification
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+
+	aamodel "github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	"github.com/google/exposure-notifications-server/internal/project"
+	"github.com/google/exposure-notifications-server/internal/verification/model"
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
+	"github.com/google/exposure-notifications-server/pkg/errcmp"
+	utils "github.com/google/exposure-notifications-server/pkg/verification"
+)
+
+// testCert is a certificate and its private key.
+type testCert struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+// issueTestCert creates a certificate from the template, signed by the parent.
+// If parent is nil, the certificate is self-signed.
+func issueTestCert(t testing.TB, tmpl *x509.Certificate, key crypto.Signer, parent *testCert) *testCert {
+	t.Helper()
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.SerialNumber = serial
+	if tmpl.NotBefore.IsZero() {
+		tmpl.NotBefore = time.Now().Add(-1 * time.Hour)
+	}
+	if tmpl.NotAfter.IsZero() {
+		tmpl.NotAfter = time.Now().Add(24 * time.Hour)
+	}
+
+	parentCert, parentKey := tmpl, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parentCert, key.Public(), parentKey)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+func testECDSAKey(t testing.TB) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func testRSAKey(t testing.TB) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// newTestCA creates a root CA and an intermediate CA issued by it.
+func newTestCA(t testing.TB, name string) (root, intermediate *testCert) {
+	t.Helper()
+
+	root = issueTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name + " Root CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, testECDSAKey(t), nil)
+
+	intermediate = issueTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name + " Intermediate CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, testECDSAKey(t), root)
+	return root, intermediate
+}
+
+// issueTestLeaf issues a signing certificate from the CA.
+func issueTestLeaf(t testing.TB, key crypto.Signer, ca *testCert, keyUsage x509.KeyUsage, notAfter time.Time) *testCert {
+	t.Helper()
+	return issueTestCert(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "Verification Server"},
+		KeyUsage: keyUsage,
+		NotAfter: notAfter,
+	}, key, ca)
+}
+
+func certPEM(certs ...*testCert) string {
+	var out []byte
+	for _, c := range certs {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})...)
+	}
+	return string(out)
+}
+
+func x5cChain(certs ...*testCert) []string {
+	chain := make([]string, 0, len(certs))
+	for _, c := range certs {
+		chain = append(chain, base64.StdEncoding.EncodeToString(c.cert.Raw))
+	}
+	return chain
+}
+
+func TestX5CPublicKey(t *testing.T) {
+	t.Parallel()
+
+	root, intermediate := newTestCA(t, "Test")
+	otherRoot, otherIntermediate := newTestCA(t, "Other")
+
+	ecdsaKey := testECDSAKey(t)
+	rsaKey := testRSAKey(t)
+	future := time.Now().Add(time.Hour)
+
+	ecdsaLeaf := issueTestLeaf(t, ecdsaKey, intermediate, x509.KeyUsageDigitalSignature, future)
+	rsaLeaf := issueTestLeaf(t, rsaKey, intermediate, x509.KeyUsageDigitalSignature, future)
+	expiredLeaf := issueTestLeaf(t, ecdsaKey, intermediate, x509.KeyUsageDigitalSignature, time.Now().Add(-1*time.Minute))
+	encipherLeaf := issueTestLeaf(t, ecdsaKey, intermediate, x509.KeyUsageKeyEncipherment, future)
+	otherLeaf := issueTestLeaf(t, ecdsaKey, otherIntermediate, x509.KeyUsageDigitalSignature, future)
+
+	cases := []struct {
+		name    string
+		anchors string
+		method  jwt.SigningMethod
+		key     interface{}
+		chain   interface{}
+		err     string
+	}{
+		{
+			name:    "ecdsa_leaf",
+			anchors: certPEM(root),
+			method:  jwt.SigningMethodES256,
+			key:     ecdsaKey,
+			chain:   x5cChain(ecdsaLeaf, intermediate),
+		},
+		{
+			name:    "rsa_leaf",
+			anchors: certPEM(root),
+			method:  jwt.SigningMethodRS256,
+			key:     rsaKey,
+			chain:   x5cChain(rsaLeaf, intermediate),
+		},
+		{
+			name:    "rsa_pss_leaf",
+			anchors: certPEM(root),
+			method:  jwt.SigningMethodPS256,
+			key:     rsaKey,
+			chain:   x5cChain(rsaLeaf, intermediate),
+		},
+		{
+			name:    "intermediate_anchor",
+			anchors: certPEM(intermediate),
+			method:  jwt.SigningMethodES256,
+			key:     ecdsaKey,
+			chain:   x5cChain(ecdsaLeaf),
+		},
+		{
+			name:    "multiple_anchors",
+			anchors: certPEM(otherRoot, root),
+			method:  jwt.SigningMethodES256,
+			key:     ecdsaKey,
+			chain:   x5cChain(ecdsaLeaf, intermediate),
+		},
+		{
+			name:   "not_enabled",
+			method: jwt.SigningMethodES256,
+			key:    ecdsaKey,
+			chain:  x5cChain(ecdsaLeaf, intermediate),
+			err:    ErrX5CNotEnabled.Error(),
+		},
+		{
+			name:    "expired_leaf",
+			anchors: certPEM(root),
+			method:  jwt.SigningMethodES256,
+			key:     ecdsaKey,
+			chain:   x5cChain(expiredLeaf, intermediate),
+			err:     "certificate has expired",
+		},
+		{
+			name:    "wrong_key_usage",
+			anchors: certPEM(root),
+			method:  jwt.SigningMethodES256,
+			key:     ecdsaKey,
+			chain:   x5cChain(encipherLeaf, intermediate),
+			err:     "leaf certificate does not allow digital signatures",
+		},
+		{
+			name:    "untrusted_root",
+			anchors: certPEM(root),
+			method:  jwt.SigningMethodES256,
+			key:     ecdsaKey,
+			chain:   x5cChain(otherLeaf, otherIntermediate),
+			err:     "certificate signed by unknown authority",
+		},
+		{
+			name:    "missing_intermediate",
+			anchors: certPEM(root),
+			method:  jwt.SigningMethodES256,
+			key:     ecdsaKey,
+			chain:   x5cChain(ecdsaLeaf),
+			err:     "certificate signed by unknown authority",
+		},
+		{
+			name:    "key_type_mismatch",
+			anchors: certPEM(root),
+			method:  jwt.SigningMethodRS256,
+			key:     rsaKey,
+			chain:   x5cChain(ecdsaLeaf, intermediate),
+			err:     "signing method RS256 requires an RSA key",
+		},
+		{
+			name:    "unsupported_method",
+			anchors: certPEM(root),
+			method:  jwt.SigningMethodHS256,
+			key:     []byte("secret"),
+			chain:   x5cChain(ecdsaLeaf, intermediate),
+			err:     "unsupported signing method for certificate chain: HS256",
+		},
+		{
+			name:    "empty_chain",
+			anchors: certPEM(root),
+			method:  jwt.SigningMethodES256,
+			key:     ecdsaKey,
+			chain:   []string{},
+			err:     `invalid "x5c" header, must not be empty`,
+		},
+		{
+			name:    "invalid_chain",
+			anchors: certPEM(root),
+			method:  jwt.SigningMethodES256,
+			key:     ecdsaKey,
+			chain:   "not-an-array",
+			err:     `invalid "x5c" header, must be an array`,
+		},
+		{
+			name:    "invalid_certificate",
+			anchors: certPEM(root),
+			method:  jwt.SigningMethodES256,
+			key:     ecdsaKey,
+			chain:   []string{base64.StdEncoding.EncodeToString([]byte("nope"))},
+			err:     `invalid "x5c" header, entry 0`,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ha := &model.HealthAuthority{
+				Issuer:          "x5c.test.health",
+				TrustAnchorsPEM: tc.anchors,
+			}
+
+			token := jwt.NewWithClaims(tc.method, &jwt.StandardClaims{
+				Issuer:    ha.Issuer,
+				ExpiresAt: time.Now().Add(time.Minute).Unix(),
+			})
+			token.Header[x5cHeader] = tc.chain
+			signed, err := token.SignedString(tc.key)
+			if err != nil {
+				t.Fatalf("failed to sign token: %v", err)
+			}
+
+			_, err = jwt.Parse(signed, func(token *jwt.Token) (interface{}, error) {
+				if !hasX5C(token) {
+					t.Fatalf("expected token to have x5c header")
+				}
+				return x5cPublicKey(token, ha, time.Now())
+			})
+			errcmp.MustMatch(t, err, tc.err)
+		})
+	}
+}
+
+func TestVerifyCertificate_X5C(t *testing.T) {
+	t.Parallel()
+
+	root, intermediate := newTestCA(t, "Test")
+	rsaKey := testRSAKey(t)
+	leaf := issueTestLeaf(t, rsaKey, intermediate, x509.KeyUsageDigitalSignature, time.Now().Add(time.Hour))
+
+	cases := []struct {
+		name    string
+		anchors string
+		err     string
+	}{
+		{
+			name:    "trusted",
+			anchors: certPEM(root),
+		},
+		{
+			name: "not_enabled",
+			err:  ErrX5CNotEnabled.Error(),
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := project.TestContext(t)
+
+			ha := &model.HealthAuthority{
+				ID:              7,
+				Issuer:          "x5c.test.health",
+				Audience:        "x5c.test.aud",
+				Name:            "Certificate Chain Health Authority",
+				TrustAnchorsPEM: tc.anchors,
+			}
+			verifier, err := New(nil, &Config{CacheDuration: time.Hour})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := verifier.CacheHealthAuthority(ha); err != nil {
+				t.Fatal(err)
+			}
+
+			authApp := aamodel.NewAuthorizedApp()
+			authApp.AllowedHealthAuthorityIDs[ha.ID] = struct{}{}
+
+			hmacKey := make([]byte, 32)
+			if _, err := rand.Read(hmacKey); err != nil {
+				t.Fatal(err)
+			}
+			publish := verifyapi.Publish{
+				Keys: []verifyapi.ExposureKey{
+					{
+						Key:              "IRgYIhYiy4WMl9z68bMk6w==",
+						IntervalNumber:   2650032,
+						IntervalCount:    144,
+						TransmissionRisk: 4,
+					},
+				},
+				HMACKey: base64.StdEncoding.EncodeToString(hmacKey),
+			}
+			allHMACs, err := utils.CalculateAllAllowedExposureKeyHMAC(publish.Keys, hmacKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			claims := verifyapi.NewVerificationClaims()
+			claims.Audience = ha.Audience
+			claims.Issuer = ha.Issuer
+			claims.IssuedAt = time.Now().Unix()
+			claims.ExpiresAt = time.Now().Add(5 * time.Minute).Unix()
+			claims.SignedMAC = base64.StdEncoding.EncodeToString(allHMACs[0])
+			claims.ReportType = verifyapi.ReportTypeConfirmed
+
+			// No kid, the key is identified by the certificate chain.
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+			token.Header[x5cHeader] = x5cChain(leaf, intermediate)
+			publish.VerificationPayload, err = token.SignedString(rsaKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := verifier.VerifyDiagnosisCertificate(ctx, authApp, &publish)
+			errcmp.MustMatch(t, err, tc.err)
+
+			if tc.err == "" {
+				if got.HealthAuthorityID != ha.ID {
+					t.Errorf("expected health authority %d to be %d", got.HealthAuthorityID, ha.ID)
+				}
+				if got.ReportType != verifyapi.ReportTypeConfirmed {
+					t.Errorf("expected report type %q to be %q", got.ReportType, verifyapi.ReportTypeConfirmed)
+				}
+			}
+		})
+	}
+}
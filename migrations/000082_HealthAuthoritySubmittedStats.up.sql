@@ -0,0 +1,30 @@
+-- Copyright 2021 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+
+BEGIN;
+
+CREATE TABLE HealthAuthoritySubmittedStats(
+    health_authority_id INT NOT NULL REFERENCES HealthAuthority(id) ON DELETE CASCADE,
+    -- UTC midnight of the day the stats are for.
+    day TIMESTAMPTZ NOT NULL,
+    -- metric name -> value
+    metrics JSONB NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE UNIQUE INDEX
+    idx_health_authority_submitted_stats_day ON HealthAuthoritySubmittedStats (health_authority_id, day);
+
+END;
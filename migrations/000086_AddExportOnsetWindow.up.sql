@@ -0,0 +1,28 @@
+-- Copyright 2021 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- default null is intentional, meaning the onset window is unbounded
+ALTER TABLE exportconfig
+  ADD COLUMN onset_window_min_days INT,
+  ADD COLUMN onset_window_max_days INT,
+  ADD COLUMN exclude_missing_onset BOOL NOT NULL DEFAULT false;
+
+ALTER TABLE exportbatch
+  ADD COLUMN onset_window_min_days INT,
+  ADD COLUMN onset_window_max_days INT,
+  ADD COLUMN exclude_missing_onset BOOL NOT NULL DEFAULT false;
+
+END;
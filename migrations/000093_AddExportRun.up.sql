@@ -0,0 +1,32 @@
+-- Copyright 2021 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+CREATE TABLE ExportRun (
+  run_id BIGSERIAL PRIMARY KEY,
+  config_id INT NOT NULL REFERENCES ExportConfig(config_id),
+  started_at TIMESTAMPTZ NOT NULL,
+  finished_at TIMESTAMPTZ NOT NULL,
+  batches INT NOT NULL,
+  keys INT NOT NULL,
+  revised_keys INT NOT NULL,
+  outcome TEXT NOT NULL,
+  error TEXT
+);
+
+CREATE INDEX idx_exportrun_config_started ON ExportRun (config_id, started_at DESC);
+CREATE INDEX idx_exportrun_started ON ExportRun (started_at DESC);
+
+END;
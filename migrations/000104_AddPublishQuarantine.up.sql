@@ -0,0 +1,48 @@
+-- Copyright 2021 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+CREATE TABLE PublishQuarantine (
+  quarantine_id BIGSERIAL PRIMARY KEY,
+  app_package_name TEXT NOT NULL,
+  health_authority_id INT,
+  regions TEXT[],
+  reason TEXT NOT NULL,
+  num_keys INT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'PENDING',
+  created_at TIMESTAMPTZ NOT NULL,
+  reviewed_at TIMESTAMPTZ
+);
+
+CREATE INDEX publish_quarantine_status ON PublishQuarantine(status, created_at);
+
+CREATE TABLE QuarantinedExposure (
+  quarantine_id BIGINT NOT NULL REFERENCES PublishQuarantine(quarantine_id) ON DELETE CASCADE,
+  exposure_key TEXT NOT NULL,
+  transmission_risk INT NOT NULL,
+  app_package_name TEXT,
+  regions TEXT[],
+  traveler BOOLEAN NOT NULL DEFAULT false,
+  interval_number INT NOT NULL,
+  interval_count INT NOT NULL,
+  health_authority_id INT,
+  report_type TEXT,
+  days_since_symptom_onset INT,
+  symptom_onset_interval INT,
+  certificate_hash TEXT,
+  PRIMARY KEY (quarantine_id, exposure_key)
+);
+
+END;
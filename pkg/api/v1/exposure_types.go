@@ -60,12 +60,61 @@ const (
 	// ErrorInvalidReportTypeTransition indicates an uploaded TEK tried to
 	// transition to an invalid state (like "positive" -> "likely").
 	ErrorInvalidReportTypeTransition = "invalid_report_type_transition"
+	// ErrorInvalidKeyEncoding indicates that none of the exposure keys in the
+	// publish request could be saved, and at least one of them isn't valid
+	// base64. Keys may use the standard or URL-safe alphabet, with or without
+	// padding. The ErrorMessage includes the index of each malformed key.
+	ErrorInvalidKeyEncoding = "invalid_key_encoding"
+	// ErrorInvalidKeyLength indicates that at least one of the exposure keys in
+	// the publish request doesn't decode to exactly KeyLength bytes. Depending
+	// on the server configuration, this is returned when none of the keys could
+	// be saved, or for any invalid key, before the verification certificate is
+	// checked. The ErrorMessage includes the index of each invalid key.
+	ErrorInvalidKeyLength = "invalid_key_length"
 	// ErrorPartialFailure indicates that some exposure keys in the publish
 	// request had invalid data (size, timing metadata) and were dropped. Other
 	// keys were saved.
 	ErrorPartialFailure = "partial_failure"
+	// ErrorUnsupportedSchemaVersion indicates that the server doesn't accept
+	// publish requests with the schema version in the X-Publish-Schema-Version
+	// header. The ErrorMessage lists the accepted versions.
+	ErrorUnsupportedSchemaVersion = "unsupported_schema_version"
+	// ErrorCertificateRepublishedTooSoon indicates that the verification
+	// certificate was used in another publish request less than the server's
+	// minimum interval ago. The Retry-After header says when the request may
+	// be retried.
+	ErrorCertificateRepublishedTooSoon = "certificate_republished_too_soon"
+	// ErrorTooManyRegions indicates that the exposure keys in the publish
+	// request are tagged with more regions than the server allows. The
+	// ErrorMessage includes the index and region count of each key.
+	ErrorTooManyRegions = "too_many_regions"
+	// ErrorDailyKeyQuotaExceeded indicates that the health authority already
+	// published its daily quota of keys. Requests are accepted again on the
+	// next UTC day.
+	ErrorDailyKeyQuotaExceeded = "daily_key_quota_exceeded"
+	// ErrorServiceUnavailable indicates that the server is temporarily not
+	// saving keys, because its database is failing. The Retry-After header
+	// says when the request may be retried.
+	ErrorServiceUnavailable = "service_unavailable"
+	// ErrorPaddingTooLarge indicates that the padding of the publish request
+	// is larger than the server allows.
+	ErrorPaddingTooLarge = "padding_too_large"
+
+	// ErrorNotFound indicates that the server has no endpoint at the requested
+	// path.
+	ErrorNotFound = "not_found"
+	// ErrorMethodNotAllowed indicates that the endpoint doesn't accept the
+	// request method.
+	ErrorMethodNotAllowed = "method_not_allowed"
 )
 
+// ErrorResponse is the body of error responses that aren't specific to an
+// endpoint, such as for unknown paths.
+type ErrorResponse struct {
+	ErrorMessage string `json:"error"`
+	Code         string `json:"code"`
+}
+
 // Publish represents the body of the PublishInfectedIds API call.
 // temporaryExposureKeys: Required and must have length >= 1 and <= 21 (`maxKeysPerPublish`)
 // healthAuthorityID: assigned by the server operator
@@ -92,7 +141,13 @@ const (
 // Padding: random base64 encoded data to obscure the request size. The server will
 // not process this data in any way. The recommendation is that padding be
 // at least 1kb in size with a random jitter of at least 1kb. Maximum overall
-// request size is capped at 64kb for the serialized JSON.
+// request size is capped at 64kb for the serialized JSON. Servers may also
+// limit the size of the padding itself, and reject requests with larger
+// padding with ErrorPaddingTooLarge.
+//
+// Servers may be configured to accept the legacy field names of older clients:
+// appPackageName for healthAuthorityID, and exposureKeys or keys for
+// temporaryExposureKeys.
 //
 // Partial success: If at least one of the Keys passed in is valid, then the publish
 // request will accept those keys, return a response code of 200 (OK) AND also
@@ -133,6 +188,20 @@ type PublishResponse struct {
 	Code              string   `json:"code,omitempty"`
 	Padding           string   `json:"padding,omitempty"`
 	Warnings          []string `json:"warnings,omitempty"`
+
+	// Counts is only present if the server is configured to report them.
+	Counts *PublishCounts `json:"counts,omitempty"`
+}
+
+// PublishCounts reports how the keys in a successful publish request were
+// handled.
+type PublishCounts struct {
+	// Inserted is the number of new keys that were accepted.
+	Inserted int `json:"inserted"`
+	// Revised is the number of previously published keys that were revised.
+	Revised int `json:"revised"`
+	// Dropped is the number of keys that were neither inserted nor revised.
+	Dropped int `json:"dropped"`
 }
 
 // ExposureKey is the 16 byte key, the start time of the key and the
@@ -26,6 +26,15 @@ import (
 const (
 	// ErrorUnauthorized is returned if the provided bearer token is invalid.
 	ErrorUnauthorized = "unauthorized"
+	// ErrorTooManyRequests is returned if a health authority has exceeded the
+	// allowed request rate.
+	ErrorTooManyRequests = "too_many_requests"
+	// ErrorBodyHashMismatch is returned if the bearer token is bound to a
+	// different request body, using its x-hmac claim.
+	ErrorBodyHashMismatch = "body_hash_mismatch"
+	// ErrorInsufficientScope is returned if the bearer token doesn't have the
+	// scope required by the endpoint.
+	ErrorInsufficientScope = "insufficient_scope"
 )
 
 // StatsRequest represents the request to retrieve publish metrics for a specific
@@ -157,3 +166,81 @@ func (s StatsDays) MarshalCSV() ([]byte, error) {
 
 	return b.Bytes(), nil
 }
+
+// StatsSubmitRequest represents a request from a health authority to store
+// aggregate stats that were computed outside of this server.
+//
+// Calls to this API require an "Authorization: Bearer <JWT>" header
+// with a JWT signed with the same private used to sign verification certificates
+// for the health authority.
+//
+// Stats are stored per health authority per day (UTC). Submitting stats for a
+// day that already has stats replaces the previously submitted values.
+//
+// The bearer token may be bound to the request body with an "x-hmac" claim,
+// containing the base64 encoded SHA-256 hash of the exact body bytes. If it is
+// present and doesn't match, the request is rejected.
+//
+// This API is invoked via POST request to /v1/stats/submit
+type StatsSubmitRequest struct {
+	// Day is the UTC day the stats are for, in the format YYYY-MM-DD.
+	Day string `json:"day"`
+	// Metrics is a map of metric name to the aggregate value for the day.
+	// Metric names must be lowercase alphanumeric with underscores and
+	// values must be >= 0.
+	Metrics map[string]int64 `json:"metrics"`
+
+	Padding string `json:"padding"`
+}
+
+// StatsSubmitResponse is the response to a StatsSubmitRequest.
+type StatsSubmitResponse struct {
+	ErrorMessage string `json:"error,omitempty"`
+	ErrorCode    string `json:"code,omitempty"`
+
+	Padding string `json:"padding"`
+}
+
+// KeyCountsRequest represents a request for the number of exposure keys that
+// were published per region per day (UTC). Only counts are returned, never the
+// keys themselves.
+//
+// If the server is configured to require authorization for this API, calls
+// require an "Authorization: Bearer <JWT>" header with a JWT signed with the
+// same private used to sign verification certificates for a health authority.
+//
+// This API is invoked via POST request to /v1/stats/keycounts
+type KeyCountsRequest struct {
+	// StartDay and EndDay are the first and last (inclusive) UTC days to return
+	// counts for, in the format YYYY-MM-DD. The server limits how many days may
+	// be requested at once.
+	StartDay string `json:"startDay"`
+	EndDay   string `json:"endDay"`
+	// PageToken is the NextPageToken from a previous response for the same
+	// window. Leave empty to request the first page.
+	PageToken string `json:"pageToken,omitempty"`
+
+	Padding string `json:"padding"`
+}
+
+// KeyCountsResponse is the response to a KeyCountsRequest.
+type KeyCountsResponse struct {
+	// Counts are ordered by day and then region. Days and regions with no
+	// published keys are omitted.
+	Counts []*KeyCount `json:"counts,omitempty"`
+	// NextPageToken is set if there are more counts in the requested window.
+	NextPageToken string `json:"nextPageToken,omitempty"`
+
+	ErrorMessage string `json:"error,omitempty"`
+	ErrorCode    string `json:"code,omitempty"`
+
+	Padding string `json:"padding"`
+}
+
+// KeyCount is the number of exposure keys published for a region on a day.
+type KeyCount struct {
+	// Day is the UTC day, in the format YYYY-MM-DD.
+	Day    string `json:"day"`
+	Region string `json:"region"`
+	Count  int64  `json:"count"`
+}
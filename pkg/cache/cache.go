@@ -20,6 +20,7 @@
 package cache
 
 import (
+	"container/list"
 	"errors"
 	"sync"
 	"time"
@@ -34,7 +35,21 @@ type Func func() (interface{}, error)
 type Cache struct {
 	data        map[string]item
 	expireAfter time.Duration
-	mu          sync.RWMutex
+	staleGrace  time.Duration
+	// serveStale keeps expired values and serves them regardless of the stale
+	// grace, see SetServeStale.
+	serveStale bool
+	mu         sync.RWMutex
+
+	// maxSize, if positive, is the maximum number of items. The least recently
+	// used items are evicted beyond it, see SetMaxSize.
+	maxSize int
+	onEvict func(name string)
+	// recency orders the names from most to least recently used if maxSize is
+	// set. Lookups only hold a read lock on mu, so it has its own lock.
+	recency   *list.List
+	elements  map[string]*list.Element
+	recencyMu sync.Mutex
 }
 
 type item struct {
@@ -46,6 +61,11 @@ func (i *item) expired() bool {
 	return i.expiresAt < time.Now().UnixNano()
 }
 
+// staleWithin returns true if the item expired less than grace ago.
+func (i *item) staleWithin(grace time.Duration) bool {
+	return i.expiresAt+int64(grace) >= time.Now().UnixNano()
+}
+
 // New creates a new in memory cache.
 func New(expireAfter time.Duration) (*Cache, error) {
 	if expireAfter < 0 {
@@ -58,16 +78,146 @@ func New(expireAfter time.Duration) (*Cache, error) {
 	}, nil
 }
 
+// NewWithStaleGrace creates a new in memory cache that keeps expired values
+// for an additional staleGrace duration, so they can be returned by
+// WriteThruLookupWithStale if refreshing the value fails.
+func NewWithStaleGrace(expireAfter, staleGrace time.Duration) (*Cache, error) {
+	if staleGrace < 0 {
+		return nil, ErrInvalidDuration
+	}
+
+	c, err := New(expireAfter)
+	if err != nil {
+		return nil, err
+	}
+	c.staleGrace = staleGrace
+	return c, nil
+}
+
 // Removes an item by name and expiry time when the purge was scheduled.
 // If there is a race, and the item has been refreshed, it will not be purged.
 func (c *Cache) purgeExpired(name string, expectedExpiryTime int64) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if c.serveStale {
+		return
+	}
+
 	if item, ok := c.data[name]; ok && item.expiresAt == expectedExpiryTime {
 		// found, and the expiry time is still the same as when the purge was requested.
 		delete(c.data, name)
+		c.forget(name)
+	}
+}
+
+// SetMaxSize limits the cache to max items. Beyond it, the least recently used
+// items are evicted, regardless of their expiration, and onEvict is called with
+// the name of each. onEvict may be nil, otherwise it's called with the cache
+// locked and must not use the cache. A max of 0 removes the limit.
+func (c *Cache) SetMaxSize(max int, onEvict func(name string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.recencyMu.Lock()
+	defer c.recencyMu.Unlock()
+
+	c.maxSize = max
+	c.onEvict = onEvict
+	c.recency = nil
+	c.elements = nil
+	if max <= 0 {
+		return
+	}
+
+	c.recency = list.New()
+	c.elements = make(map[string]*list.Element, len(c.data))
+	for name := range c.data {
+		c.elements[name] = c.recency.PushBack(name)
+	}
+	c.evict()
+}
+
+// touch marks the item as the most recently used. It must be called with a
+// lock held on mu.
+func (c *Cache) touch(name string) {
+	c.recencyMu.Lock()
+	defer c.recencyMu.Unlock()
+
+	if c.recency == nil {
+		return
+	}
+	if e, ok := c.elements[name]; ok {
+		c.recency.MoveToFront(e)
+		return
+	}
+	c.elements[name] = c.recency.PushFront(name)
+}
+
+// forget removes the item from the recency list. It must be called with a
+// write lock held on mu.
+func (c *Cache) forget(name string) {
+	c.recencyMu.Lock()
+	defer c.recencyMu.Unlock()
+
+	if e, ok := c.elements[name]; ok {
+		c.recency.Remove(e)
+		delete(c.elements, name)
+	}
+}
+
+// evict removes the least recently used items beyond maxSize. It must be called
+// with write locks held on mu and recencyMu.
+func (c *Cache) evict() {
+	for c.recency != nil && len(c.data) > c.maxSize {
+		e := c.recency.Back()
+		if e == nil {
+			return
+		}
+		name := c.recency.Remove(e).(string)
+		delete(c.elements, name)
+		delete(c.data, name)
+		if c.onEvict != nil {
+			c.onEvict(name)
+		}
+	}
+}
+
+// store saves the object in the cache and evicts the least recently used items
+// if the cache is full. It must be called with a write lock held on mu.
+func (c *Cache) store(name string, object interface{}) {
+	c.data[name] = item{
+		object:    object,
+		expiresAt: time.Now().Add(c.expireAfter).UnixNano(),
 	}
+
+	c.touch(name)
+	c.recencyMu.Lock()
+	defer c.recencyMu.Unlock()
+	c.evict()
+}
+
+// SetServeStale sets whether expired values are kept and returned by
+// WriteThruLookupWithStale if refreshing them fails, however long ago they
+// expired. This is meant for planned outages of the primary source. When
+// cleared, the stale grace applies again.
+func (c *Cache) SetServeStale(serveStale bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.serveStale = serveStale
+}
+
+// ServeStale returns true if expired values are served regardless of the stale
+// grace.
+func (c *Cache) ServeStale() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.serveStale
+}
+
+// usableStale returns true if the expired item may still be returned as a
+// stale value. It must be called with a lock held.
+func (c *Cache) usableStale(i *item) bool {
+	return c.serveStale || i.staleWithin(c.staleGrace)
 }
 
 // Size returns the number of items in the cache.
@@ -82,6 +232,13 @@ func (c *Cache) Clear() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.data = make(map[string]item, initialSize)
+
+	c.recencyMu.Lock()
+	defer c.recencyMu.Unlock()
+	if c.recency != nil {
+		c.recency.Init()
+		c.elements = make(map[string]*list.Element, initialSize)
+	}
 }
 
 // WriteThruLookup checks the cache for the value associated with name,
@@ -115,13 +272,43 @@ func (c *Cache) WriteThruLookup(name string, primaryLookup Func) (interface{}, e
 	}
 
 	// save the newData in the cache. newData may be nil, if that's what the WriteThruFunction provided.
-	c.data[name] = item{
-		object:    newData,
-		expiresAt: time.Now().Add(c.expireAfter).UnixNano(),
-	}
+	c.store(name, newData)
 	return newData, nil
 }
 
+// WriteThruLookupWithStale behaves like WriteThruLookup, but if the
+// primaryLookup function returns an error and the cache holds a value that
+// expired within the stale grace period, that value is returned instead of the
+// error. The bool return is true if a stale value was returned.
+func (c *Cache) WriteThruLookupWithStale(name string, primaryLookup Func) (interface{}, bool, error) {
+	c.mu.RLock()
+	val, hit := c.lookup(name)
+	if hit {
+		c.mu.RUnlock()
+		return val, false, nil
+	}
+	c.mu.RUnlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	val, hit = c.lookup(name)
+	if hit {
+		return val, false, nil
+	}
+
+	newData, err := primaryLookup()
+	if err != nil {
+		if item, ok := c.data[name]; ok && c.usableStale(&item) {
+			c.touch(name)
+			return item.object, true, nil
+		}
+		return nil, false, err
+	}
+
+	c.store(name, newData)
+	return newData, false, nil
+}
+
 // Lookup checks the cache for a non-expired object by the supplied key name.
 // The bool return informs the caller if there was a cache hit or not.
 // A return of nil, true means that nil is in the cache.
@@ -140,11 +327,7 @@ func (c *Cache) Set(name string, object interface{}) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.data[name] = item{
-		object:    object,
-		expiresAt: time.Now().Add(c.expireAfter).UnixNano(),
-	}
-
+	c.store(name, object)
 	return nil
 }
 
@@ -153,11 +336,15 @@ func (c *Cache) Set(name string, object interface{}) error {
 // take out a read or read-write lock.
 func (c *Cache) lookup(name string) (interface{}, bool) {
 	if item, ok := c.data[name]; ok && item.expired() {
-		// Cache hit, but expired. The removal from the cache is deferred.
-		go c.purgeExpired(name, item.expiresAt)
+		// Cache hit, but expired. The removal from the cache is deferred, unless
+		// the item may still be used as a stale value.
+		if !c.usableStale(&item) {
+			go c.purgeExpired(name, item.expiresAt)
+		}
 		return nil, false
 	} else if ok {
 		// Cache hit, not expired.
+		c.touch(name)
 		return item.object, true
 	}
 
@@ -156,11 +156,228 @@ func TestWriteThruError(t *testing.T) {
 	}
 }
 
+func TestWriteThruLookupWithStale(t *testing.T) {
+	t.Parallel()
+
+	expireAfter := 100 * time.Millisecond
+	staleGrace := 500 * time.Millisecond
+	cache, err := NewWithStaleGrace(expireAfter, staleGrace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &order{12, 34}
+	lookupOK := func() (interface{}, error) {
+		return want, nil
+	}
+	lookupErr := func() (interface{}, error) {
+		return nil, fmt.Errorf("nope")
+	}
+
+	// Initial lookup populates the cache.
+	got, stale, err := cache.WriteThruLookupWithStale("foo", lookupOK)
+	if err != nil {
+		t.Fatalf("unexpected error on WriteThruLookupWithStale: %v", err)
+	}
+	if stale {
+		t.Errorf("expected fresh value")
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Within the grace period, a failed refresh returns the stale value.
+	time.Sleep(2 * expireAfter)
+	if got, hit := cache.Lookup("foo"); got != nil || hit {
+		t.Fatalf("key did not expire as expected")
+	}
+	got, stale, err = cache.WriteThruLookupWithStale("foo", lookupErr)
+	if err != nil {
+		t.Fatalf("expected stale value, got error: %v", err)
+	}
+	if !stale {
+		t.Errorf("expected stale value")
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// After the grace period, the error is returned.
+	time.Sleep(staleGrace)
+	got, stale, err = cache.WriteThruLookupWithStale("foo", lookupErr)
+	errcmp.MustMatch(t, err, "nope")
+	if stale || got != nil {
+		t.Errorf("unexpected cached item, want: nil, got %v (stale: %v)", got, stale)
+	}
+
+	// Keys that were never cached return the error.
+	if _, _, err := cache.WriteThruLookupWithStale("bar", lookupErr); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestWriteThruLookupWithoutStaleGrace(t *testing.T) {
+	t.Parallel()
+
+	expireAfter := 100 * time.Millisecond
+	cache, err := New(expireAfter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.Set("foo", &order{2, 1}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * expireAfter)
+
+	_, stale, err := cache.WriteThruLookupWithStale("foo", func() (interface{}, error) {
+		return nil, fmt.Errorf("nope")
+	})
+	errcmp.MustMatch(t, err, "nope")
+	if stale {
+		t.Errorf("expected no stale value without a grace period")
+	}
+}
+
+func TestWriteThruLookupServeStale(t *testing.T) {
+	t.Parallel()
+
+	expireAfter := 100 * time.Millisecond
+	cache, err := New(expireAfter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.SetServeStale(true)
+	if !cache.ServeStale() {
+		t.Fatalf("expected cache to serve stale values")
+	}
+
+	want := &order{3, 4}
+	if err := cache.Set("foo", want); err != nil {
+		t.Fatal(err)
+	}
+	lookupErr := func() (interface{}, error) {
+		return nil, fmt.Errorf("nope")
+	}
+
+	// Without a grace period, the expired value is still served.
+	time.Sleep(3 * expireAfter)
+	got, stale, err := cache.WriteThruLookupWithStale("foo", lookupErr)
+	if err != nil {
+		t.Fatalf("expected stale value, got error: %v", err)
+	}
+	if !stale {
+		t.Errorf("expected stale value")
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Once cleared, the error is returned.
+	cache.SetServeStale(false)
+	got, stale, err = cache.WriteThruLookupWithStale("foo", lookupErr)
+	errcmp.MustMatch(t, err, "nope")
+	if stale || got != nil {
+		t.Errorf("unexpected cached item, want: nil, got %v (stale: %v)", got, stale)
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	t.Parallel()
+
+	cache, err := New(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if err := cache.Set(name, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var evicted []string
+	onEvict := func(name string) {
+		evicted = append(evicted, name)
+	}
+
+	// Lowering the limit evicts existing items.
+	cache.SetMaxSize(2, onEvict)
+	checkSize(t, cache, 2)
+	if len(evicted) != 1 {
+		t.Fatalf("expected 1 eviction, got %v", evicted)
+	}
+
+	// The least recently used item is evicted, regardless of insertion order.
+	cache.Clear()
+	evicted = nil
+	if err := cache.Set("a", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Set("b", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, hit := cache.Lookup("a"); !hit {
+		t.Fatal("expected a to be cached")
+	}
+	lookup := func() (interface{}, error) {
+		return "c", nil
+	}
+	if _, err := cache.WriteThruLookup("c", lookup); err != nil {
+		t.Fatal(err)
+	}
+	checkSize(t, cache, 2)
+	if diff := cmp.Diff([]string{"b"}, evicted); diff != "" {
+		t.Errorf("evicted mismatch (-want, +got):\n%s", diff)
+	}
+	for _, name := range []string{"a", "c"} {
+		if _, hit := cache.Lookup(name); !hit {
+			t.Errorf("expected %s to be cached", name)
+		}
+	}
+
+	// The cache never exceeds the limit.
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("flood-%d", i)
+		if _, _, err := cache.WriteThruLookupWithStale(name, lookup); err != nil {
+			t.Fatal(err)
+		}
+		if got := cache.Size(); got > 2 {
+			t.Fatalf("expected at most 2 items, got %d", got)
+		}
+	}
+	if got, want := len(evicted), 101; got != want {
+		t.Errorf("expected %d evictions, got %d", want, got)
+	}
+
+	// An evicted item is looked up again.
+	got, err := cache.WriteThruLookup("a", func() (interface{}, error) {
+		return "a again", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "a again" {
+		t.Errorf("expected evicted item to be looked up again, got %v", got)
+	}
+
+	// Removing the limit stops evictions.
+	cache.SetMaxSize(0, nil)
+	for i := 0; i < 5; i++ {
+		if err := cache.Set(fmt.Sprintf("unlimited-%d", i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkSize(t, cache, 7)
+}
+
 func TestInvalidDuration(t *testing.T) {
 	t.Parallel()
 
 	_, err := New(-1 * time.Second)
 	errcmp.MustMatch(t, err, "duration cannot be negative")
+
+	_, err = NewWithStaleGrace(time.Second, -1*time.Second)
+	errcmp.MustMatch(t, err, "duration cannot be negative")
 }
 
 func TestConcurrentReaders(t *testing.T) {
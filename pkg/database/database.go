@@ -31,6 +31,29 @@ var (
 	ErrKeyConflict = errors.New("key conflict")
 )
 
+// Postgres error codes of transactions that failed because of concurrent
+// transactions, and may succeed if they are run again.
+const (
+	sqlStateSerializationFailure = "40001"
+	sqlStateDeadlockDetected     = "40P01"
+)
+
+// IsRetryable returns true if the error is a Postgres serialization failure or
+// deadlock. The transaction was rolled back and may be run again.
+func IsRetryable(err error) bool {
+	// Implemented by *pgconn.PgError.
+	var pgErr interface{ SQLState() string }
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	switch pgErr.SQLState() {
+	case sqlStateSerializationFailure, sqlStateDeadlockDetected:
+		return true
+	default:
+		return false
+	}
+}
+
 func NullableTime(t time.Time) *time.Time {
 	if t.IsZero() {
 		return nil
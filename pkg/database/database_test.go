@@ -15,8 +15,12 @@
 package database
 
 import (
+	"errors"
+	"fmt"
 	"testing"
 	"time"
+
+	"github.com/jackc/pgconn"
 )
 
 var testDatabaseInstance *TestInstance
@@ -47,3 +51,32 @@ func TestNullableTime(t *testing.T) {
 		}
 	})
 }
+
+func TestIsRetryable(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "other", err: errors.New("connection refused"), want: false},
+		{name: "serialization_failure", err: &pgconn.PgError{Code: "40001"}, want: true},
+		{name: "deadlock", err: &pgconn.PgError{Code: "40P01"}, want: true},
+		{name: "wrapped", err: fmt.Errorf("committing transaction: %w", &pgconn.PgError{Code: "40001"}), want: true},
+		{name: "unique_violation", err: &pgconn.PgError{Code: "23505"}, want: false},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := IsRetryable(tc.err); got != tc.want {
+				t.Errorf("expected IsRetryable(%v) to be %t", tc.err, tc.want)
+			}
+		})
+	}
+}
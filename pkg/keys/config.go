@@ -27,4 +27,13 @@ type Config struct {
 
 	// FilesystemRoot is the root path where keys are managed on the filesystem.
 	FilesystemRoot string `env:"KEY_FILESYSTEM_ROOT"`
+
+	// PKCS11ModulePath is the path of the PKCS#11 module (shared library) of
+	// the token that holds the signing keys. The token is selected by
+	// PKCS11TokenLabel if set, otherwise by PKCS11Slot. PKCS11PIN is the user
+	// PIN of the token, and should come from secret://.
+	PKCS11ModulePath string `env:"KEY_PKCS11_MODULE_PATH"`
+	PKCS11TokenLabel string `env:"KEY_PKCS11_TOKEN_LABEL"`
+	PKCS11Slot       uint   `env:"KEY_PKCS11_SLOT, default=0"`
+	PKCS11PIN        string `env:"KEY_PKCS11_PIN"`
 }
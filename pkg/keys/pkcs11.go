@@ -0,0 +1,181 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build pkcs11
+
+package keys
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+func init() {
+	RegisterManager("PKCS11", NewPKCS11)
+}
+
+// NewPKCS11 creates a new PKCS#11 key manager. It loads the module at
+// PKCS11ModulePath and logs in to the token, which is selected by
+// PKCS11TokenLabel, or by PKCS11Slot if no label is given.
+//
+// The PKCS#11 module is loaded with cgo, so this key manager is only compiled
+// with the pkcs11 build tag and cgo enabled.
+func NewPKCS11(ctx context.Context, cfg *Config) (KeyManager, error) {
+	if cfg.PKCS11ModulePath == "" {
+		return nil, fmt.Errorf("KEY_PKCS11_MODULE_PATH is required")
+	}
+
+	ctx11 := pkcs11.New(cfg.PKCS11ModulePath)
+	if ctx11 == nil {
+		return nil, fmt.Errorf("failed to load PKCS#11 module %q", cfg.PKCS11ModulePath)
+	}
+	if err := ctx11.Initialize(); err != nil {
+		ctx11.Destroy()
+		return nil, fmt.Errorf("failed to initialize PKCS#11 module: %w", err)
+	}
+
+	slot, err := pkcs11Slot(ctx11, cfg)
+	if err != nil {
+		ctx11.Finalize()
+		ctx11.Destroy()
+		return nil, err
+	}
+
+	session, err := ctx11.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		ctx11.Finalize()
+		ctx11.Destroy()
+		return nil, fmt.Errorf("failed to open PKCS#11 session: %w", err)
+	}
+	if err := ctx11.Login(session, pkcs11.CKU_USER, cfg.PKCS11PIN); err != nil {
+		ctx11.CloseSession(session)
+		ctx11.Finalize()
+		ctx11.Destroy()
+		return nil, fmt.Errorf("failed to log in to PKCS#11 token: %w", err)
+	}
+
+	return &PKCS11{
+		token: &pkcs11Module{
+			ctx:     ctx11,
+			session: session,
+		},
+	}, nil
+}
+
+// pkcs11Slot returns the slot of the configured token.
+func pkcs11Slot(ctx11 *pkcs11.Ctx, cfg *Config) (uint, error) {
+	if cfg.PKCS11TokenLabel == "" {
+		return cfg.PKCS11Slot, nil
+	}
+
+	slots, err := ctx11.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list PKCS#11 slots: %w", err)
+	}
+	for _, slot := range slots {
+		info, err := ctx11.GetTokenInfo(slot)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get PKCS#11 token info for slot %d: %w", slot, err)
+		}
+		if info.Label == cfg.PKCS11TokenLabel {
+			return slot, nil
+		}
+	}
+	return 0, fmt.Errorf("no PKCS#11 token with label %q", cfg.PKCS11TokenLabel)
+}
+
+// pkcs11Module is a logged in session with a PKCS#11 token. Operations in a
+// session must not run concurrently, so they are serialized.
+type pkcs11Module struct {
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+
+	mu sync.Mutex
+}
+
+func (m *pkcs11Module) ecPublicKey(label string) ([]byte, []byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	obj, err := m.findObject(pkcs11.CKO_PUBLIC_KEY, label)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	attrs, err := m.ctx.GetAttributeValue(m.session, obj, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get public key attributes: %w", err)
+	}
+
+	var params, point []byte
+	for _, attr := range attrs {
+		switch attr.Type {
+		case pkcs11.CKA_EC_PARAMS:
+			params = attr.Value
+		case pkcs11.CKA_EC_POINT:
+			point = attr.Value
+		}
+	}
+	return params, point, nil
+}
+
+func (m *pkcs11Module) signECDSA(label string, digest []byte) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	obj, err := m.findObject(pkcs11.CKO_PRIVATE_KEY, label)
+	if err != nil {
+		return nil, err
+	}
+
+	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)}
+	if err := m.ctx.SignInit(m.session, mechanism, obj); err != nil {
+		return nil, fmt.Errorf("failed to initialize signing: %w", err)
+	}
+	return m.ctx.Sign(m.session, digest)
+}
+
+// findObject returns the only object of the given class with the given label.
+// The caller must hold the lock.
+func (m *pkcs11Module) findObject(class uint, label string) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+	if err := m.ctx.FindObjectsInit(m.session, template); err != nil {
+		return 0, fmt.Errorf("failed to find objects: %w", err)
+	}
+	objs, _, err := m.ctx.FindObjects(m.session, 2)
+	if finalErr := m.ctx.FindObjectsFinal(m.session); err == nil {
+		err = finalErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to find objects: %w", err)
+	}
+
+	switch len(objs) {
+	case 0:
+		return 0, fmt.Errorf("no key with label %q", label)
+	case 1:
+		return objs[0], nil
+	default:
+		return 0, fmt.Errorf("more than one key with label %q", label)
+	}
+}
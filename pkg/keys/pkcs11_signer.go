@@ -0,0 +1,158 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+)
+
+// Compile-time check to verify implements interface.
+var (
+	_ KeyManager    = (*PKCS11)(nil)
+	_ crypto.Signer = (*PKCS11Signer)(nil)
+)
+
+// oidNamedCurveP256 is the CKA_EC_PARAMS of a P-256 key.
+var oidNamedCurveP256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+
+// pkcs11Token is the part of a PKCS#11 token that is used to sign with ECDSA
+// keys. Keys are found by their CKA_LABEL, the private and public key objects
+// must have the same label.
+//
+// It is implemented on top of the PKCS#11 module in pkcs11.go, which requires
+// cgo and is only compiled with the pkcs11 build tag.
+type pkcs11Token interface {
+	// ecPublicKey returns the CKA_EC_PARAMS and CKA_EC_POINT attributes of the
+	// public key with the given label.
+	ecPublicKey(label string) (params, point []byte, err error)
+
+	// signECDSA signs the digest with CKM_ECDSA and the private key with the
+	// given label. The signature is r and s concatenated, as defined by
+	// PKCS#11.
+	signECDSA(label string, digest []byte) ([]byte, error)
+}
+
+// PKCS11 implements the keys.KeyManager interface for signing keys that are
+// kept in a PKCS#11 token, e.g. an on-prem HSM. It can only be used for
+// signing export files, the private keys never leave the token. Encryption is
+// not supported.
+//
+// The key ID of a signing key is the CKA_LABEL of its key pair, which must be
+// a P-256 ECDSA key.
+type PKCS11 struct {
+	token pkcs11Token
+}
+
+// NewSigner returns a signer for the key pair with the given label.
+func (p *PKCS11) NewSigner(ctx context.Context, keyID string) (crypto.Signer, error) {
+	return newPKCS11Signer(p.token, keyID)
+}
+
+// Encrypt is not supported by the PKCS#11 key manager.
+func (p *PKCS11) Encrypt(ctx context.Context, keyID string, plaintext []byte, aad []byte) ([]byte, error) {
+	return nil, errors.New("encryption is not supported by the PKCS#11 key manager")
+}
+
+// Decrypt is not supported by the PKCS#11 key manager.
+func (p *PKCS11) Decrypt(ctx context.Context, keyID string, ciphertext []byte, aad []byte) ([]byte, error) {
+	return nil, errors.New("decryption is not supported by the PKCS#11 key manager")
+}
+
+// PKCS11Signer signs with a P-256 ECDSA key in a PKCS#11 token.
+type PKCS11Signer struct {
+	token     pkcs11Token
+	label     string
+	publicKey *ecdsa.PublicKey
+}
+
+func newPKCS11Signer(token pkcs11Token, label string) (*PKCS11Signer, error) {
+	params, point, err := token.ecPublicKey(label)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public key %q: %w", label, err)
+	}
+
+	publicKey, err := parsePKCS11ECPublicKey(params, point)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key %q: %w", label, err)
+	}
+
+	return &PKCS11Signer{
+		token:     token,
+		label:     label,
+		publicKey: publicKey,
+	}, nil
+}
+
+// Public returns the public key. The public key is fetched when the signer is
+// created.
+func (s *PKCS11Signer) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// Sign signs the given digest in the token. The signature is ASN.1 encoded,
+// like the signatures of the other key managers.
+func (s *PKCS11Signer) Sign(_ io.Reader, digest []byte, _ crypto.SignerOpts) ([]byte, error) {
+	b, err := s.token.signECDSA(s.label, digest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+	if len(b) == 0 || len(b)%2 != 0 {
+		return nil, fmt.Errorf("invalid signature length %d", len(b))
+	}
+
+	rs := struct {
+		R, S *big.Int
+	}{
+		R: new(big.Int).SetBytes(b[:len(b)/2]),
+		S: new(big.Int).SetBytes(b[len(b)/2:]),
+	}
+	return asn1.Marshal(rs)
+}
+
+// parsePKCS11ECPublicKey parses the CKA_EC_PARAMS and CKA_EC_POINT of a P-256
+// public key. The point is a DER encoded octet string, but some tokens return
+// the uncompressed point itself, so that is accepted too.
+func parsePKCS11ECPublicKey(params, point []byte) (*ecdsa.PublicKey, error) {
+	var oid asn1.ObjectIdentifier
+	if rest, err := asn1.Unmarshal(params, &oid); err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("invalid EC params")
+	}
+	if !oid.Equal(oidNamedCurveP256) {
+		return nil, fmt.Errorf("curve %v is not P256", oid)
+	}
+
+	var raw []byte
+	if rest, err := asn1.Unmarshal(point, &raw); err != nil || len(rest) > 0 {
+		raw = point
+	}
+	x, y := elliptic.Unmarshal(elliptic.P256(), raw)
+	if x == nil {
+		return nil, fmt.Errorf("invalid EC point")
+	}
+
+	return &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     x,
+		Y:     y,
+	}, nil
+}
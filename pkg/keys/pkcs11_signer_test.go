@@ -0,0 +1,179 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"fmt"
+	"testing"
+
+	"github.com/google/exposure-notifications-server/pkg/errcmp"
+)
+
+// fakePKCS11Token is a PKCS#11 token with software keys, that returns
+// attributes and signatures in the PKCS#11 formats.
+type fakePKCS11Token struct {
+	keys   map[string]*ecdsa.PrivateKey
+	params map[string][]byte
+	// rawPoint returns the EC point without the octet string wrapping.
+	rawPoint bool
+}
+
+func (f *fakePKCS11Token) ecPublicKey(label string) ([]byte, []byte, error) {
+	key, ok := f.keys[label]
+	if !ok {
+		return nil, nil, fmt.Errorf("no key with label %q", label)
+	}
+
+	params, ok := f.params[label]
+	if !ok {
+		var err error
+		if params, err = asn1.Marshal(oidNamedCurveP256); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	point := elliptic.Marshal(key.Curve, key.X, key.Y)
+	if f.rawPoint {
+		return params, point, nil
+	}
+	wrapped, err := asn1.Marshal(point)
+	if err != nil {
+		return nil, nil, err
+	}
+	return params, wrapped, nil
+}
+
+func (f *fakePKCS11Token) signECDSA(label string, digest []byte) ([]byte, error) {
+	key, ok := f.keys[label]
+	if !ok {
+		return nil, fmt.Errorf("no key with label %q", label)
+	}
+
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+	if err != nil {
+		return nil, err
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return sig, nil
+}
+
+func TestPKCS11_NewSigner(t *testing.T) {
+	t.Parallel()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384Params, err := asn1.Marshal(asn1.ObjectIdentifier{1, 3, 132, 0, 34})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		label    string
+		rawPoint bool
+		err      string
+	}{
+		{
+			name:  "signs",
+			label: "export-signing",
+		},
+		{
+			name:     "raw_point",
+			label:    "export-signing",
+			rawPoint: true,
+		},
+		{
+			name:  "missing_key",
+			label: "nope",
+			err:   `failed to get public key "nope": no key with label "nope"`,
+		},
+		{
+			name:  "wrong_curve",
+			label: "p384",
+			err:   "curve 1.3.132.0.34 is not P256",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			km := &PKCS11{
+				token: &fakePKCS11Token{
+					keys: map[string]*ecdsa.PrivateKey{
+						"export-signing": key,
+						"p384":           p384Key,
+					},
+					params:   map[string][]byte{"p384": p384Params},
+					rawPoint: tc.rawPoint,
+				},
+			}
+
+			signer, err := km.NewSigner(ctx, tc.label)
+			errcmp.MustMatch(t, err, tc.err)
+			if err != nil {
+				return
+			}
+
+			publicKey, ok := signer.Public().(*ecdsa.PublicKey)
+			if !ok {
+				t.Fatalf("expected *ecdsa.PublicKey, got %T", signer.Public())
+			}
+			if !publicKey.Equal(&key.PublicKey) {
+				t.Errorf("expected public key of the token")
+			}
+
+			// The signature must be ASN.1, like export files are verified.
+			digest := sha256.Sum256([]byte("export.bin"))
+			sig, err := signer.Sign(rand.Reader, digest[:], nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], sig) {
+				t.Errorf("signature does not verify")
+			}
+		})
+	}
+}
+
+func TestPKCS11_Encrypt(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	km := &PKCS11{token: &fakePKCS11Token{}}
+
+	_, err := km.Encrypt(ctx, "key", []byte("plaintext"), nil)
+	errcmp.MustMatch(t, err, "not supported")
+
+	_, err = km.Decrypt(ctx, "key", []byte("ciphertext"), nil)
+	errcmp.MustMatch(t, err, "not supported")
+}
@@ -0,0 +1,49 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
+	"github.com/google/exposure-notifications-server/pkg/logging"
+)
+
+// HandleNotFound returns a 404 with a JSON error body, for requests to paths
+// the server has no endpoint for.
+func HandleNotFound() http.Handler {
+	return handleError(http.StatusNotFound, verifyapi.ErrorNotFound)
+}
+
+// HandleMethodNotAllowed returns a 405 with a JSON error body, for requests to
+// endpoints that don't accept the request method.
+func HandleMethodNotAllowed() http.Handler {
+	return handleError(http.StatusMethodNotAllowed, verifyapi.ErrorMethodNotAllowed)
+}
+
+func handleError(status int, code string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(&verifyapi.ErrorResponse{
+			ErrorMessage: http.StatusText(status),
+			Code:         code,
+		}); err != nil {
+			logging.FromContext(r.Context()).Named("server.handleError").
+				Errorw("failed to write error response", "error", err)
+		}
+	})
+}
@@ -0,0 +1,95 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	verifyapi "github.com/google/exposure-notifications-server/pkg/api/v1"
+	"github.com/google/go-cmp/cmp"
+	"github.com/gorilla/mux"
+)
+
+func TestHandleErrors(t *testing.T) {
+	t.Parallel()
+
+	r := mux.NewRouter()
+	r.NotFoundHandler = HandleNotFound()
+	r.MethodNotAllowedHandler = HandleMethodNotAllowed()
+	r.Handle("/publish", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})).Methods(http.MethodPost)
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		want   *verifyapi.ErrorResponse
+	}{
+		{
+			name:   "unknown_path",
+			method: http.MethodPost,
+			path:   "/nope",
+			status: http.StatusNotFound,
+			want:   &verifyapi.ErrorResponse{ErrorMessage: "Not Found", Code: verifyapi.ErrorNotFound},
+		},
+		{
+			name:   "wrong_method",
+			method: http.MethodGet,
+			path:   "/publish",
+			status: http.StatusMethodNotAllowed,
+			want:   &verifyapi.ErrorResponse{ErrorMessage: "Method Not Allowed", Code: verifyapi.ErrorMethodNotAllowed},
+		},
+		{
+			name:   "found",
+			method: http.MethodPost,
+			path:   "/publish",
+			status: http.StatusOK,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+
+			if got, want := w.Code, tc.status; got != want {
+				t.Fatalf("expected status %d to be %d", got, want)
+			}
+			if tc.want == nil {
+				return
+			}
+
+			if got, want := w.Header().Get("Content-Type"), "application/json"; got != want {
+				t.Errorf("expected content-type %q to be %q", got, want)
+			}
+			var got verifyapi.ErrorResponse
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, &got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
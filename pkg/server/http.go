@@ -0,0 +1,119 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// HTTPConfig is the connection tuning of the HTTP server. The defaults keep
+// the request timeouts unlimited, since some handlers, like the export worker,
+// run for minutes, but bound how long a client can take to send the headers
+// and how long idle connections are kept.
+type HTTPConfig struct {
+	// ReadTimeout, ReadHeaderTimeout, WriteTimeout and IdleTimeout are the
+	// timeouts of the same name of http.Server. Zero means no timeout.
+	ReadTimeout       time.Duration `env:"HTTP_READ_TIMEOUT, default=0"`
+	ReadHeaderTimeout time.Duration `env:"HTTP_READ_HEADER_TIMEOUT, default=10s"`
+	WriteTimeout      time.Duration `env:"HTTP_WRITE_TIMEOUT, default=0"`
+	IdleTimeout       time.Duration `env:"HTTP_IDLE_TIMEOUT, default=120s"`
+
+	// KeepAlives enables HTTP/1.1 keep-alive connections.
+	KeepAlives bool `env:"HTTP_KEEP_ALIVES, default=true"`
+
+	// HTTP2 enables HTTP/2 for TLS connections. HTTP2Cleartext additionally
+	// serves HTTP/2 without TLS (h2c), e.g. behind a load balancer that
+	// terminates TLS and speaks HTTP/2 to the backend. It cannot be used when
+	// the server terminates TLS itself.
+	HTTP2          bool `env:"HTTP2_ENABLED, default=true"`
+	HTTP2Cleartext bool `env:"HTTP2_CLEARTEXT, default=false"`
+	// HTTP2MaxConcurrentStreams is the number of concurrent streams each
+	// HTTP/2 client may open.
+	HTTP2MaxConcurrentStreams uint32 `env:"HTTP2_MAX_CONCURRENT_STREAMS, default=250"`
+}
+
+// Validate checks that the settings are valid together.
+func (c *HTTPConfig) Validate() error {
+	var result *multierror.Error
+
+	for _, t := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"HTTP_READ_TIMEOUT", c.ReadTimeout},
+		{"HTTP_READ_HEADER_TIMEOUT", c.ReadHeaderTimeout},
+		{"HTTP_WRITE_TIMEOUT", c.WriteTimeout},
+		{"HTTP_IDLE_TIMEOUT", c.IdleTimeout},
+	} {
+		if t.d < 0 {
+			result = multierror.Append(result,
+				fmt.Errorf("env var `%s` must be >= 0, got: %v", t.name, t.d))
+		}
+	}
+	if c.ReadTimeout > 0 && c.ReadHeaderTimeout > c.ReadTimeout {
+		result = multierror.Append(result,
+			fmt.Errorf("env var `HTTP_READ_HEADER_TIMEOUT` must be <= `HTTP_READ_TIMEOUT`, got: %v > %v", c.ReadHeaderTimeout, c.ReadTimeout))
+	}
+	if !c.KeepAlives && c.IdleTimeout > 0 {
+		result = multierror.Append(result,
+			fmt.Errorf("env var `HTTP_IDLE_TIMEOUT` must be 0 when `HTTP_KEEP_ALIVES` is false, got: %v", c.IdleTimeout))
+	}
+
+	if c.HTTP2 && c.HTTP2MaxConcurrentStreams == 0 {
+		result = multierror.Append(result,
+			fmt.Errorf("env var `HTTP2_MAX_CONCURRENT_STREAMS` must be > 0"))
+	}
+	if !c.HTTP2 && c.HTTP2Cleartext {
+		result = multierror.Append(result,
+			fmt.Errorf("env var `HTTP2_CLEARTEXT` requires `HTTP2_ENABLED`"))
+	}
+
+	return result.ErrorOrNil()
+}
+
+// configure applies the settings to the server. It must be called after the
+// server's TLS config is set, and before it serves.
+func (c *HTTPConfig) configure(srv *http.Server) error {
+	srv.ReadTimeout = c.ReadTimeout
+	srv.ReadHeaderTimeout = c.ReadHeaderTimeout
+	srv.WriteTimeout = c.WriteTimeout
+	srv.IdleTimeout = c.IdleTimeout
+	srv.SetKeepAlivesEnabled(c.KeepAlives)
+
+	if !c.HTTP2 {
+		// A non-nil, empty map disables HTTP/2.
+		srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		return nil
+	}
+
+	h2s := &http2.Server{
+		MaxConcurrentStreams: c.HTTP2MaxConcurrentStreams,
+		IdleTimeout:          c.IdleTimeout,
+	}
+	if err := http2.ConfigureServer(srv, h2s); err != nil {
+		return fmt.Errorf("failed to configure HTTP/2: %w", err)
+	}
+	if c.HTTP2Cleartext {
+		srv.Handler = h2c.NewHandler(srv.Handler, h2s)
+	}
+	return nil
+}
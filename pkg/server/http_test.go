@@ -0,0 +1,301 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/project"
+	"golang.org/x/net/http2"
+)
+
+// testHTTPConfig returns the defaults of HTTPConfig.
+func testHTTPConfig() *HTTPConfig {
+	return &HTTPConfig{
+		ReadHeaderTimeout:         10 * time.Second,
+		IdleTimeout:               120 * time.Second,
+		KeepAlives:                true,
+		HTTP2:                     true,
+		HTTP2MaxConcurrentStreams: 250,
+	}
+}
+
+func TestHTTPConfig_Validate(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		modify  func(c *HTTPConfig)
+		wantErr string
+	}{
+		{
+			name:   "defaults",
+			modify: func(c *HTTPConfig) {},
+		},
+		{
+			name: "http1_only",
+			modify: func(c *HTTPConfig) {
+				c.HTTP2 = false
+				c.HTTP2MaxConcurrentStreams = 0
+			},
+		},
+		{
+			name:    "negative_timeout",
+			modify:  func(c *HTTPConfig) { c.WriteTimeout = -time.Second },
+			wantErr: "`HTTP_WRITE_TIMEOUT` must be >= 0",
+		},
+		{
+			name:    "header_timeout_longer_than_read",
+			modify:  func(c *HTTPConfig) { c.ReadTimeout = 5 * time.Second },
+			wantErr: "`HTTP_READ_HEADER_TIMEOUT` must be <= `HTTP_READ_TIMEOUT`",
+		},
+		{
+			name:    "idle_timeout_without_keep_alives",
+			modify:  func(c *HTTPConfig) { c.KeepAlives = false },
+			wantErr: "`HTTP_IDLE_TIMEOUT` must be 0",
+		},
+		{
+			name:    "no_streams",
+			modify:  func(c *HTTPConfig) { c.HTTP2MaxConcurrentStreams = 0 },
+			wantErr: "`HTTP2_MAX_CONCURRENT_STREAMS` must be > 0",
+		},
+		{
+			name: "cleartext_without_http2",
+			modify: func(c *HTTPConfig) {
+				c.HTTP2 = false
+				c.HTTP2Cleartext = true
+			},
+			wantErr: "`HTTP2_CLEARTEXT` requires `HTTP2_ENABLED`",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := testHTTPConfig()
+			tc.modify(cfg)
+			err := cfg.Validate()
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error containing %q, got: %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestHTTPConfig_Configure(t *testing.T) {
+	t.Parallel()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	cfg := testHTTPConfig()
+	cfg.ReadTimeout = 30 * time.Second
+	cfg.WriteTimeout = time.Minute
+
+	srv := &http.Server{Handler: handler}
+	if err := cfg.configure(srv); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := srv.ReadTimeout, 30*time.Second; got != want {
+		t.Errorf("expected read timeout %v to be %v", got, want)
+	}
+	if got, want := srv.ReadHeaderTimeout, 10*time.Second; got != want {
+		t.Errorf("expected read header timeout %v to be %v", got, want)
+	}
+	if got, want := srv.WriteTimeout, time.Minute; got != want {
+		t.Errorf("expected write timeout %v to be %v", got, want)
+	}
+	if got, want := srv.IdleTimeout, 120*time.Second; got != want {
+		t.Errorf("expected idle timeout %v to be %v", got, want)
+	}
+	if _, ok := srv.TLSNextProto[http2.NextProtoTLS]; !ok {
+		t.Errorf("expected HTTP/2 to be configured")
+	}
+	if _, ok := srv.Handler.(http.HandlerFunc); !ok {
+		t.Errorf("expected handler to not be wrapped without cleartext HTTP/2, got %T", srv.Handler)
+	}
+
+	// Cleartext HTTP/2 wraps the handler.
+	cfg.HTTP2Cleartext = true
+	srv = &http.Server{Handler: handler}
+	if err := cfg.configure(srv); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := srv.Handler.(http.HandlerFunc); ok {
+		t.Errorf("expected handler to be wrapped for cleartext HTTP/2")
+	}
+
+	// HTTP/2 disabled.
+	cfg = testHTTPConfig()
+	cfg.HTTP2 = false
+	srv = &http.Server{Handler: handler}
+	if err := cfg.configure(srv); err != nil {
+		t.Fatal(err)
+	}
+	if srv.TLSNextProto == nil || len(srv.TLSNextProto) != 0 {
+		t.Errorf("expected HTTP/2 to be disabled, got %v", srv.TLSNextProto)
+	}
+}
+
+func TestServer_ConfigureHTTP(t *testing.T) {
+	t.Parallel()
+
+	srv, err := NewTLS("", &tls.Config{MinVersion: tls.VersionTLS12})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.listener.Close()
+
+	invalid := testHTTPConfig()
+	invalid.HTTP2MaxConcurrentStreams = 0
+	if err := srv.ConfigureHTTP(invalid); err == nil {
+		t.Errorf("expected invalid config to be rejected")
+	}
+
+	cleartext := testHTTPConfig()
+	cleartext.HTTP2Cleartext = true
+	if err := srv.ConfigureHTTP(cleartext); err == nil || !strings.Contains(err.Error(), "cannot be used when TLS is configured") {
+		t.Errorf("expected cleartext HTTP/2 to be rejected with TLS, got: %v", err)
+	}
+
+	if err := srv.ConfigureHTTP(testHTTPConfig()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serveTest serves a handler that responds with the request protocol until the
+// test completes.
+func serveTest(t *testing.T, srv *Server) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(project.TestContext(t))
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ServeHTTPHandler(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto)) //nolint:errcheck
+		}))
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-errCh; err != nil {
+			t.Errorf("failed to serve: %v", err)
+		}
+	})
+}
+
+func TestServeHTTP_HTTP2(t *testing.T) {
+	t.Parallel()
+
+	certFile, keyFile := testCertificate(t)
+	tlsConfig, err := (&TLSConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.2"}).ServerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name  string
+		http2 bool
+		want  string
+	}{
+		{"enabled", true, http2.NextProtoTLS},
+		{"disabled", false, "http/1.1"},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv, err := NewTLS("", tlsConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg := testHTTPConfig()
+			cfg.HTTP2 = tc.http2
+			if err := srv.ConfigureHTTP(cfg); err != nil {
+				t.Fatal(err)
+			}
+			serveTest(t, srv)
+
+			dialer := &net.Dialer{Timeout: 5 * time.Second}
+			conn, err := tls.DialWithDialer(dialer, "tcp", net.JoinHostPort("127.0.0.1", srv.Port()), &tls.Config{
+				// The certificate is self-signed, only the negotiation is under test.
+				InsecureSkipVerify: true, //nolint:gosec
+				NextProtos:         []string{http2.NextProtoTLS, "http/1.1"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			if got := conn.ConnectionState().NegotiatedProtocol; got != tc.want {
+				t.Errorf("expected negotiated protocol %q to be %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_HTTP2Cleartext(t *testing.T) {
+	t.Parallel()
+
+	srv, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := testHTTPConfig()
+	cfg.HTTP2Cleartext = true
+	if err := srv.ConfigureHTTP(cfg); err != nil {
+		t.Fatal(err)
+	}
+	serveTest(t, srv)
+
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+				return net.Dial(network, addr)
+			},
+		},
+	}
+	req, err := http.NewRequestWithContext(project.TestContext(t), http.MethodGet, "http://"+net.JoinHostPort("127.0.0.1", srv.Port()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got, want := resp.ProtoMajor, 2; got != want {
+		t.Errorf("expected HTTP/%d to be HTTP/%d", got, want)
+	}
+}
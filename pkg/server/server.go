@@ -21,6 +21,7 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
@@ -38,9 +39,11 @@ import (
 // Server provides a gracefully-stoppable http server implementation. It is safe
 // for concurrent use in goroutines.
 type Server struct {
-	ip       string
-	port     string
-	listener net.Listener
+	ip         string
+	port       string
+	listener   net.Listener
+	tlsConfig  *tls.Config
+	httpConfig *HTTPConfig
 }
 
 // New creates a new server listening on the provided address that responds to
@@ -62,6 +65,32 @@ func New(port string) (*Server, error) {
 	}, nil
 }
 
+// NewTLS creates a new server like New, but HTTP requests are served over TLS
+// using the provided config. If the config is nil, it is the same as New.
+func NewTLS(port string, tlsConfig *tls.Config) (*Server, error) {
+	s, err := New(port)
+	if err != nil {
+		return nil, err
+	}
+	s.tlsConfig = tlsConfig
+	return s, nil
+}
+
+// ConfigureHTTP sets the connection tuning that ServeHTTP applies to HTTP
+// servers. It returns an error if the config is invalid, or if it enables
+// HTTP/2 cleartext on a server that serves TLS. It must be called before the
+// server is started.
+func (s *Server) ConfigureHTTP(c *HTTPConfig) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if c.HTTP2Cleartext && s.tlsConfig != nil {
+		return fmt.Errorf("env var `HTTP2_CLEARTEXT` cannot be used when TLS is configured")
+	}
+	s.httpConfig = c
+	return nil
+}
+
 // NewFromListener creates a new server on the given listener. This is useful if
 // you want to customize the listener type (e.g. udp or tcp) or bind network
 // more than `New` allows.
@@ -110,7 +139,17 @@ func (s *Server) ServeHTTP(ctx context.Context, srv *http.Server) error {
 	}
 
 	// Run the server. This will block until the provided context is closed.
-	if err := srv.Serve(s.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	serve := func() error { return srv.Serve(s.listener) }
+	if s.tlsConfig != nil {
+		srv.TLSConfig = s.tlsConfig.Clone()
+		serve = func() error { return srv.ServeTLS(s.listener, "", "") }
+	}
+	if s.httpConfig != nil {
+		if err := s.httpConfig.configure(srv); err != nil {
+			return err
+		}
+	}
+	if err := serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		return fmt.Errorf("failed to serve: %w", err)
 	}
 
@@ -146,6 +185,11 @@ func (s *Server) ServeHTTPHandler(ctx context.Context, handler http.Handler) err
 func (s *Server) ServeGRPC(ctx context.Context, srv *grpc.Server) error {
 	logger := logging.FromContext(ctx)
 
+	// gRPC servers configure TLS through their transport credentials.
+	if s.tlsConfig != nil {
+		return fmt.Errorf("TLS must be configured with grpc.Creds for gRPC servers")
+	}
+
 	// Spawn a goroutine that listens for context closure. When the context is
 	// closed, the server is stopped.
 	errCh := make(chan error, 1)
@@ -0,0 +1,168 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// tlsVersions maps the supported TLS_MIN_VERSION values to TLS versions.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSConfig is the configuration for terminating TLS directly in the server.
+// These settings should be left blank on Managed Cloud Run where the TLS
+// termination is handled by the environment.
+type TLSConfig struct {
+	// CertFile and KeyFile are the PEM encoded certificate and private key to
+	// serve. If either is present, both must be present.
+	CertFile string `env:"TLS_CERT_FILE"`
+	KeyFile  string `env:"TLS_KEY_FILE"`
+
+	// MinVersion is the minimum accepted TLS version, one of 1.0, 1.1, 1.2, or
+	// 1.3.
+	MinVersion string `env:"TLS_MIN_VERSION, default=1.2"`
+
+	// CipherSuites is the list of allowed cipher suite names for TLS 1.2 and
+	// below, e.g. TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256. If empty, the Go
+	// defaults are used. Insecure cipher suites are not allowed, and TLS 1.3
+	// cipher suites are not configurable. HTTP/2 requires that the list
+	// includes TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or
+	// TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256.
+	CipherSuites []string `env:"TLS_CIPHER_SUITES"`
+}
+
+// Enabled returns true if a certificate or key is configured.
+func (c *TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// Validate checks that the configured version and cipher suites are valid
+// together. It does not load the certificate.
+func (c *TLSConfig) Validate() error {
+	_, _, err := c.parse()
+	return err
+}
+
+// ServerConfig loads the configured certificate and returns a TLS
+// configuration suitable for serving. If TLS is not enabled, it returns nil
+// and no error.
+func (c *TLSConfig) ServerConfig() (*tls.Config, error) {
+	minVersion, cipherSuites, err := c.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	if !c.Enabled() {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   minVersion,
+		CipherSuites: cipherSuites,
+	}, nil
+}
+
+// parse validates the configuration and returns the minimum version and
+// cipher suite IDs.
+func (c *TLSConfig) parse() (uint16, []uint16, error) {
+	var result *multierror.Error
+
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		result = multierror.Append(result,
+			fmt.Errorf("TLS certificate and key files must both be set or both be empty"))
+	}
+
+	minVersion, ok := tlsVersions[c.MinVersion]
+	if !ok {
+		result = multierror.Append(result,
+			fmt.Errorf("TLS minimum version must be one of 1.0, 1.1, 1.2, or 1.3, got: %q", c.MinVersion))
+	}
+
+	var cipherSuites []uint16
+	if len(c.CipherSuites) > 0 && minVersion == tls.VersionTLS13 {
+		result = multierror.Append(result,
+			fmt.Errorf("TLS cipher suites cannot be set when the minimum version is 1.3, TLS 1.3 cipher suites are not configurable"))
+	} else if ok {
+		for _, name := range c.CipherSuites {
+			id, err := cipherSuiteID(strings.TrimSpace(name), minVersion)
+			if err != nil {
+				result = multierror.Append(result, err)
+				continue
+			}
+			cipherSuites = append(cipherSuites, id)
+		}
+
+		if len(c.CipherSuites) > 0 && !hasHTTP2CipherSuite(cipherSuites) {
+			result = multierror.Append(result,
+				fmt.Errorf("TLS cipher suites must include TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, which are required by HTTP/2"))
+		}
+	}
+
+	if err := result.ErrorOrNil(); err != nil {
+		return 0, nil, err
+	}
+	return minVersion, cipherSuites, nil
+}
+
+// cipherSuiteID returns the ID of the named cipher suite. It returns an error
+// if the cipher suite is unknown, insecure, or cannot be negotiated with any
+// TLS version from minVersion through 1.2.
+func cipherSuiteID(name string, minVersion uint16) (uint16, error) {
+	for _, s := range tls.InsecureCipherSuites() {
+		if s.Name == name {
+			return 0, fmt.Errorf("cipher suite %q is insecure", name)
+		}
+	}
+
+	for _, s := range tls.CipherSuites() {
+		if s.Name != name {
+			continue
+		}
+		for _, v := range s.SupportedVersions {
+			if v >= minVersion && v <= tls.VersionTLS12 {
+				return s.ID, nil
+			}
+		}
+		return 0, fmt.Errorf("cipher suite %q cannot be used with TLS 1.2 or below", name)
+	}
+
+	return 0, fmt.Errorf("unknown cipher suite %q", name)
+}
+
+// hasHTTP2CipherSuite returns true if the list contains a cipher suite that is
+// required by HTTP/2 (RFC 7540, section 9.2.2).
+func hasHTTP2CipherSuite(ids []uint16) bool {
+	for _, id := range ids {
+		if id == tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 || id == tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,292 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/project"
+)
+
+// testCertificate writes a self-signed ECDSA certificate and key for localhost
+// to a temporary directory, returning the paths.
+func testCertificate(tb testing.TB) (string, string) {
+	tb.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	dir := tb.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		tb.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		tb.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestTLSConfig_Validate(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		cfg     *TLSConfig
+		wantErr string
+	}{
+		{
+			name: "defaults",
+			cfg:  &TLSConfig{MinVersion: "1.2"},
+		},
+		{
+			name: "cipher_suites",
+			cfg: &TLSConfig{
+				MinVersion:   "1.2",
+				CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", " TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"},
+			},
+		},
+		{
+			name:    "missing_http2_cipher_suite",
+			cfg:     &TLSConfig{MinVersion: "1.2", CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}},
+			wantErr: "required by HTTP/2",
+		},
+		{
+			name:    "cert_without_key",
+			cfg:     &TLSConfig{CertFile: "cert.pem", MinVersion: "1.2"},
+			wantErr: "must both be set",
+		},
+		{
+			name:    "key_without_cert",
+			cfg:     &TLSConfig{KeyFile: "key.pem", MinVersion: "1.2"},
+			wantErr: "must both be set",
+		},
+		{
+			name:    "unknown_version",
+			cfg:     &TLSConfig{MinVersion: "1.4"},
+			wantErr: "minimum version must be one of",
+		},
+		{
+			name:    "unknown_cipher_suite",
+			cfg:     &TLSConfig{MinVersion: "1.2", CipherSuites: []string{"TLS_NOPE"}},
+			wantErr: `unknown cipher suite "TLS_NOPE"`,
+		},
+		{
+			name:    "insecure_cipher_suite",
+			cfg:     &TLSConfig{MinVersion: "1.2", CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+			wantErr: "is insecure",
+		},
+		{
+			name:    "tls13_cipher_suite",
+			cfg:     &TLSConfig{MinVersion: "1.2", CipherSuites: []string{"TLS_AES_128_GCM_SHA256"}},
+			wantErr: "cannot be used with TLS 1.2 or below",
+		},
+		{
+			name:    "cipher_suites_with_tls13",
+			cfg:     &TLSConfig{MinVersion: "1.3", CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}},
+			wantErr: "cipher suites cannot be set",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tc.cfg.Validate()
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error containing %q, got: %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestTLSConfig_ServerConfig(t *testing.T) {
+	t.Parallel()
+
+	if c, err := (&TLSConfig{MinVersion: "1.2"}).ServerConfig(); err != nil || c != nil {
+		t.Errorf("expected nil config when disabled, got %v, %v", c, err)
+	}
+
+	if _, err := (&TLSConfig{CertFile: "missing.pem", KeyFile: "missing.pem", MinVersion: "1.2"}).ServerConfig(); err == nil {
+		t.Errorf("expected error loading missing certificate")
+	}
+}
+
+func TestNewTLS(t *testing.T) {
+	t.Parallel()
+
+	certFile, keyFile := testCertificate(t)
+
+	cases := []struct {
+		name         string
+		cfg          *TLSConfig
+		clientMin    uint16
+		clientMax    uint16
+		clientSuites []uint16
+		wantErr      bool
+	}{
+		{
+			name:      "tls11_refused",
+			cfg:       &TLSConfig{MinVersion: "1.2"},
+			clientMin: tls.VersionTLS10,
+			clientMax: tls.VersionTLS11,
+			wantErr:   true,
+		},
+		{
+			name:      "tls12",
+			cfg:       &TLSConfig{MinVersion: "1.2"},
+			clientMin: tls.VersionTLS12,
+			clientMax: tls.VersionTLS12,
+		},
+		{
+			name:      "tls13",
+			cfg:       &TLSConfig{MinVersion: "1.2"},
+			clientMin: tls.VersionTLS13,
+			clientMax: tls.VersionTLS13,
+		},
+		{
+			name:      "tls12_refused_by_min_tls13",
+			cfg:       &TLSConfig{MinVersion: "1.3"},
+			clientMin: tls.VersionTLS12,
+			clientMax: tls.VersionTLS12,
+			wantErr:   true,
+		},
+		{
+			name: "allowed_cipher_suite",
+			cfg: &TLSConfig{
+				MinVersion:   "1.2",
+				CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+			},
+			clientMin:    tls.VersionTLS12,
+			clientMax:    tls.VersionTLS12,
+			clientSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		},
+		{
+			name: "disallowed_cipher_suite",
+			cfg: &TLSConfig{
+				MinVersion:   "1.2",
+				CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+			},
+			clientMin:    tls.VersionTLS12,
+			clientMax:    tls.VersionTLS12,
+			clientSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+			wantErr:      true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithCancel(project.TestContext(t))
+			defer cancel()
+
+			tc.cfg.CertFile, tc.cfg.KeyFile = certFile, keyFile
+			tlsConfig, err := tc.cfg.ServerConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			srv, err := NewTLS("", tlsConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			errCh := make(chan error, 1)
+			go func() {
+				errCh <- srv.ServeHTTPHandler(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+				}))
+			}()
+			defer func() {
+				cancel()
+				if err := <-errCh; err != nil {
+					t.Errorf("failed to serve: %v", err)
+				}
+			}()
+
+			dialer := &net.Dialer{Timeout: 5 * time.Second}
+			conn, err := tls.DialWithDialer(dialer, "tcp", net.JoinHostPort("127.0.0.1", srv.Port()), &tls.Config{
+				// The certificate is self-signed, only the handshake is under test.
+				InsecureSkipVerify: true, //nolint:gosec
+				MinVersion:         tc.clientMin,
+				MaxVersion:         tc.clientMax,
+				CipherSuites:       tc.clientSuites,
+			})
+			if tc.wantErr {
+				if err == nil {
+					conn.Close()
+					t.Fatalf("expected handshake to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected handshake to succeed: %v", err)
+			}
+			defer conn.Close()
+
+			if got, want := conn.ConnectionState().Version, tc.clientMax; got != want {
+				t.Errorf("expected TLS version %x to be %x", got, want)
+			}
+		})
+	}
+}
@@ -225,3 +225,30 @@ resource "google_cloud_scheduler_job" "export-create-batches" {
     google_project_service.services["cloudscheduler.googleapis.com"],
   ]
 }
+
+resource "google_cloud_scheduler_job" "export-reconcile-index" {
+  name             = "export-reconcile-index"
+  region           = var.cloudscheduler_location
+  schedule         = var.export_reconcile_index_cron_schedule
+  time_zone        = "America/Los_Angeles"
+  attempt_deadline = "600s"
+
+  retry_config {
+    retry_count = 3
+  }
+
+  http_target {
+    http_method = "GET"
+    uri         = "${google_cloud_run_service.export.status.0.url}/reconcile-index"
+    oidc_token {
+      audience              = google_cloud_run_service.export.status.0.url
+      service_account_email = google_service_account.export-invoker.email
+    }
+  }
+
+  depends_on = [
+    google_app_engine_application.app,
+    google_cloud_run_service_iam_member.export-invoker,
+    google_project_service.services["cloudscheduler.googleapis.com"],
+  ]
+}
@@ -186,6 +186,13 @@ variable "export_create_batches_cron_schedule" {
   description = "Schedule to execute the export create batches service."
 }
 
+variable "export_reconcile_index_cron_schedule" {
+  type    = string
+  default = "0 */6 * * *"
+
+  description = "Schedule to execute the export index reconciliation."
+}
+
 variable "cleanup_exposure_worker_cron_schedule" {
   type    = string
   default = "0 */4 * * *"
@@ -20,6 +20,7 @@ import (
 	"context"
 	"flag"
 	"log"
+	"strings"
 
 	"github.com/google/exposure-notifications-server/internal/federationin"
 	"github.com/google/exposure-notifications-server/internal/federationin/model"
@@ -39,6 +40,8 @@ var (
 	subject  = flag.String("subject", "", "(Required) The OIDC subject (for issuer https://accounts.google.com, this is the obfuscated Gaia ID.)")
 	audience = flag.String("audience", federationin.DefaultAudience, "The OIDC audience; leaving this blank will cause server to not enforce the audience claim.")
 	note     = flag.String("note", "", "An open text note to include on the record.")
+
+	alternateAudiences = flag.String("alternate-audiences", "", "A comma-separated list of additional OIDC audiences to accept, e.g. while the partner moves to a new audience. Ignored if --audience is blank.")
 )
 
 func main() {
@@ -80,12 +83,13 @@ func main() {
 	db := database.New(env.Database())
 
 	auth := &model.FederationOutAuthorization{
-		Issuer:         defaultIssuer, // Authorization interceptor currently only supports defaultIssuer.
-		Subject:        *subject,
-		Audience:       *audience,
-		Note:           *note,
-		IncludeRegions: includeRegions,
-		ExcludeRegions: excludeRegions,
+		Issuer:             defaultIssuer, // Authorization interceptor currently only supports defaultIssuer.
+		Subject:            *subject,
+		Audience:           *audience,
+		AlternateAudiences: splitList(*alternateAudiences),
+		Note:               *note,
+		IncludeRegions:     includeRegions,
+		ExcludeRegions:     excludeRegions,
 	}
 
 	if err := db.AddFederationOutAuthorization(ctx, auth); err != nil {
@@ -94,3 +98,14 @@ func main() {
 
 	log.Printf("Successfully added federation client authorization %#v", auth)
 }
+
+// splitList splits a comma-separated list, dropping empty entries.
+func splitList(s string) []string {
+	var result []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,74 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This package re-derives the days since symptom onset for exposures created
+// in a time range from their stored symptom onset interval. It does not
+// trigger any re-export of previously generated files.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/publish/database"
+	"github.com/google/exposure-notifications-server/internal/setup"
+	coredb "github.com/google/exposure-notifications-server/pkg/database"
+)
+
+var (
+	sinceTimestamp = flag.String("since", "", "The created at timestamp (RFC3339) to start reprocessing from, inclusive.")
+	untilTimestamp = flag.String("until", "", "The created at timestamp (RFC3339) to reprocess until, exclusive.")
+	batchSize      = flag.Int("batch-size", database.DefaultReprocessBatchSize, "The number of exposures to examine per transaction.")
+)
+
+func main() {
+	flag.Parse()
+
+	if *sinceTimestamp == "" {
+		log.Fatal("--since is required.")
+	}
+	if *untilTimestamp == "" {
+		log.Fatal("--until is required.")
+	}
+
+	since, err := time.Parse(time.RFC3339, *sinceTimestamp)
+	if err != nil {
+		log.Fatalf("Failed to parse --since (use RFC3339): %v", err)
+	}
+	until, err := time.Parse(time.RFC3339, *untilTimestamp)
+	if err != nil {
+		log.Fatalf("Failed to parse --until (use RFC3339): %v", err)
+	}
+
+	ctx := context.Background()
+	var config coredb.Config
+	env, err := setup.Setup(ctx, &config)
+	if err != nil {
+		log.Fatalf("failed to setup: %v", err)
+	}
+	defer env.Close(ctx)
+
+	db := database.New(env.Database())
+	resp, err := db.ReprocessDaysSinceSymptomOnset(ctx, &database.ReprocessSymptomOnsetRequest{
+		SinceTimestamp: since,
+		UntilTimestamp: until,
+		BatchSize:      *batchSize,
+	})
+	if err != nil {
+		log.Fatalf("ReprocessDaysSinceSymptomOnset: %v", err)
+	}
+	log.Printf("Examined %d exposures, updated %d, skipped %d without a stored symptom onset.", resp.Examined, resp.Updated, resp.Skipped)
+}